@@ -0,0 +1,423 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package alg implements framework for application level gateways.
+// Application level gateway (ALG) is a helper which understands
+// protocol that carries addresses or ports inside its payload, like
+// FTP or SIP. Helper rewrites these addresses according to
+// translation made by network function and requests pinholes for
+// connections which will be opened by protocol later.
+//
+// Helpers are registered in Manager. Network function attaches
+// session to every tracked connection which has matching helper and
+// passes all payload of this connection to session. Session tracks
+// changes of payload length and adjusts TCP sequence numbers.
+// Network function checks every new connection for being expected
+// by some helper. Package doesn't depend on particular translation,
+// network function should implement Translator interface.
+package alg
+
+import (
+	"sync"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// DefaultExpectTimeout is a time during which expected connection
+// should be started.
+const DefaultExpectTimeout = 60 * time.Second
+
+// Helper is an ALG module for one application protocol.
+type Helper interface {
+	// Name returns unique name of helper.
+	Name() string
+	// Match returns true if connection with given original tuple
+	// should be handled by helper.
+	Match(t conntrack.Tuple) bool
+	// Process inspects payload of one packet and returns payload
+	// which should be sent instead of it. If nothing should be
+	// changed, it returns payload as is. Given payload can be
+	// modified in place only if its length is not changed.
+	Process(ctx *Context, payload []byte) ([]byte, error)
+}
+
+// Closer can be implemented by helper which keeps state outside of
+// session, for example tables shared between connections.
+type Closer interface {
+	// Close is called when session is detached from connection.
+	Close(s *Session)
+}
+
+// Translator is implemented by network function which changes
+// addresses of packets, for example NAT.
+type Translator interface {
+	// Translate returns address and port which should be written to
+	// payload instead of addr and port sent inside packet going in
+	// direction dir. Packets of connection which is opened later to
+	// returned address and port should be delivered to addr and
+	// port, so translator can allocate new mapping here.
+	Translate(dir conntrack.Direction, proto uint8, addr types.IPv4Address, port uint16) (types.IPv4Address, uint16, error)
+}
+
+// Expectation describes connection which is going to be opened
+// because of signalling in other connection.
+type Expectation struct {
+	// Tuple of first packet of expected connection as it is seen by
+	// network function. Zero SrcAddr or SrcPort match any value.
+	Tuple conntrack.Tuple
+	// Address and port which expected connection should be delivered
+	// to. They differ from destination of Tuple if payload was
+	// translated.
+	Addr types.IPv4Address
+	Port uint16
+	// Helper for expected connection, nil if it doesn't need one
+	Helper Helper
+	// Connection which created expectation
+	Master  *conntrack.Entry
+	expires time.Time
+}
+
+func (e *Expectation) match(t conntrack.Tuple) bool {
+	return (e.Tuple.SrcAddr == 0 || e.Tuple.SrcAddr == t.SrcAddr) &&
+		(e.Tuple.SrcPort == 0 || e.Tuple.SrcPort == t.SrcPort)
+}
+
+type expectKey struct {
+	addr  types.IPv4Address
+	port  uint16
+	proto uint8
+}
+
+func keyOf(t conntrack.Tuple) expectKey {
+	return expectKey{t.DstAddr, t.DstPort, t.Proto}
+}
+
+// Manager contains registered helpers, sessions of connections which
+// are handled by helpers and expected connections. All its methods
+// can be called from different flow function clones simultaneously.
+type Manager struct {
+	// Time during which expected connection should be started
+	ExpectTimeout time.Duration
+
+	helpers      []Helper
+	sessions     map[*conntrack.Entry]*Session
+	expectations map[expectKey][]*Expectation
+	helpersLock  sync.RWMutex
+	lock         sync.Mutex
+}
+
+// NewManager creates manager without registered helpers.
+func NewManager() *Manager {
+	return &Manager{
+		ExpectTimeout: DefaultExpectTimeout,
+		sessions:      make(map[*conntrack.Entry]*Session),
+		expectations:  make(map[expectKey][]*Expectation),
+	}
+}
+
+// Register adds helper to manager. Helpers are matched in order of
+// registration. Returns error if helper with the same name is
+// already registered.
+func (m *Manager) Register(h Helper) error {
+	m.helpersLock.Lock()
+	defer m.helpersLock.Unlock()
+	for _, r := range m.helpers {
+		if r.Name() == h.Name() {
+			return common.WrapWithNFError(nil, "ALG helper "+h.Name()+" is already registered", common.BadArgument)
+		}
+	}
+	m.helpers = append(m.helpers, h)
+	return nil
+}
+
+// Unregister removes helper with given name from manager. Already
+// attached sessions continue to use it.
+func (m *Manager) Unregister(name string) {
+	m.helpersLock.Lock()
+	defer m.helpersLock.Unlock()
+	for i, r := range m.helpers {
+		if r.Name() == name {
+			m.helpers = append(m.helpers[:i], m.helpers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Helper returns first registered helper which matches tuple.
+func (m *Manager) Helper(t conntrack.Tuple) Helper {
+	m.helpersLock.RLock()
+	defer m.helpersLock.RUnlock()
+	for _, h := range m.helpers {
+		if h.Match(t) {
+			return h
+		}
+	}
+	return nil
+}
+
+// Attach creates session for new connection if it is matched by one
+// of registered helpers. Returns nil otherwise. Translator can be nil
+// if network function doesn't change addresses.
+func (m *Manager) Attach(conn *conntrack.Entry, tr Translator) *Session {
+	h := m.Helper(conn.Original)
+	if h == nil {
+		return nil
+	}
+	return m.AttachHelper(conn, tr, h)
+}
+
+// AttachHelper creates session with given helper for connection. It
+// should be used for expected connections with helper.
+func (m *Manager) AttachHelper(conn *conntrack.Entry, tr Translator, h Helper) *Session {
+	s := &Session{
+		Helper:     h,
+		Conn:       conn,
+		Translator: tr,
+		manager:    m,
+	}
+	m.lock.Lock()
+	m.sessions[conn] = s
+	m.lock.Unlock()
+	return s
+}
+
+// Session returns session attached to connection or nil.
+func (m *Manager) Session(conn *conntrack.Entry) *Session {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.sessions[conn]
+}
+
+// Detach removes session of connection and all expectations created
+// by it. It should be called when connection is removed from
+// connection tracking table.
+func (m *Manager) Detach(conn *conntrack.Entry) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if s, ok := m.sessions[conn]; ok {
+		if c, ok := s.Helper.(Closer); ok {
+			c.Close(s)
+		}
+		delete(m.sessions, conn)
+	}
+	for key, list := range m.expectations {
+		m.expectations[key] = filterExpectations(list, func(e *Expectation) bool {
+			return e.Master != conn
+		})
+		if len(m.expectations[key]) == 0 {
+			delete(m.expectations, key)
+		}
+	}
+}
+
+// Expect adds expected connection. It is usually called by helpers
+// via Context.Expect.
+func (m *Manager) Expect(e *Expectation, now time.Time) {
+	e.expires = now.Add(m.ExpectTimeout)
+	key := keyOf(e.Tuple)
+	m.lock.Lock()
+	m.expectations[key] = append(m.expectations[key], e)
+	m.lock.Unlock()
+}
+
+// LookupExpectation finds expectation which matches first packet of
+// new connection and removes it because every expectation can be
+// used only once.
+func (m *Manager) LookupExpectation(t conntrack.Tuple, now time.Time) (*Expectation, bool) {
+	key := keyOf(t)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	list := m.expectations[key]
+	for i, e := range list {
+		if e.match(t) && now.Before(e.expires) {
+			list = append(list[:i], list[i+1:]...)
+			if len(list) == 0 {
+				delete(m.expectations, key)
+			} else {
+				m.expectations[key] = list
+			}
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// ExpireExpectations removes expectations which were not used during
+// ExpectTimeout. Returns number of removed expectations.
+func (m *Manager) ExpireExpectations(now time.Time) int {
+	removed := 0
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key, list := range m.expectations {
+		left := filterExpectations(list, func(e *Expectation) bool {
+			return now.Before(e.expires)
+		})
+		removed += len(list) - len(left)
+		if len(left) == 0 {
+			delete(m.expectations, key)
+		} else {
+			m.expectations[key] = left
+		}
+	}
+	return removed
+}
+
+func filterExpectations(list []*Expectation, keep func(*Expectation) bool) []*Expectation {
+	left := list[:0]
+	for _, e := range list {
+		if keep(e) {
+			left = append(left, e)
+		}
+	}
+	return left
+}
+
+// seqAdjust keeps TCP sequence number offset of one direction of
+// connection. Offset changes at position of last modified packet, so
+// retransmissions of earlier packets are adjusted correctly.
+type seqAdjust struct {
+	pos    uint32
+	before int32
+	after  int32
+}
+
+// seqAfter returns true if sequence number a is after b.
+func seqAfter(a, b uint32) bool {
+	return int32(b-a) < 0
+}
+
+func (a *seqAdjust) offset(seq uint32) int32 {
+	if seqAfter(seq, a.pos) {
+		return a.after
+	}
+	return a.before
+}
+
+func (a *seqAdjust) update(seq uint32, delta int32) {
+	if a.before == a.after || seqAfter(seq, a.pos) {
+		a.pos = seq
+		a.before = a.after
+		a.after += delta
+	}
+}
+
+// Session is a state of helper for one connection.
+type Session struct {
+	Helper     Helper
+	Conn       *conntrack.Entry
+	Translator Translator
+	// Any data which helper wants to keep between packets
+	Data interface{}
+
+	manager *Manager
+	adjust  [2]seqAdjust
+	lock    sync.Mutex
+}
+
+// Process passes payload of packet going in direction dir to helper.
+// seq is TCP sequence number of packet in host byte order before
+// adjustment, it is ignored for other protocols. Returns payload
+// which should replace packet payload.
+func (s *Session) Process(dir conntrack.Direction, t conntrack.Tuple, seq uint32, payload []byte, now time.Time) ([]byte, error) {
+	if len(payload) == 0 {
+		return payload, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ctx := &Context{
+		Session: s,
+		Dir:     dir,
+		Tuple:   t,
+		Now:     now,
+	}
+	out, err := s.Helper.Process(ctx, payload)
+	if err != nil {
+		return payload, err
+	}
+	if delta := len(out) - len(payload); delta != 0 && t.Proto == types.TCPNumber {
+		s.adjust[dir].update(seq, int32(delta))
+	}
+	return out, nil
+}
+
+// AdjustSeq returns sequence number which should be written to TCP
+// header of packet going in direction dir. Numbers are in host byte
+// order.
+func (s *Session) AdjustSeq(dir conntrack.Direction, seq uint32) uint32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return seq + uint32(s.adjust[dir].offset(seq))
+}
+
+// AdjustAck returns acknowledgement number which should be written
+// to TCP header of packet going in direction dir. Numbers are in
+// host byte order.
+func (s *Session) AdjustAck(dir conntrack.Direction, ack uint32) uint32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	other := &s.adjust[1-dir]
+	return ack - uint32(other.offset(ack-uint32(other.before)))
+}
+
+// Context is passed to helper for every processed packet.
+type Context struct {
+	*Session
+	// Direction of packet in connection
+	Dir conntrack.Direction
+	// Tuple of packet
+	Tuple conntrack.Tuple
+	// Time of packet processing
+	Now time.Time
+}
+
+// Translate returns address and port which should be written to
+// payload instead of given ones. If session has no translator they
+// are returned unchanged.
+func (ctx *Context) Translate(proto uint8, addr types.IPv4Address, port uint16) (types.IPv4Address, uint16, error) {
+	if ctx.Translator == nil {
+		return addr, port, nil
+	}
+	return ctx.Translator.Translate(ctx.Dir, proto, addr, port)
+}
+
+// Expect registers connection which receiver of current packet is
+// going to open to address and port from payload. taddr and tport
+// are values written to payload, addr and port are values which
+// were there before translation. Zero srcPort matches any port.
+func (ctx *Context) Expect(proto uint8, srcPort uint16, taddr types.IPv4Address, tport uint16,
+	addr types.IPv4Address, port uint16, h Helper) {
+	ctx.manager.Expect(&Expectation{
+		Tuple: conntrack.Tuple{
+			SrcAddr: ctx.Tuple.DstAddr,
+			DstAddr: taddr,
+			SrcPort: srcPort,
+			DstPort: tport,
+			Proto:   proto,
+		},
+		Addr:   addr,
+		Port:   port,
+		Helper: h,
+		Master: ctx.Conn,
+	}, ctx.Now)
+}
+
+// replace returns payload where bytes from start to end are replaced
+// by repl.
+func replace(payload []byte, start, end int, repl []byte) []byte {
+	if end-start == len(repl) {
+		copy(payload[start:], repl)
+		return payload
+	}
+	out := make([]byte, 0, len(payload)-(end-start)+len(repl))
+	out = append(out, payload[:start]...)
+	out = append(out, repl...)
+	return append(out, payload[end:]...)
+}
+
+func swapBytesUint16(x uint16) uint16 {
+	return x<<8 | x>>8
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+var (
+	client    = types.BytesToIPv4(10, 0, 0, 1)
+	server    = types.BytesToIPv4(192, 168, 1, 1)
+	publicIP  = types.BytesToIPv4(1, 2, 3, 4)
+	startTime = time.Unix(1000, 0)
+)
+
+// testTranslator maps every address to publicIP and port to port+1000
+// in Original direction and doesn't change anything in Reply direction.
+type testTranslator struct{}
+
+func (testTranslator) Translate(dir conntrack.Direction, proto uint8, addr types.IPv4Address, port uint16) (types.IPv4Address, uint16, error) {
+	if dir == conntrack.Reply {
+		return addr, port, nil
+	}
+	return publicIP, swapBytesUint16(swapBytesUint16(port) + 1000), nil
+}
+
+func newTestSession(t *testing.T, h Helper, tuple conntrack.Tuple) (*Manager, *Session) {
+	m := NewManager()
+	if err := m.Register(h); err != nil {
+		t.Fatal(err)
+	}
+	table := conntrack.NewTable(0, conntrack.DefaultTimeouts())
+	conn, _, err := table.Track(tuple, types.TCPFlagSyn, startTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := m.Attach(conn, testTranslator{})
+	if s == nil {
+		t.Fatalf("helper %s doesn't match %v", h.Name(), tuple)
+	}
+	return m, s
+}
+
+func TestRegister(t *testing.T) {
+	m := NewManager()
+	if err := m.Register(NewFTP()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Register(NewFTP(2121)); err == nil {
+		t.Error("second helper with the same name is registered")
+	}
+	ftp := conntrack.Tuple{SrcAddr: client, DstAddr: server, SrcPort: 1, DstPort: swapBytesUint16(FTPPort), Proto: types.TCPNumber}
+	if h := m.Helper(ftp); h == nil || h.Name() != "ftp" {
+		t.Errorf("got helper %v for FTP connection", h)
+	}
+	ftp.Proto = types.UDPNumber
+	if h := m.Helper(ftp); h != nil {
+		t.Errorf("got helper %v for UDP connection", h.Name())
+	}
+	m.Unregister("ftp")
+	ftp.Proto = types.TCPNumber
+	if h := m.Helper(ftp); h != nil {
+		t.Errorf("got helper %v after unregister", h.Name())
+	}
+}
+
+func TestExpectations(t *testing.T) {
+	m := NewManager()
+	master := &conntrack.Entry{}
+	e := &Expectation{
+		Tuple:  conntrack.Tuple{SrcAddr: server, DstAddr: publicIP, DstPort: 1000, Proto: types.TCPNumber},
+		Master: master,
+	}
+	m.Expect(e, startTime)
+	tuple := conntrack.Tuple{SrcAddr: server, DstAddr: publicIP, SrcPort: 20, DstPort: 1000, Proto: types.TCPNumber}
+	other := tuple
+	other.SrcAddr = client
+	if _, ok := m.LookupExpectation(other, startTime); ok {
+		t.Error("expectation matched other source address")
+	}
+	if found, ok := m.LookupExpectation(tuple, startTime); !ok || found != e {
+		t.Errorf("got %v %v, want expectation", found, ok)
+	}
+	if _, ok := m.LookupExpectation(tuple, startTime); ok {
+		t.Error("expectation is used twice")
+	}
+
+	m.Expect(e, startTime)
+	if removed := m.ExpireExpectations(startTime.Add(DefaultExpectTimeout)); removed != 1 {
+		t.Errorf("got %d expired, want 1", removed)
+	}
+	m.Expect(e, startTime)
+	m.Detach(master)
+	if _, ok := m.LookupExpectation(tuple, startTime); ok {
+		t.Error("expectation is found after master is detached")
+	}
+}
+
+var seqAdjustTests = []struct {
+	seq, delta       int32
+	nextSeq, ackBack uint32
+}{
+	// Packet with seq 100 and length 10 grows by 5 bytes
+	{100, 5, 115, 115},
+	// Retransmission of the same packet keeps offset
+	{100, 5, 115, 115},
+	// Next packet shrinks by 2 bytes
+	{115, -2, 128, 128},
+}
+
+func TestSeqAdjust(t *testing.T) {
+	var s Session
+	for i, tt := range seqAdjustTests {
+		s.adjust[conntrack.Original].update(uint32(tt.seq), tt.delta)
+		if got := s.AdjustSeq(conntrack.Original, uint32(tt.seq)); i == 0 && got != uint32(tt.seq) {
+			t.Errorf("%d: modified packet seq got %d, want %d", i, got, tt.seq)
+		}
+		// Packet right after modified one
+		next := uint32(tt.seq) + 10
+		if got := s.AdjustSeq(conntrack.Original, next); got != tt.nextSeq {
+			t.Errorf("%d: next seq got %d, want %d", i, got, tt.nextSeq)
+		}
+		// Peer acknowledges adjusted number and should get original one
+		if got := s.AdjustAck(conntrack.Reply, tt.ackBack); got != next {
+			t.Errorf("%d: ack got %d, want %d", i, got, next)
+		}
+	}
+}
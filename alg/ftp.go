@@ -0,0 +1,215 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// FTPPort is a default port of FTP control connection.
+const FTPPort = 21
+
+// FTP is a helper for FTP control connections. It handles PORT and
+// EPRT commands of client and 227 and 229 replies of server.
+type FTP struct {
+	ports []uint16
+}
+
+// NewFTP creates FTP helper for control connections to given ports.
+// If no ports are given FTPPort is used.
+func NewFTP(ports ...uint16) *FTP {
+	return &FTP{ports: networkPorts(ports, FTPPort)}
+}
+
+// Name returns "ftp".
+func (f *FTP) Name() string {
+	return "ftp"
+}
+
+// Match returns true for TCP connections to FTP ports.
+func (f *FTP) Match(t conntrack.Tuple) bool {
+	return t.Proto == types.TCPNumber && hasPort(f.ports, t.DstPort)
+}
+
+// Process rewrites addresses of data connections in commands and
+// replies and expects these connections. Every command should be
+// contained in one packet.
+func (f *FTP) Process(ctx *Context, payload []byte) ([]byte, error) {
+	var err error
+	for start := 0; start < len(payload) && err == nil; {
+		end := bytes.IndexByte(payload[start:], '\n')
+		if end == -1 {
+			break
+		}
+		end += start + 1
+		var line []byte
+		if ctx.Dir == conntrack.Original {
+			line, err = f.command(ctx, payload[start:end])
+		} else {
+			line, err = f.reply(ctx, payload[start:end])
+		}
+		if line != nil {
+			payload = replace(payload, start, end, line)
+			end = start + len(line)
+		}
+		start = end
+	}
+	return payload, err
+}
+
+func (f *FTP) command(ctx *Context, line []byte) ([]byte, error) {
+	switch {
+	case hasPrefixFold(line, "PORT "):
+		addr, port, ok := parseCommaAddr(line[5:])
+		if !ok {
+			return nil, nil
+		}
+		taddr, tport, err := f.expect(ctx, addr, port)
+		if err != nil {
+			return nil, err
+		}
+		return []byte("PORT " + formatCommaAddr(taddr, tport) + "\r\n"), nil
+	case hasPrefixFold(line, "EPRT "):
+		// EPRT |1|a.b.c.d|port|
+		fields := bytes.Split(bytes.TrimRight(line[5:], "\r\n"), line[5:6])
+		if len(fields) != 5 || string(fields[1]) != "1" {
+			return nil, nil
+		}
+		addr, ok := parseIPv4(fields[2])
+		port, err := strconv.ParseUint(string(fields[3]), 10, 16)
+		if !ok || err != nil {
+			return nil, nil
+		}
+		taddr, tport, err := f.expect(ctx, addr, swapBytesUint16(uint16(port)))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(fmt.Sprintf("EPRT |1|%s|%d|\r\n", taddr.String(), swapBytesUint16(tport))), nil
+	}
+	return nil, nil
+}
+
+func (f *FTP) reply(ctx *Context, line []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(line, []byte("227 ")):
+		// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+		open := bytes.IndexByte(line, '(')
+		close := bytes.IndexByte(line, ')')
+		if open == -1 || close < open {
+			return nil, nil
+		}
+		addr, port, ok := parseCommaAddr(line[open+1 : close])
+		if !ok {
+			return nil, nil
+		}
+		taddr, tport, err := f.expect(ctx, addr, port)
+		if err != nil {
+			return nil, err
+		}
+		return replace(append([]byte(nil), line...), open+1, close, []byte(formatCommaAddr(taddr, tport))), nil
+	case bytes.HasPrefix(line, []byte("229 ")):
+		// 229 Entering Extended Passive Mode (|||port|)
+		open := bytes.Index(line, []byte("(|||"))
+		if open == -1 {
+			return nil, nil
+		}
+		open += 4
+		close := bytes.IndexByte(line[open:], '|')
+		if close == -1 {
+			return nil, nil
+		}
+		close += open
+		port, err := strconv.ParseUint(string(line[open:close]), 10, 16)
+		if err != nil {
+			return nil, nil
+		}
+		// Address of data connection is the address of server
+		_, tport, err := f.expect(ctx, ctx.Tuple.SrcAddr, swapBytesUint16(uint16(port)))
+		if err != nil {
+			return nil, err
+		}
+		return replace(append([]byte(nil), line...), open, close, []byte(strconv.Itoa(int(swapBytesUint16(tport))))), nil
+	}
+	return nil, nil
+}
+
+func (f *FTP) expect(ctx *Context, addr types.IPv4Address, port uint16) (types.IPv4Address, uint16, error) {
+	taddr, tport, err := ctx.Translate(types.TCPNumber, addr, port)
+	if err != nil {
+		return 0, 0, err
+	}
+	ctx.Expect(types.TCPNumber, 0, taddr, tport, addr, port, nil)
+	return taddr, tport, nil
+}
+
+// parseCommaAddr parses "h1,h2,h3,h4,p1,p2" address of FTP. Returned
+// port is in network byte order.
+func parseCommaAddr(s []byte) (types.IPv4Address, uint16, bool) {
+	fields := bytes.Split(bytes.TrimSpace(s), []byte(","))
+	if len(fields) != 6 {
+		return 0, 0, false
+	}
+	var b [6]byte
+	for i := range fields {
+		v, err := strconv.ParseUint(string(fields[i]), 10, 8)
+		if err != nil {
+			return 0, 0, false
+		}
+		b[i] = byte(v)
+	}
+	return types.BytesToIPv4(b[0], b[1], b[2], b[3]), uint16(b[5])<<8 | uint16(b[4]), true
+}
+
+func formatCommaAddr(addr types.IPv4Address, port uint16) string {
+	return fmt.Sprintf("%d,%d,%d,%d,%d,%d", byte(addr), byte(addr>>8), byte(addr>>16), byte(addr>>24),
+		byte(port), byte(port>>8))
+}
+
+func parseIPv4(s []byte) (types.IPv4Address, bool) {
+	fields := bytes.Split(s, []byte("."))
+	if len(fields) != 4 {
+		return 0, false
+	}
+	var b [4]byte
+	for i := range fields {
+		v, err := strconv.ParseUint(string(fields[i]), 10, 8)
+		if err != nil {
+			return 0, false
+		}
+		b[i] = byte(v)
+	}
+	return types.BytesToIPv4(b[0], b[1], b[2], b[3]), true
+}
+
+func hasPrefixFold(s []byte, prefix string) bool {
+	return len(s) >= len(prefix) && bytes.EqualFold(s[:len(prefix)], []byte(prefix))
+}
+
+// networkPorts converts ports to network byte order. If ports are
+// empty def is used.
+func networkPorts(ports []uint16, def uint16) []uint16 {
+	if len(ports) == 0 {
+		ports = []uint16{def}
+	}
+	ret := make([]uint16, len(ports))
+	for i := range ports {
+		ret[i] = swapBytesUint16(ports[i])
+	}
+	return ret
+}
+
+func hasPort(ports []uint16, port uint16) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
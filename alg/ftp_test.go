@@ -0,0 +1,60 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+var ftpTests = []struct {
+	dir      conntrack.Direction
+	payload  string
+	expected string
+	// Expected connection destination and port in host byte order
+	expectAddr types.IPv4Address
+	expectPort uint16
+}{
+	{conntrack.Original, "PORT 10,0,0,1,4,1\r\n", "PORT 1,2,3,4,7,233\r\n", publicIP, 2025},
+	{conntrack.Original, "port 10,0,0,1,4,1\r\n", "PORT 1,2,3,4,7,233\r\n", publicIP, 2025},
+	{conntrack.Original, "EPRT |1|10.0.0.1|1025|\r\n", "EPRT |1|1.2.3.4|2025|\r\n", publicIP, 2025},
+	{conntrack.Original, "USER anonymous\r\nPORT 10,0,0,1,4,1\r\n", "USER anonymous\r\nPORT 1,2,3,4,7,233\r\n", publicIP, 2025},
+	{conntrack.Reply, "227 Entering Passive Mode (192,168,1,1,19,137).\r\n", "227 Entering Passive Mode (192,168,1,1,19,137).\r\n", server, 5001},
+	{conntrack.Reply, "229 Entering Extended Passive Mode (|||5001|)\r\n", "229 Entering Extended Passive Mode (|||5001|)\r\n", server, 5001},
+	{conntrack.Original, "PORT 10,0,0,1,4\r\n", "PORT 10,0,0,1,4\r\n", 0, 0},
+	{conntrack.Original, "LIST\r\n", "LIST\r\n", 0, 0},
+}
+
+func TestFTP(t *testing.T) {
+	tuple := conntrack.Tuple{SrcAddr: client, DstAddr: server, SrcPort: 1, DstPort: swapBytesUint16(FTPPort), Proto: types.TCPNumber}
+	for _, tt := range ftpTests {
+		m, s := newTestSession(t, NewFTP(), tuple)
+		packetTuple := tuple
+		if tt.dir == conntrack.Reply {
+			packetTuple = tuple.Reverse()
+		}
+		out, err := s.Process(tt.dir, packetTuple, 1, []byte(tt.payload), startTime)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.payload, err)
+			continue
+		}
+		if string(out) != tt.expected {
+			t.Errorf("%q: got %q, want %q", tt.payload, out, tt.expected)
+		}
+		expected := packetTuple.Reverse()
+		expected.DstAddr = tt.expectAddr
+		expected.DstPort = swapBytesUint16(tt.expectPort)
+		expected.SrcPort = swapBytesUint16(20)
+		e, ok := m.LookupExpectation(expected, startTime)
+		if ok != (tt.expectAddr != 0) {
+			t.Errorf("%q: expectation found %v, want %v", tt.payload, ok, tt.expectAddr != 0)
+		}
+		if ok && e.Master != s.Conn {
+			t.Errorf("%q: wrong master of expectation", tt.payload)
+		}
+	}
+}
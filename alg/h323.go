@@ -0,0 +1,120 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"encoding/binary"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// H323Port is a default port of H.225 call signalling.
+const H323Port = 1720
+
+const (
+	tpktVersion = 3
+	tpktHeader  = 4
+	// Size of IPv4 TransportAddress: 4 bytes of address and 2 bytes
+	// of port
+	transportAddressLen = 6
+)
+
+// H323 is a basic helper for H.323 calls. H.225 call signalling and
+// H.245 control messages are encoded with ASN.1 PER, helper doesn't
+// decode them but searches for IPv4 transport addresses of sender,
+// which are encoded as 4 bytes of address followed by 2 bytes of
+// port. Every found address is translated in place, so length of
+// messages is never changed. Addresses in H.225 messages are
+// considered to be H.245 channels and expected as TCP connections
+// which are handled by H.245 part of helper. Addresses in H.245
+// messages are considered to be RTP and RTCP channels and expected
+// as UDP streams. Gatekeeper RAS messages, tunneled H.245 and fast
+// start are not supported.
+type H323 struct {
+	ports []uint16
+	h245  h245
+}
+
+// NewH323 creates H.323 helper for call signalling to given ports. If
+// no ports are given H323Port is used.
+func NewH323(ports ...uint16) *H323 {
+	return &H323{ports: networkPorts(ports, H323Port)}
+}
+
+// Name returns "h323".
+func (h *H323) Name() string {
+	return "h323"
+}
+
+// Match returns true for TCP connections to H.225 call signalling
+// ports.
+func (h *H323) Match(t conntrack.Tuple) bool {
+	return t.Proto == types.TCPNumber && hasPort(h.ports, t.DstPort)
+}
+
+// Process rewrites H.245 addresses in H.225 messages.
+func (h *H323) Process(ctx *Context, payload []byte) ([]byte, error) {
+	return payload, translateTPKT(ctx, payload, types.TCPNumber, &h.h245)
+}
+
+// h245 is a part of H.323 helper which handles H.245 control
+// connections. It is never matched by tuple, sessions are created
+// for expected connections only.
+type h245 struct{}
+
+func (h *h245) Name() string {
+	return "h245"
+}
+
+func (h *h245) Match(t conntrack.Tuple) bool {
+	return false
+}
+
+func (h *h245) Process(ctx *Context, payload []byte) ([]byte, error) {
+	return payload, translateTPKT(ctx, payload, types.UDPNumber, nil)
+}
+
+// translateTPKT translates transport addresses of sender in every
+// TPKT packet of payload. Connections to them are expected with
+// given protocol and helper.
+func translateTPKT(ctx *Context, payload []byte, proto uint8, helper Helper) error {
+	for msg := payload; len(msg) >= tpktHeader && msg[0] == tpktVersion; {
+		length := int(binary.BigEndian.Uint16(msg[2:]))
+		if length < tpktHeader || length > len(msg) {
+			return nil
+		}
+		if err := translateAddresses(ctx, msg[tpktHeader:length], proto, helper); err != nil {
+			return err
+		}
+		msg = msg[length:]
+	}
+	return nil
+}
+
+func translateAddresses(ctx *Context, msg []byte, proto uint8, helper Helper) error {
+	addr := types.IPv4ToBytes(ctx.Tuple.SrcAddr)
+	for i := 0; i+transportAddressLen <= len(msg); i++ {
+		if msg[i] != addr[0] || msg[i+1] != addr[1] || msg[i+2] != addr[2] || msg[i+3] != addr[3] {
+			continue
+		}
+		// Port is in network byte order as in packet headers
+		port := uint16(msg[i+4]) | uint16(msg[i+5])<<8
+		if port == 0 {
+			continue
+		}
+		taddr, tport, err := ctx.Translate(proto, ctx.Tuple.SrcAddr, port)
+		if err != nil {
+			return err
+		}
+		ctx.Expect(proto, 0, taddr, tport, ctx.Tuple.SrcAddr, port, helper)
+		b := types.IPv4ToBytes(taddr)
+		copy(msg[i:], b[:])
+		msg[i+4] = byte(tport)
+		msg[i+5] = byte(tport >> 8)
+		i += transportAddressLen - 1
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// tpkt returns TPKT packet with given body which contains IPv4
+// transport address at offset 3.
+func tpkt(addr types.IPv4Address, port uint16) []byte {
+	a := types.IPv4ToBytes(addr)
+	return []byte{tpktVersion, 0, 0, 14, 0x08, 0x02, 0x00, a[0], a[1], a[2], a[3], byte(port >> 8), byte(port), 0x7e}
+}
+
+func TestH323(t *testing.T) {
+	tuple := conntrack.Tuple{SrcAddr: client, DstAddr: server, SrcPort: swapBytesUint16(3000),
+		DstPort: swapBytesUint16(H323Port), Proto: types.TCPNumber}
+	m, s := newTestSession(t, NewH323(), tuple)
+	payload := append(tpkt(client, 4000), tpkt(server, 4001)...)
+	out, err := s.Process(conntrack.Original, tuple, 100, payload, startTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(tpkt(publicIP, 5000), tpkt(server, 4001)...)
+	if !bytes.Equal(out, expected) {
+		t.Errorf("got %x, expected %x", out, expected)
+	}
+
+	// H.245 control connection
+	control := conntrack.Tuple{SrcAddr: server, DstAddr: publicIP, SrcPort: swapBytesUint16(40000),
+		DstPort: swapBytesUint16(5000), Proto: types.TCPNumber}
+	e, ok := m.LookupExpectation(control, startTime)
+	if !ok {
+		t.Fatal("no expectation for H.245 connection")
+	}
+	if e.Addr != client || e.Port != swapBytesUint16(4000) || e.Helper == nil {
+		t.Fatalf("H.245 connection goes to %s:%d with helper %v", e.Addr.String(), swapBytesUint16(e.Port), e.Helper)
+	}
+	table := conntrack.NewTable(0, conntrack.DefaultTimeouts())
+	conn, _, err := table.Track(control, types.TCPFlagSyn, startTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s245 := m.AttachHelper(conn, testTranslator{}, e.Helper)
+	// Client opens logical channel
+	h245 := conntrack.Tuple{SrcAddr: client, DstAddr: server, SrcPort: swapBytesUint16(4000),
+		DstPort: swapBytesUint16(40000), Proto: types.TCPNumber}
+	out, err = s245.Process(conntrack.Original, h245, 100, tpkt(client, 6000), startTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, tpkt(publicIP, 7000)) {
+		t.Errorf("got %x in H.245 message", out)
+	}
+	media := conntrack.Tuple{SrcAddr: server, DstAddr: publicIP, SrcPort: swapBytesUint16(6002),
+		DstPort: swapBytesUint16(7000), Proto: types.UDPNumber}
+	if _, ok := m.LookupExpectation(media, startTime); !ok {
+		t.Error("no expectation for media channel")
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// IRCPort is a default port of IRC server.
+const IRCPort = 6667
+
+// IRC is a helper for DCC CHAT and DCC SEND requests sent by client
+// to IRC server. Address and port of client are written in request
+// and other user connects to them directly, so helper expects
+// connection from any address.
+type IRC struct {
+	ports []uint16
+}
+
+// NewIRC creates IRC helper for connections to given ports. If no
+// ports are given IRCPort is used.
+func NewIRC(ports ...uint16) *IRC {
+	return &IRC{ports: networkPorts(ports, IRCPort)}
+}
+
+// Name returns "irc".
+func (h *IRC) Name() string {
+	return "irc"
+}
+
+// Match returns true for TCP connections to IRC ports.
+func (h *IRC) Match(t conntrack.Tuple) bool {
+	return t.Proto == types.TCPNumber && hasPort(h.ports, t.DstPort)
+}
+
+// Process rewrites address and port in DCC requests of client.
+// Every request should be contained in one packet.
+func (h *IRC) Process(ctx *Context, payload []byte) ([]byte, error) {
+	if ctx.Dir != conntrack.Original {
+		return payload, nil
+	}
+	for start := 0; ; {
+		i := bytes.Index(payload[start:], []byte("\x01DCC "))
+		if i == -1 {
+			return payload, nil
+		}
+		i += start + 1
+		end := bytes.IndexByte(payload[i:], '\x01')
+		if end == -1 {
+			return payload, nil
+		}
+		end += i
+		request, err := h.request(ctx, payload[i:end])
+		if err != nil {
+			return payload, err
+		}
+		if request != nil {
+			payload = replace(payload, i, end, request)
+			end = i + len(request)
+		}
+		start = end + 1
+	}
+}
+
+// request handles one "DCC <type> <argument> <address> <port> [size]"
+// request. Address is a decimal number.
+func (h *IRC) request(ctx *Context, request []byte) ([]byte, error) {
+	fields := fieldBounds(request)
+	if len(fields) < 5 {
+		return nil, nil
+	}
+	dccType := string(bytes.ToUpper(request[fields[1][0]:fields[1][1]]))
+	if dccType != "CHAT" && dccType != "SEND" {
+		return nil, nil
+	}
+	ip := len(fields) - 2
+	if dccType == "SEND" && len(fields) > 5 && isDecimal(request[fields[ip-1][0]:fields[ip-1][1]]) {
+		// Last field is size of file
+		ip--
+	}
+	a, err1 := strconv.ParseUint(string(request[fields[ip][0]:fields[ip][1]]), 10, 32)
+	p, err2 := strconv.ParseUint(string(request[fields[ip+1][0]:fields[ip+1][1]]), 10, 16)
+	if err1 != nil || err2 != nil {
+		return nil, nil
+	}
+	addr := types.BytesToIPv4(byte(a>>24), byte(a>>16), byte(a>>8), byte(a))
+	port := swapBytesUint16(uint16(p))
+	taddr, tport, err := ctx.Translate(types.TCPNumber, addr, port)
+	if err != nil {
+		return nil, err
+	}
+	ctx.manager.Expect(&Expectation{
+		Tuple: conntrack.Tuple{
+			DstAddr: taddr,
+			DstPort: tport,
+			Proto:   types.TCPNumber,
+		},
+		Addr:   addr,
+		Port:   port,
+		Master: ctx.Conn,
+	}, ctx.Now)
+	b := types.IPv4ToBytes(taddr)
+	repl := strconv.AppendUint(nil, uint64(b[0])<<24|uint64(b[1])<<16|uint64(b[2])<<8|uint64(b[3]), 10)
+	repl = append(repl, ' ')
+	repl = strconv.AppendUint(repl, uint64(swapBytesUint16(tport)), 10)
+	return replace(append([]byte(nil), request...), fields[ip][0], fields[ip+1][1], repl), nil
+}
+
+// fieldBounds returns start and end offsets of space separated fields.
+func fieldBounds(s []byte) [][2]int {
+	var ret [][2]int
+	start := -1
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || s[i] == ' ' {
+			if start != -1 {
+				ret = append(ret, [2]int{start, i})
+				start = -1
+			}
+		} else if start == -1 {
+			start = i
+		}
+	}
+	return ret
+}
+
+func isDecimal(s []byte) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) != 0
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// 167772161 is 10.0.0.1, 16909060 is 1.2.3.4
+var ircTests = []struct {
+	payload, expected string
+	expectPort        uint16
+}{
+	{"PRIVMSG bob :\x01DCC CHAT chat 167772161 1025\x01\r\n",
+		"PRIVMSG bob :\x01DCC CHAT chat 16909060 2025\x01\r\n", 2025},
+	{"PRIVMSG bob :\x01DCC SEND file.txt 167772161 1026 1500\x01\r\n",
+		"PRIVMSG bob :\x01DCC SEND file.txt 16909060 2026 1500\x01\r\n", 2026},
+	{"PRIVMSG bob :\x01DCC SEND \"my file.txt\" 167772161 1027\x01\r\n",
+		"PRIVMSG bob :\x01DCC SEND \"my file.txt\" 16909060 2027\x01\r\n", 2027},
+	{"PRIVMSG bob :\x01DCC RESUME file.txt 1025 1000\x01\r\n",
+		"PRIVMSG bob :\x01DCC RESUME file.txt 1025 1000\x01\r\n", 0},
+	{"PRIVMSG bob :hello\r\n", "PRIVMSG bob :hello\r\n", 0},
+}
+
+func TestIRC(t *testing.T) {
+	tuple := conntrack.Tuple{SrcAddr: client, DstAddr: server, SrcPort: swapBytesUint16(3000),
+		DstPort: swapBytesUint16(IRCPort), Proto: types.TCPNumber}
+	for _, tt := range ircTests {
+		m, s := newTestSession(t, NewIRC(), tuple)
+		out, err := s.Process(conntrack.Original, tuple, 0, []byte(tt.payload), startTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tt.expected {
+			t.Errorf("got %q, want %q", out, tt.expected)
+		}
+		if tt.expectPort == 0 {
+			continue
+		}
+		// Other user connects from any address
+		peer := conntrack.Tuple{SrcAddr: types.BytesToIPv4(5, 6, 7, 8), DstAddr: publicIP, SrcPort: 1,
+			DstPort: swapBytesUint16(tt.expectPort), Proto: types.TCPNumber}
+		if e, ok := m.LookupExpectation(peer, startTime); !ok || e.Addr != client {
+			t.Errorf("%q: no expectation for peer connection", tt.payload)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// ProcessPacket passes payload of IPv4 TCP or UDP packet going in
+// direction dir to session helper. If payload is changed, packet is
+// resized and IPv4 length, UDP length, TCP sequence numbers and
+// checksums are updated. TCP sequence numbers are adjusted for all
+// packets of connection, so all of them should be passed here even
+// if they have no payload. Checksums are calculated in software.
+func (s *Session) ProcessPacket(pkt *packet.Packet, dir conntrack.Direction, now time.Time) error {
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil {
+		return nil
+	}
+	t := conntrack.Tuple{
+		SrcAddr: ipv4.SrcAddr,
+		DstAddr: ipv4.DstAddr,
+		Proto:   ipv4.NextProtoID,
+	}
+	var tcp *packet.TCPHdr
+	var udp *packet.UDPHdr
+	var seq uint32
+	switch t.Proto {
+	case types.TCPNumber:
+		tcp = pkt.GetTCPForIPv4()
+		t.SrcPort = tcp.SrcPort
+		t.DstPort = tcp.DstPort
+		seq = packet.SwapBytesUint32(tcp.SentSeq)
+	case types.UDPNumber:
+		udp = pkt.GetUDPForIPv4()
+		t.SrcPort = udp.SrcPort
+		t.DstPort = udp.DstPort
+	default:
+		return nil
+	}
+	payload, ok := pkt.GetPacketPayload()
+	if !ok {
+		return nil
+	}
+	// Cut Ethernet padding of short packets
+	l3ToData := int(uintptr(pkt.Data) - uintptr(pkt.L3))
+	if l := int(packet.SwapBytesUint16(ipv4.TotalLength)) - l3ToData; l >= 0 && l < len(payload) {
+		payload = payload[:l]
+	}
+	start := uint(uintptr(pkt.Data) - uintptr(pkt.StartAtOffset(0)))
+	out, err := s.Process(dir, t, seq, payload, now)
+	if err != nil {
+		return err
+	}
+	delta := len(out) - len(payload)
+	if delta > 0 {
+		if !pkt.EncapsulateTail(start+uint(len(payload)), uint(delta)) {
+			return common.WrapWithNFError(nil, "Can't enlarge packet for ALG payload", common.AllocMbufErr)
+		}
+	} else if delta < 0 {
+		if !pkt.DecapsulateTail(start+uint(len(out)), uint(-delta)) {
+			return common.WrapWithNFError(nil, "Can't shrink packet for ALG payload", common.Fail)
+		}
+	}
+	if delta != 0 {
+		// Changed payload is always a new slice, it doesn't point to packet
+		pkt.PacketBytesChange(start, out)
+		ipv4.TotalLength = packet.SwapBytesUint16(uint16(int(packet.SwapBytesUint16(ipv4.TotalLength)) + delta))
+		if udp != nil {
+			udp.DgramLen = packet.SwapBytesUint16(uint16(int(packet.SwapBytesUint16(udp.DgramLen)) + delta))
+		}
+	}
+	ipv4.HdrChecksum = packet.SwapBytesUint16(packet.CalculateIPv4Checksum(ipv4))
+	if tcp != nil {
+		tcp.SentSeq = packet.SwapBytesUint32(s.AdjustSeq(dir, seq))
+		tcp.RecvAck = packet.SwapBytesUint32(s.AdjustAck(dir, packet.SwapBytesUint32(tcp.RecvAck)))
+		tcp.Cksum = packet.SwapBytesUint16(packet.CalculateIPv4TCPChecksum(ipv4, tcp, pkt.Data))
+	} else {
+		udp.DgramCksum = packet.SwapBytesUint16(packet.CalculateIPv4UDPChecksum(ipv4, udp, pkt.Data))
+	}
+	return nil
+}
+
+// Enhanced GRE header flags used by PPTP: key is present, version 1
+const (
+	greKeyPresent = 0x2000
+	greVersion1   = 0x0001
+	greVersion    = 0x0007
+)
+
+// TranslateGRE changes call ID of enhanced GRE packet sent by PPTP
+// server to value chosen by client. Returns address of client which
+// packet should be delivered to. Returns false if packet is not
+// enhanced GRE or call is unknown.
+func (p *PPTP) TranslateGRE(pkt *packet.Packet) (types.IPv4Address, bool) {
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil || ipv4.NextProtoID != types.GRENumber {
+		return 0, false
+	}
+	pkt.ParseL4ForIPv4()
+	gre := pkt.GetGRENoCheck()
+	flags := packet.SwapBytesUint16(gre.Flags)
+	if flags&greVersion != greVersion1 || flags&greKeyPresent == 0 {
+		return 0, false
+	}
+	// Enhanced GRE key contains payload length and call ID
+	key := (*[2]uint16)(unsafe.Pointer(uintptr(pkt.L4) + types.GRELen))
+	call, ok := p.LookupCall(ipv4.SrcAddr, packet.SwapBytesUint16(key[1]))
+	if !ok {
+		return 0, false
+	}
+	key[1] = packet.SwapBytesUint16(call.ClientCallID)
+	return call.Client, true
+}
@@ -0,0 +1,229 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// PPTPPort is a default port of PPTP control connection.
+const PPTPPort = 1723
+
+const (
+	pptpMagicCookie   = 0x1a2b3c4d
+	pptpControlHeader = 12
+
+	pptpOutCallRequest       = 7
+	pptpOutCallReply         = 8
+	pptpInCallReply          = 10
+	pptpInCallConnected      = 11
+	pptpCallClearRequest     = 12
+	pptpCallDisconnectNotify = 13
+	pptpWANErrorNotify       = 14
+	pptpSetLinkInfo          = 15
+)
+
+type pptpKey struct {
+	server types.IPv4Address
+	callID uint16
+}
+
+type pptpClientKey struct {
+	client types.IPv4Address
+	server types.IPv4Address
+	callID uint16
+}
+
+// PPTPCall is a call of PPTP client translated by helper.
+type PPTPCall struct {
+	// Addresses of PPTP client and server
+	Client, Server types.IPv4Address
+	// Call ID chosen by client
+	ClientCallID uint16
+	// Call ID which server sees instead of ClientCallID. It is unique
+	// for every server, so server can distinguish clients which have
+	// the same address after translation.
+	CallID uint16
+	conn   *conntrack.Entry
+}
+
+// PPTP is a helper for PPTP control connections of clients. It
+// rewrites call IDs chosen by clients to call IDs which are unique
+// for every server, so several clients can connect to one server via
+// one translated address. Server uses these call IDs in enhanced GRE
+// headers of data packets which are sent to clients, network function
+// should translate them back with LookupCall or TranslateGRE.
+type PPTP struct {
+	ports []uint16
+	// Calls by server address and translated call ID
+	calls map[pptpKey]*PPTPCall
+	// Calls by client address, server address and client call ID
+	clientCalls map[pptpClientKey]*PPTPCall
+	nextCallID  uint16
+	lock        sync.Mutex
+}
+
+// NewPPTP creates PPTP helper for control connections to given ports.
+// If no ports are given PPTPPort is used.
+func NewPPTP(ports ...uint16) *PPTP {
+	return &PPTP{
+		ports:       networkPorts(ports, PPTPPort),
+		calls:       make(map[pptpKey]*PPTPCall),
+		clientCalls: make(map[pptpClientKey]*PPTPCall),
+	}
+}
+
+// Name returns "pptp".
+func (p *PPTP) Name() string {
+	return "pptp"
+}
+
+// Match returns true for TCP connections to PPTP ports.
+func (p *PPTP) Match(t conntrack.Tuple) bool {
+	return t.Proto == types.TCPNumber && hasPort(p.ports, t.DstPort)
+}
+
+// Process rewrites call IDs in control messages. Length of messages
+// is never changed. Every message should be contained in one packet.
+func (p *PPTP) Process(ctx *Context, payload []byte) ([]byte, error) {
+	for msg := payload; len(msg) >= pptpControlHeader; {
+		length := int(binary.BigEndian.Uint16(msg[0:]))
+		if length < pptpControlHeader || length > len(msg) ||
+			binary.BigEndian.Uint32(msg[4:]) != pptpMagicCookie {
+			break
+		}
+		var err error
+		if ctx.Dir == conntrack.Original {
+			err = p.clientMessage(ctx, msg[:length])
+		} else {
+			p.serverMessage(ctx, msg[:length])
+		}
+		if err != nil {
+			return payload, err
+		}
+		msg = msg[length:]
+	}
+	return payload, nil
+}
+
+// clientMessage rewrites call ID of client in message.
+func (p *PPTP) clientMessage(ctx *Context, msg []byte) error {
+	if len(msg) < pptpControlHeader+2 {
+		return nil
+	}
+	field := msg[pptpControlHeader:]
+	callID := binary.BigEndian.Uint16(field)
+	switch binary.BigEndian.Uint16(msg[8:]) {
+	case pptpOutCallRequest, pptpInCallReply:
+		call, err := p.newCall(ctx, callID)
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint16(field, call.CallID)
+	case pptpCallClearRequest:
+		if call, ok := p.clientCall(ctx.Tuple.SrcAddr, ctx.Tuple.DstAddr, callID); ok {
+			binary.BigEndian.PutUint16(field, call.CallID)
+		}
+	}
+	return nil
+}
+
+// serverMessage rewrites call ID of client which is sent by server as
+// peer call ID back to value chosen by client.
+func (p *PPTP) serverMessage(ctx *Context, msg []byte) {
+	var offset int
+	msgType := binary.BigEndian.Uint16(msg[8:])
+	switch msgType {
+	case pptpOutCallReply:
+		offset = pptpControlHeader + 2
+	case pptpInCallConnected, pptpCallDisconnectNotify, pptpWANErrorNotify, pptpSetLinkInfo:
+		offset = pptpControlHeader
+	default:
+		return
+	}
+	if len(msg) < offset+2 {
+		return
+	}
+	call, ok := p.LookupCall(ctx.Tuple.SrcAddr, binary.BigEndian.Uint16(msg[offset:]))
+	if !ok {
+		return
+	}
+	binary.BigEndian.PutUint16(msg[offset:], call.ClientCallID)
+	if msgType == pptpCallDisconnectNotify {
+		p.removeCall(call)
+	}
+}
+
+func (p *PPTP) newCall(ctx *Context, clientCallID uint16) (*PPTPCall, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	client := pptpClientKey{ctx.Tuple.SrcAddr, ctx.Tuple.DstAddr, clientCallID}
+	if call, ok := p.clientCalls[client]; ok {
+		// Retransmission of request
+		return call, nil
+	}
+	call := &PPTPCall{
+		Client:       ctx.Tuple.SrcAddr,
+		Server:       ctx.Tuple.DstAddr,
+		ClientCallID: clientCallID,
+		conn:         ctx.Conn,
+	}
+	for i := 0; ; i++ {
+		if i > 0xffff {
+			return nil, common.WrapWithNFError(nil, "No free PPTP call IDs for server "+call.Server.String(), common.TableIsFull)
+		}
+		p.nextCallID++
+		if p.nextCallID == 0 {
+			p.nextCallID++
+		}
+		if _, ok := p.calls[pptpKey{call.Server, p.nextCallID}]; !ok {
+			break
+		}
+	}
+	call.CallID = p.nextCallID
+	p.calls[pptpKey{call.Server, call.CallID}] = call
+	p.clientCalls[client] = call
+	return call, nil
+}
+
+func (p *PPTP) clientCall(client, server types.IPv4Address, clientCallID uint16) (*PPTPCall, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	call, ok := p.clientCalls[pptpClientKey{client, server, clientCallID}]
+	return call, ok
+}
+
+func (p *PPTP) removeCall(call *PPTPCall) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.calls, pptpKey{call.Server, call.CallID})
+	delete(p.clientCalls, pptpClientKey{call.Client, call.Server, call.ClientCallID})
+}
+
+// LookupCall finds call by server address and call ID which server
+// uses for translated client. callID is in host byte order.
+func (p *PPTP) LookupCall(server types.IPv4Address, callID uint16) (*PPTPCall, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	call, ok := p.calls[pptpKey{server, callID}]
+	return call, ok
+}
+
+// Close removes all calls of control connection.
+func (p *PPTP) Close(s *Session) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for key, call := range p.calls {
+		if call.conn == s.Conn {
+			delete(p.calls, key)
+			delete(p.clientCalls, pptpClientKey{call.Client, call.Server, call.ClientCallID})
+		}
+	}
+}
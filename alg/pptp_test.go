@@ -0,0 +1,77 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// pptpMessage creates control message of given type with two first
+// fields set to id and peerID.
+func pptpMessage(msgType, id, peerID uint16) []byte {
+	msg := make([]byte, 32)
+	binary.BigEndian.PutUint16(msg[0:], uint16(len(msg)))
+	binary.BigEndian.PutUint16(msg[2:], 1)
+	binary.BigEndian.PutUint32(msg[4:], pptpMagicCookie)
+	binary.BigEndian.PutUint16(msg[8:], msgType)
+	binary.BigEndian.PutUint16(msg[12:], id)
+	binary.BigEndian.PutUint16(msg[14:], peerID)
+	return msg
+}
+
+func TestPPTP(t *testing.T) {
+	pptp := NewPPTP()
+	m := NewManager()
+	m.Register(pptp)
+	table := conntrack.NewTable(0, conntrack.DefaultTimeouts())
+	clients := []types.IPv4Address{client, types.BytesToIPv4(10, 0, 0, 2)}
+	var sessions []*Session
+	var callIDs []uint16
+	for _, c := range clients {
+		tuple := conntrack.Tuple{SrcAddr: c, DstAddr: server, SrcPort: 1,
+			DstPort: swapBytesUint16(PPTPPort), Proto: types.TCPNumber}
+		conn, _, _ := table.Track(tuple, types.TCPFlagSyn, startTime)
+		s := m.Attach(conn, nil)
+		if s == nil {
+			t.Fatal("PPTP helper doesn't match control connection")
+		}
+		// Both clients use the same call ID
+		out, err := s.Process(conntrack.Original, tuple, 0, pptpMessage(pptpOutCallRequest, 5, 0), startTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		callIDs = append(callIDs, binary.BigEndian.Uint16(out[12:]))
+		sessions = append(sessions, s)
+
+		reply := pptpMessage(pptpOutCallReply, 100, callIDs[len(callIDs)-1])
+		out, _ = s.Process(conntrack.Reply, tuple.Reverse(), 0, reply, startTime)
+		if got := binary.BigEndian.Uint16(out[14:]); got != 5 {
+			t.Errorf("client %s got peer call ID %d in reply, want 5", c.String(), got)
+		}
+	}
+	if callIDs[0] == callIDs[1] {
+		t.Fatalf("clients got the same call ID %d", callIDs[0])
+	}
+	for i, c := range clients {
+		call, ok := pptp.LookupCall(server, callIDs[i])
+		if !ok || call.Client != c || call.ClientCallID != 5 {
+			t.Errorf("call %d: got %v %v", callIDs[i], call, ok)
+		}
+	}
+
+	tuple := sessions[0].Conn.Original
+	sessions[0].Process(conntrack.Reply, tuple.Reverse(), 0, pptpMessage(pptpCallDisconnectNotify, callIDs[0], 0), startTime)
+	if _, ok := pptp.LookupCall(server, callIDs[0]); ok {
+		t.Error("call is found after disconnect")
+	}
+	m.Detach(sessions[1].Conn)
+	if _, ok := pptp.LookupCall(server, callIDs[1]); ok {
+		t.Error("call is found after control connection is closed")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// RTSPPort is a default port of RTSP server.
+const RTSPPort = 554
+
+// rtspMapping is an address and port of client which were replaced
+// in Transport header.
+type rtspMapping struct {
+	addr types.IPv4Address
+	port uint16
+}
+
+// RTSP is a helper for RTSP control connections of clients. It
+// rewrites client_port and destination parameters of Transport
+// header in requests and expects RTP and RTCP streams from server to
+// translated ports. Server echoes Transport header in its reply, so
+// helper rewrites these parameters back. Streams interleaved with
+// control connection don't need helper and are ignored.
+type RTSP struct {
+	ports []uint16
+}
+
+// NewRTSP creates RTSP helper for connections to given ports. If no
+// ports are given RTSPPort is used.
+func NewRTSP(ports ...uint16) *RTSP {
+	return &RTSP{ports: networkPorts(ports, RTSPPort)}
+}
+
+// Name returns "rtsp".
+func (r *RTSP) Name() string {
+	return "rtsp"
+}
+
+// Match returns true for TCP connections to RTSP ports.
+func (r *RTSP) Match(t conntrack.Tuple) bool {
+	return t.Proto == types.TCPNumber && hasPort(r.ports, t.DstPort)
+}
+
+// Process rewrites Transport headers of one RTSP message. Headers of
+// message should be contained in one packet.
+func (r *RTSP) Process(ctx *Context, payload []byte) ([]byte, error) {
+	headEnd := bytes.Index(payload, []byte("\r\n\r\n"))
+	if headEnd == -1 {
+		headEnd = len(payload)
+	}
+	if ctx.Data == nil {
+		ctx.Data = make(map[uint16]rtspMapping)
+	}
+	for start := 0; start < headEnd; {
+		end := bytes.IndexByte(payload[start:headEnd], '\n')
+		if end == -1 {
+			end = headEnd
+		} else {
+			end += start
+		}
+		if hasPrefixFold(payload[start:end], "Transport:") {
+			value := start + len("Transport:")
+			valueEnd := end
+			if valueEnd > value && payload[valueEnd-1] == '\r' {
+				valueEnd--
+			}
+			var repl []byte
+			var err error
+			if ctx.Dir == conntrack.Original {
+				repl, err = r.request(ctx, payload[value:valueEnd])
+			} else {
+				repl = r.reply(ctx, payload[value:valueEnd])
+			}
+			if err != nil {
+				return payload, err
+			}
+			delta := len(repl) - (valueEnd - value)
+			payload = replace(payload, value, valueEnd, repl)
+			headEnd += delta
+			end += delta
+		}
+		start = end + 1
+	}
+	return payload, nil
+}
+
+// request rewrites client ports and destination in every transport
+// specification and expects streams from server to them.
+func (r *RTSP) request(ctx *Context, value []byte) ([]byte, error) {
+	mappings := ctx.Data.(map[uint16]rtspMapping)
+	var out []byte
+	for _, spec := range bytes.Split(value, []byte(",")) {
+		params := bytes.Split(spec, []byte(";"))
+		addr := ctx.Tuple.SrcAddr
+		for _, p := range params {
+			if a, ok := parseIPv4(bytes.TrimSpace(paramValue(p, "destination="))); ok {
+				addr = a
+			}
+		}
+		taddr := addr
+		for i, p := range params {
+			ports := paramValue(p, "client_port=")
+			if ports == nil {
+				continue
+			}
+			min, max, ok := parsePortRange(ports)
+			if !ok {
+				continue
+			}
+			var tmin, tmax uint16
+			for port := min; ; port++ {
+				nport := swapBytesUint16(port)
+				a, tport, err := ctx.Translate(types.UDPNumber, addr, nport)
+				if err != nil {
+					return nil, err
+				}
+				taddr = a
+				ctx.Expect(types.UDPNumber, 0, taddr, tport, addr, nport, nil)
+				mappings[swapBytesUint16(tport)] = rtspMapping{addr, nport}
+				if port == min {
+					tmin = swapBytesUint16(tport)
+				}
+				tmax = swapBytesUint16(tport)
+				if port == max {
+					break
+				}
+			}
+			params[i] = formatPortRange(p, tmin, tmax, min != max)
+		}
+		for i, p := range params {
+			if paramValue(p, "destination=") != nil && taddr != addr {
+				params[i] = append([]byte("destination="), taddr.String()...)
+			}
+		}
+		if out != nil {
+			out = append(out, ',')
+		}
+		out = append(out, bytes.Join(params, []byte(";"))...)
+	}
+	return out, nil
+}
+
+// reply restores client ports and destination which server copied
+// from request.
+func (r *RTSP) reply(ctx *Context, value []byte) []byte {
+	mappings := ctx.Data.(map[uint16]rtspMapping)
+	var out []byte
+	for _, spec := range bytes.Split(value, []byte(",")) {
+		params := bytes.Split(spec, []byte(";"))
+		var addr types.IPv4Address
+		for i, p := range params {
+			min, max, ok := parsePortRange(paramValue(p, "client_port="))
+			if !ok {
+				continue
+			}
+			m1, ok1 := mappings[min]
+			m2, ok2 := mappings[max]
+			if ok1 && ok2 {
+				addr = m1.addr
+				params[i] = formatPortRange(p, swapBytesUint16(m1.port), swapBytesUint16(m2.port), min != max)
+			}
+		}
+		for i, p := range params {
+			if paramValue(p, "destination=") != nil && addr != 0 {
+				params[i] = append([]byte("destination="), addr.String()...)
+			}
+		}
+		if out != nil {
+			out = append(out, ',')
+		}
+		out = append(out, bytes.Join(params, []byte(";"))...)
+	}
+	return out
+}
+
+// paramValue returns value of transport parameter if it has given
+// name or nil otherwise.
+func paramValue(param []byte, name string) []byte {
+	param = bytes.TrimLeft(param, " \t")
+	if !hasPrefixFold(param, name) {
+		return nil
+	}
+	return bytes.TrimRight(param[len(name):], " \t")
+}
+
+// parsePortRange parses "port" or "port-port" value in host byte
+// order.
+func parsePortRange(s []byte) (uint16, uint16, bool) {
+	if s == nil {
+		return 0, 0, false
+	}
+	first, last := s, s
+	if i := bytes.IndexByte(s, '-'); i != -1 {
+		first, last = s[:i], s[i+1:]
+	}
+	min, err1 := strconv.ParseUint(string(first), 10, 16)
+	max, err2 := strconv.ParseUint(string(last), 10, 16)
+	if err1 != nil || err2 != nil || min == 0 || max < min {
+		return 0, 0, false
+	}
+	return uint16(min), uint16(max), true
+}
+
+// formatPortRange returns parameter with the same name and new port
+// range.
+func formatPortRange(param []byte, min, max uint16, isRange bool) []byte {
+	eq := bytes.IndexByte(param, '=')
+	out := append([]byte(nil), param[:eq+1]...)
+	out = strconv.AppendUint(out, uint64(min), 10)
+	if isRange {
+		out = append(out, '-')
+		out = strconv.AppendUint(out, uint64(max), 10)
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+const rtspSetup = "SETUP rtsp://192.168.1.1/stream/track1 RTSP/1.0\r\n" +
+	"CSeq: 3\r\n" +
+	"Transport: RTP/AVP;unicast;client_port=8000-8001\r\n" +
+	"\r\n"
+
+const rtspReply = "RTSP/1.0 200 OK\r\n" +
+	"CSeq: 3\r\n" +
+	"Transport: RTP/AVP;unicast;client_port=%s;server_port=9000-9001\r\n" +
+	"Session: 12345678\r\n" +
+	"\r\n"
+
+func TestRTSP(t *testing.T) {
+	tuple := conntrack.Tuple{SrcAddr: client, DstAddr: server, SrcPort: swapBytesUint16(3000),
+		DstPort: swapBytesUint16(RTSPPort), Proto: types.TCPNumber}
+	m, s := newTestSession(t, NewRTSP(), tuple)
+	out, err := s.Process(conntrack.Original, tuple, 100, []byte(rtspSetup), startTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "SETUP rtsp://192.168.1.1/stream/track1 RTSP/1.0\r\n" +
+		"CSeq: 3\r\n" +
+		"Transport: RTP/AVP;unicast;client_port=9000-9001\r\n" +
+		"\r\n"
+	if string(out) != expected {
+		t.Errorf("got %q, expected %q", out, expected)
+	}
+	for _, port := range []uint16{8000, 8001} {
+		stream := conntrack.Tuple{SrcAddr: server, DstAddr: publicIP, SrcPort: swapBytesUint16(9000),
+			DstPort: swapBytesUint16(port + 1000), Proto: types.UDPNumber}
+		e, ok := m.LookupExpectation(stream, startTime)
+		if !ok {
+			t.Errorf("no expectation for stream to port %d", port)
+			continue
+		}
+		if e.Addr != client || e.Port != swapBytesUint16(port) {
+			t.Errorf("stream goes to %s:%d", e.Addr.String(), swapBytesUint16(e.Port))
+		}
+	}
+
+	reply := tuple.Reverse()
+	out, err = s.Process(conntrack.Reply, reply, 200, []byte(replacePorts(rtspReply, "9000-9001")), startTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := replacePorts(rtspReply, "8000-8001"); string(out) != expected {
+		t.Errorf("got %q, expected %q", out, expected)
+	}
+}
+
+func TestRTSPDestination(t *testing.T) {
+	tuple := conntrack.Tuple{SrcAddr: client, DstAddr: server, SrcPort: swapBytesUint16(3000),
+		DstPort: swapBytesUint16(RTSPPort), Proto: types.TCPNumber}
+	m, s := newTestSession(t, NewRTSP(), tuple)
+	request := "SETUP rtsp://192.168.1.1/stream RTSP/1.0\r\n" +
+		"transport: RTP/AVP;unicast;destination=10.0.0.2;client_port=5000\r\n" +
+		"\r\n"
+	out, err := s.Process(conntrack.Original, tuple, 100, []byte(request), startTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "SETUP rtsp://192.168.1.1/stream RTSP/1.0\r\n" +
+		"transport: RTP/AVP;unicast;destination=1.2.3.4;client_port=6000\r\n" +
+		"\r\n"
+	if string(out) != expected {
+		t.Errorf("got %q, expected %q", out, expected)
+	}
+	stream := conntrack.Tuple{SrcAddr: server, DstAddr: publicIP, SrcPort: swapBytesUint16(9000),
+		DstPort: swapBytesUint16(6000), Proto: types.UDPNumber}
+	e, ok := m.LookupExpectation(stream, startTime)
+	if !ok {
+		t.Fatal("no expectation for stream")
+	}
+	if e.Addr != types.BytesToIPv4(10, 0, 0, 2) {
+		t.Errorf("stream goes to %s", e.Addr.String())
+	}
+}
+
+func replacePorts(s, ports string) string {
+	for i := 0; i+2 <= len(s); i++ {
+		if s[i:i+2] == "%s" {
+			return s[:i] + ports + s[i+2:]
+		}
+	}
+	return s
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// SIPPort is a default port of SIP signalling.
+const SIPPort = 5060
+
+// SIP is a helper for SIP signalling over UDP. It rewrites address of
+// sender in Via and Contact headers and media addresses in SDP body,
+// and expects RTP and RTCP streams of every media description.
+type SIP struct {
+	ports []uint16
+}
+
+// NewSIP creates SIP helper for signalling to given ports. If no
+// ports are given SIPPort is used.
+func NewSIP(ports ...uint16) *SIP {
+	return &SIP{ports: networkPorts(ports, SIPPort)}
+}
+
+// Name returns "sip".
+func (s *SIP) Name() string {
+	return "sip"
+}
+
+// Match returns true for UDP signalling to SIP ports.
+func (s *SIP) Match(t conntrack.Tuple) bool {
+	return t.Proto == types.UDPNumber && hasPort(s.ports, t.DstPort)
+}
+
+// Process rewrites one SIP message.
+func (s *SIP) Process(ctx *Context, payload []byte) ([]byte, error) {
+	bodyStart := bytes.Index(payload, []byte("\r\n\r\n"))
+	if bodyStart == -1 {
+		return payload, nil
+	}
+	bodyStart += 4
+	head := payload[:bodyStart]
+	body := payload[bodyStart:]
+
+	from := ctx.Tuple.SrcAddr.String()
+	taddr, _, err := ctx.Translate(types.UDPNumber, ctx.Tuple.SrcAddr, ctx.Tuple.SrcPort)
+	if err != nil {
+		return payload, err
+	}
+	to := taddr.String()
+
+	out := append([]byte(nil), head...)
+	if to != from {
+		out = replaceInLines(out, from, to, "Via:", "v:", "Contact:", "m:")
+	}
+
+	newBody, err := s.processSDP(ctx, body)
+	if err != nil {
+		return payload, err
+	}
+	if len(newBody) != len(body) {
+		out = setContentLength(out, len(newBody))
+	}
+	return append(out, newBody...), nil
+}
+
+// processSDP rewrites connection addresses and media ports of SDP
+// body.
+func (s *SIP) processSDP(ctx *Context, body []byte) ([]byte, error) {
+	// Session level connection address is used by all media
+	// descriptions which don't have their own.
+	var addr types.IPv4Address
+	if c := bytes.Index(body, []byte("c=IN IP4 ")); c != -1 {
+		end := c + 9
+		for end < len(body) && body[end] != '\r' && body[end] != '\n' && body[end] != '/' {
+			end++
+		}
+		addr, _ = parseIPv4(body[c+9 : end])
+	}
+	if addr == 0 {
+		return body, nil
+	}
+	var out []byte
+	var taddr types.IPv4Address
+	for start := 0; start < len(body); {
+		end := bytes.IndexByte(body[start:], '\n')
+		if end == -1 {
+			end = len(body)
+		} else {
+			end += start + 1
+		}
+		line := body[start:end]
+		start = end
+		if !bytes.HasPrefix(line, []byte("m=")) {
+			out = append(out, line...)
+			continue
+		}
+		// m=<media> <port> <proto> <fmt> ...
+		fields := bytes.SplitN(line, []byte(" "), 3)
+		if len(fields) != 3 {
+			out = append(out, line...)
+			continue
+		}
+		p, err := strconv.ParseUint(string(fields[1]), 10, 16)
+		if err != nil || p == 0 {
+			out = append(out, line...)
+			continue
+		}
+		port := swapBytesUint16(uint16(p))
+		var tport uint16
+		if taddr, tport, err = ctx.Translate(types.UDPNumber, addr, port); err != nil {
+			return body, err
+		}
+		rtcp := swapBytesUint16(uint16(p + 1))
+		trtcpAddr, trtcp, err := ctx.Translate(types.UDPNumber, addr, rtcp)
+		if err != nil {
+			return body, err
+		}
+		ctx.Expect(types.UDPNumber, 0, taddr, tport, addr, port, nil)
+		ctx.Expect(types.UDPNumber, 0, trtcpAddr, trtcp, addr, rtcp, nil)
+		out = append(out, fields[0]...)
+		out = append(out, ' ')
+		out = strconv.AppendUint(out, uint64(swapBytesUint16(tport)), 10)
+		out = append(out, ' ')
+		out = append(out, fields[2]...)
+		if trtcp != swapBytesUint16(swapBytesUint16(tport)+1) || trtcpAddr != taddr {
+			// RTCP is not on the next port, announce it explicitly (RFC 3605)
+			out = append(out, "a=rtcp:"...)
+			out = strconv.AppendUint(out, uint64(swapBytesUint16(trtcp)), 10)
+			out = append(out, " IN IP4 "...)
+			out = append(out, trtcpAddr.String()...)
+			out = append(out, "\r\n"...)
+		}
+	}
+	if taddr != 0 && taddr != addr {
+		out = replaceInLines(out, addr.String(), taddr.String(), "c=", "o=")
+	}
+	return out, nil
+}
+
+// replaceHost replaces host in header line if it is followed by
+// port, parameters or end of address.
+func replaceHost(line []byte, from, to string) []byte {
+	var out []byte
+	for {
+		i := bytes.Index(line, []byte(from))
+		if i == -1 {
+			return append(out, line...)
+		}
+		end := i + len(from)
+		if end < len(line) && (line[end] >= '0' && line[end] <= '9' || line[end] == '.') ||
+			i > 0 && (line[i-1] >= '0' && line[i-1] <= '9' || line[i-1] == '.') {
+			// Part of other address
+			out = append(out, line[:end]...)
+		} else {
+			out = append(out, line[:i]...)
+			out = append(out, to...)
+		}
+		line = line[end:]
+	}
+}
+
+// replaceInLines replaces host in all lines which start with one of
+// prefixes.
+func replaceInLines(text []byte, from, to string, prefixes ...string) []byte {
+	var out []byte
+	for start := 0; start < len(text); {
+		end := bytes.IndexByte(text[start:], '\n')
+		if end == -1 {
+			end = len(text)
+		} else {
+			end += start + 1
+		}
+		line := text[start:end]
+		for _, p := range prefixes {
+			if hasPrefixFold(line, p) {
+				line = replaceHost(line, from, to)
+				break
+			}
+		}
+		out = append(out, line...)
+		start = end
+	}
+	return out
+}
+
+// setContentLength changes value of Content-Length header.
+func setContentLength(head []byte, length int) []byte {
+	for start := 0; start < len(head); {
+		end := bytes.IndexByte(head[start:], '\n')
+		if end == -1 {
+			return head
+		}
+		end += start + 1
+		line := head[start:end]
+		if hasPrefixFold(line, "Content-Length:") || hasPrefixFold(line, "l:") {
+			colon := bytes.IndexByte(line, ':')
+			return replace(head, start+colon+1, end, []byte(" "+strconv.Itoa(length)+"\r\n"))
+		}
+		start = end
+	}
+	return head
+}
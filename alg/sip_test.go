@@ -0,0 +1,52 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+const sipInvite = "INVITE sip:bob@192.168.1.1 SIP/2.0\r\n" +
+	"Via: SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK776asdhds\r\n" +
+	"From: <sip:alice@10.0.0.10>;tag=1928301774\r\n" +
+	"Contact: <sip:alice@10.0.0.1:5060>\r\n" +
+	"Content-Type: application/sdp\r\n" +
+	"Content-Length: %d\r\n" +
+	"\r\n"
+
+const sipSDP = "v=0\r\n" +
+	"o=alice 2890844526 2890844526 IN IP4 10.0.0.1\r\n" +
+	"c=IN IP4 10.0.0.1\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 49170 RTP/AVP 0\r\n"
+
+func TestSIP(t *testing.T) {
+	tuple := conntrack.Tuple{SrcAddr: client, DstAddr: server, SrcPort: swapBytesUint16(SIPPort),
+		DstPort: swapBytesUint16(SIPPort), Proto: types.UDPNumber}
+	m, s := newTestSession(t, NewSIP(), tuple)
+	payload := strings.Replace(sipInvite, "%d", "103", 1) + sipSDP
+	out, err := s.Process(conntrack.Original, tuple, 0, []byte(payload), startTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sdp := strings.Replace(strings.Replace(sipSDP, "10.0.0.1", "1.2.3.4", -1), "49170", "50170", 1)
+	expected := strings.Replace(strings.Replace(sipInvite, "10.0.0.1:", "1.2.3.4:", -1), "%d", "101", 1) + sdp
+	if string(out) != expected {
+		t.Errorf("got\n%s\nwant\n%s", out, expected)
+	}
+	for _, port := range []uint16{50170, 50171} {
+		e, ok := m.LookupExpectation(conntrack.Tuple{SrcAddr: server, DstAddr: publicIP, SrcPort: 1,
+			DstPort: swapBytesUint16(port), Proto: types.UDPNumber}, startTime)
+		if !ok {
+			t.Errorf("no expectation for port %d", port)
+		} else if swapBytesUint16(e.Port) != port-1000 || e.Addr != client {
+			t.Errorf("expectation for port %d goes to %s:%d", port, e.Addr.String(), swapBytesUint16(e.Port))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// TFTPPort is a default port of TFTP server.
+const TFTPPort = 69
+
+// TFTP opcodes which start transfer
+const (
+	tftpReadRequest  = 1
+	tftpWriteRequest = 2
+)
+
+// TFTP is a helper for TFTP. Server answers to read and write
+// requests from new ephemeral port, so helper expects connection
+// from any port of server to address and port of client. Payload
+// is never changed.
+type TFTP struct {
+	ports []uint16
+}
+
+// NewTFTP creates TFTP helper for requests to given ports. If no
+// ports are given TFTPPort is used.
+func NewTFTP(ports ...uint16) *TFTP {
+	return &TFTP{ports: networkPorts(ports, TFTPPort)}
+}
+
+// Name returns "tftp".
+func (f *TFTP) Name() string {
+	return "tftp"
+}
+
+// Match returns true for UDP requests to TFTP ports.
+func (f *TFTP) Match(t conntrack.Tuple) bool {
+	return t.Proto == types.UDPNumber && hasPort(f.ports, t.DstPort)
+}
+
+// Process expects data connection for every read or write request.
+func (f *TFTP) Process(ctx *Context, payload []byte) ([]byte, error) {
+	if ctx.Dir != conntrack.Original || len(payload) < 2 || payload[0] != 0 ||
+		(payload[1] != tftpReadRequest && payload[1] != tftpWriteRequest) {
+		return payload, nil
+	}
+	taddr, tport, err := ctx.Translate(types.UDPNumber, ctx.Tuple.SrcAddr, ctx.Tuple.SrcPort)
+	if err != nil {
+		return payload, err
+	}
+	ctx.Expect(types.UDPNumber, 0, taddr, tport, ctx.Tuple.SrcAddr, ctx.Tuple.SrcPort, nil)
+	return payload, nil
+}
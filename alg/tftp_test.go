@@ -0,0 +1,42 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alg
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+func TestTFTP(t *testing.T) {
+	tuple := conntrack.Tuple{SrcAddr: client, DstAddr: server, SrcPort: swapBytesUint16(3000),
+		DstPort: swapBytesUint16(TFTPPort), Proto: types.UDPNumber}
+	m, s := newTestSession(t, NewTFTP(), tuple)
+	data := []byte("\x00\x03\x00\x01data")
+	if _, err := s.Process(conntrack.Original, tuple, 0, data, startTime); err != nil {
+		t.Fatal(err)
+	}
+	serverReply := conntrack.Tuple{SrcAddr: server, DstAddr: publicIP, SrcPort: swapBytesUint16(40000),
+		DstPort: swapBytesUint16(4000), Proto: types.UDPNumber}
+	if _, ok := m.LookupExpectation(serverReply, startTime); ok {
+		t.Error("expectation is created for data packet")
+	}
+	request := []byte("\x00\x01file.txt\x00octet\x00")
+	out, err := s.Process(conntrack.Original, tuple, 0, request, startTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != string(request) {
+		t.Errorf("request is changed to %q", out)
+	}
+	e, ok := m.LookupExpectation(serverReply, startTime)
+	if !ok {
+		t.Fatal("no expectation for server reply")
+	}
+	if e.Addr != client || e.Port != tuple.SrcPort {
+		t.Errorf("expectation goes to %s:%d", e.Addr.String(), swapBytesUint16(e.Port))
+	}
+}
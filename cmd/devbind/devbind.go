@@ -0,0 +1,97 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// devbind binds network devices to DPDK drivers and returns them to
+// kernel drivers. It refuses to bind devices which are used by
+// management network interfaces unless -force is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/intel-go/nff-go/devices"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: devbind [options] bind|unbind|status device...")
+	fmt.Fprintln(os.Stderr, "Device is PCI address, VMBus UUID or kernel interface name.")
+	flag.PrintDefaults()
+}
+
+func bind(device devices.Device, driver string, force bool) error {
+	if nics := devices.ManagementInterfaces(device.ID()); len(nics) != 0 && !force {
+		return fmt.Errorf("%v (%s), use -force to bind anyway", devices.ErrManagementDevice, strings.Join(nics, ", "))
+	}
+	return device.Bind(driver)
+}
+
+// unbind unbinds DPDK driver and probes kernel driver for device.
+func unbind(device devices.Device) error {
+	if err := device.Unbind(); err != nil {
+		return err
+	}
+	return device.Probe()
+}
+
+func status(device devices.Device) error {
+	driver, err := device.CurrentDriver()
+	if err != nil {
+		return err
+	}
+	if driver == "" {
+		driver = "none"
+	}
+	fmt.Printf("%s driver: %s", device.ID(), driver)
+	if nics := devices.ManagementInterfaces(device.ID()); len(nics) != 0 {
+		fmt.Printf(", management interfaces: %s", strings.Join(nics, ", "))
+	}
+	fmt.Println()
+	return nil
+}
+
+func main() {
+	driver := flag.String("driver", devices.DriverVfioPci, "DPDK driver to bind devices to.")
+	force := flag.Bool("force", false, "Bind devices used by management network interfaces.")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var action func(devices.Device) error
+	switch flag.Arg(0) {
+	case "bind":
+		if !devices.IsModuleLoaded(strings.Replace(*driver, "-", "_", -1)) {
+			fmt.Fprintln(os.Stderr, "Driver", *driver+":", devices.ErrKernelModuleNotLoaded)
+			os.Exit(1)
+		}
+		action = func(d devices.Device) error { return bind(d, *driver, *force) }
+	case "unbind":
+		action = unbind
+	case "status":
+		action = status
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, id := range flag.Args()[1:] {
+		device, err := devices.New(id)
+		if err == nil {
+			err = action(device)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, id+":", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
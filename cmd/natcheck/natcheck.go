@@ -0,0 +1,299 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// natcheck runs behind NAT and detects its behavior with STUN tests
+// from RFC 5780. It reports mapping and filtering behavior in terms
+// of RFC 4787, port preservation and hairpinning support. STUN server
+// should have two IP addresses and support OTHER-ADDRESS and
+// CHANGE-REQUEST attributes.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var errTimeout = errors.New("no response")
+
+// client sends STUN requests from one local port.
+type client struct {
+	conn    *net.UDPConn
+	timeout time.Duration
+	retries int
+}
+
+func newClient(local *net.UDPAddr, timeout time.Duration, retries int) (*client, error) {
+	conn, err := net.ListenUDP("udp4", local)
+	if err != nil {
+		return nil, err
+	}
+	return &client{conn: conn, timeout: timeout, retries: retries}, nil
+}
+
+func (c *client) close() {
+	c.conn.Close()
+}
+
+func (c *client) localAddr() *net.UDPAddr {
+	return c.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// request sends Binding request to server and waits for response.
+// Response is accepted from any address because server answers to
+// CHANGE-REQUEST from other address or port.
+func (c *client) request(server *net.UDPAddr, change uint32) (*stunResponse, error) {
+	req := newRequest(change)
+	msg := req.marshal()
+	buf := make([]byte, 1500)
+	for i := 0; i <= c.retries; i++ {
+		if _, err := c.conn.WriteToUDP(msg, server); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(c.timeout)
+		c.conn.SetReadDeadline(deadline)
+		for {
+			n, _, err := c.conn.ReadFromUDP(buf)
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			resp, err := parseResponse(buf[:n])
+			if err != nil || resp.id != req.id {
+				continue
+			}
+			return resp, nil
+		}
+	}
+	return nil, errTimeout
+}
+
+// waitRequest waits for Binding request with given transaction ID.
+func (c *client) waitRequest(id transactionID) bool {
+	buf := make([]byte, 1500)
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	for {
+		n, _, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			return false
+		}
+		if got, ok := isRequest(buf[:n]); ok && got == id {
+			return true
+		}
+	}
+}
+
+type checker struct {
+	server  *net.UDPAddr
+	local   *net.UDPAddr
+	timeout time.Duration
+	retries int
+}
+
+// newClient creates client on local port if fixed is true or on any
+// free port otherwise.
+func (ch *checker) newClient(fixed bool) (*client, error) {
+	local := ch.local
+	if !fixed {
+		local = &net.UDPAddr{IP: ch.local.IP}
+	}
+	return newClient(local, ch.timeout, ch.retries)
+}
+
+// mappingResult is a result of mapping tests.
+type mappingResult struct {
+	behavior string
+	// Local address used for tests
+	local *net.UDPAddr
+	// Mapped address from the first test
+	mapped *net.UDPAddr
+	// Other address of server
+	other *net.UDPAddr
+}
+
+// mapping performs mapping tests (RFC 5780, section 4.3).
+func (ch *checker) mapping() (*mappingResult, error) {
+	c, err := ch.newClient(true)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+	r1, err := c.request(ch.server, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r1.other == nil {
+		return nil, errors.New("server doesn't report its other address, RFC 5780 tests are impossible")
+	}
+	res := &mappingResult{local: c.localAddr(), mapped: r1.mapped, other: r1.other}
+	if isLocal(r1.mapped) {
+		res.behavior = "no NAT"
+		return res, nil
+	}
+	r2, err := c.request(&net.UDPAddr{IP: r1.other.IP, Port: ch.server.Port}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r2.mapped.String() == r1.mapped.String() {
+		res.behavior = "endpoint-independent"
+		return res, nil
+	}
+	r3, err := c.request(r1.other, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r3.mapped.String() == r2.mapped.String() {
+		res.behavior = "address-dependent"
+	} else {
+		res.behavior = "address and port-dependent"
+	}
+	return res, nil
+}
+
+// filtering performs filtering tests (RFC 5780, section 4.4). It uses
+// new local port because mapping tests have already sent packets to
+// other address of server.
+func (ch *checker) filtering() (string, error) {
+	c, err := ch.newClient(false)
+	if err != nil {
+		return "", err
+	}
+	defer c.close()
+	if _, err := c.request(ch.server, 0); err != nil {
+		return "", err
+	}
+	_, err = c.request(ch.server, changeIP|changePort)
+	if err == nil {
+		return "endpoint-independent", nil
+	} else if err != errTimeout {
+		return "", err
+	}
+	_, err = c.request(ch.server, changePort)
+	if err == nil {
+		return "address-dependent", nil
+	} else if err != errTimeout {
+		return "", err
+	}
+	return "address and port-dependent", nil
+}
+
+// hairpinning sends request from one local port to mapped address of
+// another (RFC 5780, section 4.5).
+func (ch *checker) hairpinning() (bool, error) {
+	c1, err := ch.newClient(false)
+	if err != nil {
+		return false, err
+	}
+	defer c1.close()
+	r, err := c1.request(ch.server, 0)
+	if err != nil {
+		return false, err
+	}
+	c2, err := ch.newClient(false)
+	if err != nil {
+		return false, err
+	}
+	defer c2.close()
+	for i := 0; i <= ch.retries; i++ {
+		req := newRequest(0)
+		if _, err := c2.conn.WriteToUDP(req.marshal(), r.mapped); err != nil {
+			return false, err
+		}
+		if c1.waitRequest(req.id) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isLocal returns true if address belongs to one of local interfaces.
+func isLocal(a *net.UDPAddr) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && n.IP.Equal(a.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func check(ch *checker) error {
+	fmt.Println("STUN server:", ch.server)
+	m, err := ch.mapping()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Other address:", m.other)
+	fmt.Println("Local address:", m.local)
+	fmt.Println("Mapped address:", m.mapped)
+	fmt.Println("Mapping behavior:", m.behavior)
+	if m.behavior == "no NAT" {
+		return nil
+	}
+	fmt.Println("Port preservation:", yesNo(m.mapped.Port == m.local.Port))
+	filtering, err := ch.filtering()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Filtering behavior:", filtering)
+	hairpinning, err := ch.hairpinning()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Hairpinning:", yesNo(hairpinning))
+
+	fmt.Println("RFC 4787 REQ-1 (endpoint-independent mapping):", yesNo(m.behavior == "endpoint-independent"))
+	fmt.Println("RFC 4787 REQ-8 (endpoint-independent or address-dependent filtering):",
+		yesNo(filtering != "address and port-dependent"))
+	fmt.Println("RFC 4787 REQ-9 (hairpinning):", yesNo(hairpinning))
+	return nil
+}
+
+func main() {
+	servers := flag.String("server", "stun.stunprotocol.org:3478", "Comma separated list of STUN servers, first server which supports RFC 5780 is used.")
+	local := flag.String("local", "0.0.0.0:0", "Local address of mapping test.")
+	timeout := flag.Duration("timeout", 500*time.Millisecond, "Time to wait for every response.")
+	retries := flag.Int("retries", 2, "Number of request retransmissions.")
+	flag.Parse()
+
+	localAddr, err := net.ResolveUDPAddr("udp4", *local)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Bad local address:", err)
+		os.Exit(1)
+	}
+	for _, s := range strings.Split(*servers, ",") {
+		server, err := net.ResolveUDPAddr("udp4", strings.TrimSpace(s))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Bad STUN server:", err)
+			continue
+		}
+		err = check(&checker{
+			server:  server,
+			local:   localAddr,
+			timeout: *timeout,
+			retries: *retries,
+		})
+		if err == nil {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "NAT check with", server, "failed:", err)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+const (
+	stunHeaderLen   = 20
+	stunMagicCookie = 0x2112A442
+
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunBindingError    = 0x0111
+
+	attrMappedAddress    = 0x0001
+	attrChangeRequest    = 0x0003
+	attrChangedAddress   = 0x0005
+	attrErrorCode        = 0x0009
+	attrXorMappedAddress = 0x0020
+	attrSoftware         = 0x8022
+	attrResponseOrigin   = 0x802b
+	attrOtherAddress     = 0x802c
+
+	changeIP   = 0x4
+	changePort = 0x2
+
+	addressFamilyIPv4 = 0x01
+)
+
+const software = "nff-go natcheck"
+
+type transactionID [12]byte
+
+// stunRequest is a Binding request.
+type stunRequest struct {
+	id transactionID
+	// Flags of CHANGE-REQUEST attribute
+	change uint32
+}
+
+// stunResponse contains attributes of Binding response which are
+// used by tests.
+type stunResponse struct {
+	id     transactionID
+	mapped *net.UDPAddr
+	other  *net.UDPAddr
+	origin *net.UDPAddr
+}
+
+func newRequest(change uint32) *stunRequest {
+	r := &stunRequest{change: change}
+	rand.Read(r.id[:])
+	return r
+}
+
+func (r *stunRequest) marshal() []byte {
+	b := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(b[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(b[4:], stunMagicCookie)
+	copy(b[8:], r.id[:])
+	if r.change != 0 {
+		var v [4]byte
+		binary.BigEndian.PutUint32(v[:], r.change)
+		b = appendAttribute(b, attrChangeRequest, v[:])
+	}
+	b = appendAttribute(b, attrSoftware, []byte(software))
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)-stunHeaderLen))
+	return b
+}
+
+func appendAttribute(b []byte, t uint16, v []byte) []byte {
+	var h [4]byte
+	binary.BigEndian.PutUint16(h[0:], t)
+	binary.BigEndian.PutUint16(h[2:], uint16(len(v)))
+	b = append(b, h[:]...)
+	b = append(b, v...)
+	// Attributes are padded to multiple of 4 bytes
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// isRequest returns transaction ID if b is a STUN Binding request.
+// It is used to detect hairpinned requests.
+func isRequest(b []byte) (transactionID, bool) {
+	var id transactionID
+	if len(b) < stunHeaderLen || binary.BigEndian.Uint16(b[0:]) != stunBindingRequest ||
+		binary.BigEndian.Uint32(b[4:]) != stunMagicCookie {
+		return id, false
+	}
+	copy(id[:], b[8:stunHeaderLen])
+	return id, true
+}
+
+func parseResponse(b []byte) (*stunResponse, error) {
+	if len(b) < stunHeaderLen || binary.BigEndian.Uint32(b[4:]) != stunMagicCookie {
+		return nil, errors.New("not a STUN message")
+	}
+	msgType := binary.BigEndian.Uint16(b[0:])
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if stunHeaderLen+length > len(b) {
+		return nil, errors.New("truncated STUN message")
+	}
+	r := &stunResponse{}
+	copy(r.id[:], b[8:stunHeaderLen])
+	var changed *net.UDPAddr
+	for attrs := b[stunHeaderLen : stunHeaderLen+length]; len(attrs) >= 4; {
+		t := binary.BigEndian.Uint16(attrs[0:])
+		l := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+l > len(attrs) {
+			return nil, errors.New("truncated STUN attribute")
+		}
+		v := attrs[4 : 4+l]
+		switch t {
+		case attrMappedAddress:
+			if r.mapped == nil {
+				r.mapped = parseAddress(v, false)
+			}
+		case attrXorMappedAddress:
+			r.mapped = parseAddress(v, true)
+		case attrOtherAddress:
+			r.other = parseAddress(v, false)
+		case attrChangedAddress:
+			changed = parseAddress(v, false)
+		case attrResponseOrigin:
+			r.origin = parseAddress(v, false)
+		case attrErrorCode:
+			if msgType == stunBindingError && l >= 4 {
+				return nil, errors.New("STUN error " + string(v[4:]))
+			}
+		}
+		l = (l + 3) &^ 3
+		if 4+l > len(attrs) {
+			break
+		}
+		attrs = attrs[4+l:]
+	}
+	if msgType != stunBindingResponse {
+		return nil, errors.New("not a STUN Binding response")
+	}
+	if r.other == nil {
+		// RFC 3489 servers send CHANGED-ADDRESS instead
+		r.other = changed
+	}
+	if r.mapped == nil {
+		return nil, errors.New("no mapped address in STUN response")
+	}
+	return r, nil
+}
+
+// parseAddress parses IPv4 address attribute. Other address families
+// are ignored.
+func parseAddress(v []byte, xor bool) *net.UDPAddr {
+	if len(v) < 8 || v[1] != addressFamilyIPv4 {
+		return nil
+	}
+	port := binary.BigEndian.Uint16(v[2:])
+	ip := binary.BigEndian.Uint32(v[4:])
+	if xor {
+		port ^= stunMagicCookie >> 16
+		ip ^= stunMagicCookie
+	}
+	a := &net.UDPAddr{IP: make(net.IP, net.IPv4len), Port: int(port)}
+	binary.BigEndian.PutUint32(a.IP, ip)
+	return a
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// natsim simulates occupancy of connection tracking table and public
+// port pool of NAT for given traffic profile. It prints table and
+// pool usage over time and peak values, so public pools can be sized
+// before deployment.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/conntrack/capacity"
+)
+
+// parseMix parses list of protocol=share pairs.
+func parseMix(s string) ([]capacity.ProtocolShare, error) {
+	var table conntrack.Table
+	var mix []capacity.ProtocolShare
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(item, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("wrong protocol share %q", item)
+		}
+		proto, ok := table.LookupProtocol(parts[0])
+		if !ok {
+			n, err := strconv.ParseUint(parts[0], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("unknown protocol %q", parts[0])
+			}
+			proto = uint8(n)
+		}
+		share, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("wrong share of protocol %s: %v", parts[0], err)
+		}
+		mix = append(mix, capacity.ProtocolShare{Proto: proto, Share: share})
+	}
+	return mix, nil
+}
+
+func parsePorts(s string) (uint16, uint16, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("port range %q should be min-max", s)
+	}
+	min, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint16(min), uint16(max), nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "natsim:", err)
+	os.Exit(1)
+}
+
+func main() {
+	timeouts := conntrack.DefaultTimeouts()
+	rate := flag.Float64("rate", 1000, "new sessions per second")
+	lifetime := flag.Duration("lifetime", 30*time.Second, "average time between the first and the last packet of session")
+	mix := flag.String("mix", "tcp=70,udp=25,icmp=5", "protocol mix as comma separated protocol=share pairs")
+	subscribers := flag.Int("subscribers", 10000, "number of private hosts")
+	closed := flag.Float64("tcp-closed", 0.9, "share of TCP sessions closed with FIN or RST")
+	addresses := flag.Int("addresses", 1, "number of public addresses")
+	ports := flag.String("ports", "1024-65535", "range of public ports")
+	policy := flag.String("policy", "session", "port allocation policy: session or block")
+	block := flag.Int("block", 512, "number of ports in block of block policy")
+	flag.DurationVar(&timeouts.TCPEstablished, "tcp-established", timeouts.TCPEstablished, "timeout of established TCP sessions")
+	flag.DurationVar(&timeouts.TCPTimeWait, "tcp-time-wait", timeouts.TCPTimeWait, "timeout of closed TCP sessions")
+	flag.DurationVar(&timeouts.UDPStream, "udp", timeouts.UDPStream, "timeout of UDP sessions")
+	flag.DurationVar(&timeouts.ICMP, "icmp", timeouts.ICMP, "timeout of ICMP sessions")
+	flag.DurationVar(&timeouts.Generic, "generic", timeouts.Generic, "timeout of sessions of other protocols")
+	duration := flag.Duration("duration", time.Hour, "simulated time")
+	step := flag.Duration("step", time.Second, "simulation step")
+	report := flag.Duration("report", time.Minute, "interval between printed samples, zero prints only summary")
+	seed := flag.Int64("seed", 1, "seed of random generator")
+	flag.Parse()
+
+	c := capacity.Config{
+		Profile: capacity.Profile{
+			SessionsPerSecond: *rate,
+			AverageLifetime:   *lifetime,
+			Subscribers:       *subscribers,
+			TCPClosedShare:    *closed,
+		},
+		Pool: capacity.Pool{
+			Addresses: *addresses,
+			BlockSize: *block,
+		},
+		Timeouts: timeouts,
+		Duration: *duration,
+		Step:     *step,
+		Seed:     *seed,
+	}
+	var err error
+	if c.Profile.Mix, err = parseMix(*mix); err != nil {
+		fail(err)
+	}
+	if c.Pool.PortMin, c.Pool.PortMax, err = parsePorts(*ports); err != nil {
+		fail(err)
+	}
+	switch *policy {
+	case "session":
+		c.Pool.Policy = capacity.PortPerSession
+	case "block":
+		c.Pool.Policy = capacity.PortBlock
+	default:
+		fail(fmt.Errorf("unknown policy %q", *policy))
+	}
+
+	res, err := capacity.Simulate(c)
+	if err != nil {
+		fail(err)
+	}
+	if *report > 0 {
+		fmt.Printf("%12s %12s %12s %12s %12s\n", "time", "sessions", "ports", "max/address", "failures")
+		var failures uint64
+		var next time.Duration
+		for _, s := range res.Samples {
+			failures += s.Failures
+			if s.Time < next {
+				continue
+			}
+			next = s.Time + *report
+			fmt.Printf("%12s %12d %12d %12d %12d\n", s.Time, s.Sessions, s.Ports, s.MaxAddressPorts, failures)
+			failures = 0
+		}
+	}
+	fmt.Printf("Pool size: %d ports\n", res.PoolPorts)
+	fmt.Printf("Steady state sessions: %.0f\n", res.SteadySessions)
+	fmt.Printf("Peak sessions: %d\n", res.PeakSessions)
+	fmt.Printf("Peak ports: %d (%.1f%% of pool)\n", res.PeakPorts, 100*float64(res.PeakPorts)/float64(res.PoolPorts))
+	fmt.Printf("Peak ports per address: %d\n", res.PeakAddressPorts)
+	fmt.Printf("Allocation failures: %d of %d sessions\n", res.Failures, res.Sessions)
+	if res.Failures != 0 {
+		os.Exit(2)
+	}
+}
@@ -8,6 +8,7 @@ package common
 
 import (
 	"strconv"
+	"strings"
 )
 
 // GetDefaultCPUs returns default core list {0, 1, ..., NumCPU}
@@ -103,3 +104,57 @@ func dropInvalidCPUs(nums []int, maxcpu int) []int {
 type RXTXStats struct {
 	PacketsProcessed, PacketsDropped, BytesProcessed uint64
 }
+
+// ByteAccounting defines which bytes of Ethernet frames are counted by
+// byte counters.
+type ByteAccounting uint8
+
+const (
+	// AccountL2 counts bytes from destination MAC address to the end
+	// of payload as they are stored in packet buffer.
+	AccountL2 ByteAccounting = iota
+	// AccountL1 additionally counts frame check sequence, padding to
+	// minimum frame size, preamble with start frame delimiter and
+	// interframe gap, so counters match line rate counters of carrier
+	// equipment.
+	AccountL1
+)
+
+// Ethernet overhead which isn't stored in packet buffer
+const (
+	etherFCSLen      = 4
+	etherMinFrameLen = 64
+	// Preamble, start frame delimiter and interframe gap
+	etherL1Overhead = 8 + 12
+)
+
+// FrameBytes returns number of bytes counted for frame of given
+// length without frame check sequence.
+func (a ByteAccounting) FrameBytes(length uint) uint64 {
+	if a != AccountL1 {
+		return uint64(length)
+	}
+	length += etherFCSLen
+	if length < etherMinFrameLen {
+		length = etherMinFrameLen
+	}
+	return uint64(length + etherL1Overhead)
+}
+
+func (a ByteAccounting) String() string {
+	if a == AccountL1 {
+		return "l1"
+	}
+	return "l2"
+}
+
+// ParseByteAccounting converts "l2" or "l1" to ByteAccounting.
+func ParseByteAccounting(s string) (ByteAccounting, error) {
+	switch strings.ToLower(s) {
+	case "", "l2":
+		return AccountL2, nil
+	case "l1":
+		return AccountL1, nil
+	}
+	return AccountL2, WrapWithNFError(nil, "Unknown byte accounting "+s, BadArgument)
+}
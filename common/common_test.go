@@ -120,3 +120,22 @@ func TestErrorCause(t *testing.T) {
 		}
 	}
 }
+
+func TestFrameBytes(t *testing.T) {
+	tests := []struct {
+		a        ByteAccounting
+		length   uint
+		expected uint64
+	}{
+		{AccountL2, 60, 60},
+		{AccountL2, 1514, 1514},
+		{AccountL1, 42, 84},
+		{AccountL1, 60, 84},
+		{AccountL1, 1514, 1538},
+	}
+	for _, tt := range tests {
+		if got := tt.a.FrameBytes(tt.length); got != tt.expected {
+			t.Errorf("%s bytes of %d byte frame: got %d, want %d", tt.a, tt.length, got, tt.expected)
+		}
+	}
+}
@@ -48,6 +48,10 @@ const (
 	FailToCreateKNI
 	FailToReleaseKNI
 	BadSocket
+	TableIsFull
+	BindDeviceErr
+	SelfCheckFailed
+	RateLimitExceeded
 )
 
 // NFError is error type returned by nff-go functions
@@ -0,0 +1,186 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ratelimit implements rate limiting primitives: token
+// bucket, leaky bucket and sliding window counter. Current time is
+// passed to every method, so one time.Now call can be shared by a
+// whole burst of packets. Limiters are not safe for concurrent use,
+// they should be owned by one flow function clone or protected by
+// user.
+package ratelimit
+
+import (
+	"time"
+)
+
+// TokenBucket allows events with average rate per second and bursts
+// up to burst events. Bucket is filled with tokens at given rate,
+// every event takes one token.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates full token bucket.
+func NewTokenBucket(rate float64, burst uint, now time.Time) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// Allow takes one token from bucket. Returns false if bucket is empty.
+func (b *TokenBucket) Allow(now time.Time) bool {
+	return b.AllowN(now, 1)
+}
+
+// AllowN takes n tokens from bucket. Returns false and takes nothing
+// if bucket has less than n tokens.
+func (b *TokenBucket) AllowN(now time.Time, n uint) bool {
+	b.refill(now)
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// Tokens returns number of tokens in bucket.
+func (b *TokenBucket) Tokens(now time.Time) float64 {
+	b.refill(now)
+	return b.tokens
+}
+
+// Full returns true if bucket is full, so it is the same as new
+// bucket and can be removed from tables of limiters to save memory.
+func (b *TokenBucket) Full(now time.Time) bool {
+	return b.Tokens(now) >= b.burst
+}
+
+// LeakyBucket is a bucket of given capacity which leaks at rate
+// units per second. Events add their size to bucket and are allowed
+// only if bucket doesn't overflow, so it can be used as a policer of
+// bytes or packets.
+type LeakyBucket struct {
+	rate     float64
+	capacity float64
+	level    float64
+	last     time.Time
+}
+
+// NewLeakyBucket creates empty leaky bucket.
+func NewLeakyBucket(rate float64, capacity uint, now time.Time) *LeakyBucket {
+	return &LeakyBucket{
+		rate:     rate,
+		capacity: float64(capacity),
+		last:     now,
+	}
+}
+
+func (b *LeakyBucket) leak(now time.Time) {
+	if now.After(b.last) {
+		b.level -= now.Sub(b.last).Seconds() * b.rate
+		if b.level < 0 {
+			b.level = 0
+		}
+		b.last = now
+	}
+}
+
+// Allow adds one unit to bucket. Returns false if bucket is full.
+func (b *LeakyBucket) Allow(now time.Time) bool {
+	return b.AllowN(now, 1)
+}
+
+// AllowN adds n units to bucket. Returns false and adds nothing if
+// bucket would overflow.
+func (b *LeakyBucket) AllowN(now time.Time, n uint) bool {
+	b.leak(now)
+	if b.level+float64(n) > b.capacity {
+		return false
+	}
+	b.level += float64(n)
+	return true
+}
+
+// Level returns current level of bucket.
+func (b *LeakyBucket) Level(now time.Time) float64 {
+	b.leak(now)
+	return b.level
+}
+
+// SlidingWindow allows up to limit events during any window. It
+// counts events in current and previous fixed windows and weights
+// previous window by its part which overlaps with sliding window, so
+// it doesn't allow double limit on window borders and needs constant
+// memory.
+type SlidingWindow struct {
+	limit    float64
+	window   time.Duration
+	start    time.Time
+	current  float64
+	previous float64
+}
+
+// NewSlidingWindow creates sliding window counter without events.
+func NewSlidingWindow(limit uint, window time.Duration, now time.Time) *SlidingWindow {
+	return &SlidingWindow{
+		limit:  float64(limit),
+		window: window,
+		start:  now,
+	}
+}
+
+func (w *SlidingWindow) advance(now time.Time) {
+	passed := now.Sub(w.start)
+	if passed < w.window {
+		return
+	}
+	if passed < 2*w.window {
+		w.previous = w.current
+	} else {
+		w.previous = 0
+	}
+	w.current = 0
+	w.start = w.start.Add(passed / w.window * w.window)
+}
+
+// Count returns estimated number of events during last window.
+func (w *SlidingWindow) Count(now time.Time) float64 {
+	w.advance(now)
+	passed := now.Sub(w.start)
+	if passed < 0 {
+		passed = 0
+	}
+	return w.previous*float64(w.window-passed)/float64(w.window) + w.current
+}
+
+// Allow counts one event. Returns false if limit is reached.
+func (w *SlidingWindow) Allow(now time.Time) bool {
+	return w.AllowN(now, 1)
+}
+
+// AllowN counts n events. Returns false and counts nothing if limit
+// would be exceeded.
+func (w *SlidingWindow) AllowN(now time.Time, n uint) bool {
+	if w.Count(now)+float64(n) > w.limit {
+		return false
+	}
+	w.current += float64(n)
+	return true
+}
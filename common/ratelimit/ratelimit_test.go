@@ -0,0 +1,97 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var start = time.Unix(1000, 0)
+
+func at(ms int) time.Time {
+	return start.Add(time.Duration(ms) * time.Millisecond)
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(10, 5, start)
+	for i := 0; i < 5; i++ {
+		if !b.Allow(start) {
+			t.Fatalf("event %d of burst is not allowed", i)
+		}
+	}
+	if b.Allow(start) {
+		t.Error("event after burst is allowed")
+	}
+	// One token per 100 ms
+	if b.Allow(at(50)) {
+		t.Error("event is allowed before token refill")
+	}
+	if !b.Allow(at(100)) {
+		t.Error("event is not allowed after token refill")
+	}
+	if b.AllowN(at(300), 3) {
+		t.Error("3 events are allowed with 2 tokens")
+	}
+	if !b.AllowN(at(300), 2) {
+		t.Error("2 events are not allowed with 2 tokens")
+	}
+	if b.Full(at(700)) || !b.Full(at(800)) {
+		t.Error("bucket is not refilled in 500 ms")
+	}
+	if tokens := b.Tokens(at(10000)); tokens != 5 {
+		t.Errorf("bucket has %f tokens, expected burst size", tokens)
+	}
+}
+
+func TestLeakyBucket(t *testing.T) {
+	// 1000 bytes per second, 1500 bytes bucket
+	b := NewLeakyBucket(1000, 1500, start)
+	if !b.AllowN(start, 1000) {
+		t.Fatal("first packet is not allowed")
+	}
+	if b.AllowN(start, 1000) {
+		t.Error("overflowing packet is allowed")
+	}
+	if !b.AllowN(start, 500) {
+		t.Error("packet which fills bucket is not allowed")
+	}
+	if !b.AllowN(at(500), 500) {
+		t.Error("packet is not allowed after leak")
+	}
+	if level := b.Level(at(1500)); level != 500 {
+		t.Errorf("level is %f, expected 500", level)
+	}
+	if !b.Allow(at(3000)) || b.Level(at(3000)) != 1 {
+		t.Error("bucket is not empty after long time")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	w := NewSlidingWindow(10, time.Second, start)
+	for i := 0; i < 10; i++ {
+		if !w.Allow(at(900)) {
+			t.Fatalf("event %d is not allowed", i)
+		}
+	}
+	if w.Allow(at(999)) {
+		t.Error("event over limit is allowed")
+	}
+	// Fixed window would allow 10 more events right after border
+	if w.Allow(at(1000)) {
+		t.Error("event is allowed on window border")
+	}
+	// Previous window weight is 0.5
+	if count := w.Count(at(1500)); math.Abs(count-5) > 1e-9 {
+		t.Errorf("count is %f, expected 5", count)
+	}
+	if !w.AllowN(at(1500), 5) || w.Allow(at(1500)) {
+		t.Error("wrong limit in the middle of window")
+	}
+	if count := w.Count(at(3100)); count != 0 {
+		t.Errorf("count is %f after two windows, expected 0", count)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// StatType is a kind of value of statistics field.
+type StatType string
+
+const (
+	// StatCounter is a monotonically increasing number.
+	StatCounter StatType = "counter"
+	// StatGauge is a number which can go up and down.
+	StatGauge StatType = "gauge"
+	// StatLabel identifies object which values belong to, for
+	// example name of node or port.
+	StatLabel StatType = "label"
+	// StatObject is a nested structure described by help text.
+	StatObject StatType = "object"
+)
+
+// StatField describes one field of statistics in JSON output.
+type StatField struct {
+	// Name of field in JSON output
+	Name string   `json:"name"`
+	Type StatType `json:"type"`
+	// Unit of value, for example "packets", "bytes" or "ns"
+	Unit string `json:"unit,omitempty"`
+	Help string `json:"help"`
+}
+
+// StatsGroup describes JSON objects returned by one telemetry request.
+// Version of group is increased when fields are renamed, removed or
+// change their meaning, request path of new version should also
+// change. New fields are added without increasing version, so
+// consumers should ignore fields which they don't know.
+type StatsGroup struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	// Path of telemetry request
+	Path   string      `json:"path"`
+	Help   string      `json:"help"`
+	Fields []StatField `json:"fields"`
+}
+
+// StatsSchema describes all statistics which are exposed by
+// application.
+type StatsSchema struct {
+	Groups []StatsGroup `json:"groups"`
+}
+
+// DescribeStats returns descriptions of JSON fields of struct v in
+// order of their declaration. Fields of embedded structs are included
+// as JSON encoder does. Descriptions are taken from fields which keys
+// are JSON names of fields. Error is returned if some field isn't
+// described or some description doesn't match any field, so tests can
+// catch counters which are added or renamed without updating schema.
+func DescribeStats(v interface{}, fields map[string]StatField) ([]StatField, error) {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, WrapWithNFError(nil, "Statistics should be struct, not "+t.String(), BadArgument)
+	}
+	var ret []StatField
+	var missing []string
+	seen := make(map[string]bool)
+	for _, name := range jsonFields(t) {
+		seen[name] = true
+		f, ok := fields[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		f.Name = name
+		ret = append(ret, f)
+	}
+	if len(missing) != 0 {
+		return nil, WrapWithNFError(nil, "Statistics fields "+strings.Join(missing, ", ")+" of "+t.String()+" aren't described", BadArgument)
+	}
+	var unknown []string
+	for name := range fields {
+		if !seen[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return nil, WrapWithNFError(nil, "Descriptions "+strings.Join(unknown, ", ")+" don't match fields of "+t.String(), BadArgument)
+	}
+	return ret, nil
+}
+
+// MustDescribeStats is like DescribeStats but panics on error. It is
+// used to describe statistics at initialization.
+func MustDescribeStats(v interface{}, fields map[string]StatField) []StatField {
+	ret, err := DescribeStats(v, fields)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// jsonFields returns JSON names of fields of struct t.
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				names = append(names, jsonFields(ft)...)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			// Unexported field
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
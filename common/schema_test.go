@@ -0,0 +1,55 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testInner struct {
+	Packets uint64 `json:"packets"`
+	hidden  uint64
+}
+
+type testStats struct {
+	Name string
+	testInner
+	Bytes   uint64 `json:"bytes,omitempty"`
+	Ignored uint64 `json:"-"`
+}
+
+func TestDescribeStats(t *testing.T) {
+	descr := map[string]StatField{
+		"Name":    {Type: StatLabel, Help: "name"},
+		"packets": {Type: StatCounter, Unit: "packets", Help: "packets"},
+		"bytes":   {Type: StatCounter, Unit: "bytes", Help: "bytes"},
+	}
+	got, err := DescribeStats(&testStats{}, descr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StatField{
+		{Name: "Name", Type: StatLabel, Help: "name"},
+		{Name: "packets", Type: StatCounter, Unit: "packets", Help: "packets"},
+		{Name: "bytes", Type: StatCounter, Unit: "bytes", Help: "bytes"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DescribeStats() = %v, want %v", got, want)
+	}
+
+	delete(descr, "bytes")
+	if _, err := DescribeStats(testStats{}, descr); err == nil {
+		t.Error("Field without description is accepted")
+	}
+	descr["bytes"] = StatField{Type: StatCounter}
+	descr["drops"] = StatField{Type: StatCounter}
+	if _, err := DescribeStats(testStats{}, descr); err == nil {
+		t.Error("Description without field is accepted")
+	}
+	if _, err := DescribeStats(1, nil); err == nil {
+		t.Error("Not struct is accepted")
+	}
+}
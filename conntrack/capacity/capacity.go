@@ -0,0 +1,401 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package capacity simulates occupancy of connection tracking table
+// and NAT public port pool for given traffic profile, so pool of
+// public addresses can be sized before deployment. Sessions arrive
+// as Poisson process, their active lifetime is distributed
+// exponentially and every session holds its table entry and public
+// port until it expires by timeout of conntrack.Timeouts after the
+// last packet.
+package capacity
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+// Maximum number of simulated steps
+const maxSteps = 1 << 20
+
+// AllocationPolicy defines how public ports are allocated to sessions.
+type AllocationPolicy uint8
+
+const (
+	// PortPerSession allocates one port of public address paired with
+	// subscriber to every session.
+	PortPerSession AllocationPolicy = iota
+	// PortBlock allocates blocks of ports of public address paired
+	// with subscriber. Subscriber gets a new block when all its blocks
+	// are used and returns block as soon as its sessions fit into
+	// fewer blocks.
+	PortBlock
+)
+
+// ProtocolShare is a share of sessions of one protocol.
+type ProtocolShare struct {
+	Proto uint8
+	// Relative weight of protocol, shares are normalized to their sum
+	Share float64
+}
+
+// Profile describes traffic.
+type Profile struct {
+	// Rate of new sessions
+	SessionsPerSecond float64
+	// Mean time between the first and the last packet of session
+	AverageLifetime time.Duration
+	// Protocol mix, all sessions are TCP if it is empty
+	Mix []ProtocolShare
+	// Number of private hosts which open sessions, sessions are
+	// distributed between them uniformly
+	Subscribers int
+	// Share of TCP sessions which are closed with FIN or RST. Other
+	// TCP sessions become idle and expire by established timeout.
+	TCPClosedShare float64
+}
+
+// Pool describes public addresses and allocation policy.
+type Pool struct {
+	Addresses int
+	// Range of public ports of every address
+	PortMin, PortMax uint16
+	Policy           AllocationPolicy
+	// Number of ports in one block of PortBlock policy
+	BlockSize int
+}
+
+// Config is a simulation configuration.
+type Config struct {
+	Profile  Profile
+	Pool     Pool
+	Timeouts conntrack.Timeouts
+	// Simulated time and interval between samples
+	Duration time.Duration
+	Step     time.Duration
+	// Seed of random generator, simulation with the same seed is
+	// repeatable
+	Seed int64
+}
+
+// Sample is a state of table and pool at the end of one step.
+type Sample struct {
+	Time time.Duration
+	// Number of table entries
+	Sessions int
+	// Number of public ports in use, for PortBlock policy all ports of
+	// allocated blocks are counted
+	Ports int
+	// Number of ports in use on the busiest public address
+	MaxAddressPorts int
+	// Number of sessions which didn't get port during step
+	Failures uint64
+}
+
+// Result is a result of simulation.
+type Result struct {
+	Samples []Sample
+	// Number of ports in pool
+	PoolPorts int
+	// Number of table entries in steady state according to Little's
+	// law, simulation converges to it if Duration is much longer than
+	// average hold time of entry
+	SteadySessions float64
+	PeakSessions   int
+	PeakPorts      int
+	// Peak number of ports in use on one address
+	PeakAddressPorts int
+	// Total number of simulated sessions and allocation failures
+	Sessions uint64
+	Failures uint64
+}
+
+func (p *Pool) portsPerAddress() int {
+	return int(p.PortMax) - int(p.PortMin) + 1
+}
+
+func (c *Config) check() error {
+	if c.Profile.SessionsPerSecond <= 0 || c.Profile.AverageLifetime < 0 {
+		return common.WrapWithNFError(nil, "Session rate should be positive", common.BadArgument)
+	}
+	if c.Profile.Subscribers <= 0 {
+		return common.WrapWithNFError(nil, "Number of subscribers should be positive", common.BadArgument)
+	}
+	if c.Profile.TCPClosedShare < 0 || c.Profile.TCPClosedShare > 1 {
+		return common.WrapWithNFError(nil, "Share of closed TCP sessions should be from 0 to 1", common.BadArgument)
+	}
+	var total float64
+	for _, s := range c.Profile.Mix {
+		if s.Share < 0 {
+			return common.WrapWithNFError(nil, fmt.Sprintf("Share of protocol %d is negative", s.Proto), common.BadArgument)
+		}
+		total += s.Share
+	}
+	if len(c.Profile.Mix) != 0 && total == 0 {
+		return common.WrapWithNFError(nil, "Protocol mix has zero shares", common.BadArgument)
+	}
+	if c.Pool.Addresses <= 0 || c.Pool.PortMin > c.Pool.PortMax {
+		return common.WrapWithNFError(nil, "Pool should have addresses and valid port range", common.BadArgument)
+	}
+	switch c.Pool.Policy {
+	case PortPerSession:
+	case PortBlock:
+		if c.Pool.BlockSize <= 0 || c.Pool.BlockSize > c.Pool.portsPerAddress() {
+			return common.WrapWithNFError(nil, "Block size should be from 1 to size of port range", common.BadArgument)
+		}
+	default:
+		return common.WrapWithNFError(nil, "Unknown allocation policy", common.BadArgument)
+	}
+	if c.Step <= 0 || c.Duration < c.Step {
+		return common.WrapWithNFError(nil, "Step should be positive and not longer than duration", common.BadArgument)
+	}
+	if c.Duration/c.Step > maxSteps {
+		return common.WrapWithNFError(nil, fmt.Sprintf("Simulation can't have more than %d steps", maxSteps), common.BadArgument)
+	}
+	return nil
+}
+
+// mix returns protocol mix with cumulative shares normalized to 1.
+func (p *Profile) mix() []ProtocolShare {
+	if len(p.Mix) == 0 {
+		return []ProtocolShare{{Proto: types.TCPNumber, Share: 1}}
+	}
+	var total float64
+	for _, s := range p.Mix {
+		total += s.Share
+	}
+	mix := make([]ProtocolShare, len(p.Mix))
+	var sum float64
+	for i, s := range p.Mix {
+		sum += s.Share
+		mix[i] = ProtocolShare{Proto: s.Proto, Share: sum / total}
+	}
+	return mix
+}
+
+// idleTimeout returns time which entry of session stays in table
+// after its last packet.
+func idleTimeout(t *conntrack.Timeouts, proto uint8, closed bool) time.Duration {
+	switch proto {
+	case types.TCPNumber:
+		if closed {
+			return t.TCPTimeWait
+		}
+		return t.TCPEstablished
+	case types.UDPNumber:
+		return t.UDPStream
+	case types.UDPLiteNumber:
+		return t.UDPLiteStream
+	case types.DCCPNumber:
+		return t.DCCPStream
+	case types.ICMPNumber:
+		return t.ICMP
+	default:
+		return t.Generic
+	}
+}
+
+// averageHoldTime returns mean time which session holds table entry.
+func (c *Config) averageHoldTime() time.Duration {
+	hold := float64(c.Profile.AverageLifetime)
+	var prev float64
+	for _, s := range c.Profile.mix() {
+		share := s.Share - prev
+		prev = s.Share
+		if s.Proto == types.TCPNumber {
+			closed := c.Profile.TCPClosedShare
+			hold += share * (closed*float64(c.Timeouts.TCPTimeWait) + (1-closed)*float64(c.Timeouts.TCPEstablished))
+		} else {
+			hold += share * float64(idleTimeout(&c.Timeouts, s.Proto, false))
+		}
+	}
+	return time.Duration(hold)
+}
+
+// poisson returns random number of events with given mean. Normal
+// approximation is used for large means.
+func poisson(rnd *rand.Rand, mean float64) int {
+	if mean > 30 {
+		n := math.Floor(mean + math.Sqrt(mean)*rnd.NormFloat64() + 0.5)
+		if n < 0 {
+			return 0
+		}
+		return int(n)
+	}
+	limit := math.Exp(-mean)
+	n := 0
+	for p := rnd.Float64(); p > limit; p *= rnd.Float64() {
+		n++
+	}
+	return n
+}
+
+// simulator keeps state of pool. Sessions are counted per subscriber
+// for PortBlock policy and per address for PortPerSession policy.
+type simulator struct {
+	pool     *Pool
+	capacity int
+	// Ports or blocks in use on every address
+	used []int
+	// Sessions and blocks of every subscriber
+	active []int
+	blocks []int
+	// Releases of every future step: per address or per subscriber
+	releases []map[int]int
+}
+
+func newSimulator(c *Config, steps int) *simulator {
+	s := &simulator{
+		pool:     &c.Pool,
+		capacity: c.Pool.portsPerAddress(),
+		used:     make([]int, c.Pool.Addresses),
+		releases: make([]map[int]int, steps),
+	}
+	if c.Pool.Policy == PortBlock {
+		s.capacity /= c.Pool.BlockSize
+		s.active = make([]int, c.Profile.Subscribers)
+		s.blocks = make([]int, c.Profile.Subscribers)
+	}
+	return s
+}
+
+// allocate takes port for new session of subscriber. Returns key of
+// release or false if pool is exhausted.
+func (s *simulator) allocate(subscriber int) (int, bool) {
+	address := subscriber % s.pool.Addresses
+	if s.pool.Policy == PortPerSession {
+		if s.used[address] == s.capacity {
+			return 0, false
+		}
+		s.used[address]++
+		return address, true
+	}
+	if s.active[subscriber] == s.blocks[subscriber]*s.pool.BlockSize {
+		if s.used[address] == s.capacity {
+			return 0, false
+		}
+		s.used[address]++
+		s.blocks[subscriber]++
+	}
+	s.active[subscriber]++
+	return subscriber, true
+}
+
+// release frees ports of count sessions with given key.
+func (s *simulator) release(key, count int) {
+	if s.pool.Policy == PortPerSession {
+		s.used[key] -= count
+		return
+	}
+	s.active[key] -= count
+	need := (s.active[key] + s.pool.BlockSize - 1) / s.pool.BlockSize
+	s.used[key%s.pool.Addresses] -= s.blocks[key] - need
+	s.blocks[key] = need
+}
+
+func (s *simulator) schedule(step, key int) {
+	if step >= len(s.releases) {
+		// Session outlives simulation
+		return
+	}
+	if s.releases[step] == nil {
+		s.releases[step] = make(map[int]int)
+	}
+	s.releases[step][key]++
+}
+
+// ports returns number of ports in use in pool and on the busiest
+// address.
+func (s *simulator) ports() (total, max int) {
+	for _, u := range s.used {
+		total += u
+		if u > max {
+			max = u
+		}
+	}
+	if s.pool.Policy == PortBlock {
+		total *= s.pool.BlockSize
+		max *= s.pool.BlockSize
+	}
+	return total, max
+}
+
+// Estimate returns steady state number of table entries according to
+// Little's law without running simulation.
+func Estimate(c Config) (float64, error) {
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	return c.Profile.SessionsPerSecond * c.averageHoldTime().Seconds(), nil
+}
+
+// Simulate runs simulation of given configuration.
+func Simulate(c Config) (*Result, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	steps := int(c.Duration / c.Step)
+	rnd := rand.New(rand.NewSource(c.Seed))
+	mix := c.Profile.mix()
+	sim := newSimulator(&c, steps)
+	res := &Result{
+		PoolPorts:      c.Pool.Addresses * c.Pool.portsPerAddress(),
+		SteadySessions: c.Profile.SessionsPerSecond * c.averageHoldTime().Seconds(),
+		Samples:        make([]Sample, 0, steps),
+	}
+	arrivals := c.Profile.SessionsPerSecond * c.Step.Seconds()
+	sessions := 0
+	for i := 0; i < steps; i++ {
+		for key, count := range sim.releases[i] {
+			sim.release(key, count)
+			sessions -= count
+		}
+		sim.releases[i] = nil
+		start := time.Duration(i) * c.Step
+		sample := Sample{Time: start + c.Step}
+		for n := poisson(rnd, arrivals); n > 0; n-- {
+			res.Sessions++
+			key, ok := sim.allocate(rnd.Intn(c.Profile.Subscribers))
+			if !ok {
+				sample.Failures++
+				continue
+			}
+			sessions++
+			p := rnd.Float64()
+			proto := mix[len(mix)-1].Proto
+			for _, s := range mix {
+				if p < s.Share {
+					proto = s.Proto
+					break
+				}
+			}
+			closed := proto == types.TCPNumber && rnd.Float64() < c.Profile.TCPClosedShare
+			end := start + time.Duration(rnd.Float64()*float64(c.Step)) +
+				time.Duration(rnd.ExpFloat64()*float64(c.Profile.AverageLifetime)) +
+				idleTimeout(&c.Timeouts, proto, closed)
+			// Entry is released at the beginning of the next step
+			sim.schedule(int(end/c.Step)+1, key)
+		}
+		sample.Sessions = sessions
+		sample.Ports, sample.MaxAddressPorts = sim.ports()
+		res.Failures += sample.Failures
+		if sample.Sessions > res.PeakSessions {
+			res.PeakSessions = sample.Sessions
+		}
+		if sample.Ports > res.PeakPorts {
+			res.PeakPorts = sample.Ports
+		}
+		if sample.MaxAddressPorts > res.PeakAddressPorts {
+			res.PeakAddressPorts = sample.MaxAddressPorts
+		}
+		res.Samples = append(res.Samples, sample)
+	}
+	return res, nil
+}
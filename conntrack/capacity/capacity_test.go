@@ -0,0 +1,126 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capacity
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/types"
+)
+
+func testConfig() Config {
+	timeouts := conntrack.DefaultTimeouts()
+	timeouts.TCPEstablished = 10 * time.Minute
+	return Config{
+		Profile: Profile{
+			SessionsPerSecond: 100,
+			AverageLifetime:   10 * time.Second,
+			Mix: []ProtocolShare{
+				{Proto: types.TCPNumber, Share: 70},
+				{Proto: types.UDPNumber, Share: 25},
+				{Proto: types.ICMPNumber, Share: 5},
+			},
+			Subscribers:    1000,
+			TCPClosedShare: 0.9,
+		},
+		Pool: Pool{
+			Addresses: 4,
+			PortMin:   1024,
+			PortMax:   65535,
+			Policy:    PortPerSession,
+		},
+		Timeouts: timeouts,
+		Duration: time.Hour,
+		Step:     time.Second,
+		Seed:     1,
+	}
+}
+
+func TestSteadyState(t *testing.T) {
+	c := testConfig()
+	res, err := Simulate(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimate, err := Estimate(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if estimate != res.SteadySessions {
+		t.Errorf("Estimate %f differs from steady state %f of simulation", estimate, res.SteadySessions)
+	}
+	last := res.Samples[len(res.Samples)-1]
+	if math.Abs(float64(last.Sessions)-estimate) > estimate*0.1 {
+		t.Errorf("Simulation ended with %d sessions, expected about %f", last.Sessions, estimate)
+	}
+	if res.Failures != 0 || last.Ports != last.Sessions {
+		t.Errorf("Pool wasn't sufficient: %d failures, %d ports for %d sessions", res.Failures, last.Ports, last.Sessions)
+	}
+	again, _ := Simulate(c)
+	if again.PeakSessions != res.PeakSessions || again.Sessions != res.Sessions {
+		t.Error("Simulation with the same seed isn't repeatable")
+	}
+}
+
+func TestExhaustion(t *testing.T) {
+	c := testConfig()
+	c.Pool.Addresses = 1
+	c.Pool.PortMax = c.Pool.PortMin + 999
+	res, err := Simulate(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Failures == 0 || res.PeakPorts != 1000 || res.PeakAddressPorts != 1000 {
+		t.Errorf("Pool of 1000 ports wasn't exhausted: %d failures, %d peak ports", res.Failures, res.PeakPorts)
+	}
+}
+
+func TestPortBlock(t *testing.T) {
+	c := testConfig()
+	c.Pool.Policy = PortBlock
+	c.Pool.BlockSize = 64
+	res, err := Simulate(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := res.Samples[len(res.Samples)-1]
+	if last.Ports%64 != 0 || last.Ports < last.Sessions {
+		t.Errorf("%d ports in blocks for %d sessions", last.Ports, last.Sessions)
+	}
+	if res.Failures != 0 {
+		t.Errorf("%d failures with sufficient blocks", res.Failures)
+	}
+
+	// Every subscriber needs at least one block, only half of them get it
+	c.Profile.Subscribers = 2 * c.Pool.portsPerAddress() / 64 * c.Pool.Addresses
+	if res, err = Simulate(c); err != nil {
+		t.Fatal(err)
+	}
+	if res.Failures == 0 {
+		t.Error("Blocks weren't exhausted")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	configs := []func(*Config){
+		func(c *Config) { c.Profile.SessionsPerSecond = 0 },
+		func(c *Config) { c.Profile.Subscribers = 0 },
+		func(c *Config) { c.Profile.Mix = []ProtocolShare{{Proto: types.UDPNumber}} },
+		func(c *Config) { c.Pool.PortMax = c.Pool.PortMin - 1 },
+		func(c *Config) { c.Pool.Policy = PortBlock },
+		func(c *Config) { c.Step = 0 },
+		func(c *Config) { c.Duration = time.Duration(maxSteps+1) * c.Step },
+	}
+	for i, modify := range configs {
+		c := testConfig()
+		modify(&c)
+		if _, err := Simulate(c); err == nil {
+			t.Errorf("Wrong configuration %d was accepted", i)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package conntrack implements connection tracking which can be
+// shared between network functions like firewall, load balancer and
+// NAT. Connections are identified by 5-tuple, both directions of
+// connection are mapped to the same entry. Package tracks TCP state
+// machine and expires connections after protocol specific
+// timeouts. Package doesn't depend on packet memory, so user should
+// extract tuple and TCP flags from a packet and pass them to Track.
+package conntrack
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/types"
+)
+
+// ShardsNumber is a number of independently locked parts of tables
+// keyed by Tuple, it is used by flow.Table too. Should be power of 2,
+// so that shard is selected by low bits of Hash.
+const ShardsNumber = 64
+
+// Tuple is a key of connection. Addresses and ports are stored in
+// the same byte order as in packet headers.
+type Tuple struct {
+	SrcAddr types.IPv4Address
+	DstAddr types.IPv4Address
+	SrcPort uint16
+	DstPort uint16
+	Proto   uint8
+}
+
+func (t Tuple) String() string {
+	return fmt.Sprintf("%d %s:%d -> %s:%d", t.Proto, t.SrcAddr.String(), swapBytesUint16(t.SrcPort),
+		t.DstAddr.String(), swapBytesUint16(t.DstPort))
+}
+
+// Reverse returns tuple of packets going in opposite direction.
+func (t Tuple) Reverse() Tuple {
+	return Tuple{
+		SrcAddr: t.DstAddr,
+		DstAddr: t.SrcAddr,
+		SrcPort: t.DstPort,
+		DstPort: t.SrcPort,
+		Proto:   t.Proto,
+	}
+}
+
+// canonical returns one of tuple and its reverse which is the same
+// for both directions of connection.
+func (t Tuple) canonical() Tuple {
+	if t.SrcAddr < t.DstAddr || (t.SrcAddr == t.DstAddr && t.SrcPort <= t.DstPort) {
+		return t
+	}
+	return t.Reverse()
+}
+
+// Hash returns hash of tuple which is the same for both directions
+// of connection.
+func (t Tuple) Hash() uint32 {
+	c := t.canonical()
+	h := uint32(c.SrcAddr)*2654435761 ^ uint32(c.DstAddr)*40503
+	h ^= (uint32(c.SrcPort)<<16 | uint32(c.DstPort)) * 2246822519
+	h ^= uint32(c.Proto)
+	return h ^ h>>15
+}
+
+// Direction shows whether packet goes from connection initiator
+// or from responder.
+type Direction uint8
+
+const (
+	// Original direction is a direction of first packet of connection
+	Original Direction = iota
+	// Reply direction is opposite to original
+	Reply
+)
+
+// State is a state of tracked connection.
+type State uint8
+
+// Connection states. TCP connections pass all of them, UDP and other
+// protocols have only New, Established and Closed states.
+const (
+	New State = iota
+	SynSent
+	SynReceived
+	Established
+	FinWait
+	TimeWait
+	Closed
+)
+
+var stateNames = [...]string{
+	New:         "NEW",
+	SynSent:     "SYN_SENT",
+	SynReceived: "SYN_RECV",
+	Established: "ESTABLISHED",
+	FinWait:     "FIN_WAIT",
+	TimeWait:    "TIME_WAIT",
+	Closed:      "CLOSED",
+}
+
+func (s State) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "UNKNOWN"
+}
+
+// Timeouts contains idle time after which connection in some state
+// is removed from table.
+type Timeouts struct {
+	TCPSynSent     time.Duration
+	TCPEstablished time.Duration
+	TCPFinWait     time.Duration
+	TCPTimeWait    time.Duration
+	TCPClosed      time.Duration
+	// UDP timeout before any reply was seen
+	UDP time.Duration
+	// UDP timeout after packets in both directions were seen
+	UDPStream time.Duration
+	// UDP-Lite timeouts before and after reply was seen
+	UDPLite       time.Duration
+	UDPLiteStream time.Duration
+	// DCCP timeout before any reply was seen
+	DCCP time.Duration
+	// DCCP timeout after packets in both directions were seen
+	DCCPStream time.Duration
+	ICMP       time.Duration
+	Generic    time.Duration
+}
+
+// DefaultTimeouts returns timeouts which are equal to Linux
+// netfilter defaults.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		TCPSynSent:     120 * time.Second,
+		TCPEstablished: 5 * 24 * time.Hour,
+		TCPFinWait:     120 * time.Second,
+		TCPTimeWait:    120 * time.Second,
+		TCPClosed:      10 * time.Second,
+		UDP:            30 * time.Second,
+		UDPStream:      180 * time.Second,
+		UDPLite:        30 * time.Second,
+		UDPLiteStream:  180 * time.Second,
+		DCCP:           240 * time.Second,
+		DCCPStream:     12 * time.Hour,
+		ICMP:           30 * time.Second,
+		Generic:        600 * time.Second,
+	}
+}
+
+// Entry is a tracked connection. Fields of entry shouldn't be
+// changed by user except UserData. Entries returned by Lookup and
+// Track are updated by other clones after return, so State,
+// LastSeen, Packets and Bytes should be read through Snapshot.
+// Functions called by Range and OnExpire run with entry shard locked
+// and can read fields directly.
+type Entry struct {
+	// Tuple of first packet of connection
+	Original Tuple
+	State    State
+	// Time of last packet of connection
+	LastSeen time.Time
+	// Number of packets in original and reply directions
+	Packets [2]uint64
+	// Number of bytes in original and reply directions, only packets
+	// passed to TrackPacket are counted
+	Bytes [2]uint64
+	// Any data that user wants to attach to connection, for example
+	// NAT mapping or chosen backend
+	UserData interface{}
+	finSeen  [2]bool
+	shard    *shard
+}
+
+// Snapshot returns copy of entry made with entry shard locked. It
+// shouldn't be called from Range and OnExpire functions.
+func (e *Entry) Snapshot() Entry {
+	if e.shard == nil {
+		return *e
+	}
+	e.shard.Lock()
+	defer e.shard.Unlock()
+	return *e
+}
+
+func (e *Entry) timeout(t *Timeouts, protocols map[uint8]*Protocol) time.Duration {
+	if p, ok := protocols[e.Original.Proto]; ok {
+		return p.timeout(e.State, t)
+	}
+	switch e.Original.Proto {
+	case types.TCPNumber:
+		switch e.State {
+		case New, SynSent, SynReceived:
+			return t.TCPSynSent
+		case Established:
+			return t.TCPEstablished
+		case FinWait:
+			return t.TCPFinWait
+		case TimeWait:
+			return t.TCPTimeWait
+		default:
+			return t.TCPClosed
+		}
+	case types.UDPNumber:
+		if e.State == Established {
+			return t.UDPStream
+		}
+		return t.UDP
+	case types.UDPLiteNumber:
+		if e.State == Established {
+			return t.UDPLiteStream
+		}
+		return t.UDPLite
+	case types.DCCPNumber:
+		if e.State == Established {
+			return t.DCCPStream
+		}
+		return t.DCCP
+	case types.ICMPNumber:
+		return t.ICMP
+	}
+	return t.Generic
+}
+
+// update moves entry to next state according to seen packet.
+func (e *Entry) update(dir Direction, flags types.TCPFlags) {
+	e.Packets[dir]++
+	if e.Original.Proto != types.TCPNumber {
+		if dir == Reply {
+			e.State = Established
+		}
+		return
+	}
+	if flags&types.TCPFlagRst != 0 {
+		e.State = Closed
+		return
+	}
+	switch e.State {
+	case SynSent:
+		if dir == Reply && flags&(types.TCPFlagSyn|types.TCPFlagAck) == types.TCPFlagSyn|types.TCPFlagAck {
+			e.State = SynReceived
+		}
+	case SynReceived:
+		if dir == Original && flags&types.TCPFlagAck != 0 {
+			e.State = Established
+		}
+	}
+	if flags&types.TCPFlagFin != 0 && e.State >= Established && e.State < Closed {
+		e.finSeen[dir] = true
+		if e.finSeen[Original] && e.finSeen[Reply] {
+			e.State = TimeWait
+		} else {
+			e.State = FinWait
+		}
+	}
+}
+
+type shard struct {
+	sync.Mutex
+	entries map[Tuple]*Entry
+}
+
+// Table is a connection tracking table. All its methods can be
+// called from different flow function clones simultaneously.
+type Table struct {
+	shards     [ShardsNumber]shard
+	timeouts   Timeouts
+	maxEntries int
+	count      int64
+	// OnExpire is called for every entry removed by Expire. It is
+	// called with entry shard locked, so it shouldn't call table
+	// methods.
+	OnExpire func(*Entry)
+	// OnSetupLimit is called for every connection which is not
+	// created because its source address exceeded setup rate limit.
+	// It is called with shard locked, so it shouldn't call table
+	// methods.
+	OnSetupLimit func(Tuple)
+	limiter      *setupLimiter
+	// Protocols added by SetProtocols
+	protocols  map[uint8]*Protocol
+	accounting common.ByteAccounting
+}
+
+// NewTable creates connection tracking table which can contain up
+// to maxEntries connections. Zero maxEntries means no limit.
+func NewTable(maxEntries int, timeouts Timeouts) *Table {
+	t := &Table{
+		timeouts:   timeouts,
+		maxEntries: maxEntries,
+	}
+	for i := range t.shards {
+		t.shards[i].entries = make(map[Tuple]*Entry)
+	}
+	return t
+}
+
+// SetSetupLimit limits rate of new connections from every source
+// address to rate connections per second with bursts up to burst
+// connections. Connections which exceed limit are not created and
+// Track returns error for them. Zero rate removes limit. It should
+// be called before table is used.
+func (t *Table) SetSetupLimit(rate float64, burst uint) {
+	if rate <= 0 {
+		t.limiter = nil
+		return
+	}
+	if burst == 0 {
+		burst = 1
+	}
+	t.limiter = newSetupLimiter(rate, burst)
+}
+
+// SetByteAccounting defines which bytes of frames are counted in
+// Bytes of entries by TrackPacket. It should be called before table
+// is used, usually with value of flow.GetByteAccounting so that
+// connection and port counters are consistent.
+func (t *Table) SetByteAccounting(a common.ByteAccounting) {
+	t.accounting = a
+}
+
+// SetupLimited returns number of connections which were not created
+// because of setup rate limit.
+func (t *Table) SetupLimited() uint64 {
+	if t.limiter == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&t.limiter.limited)
+}
+
+func (t *Table) getShard(tuple Tuple) *shard {
+	return &t.shards[tuple.Hash()&(ShardsNumber-1)]
+}
+
+func direction(e *Entry, tuple Tuple) Direction {
+	if e.Original == tuple {
+		return Original
+	}
+	return Reply
+}
+
+// Lookup finds connection which packet with given tuple belongs to.
+// Returns entry, direction of packet and true if connection is
+// tracked. Lookup doesn't change connection state. Counters and
+// state of returned entry should be read with Snapshot.
+func (t *Table) Lookup(tuple Tuple) (*Entry, Direction, bool) {
+	tuple = t.normalize(tuple)
+	s := t.getShard(tuple)
+	s.Lock()
+	e, ok := s.entries[tuple.canonical()]
+	s.Unlock()
+	if !ok {
+		return nil, Original, false
+	}
+	return e, direction(e, tuple), true
+}
+
+// Track finds or creates connection for packet with given tuple and
+// updates its state. flags are TCP flags of packet and are ignored
+// for other protocols. TCP connections which don't start with SYN
+// are picked up in Established state. Returns error if table is full
+// or if source address exceeded setup rate limit. Fields of tuple
+// which are not used by tracking mode of protocol are cleared in
+// connection Original tuple.
+func (t *Table) Track(tuple Tuple, flags types.TCPFlags, now time.Time) (*Entry, Direction, error) {
+	return t.track(tuple, flags, 0, now)
+}
+
+// TrackPacket is the same as Track but also counts length of packet
+// in Bytes of connection. length is a length of Ethernet frame
+// without frame check sequence, it is adjusted according to byte
+// accounting of table.
+func (t *Table) TrackPacket(tuple Tuple, flags types.TCPFlags, length uint, now time.Time) (*Entry, Direction, error) {
+	return t.track(tuple, flags, t.accounting.FrameBytes(length), now)
+}
+
+func (t *Table) track(tuple Tuple, flags types.TCPFlags, bytes uint64, now time.Time) (*Entry, Direction, error) {
+	tuple = t.normalize(tuple)
+	s := t.getShard(tuple)
+	key := tuple.canonical()
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.entries[key]
+	if ok && e.State == Closed && tuple.Proto == types.TCPNumber && flags&(types.TCPFlagSyn|types.TCPFlagAck) == types.TCPFlagSyn {
+		// New connection reuses tuple of closed one
+		delete(s.entries, key)
+		t.changeCount(-1)
+		ok = false
+	}
+	if !ok {
+		if t.limiter != nil && !t.limiter.allow(tuple.SrcAddr, now) {
+			if t.OnSetupLimit != nil {
+				t.OnSetupLimit(tuple)
+			}
+			return nil, Original, common.WrapWithNFError(nil, "Connection setup rate limit exceeded by "+
+				tuple.SrcAddr.String(), common.RateLimitExceeded)
+		}
+		if !t.changeCount(1) {
+			return nil, Original, common.WrapWithNFError(nil, "Connection tracking table is full", common.TableIsFull)
+		}
+		e = &Entry{Original: tuple, shard: s}
+		if tuple.Proto == types.TCPNumber {
+			if flags&(types.TCPFlagSyn|types.TCPFlagAck) == types.TCPFlagSyn {
+				e.State = SynSent
+			} else {
+				e.State = Established
+			}
+		}
+		s.entries[key] = e
+		e.LastSeen = now
+		e.Packets[Original]++
+		e.Bytes[Original] += bytes
+		return e, Original, nil
+	}
+	dir := direction(e, tuple)
+	e.update(dir, flags)
+	e.Bytes[dir] += bytes
+	e.LastSeen = now
+	return e, dir, nil
+}
+
+func (t *Table) changeCount(delta int64) bool {
+	if atomic.AddInt64(&t.count, delta) > int64(t.maxEntries) && delta > 0 && t.maxEntries != 0 {
+		atomic.AddInt64(&t.count, -delta)
+		return false
+	}
+	return true
+}
+
+// Delete removes connection from table.
+func (t *Table) Delete(e *Entry) {
+	s := e.shard
+	s.Lock()
+	key := e.Original.canonical()
+	if cur, ok := s.entries[key]; ok && cur == e {
+		delete(s.entries, key)
+		t.changeCount(-1)
+	}
+	s.Unlock()
+}
+
+// Expire removes all connections which were idle longer than their
+// timeouts. Returns number of removed connections. It should be
+// called periodically, for example from flow timer.
+func (t *Table) Expire(now time.Time) int {
+	removed := 0
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.Lock()
+		for key, e := range s.entries {
+			if now.Sub(e.LastSeen) >= e.timeout(&t.timeouts, t.protocols) {
+				delete(s.entries, key)
+				if t.OnExpire != nil {
+					t.OnExpire(e)
+				}
+				removed++
+			}
+		}
+		s.Unlock()
+	}
+	t.changeCount(int64(-removed))
+	if t.limiter != nil {
+		t.limiter.expire(now)
+	}
+	return removed
+}
+
+// Len returns number of tracked connections.
+func (t *Table) Len() int {
+	return int(atomic.LoadInt64(&t.count))
+}
+
+// Range calls f for every tracked connection until f returns false.
+// f is called with entry shard locked, so it shouldn't call table
+// methods.
+func (t *Table) Range(f func(*Entry) bool) {
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.Lock()
+		for _, e := range s.entries {
+			if !f(e) {
+				s.Unlock()
+				return
+			}
+		}
+		s.Unlock()
+	}
+}
+
+func swapBytesUint16(x uint16) uint16 {
+	return x<<8 | x>>8
+}
@@ -0,0 +1,372 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conntrack
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/types"
+)
+
+var client = types.BytesToIPv4(10, 0, 0, 1)
+var server = types.BytesToIPv4(10, 0, 0, 2)
+
+func tcpTuple() Tuple {
+	return Tuple{
+		SrcAddr: client,
+		DstAddr: server,
+		SrcPort: swapBytesUint16(12345),
+		DstPort: swapBytesUint16(80),
+		Proto:   types.TCPNumber,
+	}
+}
+
+type step struct {
+	dir   Direction
+	flags types.TCPFlags
+	state State
+}
+
+var tcpStateTests = []struct {
+	name  string
+	steps []step
+}{
+	{"handshake", []step{
+		{Original, types.TCPFlagSyn, SynSent},
+		{Reply, types.TCPFlagSyn | types.TCPFlagAck, SynReceived},
+		{Original, types.TCPFlagAck, Established},
+	}},
+	{"close", []step{
+		{Original, types.TCPFlagSyn, SynSent},
+		{Reply, types.TCPFlagSyn | types.TCPFlagAck, SynReceived},
+		{Original, types.TCPFlagAck, Established},
+		{Original, types.TCPFlagFin | types.TCPFlagAck, FinWait},
+		{Reply, types.TCPFlagAck, FinWait},
+		{Reply, types.TCPFlagFin | types.TCPFlagAck, TimeWait},
+	}},
+	{"reset", []step{
+		{Original, types.TCPFlagSyn, SynSent},
+		{Reply, types.TCPFlagRst, Closed},
+	}},
+	{"midstream", []step{
+		{Original, types.TCPFlagAck, Established},
+		{Reply, types.TCPFlagAck, Established},
+	}},
+	{"reuse", []step{
+		{Original, types.TCPFlagAck, Established},
+		{Reply, types.TCPFlagRst, Closed},
+		{Original, types.TCPFlagSyn, SynSent},
+	}},
+}
+
+func TestTCPStates(t *testing.T) {
+	now := time.Now()
+	for _, tt := range tcpStateTests {
+		table := NewTable(0, DefaultTimeouts())
+		for i, s := range tt.steps {
+			tuple := tcpTuple()
+			if s.dir == Reply {
+				tuple = tuple.Reverse()
+			}
+			e, dir, err := table.Track(tuple, s.flags, now)
+			if err != nil {
+				t.Fatalf("%s: step %d: unexpected error %v", tt.name, i, err)
+			}
+			if dir != s.dir {
+				t.Errorf("%s: step %d: got direction %d, want %d", tt.name, i, dir, s.dir)
+			}
+			if e.State != s.state {
+				t.Errorf("%s: step %d: got state %v, want %v", tt.name, i, e.State, s.state)
+			}
+		}
+		if table.Len() != 1 {
+			t.Errorf("%s: got %d entries, want 1", tt.name, table.Len())
+		}
+	}
+}
+
+func TestUDP(t *testing.T) {
+	now := time.Now()
+	table := NewTable(0, DefaultTimeouts())
+	tuple := Tuple{SrcAddr: client, DstAddr: server, SrcPort: 53, DstPort: 53, Proto: types.UDPNumber}
+	e, _, _ := table.Track(tuple, 0, now)
+	if e.State != New {
+		t.Errorf("got state %v, want %v", e.State, New)
+	}
+	if removed := table.Expire(now.Add(31 * time.Second)); removed != 1 {
+		t.Errorf("unreplied connection: got %d removed, want 1", removed)
+	}
+	e, _, _ = table.Track(tuple, 0, now)
+	table.Track(tuple.Reverse(), 0, now)
+	if e.State != Established {
+		t.Errorf("got state %v, want %v", e.State, Established)
+	}
+	if e.Packets != [2]uint64{1, 1} {
+		t.Errorf("got packets %v, want [1 1]", e.Packets)
+	}
+	if removed := table.Expire(now.Add(31 * time.Second)); removed != 0 {
+		t.Errorf("replied connection: got %d removed, want 0", removed)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		accounting common.ByteAccounting
+		bytes      [2]uint64
+	}{
+		{common.AccountL2, [2]uint64{1514 + 60, 60}},
+		{common.AccountL1, [2]uint64{1538 + 84, 84}},
+	} {
+		table := NewTable(0, DefaultTimeouts())
+		table.SetByteAccounting(tt.accounting)
+		tuple := tcpTuple()
+		e, _, _ := table.TrackPacket(tuple, types.TCPFlagAck, 1514, now)
+		table.TrackPacket(tuple.Reverse(), types.TCPFlagAck, 60, now)
+		table.TrackPacket(tuple, types.TCPFlagAck, 60, now)
+		// Packets passed to Track are not counted
+		table.Track(tuple, types.TCPFlagAck, now)
+		if e.Bytes != tt.bytes {
+			t.Errorf("%s accounting: got bytes %v, want %v", tt.accounting, e.Bytes, tt.bytes)
+		}
+	}
+}
+
+func TestLookupAndDelete(t *testing.T) {
+	now := time.Now()
+	table := NewTable(0, DefaultTimeouts())
+	tuple := tcpTuple()
+	if _, _, ok := table.Lookup(tuple); ok {
+		t.Fatal("found connection in empty table")
+	}
+	e, _, _ := table.Track(tuple, types.TCPFlagSyn, now)
+	found, dir, ok := table.Lookup(tuple.Reverse())
+	if !ok || found != e || dir != Reply {
+		t.Fatalf("Lookup of reply tuple: got %v %d %v", found, dir, ok)
+	}
+	table.Delete(e)
+	if _, _, ok := table.Lookup(tuple); ok || table.Len() != 0 {
+		t.Error("connection is found after delete")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	now := time.Now()
+	table := NewTable(0, DefaultTimeouts())
+	tuple := tcpTuple()
+	e, _, _ := table.TrackPacket(tuple, types.TCPFlagSyn, 60, now)
+	const packets = 1000
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < packets; i++ {
+			table.TrackPacket(tuple.Reverse(), types.TCPFlagSyn|types.TCPFlagAck, 60, now.Add(time.Duration(i)))
+		}
+	}()
+	// Snapshot can be taken while other clone tracks packets
+	for i := 0; i < packets; i++ {
+		if c := e.Snapshot(); c.Packets[Reply]*60 != c.Bytes[Reply] {
+			t.Fatalf("inconsistent snapshot: packets %v, bytes %v", c.Packets, c.Bytes)
+		}
+	}
+	wg.Wait()
+	c := e.Snapshot()
+	if c.Packets != [2]uint64{1, packets} || c.State != SynReceived || !c.LastSeen.Equal(now.Add(packets-1)) {
+		t.Errorf("got packets %v, state %s, last seen %v", c.Packets, c.State, c.LastSeen)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	timeouts := DefaultTimeouts()
+	table := NewTable(0, timeouts)
+	var expired []*Entry
+	table.OnExpire = func(e *Entry) {
+		expired = append(expired, e)
+	}
+	syn := tcpTuple()
+	est := tcpTuple()
+	est.SrcPort++
+	table.Track(syn, types.TCPFlagSyn, now)
+	table.Track(est, types.TCPFlagAck, now)
+
+	if removed := table.Expire(now.Add(timeouts.TCPSynSent - time.Second)); removed != 0 {
+		t.Errorf("got %d removed, want 0", removed)
+	}
+	if removed := table.Expire(now.Add(timeouts.TCPSynSent)); removed != 1 {
+		t.Errorf("got %d removed, want 1", removed)
+	}
+	if len(expired) != 1 || expired[0].Original != syn {
+		t.Errorf("OnExpire got %v, want only %v", expired, syn)
+	}
+	if table.Len() != 1 {
+		t.Errorf("got %d entries, want 1", table.Len())
+	}
+}
+
+func TestTableIsFull(t *testing.T) {
+	now := time.Now()
+	table := NewTable(1, DefaultTimeouts())
+	tuple := tcpTuple()
+	if _, _, err := table.Track(tuple, types.TCPFlagSyn, now); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, _, err := table.Track(tuple.Reverse(), types.TCPFlagSyn|types.TCPFlagAck, now); err != nil {
+		t.Fatalf("reply packet: unexpected error %v", err)
+	}
+	tuple.SrcPort++
+	_, _, err := table.Track(tuple, types.TCPFlagSyn, now)
+	if common.GetNFErrorCode(err) != common.TableIsFull {
+		t.Errorf("got error %v, want TableIsFull", err)
+	}
+}
+
+func TestSetupLimit(t *testing.T) {
+	now := time.Now()
+	table := NewTable(0, DefaultTimeouts())
+	table.SetSetupLimit(10, 3)
+	var limited []Tuple
+	table.OnSetupLimit = func(tuple Tuple) {
+		limited = append(limited, tuple)
+	}
+	tuple := tcpTuple()
+	for i := 0; i < 3; i++ {
+		tuple.SrcPort++
+		if _, _, err := table.Track(tuple, types.TCPFlagSyn, now); err != nil {
+			t.Fatalf("connection %d: %v", i, err)
+		}
+	}
+	tuple.SrcPort++
+	_, _, err := table.Track(tuple, types.TCPFlagSyn, now)
+	if common.GetNFErrorCode(err) != common.RateLimitExceeded {
+		t.Errorf("got error %v, want RateLimitExceeded", err)
+	}
+	if len(limited) != 1 || limited[0] != tuple || table.SetupLimited() != 1 {
+		t.Errorf("limited connections %v, counter %d", limited, table.SetupLimited())
+	}
+
+	// Other source address has its own limit
+	other := tuple
+	other.SrcAddr = types.BytesToIPv4(10, 0, 0, 3)
+	if _, _, err := table.Track(other, types.TCPFlagSyn, now); err != nil {
+		t.Errorf("other source: %v", err)
+	}
+	// Packets of existing connections are not limited
+	existing := tcpTuple()
+	existing.SrcPort++
+	if _, _, err := table.Track(existing, types.TCPFlagAck, now); err != nil {
+		t.Errorf("existing connection: %v", err)
+	}
+	now = now.Add(100 * time.Millisecond)
+	if _, _, err := table.Track(tuple, types.TCPFlagSyn, now); err != nil {
+		t.Errorf("after token refill: %v", err)
+	}
+}
+
+func TestCustomProtocols(t *testing.T) {
+	var protocols []Protocol
+	err := json.Unmarshal([]byte(`[
+		{"name": "SCTP", "number": 132, "mode": "ports", "timeout": "10s"},
+		{"name": "echo", "number": 200, "mode": "identifier"},
+		{"name": "GRE", "number": 47, "mode": "address"}
+	]`), &protocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := NewTable(0, DefaultTimeouts())
+	if err := table.SetProtocols(protocols); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := table.LookupProtocol("sctp"); !ok || n != 132 {
+		t.Errorf("sctp is resolved to %d %v", n, ok)
+	}
+	if n, ok := table.LookupProtocol("UDP-Lite"); !ok || n != types.UDPLiteNumber {
+		t.Errorf("UDP-Lite is resolved to %d %v", n, ok)
+	}
+	if n, ok := table.LookupProtocol("UDP"); !ok || n != types.UDPNumber {
+		t.Errorf("UDP is resolved to %d %v", n, ok)
+	}
+	if _, ok := table.LookupProtocol("esp"); ok {
+		t.Error("Unknown protocol is resolved")
+	}
+
+	now := time.Now()
+	gre := Tuple{SrcAddr: client, DstAddr: server, SrcPort: 1, DstPort: 2, Proto: 47}
+	e, _, err := table.Track(gre, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Original.SrcPort != 0 || e.Original.DstPort != 0 {
+		t.Errorf("Ports are kept in address mode: %v", e.Original)
+	}
+	reply := Tuple{SrcAddr: server, DstAddr: client, SrcPort: 3, DstPort: 4, Proto: 47}
+	if e2, dir, ok := table.Lookup(reply); !ok || e2 != e || dir != Reply {
+		t.Error("Reply with other ports doesn't match connection in address mode")
+	}
+
+	echo := Tuple{SrcAddr: client, DstAddr: server, SrcPort: 7, Proto: 200}
+	table.Track(echo, 0, now)
+	if _, dir, ok := table.Lookup(Tuple{SrcAddr: server, DstAddr: client, SrcPort: 7, Proto: 200}); !ok || dir != Reply {
+		t.Error("Reply with the same identifier doesn't match connection in identifier mode")
+	}
+	if _, _, ok := table.Lookup(Tuple{SrcAddr: server, DstAddr: client, SrcPort: 8, Proto: 200}); ok {
+		t.Error("Reply with other identifier matches connection in identifier mode")
+	}
+
+	sctp := Tuple{SrcAddr: client, DstAddr: server, SrcPort: 1000, DstPort: 2000, Proto: 132}
+	table.Track(sctp, 0, now)
+	if n := table.Expire(now.Add(11 * time.Second)); n != 1 {
+		t.Errorf("Expire removed %d connections, expected only SCTP one", n)
+	}
+
+	if err := table.SetProtocols([]Protocol{{Name: "mytcp", Number: types.TCPNumber}}); err == nil {
+		t.Error("TCP was redefined")
+	}
+	if err := table.SetProtocols([]Protocol{{Name: "mydccp", Number: types.DCCPNumber}}); err == nil {
+		t.Error("DCCP was redefined")
+	}
+	if err := table.SetProtocols([]Protocol{{Name: "a", Number: 132}, {Name: "b", Number: 132}}); err == nil {
+		t.Error("Protocol number was defined twice")
+	}
+}
+
+func BenchmarkTrackExisting(b *testing.B) {
+	now := time.Now()
+	table := NewTable(0, DefaultTimeouts())
+	tuple := tcpTuple()
+	table.Track(tuple, types.TCPFlagAck, now)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.Track(tuple, types.TCPFlagAck, now)
+	}
+}
+
+func BenchmarkTrackNew(b *testing.B) {
+	now := time.Now()
+	table := NewTable(0, DefaultTimeouts())
+	tuple := tcpTuple()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tuple.SrcAddr = types.IPv4Address(i)
+		table.Track(tuple, types.TCPFlagSyn, now)
+	}
+}
+
+func BenchmarkTrackParallel(b *testing.B) {
+	now := time.Now()
+	table := NewTable(0, DefaultTimeouts())
+	b.RunParallel(func(pb *testing.PB) {
+		tuple := tcpTuple()
+		for pb.Next() {
+			tuple.SrcPort++
+			table.Track(tuple, types.TCPFlagAck, now)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conntrack
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common/ratelimit"
+	"github.com/intel-go/nff-go/types"
+)
+
+type limitShard struct {
+	sync.Mutex
+	buckets map[types.IPv4Address]*ratelimit.TokenBucket
+}
+
+// setupLimiter limits rate of new connections from every source
+// address.
+type setupLimiter struct {
+	shards  [ShardsNumber]limitShard
+	rate    float64
+	burst   uint
+	limited uint64
+}
+
+func newSetupLimiter(rate float64, burst uint) *setupLimiter {
+	l := &setupLimiter{rate: rate, burst: burst}
+	for i := range l.shards {
+		l.shards[i].buckets = make(map[types.IPv4Address]*ratelimit.TokenBucket)
+	}
+	return l
+}
+
+func (l *setupLimiter) getShard(addr types.IPv4Address) *limitShard {
+	return &l.shards[(uint32(addr)*2654435761>>16)&(ShardsNumber-1)]
+}
+
+// allow takes token from bucket of address.
+func (l *setupLimiter) allow(addr types.IPv4Address, now time.Time) bool {
+	s := l.getShard(addr)
+	s.Lock()
+	b, ok := s.buckets[addr]
+	if !ok {
+		b = ratelimit.NewTokenBucket(l.rate, l.burst, now)
+		s.buckets[addr] = b
+	}
+	allowed := b.Allow(now)
+	s.Unlock()
+	if !allowed {
+		atomic.AddUint64(&l.limited, 1)
+	}
+	return allowed
+}
+
+// expire removes buckets which are full, they are the same as
+// buckets of new addresses.
+func (l *setupLimiter) expire(now time.Time) {
+	for i := range l.shards {
+		s := &l.shards[i]
+		s.Lock()
+		for addr, b := range s.buckets {
+			if b.Full(now) {
+				delete(s.buckets, addr)
+			}
+		}
+		s.Unlock()
+	}
+}
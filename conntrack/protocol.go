@@ -0,0 +1,191 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conntrack
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/types"
+)
+
+// TrackingMode defines which fields of tuple identify connection of
+// protocol.
+type TrackingMode uint8
+
+const (
+	// TrackPorts identifies connections by addresses and ports like UDP
+	TrackPorts TrackingMode = iota
+	// TrackIdentifier identifies connections by addresses and one
+	// identifier which is the same in both directions like ICMP echo
+	// identifier. Identifier is taken from SrcPort of tuple.
+	TrackIdentifier
+	// TrackAddresses identifies connections by addresses only, ports
+	// of tuple are ignored
+	TrackAddresses
+)
+
+var modeNames = [...]string{
+	TrackPorts:      "ports",
+	TrackIdentifier: "identifier",
+	TrackAddresses:  "address",
+}
+
+func (m TrackingMode) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return "unknown"
+}
+
+// UnmarshalJSON parses tracking mode from one of "ports", "identifier"
+// and "address" strings.
+func (m *TrackingMode) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	for i, name := range modeNames {
+		if strings.EqualFold(s, name) {
+			*m = TrackingMode(i)
+			return nil
+		}
+	}
+	return common.WrapWithNFError(nil, "Unknown tracking mode "+s, common.BadArgument)
+}
+
+// Protocol describes protocol which isn't known to connection tracking
+// by default, for example SCTP or ESP.
+type Protocol struct {
+	Name   string       `json:"name"`
+	Number uint8        `json:"number"`
+	Mode   TrackingMode `json:"mode"`
+	// Idle timeout of connections, zero means default timeout of mode:
+	// UDP timeouts for TrackPorts, ICMP timeout for TrackIdentifier and
+	// Generic timeout for TrackAddresses
+	Timeout time.Duration `json:"-"`
+}
+
+// UnmarshalJSON parses protocol with timeout in time.ParseDuration
+// format, for example {"name": "dccp", "number": 33, "mode": "ports",
+// "timeout": "10m"}.
+func (p *Protocol) UnmarshalJSON(b []byte) error {
+	type protocol Protocol
+	aux := struct {
+		*protocol
+		Timeout string `json:"timeout"`
+	}{protocol: (*protocol)(p)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if aux.Timeout != "" {
+		t, err := time.ParseDuration(aux.Timeout)
+		if err != nil {
+			return common.WrapWithNFError(err, "Wrong timeout of protocol "+p.Name, common.BadArgument)
+		}
+		p.Timeout = t
+	}
+	return nil
+}
+
+var builtinProtocols = map[string]uint8{
+	"tcp":      types.TCPNumber,
+	"udp":      types.UDPNumber,
+	"udplite":  types.UDPLiteNumber,
+	"udp-lite": types.UDPLiteNumber,
+	"dccp":     types.DCCPNumber,
+	"icmp":     types.ICMPNumber,
+}
+
+// SetProtocols adds protocols to the list of protocols known by table.
+// Protocols with built-in handling (TCP, UDP, UDP-Lite, DCCP and
+// ICMP) can't be redefined. It should be called before table is used.
+func (t *Table) SetProtocols(protocols []Protocol) error {
+	known := make(map[uint8]*Protocol)
+	for i := range protocols {
+		p := &protocols[i]
+		name := strings.ToLower(p.Name)
+		if name == "" {
+			return common.WrapWithNFError(nil, fmt.Sprintf("Protocol %d should have a name", p.Number), common.BadArgument)
+		}
+		if _, ok := builtinProtocols[name]; ok {
+			return common.WrapWithNFError(nil, "Protocol "+p.Name+" has built-in tracking", common.BadArgument)
+		}
+		for _, number := range builtinProtocols {
+			if p.Number == number {
+				return common.WrapWithNFError(nil, fmt.Sprintf("Protocol %d has built-in tracking", p.Number), common.BadArgument)
+			}
+		}
+		if p.Mode > TrackAddresses {
+			return common.WrapWithNFError(nil, "Unknown tracking mode of protocol "+p.Name, common.BadArgument)
+		}
+		if _, ok := known[p.Number]; ok {
+			return common.WrapWithNFError(nil, fmt.Sprintf("Protocol %d is defined twice", p.Number), common.BadArgument)
+		}
+		for _, k := range known {
+			if strings.EqualFold(k.Name, p.Name) {
+				return common.WrapWithNFError(nil, "Protocol "+p.Name+" is defined twice", common.BadArgument)
+			}
+		}
+		known[p.Number] = &Protocol{Name: p.Name, Number: p.Number, Mode: p.Mode, Timeout: p.Timeout}
+	}
+	t.protocols = known
+	return nil
+}
+
+// LookupProtocol returns number of protocol with given name. Names are
+// case insensitive. Besides built-in protocols and protocols added by
+// SetProtocols decimal numbers are also accepted.
+func (t *Table) LookupProtocol(name string) (uint8, bool) {
+	if number, ok := builtinProtocols[strings.ToLower(name)]; ok {
+		return number, true
+	}
+	for _, p := range t.protocols {
+		if strings.EqualFold(p.Name, name) {
+			return p.Number, true
+		}
+	}
+	if number, err := strconv.ParseUint(name, 10, 8); err == nil {
+		return uint8(number), true
+	}
+	return 0, false
+}
+
+// normalize clears fields of tuple which are not used by tracking mode
+// of its protocol.
+func (t *Table) normalize(tuple Tuple) Tuple {
+	if p, ok := t.protocols[tuple.Proto]; ok {
+		switch p.Mode {
+		case TrackIdentifier:
+			tuple.DstPort = tuple.SrcPort
+		case TrackAddresses:
+			tuple.SrcPort = 0
+			tuple.DstPort = 0
+		}
+	}
+	return tuple
+}
+
+// timeout returns timeout of connection of protocol added by
+// SetProtocols.
+func (p *Protocol) timeout(state State, t *Timeouts) time.Duration {
+	if p.Timeout != 0 {
+		return p.Timeout
+	}
+	switch p.Mode {
+	case TrackPorts:
+		if state == Established {
+			return t.UDPStream
+		}
+		return t.UDP
+	case TrackIdentifier:
+		return t.ICMP
+	}
+	return t.Generic
+}
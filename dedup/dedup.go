@@ -0,0 +1,189 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dedup detects duplicate packets which are captured at
+// several tap points, so mirrored and monitored outputs don't count
+// the same packet twice. Packet is a duplicate if packet with the same
+// invariant bytes was seen during time window. Fields which routers
+// change on the way are ignored: Ethernet addresses and VLAN tags,
+// IPv4 TTL and header checksum and IPv6 hop limit. Package works with
+// byte slices which start with Ethernet header and doesn't depend on
+// DPDK.
+//
+// Filter keeps fixed number of hashes, so packets which are seen too
+// often can push out older ones before end of window and their
+// duplicates are missed. Hash collisions make unrelated packets
+// duplicates with probability of about size/2^64.
+package dedup
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// Offsets and values of headers
+const (
+	etherAddrsLen    = 12
+	etherTypeLen     = 2
+	etherTypeIPv4    = 0x0800
+	etherTypeIPv6    = 0x86dd
+	ipv4TTLOff       = 8
+	ipv4ChecksumOff  = 10
+	ipv4ChecksumEnd  = 12
+	ipv6HopLimitOff  = 7
+	fnvOffset        = 14695981039346656037
+	fnvPrime         = 1099511628211
+	ways             = 4
+	shards           = 64
+	defaultHashBytes = 128
+)
+
+// VLAN EtherTypes which are skipped
+var vlanTypes = [...]uint16{0x8100, 0x88a8, 0x9100}
+
+type entry struct {
+	hash uint64
+	seen int64
+}
+
+type bucket [ways]entry
+
+// Filter remembers hashes of packets during time window. Filter can be
+// used by several goroutines simultaneously.
+type Filter struct {
+	window    int64
+	hashBytes int
+	mask      uint64
+	buckets   []bucket
+	locks     [shards]sync.Mutex
+
+	checked    uint64
+	duplicates uint64
+}
+
+// Stats contains counters of filter.
+type Stats struct {
+	// Packets passed to Duplicate
+	Checked uint64
+	// Packets which were recognized as duplicates
+	Duplicates uint64
+}
+
+// NewFilter creates filter which remembers packets during window and
+// keeps at least size hashes. Only the first hashBytes bytes of
+// packet after Ethernet header are hashed, 128 bytes are used if it is
+// zero.
+func NewFilter(window time.Duration, size int, hashBytes int) (*Filter, error) {
+	if window <= 0 || size <= 0 || hashBytes < 0 {
+		return nil, common.WrapWithNFError(nil, "Window and size of deduplication filter should be positive", common.BadArgument)
+	}
+	if hashBytes == 0 {
+		hashBytes = defaultHashBytes
+	}
+	n := 1
+	for n*ways < size {
+		n <<= 1
+	}
+	return &Filter{
+		window:    int64(window),
+		hashBytes: hashBytes,
+		mask:      uint64(n - 1),
+		buckets:   make([]bucket, n),
+	}, nil
+}
+
+// Duplicate returns true if packet with the same invariant bytes was
+// seen during window before now. Otherwise packet is remembered.
+func (f *Filter) Duplicate(frame []byte, now time.Time) bool {
+	atomic.AddUint64(&f.checked, 1)
+	h := Hash(frame, f.hashBytes)
+	t := now.UnixNano()
+	i := h & f.mask
+	lock := &f.locks[i%shards]
+	b := &f.buckets[i]
+	oldest := 0
+	lock.Lock()
+	for w := range b {
+		if b[w].hash == h && b[w].seen != 0 && t-b[w].seen < f.window {
+			lock.Unlock()
+			atomic.AddUint64(&f.duplicates, 1)
+			return true
+		}
+		if b[w].seen < b[oldest].seen {
+			oldest = w
+		}
+	}
+	b[oldest] = entry{hash: h, seen: t}
+	lock.Unlock()
+	return false
+}
+
+// Stats returns counters of filter.
+func (f *Filter) Stats() Stats {
+	return Stats{
+		Checked:    atomic.LoadUint64(&f.checked),
+		Duplicates: atomic.LoadUint64(&f.duplicates),
+	}
+}
+
+// Hash returns FNV-1a hash of invariant bytes of Ethernet frame. At
+// most maxBytes bytes after Ethernet header and VLAN tags are hashed
+// together with EtherType and length of frame.
+func Hash(frame []byte, maxBytes int) uint64 {
+	off := etherAddrsLen
+	if len(frame) < off+etherTypeLen {
+		return hashBytes(fnvOffset, frame)
+	}
+	etherType := binary.BigEndian.Uint16(frame[off:])
+	for isVLAN(etherType) && len(frame) >= off+4+etherTypeLen {
+		off += 4
+		etherType = binary.BigEndian.Uint16(frame[off:])
+	}
+	h := hashBytes(fnvOffset, frame[off:off+etherTypeLen])
+	data := frame[off+etherTypeLen:]
+	h = hashUint64(h, uint64(len(data)))
+	if len(data) > maxBytes {
+		data = data[:maxBytes]
+	}
+	switch {
+	case etherType == etherTypeIPv4 && len(data) >= ipv4ChecksumEnd:
+		h = hashBytes(h, data[:ipv4TTLOff])
+		h = hashBytes(h, data[ipv4TTLOff+1:ipv4ChecksumOff])
+		return hashBytes(h, data[ipv4ChecksumEnd:])
+	case etherType == etherTypeIPv6 && len(data) > ipv6HopLimitOff:
+		h = hashBytes(h, data[:ipv6HopLimitOff])
+		return hashBytes(h, data[ipv6HopLimitOff+1:])
+	}
+	return hashBytes(h, data)
+}
+
+func isVLAN(etherType uint16) bool {
+	for _, t := range vlanTypes {
+		if etherType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func hashBytes(h uint64, b []byte) uint64 {
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= fnvPrime
+	}
+	return h
+}
+
+func hashUint64(h uint64, v uint64) uint64 {
+	for i := 0; i < 8; i++ {
+		h ^= v & 0xff
+		h *= fnvPrime
+		v >>= 8
+	}
+	return h
+}
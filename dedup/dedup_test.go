@@ -0,0 +1,113 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dedup
+
+import (
+	"testing"
+	"time"
+)
+
+// frame returns Ethernet frame with IPv4 UDP packet with given source
+// MAC, VLAN tag if it isn't zero, TTL and payload byte.
+func frame(src byte, vlan uint16, ttl byte, payload byte) []byte {
+	b := []byte{0x02, 0, 0, 0, 0, 0x01, 0x02, 0, 0, 0, 0, src}
+	if vlan != 0 {
+		b = append(b, 0x81, 0x00, byte(vlan>>8), byte(vlan))
+	}
+	b = append(b, 0x08, 0x00,
+		0x45, 0, 0, 29, 0x12, 0x34, 0, 0, ttl, 17, byte(ttl), byte(ttl)^0x55,
+		10, 0, 0, 1, 10, 0, 0, 2,
+		0x30, 0x39, 0, 53, 0, 9, 0, 0, payload)
+	return b
+}
+
+func TestDuplicate(t *testing.T) {
+	f, err := NewFilter(10*time.Millisecond, 1024, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	if f.Duplicate(frame(1, 0, 64, 'a'), now) {
+		t.Error("First packet is a duplicate")
+	}
+	// The same packet after router seen at other tap point
+	if !f.Duplicate(frame(2, 100, 63, 'a'), now.Add(time.Millisecond)) {
+		t.Error("Packet with changed TTL, MAC and VLAN isn't a duplicate")
+	}
+	if f.Duplicate(frame(1, 0, 64, 'b'), now.Add(time.Millisecond)) {
+		t.Error("Packet with different payload is a duplicate")
+	}
+	if f.Duplicate(frame(1, 0, 64, 'a'), now.Add(20*time.Millisecond)) {
+		t.Error("Packet after window is a duplicate")
+	}
+	if s := f.Stats(); s.Checked != 4 || s.Duplicates != 1 {
+		t.Errorf("Wrong stats %+v", s)
+	}
+}
+
+func TestHash(t *testing.T) {
+	ipv6 := func(hopLimit, payload byte) []byte {
+		b := []byte{0x02, 0, 0, 0, 0, 0x01, 0x02, 0, 0, 0, 0, 0x02, 0x86, 0xdd,
+			0x60, 0, 0, 0, 0, 1, 59, hopLimit}
+		b = append(b, make([]byte, 32)...)
+		return append(b, payload)
+	}
+	if Hash(ipv6(64, 1), defaultHashBytes) != Hash(ipv6(1, 1), defaultHashBytes) {
+		t.Error("Hash depends on IPv6 hop limit")
+	}
+	if Hash(ipv6(64, 1), defaultHashBytes) == Hash(ipv6(64, 2), defaultHashBytes) {
+		t.Error("Hash doesn't depend on payload")
+	}
+	// Only the first bytes are hashed, but length of packet is
+	if Hash(frame(1, 0, 64, 'a'), 20) != Hash(frame(1, 0, 64, 'b'), 20) {
+		t.Error("Hash depends on bytes after limit")
+	}
+	if Hash(frame(1, 0, 64, 'a'), 20) == Hash(append(frame(1, 0, 64, 'a'), 0), 20) {
+		t.Error("Hash doesn't depend on length of packet")
+	}
+	// Short frames don't panic
+	for i := 0; i < 20; i++ {
+		Hash(frame(1, 100, 64, 'a')[:i], defaultHashBytes)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	f, err := NewFilter(time.Second, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	for i := 0; i < ways+1; i++ {
+		f.Duplicate(frame(1, 0, 64, byte(i)), now.Add(time.Duration(i)))
+	}
+	// The oldest packet was pushed out by the last one
+	if f.Duplicate(frame(1, 0, 64, 0), now.Add(ways+1)) {
+		t.Error("Evicted packet is a duplicate")
+	}
+	if !f.Duplicate(frame(1, 0, 64, ways), now.Add(ways+2)) {
+		t.Error("The newest packet isn't a duplicate")
+	}
+}
+
+func TestNewFilterErrors(t *testing.T) {
+	if _, err := NewFilter(0, 1, 0); err == nil {
+		t.Error("Zero window was accepted")
+	}
+	if _, err := NewFilter(time.Second, 0, 0); err == nil {
+		t.Error("Zero size was accepted")
+	}
+}
+
+func BenchmarkDuplicate(b *testing.B) {
+	f, err := NewFilter(time.Millisecond, 1<<16, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := frame(1, 100, 64, 'a')
+	now := time.Now()
+	for i := 0; i < b.N; i++ {
+		f.Duplicate(data, now)
+	}
+}
@@ -18,4 +18,5 @@ var (
 	ErrUnsupportedDriver     = errors.New("unsupported DPDK driver")
 	ErrNotProbe              = errors.New("device doesn't support 'drive_probe'")
 	ErrKernelModuleNotLoaded = errors.New("kernel module is not loaded")
+	ErrManagementDevice      = errors.New("device is used by management network interface")
 )
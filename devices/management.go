@@ -0,0 +1,99 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Path to routing tables
+const (
+	PathProcNetRoute     = "/proc/net/route"
+	PathProcNetIPv6Route = "/proc/net/ipv6_route"
+)
+
+// ManagementInterfaces returns names of kernel network interfaces of
+// device which look like management ones: they have IP addresses
+// other than link local or carry default route. Binding such device
+// to DPDK driver cuts host from network.
+func ManagementInterfaces(devID string) []string {
+	var nics []string
+	for _, bus := range []string{PathSysPciDevices, PathSysVmbusDevices} {
+		matches, _ := filepath.Glob(filepath.Join(bus, devID, "net", "*"))
+		for _, m := range matches {
+			nics = append(nics, filepath.Base(m))
+		}
+	}
+	routes := defaultRouteInterfaces()
+	var result []string
+	for _, nic := range nics {
+		if routes[nic] || hasIPAddresses(nic) {
+			result = append(result, nic)
+		}
+	}
+	return result
+}
+
+// CheckSafeToBind returns ErrManagementDevice if device is used by
+// management network interface.
+func CheckSafeToBind(device Device) error {
+	if len(ManagementInterfaces(device.ID())) != 0 {
+		return ErrManagementDevice
+	}
+	return nil
+}
+
+func hasIPAddresses(nicName string) bool {
+	nic, err := net.InterfaceByName(nicName)
+	if err != nil {
+		return false
+	}
+	addrs, err := nic.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && !n.IP.IsLinkLocalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultRouteInterfaces returns set of interfaces which carry IPv4
+// or IPv6 default route.
+func defaultRouteInterfaces() map[string]bool {
+	result := make(map[string]bool)
+	// Iface Destination Gateway Flags ...
+	forEachLine(PathProcNetRoute, func(fields []string) {
+		if len(fields) > 1 && fields[1] == "00000000" {
+			result[fields[0]] = true
+		}
+	})
+	// Destination PrefixLength Source PrefixLength NextHop Metric RefCount Use Flags Iface
+	forEachLine(PathProcNetIPv6Route, func(fields []string) {
+		if len(fields) == 10 && fields[1] == "00" && fields[9] != "lo" &&
+			strings.Trim(fields[0], "0") == "" {
+			result[fields[9]] = true
+		}
+	})
+	return result
+}
+
+func forEachLine(path string, f func(fields []string)) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f(strings.Fields(scanner.Text()))
+	}
+}
@@ -16,9 +16,14 @@ func main() {
 	inport := flag.String("in", "", "device for receiver")
 	inQueue := flag.Int("in-queue", 16, "queue for receiver")
 	outport := flag.String("out", "", "device for sender")
+	tap := flag.String("tap", "", "create tap device with this name and forward packets from it to sender device")
 	flag.Parse()
 
 	flow.CheckFatal(flow.SystemInit(nil))
+	if *tap != "" {
+		flow.CheckFatal(flow.CreateOSDevice(*tap, flow.TapDevice))
+		*inport = *tap
+	}
 	if *afXDP {
 		inputFlow, err := flow.SetReceiverXDP(*inport, *inQueue)
 		flow.CheckFatal(err)
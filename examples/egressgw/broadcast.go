@@ -0,0 +1,133 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package egressgw
+
+import (
+	"sync/atomic"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// Actions for broadcast packets received on public port
+const (
+	broadcastDrop     = "drop"
+	broadcastPunt     = "punt"
+	broadcastPuntDHCP = "punt-dhcp"
+)
+
+// Kinds of broadcast destinations, they are indexes of counters
+const (
+	limitedBroadcast = iota
+	directedBroadcast
+	notBroadcast
+)
+
+// UDP ports of DHCP server and client
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+)
+
+const limitedBroadcastAddress = types.IPv4Address(0xffffffff)
+
+// Broadcast packets aren't translated
+var dropBroadcast flow.DropReason
+
+// Counters of broadcast packets of every kind, accessed atomically
+var broadcastStats struct {
+	dropped [2]uint64
+	punted  [2]uint64
+}
+
+// initBroadcast checks broadcast config and registers drop reason.
+func initBroadcast() error {
+	c := &GWConfig.Broadcast
+	for _, action := range []*string{&c.Limited, &c.Directed} {
+		switch *action {
+		case "":
+			*action = broadcastDrop
+		case broadcastDrop:
+		case broadcastPunt, broadcastPuntDHCP:
+			if c.PuntRing == "" {
+				return common.WrapWithNFError(nil, "Broadcast packets are punted, but punt-ring isn't set", common.BadArgument)
+			}
+		default:
+			return common.WrapWithNFError(nil, "Broadcast action should be drop, punt or punt-dhcp, not "+*action, common.BadArgument)
+		}
+	}
+	var err error
+	dropBroadcast, err = flow.RegisterDropReason("broadcast")
+	return err
+}
+
+// punts returns true if some broadcast packets are punted.
+func (c *BroadcastConfig) punts() bool {
+	return c.Limited != broadcastDrop || c.Directed != broadcastDrop
+}
+
+// broadcastKind returns kind of destination of packet received on
+// port. Subnet-directed broadcasts are recognized only if subnet of
+// port is configured.
+func (port *IpPort) broadcastKind(dst types.IPv4Address) int {
+	if dst == limitedBroadcastAddress {
+		return limitedBroadcast
+	}
+	if port.Subnet.Mask != 0 && dst == port.Subnet.Addr|^port.Subnet.Mask {
+		return directedBroadcast
+	}
+	return notBroadcast
+}
+
+// isDHCP returns true if IPv4 packet is DHCP message. L3 header should
+// be parsed.
+func isDHCP(pkt *packet.Packet) bool {
+	pkt.ParseL4ForIPv4()
+	udp := pkt.GetUDPForIPv4()
+	if udp == nil {
+		return false
+	}
+	dst := packet.SwapBytesUint16(udp.DstPort)
+	return dst == dhcpServerPort || dst == dhcpClientPort
+}
+
+// notPunted is a separate function which sends broadcast packets from
+// public port to punt ring according to their action. Packets which
+// are not punted are handled by ingress.
+func notPunted(pkt *packet.Packet, ctx flow.UserContext) bool {
+	pkt.ParseL3()
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil {
+		return true
+	}
+	kind := GWConfig.PublicPort.broadcastKind(ipv4.DstAddr)
+	var action string
+	switch kind {
+	case limitedBroadcast:
+		action = GWConfig.Broadcast.Limited
+	case directedBroadcast:
+		action = GWConfig.Broadcast.Directed
+	default:
+		return true
+	}
+	if action == broadcastPunt || (action == broadcastPuntDHCP && isDHCP(pkt)) {
+		atomic.AddUint64(&broadcastStats.punted[kind], 1)
+		return false
+	}
+	return true
+}
+
+// handleBroadcast drops broadcast packet which wasn't punted. Returns
+// false if packet isn't broadcast.
+func handleBroadcast(ipv4 *packet.IPv4Hdr) bool {
+	kind := GWConfig.PublicPort.broadcastKind(ipv4.DstAddr)
+	if kind == notBroadcast {
+		return false
+	}
+	atomic.AddUint64(&broadcastStats.dropped[kind], 1)
+	return true
+}
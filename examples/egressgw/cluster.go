@@ -0,0 +1,320 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package egressgw
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/examples/egressgw/rewrite"
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+const (
+	clusterDefaultSlots  = 4
+	clusterMaxSlots      = 64
+	clusterDefaultTTL    = 10
+	clusterDefaultPrefix = "/egressgw/"
+	etcdTimeout          = 3 * time.Second
+)
+
+// clusterMember is a value of key of slot in etcd.
+type clusterMember struct {
+	Node string `json:"node"`
+	MAC  string `json:"mac"`
+	// Parsed MAC, it isn't stored in etcd
+	mac types.MACAddress
+}
+
+// Cluster state of this node. Node owns public ports which are equal
+// to its slot modulo number of slots, so every range of public ports
+// is shared by all nodes.
+var cluster struct {
+	config *ClusterConfig
+	etcd   *etcdClient
+	// Lease of slot key, empty if node should claim slot again
+	lease     string
+	renewed   time.Time
+	preferred int
+	// Slot of this node, -1 while node doesn't own slot
+	slot int32
+	// Members indexed by slot, empty nodes are free slots. Slice is
+	// replaced as a whole.
+	members    atomic.Value
+	redirected uint64
+}
+
+// initCluster checks cluster config and claims slot of this node. It
+// should be called after public port is initialized.
+func initCluster() error {
+	c := GWConfig.Cluster
+	cluster.slot = -1
+	cluster.members.Store([]clusterMember{})
+	if c == nil {
+		return nil
+	}
+	if c.Node == "" || len(c.Etcd) == 0 {
+		return common.WrapWithNFError(nil, "Cluster requires node and etcd options", common.BadArgument)
+	}
+	if c.Slots == 0 {
+		c.Slots = clusterDefaultSlots
+	}
+	if c.Slots > clusterMaxSlots {
+		return common.WrapWithNFError(nil, fmt.Sprintf("Cluster can't have more than %d slots", clusterMaxSlots), common.BadArgument)
+	}
+	if c.TTL == 0 {
+		c.TTL = clusterDefaultTTL
+	}
+	if c.Prefix == "" {
+		c.Prefix = clusterDefaultPrefix
+	}
+	cluster.config = c
+	cluster.etcd = &etcdClient{endpoints: c.Etcd, client: http.Client{Timeout: etcdTimeout}}
+	h := fnv.New32a()
+	h.Write([]byte(c.Node))
+	cluster.preferred = int(h.Sum32() % uint32(c.Slots))
+	cluster.members.Store(make([]clusterMember, c.Slots))
+	if err := renewCluster(time.Now()); err != nil {
+		return common.WrapWithNFError(err, "Can't join cluster: "+err.Error(), common.Fail)
+	}
+	if err := refreshMembers(); err != nil {
+		common.LogWarning(common.Initialization, "Can't read cluster members:", err)
+	}
+	go func() {
+		for now := range time.Tick(time.Duration(c.TTL) * time.Second / 3) {
+			if err := renewCluster(now); err != nil {
+				common.LogWarning(common.Debug, "Can't renew cluster slot:", err)
+			}
+			if err := refreshMembers(); err != nil {
+				common.LogWarning(common.Debug, "Can't read cluster members:", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// ownsPort returns true if public port in host byte order belongs to
+// this node. All ports belong to node which isn't in cluster.
+func ownsPort(port uint16) bool {
+	if cluster.config == nil {
+		return true
+	}
+	return int(port)%cluster.config.Slots == int(atomic.LoadInt32(&cluster.slot))
+}
+
+func slotKey(slot int) string {
+	return cluster.config.Prefix + "slots/" + strconv.Itoa(slot)
+}
+
+// renewCluster keeps lease of slot alive. If lease is lost, node stops
+// allocation of new bindings and claims slot again, previous slot is
+// tried first so that node keeps its ports after short failures.
+func renewCluster(now time.Time) error {
+	ttl := time.Duration(cluster.config.TTL) * time.Second
+	if cluster.lease != "" {
+		alive, err := cluster.etcd.keepAlive(cluster.lease)
+		if err == nil && alive {
+			cluster.renewed = now
+			return nil
+		}
+		// Lease can still be alive in etcd after errors
+		if err != nil && now.Sub(cluster.renewed) < ttl {
+			return err
+		}
+		atomic.StoreInt32(&cluster.slot, -1)
+		cluster.lease = ""
+		common.LogWarning(common.Initialization, "Cluster slot is lost, new bindings aren't allocated")
+	}
+	lease, err := cluster.etcd.grant(cluster.config.TTL)
+	if err != nil {
+		return err
+	}
+	value, _ := json.Marshal(clusterMember{Node: cluster.config.Node, MAC: GWConfig.PublicPort.macAddress.String()})
+	for i := 0; i < cluster.config.Slots; i++ {
+		slot := (cluster.preferred + i) % cluster.config.Slots
+		created, err := cluster.etcd.create(slotKey(slot), value, lease)
+		if err != nil {
+			return err
+		}
+		if created {
+			cluster.lease = lease
+			cluster.renewed = now
+			cluster.preferred = slot
+			atomic.StoreInt32(&cluster.slot, int32(slot))
+			common.LogDebug(common.Initialization, "Cluster node", cluster.config.Node, "owns slot", slot)
+			return nil
+		}
+	}
+	return fmt.Errorf("all %d cluster slots are taken", cluster.config.Slots)
+}
+
+// refreshMembers reads owners of slots from etcd.
+func refreshMembers() error {
+	kvs, err := cluster.etcd.list(cluster.config.Prefix + "slots/")
+	if err != nil {
+		return err
+	}
+	members := make([]clusterMember, cluster.config.Slots)
+	for _, kv := range kvs {
+		slot, err := strconv.Atoi(strings.TrimPrefix(string(kv.Key), cluster.config.Prefix+"slots/"))
+		if err != nil || slot < 0 || slot >= len(members) {
+			continue
+		}
+		var m clusterMember
+		if json.Unmarshal(kv.Value, &m) != nil {
+			continue
+		}
+		if m.mac, err = types.StringToMACAddress(m.MAC); err != nil {
+			continue
+		}
+		members[slot] = m
+	}
+	cluster.members.Store(members)
+	return nil
+}
+
+func getMembers() []clusterMember {
+	return cluster.members.Load().([]clusterMember)
+}
+
+// notRedirected is a separate function which sends packets to public
+// ports of other nodes back to public port with Ethernet address of
+// their owner.
+func notRedirected(pkt *packet.Packet, ctx flow.UserContext) bool {
+	pkt.ParseL3()
+	if pkt.GetIPv4() == nil {
+		return true
+	}
+	public, ok := rewrite.Translated(l3Bytes(pkt), false)
+	if !ok || !getPolicy().isPublic(public.Addr) {
+		return true
+	}
+	members := getMembers()
+	if len(members) == 0 {
+		return true
+	}
+	slot := int(public.Port) % len(members)
+	owner := &members[slot]
+	if slot == int(atomic.LoadInt32(&cluster.slot)) || owner.Node == "" || owner.Node == cluster.config.Node {
+		return true
+	}
+	// Packets from other nodes aren't redirected again, so packets
+	// don't loop while nodes disagree about owners of slots
+	for i := range members {
+		if members[i].Node != "" && pkt.Ether.SAddr == members[i].mac {
+			return true
+		}
+	}
+	pkt.Ether.SAddr = GWConfig.PublicPort.macAddress
+	pkt.Ether.DAddr = owner.mac
+	atomic.AddUint64(&cluster.redirected, 1)
+	return false
+}
+
+// etcdClient is a minimal client of JSON gateway of etcd v3 API.
+type etcdClient struct {
+	endpoints []string
+	client    http.Client
+}
+
+type etcdKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// call posts JSON request to path and decodes response. Endpoints are
+// tried in order until one of them responds.
+func (c *etcdClient) call(path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	for _, endpoint := range c.endpoints {
+		var r *http.Response
+		r, err = c.client.Post(strings.TrimSuffix(endpoint, "/")+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		if r.StatusCode != http.StatusOK {
+			msg, _ := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			err = fmt.Errorf("%s%s: %s: %s", endpoint, path, r.Status, bytes.TrimSpace(msg))
+			continue
+		}
+		err = json.NewDecoder(r.Body).Decode(resp)
+		r.Body.Close()
+		return err
+	}
+	return err
+}
+
+// grant returns ID of new lease with given TTL in seconds.
+func (c *etcdClient) grant(ttl uint) (string, error) {
+	var resp struct {
+		ID string `json:"ID"`
+	}
+	err := c.call("/v3/lease/grant", map[string]string{"TTL": strconv.Itoa(int(ttl))}, &resp)
+	if err == nil && resp.ID == "" {
+		err = fmt.Errorf("etcd didn't grant lease")
+	}
+	return resp.ID, err
+}
+
+// keepAlive renews lease, returns false if lease has expired.
+func (c *etcdClient) keepAlive(lease string) (bool, error) {
+	var resp struct {
+		Result struct {
+			TTL string `json:"TTL"`
+		} `json:"result"`
+	}
+	if err := c.call("/v3/lease/keepalive", map[string]string{"ID": lease}, &resp); err != nil {
+		return false, err
+	}
+	ttl, _ := strconv.Atoi(resp.Result.TTL)
+	return ttl > 0, nil
+}
+
+// create puts key with lease if it doesn't exist, returns false if key
+// exists.
+func (c *etcdClient) create(key string, value []byte, lease string) (bool, error) {
+	req := map[string]interface{}{
+		"compare": []map[string]interface{}{{
+			"key":             []byte(key),
+			"target":          "CREATE",
+			"result":          "EQUAL",
+			"create_revision": "0",
+		}},
+		"success": []map[string]interface{}{{
+			"request_put": map[string]interface{}{"key": []byte(key), "value": value, "lease": lease},
+		}},
+	}
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	err := c.call("/v3/kv/txn", req, &resp)
+	return resp.Succeeded, err
+}
+
+// list returns keys which start with prefix.
+func (c *etcdClient) list(prefix string) ([]etcdKV, error) {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	var resp struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	err := c.call("/v3/kv/range", map[string][]byte{"key": []byte(prefix), "range_end": end}, &resp)
+	return resp.KVs, err
+}
@@ -0,0 +1,456 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package egressgw
+
+import (
+	"encoding/json"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+type IpPort struct {
+	Index uint16 `json:"index"`
+	// Device is PCI address, device name or MAC address of port. If
+	// it is specified, Index is ignored. SR-IOV device plugin passes
+	// PCI address of allocated virtual function in environment
+	// variable, so device can be also specified as $VARIABLE.
+	Device string `json:"device"`
+	// Bond aggregates several devices into one port, Index and Device
+	// are ignored if it is set.
+	Bond *BondConfig `json:"bond"`
+	// Address of gateway on port network
+	Address types.IPv4Address `json:"address"`
+	// Next hop for packets which are sent to this port
+	Gateway types.IPv4Address `json:"gateway"`
+	// Network of port, it is used to recognize subnet-directed
+	// broadcasts
+	Subnet types.IPv4Subnet `json:"subnet"`
+	// IPv6 address of gateway and IPv6 next hop, they are used only
+	// for hosts proxied by ND proxy
+	Address6 types.IPv6Address `json:"address6"`
+	Gateway6 types.IPv6Address `json:"gateway6"`
+	// MTU of port, MTU of device is kept if it is zero. Jumbo frames
+	// require MTU larger than 1500.
+	MTU        uint16 `json:"mtu"`
+	neighCache *packet.NeighboursLookupTable
+	macAddress types.MACAddress
+	// Not zero while link of port is down, accessed atomically
+	linkDown int32
+}
+
+type GatewayConfig struct {
+	// Port which receives traffic of pods
+	PrivatePort IpPort `json:"private-port"`
+	// Port which sends traffic to external networks
+	PublicPort IpPort `json:"public-port"`
+	// Pod CIDR of cluster, packets from other addresses are dropped
+	PodSubnet types.IPv4Subnet `json:"pod-subnet"`
+	// File with NAT policy written by controller
+	PolicyFile string `json:"policy-file"`
+	// Range of public ports which are used for translation
+	PortMin uint16 `json:"port-min"`
+	PortMax uint16 `json:"port-max"`
+	// Time in seconds after which unused binding is removed
+	BindingTimeout uint `json:"binding-timeout"`
+	// Time in seconds during which released public port isn't given
+	// to other pod if there are other free ports. Zero disables delay.
+	PortReuseDelay uint `json:"port-reuse-delay"`
+	// Address of control HTTP server, for example "127.0.0.1:8081".
+	// Server is disabled if it is empty.
+	ControlAddress string `json:"control-address"`
+	// "l2" counts bytes of frames without FCS, "l1" adds Ethernet
+	// overhead for billing parity with carrier equipment. Default
+	// value is "l2".
+	ByteAccounting string `json:"byte-accounting"`
+	accounting     common.ByteAccounting
+	// IPv6 hosts in pod network which are reachable from external
+	// networks without translation
+	NDProxy NDProxyConfig `json:"nd-proxy"`
+	// External packet handlers which process translated packets
+	Extensions []ExtensionConfig `json:"extensions"`
+	// File to which translated packets are written in pcapng format
+	// for debugging. Every packet has custom option with translation
+	// decision. Dump is disabled if it is empty.
+	DebugDump string `json:"debug-dump"`
+	// Filter expression which selects translated packets written to
+	// debug dump, see package pktfilter. All packets are written if
+	// it is empty.
+	DebugDumpFilter string `json:"debug-dump-filter"`
+	// Learning of path MTU of external destinations
+	PathMTU PathMTUConfig `json:"path-mtu"`
+	// Handling of broadcast packets received on public port
+	Broadcast BroadcastConfig `json:"broadcast"`
+	// Lawful interception of sessions of provisioned targets.
+	// Interception is disabled if it is nil.
+	Intercept *InterceptConfig `json:"intercept"`
+	// Cluster of gateways which share public addresses. Gateway works
+	// alone if it is nil.
+	Cluster *ClusterConfig `json:"cluster"`
+	// Health checks and actions which steer traffic away from gateway
+	// while it is unhealthy. Health isn't checked if it is nil.
+	Health *HealthConfig `json:"health"`
+}
+
+// HealthConfig describes periodic health checks of gateway. Gateway
+// becomes unhealthy after fail-after consecutive rounds with failed
+// checks and healthy again after recover-after rounds without them.
+// Action is taken on every change, for example anycast route of public
+// addresses is withdrawn from BGP or VRRP priority is lowered so that
+// traffic moves to other gateways.
+type HealthConfig struct {
+	// Checks which are performed: "uplink" fails if any port is down,
+	// "mempool" fails if mempools are almost exhausted, "sessions"
+	// fails if tables of bindings are inconsistent. All checks are
+	// performed by default.
+	Checks []string `json:"checks"`
+	// Interval between rounds of checks in seconds. Default value is 1.
+	Interval uint `json:"interval"`
+	// Default value is 3.
+	FailAfter uint `json:"fail-after"`
+	// Default value is 10.
+	RecoverAfter uint `json:"recover-after"`
+	// Minimum free part of mempools in percents. Default value is 5.
+	MempoolMinFree float64 `json:"mempool-min-free"`
+	// Action which is taken when health changes: "exec" runs on-fail
+	// or on-recover command, "frr-withdraw" removes and adds back
+	// prefixes in BGP configuration of FRR, "vrrp-priority" sets
+	// priority of VRRP router of FRR. Only state is reported if it is
+	// empty.
+	Action string `json:"action"`
+	// Path of FRR vtysh. Default value is "vtysh".
+	Vtysh string `json:"vtysh"`
+	// AS number of BGP router and prefixes announced by gateway for
+	// frr-withdraw action
+	ASN      uint32             `json:"asn"`
+	Prefixes []types.IPv4Subnet `json:"prefixes"`
+	// Interface and VRID of VRRP router, its priority while gateway is
+	// healthy and unhealthy for vrrp-priority action
+	Interface    string `json:"interface"`
+	VRID         uint8  `json:"vrid"`
+	Priority     uint8  `json:"priority"`
+	FailPriority uint8  `json:"fail-priority"`
+	// Commands with arguments for exec action
+	OnFail    []string `json:"on-fail"`
+	OnRecover []string `json:"on-recover"`
+}
+
+// ClusterConfig describes cluster of gateways with the same policy
+// which share public addresses and ports. Every node claims slot in
+// etcd and allocates only public ports which are equal to its slot
+// modulo number of slots. Nodes should be connected to the same public
+// network, return traffic which arrives to other node is sent to owner
+// of its port by Ethernet address.
+type ClusterConfig struct {
+	// Unique name of node
+	Node string `json:"node"`
+	// Client URLs of etcd, for example "http://10.0.0.2:2379"
+	Etcd []string `json:"etcd"`
+	// Prefix of etcd keys. Default value is "/egressgw/".
+	Prefix string `json:"prefix"`
+	// Maximum number of nodes, it should be the same on all nodes.
+	// Default value is 4.
+	Slots int `json:"slots"`
+	// TTL of slot lease in seconds, node which doesn't renew lease
+	// loses its slot. Default value is 10.
+	TTL uint `json:"ttl"`
+}
+
+// InterceptConfig describes interception of sessions. Targets are
+// provisioned by separate HTTP server which requires bearer token and
+// records every request in audit log. Information about sessions of
+// targets is sent to X2 address of mediation device and copies of
+// their translated packets are sent to X3 address.
+type InterceptConfig struct {
+	// Address of provisioning server, for example address in
+	// management network
+	Address string `json:"address"`
+	// Certificate and key of provisioning server, server uses HTTPS
+	// if they are set
+	CertFile string `json:"cert-file"`
+	KeyFile  string `json:"key-file"`
+	// File with token which should be sent in Authorization header
+	// of provisioning requests
+	TokenFile string `json:"token-file"`
+	// File to which provisioning requests are appended
+	AuditLog string `json:"audit-log"`
+	// Addresses of mediation device
+	X2 string `json:"x2"`
+	X3 string `json:"x3"`
+	// "udp" or "tcp". Default value is "udp".
+	Transport string `json:"transport"`
+}
+
+// BroadcastConfig describes handling of packets which are received on
+// public port and are sent to limited broadcast address
+// 255.255.255.255 or to broadcast address of subnet of public port.
+// Such packets are never translated. Action "drop" drops packets,
+// "punt" sends them to punt ring and "punt-dhcp" sends DHCP packets to
+// punt ring and drops others, so host process can answer DHCP.
+type BroadcastConfig struct {
+	// Action for limited broadcasts. Default value is "drop".
+	Limited string `json:"limited"`
+	// Action for subnet-directed broadcasts. Default value is "drop".
+	Directed string `json:"directed"`
+	// Name of ring to which punted packets are sent. Host process
+	// receives them as DPDK secondary process.
+	PuntRing string `json:"punt-ring"`
+}
+
+// PathMTUConfig enables learning of path MTU from ICMP Fragmentation
+// Needed messages received on public port. Packets to external
+// networks are fragmented and MSS of TCP SYN packets is clamped
+// according to learned path MTU of their destinations.
+type PathMTUConfig struct {
+	Enabled bool `json:"enabled"`
+	// MTU of public link which is used for destinations with unknown
+	// path MTU. Default value is MTU of public port or 1500 if it
+	// isn't set.
+	MTU uint `json:"mtu"`
+	// Time in seconds during which learned path MTU is used. Default
+	// value is 600.
+	Timeout uint `json:"timeout"`
+	// Maximum number of destinations in cache. Default value is 4096.
+	MaxEntries int `json:"max-entries"`
+}
+
+// ExtensionConfig describes external handler of translated packets,
+// for example custom tagging or handling of proprietary protocol.
+// Handler is either Go plugin loaded at start, sandboxed WebAssembly
+// program or separate process connected to gateway by shared rings.
+// Extensions are applied in order of configuration.
+type ExtensionConfig struct {
+	// Go plugin built with -buildmode=plugin against the same NFF-GO
+	// sources as gateway. Plugin exports functions Egress and Ingress
+	// of type func(*packet.Packet) bool which handle packets of
+	// corresponding direction, packets are dropped if they return
+	// false. Any of functions can be omitted.
+	Plugin string `json:"plugin"`
+	// WebAssembly filter program, see package wasmfilter. Program
+	// exports functions egress and ingress which are called with
+	// frames of corresponding direction, packets are dropped if they
+	// return zero. Packets are also dropped if program traps. Any of
+	// functions can be omitted.
+	WASM string `json:"wasm"`
+	// Name of rings shared with handler process which is started as
+	// DPDK secondary process. Gateway sends packets to rings
+	// Ring-egress and Ring-ingress and receives packets which should
+	// be forwarded from rings Ring-egress-done and Ring-ingress-done.
+	// Name shouldn't be longer than 16 characters.
+	Ring string `json:"ring"`
+}
+
+// BondConfig describes bonded port, for example redundant uplinks.
+type BondConfig struct {
+	// "active-backup" or "lacp"
+	Mode string `json:"mode"`
+	// Slave devices in the same format as Device of port. The first
+	// device is primary in active-backup mode.
+	Devices []string `json:"devices"`
+}
+
+var bondModes = map[string]flow.BondMode{
+	"active-backup": flow.BondActiveBackup,
+	"lacp":          flow.BondLACP,
+}
+
+// create creates bonded port and returns its index.
+func (b *BondConfig) create() (uint16, error) {
+	mode, ok := bondModes[b.Mode]
+	if !ok {
+		return 0, common.WrapWithNFError(nil, "Bonding mode should be active-backup or lacp, not "+b.Mode, common.BadArgument)
+	}
+	slaves := make([]uint16, len(b.Devices))
+	for i, d := range b.Devices {
+		var err error
+		if slaves[i], err = flow.ResolvePort(os.ExpandEnv(d)); err != nil {
+			return 0, err
+		}
+	}
+	return flow.CreateBondedPort(mode, slaves)
+}
+
+// NDProxyConfig lists IPv6 addresses and subnets for which gateway
+// answers Neighbor Solicitations on public port and which it routes to
+// private port.
+type NDProxyConfig struct {
+	Addresses []types.IPv6Address `json:"addresses"`
+	Subnets   []types.IPv6Subnet  `json:"subnets"`
+}
+
+var GWConfig GatewayConfig
+
+func ReadConfig(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+
+	GWConfig = GatewayConfig{
+		PortMin:        1024,
+		PortMax:        65535,
+		BindingTimeout: 300,
+		PathMTU: PathMTUConfig{
+			Timeout:    600,
+			MaxEntries: 4096,
+		},
+	}
+	err = decoder.Decode(&GWConfig)
+	if err != nil {
+		return err
+	}
+	GWConfig.accounting, err = common.ParseByteAccounting(GWConfig.ByteAccounting)
+	return err
+}
+
+// GetByteAccounting returns byte accounting of config which should be
+// passed to flow.SystemInit.
+func (c *GatewayConfig) GetByteAccounting() common.ByteAccounting {
+	return c.accounting
+}
+
+func InitFlows() {
+	flow.CheckFatal(GWConfig.PrivatePort.resolve())
+	flow.CheckFatal(GWConfig.PublicPort.resolve())
+	flow.CheckFatal(loadPolicy(GWConfig.PolicyFile))
+	initNAT(time.Duration(GWConfig.PortReuseDelay) * time.Second)
+	var err error
+	dropARP, err = flow.RegisterDropReason("arp")
+	flow.CheckFatal(err)
+	dropPortsExhausted, err = flow.RegisterDropReason("ports-exhausted")
+	flow.CheckFatal(err)
+	dropLinkDown, err = flow.RegisterDropReason("link-down")
+	flow.CheckFatal(err)
+	flow.CheckFatal(initPathMTU())
+	flow.CheckFatal(initBroadcast())
+
+	outFlow, err := flow.SetReceiver(GWConfig.PrivatePort.Index)
+	flow.CheckFatal(err)
+	flow.CheckFatal(flow.SetHandlerDropReason(outFlow, egress, nil))
+	outFlow, err = addStages(outFlow, egressDir)
+	flow.CheckFatal(err)
+	if pmtuCache != nil {
+		flow.CheckFatal(flow.SetPathMTUFragmenter(outFlow, GWConfig.PathMTU.MTU, pmtuCache))
+	}
+	inFlow, err := flow.SetReceiver(GWConfig.PublicPort.Index)
+	flow.CheckFatal(err)
+	if GWConfig.Cluster != nil {
+		redirectFlow, err := flow.SetSeparator(inFlow, notRedirected, nil)
+		flow.CheckFatal(err)
+		outFlow, err = flow.SetMerger(outFlow, redirectFlow)
+		flow.CheckFatal(err)
+	}
+	flow.CheckFatal(flow.SetSender(outFlow, GWConfig.PublicPort.Index))
+	if GWConfig.Broadcast.punts() {
+		puntFlow, err := flow.SetSeparator(inFlow, notPunted, nil)
+		flow.CheckFatal(err)
+		flow.CheckFatal(flow.SetSenderRing(puntFlow, GWConfig.Broadcast.PuntRing))
+	}
+	flow.CheckFatal(flow.SetHandlerDropReason(inFlow, ingress, nil))
+	inFlow, err = addStages(inFlow, ingressDir)
+	flow.CheckFatal(err)
+	flow.CheckFatal(flow.SetSender(inFlow, GWConfig.PrivatePort.Index))
+
+	GWConfig.PrivatePort.initPort(func(ipv4 types.IPv4Address) bool {
+		return ipv4 == GWConfig.PrivatePort.Address
+	})
+	// Gateway answers ARP requests for all public addresses from policy
+	GWConfig.PublicPort.initPort(func(ipv4 types.IPv4Address) bool {
+		return ipv4 == GWConfig.PublicPort.Address || getPolicy().isPublic(ipv4)
+	})
+	flow.CheckFatal(GWConfig.PrivatePort.watchLink())
+	flow.CheckFatal(GWConfig.PublicPort.watchLink())
+	flow.CheckFatal(initCluster())
+	flow.CheckFatal(initHealth())
+	if GWConfig.ControlAddress != "" && !hasModule("control") {
+		common.LogWarning(common.Initialization, "Control server is not compiled in, control-address is ignored")
+	}
+	if len(GWConfig.NDProxy.Addresses)+len(GWConfig.NDProxy.Subnets) != 0 && !hasModule("ipv6") {
+		common.LogWarning(common.Initialization, "IPv6 support is not compiled in, nd-proxy is ignored")
+	}
+	if len(GWConfig.Extensions) != 0 && !hasModule("extensions") {
+		common.LogWarning(common.Initialization, "Extensions are not compiled in, extensions are ignored")
+	}
+	if GWConfig.DebugDump != "" && !hasModule("debugdump") {
+		common.LogWarning(common.Initialization, "Debug dump is not compiled in, debug-dump is ignored")
+	}
+	if GWConfig.Intercept != nil && !hasModule("intercept") {
+		common.LogWarning(common.Initialization, "Interception is not compiled in, intercept is ignored")
+	}
+	flow.CheckFatal(startModules())
+
+	timeout := time.Duration(GWConfig.BindingTimeout) * time.Second
+	// Expiry sweeps run on data-path cores between bursts, reload of
+	// policy reads file and stays in separate goroutine
+	flow.CheckFatal(flow.AddMaintenanceJob("nat-expiry", time.Second, func(now time.Time) {
+		expireBindings(now.Add(-timeout))
+		if pmtuCache != nil {
+			pmtuCache.Expire(now)
+		}
+	}))
+	go func() {
+		for range time.Tick(time.Second) {
+			if err := reloadPolicy(GWConfig.PolicyFile); err != nil {
+				common.LogWarning(common.Initialization, "Can't reload NAT policy:", err)
+			}
+		}
+	}()
+}
+
+func (port *IpPort) resolve() error {
+	if port.Bond != nil {
+		index, err := port.Bond.create()
+		if err != nil {
+			return err
+		}
+		port.Index = index
+	} else if device := os.ExpandEnv(port.Device); device != "" {
+		index, err := flow.ResolvePort(device)
+		if err != nil {
+			return err
+		}
+		port.Index = index
+	}
+	if port.MTU != 0 {
+		return flow.SetPortMTU(port.Index, port.MTU)
+	}
+	return nil
+}
+
+// watchLink pauses translation of packets which are sent to port while
+// its link is down. Neighbours are forgotten when link goes down
+// because next hop can be replaced while link is down.
+func (port *IpPort) watchLink() error {
+	if up, _ := flow.GetPortLinkStatus(port.Index); !up {
+		atomic.StoreInt32(&port.linkDown, 1)
+	}
+	return flow.RegisterLinkCallback(port.Index, func(index uint16, up bool, speed uint32) {
+		if up {
+			atomic.StoreInt32(&port.linkDown, 0)
+			return
+		}
+		atomic.StoreInt32(&port.linkDown, 1)
+		port.neighCache.Flush()
+	})
+}
+
+func (port *IpPort) isDown() bool {
+	return atomic.LoadInt32(&port.linkDown) != 0
+}
+
+func (port *IpPort) initPort(checkv4 func(ipv4 types.IPv4Address) bool) {
+	port.macAddress = flow.GetPortMACAddress(port.Index)
+	port.neighCache = packet.NewNeighbourTable(port.Index, port.macAddress, checkv4,
+		func(ipv6 types.IPv6Address) bool {
+			return port.Address6 != types.IPv6Address{} && ipv6 == port.Address6
+		})
+}
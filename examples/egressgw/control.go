@@ -0,0 +1,529 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !nat_minimal,!no_control
+
+package egressgw
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/examples/egressgw/policy"
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+type applyResult struct {
+	Changed bool            `json:"changed"`
+	Changes []policy.Change `json:"changes"`
+}
+
+// handleApply takes full desired policy, applies the difference with
+// current policy and returns it. Result has "changed" field as
+// configuration management tools expect. With dry-run=true query
+// parameter changes are only computed.
+func handleApply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "Desired policy should be sent with POST or PUT", http.StatusMethodNotAllowed)
+		return
+	}
+	desired := new(policy.Policy)
+	if err := json.NewDecoder(r.Body).Decode(desired); err != nil {
+		http.Error(w, "Bad policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry-run") == "true"
+	changes, err := applyPolicy(GWConfig.PolicyFile, desired, dryRun)
+	if err != nil {
+		http.Error(w, "Can't apply policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if changes == nil {
+		changes = []policy.Change{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(applyResult{
+		Changed: len(changes) != 0 && !dryRun,
+		Changes: changes,
+	})
+}
+
+// handleState returns current policy.
+func handleState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getPolicy().source)
+}
+
+// trafficCounters are counters of egress and ingress directions.
+type trafficCounters struct {
+	Packets [2]uint64 `json:"packets"`
+	Bytes   [2]uint64 `json:"bytes"`
+}
+
+func (c *counters) load() trafficCounters {
+	var t trafficCounters
+	for dir := range t.Packets {
+		t.Packets[dir] = atomic.LoadUint64(&c.packets[dir])
+		t.Bytes[dir] = atomic.LoadUint64(&c.bytes[dir])
+	}
+	return t
+}
+
+type podCounters struct {
+	Address string `json:"address"`
+	trafficCounters
+}
+
+type bindingCounters struct {
+	Session  uint64 `json:"session"`
+	Private  string `json:"private"`
+	Public   string `json:"public"`
+	Protocol uint8  `json:"protocol"`
+	trafficCounters
+}
+
+// handleCounters returns counters of every pod. Bytes are counted
+// according to byte-accounting option of config.
+func handleCounters(w http.ResponseWriter, r *http.Request) {
+	pods := []podCounters{}
+	nat.Lock()
+	for addr, c := range nat.pods {
+		pods = append(pods, podCounters{Address: addr.String(), trafficCounters: c.load()})
+	}
+	nat.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pods)
+}
+
+// handleBindings returns current bindings with their counters.
+func handleBindings(w http.ResponseWriter, r *http.Request) {
+	bindings := []bindingCounters{}
+	nat.Lock()
+	for _, b := range nat.out {
+		bindings = append(bindings, bindingCounters{
+			Session:         b.session,
+			Private:         b.private.String(),
+			Public:          b.public.String(),
+			Protocol:        b.private.proto,
+			trafficCounters: b.load(),
+		})
+	}
+	nat.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bindings)
+}
+
+// Number of buckets of allocated ports distribution
+const portBuckets = 16
+
+type portBucket struct {
+	First     uint16 `json:"first"`
+	Last      uint16 `json:"last"`
+	Allocated int    `json:"allocated"`
+}
+
+type allocatorResult struct {
+	allocatorStats
+	// Public ports which are used by bindings now
+	Allocated int `json:"allocated"`
+	// Allocated ports of every public address
+	Addresses map[string]int `json:"addresses"`
+	// Distribution of allocated ports in range of public ports
+	Distribution []portBucket `json:"distribution"`
+}
+
+// handleAllocator returns counters of port allocator and distribution
+// of allocated ports. Many collisions or reuse delay violations mean
+// that range of public ports is too small or that reuse delay is too
+// long for current number of bindings.
+func handleAllocator(w http.ResponseWriter, r *http.Request) {
+	min, max := uint32(GWConfig.PortMin), uint32(GWConfig.PortMax)
+	size := (max - min + portBuckets) / portBuckets
+	res := allocatorResult{Addresses: make(map[string]int)}
+	for first := min; first <= max; first += size {
+		last := first + size - 1
+		if last > max {
+			last = max
+		}
+		res.Distribution = append(res.Distribution, portBucket{First: uint16(first), Last: uint16(last)})
+	}
+	nat.Lock()
+	res.allocatorStats = nat.stats
+	res.Allocated = len(nat.in)
+	for key := range nat.in {
+		res.Addresses[key.addr.String()]++
+		if port := uint32(packet.SwapBytesUint16(key.port)); port >= min && port <= max {
+			res.Distribution[(port-min)/size].Allocated++
+		}
+	}
+	nat.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+type pathMTUResult struct {
+	Destination string    `json:"destination"`
+	MTU         uint16    `json:"mtu"`
+	Learned     time.Time `json:"learned"`
+}
+
+// handlePathMTU returns path MTU of external destinations learned from
+// ICMP messages. List is empty if path MTU learning is disabled.
+func handlePathMTU(w http.ResponseWriter, r *http.Request) {
+	res := []pathMTUResult{}
+	for _, e := range pathMTUEntries(time.Now()) {
+		res = append(res, pathMTUResult{Destination: e.Destination, MTU: e.MTU, Learned: e.Learned})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+type clusterMemberResult struct {
+	Slot int    `json:"slot"`
+	Node string `json:"node"`
+	MAC  string `json:"mac"`
+}
+
+type clusterResult struct {
+	Node       string                `json:"node"`
+	Slot       int32                 `json:"slot"`
+	Members    []clusterMemberResult `json:"members"`
+	Redirected uint64                `json:"redirected"`
+}
+
+// handleCluster returns slot of this node, owners of other slots and
+// number of packets redirected to other nodes. Node is empty if
+// cluster mode is disabled.
+func handleCluster(w http.ResponseWriter, r *http.Request) {
+	res := clusterResult{
+		Slot:       atomic.LoadInt32(&cluster.slot),
+		Members:    []clusterMemberResult{},
+		Redirected: atomic.LoadUint64(&cluster.redirected),
+	}
+	if cluster.config != nil {
+		res.Node = cluster.config.Node
+	}
+	for slot, m := range getMembers() {
+		if m.Node != "" {
+			res.Members = append(res.Members, clusterMemberResult{Slot: slot, Node: m.Node, MAC: m.MAC})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+type healthResult struct {
+	Healthy     bool      `json:"healthy"`
+	Failed      []string  `json:"failed"`
+	Since       time.Time `json:"since"`
+	ActionError string    `json:"action-error,omitempty"`
+}
+
+// handleHealth returns health of gateway and checks which failed in
+// the last round. Status is 503 while gateway is unhealthy, so it can
+// be used by external probes.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	health.Lock()
+	res := healthResult{
+		Healthy:     health.healthy,
+		Failed:      append([]string{}, health.failed...),
+		Since:       health.changed,
+		ActionError: health.actionError,
+	}
+	health.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if !res.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+type broadcastResult struct {
+	Dropped [2]uint64 `json:"dropped"`
+	Punted  [2]uint64 `json:"punted"`
+}
+
+// handleBroadcastCounters returns counters of limited and subnet-directed
+// broadcast packets received on public port.
+func handleBroadcastCounters(w http.ResponseWriter, r *http.Request) {
+	var res broadcastResult
+	for kind := range res.Dropped {
+		res.Dropped[kind] = atomic.LoadUint64(&broadcastStats.dropped[kind])
+		res.Punted[kind] = atomic.LoadUint64(&broadcastStats.punted[kind])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+type deleteResult struct {
+	DryRun  bool `json:"dry-run"`
+	Deleted int  `json:"deleted"`
+}
+
+// parseBindingFilter parses subnet, protocol and ports query
+// parameters. Missing parameters match all bindings.
+func parseBindingFilter(r *http.Request) (*bindingFilter, error) {
+	q := r.URL.Query()
+	f := &bindingFilter{portMax: 65535}
+	var err error
+	if s := q.Get("subnet"); s != "" {
+		if f.subnet, err = parseSubnet(s); err != nil {
+			return nil, err
+		}
+	}
+	switch s := q.Get("protocol"); s {
+	case "":
+	case "tcp":
+		f.proto = types.TCPNumber
+	case "udp":
+		f.proto = types.UDPNumber
+	case "icmp":
+		f.proto = types.ICMPNumber
+	default:
+		proto, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("Bad protocol %q", s)
+		}
+		f.proto = uint8(proto)
+	}
+	if s := q.Get("ports"); s != "" {
+		parts := strings.Split(s, "-")
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("Bad range of ports %q", s)
+		}
+		min, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("Bad range of ports %q", s)
+		}
+		max := min
+		if len(parts) == 2 {
+			if max, err = strconv.ParseUint(parts[1], 10, 16); err != nil || max < min {
+				return nil, fmt.Errorf("Bad range of ports %q", s)
+			}
+		}
+		f.portMin, f.portMax = uint16(min), uint16(max)
+	}
+	return f, nil
+}
+
+// handleDelete removes bindings which match subnet, protocol and ports
+// query parameters. Bindings are removed by batches of batch bindings
+// (1000 by default) with pause between them (10ms by default). With
+// dry-run=true bindings are only counted.
+func handleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Bindings should be deleted with POST or DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := parseBindingFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	batch := 1000
+	if s := r.URL.Query().Get("batch"); s != "" {
+		if batch, err = strconv.Atoi(s); err != nil || batch <= 0 {
+			http.Error(w, "Bad batch size "+s, http.StatusBadRequest)
+			return
+		}
+	}
+	pause := 10 * time.Millisecond
+	if s := r.URL.Query().Get("pause"); s != "" {
+		if pause, err = time.ParseDuration(s); err != nil {
+			http.Error(w, "Bad pause: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	dryRun := r.URL.Query().Get("dry-run") == "true"
+	deleted := deleteBindings(f, batch, pause, dryRun)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deleteResult{DryRun: dryRun, Deleted: deleted})
+}
+
+type subscriberResult struct {
+	Private string `json:"private"`
+}
+
+// handleSubscriber returns private address which owns public address
+// and port given by address and port query parameters according to
+// deterministic mappings of current policy.
+func handleSubscriber(w http.ResponseWriter, r *http.Request) {
+	public, err := parseIPv4(r.URL.Query().Get("address"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	port, err := strconv.ParseUint(r.URL.Query().Get("port"), 10, 16)
+	if err != nil {
+		http.Error(w, "Bad port: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	private, ok := getPolicy().subscriber(public, uint16(port))
+	if !ok {
+		http.Error(w, "Address and port don't belong to deterministic mapping", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscriberResult{Private: private.String()})
+}
+
+// Version of telemetry requests of control server. Requests of this
+// version have prefix /v1/.
+const controlAPIVersion = 1
+
+var trafficFields = map[string]common.StatField{
+	"packets": {Type: common.StatCounter, Unit: "packets", Help: "Translated packets in egress and ingress directions"},
+	"bytes":   {Type: common.StatCounter, Unit: "bytes", Help: "Bytes of translated packets in egress and ingress directions counted according to byte-accounting option"},
+}
+
+func withTraffic(fields map[string]common.StatField) map[string]common.StatField {
+	for k, v := range trafficFields {
+		fields[k] = v
+	}
+	return fields
+}
+
+// Statistics of control server, descriptions are checked against
+// structures at start.
+var controlStats = []common.StatsGroup{
+	{
+		Name:    "nat-counters",
+		Version: controlAPIVersion,
+		Path:    "/v1/counters",
+		Help:    "Counters of every pod which had bindings",
+		Fields: common.MustDescribeStats(podCounters{}, withTraffic(map[string]common.StatField{
+			"address": {Type: common.StatLabel, Help: "Address of pod"},
+		})),
+	},
+	{
+		Name:    "nat-bindings",
+		Version: controlAPIVersion,
+		Path:    "/v1/bindings",
+		Help:    "Current bindings of private addresses and ports to public ones",
+		Fields: common.MustDescribeStats(bindingCounters{}, withTraffic(map[string]common.StatField{
+			"session":  {Type: common.StatLabel, Help: "Number of binding which is also recorded in debug dump"},
+			"private":  {Type: common.StatLabel, Help: "Private address and port of pod"},
+			"public":   {Type: common.StatLabel, Help: "Public address and port"},
+			"protocol": {Type: common.StatLabel, Help: "IP protocol number"},
+		})),
+	},
+	{
+		Name:    "nat-allocator",
+		Version: controlAPIVersion,
+		Path:    "/v1/allocator",
+		Help:    "Statistics of public port allocator",
+		Fields: common.MustDescribeStats(allocatorResult{}, map[string]common.StatField{
+			"allocations":      {Type: common.StatCounter, Unit: "bindings", Help: "New bindings"},
+			"failures":         {Type: common.StatCounter, Unit: "bindings", Help: "Bindings which weren't created because all ports were used"},
+			"collisions":       {Type: common.StatCounter, Unit: "ports", Help: "Ports which were tried but were used by other bindings"},
+			"reuse-delayed":    {Type: common.StatCounter, Unit: "ports", Help: "Free ports which were skipped because of reuse delay"},
+			"reuse-violations": {Type: common.StatCounter, Unit: "ports", Help: "Ports which were allocated before end of reuse delay"},
+			"allocated":        {Type: common.StatGauge, Unit: "ports", Help: "Public ports which are used by bindings"},
+			"addresses":        {Type: common.StatObject, Unit: "ports", Help: "Map of public addresses to numbers of their allocated ports"},
+			"distribution":     {Type: common.StatObject, Unit: "ports", Help: "List of ranges of public ports with first and last ports and number of allocated ports"},
+		}),
+	},
+	{
+		Name:    "nat-pmtu",
+		Version: controlAPIVersion,
+		Path:    "/v1/pmtu",
+		Help:    "Path MTU of external destinations learned from ICMP messages if path-mtu is enabled",
+		Fields: common.MustDescribeStats(pathMTUResult{}, map[string]common.StatField{
+			"destination": {Type: common.StatLabel, Help: "Address of external destination"},
+			"mtu":         {Type: common.StatGauge, Unit: "bytes", Help: "Learned path MTU"},
+			"learned":     {Type: common.StatLabel, Help: "Time when path MTU was learned, entry expires after timeout of path-mtu option"},
+		}),
+	},
+	{
+		Name:    "nat-broadcast",
+		Version: controlAPIVersion,
+		Path:    "/v1/broadcast",
+		Help:    "Broadcast packets received on public port",
+		Fields: common.MustDescribeStats(broadcastResult{}, map[string]common.StatField{
+			"dropped": {Type: common.StatCounter, Unit: "packets", Help: "Dropped packets to limited and subnet-directed broadcast addresses"},
+			"punted":  {Type: common.StatCounter, Unit: "packets", Help: "Packets to limited and subnet-directed broadcast addresses which were sent to punt ring"},
+		}),
+	},
+	{
+		Name:    "nat-cluster",
+		Version: controlAPIVersion,
+		Path:    "/v1/cluster",
+		Help:    "Slots of nodes of gateway cluster if cluster is configured",
+		Fields: common.MustDescribeStats(clusterResult{}, map[string]common.StatField{
+			"node":       {Type: common.StatLabel, Help: "Name of this node"},
+			"slot":       {Type: common.StatLabel, Help: "Slot of this node, -1 if node doesn't own slot"},
+			"members":    {Type: common.StatObject, Help: "List of slots with names and public Ethernet addresses of their owners"},
+			"redirected": {Type: common.StatCounter, Unit: "packets", Help: "Packets to public ports of other nodes which were sent to their owners"},
+		}),
+	},
+	{
+		Name:    "nat-health",
+		Version: controlAPIVersion,
+		Path:    "/v1/health",
+		Help:    "Health of gateway if health checks are configured",
+		Fields: common.MustDescribeStats(healthResult{}, map[string]common.StatField{
+			"healthy":      {Type: common.StatGauge, Help: "True while gateway is healthy"},
+			"failed":       {Type: common.StatObject, Help: "List of checks which failed in the last round"},
+			"since":        {Type: common.StatLabel, Help: "Time of the last change of health"},
+			"action-error": {Type: common.StatLabel, Help: "Error of action which was taken on the last change"},
+		}),
+	},
+}
+
+// handleSchema returns description of statistics of NFF-GO and control
+// server.
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flow.GetStatsSchema())
+}
+
+func init() {
+	registerModule("control", func() error {
+		for _, g := range controlStats {
+			if err := flow.RegisterStatsGroup(g); err != nil {
+				return err
+			}
+		}
+		if GWConfig.ControlAddress != "" {
+			startControl(GWConfig.ControlAddress)
+		}
+		return nil
+	})
+}
+
+func startControl(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apply", handleApply)
+	mux.HandleFunc("/state", handleState)
+	mux.HandleFunc("/counters", handleCounters)
+	mux.HandleFunc("/bindings", handleBindings)
+	mux.HandleFunc("/subscriber", handleSubscriber)
+	mux.HandleFunc("/allocator", handleAllocator)
+	mux.HandleFunc("/delete", handleDelete)
+	mux.HandleFunc("/pmtu", handlePathMTU)
+	mux.HandleFunc("/broadcast", handleBroadcastCounters)
+	mux.HandleFunc("/cluster", handleCluster)
+	mux.HandleFunc("/health", handleHealth)
+	mux.HandleFunc("/v1/counters", handleCounters)
+	mux.HandleFunc("/v1/bindings", handleBindings)
+	mux.HandleFunc("/v1/allocator", handleAllocator)
+	mux.HandleFunc("/v1/pmtu", handlePathMTU)
+	mux.HandleFunc("/v1/broadcast", handleBroadcastCounters)
+	mux.HandleFunc("/v1/cluster", handleCluster)
+	mux.HandleFunc("/v1/health", handleHealth)
+	mux.HandleFunc("/v1/schema", handleSchema)
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			common.LogWarning(common.Initialization, "Error while serving control requests:", err)
+		}
+	}()
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Controller periodically reads namespaces, pods and nodes of
+// Kubernetes cluster with kubectl and writes NAT policy for egress
+// gateway. Public address of namespace is selected by annotation.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os/exec"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/intel-go/nff-go/examples/egressgw/policy"
+)
+
+type metadata struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type object struct {
+	Metadata metadata `json:"metadata"`
+	Spec     struct {
+		HostNetwork bool   `json:"hostNetwork"`
+		PodCIDR     string `json:"podCIDR"`
+	} `json:"spec"`
+	Status struct {
+		PodIP     string `json:"podIP"`
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"status"`
+}
+
+var kubectl *string
+
+func list(args ...string) ([]object, error) {
+	out, err := exec.Command(*kubectl, append([]string{"get", "-o", "json"}, args...)...).Output()
+	if err != nil {
+		return nil, err
+	}
+	var l struct {
+		Items []object `json:"items"`
+	}
+	err = json.Unmarshal(out, &l)
+	return l.Items, err
+}
+
+func buildPolicy(annotation, defaultAddress string, deterministic []policy.Deterministic) (*policy.Policy, error) {
+	namespaces, err := list("namespaces")
+	if err != nil {
+		return nil, err
+	}
+	pods, err := list("pods", "--all-namespaces", "--field-selector=status.phase=Running")
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := list("nodes")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &policy.Policy{DefaultAddress: defaultAddress, Deterministic: deterministic}
+	index := make(map[string]int)
+	for _, ns := range namespaces {
+		if address, ok := ns.Metadata.Annotations[annotation]; ok {
+			index[ns.Metadata.Name] = len(p.Namespaces)
+			p.Namespaces = append(p.Namespaces, policy.Namespace{Name: ns.Metadata.Name, Address: address})
+		}
+	}
+	for _, pod := range pods {
+		i, ok := index[pod.Metadata.Namespace]
+		if !ok || pod.Spec.HostNetwork || pod.Status.PodIP == "" {
+			continue
+		}
+		p.Namespaces[i].Pods = append(p.Namespaces[i].Pods, pod.Status.PodIP)
+	}
+	for _, node := range nodes {
+		if node.Spec.PodCIDR == "" {
+			continue
+		}
+		for _, a := range node.Status.Addresses {
+			if a.Type == "InternalIP" {
+				p.Routes = append(p.Routes, policy.Route{Subnet: node.Spec.PodCIDR, Gateway: a.Address})
+				break
+			}
+		}
+	}
+	// Policy is written only if it is changed, so order should be stable
+	sort.Slice(p.Namespaces, func(i, j int) bool { return p.Namespaces[i].Name < p.Namespaces[j].Name })
+	for i := range p.Namespaces {
+		sort.Strings(p.Namespaces[i].Pods)
+	}
+	sort.Slice(p.Routes, func(i, j int) bool { return p.Routes[i].Subnet < p.Routes[j].Subnet })
+	return p, nil
+}
+
+func main() {
+	policyFile := flag.String("policy", "/etc/egressgw/policy.json", "Specify policy file name.")
+	annotation := flag.String("annotation", "egressgw.nff-go.io/address", "Specify namespace annotation with public address.")
+	defaultAddress := flag.String("default-address", "", "Specify public address of namespaces without annotation, they can't access external networks if it is empty.")
+	mappings := flag.String("deterministic", "", "Specify comma separated deterministic mappings of private subnets in private=public[:ports-per-host] format, for example 10.0.0.0/22=198.51.100.0/27:1024.")
+	interval := flag.Duration("interval", 5*time.Second, "Specify interval of cluster polling.")
+	kubectl = flag.String("kubectl", "kubectl", "Specify kubectl command.")
+	flag.Parse()
+	deterministic, err := policy.ParseDeterministic(*mappings)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var current *policy.Policy
+	for ; ; time.Sleep(*interval) {
+		p, err := buildPolicy(*annotation, *defaultAddress, deterministic)
+		if err != nil {
+			log.Println("Can't read cluster state:", err)
+			continue
+		}
+		if reflect.DeepEqual(p, current) {
+			continue
+		}
+		if err := p.Write(*policyFile); err != nil {
+			log.Println("Can't write policy:", err)
+			continue
+		}
+		log.Println("Policy updated:", len(p.Namespaces), "namespaces,", len(p.Routes), "routes")
+		current = p
+	}
+}
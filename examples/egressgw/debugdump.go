@@ -0,0 +1,99 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !nat_minimal,!no_debugdump
+
+package egressgw
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/pktfilter"
+)
+
+func init() {
+	registerModule("debugdump", startDebugDump)
+}
+
+// IANA Private Enterprise Number of Intel which is used in custom
+// options of debug dump
+const intelPEN = 343
+
+// Interfaces of debug dump, packets are recorded on interface to which
+// they are sent
+const (
+	dumpPrivateIface = 0
+	dumpPublicIface  = 1
+)
+
+var debugDump struct {
+	sync.Mutex
+	file   *os.File
+	filter *pktfilter.Filter
+}
+
+// startDebugDump creates pcapng file to which translated packets are
+// written if debug dump is configured.
+func startDebugDump() error {
+	if GWConfig.DebugDump == "" {
+		return nil
+	}
+	if GWConfig.DebugDumpFilter != "" {
+		filter, err := pktfilter.Compile(GWConfig.DebugDumpFilter)
+		if err != nil {
+			return err
+		}
+		debugDump.filter = filter
+	}
+	f, err := os.Create(GWConfig.DebugDump)
+	if err != nil {
+		return common.WrapWithNFError(err, "Can't create debug dump "+GWConfig.DebugDump, common.FileErr)
+	}
+	if err := packet.WritePcapngHdr(f, "private", "public"); err != nil {
+		f.Close()
+		return err
+	}
+	debugDump.file = f
+	dumpTranslation = writeTranslation
+	return nil
+}
+
+// translationMetadata describes translation decision of binding b for
+// packet of direction dir.
+func translationMetadata(dir int, b *binding) string {
+	if dir == egressDir {
+		return fmt.Sprintf("dir=egress session=%d proto=%d original=%s translated=%s",
+			b.session, b.private.proto, b.private, b.public)
+	}
+	return fmt.Sprintf("dir=ingress session=%d proto=%d original=%s translated=%s",
+		b.session, b.public.proto, b.public, b.private)
+}
+
+// writeTranslation writes translated packet with translation decision
+// in custom option if it matches debug dump filter. Dump is stopped
+// after the first write error.
+func writeTranslation(pkt *packet.Packet, dir int, b *binding) {
+	if debugDump.filter != nil && !debugDump.filter.Match(pkt.GetRawPacketBytes()) {
+		return
+	}
+	iface := uint32(dumpPublicIface)
+	if dir == ingressDir {
+		iface = dumpPrivateIface
+	}
+	option := packet.PcapngCustomString(intelPEN, translationMetadata(dir, b))
+	debugDump.Lock()
+	defer debugDump.Unlock()
+	if debugDump.file == nil {
+		return
+	}
+	if err := pkt.WritePcapngOnePacket(debugDump.file, iface, option); err != nil {
+		common.LogWarning(common.Debug, "Debug dump is stopped:", err)
+		debugDump.file.Close()
+		debugDump.file = nil
+	}
+}
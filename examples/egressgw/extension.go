@@ -0,0 +1,175 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !nat_minimal,!no_extensions
+
+package egressgw
+
+import (
+	"plugin"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/wasmfilter"
+)
+
+func init() {
+	registerStage("extensions", addExtensions)
+}
+
+// Maximum length of ring name of extension, suffixes are added to it
+// and DPDK limits ring names to 29 characters
+const maxExtensionRing = 16
+
+var (
+	// Packets are dropped by handler of extension
+	dropExtension flow.DropReason
+	// WebAssembly program of extension trapped
+	dropExtensionTrap flow.DropReason
+)
+
+// Handlers of plugins in egress and ingress directions, nil handler
+// means that plugin doesn't handle direction
+type pluginHandlers [2]func(pkt *packet.Packet) bool
+
+// Loaded plugins, plugin is loaded once for both directions
+var plugins = make(map[string]pluginHandlers)
+
+func loadPlugin(path string) (pluginHandlers, error) {
+	if h, ok := plugins[path]; ok {
+		return h, nil
+	}
+	var h pluginHandlers
+	p, err := plugin.Open(path)
+	if err != nil {
+		return h, common.WrapWithNFError(err, "Can't load plugin "+path+": "+err.Error(), common.BadArgument)
+	}
+	for dir, name := range []string{egressDir: "Egress", ingressDir: "Ingress"} {
+		sym, err := p.Lookup(name)
+		if err != nil {
+			continue
+		}
+		f, ok := sym.(func(*packet.Packet) bool)
+		if !ok {
+			return h, common.WrapWithNFError(nil, "Function "+name+" of plugin "+path+" should have type func(*packet.Packet) bool", common.BadArgument)
+		}
+		h[dir] = f
+	}
+	if h[egressDir] == nil && h[ingressDir] == nil {
+		return h, common.WrapWithNFError(nil, "Plugin "+path+" exports neither Egress nor Ingress function", common.BadArgument)
+	}
+	plugins[path] = h
+	common.LogDebug(common.Initialization, "Loaded plugin", path)
+	return h, nil
+}
+
+// addExtensions adds handlers of all configured extensions to flow of
+// direction dir.
+func addExtensions(f *flow.Flow, dir int) (*flow.Flow, error) {
+	if len(GWConfig.Extensions) != 0 && dropExtension == 0 {
+		var err error
+		if dropExtension, err = flow.RegisterDropReason("extension"); err != nil {
+			return nil, err
+		}
+		if dropExtensionTrap, err = flow.RegisterDropReason("extension-trap"); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range GWConfig.Extensions {
+		var err error
+		kinds := 0
+		for _, v := range []string{e.Plugin, e.WASM, e.Ring} {
+			if v != "" {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			return nil, common.WrapWithNFError(nil, "Extension should have one of plugin, wasm or ring", common.BadArgument)
+		}
+		switch {
+		case e.Plugin != "":
+			f, err = addPlugin(f, dir, e.Plugin)
+		case e.WASM != "":
+			f, err = addWASM(f, dir, e.WASM)
+		default:
+			f, err = addExternalHandler(f, dir, e.Ring)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func addPlugin(f *flow.Flow, dir int, path string) (*flow.Flow, error) {
+	h, err := loadPlugin(path)
+	if err != nil {
+		return nil, err
+	}
+	handler := h[dir]
+	if handler == nil {
+		return f, nil
+	}
+	err = flow.SetHandlerDropReason(f, func(pkt *packet.Packet, ctx flow.UserContext) flow.DropReason {
+		if handler(pkt) {
+			return flow.Pass
+		}
+		return dropExtension
+	}, nil)
+	return f, err
+}
+
+// Loaded WebAssembly programs, program is loaded once for both
+// directions
+var wasmFilters = make(map[string]*wasmfilter.Filter)
+
+func addWASM(f *flow.Flow, dir int, file string) (*flow.Flow, error) {
+	filter, ok := wasmFilters[file]
+	if !ok {
+		var err error
+		if filter, err = wasmfilter.Load(file); err != nil {
+			return nil, err
+		}
+		if !filter.HasHook("egress") && !filter.HasHook("ingress") {
+			return nil, common.WrapWithNFError(nil, "WebAssembly program "+file+" exports neither egress nor ingress function", common.BadArgument)
+		}
+		wasmFilters[file] = filter
+		common.LogDebug(common.Initialization, "Loaded WebAssembly program", file)
+	}
+	hook := "egress"
+	if dir == ingressDir {
+		hook = "ingress"
+	}
+	if !filter.HasHook(hook) {
+		return f, nil
+	}
+	err := flow.SetHandlerDropReason(f, func(pkt *packet.Packet, ctx flow.UserContext) flow.DropReason {
+		verdict, err := filter.Run(hook, pkt.GetRawPacketBytes())
+		if err != nil {
+			return dropExtensionTrap
+		}
+		if verdict == 0 {
+			return dropExtension
+		}
+		return flow.Pass
+	}, nil)
+	return f, err
+}
+
+// addExternalHandler sends packets to handler process and returns flow
+// of packets which handler returns back.
+func addExternalHandler(f *flow.Flow, dir int, ring string) (*flow.Flow, error) {
+	if len(ring) > maxExtensionRing {
+		return nil, common.WrapWithNFError(nil, "Ring name of extension "+ring+" is too long", common.BadArgument)
+	}
+	name := ring + "-egress"
+	if dir == ingressDir {
+		name = ring + "-ingress"
+	}
+	if err := flow.SetSenderRing(f, name); err != nil {
+		return nil, err
+	}
+	return flow.SetReceiverRing(name + "-done")
+}
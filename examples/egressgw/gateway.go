@@ -0,0 +1,167 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package egressgw
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/intel-go/nff-go/examples/egressgw/rewrite"
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// l3Bytes returns slice of packet data which starts at L3 header.
+// Slice refers to mbuf, so header rewriting functions change packet.
+func l3Bytes(pkt *packet.Packet) []byte {
+	length := int(pkt.GetPacketSegmentLen()) - types.EtherLen
+	if length <= 0 {
+		return nil
+	}
+	return (*[types.MaxLength]byte)(pkt.L3)[:length:length]
+}
+
+// setNextHop fills L2 header of packet sent to port. Returns
+// DropARPMiss if MAC address of next hop is unknown yet.
+func (port *IpPort) setNextHop(pkt *packet.Packet, nextHop types.IPv4Address) flow.DropReason {
+	mac, found := port.neighCache.LookupMACForIPv4(nextHop)
+	if !found {
+		port.neighCache.SendARPRequestForIPv4(nextHop, port.Address, 0)
+		return flow.DropARPMiss
+	}
+	pkt.Ether.SAddr = port.macAddress
+	pkt.Ether.DAddr = mac
+	return flow.Pass
+}
+
+// Drop reasons of gateway in addition to predefined ones
+var (
+	// ARP packets are handled by gateway and aren't forwarded
+	dropARP flow.DropReason
+	// All public ports of public address are used
+	dropPortsExhausted flow.DropReason
+	// Link of port to which packet should be sent is down
+	dropLinkDown flow.DropReason
+)
+
+// Handlers of IPv6 packets, they are set by ipv6 module. IPv6 packets
+// are dropped if module isn't compiled in.
+var egress6, ingress6 func(pkt *packet.Packet, ipv6 *packet.IPv6Hdr) flow.DropReason
+
+// Writes translated packet to debug dump, it is set by debugdump
+// module if dump is enabled.
+var dumpTranslation func(pkt *packet.Packet, dir int, b *binding)
+
+// Sends copies of packets of intercepted sessions to mediation device,
+// it is set by intercept module if interception is configured.
+var interceptPacket func(pkt *packet.Packet, dir int, b *binding)
+
+func handleARP(pkt *packet.Packet, port *IpPort) {
+	if err := port.neighCache.HandleIPv4ARPPacket(pkt); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// egress translates packets from pods to external networks.
+func egress(pkt *packet.Packet, ctx flow.UserContext) flow.DropReason {
+	pkt.ParseL3()
+	if pkt.Ether.EtherType == types.SwapARPNumber {
+		handleARP(pkt, &GWConfig.PrivatePort)
+		return dropARP
+	}
+	if ipv6 := pkt.GetIPv6(); ipv6 != nil {
+		if egress6 == nil {
+			return flow.DropOther
+		}
+		return egress6(pkt, ipv6)
+	}
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil || !GWConfig.PodSubnet.CheckIPv4AddressWithinSubnet(ipv4.SrcAddr) {
+		return flow.DropACLDeny
+	}
+	public, portMin, portMax := getPolicy().publicAddress(ipv4.SrcAddr)
+	if public == 0 {
+		return flow.DropACLDeny
+	}
+	// Bindings aren't allocated while uplink is dead
+	if GWConfig.PublicPort.isDown() {
+		return dropLinkDown
+	}
+	ip := l3Bytes(pkt)
+	private, ok := rewrite.Translated(ip, true)
+	if !ok {
+		return flow.DropNoTranslation
+	}
+	now := time.Now()
+	b := allocate(natKey{addr: private.Addr, port: packet.SwapBytesUint16(private.Port), proto: private.Proto},
+		public, portMin, portMax, now)
+	if b == nil {
+		return dropPortsExhausted
+	}
+	rewrite.Translate(ip, true, b.public.addr, packet.SwapBytesUint16(b.public.port))
+	clampMSS(ip, now)
+	reason := GWConfig.PublicPort.setNextHop(pkt, GWConfig.PublicPort.Gateway)
+	if reason == flow.Pass {
+		b.count(egressDir, flow.GetByteAccounting().FrameBytes(pkt.GetPacketLen()))
+		if dumpTranslation != nil {
+			dumpTranslation(pkt, egressDir, b)
+		}
+		if interceptPacket != nil {
+			interceptPacket(pkt, egressDir, b)
+		}
+	}
+	return reason
+}
+
+// ingress translates replies from external networks back to pods.
+func ingress(pkt *packet.Packet, ctx flow.UserContext) flow.DropReason {
+	pkt.ParseL3()
+	if pkt.Ether.EtherType == types.SwapARPNumber {
+		handleARP(pkt, &GWConfig.PublicPort)
+		return dropARP
+	}
+	if ipv6 := pkt.GetIPv6(); ipv6 != nil {
+		if ingress6 == nil {
+			return flow.DropOther
+		}
+		return ingress6(pkt, ipv6)
+	}
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil {
+		return flow.DropOther
+	}
+	if handleBroadcast(ipv4) {
+		return dropBroadcast
+	}
+	if GWConfig.PrivatePort.isDown() {
+		return dropLinkDown
+	}
+	ip := l3Bytes(pkt)
+	now := time.Now()
+	if learnPathMTU(ip, now) {
+		return dropPathMTU
+	}
+	public, ok := rewrite.Translated(ip, false)
+	if !ok {
+		return flow.DropNoTranslation
+	}
+	b := lookupPublic(natKey{addr: public.Addr, port: packet.SwapBytesUint16(public.Port), proto: public.Proto}, now)
+	if b == nil {
+		return flow.DropNoTranslation
+	}
+	rewrite.Translate(ip, false, b.private.addr, packet.SwapBytesUint16(b.private.port))
+	reason := GWConfig.PrivatePort.setNextHop(pkt, getPolicy().nextHop(ipv4.DstAddr))
+	if reason == flow.Pass {
+		b.count(ingressDir, flow.GetByteAccounting().FrameBytes(pkt.GetPacketLen()))
+		if dumpTranslation != nil {
+			dumpTranslation(pkt, ingressDir, b)
+		}
+		if interceptPacket != nil {
+			interceptPacket(pkt, ingressDir, b)
+		}
+	}
+	return reason
+}
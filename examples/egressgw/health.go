@@ -0,0 +1,230 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package egressgw
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/flow"
+)
+
+// Health checks
+const (
+	healthUplink   = "uplink"
+	healthMempool  = "mempool"
+	healthSessions = "sessions"
+)
+
+// Actions which are taken when health changes
+const (
+	healthActionExec  = "exec"
+	healthActionFRR   = "frr-withdraw"
+	healthActionVRRP  = "vrrp-priority"
+	healthCmdTimeout  = 10 * time.Second
+	healthSampleLimit = 64
+)
+
+var healthChecks = map[string]func() bool{
+	healthUplink: func() bool {
+		return !GWConfig.PublicPort.isDown() && !GWConfig.PrivatePort.isDown()
+	},
+	healthMempool: func() bool {
+		return flow.GetMempoolsFree()*100 >= GWConfig.Health.MempoolMinFree
+	},
+	healthSessions: checkSessions,
+}
+
+var health struct {
+	sync.Mutex
+	healthy bool
+	// Failed checks of the last round
+	failed []string
+	// Number of consecutive rounds which disagree with current state
+	streak  uint
+	changed time.Time
+	// Error of the last action, empty if it succeeded
+	actionError string
+}
+
+// initHealth checks health config and starts periodic checks.
+func initHealth() error {
+	health.healthy = true
+	health.changed = time.Now()
+	c := GWConfig.Health
+	if c == nil {
+		return nil
+	}
+	if len(c.Checks) == 0 {
+		c.Checks = []string{healthUplink, healthMempool, healthSessions}
+	}
+	for _, name := range c.Checks {
+		if healthChecks[name] == nil {
+			return common.WrapWithNFError(nil, "Health check should be uplink, mempool or sessions, not "+name, common.BadArgument)
+		}
+	}
+	if c.Interval == 0 {
+		c.Interval = 1
+	}
+	if c.FailAfter == 0 {
+		c.FailAfter = 3
+	}
+	if c.RecoverAfter == 0 {
+		c.RecoverAfter = 10
+	}
+	if c.MempoolMinFree == 0 {
+		c.MempoolMinFree = 5
+	}
+	if c.Vtysh == "" {
+		c.Vtysh = "vtysh"
+	}
+	switch c.Action {
+	case "":
+	case healthActionExec:
+		if len(c.OnFail) == 0 || len(c.OnRecover) == 0 {
+			return common.WrapWithNFError(nil, "Health action exec requires on-fail and on-recover commands", common.BadArgument)
+		}
+	case healthActionFRR:
+		if c.ASN == 0 || len(c.Prefixes) == 0 {
+			return common.WrapWithNFError(nil, "Health action frr-withdraw requires asn and prefixes", common.BadArgument)
+		}
+	case healthActionVRRP:
+		if c.Interface == "" || c.VRID == 0 || c.Priority == 0 {
+			return common.WrapWithNFError(nil, "Health action vrrp-priority requires interface, vrid and priority", common.BadArgument)
+		}
+	default:
+		return common.WrapWithNFError(nil, "Health action should be exec, frr-withdraw or vrrp-priority, not "+c.Action, common.BadArgument)
+	}
+	go func() {
+		for range time.Tick(time.Duration(c.Interval) * time.Second) {
+			updateHealth(runHealthChecks())
+		}
+	}()
+	return nil
+}
+
+// checkSessions checks that tables of bindings agree with each other on
+// sample of bindings. Iteration order of maps is random, so every
+// round checks different bindings.
+func checkSessions() bool {
+	nat.Lock()
+	defer nat.Unlock()
+	if len(nat.out) != len(nat.in) {
+		return false
+	}
+	n := 0
+	for key, b := range nat.out {
+		if b.private != key || nat.in[b.public] != b {
+			return false
+		}
+		if n++; n >= healthSampleLimit {
+			break
+		}
+	}
+	return true
+}
+
+// runHealthChecks returns names of failed checks.
+func runHealthChecks() []string {
+	var failed []string
+	for _, name := range GWConfig.Health.Checks {
+		if !healthChecks[name]() {
+			failed = append(failed, name)
+		}
+	}
+	return failed
+}
+
+// updateHealth changes health of gateway after enough consecutive
+// rounds disagree with it and takes action of new state.
+func updateHealth(failed []string) {
+	c := GWConfig.Health
+	health.Lock()
+	health.failed = failed
+	if (len(failed) == 0) == health.healthy {
+		health.streak = 0
+		health.Unlock()
+		return
+	}
+	health.streak++
+	limit := c.FailAfter
+	if !health.healthy {
+		limit = c.RecoverAfter
+	}
+	if health.streak < limit {
+		health.Unlock()
+		return
+	}
+	health.healthy = !health.healthy
+	health.streak = 0
+	health.changed = time.Now()
+	healthy := health.healthy
+	health.Unlock()
+
+	if healthy {
+		common.LogWarning(common.Initialization, "Gateway is healthy again")
+	} else {
+		common.LogWarning(common.Initialization, "Gateway is unhealthy, failed checks:", strings.Join(failed, ", "))
+	}
+	var msg string
+	if err := runHealthAction(healthy); err != nil {
+		common.LogWarning(common.Initialization, "Health action failed:", err)
+		msg = err.Error()
+	}
+	health.Lock()
+	health.actionError = msg
+	health.Unlock()
+}
+
+// healthCommand returns command which is run when gateway becomes
+// healthy or unhealthy.
+func healthCommand(healthy bool) []string {
+	c := GWConfig.Health
+	switch c.Action {
+	case healthActionExec:
+		if healthy {
+			return c.OnRecover
+		}
+		return c.OnFail
+	case healthActionFRR:
+		cmd := []string{c.Vtysh, "-c", "configure terminal", "-c", fmt.Sprintf("router bgp %d", c.ASN),
+			"-c", "address-family ipv4 unicast"}
+		for _, p := range c.Prefixes {
+			network := "network " + p.String()
+			if !healthy {
+				network = "no " + network
+			}
+			cmd = append(cmd, "-c", network)
+		}
+		return cmd
+	case healthActionVRRP:
+		priority := c.Priority
+		if !healthy {
+			priority = c.FailPriority
+		}
+		return []string{c.Vtysh, "-c", "configure terminal", "-c", "interface " + c.Interface,
+			"-c", fmt.Sprintf("vrrp %d priority %d", c.VRID, priority)}
+	}
+	return nil
+}
+
+func runHealthAction(healthy bool) error {
+	cmd := healthCommand(healthy)
+	if len(cmd) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthCmdTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, cmd[0], cmd[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", cmd[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
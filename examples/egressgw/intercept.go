@@ -0,0 +1,419 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !nat_minimal,!no_intercept
+
+package egressgw
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/pktfilter"
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	registerModule("intercept", startIntercept)
+}
+
+// Types of intercept records
+const (
+	interceptIRI     = 2 // Intercept related information, sent to X2
+	interceptContent = 3 // Copy of packet, sent to X3
+)
+
+const (
+	interceptVersion   = 1
+	interceptHdrLen    = 24
+	interceptQueueLen  = 4096
+	interceptRedialGap = time.Second
+)
+
+// interceptTarget is a provisioned target. Target matches bindings of
+// private address or bindings to public address and port, zero
+// fields aren't compared. Only packets matching filter are copied if
+// it isn't nil.
+type interceptTarget struct {
+	ID      string
+	Private types.IPv4Address
+	Public  types.IPv4Address
+	Port    uint16
+	Filter  *pktfilter.Filter
+}
+
+func (t *interceptTarget) matches(b *binding) bool {
+	if t.Private != 0 && b.private.addr == t.Private {
+		return true
+	}
+	return t.Public != 0 && b.public.addr == t.Public &&
+		(t.Port == 0 || packet.SwapBytesUint16(b.public.port) == t.Port)
+}
+
+// interceptRecord is a record which is sent to mediation device.
+type interceptRecord struct {
+	kind    uint8
+	dir     uint8
+	session uint64
+	time    time.Time
+	target  string
+	payload []byte
+	// Binding of intercepted packet, its addresses don't change
+	binding *binding
+}
+
+// encode returns record in wire format. TCP records are prefixed by
+// their length.
+func (r *interceptRecord) encode(stream bool) []byte {
+	length := interceptHdrLen + len(r.target) + len(r.payload)
+	buf := make([]byte, 0, 4+length)
+	if stream {
+		buf = append(buf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buf, uint32(length))
+	}
+	var hdr [interceptHdrLen]byte
+	hdr[0] = interceptVersion
+	hdr[1] = r.kind
+	hdr[2] = r.dir
+	binary.BigEndian.PutUint64(hdr[4:], r.session)
+	binary.BigEndian.PutUint64(hdr[12:], uint64(r.time.UnixNano()))
+	binary.BigEndian.PutUint16(hdr[20:], uint16(len(r.target)))
+	binary.BigEndian.PutUint16(hdr[22:], uint16(len(r.payload)))
+	buf = append(buf, hdr[:]...)
+	buf = append(buf, r.target...)
+	return append(buf, r.payload...)
+}
+
+// mediation is a connection to one interface of mediation device.
+// Connection is established again after errors.
+type mediation struct {
+	network string
+	address string
+	conn    net.Conn
+	redial  time.Time
+}
+
+func (m *mediation) send(r *interceptRecord) {
+	if m.conn == nil {
+		if time.Now().Before(m.redial) {
+			atomic.AddUint64(&intercept.stats.lost, 1)
+			return
+		}
+		conn, err := net.DialTimeout(m.network, m.address, interceptRedialGap)
+		if err != nil {
+			common.LogWarning(common.Debug, "Can't connect to mediation device", m.address, err)
+			m.redial = time.Now().Add(interceptRedialGap)
+			atomic.AddUint64(&intercept.stats.lost, 1)
+			return
+		}
+		m.conn = conn
+	}
+	if _, err := m.conn.Write(r.encode(m.network == "tcp")); err != nil {
+		m.conn.Close()
+		m.conn = nil
+		atomic.AddUint64(&intercept.stats.lost, 1)
+		return
+	}
+	atomic.AddUint64(&intercept.stats.sent, 1)
+}
+
+var intercept struct {
+	// Current targets, they are replaced as a whole on provisioning
+	targets atomic.Value
+	// Serializes provisioning requests and audit log writes
+	sync.Mutex
+	audit   *os.File
+	token   []byte
+	records chan *interceptRecord
+	stats   struct {
+		sent    uint64
+		lost    uint64
+		dropped uint64
+	}
+}
+
+func getTargets() []interceptTarget {
+	return intercept.targets.Load().([]interceptTarget)
+}
+
+// startIntercept opens audit log and starts provisioning server and
+// sender of intercepted packets if interception is configured.
+func startIntercept() error {
+	c := GWConfig.Intercept
+	if c == nil {
+		return nil
+	}
+	network := c.Transport
+	if network == "" {
+		network = "udp"
+	}
+	if network != "udp" && network != "tcp" {
+		return common.WrapWithNFError(nil, "Intercept transport should be udp or tcp, not "+c.Transport, common.BadArgument)
+	}
+	if c.Address == "" || c.X2 == "" || c.X3 == "" || c.AuditLog == "" || c.TokenFile == "" {
+		return common.WrapWithNFError(nil, "Intercept requires address, x2, x3, audit-log and token-file options", common.BadArgument)
+	}
+	token, err := ioutil.ReadFile(c.TokenFile)
+	if err != nil {
+		return common.WrapWithNFError(err, "Can't read intercept token", common.FileErr)
+	}
+	intercept.token = []byte(strings.TrimSpace(string(token)))
+	if len(intercept.token) == 0 {
+		return common.WrapWithNFError(nil, "Intercept token is empty", common.BadArgument)
+	}
+	intercept.audit, err = os.OpenFile(c.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return common.WrapWithNFError(err, "Can't open intercept audit log "+c.AuditLog, common.FileErr)
+	}
+	intercept.targets.Store([]interceptTarget{})
+	intercept.records = make(chan *interceptRecord, interceptQueueLen)
+	go sendRecords(&mediation{network: network, address: c.X2}, &mediation{network: network, address: c.X3})
+	interceptPacket = copyPacket
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/targets", handleTargets)
+	mux.HandleFunc("/stats", handleInterceptStats)
+	go func() {
+		var err error
+		if c.CertFile != "" {
+			err = http.ListenAndServeTLS(c.Address, c.CertFile, c.KeyFile, mux)
+		} else {
+			err = http.ListenAndServe(c.Address, mux)
+		}
+		common.LogWarning(common.Initialization, "Error while serving intercept provisioning:", err)
+	}()
+	return nil
+}
+
+// copyPacket sends copy of translated packet of target session to
+// mediation device. Copies are dropped if sender falls behind.
+func copyPacket(pkt *packet.Packet, dir int, b *binding) {
+	targets := getTargets()
+	for i := range targets {
+		if !targets[i].matches(b) ||
+			(targets[i].Filter != nil && !targets[i].Filter.Match(pkt.GetRawPacketBytes())) {
+			continue
+		}
+		r := &interceptRecord{
+			kind:    interceptContent,
+			dir:     uint8(dir),
+			session: b.session,
+			time:    time.Now(),
+			target:  targets[i].ID,
+			payload: append([]byte(nil), l3Bytes(pkt)...),
+			binding: b,
+		}
+		select {
+		case intercept.records <- r:
+		default:
+			atomic.AddUint64(&intercept.stats.dropped, 1)
+		}
+	}
+}
+
+// interceptSession is intercept related information which is sent to
+// X2 before the first packet of every session.
+type interceptSession struct {
+	Private  string `json:"private"`
+	Public   string `json:"public"`
+	Protocol uint8  `json:"protocol"`
+}
+
+// sendRecords sends intercepted packets to X3 and information about
+// new sessions to X2.
+func sendRecords(x2, x3 *mediation) {
+	sessions := make(map[uint64]bool)
+	for r := range intercept.records {
+		if !sessions[r.session] {
+			sessions[r.session] = true
+			b := r.binding
+			iri, err := json.Marshal(interceptSession{Private: b.private.String(), Public: b.public.String(), Protocol: b.private.proto})
+			if err == nil {
+				x2.send(&interceptRecord{kind: interceptIRI, dir: r.dir, session: r.session, time: r.time, target: r.target, payload: iri})
+			}
+		}
+		x3.send(r)
+		// Sessions are forgotten when map grows, so information is
+		// sent again for long sessions which is harmless
+		if len(sessions) > interceptQueueLen {
+			sessions = make(map[uint64]bool)
+		}
+	}
+}
+
+// auditEntry is a line of audit log.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Remote string    `json:"remote"`
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"`
+	Result string    `json:"result"`
+}
+
+// auditLog appends provisioning action to audit log. Intercept lock
+// should be held. Returns false if entry can't be written, action
+// should be refused then.
+func auditLog(r *http.Request, action, target, result string) bool {
+	line, err := json.Marshal(auditEntry{Time: time.Now().UTC(), Remote: r.RemoteAddr, Action: action, Target: target, Result: result})
+	if err == nil {
+		_, err = intercept.audit.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = intercept.audit.Sync()
+	}
+	if err != nil {
+		common.LogWarning(common.Initialization, "Can't write intercept audit log:", err)
+		return false
+	}
+	return true
+}
+
+func authorized(r *http.Request) bool {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	return strings.HasPrefix(h, prefix) && subtle.ConstantTimeCompare([]byte(h[len(prefix):]), intercept.token) == 1
+}
+
+type targetRequest struct {
+	ID      string            `json:"id"`
+	Private types.IPv4Address `json:"private"`
+	Public  types.IPv4Address `json:"public"`
+	Port    uint16            `json:"port"`
+	Filter  string            `json:"filter"`
+}
+
+type targetView struct {
+	ID      string `json:"id"`
+	Private string `json:"private,omitempty"`
+	Public  string `json:"public,omitempty"`
+	Port    uint16 `json:"port,omitempty"`
+	Filter  string `json:"filter,omitempty"`
+}
+
+// handleTargets lists targets with GET, adds target with POST and
+// removes target given by id query parameter with DELETE. Every
+// request including rejected ones is recorded in audit log.
+func handleTargets(w http.ResponseWriter, r *http.Request) {
+	intercept.Lock()
+	defer intercept.Unlock()
+	if !authorized(r) {
+		auditLog(r, r.Method, "", "unauthorized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	targets := getTargets()
+	switch r.Method {
+	case http.MethodGet:
+		if !auditLog(r, "list", "", "ok") {
+			http.Error(w, "Audit log failure", http.StatusInternalServerError)
+			return
+		}
+		views := []targetView{}
+		for _, t := range targets {
+			v := targetView{ID: t.ID, Port: t.Port}
+			if t.Filter != nil {
+				v.Filter = t.Filter.String()
+			}
+			if t.Private != 0 {
+				v.Private = t.Private.String()
+			}
+			if t.Public != 0 {
+				v.Public = t.Public.String()
+			}
+			views = append(views, v)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(views)
+	case http.MethodPost:
+		var req targetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" ||
+			(req.Private == 0 && req.Public == 0) || (req.Port != 0 && req.Public == 0) {
+			auditLog(r, "add", req.ID, "bad-request")
+			http.Error(w, "Target should have id and either private or public address", http.StatusBadRequest)
+			return
+		}
+		var filter *pktfilter.Filter
+		if req.Filter != "" {
+			var err error
+			if filter, err = pktfilter.Compile(req.Filter); err != nil {
+				auditLog(r, "add", req.ID, "bad-filter")
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		for _, t := range targets {
+			if t.ID == req.ID {
+				auditLog(r, "add", req.ID, "exists")
+				http.Error(w, "Target already exists", http.StatusConflict)
+				return
+			}
+		}
+		if !auditLog(r, "add", req.ID, "ok") {
+			http.Error(w, "Audit log failure", http.StatusInternalServerError)
+			return
+		}
+		next := append(append([]interceptTarget(nil), targets...),
+			interceptTarget{ID: req.ID, Private: req.Private, Public: req.Public, Port: req.Port, Filter: filter})
+		intercept.targets.Store(next)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		next := make([]interceptTarget, 0, len(targets))
+		for _, t := range targets {
+			if t.ID != id {
+				next = append(next, t)
+			}
+		}
+		if len(next) == len(targets) {
+			auditLog(r, "remove", id, "not-found")
+			http.Error(w, "Target not found", http.StatusNotFound)
+			return
+		}
+		if !auditLog(r, "remove", id, "ok") {
+			http.Error(w, "Audit log failure", http.StatusInternalServerError)
+			return
+		}
+		intercept.targets.Store(next)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		auditLog(r, r.Method, "", "bad-method")
+		http.Error(w, "Targets are listed with GET, added with POST and removed with DELETE", http.StatusMethodNotAllowed)
+	}
+}
+
+type interceptStats struct {
+	Sent    uint64 `json:"sent"`
+	Lost    uint64 `json:"lost"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// handleInterceptStats returns records sent to mediation device,
+// records lost because of connection errors and packets which weren't
+// copied because sender fell behind.
+func handleInterceptStats(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		intercept.Lock()
+		auditLog(r, "stats", "", "unauthorized")
+		intercept.Unlock()
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(interceptStats{
+		Sent:    atomic.LoadUint64(&intercept.stats.sent),
+		Lost:    atomic.LoadUint64(&intercept.stats.lost),
+		Dropped: atomic.LoadUint64(&intercept.stats.dropped),
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !nat_minimal,!no_ipv6
+
+package egressgw
+
+import (
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/examples/egressgw/rewrite"
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	registerModule("ipv6", startIPv6)
+}
+
+// Neighbor Discovery packets are handled by gateway and aren't
+// forwarded
+var dropND flow.DropReason
+
+// IPv6 hosts which are routed without translation
+var ndProxy *packet.NDProxy
+
+// startIPv6 enables routing of IPv6 hosts proxied by ND proxy.
+func startIPv6() error {
+	var err error
+	if dropND, err = flow.RegisterDropReason("nd"); err != nil {
+		return err
+	}
+	ndProxy = packet.NewNDProxy()
+	for _, addr := range GWConfig.NDProxy.Addresses {
+		ndProxy.AddAddress(addr)
+	}
+	for _, subnet := range GWConfig.NDProxy.Subnets {
+		ndProxy.AddSubnet(subnet)
+	}
+	// Gateway answers Neighbor Solicitations for proxied hosts
+	GWConfig.PublicPort.neighCache.SetNDProxy(ndProxy)
+	egress6, ingress6 = egressIPv6, ingressIPv6
+	return nil
+}
+
+// setNextHop6 fills L2 header of IPv6 packet sent to port. Returns
+// DropARPMiss if MAC address of next hop is unknown yet.
+func (port *IpPort) setNextHop6(pkt *packet.Packet, nextHop types.IPv6Address) flow.DropReason {
+	mac, found := port.neighCache.LookupMACForIPv6(nextHop)
+	if !found {
+		port.neighCache.SendNeighborSolicitationForIPv6(nextHop, port.Address6, 0)
+		return flow.DropARPMiss
+	}
+	pkt.Ether.SAddr = port.macAddress
+	pkt.Ether.DAddr = mac
+	return flow.Pass
+}
+
+// isNeighborDiscovery returns true for Neighbor Solicitation and
+// Neighbor Advertisement packets.
+func isNeighborDiscovery(pkt *packet.Packet, ipv6 *packet.IPv6Hdr) bool {
+	if ipv6.Proto != types.ICMPv6Number {
+		return false
+	}
+	pkt.ParseL4ForIPv6()
+	icmp := pkt.GetICMPForIPv6()
+	return icmp.Type == types.ICMPv6NeighborSolicitation || icmp.Type == types.ICMPv6NeighborAdvertisement
+}
+
+func handleND(pkt *packet.Packet, port *IpPort) {
+	if err := port.neighCache.HandleIPv6NeighborDiscoveryPacket(pkt); err != nil {
+		// Solicitations for other hosts of network are normal
+		common.LogDebug(common.Debug, err)
+	}
+}
+
+// forward6 routes IPv6 packet to port without translation.
+func forward6(pkt *packet.Packet, port *IpPort, nextHop types.IPv6Address) flow.DropReason {
+	if !rewrite.DecrementHopLimit(l3Bytes(pkt)) {
+		return flow.DropOther
+	}
+	return port.setNextHop6(pkt, nextHop)
+}
+
+// egressIPv6 routes IPv6 packets of proxied hosts to external networks.
+func egressIPv6(pkt *packet.Packet, ipv6 *packet.IPv6Hdr) flow.DropReason {
+	if isNeighborDiscovery(pkt, ipv6) {
+		handleND(pkt, &GWConfig.PrivatePort)
+		return dropND
+	}
+	if !ndProxy.Contains(ipv6.SrcAddr) {
+		return flow.DropACLDeny
+	}
+	return forward6(pkt, &GWConfig.PublicPort, GWConfig.PublicPort.Gateway6)
+}
+
+// ingressIPv6 routes IPv6 packets to proxied hosts which are directly
+// connected to private port.
+func ingressIPv6(pkt *packet.Packet, ipv6 *packet.IPv6Hdr) flow.DropReason {
+	if isNeighborDiscovery(pkt, ipv6) {
+		handleND(pkt, &GWConfig.PublicPort)
+		return dropND
+	}
+	if !ndProxy.Contains(ipv6.DstAddr) {
+		return flow.DropNoTranslation
+	}
+	return forward6(pkt, &GWConfig.PrivatePort, ipv6.DstAddr)
+}
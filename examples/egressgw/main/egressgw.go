@@ -0,0 +1,61 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/intel-go/nff-go/flow"
+
+	"github.com/intel-go/nff-go/examples/egressgw"
+)
+
+// vdevs collects all -vdev options
+type vdevs []string
+
+func (v *vdevs) String() string {
+	return strings.Join(*v, " ")
+}
+
+func (v *vdevs) Set(s string) error {
+	*v = append(*v, s)
+	return nil
+}
+
+func main() {
+	var devices vdevs
+	cores := flag.String("cores", "", "Specify CPU cores to use.")
+	configFile := flag.String("config", "config.json", "Specify config file name.")
+	noscheduler := flag.Bool("no-scheduler", false, "Disable scheduler.")
+	dpdkLogLevel := flag.String("dpdk", "--log-level=0", "Passes an arbitrary argument to dpdk EAL.")
+	flag.Var(&devices, "vdev", "Adds DPDK virtual device, for example net_af_xdp0,iface=eth1 for AF_XDP attachment. Can be repeated.")
+	modules := flag.Bool("modules", false, "Print modules compiled in gateway and exit.")
+	flag.Parse()
+
+	if *modules {
+		fmt.Println(strings.Join(egressgw.Modules(), " "))
+		return
+	}
+
+	// Read config
+	flow.CheckFatal(egressgw.ReadConfig(*configFile))
+
+	dpdkArgs := []string{*dpdkLogLevel}
+	for _, d := range devices {
+		dpdkArgs = append(dpdkArgs, "--vdev="+d)
+	}
+	nffgoconfig := flow.Config{
+		CPUList:          *cores,
+		DPDKArgs:         dpdkArgs,
+		DisableScheduler: *noscheduler,
+		ByteAccounting:   egressgw.GWConfig.GetByteAccounting(),
+	}
+
+	flow.CheckFatal(flow.SystemInit(&nffgoconfig))
+	egressgw.InitFlows()
+	flow.CheckFatal(flow.SystemStart())
+}
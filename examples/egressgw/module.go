@@ -0,0 +1,80 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package egressgw
+
+import (
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/flow"
+)
+
+// module is an optional subsystem of gateway. Modules register
+// themselves in init functions of files which are compiled unless
+// build tags exclude them, so minimal build profiles produce smaller
+// binaries without changes of the rest of gateway. Modules are started
+// in order of registration after ports are initialized. Modules can
+// also add stages to flows of both directions, stages handle packets
+// after translation before they are sent to ports.
+type module struct {
+	name  string
+	start func() error
+	stage func(f *flow.Flow, dir int) (*flow.Flow, error)
+}
+
+var modules []module
+
+func registerModule(name string, start func() error) {
+	modules = append(modules, module{name: name, start: start})
+}
+
+func registerStage(name string, stage func(f *flow.Flow, dir int) (*flow.Flow, error)) {
+	modules = append(modules, module{name: name, stage: stage})
+}
+
+// hasModule returns true if module is compiled in.
+func hasModule(name string) bool {
+	for i := range modules {
+		if modules[i].name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func startModules() error {
+	for i := range modules {
+		if modules[i].start == nil {
+			continue
+		}
+		common.LogDebug(common.Initialization, "Starting module", modules[i].name)
+		if err := modules[i].start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addStages adds stages of modules to flow of direction dir and
+// returns flow which should be sent to port.
+func addStages(f *flow.Flow, dir int) (*flow.Flow, error) {
+	var err error
+	for i := range modules {
+		if modules[i].stage == nil {
+			continue
+		}
+		if f, err = modules[i].stage(f, dir); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Modules returns names of modules compiled in gateway.
+func Modules() []string {
+	names := make([]string, len(modules))
+	for i := range modules {
+		names[i] = modules[i].name
+	}
+	return names
+}
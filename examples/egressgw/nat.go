@@ -0,0 +1,293 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package egressgw
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// natKey is an address, port and protocol of one side of binding.
+// Port is stored in the same byte order as in packet headers.
+type natKey struct {
+	addr  types.IPv4Address
+	port  uint16
+	proto uint8
+}
+
+func (k natKey) String() string {
+	return fmt.Sprintf("%s:%d", k.addr, packet.SwapBytesUint16(k.port))
+}
+
+// Directions of counters
+const (
+	egressDir  = 0
+	ingressDir = 1
+)
+
+// counters of translated packets and bytes in egress and ingress
+// directions, accessed atomically.
+type counters struct {
+	packets [2]uint64
+	bytes   [2]uint64
+}
+
+func (c *counters) add(dir int, bytes uint64) {
+	atomic.AddUint64(&c.packets[dir], 1)
+	atomic.AddUint64(&c.bytes[dir], bytes)
+}
+
+// binding maps private address and port of pod to public address and
+// port. Binding is endpoint independent, so all connections from the
+// same pod port use the same public port.
+type binding struct {
+	// Unique number of binding which identifies it in debug dumps
+	// and control API
+	session uint64
+	private natKey
+	public  natKey
+	// Time of last packet in nanoseconds, accessed atomically
+	lastUsed int64
+	counters
+	// Counters of pod which owns binding
+	pod *counters
+}
+
+// count adds translated packet to counters of binding and its pod.
+func (b *binding) count(dir int, bytes uint64) {
+	b.add(dir, bytes)
+	b.pod.add(dir, bytes)
+}
+
+// allocatorStats are counters of port allocator which help to tune
+// range of public ports and reuse delay.
+type allocatorStats struct {
+	// New bindings
+	Allocations uint64 `json:"allocations"`
+	// Bindings which weren't created because all ports were used
+	Failures uint64 `json:"failures"`
+	// Ports which were tried but were used by other bindings
+	Collisions uint64 `json:"collisions"`
+	// Free ports which were skipped because they were released less
+	// than reuse delay ago
+	ReuseDelayed uint64 `json:"reuse-delayed"`
+	// Ports which were allocated before end of reuse delay because
+	// there were no other free ports
+	ReuseViolations uint64 `json:"reuse-violations"`
+}
+
+var nat struct {
+	sync.Mutex
+	out map[natKey]*binding
+	in  map[natKey]*binding
+	// Next port to try for every range of public ports, key has
+	// public address, protocol and the first port of range
+	next map[natKey]uint16
+	// Counters of every pod which had bindings
+	pods map[types.IPv4Address]*counters
+	// Time in nanoseconds when public port was released, it is kept
+	// only during reuse delay
+	released   map[natKey]int64
+	reuseDelay time.Duration
+	stats      allocatorStats
+	// Session number of last binding
+	lastSession uint64
+}
+
+func initNAT(reuseDelay time.Duration) {
+	nat.out = make(map[natKey]*binding)
+	nat.in = make(map[natKey]*binding)
+	nat.next = make(map[natKey]uint16)
+	nat.pods = make(map[types.IPv4Address]*counters)
+	nat.released = make(map[natKey]int64)
+	nat.reuseDelay = reuseDelay
+}
+
+func (b *binding) touch(now time.Time) {
+	atomic.StoreInt64(&b.lastUsed, now.UnixNano())
+}
+
+func (b *binding) remove(now time.Time) {
+	delete(nat.out, b.private)
+	delete(nat.in, b.public)
+	if nat.reuseDelay != 0 {
+		nat.released[b.public] = now.UnixNano()
+	}
+}
+
+// bind creates binding of private address and port to public key.
+// Next is a port which should be tried first by next allocation from
+// the same pool.
+func bind(private, public, pool natKey, next uint16, now time.Time) *binding {
+	pod, ok := nat.pods[private.addr]
+	if !ok {
+		pod = new(counters)
+		nat.pods[private.addr] = pod
+	}
+	nat.lastSession++
+	b := &binding{session: nat.lastSession, private: private, public: public, pod: pod}
+	b.touch(now)
+	nat.out[private] = b
+	nat.in[public] = b
+	nat.next[pool] = next
+	delete(nat.released, public)
+	nat.stats.Allocations++
+	return b
+}
+
+// allocate returns binding of private address and port to given
+// public address and port from range [portMin, portMax]. If binding
+// exists but uses other public address or port because policy was
+// changed, it is replaced. Ports released less than reuse delay ago
+// are used only if there are no other free ports. In cluster mode only
+// ports of this node are allocated. Returns nil if all public ports of
+// range are used.
+func allocate(private natKey, public types.IPv4Address, portMin, portMax uint16, now time.Time) *binding {
+	nat.Lock()
+	defer nat.Unlock()
+	if b, ok := nat.out[private]; ok {
+		port := packet.SwapBytesUint16(b.public.port)
+		if b.public.addr == public && port >= portMin && port <= portMax {
+			b.touch(now)
+			return b
+		}
+		b.remove(now)
+	}
+	pool := natKey{addr: public, port: portMin, proto: private.proto}
+	port, ok := nat.next[pool]
+	if !ok {
+		port = portMin
+	}
+	var delayed *natKey
+	var delayedNext uint16
+	for i := uint32(0); i <= uint32(portMax-portMin); i++ {
+		key := natKey{addr: public, port: packet.SwapBytesUint16(port), proto: private.proto}
+		owned := ownsPort(port)
+		if port == portMax {
+			port = portMin
+		} else {
+			port++
+		}
+		if !owned {
+			continue
+		}
+		if _, used := nat.in[key]; used {
+			nat.stats.Collisions++
+			continue
+		}
+		if released, ok := nat.released[key]; ok && now.UnixNano()-released < int64(nat.reuseDelay) {
+			nat.stats.ReuseDelayed++
+			if delayed == nil {
+				delayed, delayedNext = &key, port
+			}
+			continue
+		}
+		return bind(private, key, pool, port, now)
+	}
+	if delayed != nil {
+		nat.stats.ReuseViolations++
+		return bind(private, *delayed, pool, delayedNext, now)
+	}
+	nat.stats.Failures++
+	return nil
+}
+
+// lookupPublic returns binding which owns public address and port.
+func lookupPublic(public natKey, now time.Time) *binding {
+	nat.Lock()
+	b := nat.in[public]
+	nat.Unlock()
+	if b != nil {
+		b.touch(now)
+	}
+	return b
+}
+
+// expireBindings removes bindings which weren't used after given time.
+func expireBindings(before time.Time) {
+	limit := before.UnixNano()
+	now := time.Now()
+	nat.Lock()
+	for _, b := range nat.out {
+		if atomic.LoadInt64(&b.lastUsed) < limit {
+			b.remove(now)
+		}
+	}
+	for key, released := range nat.released {
+		if now.UnixNano()-released >= int64(nat.reuseDelay) {
+			delete(nat.released, key)
+		}
+	}
+	nat.Unlock()
+}
+
+// bindingFilter selects bindings for bulk operations. Subnet and
+// range of ports match either private or public side of binding.
+type bindingFilter struct {
+	// Zero mask matches all addresses
+	subnet types.IPv4Subnet
+	// Zero protocol matches all protocols
+	proto uint8
+	// Range of ports in host byte order
+	portMin, portMax uint16
+}
+
+func (f *bindingFilter) matchKey(k natKey) bool {
+	port := packet.SwapBytesUint16(k.port)
+	return f.subnet.CheckIPv4AddressWithinSubnet(k.addr) && port >= f.portMin && port <= f.portMax
+}
+
+func (f *bindingFilter) match(b *binding) bool {
+	if f.proto != 0 && b.private.proto != f.proto {
+		return false
+	}
+	return f.matchKey(b.private) || f.matchKey(b.public)
+}
+
+// deleteBindings removes bindings which match filter and returns
+// their number. Bindings are removed by batches, lock is released and
+// pause is made after every batch, so removal of many bindings
+// doesn't stall packet processing. If dryRun is true, bindings are
+// only counted.
+func deleteBindings(f *bindingFilter, batch int, pause time.Duration, dryRun bool) int {
+	var keys []natKey
+	nat.Lock()
+	for key, b := range nat.out {
+		if f.match(b) {
+			keys = append(keys, key)
+		}
+	}
+	nat.Unlock()
+	if dryRun {
+		return len(keys)
+	}
+	deleted := 0
+	for len(keys) != 0 {
+		n := batch
+		if n > len(keys) {
+			n = len(keys)
+		}
+		now := time.Now()
+		nat.Lock()
+		for _, key := range keys[:n] {
+			// Binding could be replaced after keys were collected
+			if b, ok := nat.out[key]; ok && f.match(b) {
+				b.remove(now)
+				deleted++
+			}
+		}
+		nat.Unlock()
+		keys = keys[n:]
+		if len(keys) != 0 {
+			time.Sleep(pause)
+		}
+	}
+	return deleted
+}
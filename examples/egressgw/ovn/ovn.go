@@ -0,0 +1,291 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Ovn periodically reads NAT rules of OVN logical routers with
+// ovn-nbctl and applies equivalent policy to egress gateway by its
+// control server. SNAT rules with the same external address become
+// namespace with this public address, or deterministic mapping if
+// there is only one rule of subnet. Gateway translates only
+// connections which are opened from private side, so DNAT rules and
+// DNAT part of dnat_and_snat rules are skipped.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intel-go/nff-go/examples/egressgw/policy"
+)
+
+// natRule is a row of NAT table of OVN northbound database.
+type natRule struct {
+	UUID       string
+	Type       string
+	LogicalIP  string
+	ExternalIP string
+}
+
+var nbctl, db *string
+
+func runNbctl(args ...string) ([]byte, error) {
+	if *db != "" {
+		args = append([]string{"--db=" + *db}, args...)
+	}
+	return exec.Command(*nbctl, args...).Output()
+}
+
+// listNAT returns NAT rules of router or of all routers if router is
+// empty. Rows are printed by ovn-nbctl as arrays of columns, UUID is
+// printed as ["uuid", value] pair.
+func listNAT(router string) ([]natRule, error) {
+	out, err := runNbctl("--format=json", "--columns=_uuid,type,logical_ip,external_ip", "list", "NAT")
+	if err != nil {
+		return nil, err
+	}
+	var table struct {
+		Data [][]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(out, &table); err != nil {
+		return nil, err
+	}
+	var rules []natRule
+	for _, row := range table.Data {
+		if len(row) != 4 {
+			return nil, fmt.Errorf("NAT row has %d columns instead of 4", len(row))
+		}
+		var uuid [2]string
+		var r natRule
+		for i, v := range []interface{}{&uuid, &r.Type, &r.LogicalIP, &r.ExternalIP} {
+			if err := json.Unmarshal(row[i], v); err != nil {
+				return nil, err
+			}
+		}
+		r.UUID = uuid[1]
+		rules = append(rules, r)
+	}
+	if router == "" {
+		return rules, nil
+	}
+
+	out, err = runNbctl("--bare", "--columns=nat", "list", "Logical_Router", router)
+	if err != nil {
+		return nil, err
+	}
+	own := make(map[string]bool)
+	for _, uuid := range strings.Fields(string(out)) {
+		own[uuid] = true
+	}
+	filtered := rules[:0]
+	for _, r := range rules {
+		if own[r.UUID] {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered, nil
+}
+
+// addressRules are NAT rules with one external address.
+type addressRules struct {
+	hosts   []string
+	subnets []natRule
+}
+
+// subnetSize returns number of addresses in IPv4 subnet.
+func subnetSize(subnet string) int {
+	_, ipnet, _ := net.ParseCIDR(subnet)
+	ones, bits := ipnet.Mask.Size()
+	return 1 << uint(bits-ones)
+}
+
+// appendSubnetHosts appends all addresses of IPv4 subnet to hosts.
+func appendSubnetHosts(hosts []string, subnet string) []string {
+	_, ipnet, _ := net.ParseCIDR(subnet)
+	first := binary.BigEndian.Uint32(ipnet.IP.To4())
+	ip := make(net.IP, net.IPv4len)
+	for i := 0; i < subnetSize(subnet); i++ {
+		binary.BigEndian.PutUint32(ip, first+uint32(i))
+		hosts = append(hosts, ip.String())
+	}
+	return hosts
+}
+
+// buildPolicy replaces namespaces and deterministic mappings of base
+// policy with ones made from NAT rules. Default address and routes
+// are kept. Gateway doesn't allow to share public address of
+// deterministic mapping, so only external address with one subnet
+// rule becomes deterministic mapping if ports from portMin to portMax
+// are enough for the subnet. Other external addresses become
+// namespaces, subnets of their rules are expanded to pods if they
+// have at most maxHosts addresses. It returns reasons of skipped
+// rules.
+func buildPolicy(base *policy.Policy, rules []natRule, maxHosts int, portMin, portMax uint16) (*policy.Policy, []string) {
+	p := &policy.Policy{DefaultAddress: base.DefaultAddress, Routes: base.Routes}
+	groups := make(map[string]*addressRules)
+	var skipped []string
+	for _, r := range rules {
+		if r.Type != "snat" && r.Type != "dnat_and_snat" {
+			skipped = append(skipped, r.UUID+": "+r.Type+" rules are not translated by gateway")
+			continue
+		}
+		if ip := net.ParseIP(r.ExternalIP); ip == nil || ip.To4() == nil {
+			skipped = append(skipped, r.UUID+": external address "+r.ExternalIP+" is not IPv4")
+			continue
+		}
+		g := groups[r.ExternalIP]
+		if g == nil {
+			g = new(addressRules)
+			groups[r.ExternalIP] = g
+		}
+		if ip, ipnet, err := net.ParseCIDR(r.LogicalIP); err == nil {
+			if ones, bits := ipnet.Mask.Size(); ip.To4() != nil && ones != bits {
+				r.LogicalIP = ipnet.String()
+				g.subnets = append(g.subnets, r)
+				continue
+			}
+			r.LogicalIP = ip.String()
+		}
+		if ip := net.ParseIP(r.LogicalIP); ip == nil || ip.To4() == nil {
+			skipped = append(skipped, r.UUID+": logical address "+r.LogicalIP+" is not IPv4")
+			continue
+		}
+		g.hosts = append(g.hosts, r.LogicalIP)
+	}
+
+	for address, g := range groups {
+		if len(g.hosts) == 0 && len(g.subnets) == 1 && address != base.DefaultAddress {
+			d := policy.Deterministic{Private: g.subnets[0].LogicalIP, Public: address + "/32"}
+			if _, err := d.Compile(portMin, portMax); err == nil {
+				p.Deterministic = append(p.Deterministic, d)
+				continue
+			}
+		}
+		for _, r := range g.subnets {
+			if subnetSize(r.LogicalIP) > maxHosts {
+				skipped = append(skipped, fmt.Sprintf("%s: subnet %s can't be mapped to %s, it has more than %d addresses",
+					r.UUID, r.LogicalIP, address, maxHosts))
+				continue
+			}
+			g.hosts = appendSubnetHosts(g.hosts, r.LogicalIP)
+		}
+		if len(g.hosts) == 0 {
+			continue
+		}
+		// Subnets can contain hosts of other rules
+		sort.Strings(g.hosts)
+		pods := g.hosts[:1]
+		for _, h := range g.hosts[1:] {
+			if h != pods[len(pods)-1] {
+				pods = append(pods, h)
+			}
+		}
+		p.Namespaces = append(p.Namespaces, policy.Namespace{Name: "ovn-" + address, Address: address, Pods: pods})
+	}
+	// Policy is applied only if it is changed, so order should be stable
+	sort.Slice(p.Namespaces, func(i, j int) bool { return p.Namespaces[i].Name < p.Namespaces[j].Name })
+	sort.Slice(p.Deterministic, func(i, j int) bool { return p.Deterministic[i].Private < p.Deterministic[j].Private })
+	sort.Strings(skipped)
+	return p, skipped
+}
+
+func request(method, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", method, url, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+func getState(gateway string) (*policy.Policy, error) {
+	out, err := request(http.MethodGet, gateway+"/state", nil)
+	if err != nil {
+		return nil, err
+	}
+	p := new(policy.Policy)
+	err = json.Unmarshal(out, p)
+	return p, err
+}
+
+func apply(gateway string, p *policy.Policy) (bool, error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return false, err
+	}
+	out, err := request(http.MethodPut, gateway+"/apply", body)
+	if err != nil {
+		return false, err
+	}
+	var result struct {
+		Changed bool `json:"changed"`
+	}
+	err = json.Unmarshal(out, &result)
+	return result.Changed, err
+}
+
+func main() {
+	gateway := flag.String("gateway", "http://127.0.0.1:8081", "Specify URL of gateway control server.")
+	router := flag.String("router", "", "Specify OVN logical router, NAT rules of all routers are used if it is empty.")
+	maxHosts := flag.Int("max-subnet-hosts", 1024, "Specify maximum number of addresses of subnet which shares external address with other rules, such subnets are expanded to namespace pods.")
+	portMin := flag.Uint("port-min", 1024, "Specify the first public port of gateway, it should be the same as port-min of gateway config.")
+	portMax := flag.Uint("port-max", 65535, "Specify the last public port of gateway, it should be the same as port-max of gateway config.")
+	interval := flag.Duration("interval", 5*time.Second, "Specify interval of OVN database polling.")
+	nbctl = flag.String("nbctl", "ovn-nbctl", "Specify ovn-nbctl command.")
+	db = flag.String("db", "", "Specify OVN northbound database, default database of ovn-nbctl is used if it is empty.")
+	flag.Parse()
+	*gateway = strings.TrimSuffix(*gateway, "/")
+
+	var current *policy.Policy
+	for ; ; time.Sleep(*interval) {
+		rules, err := listNAT(*router)
+		if err != nil {
+			log.Println("Can't read OVN NAT rules:", err)
+			continue
+		}
+		base, err := getState(*gateway)
+		if err != nil {
+			log.Println("Can't read gateway policy:", err)
+			continue
+		}
+		p, skipped := buildPolicy(base, rules, *maxHosts, uint16(*portMin), uint16(*portMax))
+		if reflect.DeepEqual(p, current) {
+			continue
+		}
+		for _, s := range skipped {
+			log.Println("Skipped NAT rule", s)
+		}
+		changed, err := apply(*gateway, p)
+		if err != nil {
+			log.Println("Can't apply policy:", err)
+			continue
+		}
+		if changed {
+			log.Println("Policy updated:", len(p.Namespaces), "namespaces,", len(p.Deterministic),
+				"deterministic mappings,", len(skipped), "rules skipped")
+		}
+		current = p
+	}
+}
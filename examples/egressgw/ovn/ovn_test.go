@@ -0,0 +1,54 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/examples/egressgw/policy"
+)
+
+// Several logical switches are usually translated to one gateway
+// address of router. Gateway rejects policy which has several
+// deterministic mappings with one public address, so such subnets
+// should become one namespace.
+func TestBuildPolicySharedAddress(t *testing.T) {
+	rules := []natRule{
+		{UUID: "a", Type: "snat", LogicalIP: "10.0.0.0/24", ExternalIP: "172.16.0.10"},
+		{UUID: "b", Type: "snat", LogicalIP: "10.0.1.0/24", ExternalIP: "172.16.0.10"},
+		{UUID: "c", Type: "dnat_and_snat", LogicalIP: "10.0.0.5", ExternalIP: "172.16.0.10"},
+		{UUID: "d", Type: "snat", LogicalIP: "10.1.0.0/16", ExternalIP: "172.16.0.10"},
+		{UUID: "e", Type: "snat", LogicalIP: "10.2.0.0/22", ExternalIP: "172.16.0.11"},
+		{UUID: "f", Type: "dnat", LogicalIP: "10.0.0.6", ExternalIP: "172.16.0.12"},
+	}
+	base := &policy.Policy{DefaultAddress: "172.16.0.1"}
+	p, skipped := buildPolicy(base, rules, 1024, 1024, 65535)
+
+	if len(p.Namespaces) != 1 {
+		t.Fatalf("Expected one namespace, got %+v", p.Namespaces)
+	}
+	ns := p.Namespaces[0]
+	if ns.Address != "172.16.0.10" || len(ns.Pods) != 512 {
+		t.Errorf("Namespace %s has address %s and %d pods, expected 172.16.0.10 and 512 pods", ns.Name, ns.Address, len(ns.Pods))
+	}
+	pods := make(map[string]bool)
+	for _, pod := range ns.Pods {
+		pods[pod] = true
+	}
+	for _, pod := range []string{"10.0.0.0", "10.0.0.5", "10.0.1.255"} {
+		if !pods[pod] {
+			t.Errorf("Pod %s is missing", pod)
+		}
+	}
+	if len(p.Deterministic) != 1 || p.Deterministic[0] != (policy.Deterministic{Private: "10.2.0.0/22", Public: "172.16.0.11/32"}) {
+		t.Errorf("Unexpected deterministic mappings %+v", p.Deterministic)
+	}
+	if len(skipped) != 2 || skipped[0][:2] != "d:" || skipped[1][:2] != "f:" {
+		t.Errorf("Rules d and f should be skipped, got %q", skipped)
+	}
+	if p.DefaultAddress != base.DefaultAddress {
+		t.Errorf("Default address %s is not kept", p.DefaultAddress)
+	}
+}
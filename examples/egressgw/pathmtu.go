@@ -0,0 +1,67 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package egressgw
+
+import (
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/pmtu"
+)
+
+// Path MTU of external destinations, it is nil if path MTU learning
+// is disabled.
+var pmtuCache *pmtu.Cache
+
+// ICMP Fragmentation Needed messages are consumed by gateway
+var dropPathMTU flow.DropReason
+
+// initPathMTU creates path MTU cache if it is enabled by config.
+func initPathMTU() error {
+	c := &GWConfig.PathMTU
+	if !c.Enabled {
+		return nil
+	}
+	if c.MTU == 0 {
+		c.MTU = 1500
+		if GWConfig.PublicPort.MTU != 0 {
+			c.MTU = uint(GWConfig.PublicPort.MTU)
+		}
+	}
+	if c.MTU < pmtu.MinIPv4MTU || c.MTU > 0xffff {
+		return common.WrapWithNFError(nil, "MTU of path-mtu option should be between 552 and 65535", common.BadArgument)
+	}
+	var err error
+	if dropPathMTU, err = flow.RegisterDropReason("path-mtu"); err != nil {
+		return err
+	}
+	pmtuCache, err = pmtu.NewCache(time.Duration(c.Timeout)*time.Second, c.MaxEntries)
+	return err
+}
+
+// learnPathMTU returns true if packet received on public port is ICMP
+// Fragmentation Needed message. Such messages are about translated
+// packets, so they aren't forwarded to pods, gateway fragments packets
+// and clamps MSS itself.
+func learnPathMTU(ip []byte, now time.Time) bool {
+	return pmtuCache != nil && pmtuCache.Learn(ip, now)
+}
+
+// clampMSS decreases MSS of translated TCP SYN packet, so replies of
+// destination fit into its path MTU.
+func clampMSS(ip []byte, now time.Time) {
+	if pmtuCache != nil {
+		pmtuCache.ClampMSS(ip, uint16(GWConfig.PathMTU.MTU), now)
+	}
+}
+
+// pathMTUEntries returns learned path MTU of destinations.
+func pathMTUEntries(now time.Time) []pmtu.Entry {
+	if pmtuCache == nil {
+		return []pmtu.Entry{}
+	}
+	return pmtuCache.Entries(now)
+}
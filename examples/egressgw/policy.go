@@ -0,0 +1,253 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package egressgw
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/examples/egressgw/policy"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+type route struct {
+	subnet  types.IPv4Subnet
+	gateway types.IPv4Address
+}
+
+// natPolicy is a policy file converted for fast lookups. It is never
+// changed after creation, new policy replaces it as a whole.
+type natPolicy struct {
+	defaultAddress types.IPv4Address
+	// Public address of every pod from namespaces with selected address
+	pods map[types.IPv4Address]types.IPv4Address
+	// All public addresses which gateway should answer ARP requests for
+	public map[types.IPv4Address]bool
+	routes []route
+	// Deterministic mappings of private subnets
+	deterministic []*policy.DeterministicMap
+	// Policy file which was converted
+	source *policy.Policy
+}
+
+var (
+	currentPolicy atomic.Value
+	// Serializes policy reloads and applies
+	policyLock      sync.Mutex
+	policyTimestamp time.Time
+)
+
+func getPolicy() *natPolicy {
+	return currentPolicy.Load().(*natPolicy)
+}
+
+func parseIPv4(s string) (types.IPv4Address, error) {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return 0, fmt.Errorf("Bad IPv4 address %q", s)
+	}
+	return types.BytesToIPv4(ip[0], ip[1], ip[2], ip[3]), nil
+}
+
+func parseSubnet(s string) (types.IPv4Subnet, error) {
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil || ipnet.IP.To4() == nil {
+		return types.IPv4Subnet{}, fmt.Errorf("Bad IPv4 subnet %q", s)
+	}
+	ip, mask := ipnet.IP.To4(), ipnet.Mask
+	return types.IPv4Subnet{
+		Addr: types.BytesToIPv4(ip[0], ip[1], ip[2], ip[3]),
+		Mask: types.BytesToIPv4(mask[0], mask[1], mask[2], mask[3]),
+	}, nil
+}
+
+// ipv4ToHost and hostToIPv4 convert addresses to and from host byte
+// order which is used by deterministic mappings.
+func ipv4ToHost(address types.IPv4Address) uint32 {
+	return uint32(packet.SwapBytesIPv4Addr(address))
+}
+
+func hostToIPv4(address uint32) types.IPv4Address {
+	return packet.SwapBytesIPv4Addr(types.IPv4Address(address))
+}
+
+func compilePolicy(p *policy.Policy) (*natPolicy, error) {
+	np := &natPolicy{
+		pods:   make(map[types.IPv4Address]types.IPv4Address),
+		public: make(map[types.IPv4Address]bool),
+		source: p,
+	}
+	// Ports of deterministic public addresses can't be allocated
+	// dynamically, otherwise subscriber can't be found by port
+	deterministic := make(map[types.IPv4Address]bool)
+	for i := range p.Deterministic {
+		m, err := p.Deterministic[i].Compile(GWConfig.PortMin, GWConfig.PortMax)
+		if err != nil {
+			return nil, err
+		}
+		first, count := m.PublicAddresses()
+		for j := uint32(0); j < count; j++ {
+			address := hostToIPv4(first + j)
+			if deterministic[address] {
+				return nil, fmt.Errorf("Public address %s is used by several deterministic mappings", address)
+			}
+			deterministic[address] = true
+			np.public[address] = true
+		}
+		np.deterministic = append(np.deterministic, m)
+	}
+	var err error
+	if p.DefaultAddress != "" {
+		if np.defaultAddress, err = parseIPv4(p.DefaultAddress); err != nil {
+			return nil, err
+		}
+		if deterministic[np.defaultAddress] {
+			return nil, fmt.Errorf("Default address %s is used by deterministic mapping", np.defaultAddress)
+		}
+		np.public[np.defaultAddress] = true
+	}
+	for _, ns := range p.Namespaces {
+		address, err := parseIPv4(ns.Address)
+		if err != nil {
+			return nil, fmt.Errorf("Namespace %s: %v", ns.Name, err)
+		}
+		if deterministic[address] {
+			return nil, fmt.Errorf("Namespace %s: address %s is used by deterministic mapping", ns.Name, address)
+		}
+		np.public[address] = true
+		for _, pod := range ns.Pods {
+			podAddress, err := parseIPv4(pod)
+			if err != nil {
+				return nil, fmt.Errorf("Namespace %s: %v", ns.Name, err)
+			}
+			np.pods[podAddress] = address
+		}
+	}
+	for _, r := range p.Routes {
+		subnet, err := parseSubnet(r.Subnet)
+		if err != nil {
+			return nil, err
+		}
+		gateway, err := parseIPv4(r.Gateway)
+		if err != nil {
+			return nil, err
+		}
+		np.routes = append(np.routes, route{subnet: subnet, gateway: gateway})
+	}
+	return np, nil
+}
+
+func loadPolicy(fileName string) error {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	return loadPolicyLocked(fileName)
+}
+
+func loadPolicyLocked(fileName string) error {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	p, err := policy.Read(fileName)
+	if err != nil {
+		return err
+	}
+	np, err := compilePolicy(p)
+	if err != nil {
+		return err
+	}
+	currentPolicy.Store(np)
+	policyTimestamp = info.ModTime()
+	common.LogDebug(common.Debug, "Loaded NAT policy with", len(np.pods), "pods and", len(np.public), "public addresses")
+	return nil
+}
+
+// reloadPolicy loads policy file again if it was changed. Current
+// policy is kept if new one is incorrect.
+func reloadPolicy(fileName string) error {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(policyTimestamp) {
+		return nil
+	}
+	return loadPolicyLocked(fileName)
+}
+
+// applyPolicy makes desired policy current and returns changes which
+// were made. Policy file is written only if policy is changed, so
+// applying the same policy again changes nothing. If dryRun is true,
+// changes are only computed.
+func applyPolicy(fileName string, desired *policy.Policy, dryRun bool) ([]policy.Change, error) {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	// Incorrect policy shouldn't be written to file
+	if _, err := compilePolicy(desired); err != nil {
+		return nil, err
+	}
+	changes := policy.Diff(getPolicy().source, desired)
+	if len(changes) == 0 || dryRun {
+		return changes, nil
+	}
+	if err := desired.Write(fileName); err != nil {
+		return nil, err
+	}
+	return changes, loadPolicyLocked(fileName)
+}
+
+// publicAddress returns address and range of ports which should be
+// used for packets from pod, zero address means that pod can't access
+// external networks. Pods from subnets with deterministic mapping get
+// their own block of ports.
+func (np *natPolicy) publicAddress(pod types.IPv4Address) (address types.IPv4Address, portMin, portMax uint16) {
+	host := ipv4ToHost(pod)
+	for _, m := range np.deterministic {
+		if m.Contains(host) {
+			public, portMin, portMax := m.Forward(host)
+			return hostToIPv4(public), portMin, portMax
+		}
+	}
+	if address, ok := np.pods[pod]; ok {
+		return address, GWConfig.PortMin, GWConfig.PortMax
+	}
+	return np.defaultAddress, GWConfig.PortMin, GWConfig.PortMax
+}
+
+// subscriber returns private address which owns public address and
+// port according to deterministic mappings.
+func (np *natPolicy) subscriber(public types.IPv4Address, port uint16) (types.IPv4Address, bool) {
+	for _, m := range np.deterministic {
+		if private, ok := m.Reverse(ipv4ToHost(public), port); ok {
+			return hostToIPv4(private), true
+		}
+	}
+	return 0, false
+}
+
+func (np *natPolicy) isPublic(address types.IPv4Address) bool {
+	return np.public[address]
+}
+
+// nextHop returns address of node which hosts pod.
+func (np *natPolicy) nextHop(pod types.IPv4Address) types.IPv4Address {
+	for i := range np.routes {
+		if np.routes[i].subnet.CheckIPv4AddressWithinSubnet(pod) {
+			return np.routes[i].gateway
+		}
+	}
+	if GWConfig.PrivatePort.Gateway != 0 {
+		return GWConfig.PrivatePort.Gateway
+	}
+	return pod
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Deterministic maps every address of private subnet to fixed public
+// address and block of ports as described in RFC 7422. Subscriber can
+// be found by public address and port without logging of sessions.
+type Deterministic struct {
+	Private string `json:"private"`
+	Public  string `json:"public"`
+	// Number of ports of every private address. If it is zero, ports
+	// of public addresses are divided equally.
+	PortsPerHost uint16 `json:"ports-per-host,omitempty"`
+}
+
+func (d *Deterministic) String() string {
+	return fmt.Sprintf("%s ports-per-host %d", d.Public, d.PortsPerHost)
+}
+
+// ParseDeterministic parses comma separated list of mappings in
+// private=public[:ports-per-host] format, for example
+// "10.0.0.0/22=198.51.100.0/27:1024".
+func ParseDeterministic(s string) ([]Deterministic, error) {
+	var list []Deterministic
+	if s == "" {
+		return list, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(item, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Bad deterministic mapping %q", item)
+		}
+		d := Deterministic{Private: parts[0], Public: parts[1]}
+		if i := strings.Index(d.Public, ":"); i >= 0 {
+			ports, err := strconv.ParseUint(d.Public[i+1:], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("Bad ports per host in deterministic mapping %q", item)
+			}
+			d.Public, d.PortsPerHost = d.Public[:i], uint16(ports)
+		}
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+// DeterministicMap is a compiled deterministic mapping.
+type DeterministicMap struct {
+	private, public uint32
+	privateCount    uint32
+	publicCount     uint32
+	portMin         uint16
+	portsPerHost    uint32
+	hostsPerAddress uint32
+}
+
+func parseRange(s string) (uint32, uint32, error) {
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil || ipnet.IP.To4() == nil {
+		return 0, 0, fmt.Errorf("Bad IPv4 subnet %q", s)
+	}
+	ones, bits := ipnet.Mask.Size()
+	if ones == 0 {
+		return 0, 0, fmt.Errorf("Subnet %q is too large", s)
+	}
+	return binary.BigEndian.Uint32(ipnet.IP.To4()), uint32(1) << uint(bits-ones), nil
+}
+
+// Compile checks that every private address gets a block of ports of
+// public addresses from range of ports [portMin, portMax] and
+// returns mapping.
+func (d *Deterministic) Compile(portMin, portMax uint16) (*DeterministicMap, error) {
+	m := &DeterministicMap{portMin: portMin}
+	var err error
+	if m.private, m.privateCount, err = parseRange(d.Private); err != nil {
+		return nil, err
+	}
+	if m.public, m.publicCount, err = parseRange(d.Public); err != nil {
+		return nil, err
+	}
+	if portMin > portMax {
+		return nil, fmt.Errorf("Bad port range %d-%d", portMin, portMax)
+	}
+	ports := uint32(portMax) - uint32(portMin) + 1
+	m.portsPerHost = uint32(d.PortsPerHost)
+	if m.portsPerHost == 0 {
+		hosts := (m.privateCount + m.publicCount - 1) / m.publicCount
+		m.portsPerHost = ports / hosts
+	}
+	if m.portsPerHost == 0 || m.portsPerHost > ports {
+		return nil, fmt.Errorf("Deterministic mapping of %s: %d ports can't be shared by %d hosts",
+			d.Private, ports*m.publicCount, m.privateCount)
+	}
+	m.hostsPerAddress = ports / m.portsPerHost
+	if uint64(m.hostsPerAddress)*uint64(m.publicCount) < uint64(m.privateCount) {
+		return nil, fmt.Errorf("Deterministic mapping of %s: %d public addresses with %d ports per host are not enough for %d hosts",
+			d.Private, m.publicCount, m.portsPerHost, m.privateCount)
+	}
+	return m, nil
+}
+
+// Contains returns true if private address is mapped. Addresses are
+// in host byte order.
+func (m *DeterministicMap) Contains(private uint32) bool {
+	return private-m.private < m.privateCount
+}
+
+// ContainsPublic returns true if public address is used by mapping.
+func (m *DeterministicMap) ContainsPublic(public uint32) bool {
+	return public-m.public < m.publicCount
+}
+
+// PublicAddresses returns number of public addresses and the first
+// of them.
+func (m *DeterministicMap) PublicAddresses() (first, count uint32) {
+	return m.public, m.publicCount
+}
+
+// Forward returns public address and range of ports of private
+// address.
+func (m *DeterministicMap) Forward(private uint32) (public uint32, portMin, portMax uint16) {
+	index := private - m.private
+	public = m.public + index/m.hostsPerAddress
+	portMin = m.portMin + uint16(index%m.hostsPerAddress*m.portsPerHost)
+	return public, portMin, portMin + uint16(m.portsPerHost-1)
+}
+
+// Reverse returns private address which uses public address and port.
+// Returns false if they don't belong to mapping.
+func (m *DeterministicMap) Reverse(public uint32, port uint16) (uint32, bool) {
+	if !m.ContainsPublic(public) || port < m.portMin {
+		return 0, false
+	}
+	block := uint32(port-m.portMin) / m.portsPerHost
+	if block >= m.hostsPerAddress {
+		return 0, false
+	}
+	index := (public-m.public)*m.hostsPerAddress + block
+	if index >= m.privateCount {
+		return 0, false
+	}
+	return m.private + index, true
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"sort"
+	"strings"
+)
+
+// Change describes difference of one object between two policies.
+type Change struct {
+	// "add", "remove" or "update"
+	Action string `json:"action"`
+	// "default-address", "namespace", "route" or "deterministic"
+	Object string `json:"object"`
+	// Name of namespace, subnet of route or private subnet of
+	// deterministic mapping
+	Name string `json:"name,omitempty"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+func (ns *Namespace) String() string {
+	pods := append([]string(nil), ns.Pods...)
+	sort.Strings(pods)
+	return ns.Address + " [" + strings.Join(pods, " ") + "]"
+}
+
+func change(old, new string) string {
+	switch {
+	case old == "":
+		return "add"
+	case new == "":
+		return "remove"
+	}
+	return "update"
+}
+
+// diffObjects compares objects of two policies which are described
+// by name and string value.
+func diffObjects(object string, old, new map[string]string) []Change {
+	names := make([]string, 0, len(old)+len(new))
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var changes []Change
+	for _, name := range names {
+		if old[name] != new[name] {
+			changes = append(changes, Change{
+				Action: change(old[name], new[name]),
+				Object: object,
+				Name:   name,
+				Old:    old[name],
+				New:    new[name],
+			})
+		}
+	}
+	return changes
+}
+
+// Diff returns changes which turn old policy into new one. Order of
+// namespaces, pods, routes and deterministic mappings is ignored. Old
+// policy can be nil.
+func Diff(old, new *Policy) []Change {
+	if old == nil {
+		old = &Policy{}
+	}
+	var changes []Change
+	if old.DefaultAddress != new.DefaultAddress {
+		changes = append(changes, Change{
+			Action: change(old.DefaultAddress, new.DefaultAddress),
+			Object: "default-address",
+			Old:    old.DefaultAddress,
+			New:    new.DefaultAddress,
+		})
+	}
+	namespaces := func(p *Policy) map[string]string {
+		m := make(map[string]string)
+		for i := range p.Namespaces {
+			m[p.Namespaces[i].Name] = p.Namespaces[i].String()
+		}
+		return m
+	}
+	changes = append(changes, diffObjects("namespace", namespaces(old), namespaces(new))...)
+	routes := func(p *Policy) map[string]string {
+		m := make(map[string]string)
+		for _, r := range p.Routes {
+			m[r.Subnet] = r.Gateway
+		}
+		return m
+	}
+	changes = append(changes, diffObjects("route", routes(old), routes(new))...)
+	deterministic := func(p *Policy) map[string]string {
+		m := make(map[string]string)
+		for i := range p.Deterministic {
+			m[p.Deterministic[i].Private] = p.Deterministic[i].String()
+		}
+		return m
+	}
+	changes = append(changes, diffObjects("deterministic", deterministic(old), deterministic(new))...)
+	return changes
+}
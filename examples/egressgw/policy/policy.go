@@ -0,0 +1,77 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package policy describes NAT policy file which is written by egress
+// gateway controller and is read by egress gateway. Package doesn't
+// depend on DPDK, so controller can be built without it. Addresses
+// are stored as strings in the same format as kubectl prints them.
+package policy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Namespace contains public address selected for pods of Kubernetes
+// namespace.
+type Namespace struct {
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	Pods    []string `json:"pods"`
+}
+
+// Route contains next hop for pod subnet of one node.
+type Route struct {
+	Subnet  string `json:"subnet"`
+	Gateway string `json:"gateway"`
+}
+
+// Policy is a content of policy file.
+type Policy struct {
+	// Public address of pods from namespaces without selected address
+	DefaultAddress string      `json:"default-address"`
+	Namespaces     []Namespace `json:"namespaces"`
+	Routes         []Route     `json:"routes"`
+	// Private subnets with deterministic mapping, it is used instead
+	// of namespace and default addresses
+	Deterministic []Deterministic `json:"deterministic,omitempty"`
+}
+
+// Read reads policy from file.
+func Read(fileName string) (*Policy, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	p := new(Policy)
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Write writes policy to temporary file and renames it, so reader
+// never sees partially written policy.
+func (p *Policy) Write(fileName string) error {
+	data, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), ".policy")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build gofuzz
+
+package rewrite
+
+import (
+	"bytes"
+)
+
+// Fuzz is an entry point of go-fuzz. Translation of any input
+// shouldn't panic, should change only address, port and checksums and
+// should be reversible.
+func Fuzz(data []byte) int {
+	for _, egress := range []bool{true, false} {
+		ip := append([]byte(nil), data...)
+		orig, ok := Translated(ip, egress)
+		if !ok {
+			if Translate(ip, egress, 1, 1) || !bytes.Equal(ip, data) {
+				panic("packet which can't be translated was changed")
+			}
+			continue
+		}
+		if !Translate(ip, egress, 0x0a00710b, 12345) {
+			panic("translatable packet wasn't translated")
+		}
+		if got, _ := Translated(ip, egress); got.Addr != 0x0a00710b || got.Port != 12345 {
+			panic("endpoint wasn't translated")
+		}
+		Translate(ip, egress, orig.Addr, orig.Port)
+		if !bytes.Equal(ip, data) {
+			// Checksum can change between equivalent zero values
+			return 0
+		}
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,204 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package rewrite contains header rewriting of egress gateway. It
+// works with byte slices which start with IP header and doesn't depend
+// on DPDK, so translation can be unit tested and fuzzed without mbufs.
+// Gateway calls these functions with slices of packet data. Checksums
+// are updated incrementally as described in RFC 1624, so payload isn't
+// read.
+package rewrite
+
+import (
+	"encoding/binary"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Offsets of IPv4 header fields
+const (
+	ipv4MinLen      = 20
+	ipv4ProtoOff    = 9
+	ipv4ChecksumOff = 10
+	ipv4SrcOff      = 12
+	ipv4DstOff      = 16
+)
+
+// Offsets of fields of transport headers
+const (
+	srcPortOff      = 0
+	dstPortOff      = 2
+	tcpChecksumOff  = 16
+	tcpMinLen       = 20
+	udpChecksumOff  = 6
+	udpLen          = 8
+	icmpChecksumOff = 2
+	icmpIDOff       = 4
+	icmpEchoLen     = 8
+)
+
+// Offset of hop limit in IPv6 header
+const (
+	ipv6MinLen      = 40
+	ipv6HopLimitOff = 7
+)
+
+// Endpoint is an address and port of one side of connection. Port is
+// in host byte order, it is ICMP echo identifier for ICMP.
+type Endpoint struct {
+	Addr  types.IPv4Address
+	Port  uint16
+	Proto uint8
+}
+
+// l4 returns transport header of IPv4 packet and checks that it is
+// long enough for translation. Fragments except the first one don't
+// have transport header.
+func l4(ip []byte) ([]byte, bool) {
+	if len(ip) < ipv4MinLen || ip[0]>>4 != 4 {
+		return nil, false
+	}
+	hdrLen := int(ip[0]&0xf) * 4
+	if hdrLen < ipv4MinLen || len(ip) < hdrLen {
+		return nil, false
+	}
+	// Fragment offset is not zero
+	if binary.BigEndian.Uint16(ip[6:8])&0x1fff != 0 {
+		return nil, false
+	}
+	l4 := ip[hdrLen:]
+	switch ip[ipv4ProtoOff] {
+	case types.TCPNumber:
+		return l4, len(l4) >= tcpMinLen
+	case types.UDPNumber:
+		return l4, len(l4) >= udpLen
+	case types.ICMPNumber:
+		return l4, len(l4) >= icmpEchoLen
+	}
+	return nil, false
+}
+
+// portOffset returns offset of translated port in transport header.
+// Source port is translated in egress direction and destination port
+// in ingress direction. Only ICMP echo requests are translated in
+// egress direction and only echo replies in ingress direction.
+func portOffset(proto uint8, l4 []byte, egress bool) (int, bool) {
+	switch proto {
+	case types.TCPNumber, types.UDPNumber:
+		if egress {
+			return srcPortOff, true
+		}
+		return dstPortOff, true
+	case types.ICMPNumber:
+		if (egress && l4[0] == types.ICMPTypeEchoRequest) ||
+			(!egress && l4[0] == types.ICMPTypeEchoResponse) {
+			return icmpIDOff, true
+		}
+	}
+	return 0, false
+}
+
+func addrOffset(egress bool) int {
+	if egress {
+		return ipv4SrcOff
+	}
+	return ipv4DstOff
+}
+
+// Translated returns endpoint which is translated: source of egress
+// packet or destination of ingress packet. Returns false if packet
+// can't be translated.
+func Translated(ip []byte, egress bool) (Endpoint, bool) {
+	l4, ok := l4(ip)
+	if !ok {
+		return Endpoint{}, false
+	}
+	proto := ip[ipv4ProtoOff]
+	off, ok := portOffset(proto, l4, egress)
+	if !ok {
+		return Endpoint{}, false
+	}
+	a := addrOffset(egress)
+	return Endpoint{
+		Addr:  types.SliceToIPv4(ip[a : a+4]),
+		Port:  binary.BigEndian.Uint16(l4[off:]),
+		Proto: proto,
+	}, true
+}
+
+// Adjust returns checksum after 16-bit word of checksummed data is
+// changed from old to new value.
+func Adjust(checksum, old, new uint16) uint16 {
+	// ~C' = ~C + ~m + m'
+	sum := uint32(^checksum) + uint32(^old) + uint32(new)
+	sum = (sum & 0xffff) + (sum >> 16)
+	sum = (sum & 0xffff) + (sum >> 16)
+	return ^uint16(sum)
+}
+
+// adjustField updates checksum at checksumOff of data after bytes of
+// field are replaced with new ones. Field length should be even.
+func adjustField(data []byte, checksumOff int, field, new []byte) {
+	checksum := binary.BigEndian.Uint16(data[checksumOff:])
+	for i := 0; i < len(field); i += 2 {
+		checksum = Adjust(checksum, binary.BigEndian.Uint16(field[i:]), binary.BigEndian.Uint16(new[i:]))
+	}
+	binary.BigEndian.PutUint16(data[checksumOff:], checksum)
+}
+
+// Translate replaces translated endpoint of IPv4 packet, which is
+// returned by Translated, with given address and port and updates
+// checksums of IP and transport headers. Zero UDP checksum isn't
+// updated because it means that checksum isn't used. Returns false
+// and doesn't change packet if it can't be translated.
+func Translate(ip []byte, egress bool, addr types.IPv4Address, port uint16) bool {
+	l4, ok := l4(ip)
+	if !ok {
+		return false
+	}
+	proto := ip[ipv4ProtoOff]
+	off, ok := portOffset(proto, l4, egress)
+	if !ok {
+		return false
+	}
+	var newAddr [4]byte
+	binary.LittleEndian.PutUint32(newAddr[:], uint32(addr))
+	var newPort [2]byte
+	binary.BigEndian.PutUint16(newPort[:], port)
+	a := addrOffset(egress)
+	oldAddr := ip[a : a+4]
+
+	switch proto {
+	case types.TCPNumber:
+		// Pseudo header of checksum contains addresses
+		adjustField(l4, tcpChecksumOff, oldAddr, newAddr[:])
+		adjustField(l4, tcpChecksumOff, l4[off:off+2], newPort[:])
+	case types.UDPNumber:
+		if binary.BigEndian.Uint16(l4[udpChecksumOff:]) != 0 {
+			adjustField(l4, udpChecksumOff, oldAddr, newAddr[:])
+			adjustField(l4, udpChecksumOff, l4[off:off+2], newPort[:])
+			// Zero is transmitted as all ones
+			if binary.BigEndian.Uint16(l4[udpChecksumOff:]) == 0 {
+				binary.BigEndian.PutUint16(l4[udpChecksumOff:], 0xffff)
+			}
+		}
+	case types.ICMPNumber:
+		adjustField(l4, icmpChecksumOff, l4[off:off+2], newPort[:])
+	}
+	adjustField(ip, ipv4ChecksumOff, oldAddr, newAddr[:])
+	copy(oldAddr, newAddr[:])
+	copy(l4[off:], newPort[:])
+	return true
+}
+
+// DecrementHopLimit decrements hop limit of IPv6 packet. Returns false
+// and doesn't change packet if hop limit is exhausted and packet
+// shouldn't be forwarded.
+func DecrementHopLimit(ip []byte) bool {
+	if len(ip) < ipv6MinLen || ip[ipv6HopLimitOff] <= 1 {
+		return false
+	}
+	ip[ipv6HopLimitOff]--
+	return true
+}
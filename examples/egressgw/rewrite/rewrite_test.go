@@ -0,0 +1,206 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rewrite
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func sum(data []byte, initial uint32) uint32 {
+	s := initial
+	for i := 0; i+1 < len(data); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 != 0 {
+		s += uint32(data[len(data)-1]) << 8
+	}
+	return s
+}
+
+func fold(s uint32) uint16 {
+	for s>>16 != 0 {
+		s = (s & 0xffff) + (s >> 16)
+	}
+	return ^uint16(s)
+}
+
+// checksums returns checksums of IP header and transport header which
+// are computed from scratch.
+func checksums(ip []byte) (uint16, uint16) {
+	hdr := append([]byte(nil), ip[:ipv4MinLen]...)
+	hdr[ipv4ChecksumOff], hdr[ipv4ChecksumOff+1] = 0, 0
+	l4 := append([]byte(nil), ip[ipv4MinLen:]...)
+	var l4sum uint32
+	var off int
+	switch ip[ipv4ProtoOff] {
+	case types.TCPNumber, types.UDPNumber:
+		l4sum = sum(ip[ipv4SrcOff:ipv4DstOff+4], uint32(ip[ipv4ProtoOff])+uint32(len(l4)))
+		off = tcpChecksumOff
+		if ip[ipv4ProtoOff] == types.UDPNumber {
+			off = udpChecksumOff
+		}
+	case types.ICMPNumber:
+		off = icmpChecksumOff
+	}
+	l4[off], l4[off+1] = 0, 0
+	return fold(sum(hdr, 0)), fold(sum(l4, l4sum))
+}
+
+func packet(proto uint8, l4 []byte) []byte {
+	ip := make([]byte, ipv4MinLen+len(l4))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)))
+	ip[8] = 64
+	ip[ipv4ProtoOff] = proto
+	copy(ip[ipv4SrcOff:], []byte{10, 244, 1, 5})
+	copy(ip[ipv4DstOff:], []byte{93, 184, 216, 34})
+	copy(ip[ipv4MinLen:], l4)
+	ipSum, l4Sum := checksums(ip)
+	binary.BigEndian.PutUint16(ip[ipv4ChecksumOff:], ipSum)
+	switch proto {
+	case types.TCPNumber:
+		binary.BigEndian.PutUint16(ip[ipv4MinLen+tcpChecksumOff:], l4Sum)
+	case types.UDPNumber:
+		binary.BigEndian.PutUint16(ip[ipv4MinLen+udpChecksumOff:], l4Sum)
+	case types.ICMPNumber:
+		binary.BigEndian.PutUint16(ip[ipv4MinLen+icmpChecksumOff:], l4Sum)
+	}
+	return ip
+}
+
+func tcp() []byte {
+	l4 := make([]byte, 24)
+	binary.BigEndian.PutUint16(l4[srcPortOff:], 40000)
+	binary.BigEndian.PutUint16(l4[dstPortOff:], 443)
+	l4[12] = 5 << 4
+	copy(l4[20:], "data")
+	return l4
+}
+
+func udp() []byte {
+	l4 := make([]byte, 13)
+	binary.BigEndian.PutUint16(l4[srcPortOff:], 5353)
+	binary.BigEndian.PutUint16(l4[dstPortOff:], 53)
+	binary.BigEndian.PutUint16(l4[4:], 13)
+	copy(l4[8:], "query")
+	return l4
+}
+
+func icmp(typ uint8) []byte {
+	l4 := make([]byte, 16)
+	l4[0] = typ
+	binary.BigEndian.PutUint16(l4[icmpIDOff:], 0x1234)
+	binary.BigEndian.PutUint16(l4[6:], 1)
+	return l4
+}
+
+func TestTranslate(t *testing.T) {
+	public := types.BytesToIPv4(203, 0, 113, 10)
+	tests := []struct {
+		name   string
+		proto  uint8
+		l4     []byte
+		egress bool
+		want   Endpoint
+	}{
+		{"tcp egress", types.TCPNumber, tcp(), true,
+			Endpoint{types.BytesToIPv4(10, 244, 1, 5), 40000, types.TCPNumber}},
+		{"tcp ingress", types.TCPNumber, tcp(), false,
+			Endpoint{types.BytesToIPv4(93, 184, 216, 34), 443, types.TCPNumber}},
+		{"udp odd length", types.UDPNumber, udp(), true,
+			Endpoint{types.BytesToIPv4(10, 244, 1, 5), 5353, types.UDPNumber}},
+		{"echo request", types.ICMPNumber, icmp(types.ICMPTypeEchoRequest), true,
+			Endpoint{types.BytesToIPv4(10, 244, 1, 5), 0x1234, types.ICMPNumber}},
+		{"echo reply", types.ICMPNumber, icmp(types.ICMPTypeEchoResponse), false,
+			Endpoint{types.BytesToIPv4(93, 184, 216, 34), 0x1234, types.ICMPNumber}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip := packet(tt.proto, tt.l4)
+			got, ok := Translated(ip, tt.egress)
+			if !ok || got != tt.want {
+				t.Fatalf("Translated() = %v, %v, want %v", got, ok, tt.want)
+			}
+			for _, port := range []uint16{1024, 0xffff, 0, got.Port} {
+				if !Translate(ip, tt.egress, public, port) {
+					t.Fatal("Translate() failed")
+				}
+				got, _ := Translated(ip, tt.egress)
+				want := Endpoint{public, port, tt.proto}
+				if got != want {
+					t.Fatalf("translated to %v, want %v", got, want)
+				}
+				ipSum, l4Sum := checksums(ip)
+				if s := binary.BigEndian.Uint16(ip[ipv4ChecksumOff:]); s != ipSum {
+					t.Errorf("IP checksum %#x, want %#x", s, ipSum)
+				}
+				off := map[uint8]int{types.TCPNumber: tcpChecksumOff, types.UDPNumber: udpChecksumOff, types.ICMPNumber: icmpChecksumOff}[tt.proto]
+				s := binary.BigEndian.Uint16(ip[ipv4MinLen+off:])
+				// Zero and all ones are the same value in one's complement
+				if s != l4Sum && !(s == 0xffff && l4Sum == 0) && !(s == 0 && l4Sum == 0xffff) {
+					t.Errorf("transport checksum %#x, want %#x", s, l4Sum)
+				}
+			}
+		})
+	}
+}
+
+func TestZeroUDPChecksum(t *testing.T) {
+	ip := packet(types.UDPNumber, udp())
+	binary.BigEndian.PutUint16(ip[ipv4MinLen+udpChecksumOff:], 0)
+	if !Translate(ip, true, types.BytesToIPv4(203, 0, 113, 10), 2000) {
+		t.Fatal("Translate() failed")
+	}
+	if s := binary.BigEndian.Uint16(ip[ipv4MinLen+udpChecksumOff:]); s != 0 {
+		t.Errorf("zero UDP checksum was changed to %#x", s)
+	}
+}
+
+func TestNotTranslated(t *testing.T) {
+	fragment := packet(types.TCPNumber, tcp())
+	binary.BigEndian.PutUint16(fragment[6:], 100)
+	tests := []struct {
+		name   string
+		ip     []byte
+		egress bool
+	}{
+		{"echo reply egress", packet(types.ICMPNumber, icmp(types.ICMPTypeEchoResponse)), true},
+		{"echo request ingress", packet(types.ICMPNumber, icmp(types.ICMPTypeEchoRequest)), false},
+		{"other protocol", packet(47, make([]byte, 8)), true},
+		{"short tcp", packet(types.TCPNumber, tcp())[:ipv4MinLen+10], true},
+		{"short header", packet(types.TCPNumber, tcp())[:12], true},
+		{"fragment", fragment, true},
+		{"ipv6", append([]byte{0x60}, make([]byte, 59)...), true},
+	}
+	for _, tt := range tests {
+		orig := append([]byte(nil), tt.ip...)
+		if _, ok := Translated(tt.ip, tt.egress); ok {
+			t.Errorf("%s: Translated() succeeded", tt.name)
+		}
+		if Translate(tt.ip, tt.egress, 1, 1) {
+			t.Errorf("%s: Translate() succeeded", tt.name)
+		}
+		if string(orig) != string(tt.ip) {
+			t.Errorf("%s: packet was changed", tt.name)
+		}
+	}
+}
+
+func TestDecrementHopLimit(t *testing.T) {
+	ip := make([]byte, ipv6MinLen)
+	ip[ipv6HopLimitOff] = 2
+	if !DecrementHopLimit(ip) || ip[ipv6HopLimitOff] != 1 {
+		t.Errorf("hop limit 2 wasn't decremented")
+	}
+	if DecrementHopLimit(ip) || ip[ipv6HopLimitOff] != 1 {
+		t.Errorf("packet with hop limit 1 is forwarded")
+	}
+	if DecrementHopLimit(ip[:10]) {
+		t.Errorf("short packet is forwarded")
+	}
+}
@@ -72,9 +72,12 @@ func generate(pkt *packet.Packet, context flow.UserContext) {
 	}
 	for remain != 0 {
 		diff, remain = m(maxPacketSegment, remain)
-		pkt = packet.InitNextPacket(diff, pkt)
-		for i := 0; i < maxPacketSegment; i++ {
-			(*(*[maxPacketSegment]byte)(pkt.Data))[i] = byte(c % 10)
+		segment := pkt.AppendSegment(diff)
+		if segment == nil {
+			return
+		}
+		for i := 0; i < int(diff); i++ {
+			(*(*[maxPacketSegment]byte)(segment.Data))[i] = byte(c % 10)
 			c++
 		}
 	}
@@ -91,10 +94,8 @@ func m(max int, remain int) (uint, int) {
 
 func dump(currentPacket *packet.Packet, context flow.UserContext) {
 	fmt.Println("NEW PACKET")
-	fmt.Printf("BLOCK\n%x\nEND_BLOCK\n", currentPacket.GetRawPacketBytes())
-	for currentPacket.Next != nil {
-		currentPacket = currentPacket.Next
-		fmt.Printf("BLOCK\n%x\nEND_BLOCK\n", currentPacket.GetRawPacketBytes())
+	for ; currentPacket != nil; currentPacket = currentPacket.Next {
+		fmt.Printf("BLOCK\n%x\nEND_BLOCK\n", currentPacket.GetSegmentBytes())
 	}
 	fmt.Println("END PACKET")
 }
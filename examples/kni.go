@@ -10,6 +10,10 @@
 // Other variants of rte_kni.ko configuration can be found here:
 // http://dpdk.org/doc/guides/sample_app_ug/kernel_nic_interface.html
 
+// With --virtio-user option virtio-user exception path is used instead
+// of KNI, it doesn't need rte_kni.ko, but needs /dev/vhost-net. Interface
+// should be set up with "ip link set myKNI up" after start.
+
 // Need to call "ifconfig myKNI 111.111.11.11" while running this example to allow other applications
 // to receive packets from "111.111.11.11" address
 
@@ -31,17 +35,25 @@ func main() {
 	kniport := flag.Uint("kniport", 0, "port for kni")
 	mode := flag.Uint("mode", 0, "0 - for three cores for KNI send/receive/Linux, 1 - for single core for KNI send/receive, 2 - for one core for all KNI")
 	flag.BoolVar(&ping, "ping", false, "use this for pushing only ARP and ICMP packets to KNI")
+	virtio := flag.Bool("virtio-user", false, "use virtio-user exception path instead of KNI")
 	flag.Parse()
 
 	config := flow.Config{
 		// Is required for KNI
-		NeedKNI: true,
+		NeedKNI: !*virtio,
 		CPUList: "0-7",
 	}
 
 	flow.CheckFatal(flow.SystemInit(&config))
-	// port of device, name of device
-	kni, err := flow.CreateKniDevice(uint16(*kniport), "myKNI")
+	var kni *flow.Kni
+	var virtioUser *flow.VirtioUser
+	var err error
+	if *virtio {
+		virtioUser, err = flow.CreateVirtioUserDevice(uint16(*kniport), "myKNI")
+	} else {
+		// port of device, name of device
+		kni, err = flow.CreateKniDevice(uint16(*kniport), "myKNI")
+	}
 	flow.CheckFatal(err)
 
 	inputFlow, err := flow.SetReceiver(uint16(*inport))
@@ -51,14 +63,17 @@ func main() {
 	flow.CheckFatal(err)
 
 	var fromKNIFlow *flow.Flow
-	switch *mode {
-	case 0:
+	switch {
+	case *virtio:
+		fromKNIFlow, err = flow.SetSenderReceiverVirtioUser(toKNIFlow, virtioUser)
+		flow.CheckFatal(err)
+	case *mode == 0:
 		flow.CheckFatal(flow.SetSenderKNI(toKNIFlow, kni))
 		fromKNIFlow = flow.SetReceiverKNI(kni)
-	case 1:
+	case *mode == 1:
 		fromKNIFlow, err = flow.SetSenderReceiverKNI(toKNIFlow, kni, false)
 		flow.CheckFatal(err)
-	case 2:
+	case *mode == 2:
 		fromKNIFlow, err = flow.SetSenderReceiverKNI(toKNIFlow, kni, true)
 		flow.CheckFatal(err)
 	}
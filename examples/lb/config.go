@@ -19,7 +19,10 @@ type NetworkSubnet struct {
 }
 
 type IpPort struct {
-	Index      uint16        `json:"index"`
+	Index uint16 `json:"index"`
+	// Device is PCI address, device name or MAC address of port. If
+	// it is specified, Index is ignored.
+	Device     string        `json:"device"`
 	Subnet     NetworkSubnet `json:"subnet"`
 	neighCache *packet.NeighboursLookupTable
 	macAddress types.MACAddress
@@ -50,6 +53,9 @@ func ReadConfig(fileName string) error {
 }
 
 func InitFlows() {
+	flow.CheckFatal(LBConfig.InputPort.resolve())
+	flow.CheckFatal(LBConfig.TunnelPort.resolve())
+
 	ioFlow, err := flow.SetReceiver(LBConfig.InputPort.Index)
 	flow.CheckFatal(err)
 	flow.CheckFatal(flow.SetHandlerDrop(ioFlow, balancer, nil))
@@ -62,6 +68,18 @@ func InitFlows() {
 	LBConfig.TunnelPort.initPort()
 }
 
+func (port *IpPort) resolve() error {
+	if port.Device == "" {
+		return nil
+	}
+	index, err := flow.ResolvePort(port.Device)
+	if err != nil {
+		return err
+	}
+	port.Index = index
+	return nil
+}
+
 func (port *IpPort) initPort() {
 	port.macAddress = flow.GetPortMACAddress(port.Index)
 	port.neighCache = packet.NewNeighbourTable(port.Index, port.macAddress,
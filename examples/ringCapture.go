@@ -0,0 +1,47 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+
+	"github.com/intel-go/nff-go/flow"
+)
+
+// Example has two modes. Primary process forwards packets from inport
+// to outport and sends copies of them to shared ring. Capture helper
+// is started as DPDK secondary process alongside primary one and
+// writes packets from shared ring to pcap file:
+//
+//	ringCapture -inport 0 -outport 1
+//	ringCapture -secondary -file out.pcap
+func main() {
+	secondary := flag.Bool("secondary", false, "run as capture helper in DPDK secondary process")
+	inport := flag.Uint("inport", 0, "port for receiver")
+	outport := flag.Uint("outport", 1, "port for sender")
+	ring := flag.String("ring", "capture", "name of ring shared by processes")
+	file := flag.String("file", "out.pcap", "file for captured packets")
+	flag.Parse()
+
+	config := flow.Config{
+		SecondaryProcess: *secondary,
+	}
+	flow.CheckFatal(flow.SystemInit(&config))
+
+	if *secondary {
+		captured, err := flow.SetReceiverRing(*ring)
+		flow.CheckFatal(err)
+		flow.CheckFatal(flow.SetSenderFile(captured, *file))
+	} else {
+		input, err := flow.SetReceiver(uint16(*inport))
+		flow.CheckFatal(err)
+		captured, err := flow.SetCopier(input)
+		flow.CheckFatal(err)
+		flow.CheckFatal(flow.SetSenderRing(captured, *ring))
+		flow.CheckFatal(flow.SetSender(input, uint16(*outport)))
+	}
+
+	flow.CheckFatal(flow.SystemStart())
+}
@@ -0,0 +1,39 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// SetHandlerL3ACL adds handler which drops packets denied by rules of
+// acl. Dropped packets are counted with "acl-deny" drop reason in
+// GetDropStats. Rules can be replaced by acl.SetRules or acl reload
+// functions while flow graph is running, each packet is checked by
+// rules which were current when its check started.
+func SetHandlerL3ACL(IN *Flow, acl *packet.L3ACL) error {
+	if acl == nil {
+		return common.WrapWithNFError(nil, "ACL should not be nil", common.BadArgument)
+	}
+	return SetHandlerDropReason(IN, func(pkt *packet.Packet, ctx UserContext) DropReason {
+		if pkt.L3ACLPermit(acl.Rules()) {
+			return Pass
+		}
+		return DropACLDeny
+	}, nil)
+}
+
+// SetSeparatorL3ACL adds separator which keeps packets permitted by
+// rules of acl in IN and sends denied packets to returned flow. Rules
+// can be replaced at runtime like rules of SetHandlerL3ACL.
+func SetSeparatorL3ACL(IN *Flow, acl *packet.L3ACL) (OUT *Flow, err error) {
+	if acl == nil {
+		return nil, common.WrapWithNFError(nil, "ACL should not be nil", common.BadArgument)
+	}
+	return SetSeparator(IN, func(pkt *packet.Packet, ctx UserContext) bool {
+		return pkt.L3ACLPermit(acl.Rules())
+	}, nil)
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// Cores which were requested by SetAffinity for flow functions
+var nodeAffinity = make(map[string][]int)
+
+// SetAffinity pins flow function with given name to given CPU cores.
+// Names of flow functions are the same as in statistics and in
+// DumpGraph output, for example "segment1" or "copy2". Clones of pinned
+// flow function are started only on its cores in given order, so number
+// of its clones is limited by number of cores. Pinned cores are
+// reserved and aren't used by other flow functions, scheduler or
+// stop function. All cores should be from the list of cores which
+// framework is allowed to use, and every core can be used only by one
+// flow function. Function should be called before SystemStart,
+// errors in names and cores are reported by SystemStart.
+func SetAffinity(node string, cores []int) error {
+	if len(cores) == 0 {
+		return common.WrapWithNFError(nil, "Affinity of "+node+" requires at least one core", common.BadArgument)
+	}
+	nodeAffinity[node] = append([]int(nil), cores...)
+	return nil
+}
+
+// applyAffinity binds flow functions to cores requested by SetAffinity
+// and reserves these cores.
+func (scheduler *scheduler) applyAffinity() error {
+	for node, cores := range nodeAffinity {
+		var ff *flowFunction
+		for i := range scheduler.ff {
+			if scheduler.ff[i].name == node {
+				ff = scheduler.ff[i]
+				break
+			}
+		}
+		if ff == nil {
+			return common.WrapWithNFError(nil, "Flow function "+node+" from affinity settings doesn't exist", common.BadArgument)
+		}
+		if ff.fType == comboKNI {
+			return common.WrapWithNFError(nil, "Flow function "+node+" uses KNI core and can't be pinned", common.BadArgument)
+		}
+		ff.cores = ff.cores[:0]
+		for _, id := range cores {
+			index := -1
+			for i := range scheduler.cores {
+				if scheduler.cores[i].id == id {
+					index = i
+					break
+				}
+			}
+			if index == -1 {
+				return common.WrapWithNFError(nil, fmt.Sprintf("Core %d of %s isn't in the list of cores available for framework", id, node), common.NotEnoughCores)
+			}
+			if scheduler.cores[index].reserved || !scheduler.cores[index].isfree {
+				return common.WrapWithNFError(nil, fmt.Sprintf("Core %d of %s is already used", id, node), common.BadArgument)
+			}
+			scheduler.cores[index].reserved = true
+			ff.cores = append(ff.cores, index)
+		}
+	}
+	return nil
+}
+
+// getPinnedCore returns first free core from cores of pinned flow
+// function.
+func (scheduler *scheduler) getPinnedCore(ff *flowFunction) (int, int, error) {
+	for _, i := range ff.cores {
+		if scheduler.cores[i].isfree == true {
+			return scheduler.takeCore(i)
+		}
+	}
+	return 0, 0, common.WrapWithNFError(nil, "All cores of "+ff.name+" are used.", common.NotEnoughCores)
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// BackpressureFunction is called when backpressure is switched on or
+// off. node is a name of flow function which input ring crossed
+// watermark, fill is a fill level of this ring from 0 to 1.
+type BackpressureFunction func(on bool, node string, fill float64)
+
+type backpressure struct {
+	high, low      float64
+	interval       time.Duration
+	callback       BackpressureFunction
+	pauseReceivers bool
+	// Not zero while backpressure is on, accessed atomically
+	active int32
+}
+
+var backpressureState *backpressure
+
+// Receivers from ports don't poll NICs while this flag is not zero.
+var receivePause int32
+
+// SetBackpressure enables backpressure mode. Fill levels of input
+// rings of all flow functions are checked every interval. When any
+// ring is filled above high watermark backpressure is switched on and
+// stays on until all rings are filled below low watermark. Watermarks
+// are fractions of ring size from 0 to 1. If pauseReceivers is true,
+// receivers stop polling ports while backpressure is on, so packets
+// are dropped by NICs instead of being dropped inside flow graph
+// after partial processing. Receivers from OS sockets, XDP and KNI
+// are not paused. callback is called when backpressure is switched on
+// or off if it is not nil. It is called from separate goroutine.
+// Function should be called before SystemStart.
+func SetBackpressure(high, low float64, interval time.Duration, callback BackpressureFunction, pauseReceivers bool) error {
+	if high <= 0 || high > 1 || low < 0 || low > high {
+		return common.WrapWithNFError(nil, "Backpressure watermarks should satisfy 0 <= low <= high <= 1 and high > 0", common.BadArgument)
+	}
+	if interval <= 0 {
+		return common.WrapWithNFError(nil, "Backpressure check interval should be positive", common.BadArgument)
+	}
+	if callback == nil && !pauseReceivers {
+		return common.WrapWithNFError(nil, "Backpressure should either pause receivers or have a callback", common.BadArgument)
+	}
+	backpressureState = &backpressure{
+		high:           high,
+		low:            low,
+		interval:       interval,
+		callback:       callback,
+		pauseReceivers: pauseReceivers,
+	}
+	return nil
+}
+
+// IsBackpressureOn returns true if backpressure is currently on.
+func IsBackpressureOn() bool {
+	return backpressureState != nil && atomic.LoadInt32(&backpressureState.active) != 0
+}
+
+// ringFill returns maximum fill level of input rings of flow function.
+func (ff *flowFunction) ringFill() float64 {
+	var fill float64
+	for _, r := range ff.inputRings() {
+		if c := r.GetRingCapacity(); c != 0 {
+			if f := float64(r.GetRingCount()) / float64(c); f > fill {
+				fill = f
+			}
+		}
+	}
+	return fill
+}
+
+// mostFilled returns flow function with maximum fill level of input
+// rings. Flow functions are read under scheduler lock, because ports
+// can be detached and graph can be updated at the same time.
+func mostFilled() (string, float64) {
+	var name string
+	var max float64
+	schedState.tuningLock.Lock()
+	defer schedState.tuningLock.Unlock()
+	for _, ff := range schedState.ff {
+		if f := ff.ringFill(); f >= max {
+			name, max = ff.name, f
+		}
+	}
+	return name, max
+}
+
+func (bp *backpressure) monitor() {
+	for atomic.LoadInt32(&schedState.stopFlag) == process {
+		time.Sleep(bp.interval)
+		name, fill := mostFilled()
+		active := atomic.LoadInt32(&bp.active) != 0
+		switch {
+		case !active && fill > bp.high:
+			bp.switchTo(true, name, fill)
+		case active && fill < bp.low:
+			bp.switchTo(false, name, fill)
+		}
+	}
+	atomic.StoreInt32(&bp.active, 0)
+	atomic.StoreInt32(&receivePause, 0)
+}
+
+func (bp *backpressure) switchTo(on bool, name string, fill float64) {
+	if on {
+		atomic.StoreInt32(&bp.active, 1)
+		common.LogDebug(common.Debug, "Backpressure is on, input ring of", name, "is", int(fill*100), "% full")
+		if bp.pauseReceivers {
+			atomic.StoreInt32(&receivePause, 1)
+		}
+	} else {
+		common.LogDebug(common.Debug, "Backpressure is off")
+		atomic.StoreInt32(&bp.active, 0)
+		atomic.StoreInt32(&receivePause, 0)
+	}
+	if bp.callback != nil {
+		bp.callback(on, name, fill)
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// BFDConfig is a configuration of single hop asynchronous BFD session
+// over IPv4.
+type BFDConfig struct {
+	// Port which is connected to peer
+	Port         uint16
+	LocalAddress types.IPv4Address
+	PeerAddress  types.IPv4Address
+	// MAC address of peer, it is resolved by Neighbours if it is zero
+	PeerMAC    types.MACAddress
+	Neighbours *packet.NeighboursLookupTable
+	// Desired minimum interval between sent control packets and
+	// required minimum interval between received control packets,
+	// default is 100 milliseconds. Control packets are sent at least
+	// every second while session isn't up.
+	DesiredMinTxInterval  time.Duration
+	RequiredMinRxInterval time.Duration
+	// Detection time multiplier, default is 3
+	DetectMultiplier uint8
+	// Link callbacks of port registered by RegisterLinkCallback are
+	// called when session goes up or down if it is set
+	LinkCallbacks bool
+	// Function which is called when state of session changes
+	StateChanged func(port uint16, peer types.IPv4Address, state uint8)
+}
+
+const (
+	// Granularity of BFD timers
+	bfdTick = time.Millisecond
+	// Interval between control packets while session isn't up
+	bfdSlowInterval      = time.Second
+	bfdDefaultInterval   = 100 * time.Millisecond
+	bfdDefaultDetectMult = 3
+)
+
+type bfdSession struct {
+	sync.Mutex
+	config  BFDConfig
+	mac     types.MACAddress
+	srcPort uint16
+	// Local state variables of RFC 5880
+	state        uint8
+	diag         uint8
+	localDiscr   uint32
+	desiredMinTx time.Duration
+	// Poll sequence is in progress
+	poll bool
+	// Remote state variables of RFC 5880
+	remoteState        uint8
+	remoteDiscr        uint32
+	remoteDesiredMinTx time.Duration
+	remoteMinRx        time.Duration
+	nextTx             time.Time
+	// Detection time, zero if no packets were received
+	detectTimer time.Time
+}
+
+type bfdEvent struct {
+	s     *bfdSession
+	state uint8
+	// Session went up or down
+	link bool
+}
+
+var bfdSessions struct {
+	sync.Mutex
+	sessions []*bfdSession
+	// State changes are reported from separate goroutine in order of
+	// occurrence
+	events chan bfdEvent
+}
+
+// SetBFD adds single hop BFD session of port to flow. IN should be
+// flow of packets received from port, session removes BFD control
+// packets of peer from it. Session timers and sending of control
+// packets of all sessions are handled by one timer on dedicated core
+// after SystemStart. State changes are reported from separate
+// goroutine.
+func SetBFD(IN *Flow, config BFDConfig) error {
+	if int(config.Port) >= len(createdPorts) {
+		return common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	if config.LocalAddress == 0 || config.PeerAddress == 0 ||
+		config.PeerMAC == (types.MACAddress{}) && config.Neighbours == nil {
+		return common.WrapWithNFError(nil, "BFD session should have addresses and peer MAC address or neighbours table", common.BadArgument)
+	}
+	if config.DesiredMinTxInterval == 0 {
+		config.DesiredMinTxInterval = bfdDefaultInterval
+	}
+	if config.RequiredMinRxInterval == 0 {
+		config.RequiredMinRxInterval = bfdDefaultInterval
+	}
+	if config.DetectMultiplier == 0 {
+		config.DetectMultiplier = bfdDefaultDetectMult
+	}
+	if config.DesiredMinTxInterval < bfdTick || config.RequiredMinRxInterval < bfdTick {
+		return common.WrapWithNFError(nil, fmt.Sprintf("BFD intervals should be at least %v", bfdTick), common.BadArgument)
+	}
+	s := &bfdSession{
+		config:       config,
+		mac:          types.MACAddress(GetPortMACAddress(config.Port)),
+		srcPort:      uint16(packet.BFDMinSrcPort + rand.Intn(0x10000-packet.BFDMinSrcPort)),
+		state:        packet.BFDStateDown,
+		desiredMinTx: maxDuration(config.DesiredMinTxInterval, bfdSlowInterval),
+		remoteState:  packet.BFDStateDown,
+		remoteMinRx:  time.Microsecond,
+	}
+
+	bfdSessions.Lock()
+	for _, other := range bfdSessions.sessions {
+		if other.config.Port == config.Port && other.config.PeerAddress == config.PeerAddress {
+			bfdSessions.Unlock()
+			return common.WrapWithNFError(nil, fmt.Sprintf("BFD session of port %d with %v is already set", config.Port, config.PeerAddress), common.BadArgument)
+		}
+	}
+	for s.localDiscr == 0 {
+		s.localDiscr = rand.Uint32()
+		for _, other := range bfdSessions.sessions {
+			if other.localDiscr == s.localDiscr {
+				s.localDiscr = 0
+			}
+		}
+	}
+	first := bfdSessions.sessions == nil
+	bfdSessions.sessions = append(bfdSessions.sessions, s)
+	if first {
+		bfdSessions.events = make(chan bfdEvent, 256)
+		go bfdReport(bfdSessions.events)
+	}
+	bfdSessions.Unlock()
+
+	if err := SetHandlerDrop(IN, func(pkt *packet.Packet, ctx UserContext) bool {
+		return s.handle(pkt)
+	}, nil); err != nil {
+		return err
+	}
+	if first {
+		return SetTimer(bfdTick, func(UserContext) {
+			now := time.Now()
+			bfdSessions.Lock()
+			sessions := bfdSessions.sessions
+			bfdSessions.Unlock()
+			for _, s := range sessions {
+				s.tick(now)
+			}
+		}, nil, true)
+	}
+	return nil
+}
+
+// GetBFDState returns state of BFD session of port with peer. Sessions
+// which aren't set are in administratively down state.
+func GetBFDState(port uint16, peer types.IPv4Address) uint8 {
+	bfdSessions.Lock()
+	defer bfdSessions.Unlock()
+	for _, s := range bfdSessions.sessions {
+		if s.config.Port == port && s.config.PeerAddress == peer {
+			s.Lock()
+			defer s.Unlock()
+			return s.state
+		}
+	}
+	return packet.BFDStateAdminDown
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func bfdReport(events chan bfdEvent) {
+	for e := range events {
+		config := &e.s.config
+		if config.StateChanged != nil {
+			config.StateChanged(config.Port, config.PeerAddress, e.state)
+		}
+		if !e.link || !config.LinkCallbacks {
+			continue
+		}
+		up := e.state == packet.BFDStateUp
+		var speed uint32
+		if up {
+			_, speed = GetPortLinkStatus(config.Port)
+		}
+		for _, fn := range getLinkCallbacks(config.Port) {
+			fn(config.Port, up, speed)
+		}
+	}
+}
+
+// handle processes control packets of peer, returns false for packets
+// which are consumed by session.
+func (s *bfdSession) handle(pkt *packet.Packet) bool {
+	pkt.ParseL3()
+	data := pkt.GetBFD()
+	if data == nil {
+		return true
+	}
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil || ipv4.SrcAddr != s.config.PeerAddress || ipv4.DstAddr != s.config.LocalAddress {
+		return true
+	}
+	var c packet.BFDControl
+	if packet.ParseBFD(data, &c) {
+		s.receive(&c, time.Now())
+	}
+	return false
+}
+
+// receive handles control packet of peer as described in section
+// 6.8.6 of RFC 5880.
+func (s *bfdSession) receive(c *packet.BFDControl, now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	if c.YourDiscriminator != 0 && c.YourDiscriminator != s.localDiscr {
+		return
+	}
+	s.remoteDiscr = c.MyDiscriminator
+	s.remoteState = c.State
+	s.remoteDesiredMinTx = c.DesiredMinTxInterval
+	s.remoteMinRx = c.RequiredMinRxInterval
+	if c.Flags&packet.BFDFlagFinal != 0 {
+		s.poll = false
+	}
+	s.detectTimer = now.Add(time.Duration(c.DetectMult) * maxDuration(s.config.RequiredMinRxInterval, c.DesiredMinTxInterval))
+
+	if c.State == packet.BFDStateAdminDown {
+		s.setState(packet.BFDStateDown, packet.BFDDiagNeighborDown)
+	} else {
+		switch s.state {
+		case packet.BFDStateDown:
+			if c.State == packet.BFDStateDown {
+				s.setState(packet.BFDStateInit, packet.BFDDiagNone)
+			} else if c.State == packet.BFDStateInit {
+				s.setState(packet.BFDStateUp, packet.BFDDiagNone)
+			}
+		case packet.BFDStateInit:
+			if c.State == packet.BFDStateInit || c.State == packet.BFDStateUp {
+				s.setState(packet.BFDStateUp, packet.BFDDiagNone)
+			}
+		case packet.BFDStateUp:
+			if c.State == packet.BFDStateDown {
+				s.setState(packet.BFDStateDown, packet.BFDDiagNeighborDown)
+			}
+		}
+	}
+	if c.Flags&packet.BFDFlagPoll != 0 {
+		s.send(packet.BFDFlagFinal)
+	}
+}
+
+// tick handles detection timer and periodic transmission.
+func (s *bfdSession) tick(now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	if !s.detectTimer.IsZero() && !now.Before(s.detectTimer) {
+		s.detectTimer = time.Time{}
+		s.remoteDiscr = 0
+		s.remoteState = packet.BFDStateDown
+		if s.state != packet.BFDStateDown {
+			s.setState(packet.BFDStateDown, packet.BFDDiagDetectionTimeExpired)
+		}
+	}
+	// Peer which requires zero interval doesn't want to receive
+	// periodic control packets
+	if now.Before(s.nextTx) || s.remoteMinRx == 0 {
+		return
+	}
+	s.send(0)
+	// Interval is reduced by random jitter of up to 25 percent, or
+	// from 10 to 25 percent for detection multiplier 1
+	interval := maxDuration(s.desiredMinTx, s.remoteMinRx)
+	jitter := 75 + rand.Intn(26)
+	if s.config.DetectMultiplier == 1 {
+		jitter = 75 + rand.Intn(16)
+	}
+	s.nextTx = now.Add(interval * time.Duration(jitter) / 100)
+}
+
+// setState changes local state. Fast transmission interval is
+// advertised with poll sequence when session goes up.
+func (s *bfdSession) setState(state, diag uint8) {
+	if s.state == state {
+		return
+	}
+	link := state == packet.BFDStateUp || s.state == packet.BFDStateUp
+	s.state, s.diag = state, diag
+	if state == packet.BFDStateUp {
+		s.desiredMinTx = s.config.DesiredMinTxInterval
+		s.poll = true
+	} else {
+		s.desiredMinTx = maxDuration(s.config.DesiredMinTxInterval, bfdSlowInterval)
+		s.poll = false
+	}
+	// State is sent immediately
+	s.nextTx = time.Time{}
+	if s.config.StateChanged != nil || link && s.config.LinkCallbacks {
+		bfdSessions.events <- bfdEvent{s, state, link}
+	}
+}
+
+// send sends control packet with current state and given flags.
+func (s *bfdSession) send(flags uint8) {
+	dst := s.config.PeerMAC
+	if dst == (types.MACAddress{}) {
+		var found bool
+		if dst, found = s.config.Neighbours.LookupMACForIPv4(s.config.PeerAddress); !found {
+			s.config.Neighbours.SendARPRequestForIPv4(s.config.PeerAddress, s.config.LocalAddress, 0)
+			return
+		}
+	}
+	if s.poll && flags&packet.BFDFlagFinal == 0 {
+		flags |= packet.BFDFlagPoll
+	}
+	c := packet.BFDControl{
+		Diag:                  s.diag,
+		State:                 s.state,
+		Flags:                 flags,
+		DetectMult:            s.config.DetectMultiplier,
+		MyDiscriminator:       s.localDiscr,
+		YourDiscriminator:     s.remoteDiscr,
+		DesiredMinTxInterval:  s.desiredMinTx,
+		RequiredMinRxInterval: s.config.RequiredMinRxInterval,
+	}
+	pkt, err := packet.NewPacket()
+	if err != nil {
+		return
+	}
+	if !packet.InitBFDPacket(pkt, s.mac, dst, s.config.LocalAddress, s.config.PeerAddress, s.srcPort, &c) {
+		low.DirectStop(1, []uintptr{pkt.ToUintptr()})
+		return
+	}
+	low.DirectSend(pkt.CMbuf, s.config.Port)
+}
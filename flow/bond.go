@@ -0,0 +1,110 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// BondMode is a mode of bonded port.
+type BondMode int
+
+const (
+	// BondActiveBackup sends and receives packets by one slave port,
+	// other slaves are used when link of active port goes down.
+	BondActiveBackup BondMode = 1
+	// BondLACP aggregates slaves by IEEE 802.3ad dynamic link
+	// aggregation. Switch should have LACP configured on its ports.
+	// Flows are balanced between slaves by addresses and ports of
+	// packets.
+	BondLACP BondMode = 4
+)
+
+// Bonded ports of slave ports
+var bondSlaves = make(map[uint16]uint16)
+
+// Number of created bonding devices, it is used for unique device names
+var bondDevices int
+
+// CreateBondedPort creates port which aggregates slave ports and
+// returns its index. Bonded port is used by SetReceiver, SetSender
+// and other functions like any other port while slave ports can't be
+// used directly. The first slave is a primary port in active-backup
+// mode and bonded port has its MAC address. DPDK bonding driver
+// exchanges LACP frames when packets are received and sent, so bonded
+// port in LACP mode should have both receiver and sender. Should be
+// called after SystemInit and before SystemInitPortsAndMemory.
+func CreateBondedPort(mode BondMode, slaves []uint16) (uint16, error) {
+	if mode != BondActiveBackup && mode != BondLACP {
+		return 0, common.WrapWithNFError(nil, "Unknown bonding mode "+strconv.Itoa(int(mode)), common.BadArgument)
+	}
+	if len(slaves) == 0 {
+		return 0, common.WrapWithNFError(nil, "Bonded port should have slave ports", common.BadArgument)
+	}
+	args := []string{"mode=" + strconv.Itoa(int(mode))}
+	if mode == BondLACP {
+		args = append(args, "xmit_policy=l34")
+	}
+	for i, s := range slaves {
+		if int(s) >= len(createdPorts) {
+			return 0, common.WrapWithNFError(nil, "Slave port "+strconv.Itoa(int(s))+" exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+		}
+		if err := checkPortOwner(s); err != nil {
+			return 0, err
+		}
+		if createdPorts[s].wasRequested || createdPorts[s].willKNI {
+			return 0, common.WrapWithNFError(nil, "Slave port "+strconv.Itoa(int(s))+" is already used by flow graph", common.BadArgument)
+		}
+		for _, prev := range slaves[:i] {
+			if prev == s {
+				return 0, common.WrapWithNFError(nil, "Slave port "+strconv.Itoa(int(s))+" is repeated", common.BadArgument)
+			}
+		}
+		name, err := GetNameByPort(s)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, "slave="+name)
+		if i == 0 && mode == BondActiveBackup {
+			args = append(args, "primary="+name)
+		}
+	}
+	portId, err := addVdev(fmt.Sprintf("net_bonding%d", bondDevices), strings.Join(args, ","))
+	if err != nil {
+		return 0, err
+	}
+	bondDevices++
+	for _, s := range slaves {
+		bondSlaves[s] = portId
+	}
+	common.LogDebug(common.Initialization, "Ports", slaves, "are bonded to port", portId)
+	return portId, nil
+}
+
+// GetBondSlaves returns slave ports of bonded port in ascending order.
+func GetBondSlaves(portId uint16) []uint16 {
+	var ret []uint16
+	for s, b := range bondSlaves {
+		if b == portId {
+			ret = append(ret, s)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// removeBond forgets slaves of detached bonded port.
+func removeBond(portId uint16) {
+	for s, b := range bondSlaves {
+		if b == portId {
+			delete(bondSlaves, s)
+		}
+	}
+}
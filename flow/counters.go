@@ -11,6 +11,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
 
 	"github.com/intel-go/nff-go/common"
 	"github.com/intel-go/nff-go/internal/low"
@@ -18,11 +19,19 @@ import (
 
 const (
 	rootText = `<!DOCTYPE html><html><body>
+/<a href="/v1/schema">v1/schema</a> for JSON data structure describing names,
+types, units and meaning of all statistics returned by JSON requests.
+Requests with /v1/ prefix return the same data as /json/ requests, but
+their format changes only together with version.<br>
 /<a href="/rxtx">rxtx</a> for protocol statistics gathered on all send and
 receive or /rxtx/name for individual sender/receiver port.<br>
 /<a href="/json/rxtx">json/rxtx</a> for JSON data structure enumerating all
 ports that have statistics or /json/rxtx/name for JSON data structure with statistics
-of indivitual individual sender/receiver port.
+of indivitual individual sender/receiver port.<br>
+/<a href="/json/latency">json/latency</a> for JSON data structure with processing
+time percentiles of user handlers if latency instrumentation is enabled.<br>
+/<a href="/json/drops">json/drops</a> for JSON data structure with numbers of
+packets dropped by handlers for every drop reason.
 </body></html>`
 
 	statsSummaryTemplateText = `<!DOCTYPE html>
@@ -312,6 +321,7 @@ var (
 	countersEnabledInApplication bool = false
 	useInterlockedCounters       bool = low.UseInterlockedCounters
 	analyzePacketSizes           bool = low.AnalyzePacketSizes
+	byteAccounting               common.ByteAccounting
 )
 
 func init() {
@@ -393,12 +403,43 @@ func handleJSONRXTXStatsNode(w http.ResponseWriter, r *http.Request) {
 	enc.Encode(stats)
 }
 
+func handleJSONLatency(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(GetHandlerLatency())
+}
+
+func handleJSONDrops(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(GetDropStats())
+}
+
+func handleJSONMaintenance(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(GetMaintenanceStats())
+}
+
 func initCounters(addr *net.TCPAddr) error {
 	http.HandleFunc("/", handleRoot)
 	http.HandleFunc("/rxtx/", handleRXTXStatsNode)
 	http.HandleFunc("/rxtx", handleRXTXStats)
 	http.HandleFunc("/json/rxtx/", handleJSONRXTXStatsNode)
 	http.HandleFunc("/json/rxtx", handleJSONRXTXStats)
+	http.HandleFunc("/json/latency", handleJSONLatency)
+	http.HandleFunc("/json/drops", handleJSONDrops)
+	http.HandleFunc("/json/maintenance", handleJSONMaintenance)
+	http.HandleFunc("/json/schema", handleJSONSchema)
+	http.HandleFunc("/v1/rxtx/", handleJSONRXTXStatsNode)
+	http.HandleFunc("/v1/rxtx", handleJSONRXTXStats)
+	http.HandleFunc("/v1/latency", handleJSONLatency)
+	http.HandleFunc("/v1/drops", handleJSONDrops)
+	http.HandleFunc("/v1/maintenance", handleJSONMaintenance)
+	http.HandleFunc("/v1/schema", handleJSONSchema)
 
 	server := &http.Server{}
 	listener, err := net.ListenTCP("tcp", addr)
@@ -422,3 +463,138 @@ func initCounters(addr *net.TCPAddr) error {
 func registerRXTXStatitics(s *common.RXTXStats, name string) {
 	rxtxstats[name] = s
 }
+
+// NodeStats describes current state of one flow function node
+// of constructed graph.
+type NodeStats struct {
+	// Name of node, the same as used in scheduler debug output
+	Name string
+	// Type of node, for example "segment", "receive", "send"
+	Type string
+	// Number of instances of this node, one instance per
+	// group of input queues
+	Instances int
+	// Number of clones active in all instances of this node
+	Clones int
+	// Speed of node in packets per second during last scheduler
+	// interval. It is measured only for clonable nodes.
+	PacketsPerSecond uint64
+	// Number of packets currently waiting in all input rings of this node
+	RingOccupancy uint32
+	// Total capacity of all input rings of this node
+	RingCapacity uint32
+	// Maximum fill level of input rings of this node from 0 to 1
+	RingFill float64
+	// Number of packets processed, dropped and bytes processed. These
+	// counters are filled for send and receive nodes only and only
+	// if counters are enabled in framework and application.
+	PacketsProcessed, PacketsDropped, BytesProcessed uint64
+	// Time from receiving packets from input ring to sending them to
+	// output ring. It is filled for segment nodes only if
+	// Config.LatencyInstrumentation is set.
+	Latency LatencyStats
+}
+
+var ffTypeNames = map[ffType]string{
+	segmentCopy:    "segment",
+	fastGenerate:   "fast generate",
+	receiveRSS:     "receive",
+	sendReceiveKNI: "send",
+	generate:       "generate",
+	readWrite:      "file",
+	comboKNI:       "KNI",
+}
+
+// GetNodeStats returns statistics for all nodes of flow graph. It
+// can be called after SystemStart from any goroutine, including
+// graph update. Instances of nodes are read under the lock which
+// scheduler holds while it adds and removes them.
+// Counters are gathered without synchronization with working threads
+// so they are approximate and should be used for reporting only.
+func GetNodeStats() []NodeStats {
+	if schedState == nil {
+		return nil
+	}
+	schedState.tuningLock.Lock()
+	defer schedState.tuningLock.Unlock()
+	ret := make([]NodeStats, len(schedState.ff))
+	for i, ff := range schedState.ff {
+		ns := &ret[i]
+		ns.Name = ff.name
+		ns.Type = ffTypeNames[ff.fType]
+		ns.Instances = ff.instanceNumber
+		for q := 0; q < ff.instanceNumber; q++ {
+			ns.Clones += ff.instance[q].cloneNumber
+			if ff.fType == segmentCopy || ff.fType == fastGenerate {
+				ns.PacketsPerSecond += ff.instance[q].reportedState.V.normalize(loadSchedTime()).Packets
+			}
+		}
+		for _, r := range ff.inputRings() {
+			ns.RingOccupancy += r.GetRingCount()
+			ns.RingCapacity += r.GetRingCapacity()
+		}
+		ns.RingFill = ff.ringFill()
+		if par, ok := ff.Parameters.(*segmentParameters); ok && par.latency != nil {
+			ns.Latency = par.latency.stats()
+		}
+		if ff.stats != nil {
+			ns.PacketsProcessed = atomic.LoadUint64(&ff.stats.PacketsProcessed)
+			ns.PacketsDropped = atomic.LoadUint64(&ff.stats.PacketsDropped)
+			ns.BytesProcessed = atomic.LoadUint64(&ff.stats.BytesProcessed)
+		}
+	}
+	return ret
+}
+
+// GetMempoolsFree returns the smallest fraction of free mbufs among
+// mempools of receivers and generators. Packets are dropped on receive
+// when mempool is exhausted, so small values mean that packets are
+// held too long or leak.
+func GetMempoolsFree() float64 {
+	return low.GetMempoolsFree()
+}
+
+// GetNodeStatsByName returns statistics for node with given name.
+// Returns false if there is no such node.
+func GetNodeStatsByName(name string) (NodeStats, bool) {
+	for _, ns := range GetNodeStats() {
+		if ns.Name == name {
+			return ns, true
+		}
+	}
+	return NodeStats{}, false
+}
+
+func (ff *flowFunction) inputRings() low.Rings {
+	switch parameters := ff.Parameters.(type) {
+	case *segmentParameters:
+		return parameters.in
+	case *copyParameters:
+		return parameters.in
+	case *sendParameters:
+		return parameters.in
+	case *sendQueueParameters:
+		return parameters.in
+	case *sendOSParameters:
+		return parameters.in
+	case *sendXDPParameters:
+		return parameters.in
+	case *sendRingParameters:
+		return parameters.in
+	case *writeParameters:
+		return parameters.in
+	case *KNIParameters:
+		return parameters.in
+	case *fragmentParameters:
+		return parameters.in
+	case *reassembleParameters:
+		return parameters.in
+	case *sequencerParameters:
+		return parameters.in
+	case *reordererParameters:
+		return parameters.in
+	case *priorityMergeParameters:
+		return append(append(low.Rings(nil), parameters.high...), parameters.bestEffort...)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"time"
+
+	"github.com/intel-go/nff-go/dedup"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// SetDeduplicator adds handler which drops packets recognized as
+// duplicates by filter. It should be placed in front of captures and
+// mirrored outputs which receive packets from several tap points, so
+// the same packet isn't exported twice. Dropped packets are counted
+// with "duplicate" drop reason in GetDropStats, filter counters are
+// returned by its Stats method. Filter can be shared by several flows.
+func SetDeduplicator(IN *Flow, filter *dedup.Filter) error {
+	reason, err := RegisterDropReason("duplicate")
+	if err != nil {
+		return err
+	}
+	return SetHandlerDropReason(IN, func(pkt *packet.Packet, ctx UserContext) DropReason {
+		if filter.Duplicate(pkt.GetRawPacketBytes(), time.Now()) {
+			return reason
+		}
+		return Pass
+	}, nil)
+}
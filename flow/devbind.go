@@ -0,0 +1,72 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/devices"
+)
+
+// boundDevice is a device which was bound to DPDK driver by
+// SystemInit and its original driver.
+type boundDevice struct {
+	device devices.Device
+	driver string
+}
+
+var boundDevices []boundDevice
+
+// bindDevices binds given devices to driver. Devices which are used
+// by management network interfaces are not bound. If any device
+// fails, already bound devices are returned to their drivers.
+func bindDevices(ids []string, driver string) error {
+	if driver == "" {
+		driver = devices.DriverVfioPci
+	}
+	for _, id := range ids {
+		device, err := devices.New(id)
+		if err != nil {
+			restoreDevices()
+			return common.WrapWithNFError(err, "Cannot find device "+id, common.BindDeviceErr)
+		}
+		if err := devices.CheckSafeToBind(device); err != nil {
+			restoreDevices()
+			return common.WrapWithNFError(err, "Device "+id+" is not bound", common.BindDeviceErr)
+		}
+		original, err := device.CurrentDriver()
+		if err != nil {
+			restoreDevices()
+			return common.WrapWithNFError(err, "Cannot get driver of device "+id, common.BindDeviceErr)
+		}
+		if original == driver {
+			continue
+		}
+		common.LogDebug(common.Initialization, "Binding device", id, "to", driver)
+		if err := device.Bind(driver); err != nil {
+			restoreDevices()
+			return common.WrapWithNFError(err, "Cannot bind device "+id+" to "+driver, common.BindDeviceErr)
+		}
+		boundDevices = append(boundDevices, boundDevice{device: device, driver: original})
+	}
+	return nil
+}
+
+// restoreDevices returns devices bound by bindDevices to their
+// original drivers. It should be called after DPDK releases devices.
+func restoreDevices() {
+	for i := len(boundDevices) - 1; i >= 0; i-- {
+		d := boundDevices[i]
+		var err error
+		if d.driver == "" {
+			err = d.device.Unbind()
+		} else {
+			err = d.device.Bind(d.driver)
+		}
+		if err != nil {
+			common.LogWarning(common.Initialization, "Cannot return device", d.device.ID(), "to its driver:", err)
+		}
+	}
+	boundDevices = nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// DropReason describes why packet was dropped by handler.
+type DropReason uint8
+
+// Predefined drop reasons. Applications can add their own reasons
+// with RegisterDropReason.
+const (
+	// Pass means that packet isn't dropped
+	Pass DropReason = iota
+	// DropNoTranslation means that there is no address translation
+	// for packet
+	DropNoTranslation
+	// DropARPMiss means that MAC address of next hop is unknown
+	DropARPMiss
+	// DropChecksum means that packet has wrong checksum
+	DropChecksum
+	// DropACLDeny means that packet was denied by access control rules
+	DropACLDeny
+	// DropOther is used for packets dropped for other reasons
+	DropOther
+)
+
+// Maximum number of drop reasons including registered ones
+const maxDropReasons = 64
+
+// Names of drop reasons are read without locks by String, so
+// RegisterDropReason replaces whole slice under dropReasonLock.
+var (
+	dropReasonNames atomic.Value
+	dropReasonLock  sync.Mutex
+)
+
+func init() {
+	dropReasonNames.Store([]string{"pass", "no-translation", "arp-miss", "checksum-fail", "acl-deny", "other"})
+}
+
+// RegisterDropReason adds drop reason with given name. Returns
+// existing reason if name is already registered. It can be called
+// concurrently with running flow graph.
+func RegisterDropReason(name string) (DropReason, error) {
+	dropReasonLock.Lock()
+	defer dropReasonLock.Unlock()
+	names := dropReasonNames.Load().([]string)
+	for i, n := range names {
+		if n == name {
+			return DropReason(i), nil
+		}
+	}
+	if len(names) == maxDropReasons {
+		return Pass, common.WrapWithNFError(nil, "Too many drop reasons", common.BadArgument)
+	}
+	newNames := make([]string, len(names)+1)
+	copy(newNames, names)
+	newNames[len(names)] = name
+	dropReasonNames.Store(newNames)
+	return DropReason(len(names)), nil
+}
+
+func (r DropReason) String() string {
+	if names := dropReasonNames.Load().([]string); int(r) < len(names) {
+		return names[r]
+	}
+	return "unknown"
+}
+
+// DropFunction is a function type like SeparateFunction which returns
+// reason of packet drop instead of boolean value. Packet is passed
+// further if function returns Pass.
+type DropFunction func(*packet.Packet, UserContext) DropReason
+
+// dropCounters counts packets dropped by one DropFunction.
+type dropCounters struct {
+	node    string
+	packets [maxDropReasons]uint64
+}
+
+// Counters of all drop functions in order of graph construction.
+// Handlers can be added by graph update while counters are read.
+var (
+	dropStats     []*dropCounters
+	dropStatsLock sync.Mutex
+)
+
+func makeDropper(dropFunction DropFunction) *Func {
+	f := makeSeparator(nil, nil)
+	f.sDropFunction = dropFunction
+	f.sFunc = dropWithReason
+	f.drops = new(dropCounters)
+	f.instrument(dropFunction)
+	return f
+}
+
+func dropWithReason(packet *packet.Packet, sc *Func, ctx UserContext) uint {
+	reason := sc.sDropFunction(packet, ctx)
+	if reason == Pass {
+		return 1
+	}
+	if int(reason) < maxDropReasons {
+		atomic.AddUint64(&sc.drops.packets[reason], 1)
+	}
+	return 0
+}
+
+// SetHandlerDropReason adds handle function which can drop packets to
+// flow graph. Unlike SetHandlerDrop user function returns reason of
+// drop which is counted and can be read with GetDropStats. Packets
+// are passed further if function returns Pass.
+func SetHandlerDropReason(IN *Flow, dropFunction DropFunction, context UserContext) error {
+	dropper := makeDropper(dropFunction)
+	if err := segmentInsert(IN, dropper, false, context, 1, 1); err != nil {
+		return err
+	}
+	dropper.drops.node = dropper.node
+	dropStatsLock.Lock()
+	dropStats = append(dropStats, dropper.drops)
+	dropStatsLock.Unlock()
+	return SetStopper(newFlowSegment(IN.segment, &dropper.next[0], IN.inIndexNumber))
+}
+
+// DropStats contains number of packets dropped for one reason.
+type DropStats struct {
+	// Name of segment node with handler which dropped packets
+	Node string
+	// Name of drop reason
+	Reason  string
+	Packets uint64
+}
+
+// GetDropStats returns number of dropped packets for all handlers
+// added by SetHandlerDropReason and all reasons which occurred.
+func GetDropStats() []DropStats {
+	var ret []DropStats
+	dropStatsLock.Lock()
+	defer dropStatsLock.Unlock()
+	for _, dc := range dropStats {
+		for r := range dc.packets {
+			if n := atomic.LoadUint64(&dc.packets[r]); n != 0 {
+				ret = append(ret, DropStats{
+					Node:    dc.node,
+					Reason:  DropReason(r).String(),
+					Packets: n,
+				})
+			}
+		}
+	}
+	return ret
+}
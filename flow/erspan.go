@@ -0,0 +1,128 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// ERSPANConfig is a configuration of ERSPAN session which mirrors
+// packets to remote analyzer over IPv4 GRE.
+type ERSPANConfig struct {
+	// Port which sends mirrored packets
+	Port uint16
+	// Type of ERSPAN header, packet.ERSPANTypeII or
+	// packet.ERSPANTypeIII, default is Type II
+	Type      uint8
+	SessionID uint16
+	// Port index of Type II header or hardware ID of Type III header
+	Index uint32
+	// Mirrored packets are egress packets, it is used only by Type III
+	// header
+	Egress bool
+	// Source and destination addresses of outer IPv4 header
+	LocalAddress    types.IPv4Address
+	AnalyzerAddress types.IPv4Address
+	// MAC address of next hop to analyzer, it is resolved by Neighbours
+	// if it is zero
+	AnalyzerMAC types.MACAddress
+	Neighbours  *packet.NeighboursLookupTable
+	// Mirrored frames are truncated to MaxLength bytes if it isn't zero
+	MaxLength uint
+	// Function which selects mirrored packets, all packets are mirrored
+	// if it is nil
+	Select func(pkt *packet.Packet) bool
+}
+
+type erspanSession struct {
+	config ERSPANConfig
+	mac    types.MACAddress
+	// Sequence number of GRE header, accessed atomically
+	seq uint32
+}
+
+// SetERSPANMirror adds handler which sends copies of packets selected
+// by config to remote analyzer encapsulated to ERSPAN. Packets of
+// flow are passed unchanged. Packets aren't mirrored while MAC address
+// of analyzer is unknown, ARP requests are sent instead.
+func SetERSPANMirror(IN *Flow, config ERSPANConfig) error {
+	if int(config.Port) >= len(createdPorts) {
+		return common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	if config.Type == 0 {
+		config.Type = packet.ERSPANTypeII
+	}
+	if config.Type != packet.ERSPANTypeII && config.Type != packet.ERSPANTypeIII {
+		return common.WrapWithNFError(nil, "Unknown ERSPAN type", common.BadArgument)
+	}
+	if config.SessionID > packet.ERSPANMaxSessionID {
+		return common.WrapWithNFError(nil, "ERSPAN session ID should be less than 1024", common.BadArgument)
+	}
+	if config.LocalAddress == 0 || config.AnalyzerAddress == 0 ||
+		config.AnalyzerMAC == (types.MACAddress{}) && config.Neighbours == nil {
+		return common.WrapWithNFError(nil, "ERSPAN session should have addresses and analyzer MAC address or neighbours table", common.BadArgument)
+	}
+	s := &erspanSession{
+		config: config,
+		mac:    types.MACAddress(GetPortMACAddress(config.Port)),
+	}
+	return SetHandler(IN, func(pkt *packet.Packet, ctx UserContext) {
+		if config.Select == nil || config.Select(pkt) {
+			s.mirror(pkt)
+		}
+	}, nil)
+}
+
+// mirror sends encapsulated copy of pkt to analyzer.
+func (s *erspanSession) mirror(pkt *packet.Packet) {
+	dst := s.config.AnalyzerMAC
+	if dst == (types.MACAddress{}) {
+		var found bool
+		if dst, found = s.config.Neighbours.LookupMACForIPv4(s.config.AnalyzerAddress); !found {
+			s.config.Neighbours.SendARPRequestForIPv4(s.config.AnalyzerAddress, s.config.LocalAddress, 0)
+			return
+		}
+	}
+	h := packet.ERSPANHeader{
+		Type:      s.config.Type,
+		SessionID: s.config.SessionID,
+	}
+	if vlan := pkt.GetVLAN(); vlan != nil {
+		tci := packet.SwapBytesUint16(vlan.TCI)
+		h.VLAN = tci & 0x0fff
+		h.COS = uint8(tci >> 13)
+		h.Encap = packet.ERSPANEncapVLANKept
+	}
+	if s.config.Type == packet.ERSPANTypeII {
+		h.Index = s.config.Index
+	} else {
+		h.Encap = packet.ERSPANFrameGood
+		h.HardwareID = uint8(s.config.Index)
+		h.Egress = s.config.Egress
+		h.Granularity = packet.ERSPANGranularity100us
+		h.Timestamp = uint32(time.Now().UnixNano() / int64(100*time.Microsecond))
+	}
+	frame := pkt.GetRawPacketBytes()
+	if s.config.MaxLength != 0 && uint(len(frame)) > s.config.MaxLength {
+		frame = frame[:s.config.MaxLength]
+		h.Truncated = true
+	}
+	mirrored, err := packet.NewPacket()
+	if err != nil {
+		return
+	}
+	if !packet.GeneratePacketFromByte(mirrored, frame) ||
+		!mirrored.EncapsulateERSPAN(s.mac, dst, s.config.LocalAddress, s.config.AnalyzerAddress, atomic.AddUint32(&s.seq, 1)-1, &h) {
+		low.DirectStop(1, []uintptr{mirrored.ToUintptr()})
+		return
+	}
+	low.DirectSend(mirrored.CMbuf, s.config.Port)
+}
@@ -34,6 +34,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strconv"
 	"sync/atomic"
 	"time"
 
@@ -51,6 +52,9 @@ var schedState *scheduler
 var vEach [10][vBurstSize]uint8
 var ioDevices map[string]interface{}
 
+// NFF-GO was started as DPDK secondary process, see Config.SecondaryProcess
+var secondaryProcess bool
+
 type Timer struct {
 	t        *time.Ticker
 	handler  func(UserContext)
@@ -62,6 +66,8 @@ type processSegment struct {
 	out      []low.Rings
 	contexts []UserContext
 	stype    uint8
+	socket   int
+	name     string
 }
 
 // Flow is an abstraction for connecting flow functions with each other.
@@ -71,6 +77,8 @@ type Flow struct {
 	segment       *processSegment
 	previous      **Func
 	inIndexNumber int32
+	// NUMA socket of port which packets of this flow are received from
+	socket int
 }
 
 type partitionCtx struct {
@@ -97,11 +105,19 @@ type Func struct {
 	vSeparateFunction VectorSeparateFunction
 	vSplitFunction    VectorSplitFunction
 	vFunc             func([]*packet.Packet, *[vBurstSize]bool, *[vBurstSize]uint8, *Func, UserContext)
+	sDropFunction     DropFunction
+	drops             *dropCounters
 
 	next            [](*Func)
 	bufIndex        uint
 	contextIndex    int
 	followingNumber uint8
+
+	// Name of user function and segment node, and processing time
+	// histogram if latency instrumentation is enabled
+	name    string
+	node    string
+	latency *latencyHistogram
 }
 
 // GenerateFunction is a function type for user defined function which generates packets.
@@ -142,6 +158,10 @@ type SplitFunction func(*packet.Packet, UserContext) uint
 // VectorSplitFunction is a function type like SplitFunction for vector splitting
 type VectorSplitFunction func([]*packet.Packet, *[vBurstSize]bool, *[vBurstSize]uint8, UserContext)
 
+// TimerFunction is a function type for user defined function which is
+// called periodically after SetTimer.
+type TimerFunction func(UserContext)
+
 // Kni is a high level struct of KNI device. The device itself is stored
 // in C memory in low.c and is defined by its port which is equal to port
 // in this structure
@@ -161,18 +181,27 @@ func addReceiver(portId uint16, out low.Rings, inIndexNumber int32) {
 	par.port = low.GetPort(portId)
 	par.out = out
 	par.status = make([]int32, maxRecv, maxRecv)
-	schedState.addFF("receiverPort"+string(portId), nil, recvRSS, nil, par, nil, receiveRSS, inIndexNumber, &par.stats)
+	ff := schedState.addFF("receiverPort"+string(portId), nil, recvRSS, nil, par, nil, receiveRSS, inIndexNumber, &par.stats)
+	ff.socket = low.GetPortSocket(portId)
+}
+
+// osDevice is a raw socket of Linux interface or file of tap or tun
+// device created by CreateOSDevice. Packets of tun device don't have
+// Ethernet header.
+type osDevice struct {
+	socket int
+	l3     bool
 }
 
 type receiveOSParameters struct {
 	out    low.Rings
-	socket int
+	device osDevice
 	stats  common.RXTXStats
 }
 
-func addOSReceiver(socket int, out low.Rings) {
+func addOSReceiver(device osDevice, out low.Rings) {
 	par := new(receiveOSParameters)
-	par.socket = socket
+	par.device = device
 	par.out = out
 	schedState.addFF("OS receiver", nil, recvOS, nil, par, nil, sendReceiveKNI, 0, &par.stats)
 }
@@ -237,7 +266,7 @@ func addGenerator(out low.Rings, generateFunction GenerateFunction, context User
 func addFastGenerator(out low.Rings, generateFunction GenerateFunction,
 	vectorGenerateFunction VectorGenerateFunction, targetSpeed uint64, context UserContext) (chan uint64, error) {
 	fTargetSpeed := float64(targetSpeed)
-	if fTargetSpeed/(1000 /*milleseconds*/ /float64(schedTime)) < float64(burstSize) {
+	if fTargetSpeed/(1000 /*milleseconds*/ /float64(loadSchedTime())) < float64(burstSize) {
 		// TargetSpeed per schedTime should be more than burstSize because one burstSize packets in
 		// one schedTime seconds are out minimal scheduling part. We can't make generate speed less than this.
 		return nil, common.WrapWithNFError(nil, "Target speed per schedTime should be more than burstSize", common.BadArgument)
@@ -270,20 +299,21 @@ func addSender(port uint16, in low.Rings, inIndexNumber int32) {
 		par.in = in
 		par.unrestrictedClones = schedState.unrestrictedClones
 		par.sendThreadIndex = iii
-		schedState.addFF("senderPort"+string(port)+"Thread"+string(iii),
+		ff := schedState.addFF("senderPort"+string(port)+"Thread"+string(iii),
 			nil, send, nil, par, nil, sendReceiveKNI, inIndexNumber, &par.stats)
+		ff.socket = low.GetPortSocket(port)
 	}
 }
 
 type sendOSParameters struct {
 	in     low.Rings
-	socket int
+	device osDevice
 	stats  common.RXTXStats
 }
 
-func addSenderOS(socket int, in low.Rings, inIndexNumber int32) {
+func addSenderOS(device osDevice, in low.Rings, inIndexNumber int32) {
 	par := new(sendOSParameters)
-	par.socket = socket
+	par.device = device
 	par.in = in
 	schedState.addFF("sender OS", nil, sendOS, nil, par, nil, sendReceiveKNI, inIndexNumber, &par.stats)
 }
@@ -306,15 +336,22 @@ type copyParameters struct {
 	out     low.Rings
 	outCopy low.Rings
 	mempool *low.Mempool
+	// Copies share data with original packets
+	clone bool
 }
 
-func addCopier(in low.Rings, out low.Rings, outCopy low.Rings, inIndexNumber int32) {
+func addCopier(in low.Rings, out low.Rings, outCopy low.Rings, clone bool, inIndexNumber int32) {
 	par := new(copyParameters)
 	par.in = in
 	par.out = out
 	par.outCopy = outCopy
-	par.mempool = low.CreateMempool("copy")
-	schedState.addFF("copy", nil, nil, pcopy, par, nil, segmentCopy, inIndexNumber, nil)
+	par.clone = clone
+	name := "copy"
+	if clone {
+		name = "clone"
+	}
+	par.mempool = low.CreateMempool(name)
+	schedState.addFF(name, nil, nil, pcopy, par, nil, segmentCopy, inIndexNumber, nil)
 }
 
 func makePartitioner(N uint64, M uint64) *Func {
@@ -334,6 +371,7 @@ func makeSeparator(separateFunction SeparateFunction, vectorSeparateFunction Vec
 	f.vFunc = vSeparate
 	f.next = make([]*Func, 2, 2)
 	f.followingNumber = 2
+	f.instrument(separateFunction, vectorSeparateFunction)
 	return f
 }
 
@@ -345,6 +383,7 @@ func makeSplitter(splitFunction SplitFunction, vectorSplitFunction VectorSplitFu
 	f.vFunc = vSplit
 	f.next = make([]*Func, n, n)
 	f.followingNumber = n
+	f.instrument(splitFunction, vectorSplitFunction)
 	return f
 }
 
@@ -356,6 +395,7 @@ func makeHandler(handleFunction HandleFunction, vectorHandleFunction VectorHandl
 	f.vFunc = vHandle
 	f.next = make([]*Func, 1, 1)
 	f.followingNumber = 1
+	f.instrument(handleFunction, vectorHandleFunction)
 	return f
 }
 
@@ -387,6 +427,25 @@ func addReader(filename string, out low.Rings, repcount int32) {
 	schedState.addFF("read", read, nil, nil, par, nil, readWrite, 0, &par.stats)
 }
 
+type timerParameters struct {
+	interval time.Duration
+	function TimerFunction
+	context  UserContext
+	next     time.Time
+}
+
+func addTimerFunction(interval time.Duration, function TimerFunction, context UserContext, dedicatedCore bool) {
+	par := new(timerParameters)
+	par.interval = interval
+	par.function = function
+	par.context = context
+	if dedicatedCore {
+		schedState.addFF("timer", periodic, nil, nil, par, nil, readWrite, 0, nil)
+	} else {
+		schedState.periodic = append(schedState.periodic, par)
+	}
+}
+
 func makeSlice(out low.Rings, segment *processSegment) *Func {
 	f := new(Func)
 	f.sFunc = constructSlice
@@ -402,18 +461,30 @@ type segmentParameters struct {
 	out       *([]low.Rings)
 	firstFunc *Func
 	stype     *uint8
+	// Time from dequeue to enqueue of packets if latency
+	// instrumentation is enabled
+	latency *latencyHistogram
+	// Name of segment node for tracing
+	name string
 }
 
-func addSegment(in low.Rings, first *Func, inIndexNumber int32) *processSegment {
+func addSegment(in low.Rings, first *Func, inIndexNumber int32, socket int) *processSegment {
 	par := new(segmentParameters)
 	par.in = in
 	par.firstFunc = first
 	segment := new(processSegment)
 	segment.out = make([]low.Rings, 0, 0)
 	segment.contexts = make([](UserContext), 0, 0)
+	segment.socket = socket
 	par.out = &segment.out
 	par.stype = &segment.stype
-	schedState.addFF("segment", nil, nil, segmentProcess, par, &segment.contexts, segmentCopy, inIndexNumber, nil)
+	if latencyEnabled {
+		par.latency = new(latencyHistogram)
+	}
+	ff := schedState.addFF("segment", nil, nil, segmentProcess, par, &segment.contexts, segmentCopy, inIndexNumber, nil)
+	ff.socket = socket
+	segment.name = ff.name
+	par.name = ff.name
 	return segment
 }
 
@@ -422,6 +493,9 @@ type HWCapability int
 const (
 	HWTXChecksumCapability HWCapability = iota
 	HWRXPacketsTimestamp
+	// Calculation of outer IPv4 and UDP checksums of tunnel packets,
+	// it is used together with HWTXChecksumCapability
+	HWTXTunnelChecksumCapability
 )
 
 const (
@@ -442,6 +516,8 @@ func CheckHWCapability(capa HWCapability, ports []uint16) []bool {
 			ret[p] = low.CheckHWTXChecksumCapability(ports[p])
 		case HWRXPacketsTimestamp:
 			ret[p] = low.CheckHWRXPacketsTimestamp(ports[p])
+		case HWTXTunnelChecksumCapability:
+			ret[p] = low.CheckHWTXTunnelChecksumCapability(ports[p])
 		default:
 			ret[p] = false
 		}
@@ -455,6 +531,8 @@ func SetUseHWCapability(capa HWCapability, use bool) {
 	switch capa {
 	case HWTXChecksumCapability:
 		packet.SetHWTXChecksumFlag(use)
+	case HWTXTunnelChecksumCapability:
+		packet.SetHWTXTunnelChecksumFlag(use)
 	}
 }
 
@@ -470,11 +548,24 @@ const vBurstSize = 32
 const reportMbits = false
 
 var sizeMultiplier uint
-var schedTime uint
+
+// schedTime is scheduler interval in milliseconds. It is changed by
+// SetSchedulerTuning and measure while clones are running, so it is
+// accessed only through loadSchedTime and storeSchedTime.
+var schedTime uint32
 var hwtxchecksum, hwrxpacketstimestamp, setSIGINTHandler bool
 var maxRecv int
+
 var sendCPUCoresPerPort, tXQueuesNumberPerPort int
 
+func loadSchedTime() uint {
+	return uint(atomic.LoadUint32(&schedTime))
+}
+
+func storeSchedTime(t uint) {
+	atomic.StoreUint32(&schedTime, uint32(t))
+}
+
 type port struct {
 	wasRequested bool // has user requested any send/receive operations at this port
 	willReceive  bool // will this port receive packets
@@ -484,6 +575,16 @@ type port struct {
 	MAC          types.MACAddress
 	InIndex      int32
 	sendRings    low.Rings
+	// Rings of every TX queue used by SetSenderQueues
+	queueRings []low.Rings
+	// Receive side scaling set by SetPortRSS
+	rss low.RSSConf
+	// MTU set by SetPortMTU, zero keeps MTU of device
+	mtu uint16
+	// TCP segmentation offload enabled by SetPortTSO
+	tso bool
+	// Port was started by SystemInitPortsAndMemory
+	started bool
 }
 
 // Config is a struct with all parameters, which user can pass to NFF-GO library
@@ -589,6 +690,47 @@ type Config struct {
 	// 500. Lower values allow faster reaction to changing traffic but
 	// increase scheduling overhead.
 	SchedulerInterval uint
+	// PCI addresses, VMBus UUIDs or kernel names of network devices
+	// which should be bound to BindDriver before DPDK
+	// initialization. SystemInit refuses to bind devices which are
+	// used by management network interfaces. Devices are returned to
+	// their original drivers by SystemReset or after SIGINT handled
+	// by SystemStartScheduler.
+	BindDevices []string
+	// DPDK driver for BindDevices. Default value is vfio-pci.
+	BindDriver string
+	// Enables power-saving idle mode. Receivers from ports, senders
+	// to ports and segments of user handlers which have no packets
+	// sleep instead of polling all the time. Sleep time grows
+	// exponentially while there is no traffic and is limited by this
+	// value, so it is a maximum additional latency of first packet
+	// after idle period. Sleeping segments are counted as idle by
+	// scheduler, so their clones are removed as without sleep.
+	// Default value is zero which means busy polling.
+	IdleWakeupLatency time.Duration
+	// Enables measurement of packet processing time in segments of
+	// user functions. Every handler, separator and splitter is timed
+	// separately, so the slowest of them can be found with
+	// GetHandlerLatency. Processing time of whole segments is reported
+	// by GetNodeStats. Measurement adds two time readings per packet
+	// and function, so it shouldn't be enabled for peak performance.
+	LatencyInstrumentation bool
+	// Defines which bytes of frames are counted by byte counters of
+	// receivers, senders and other nodes. common.AccountL1 adds
+	// Ethernet overhead so that counters match line rate counters of
+	// carrier equipment. Default value is common.AccountL2. Use
+	// GetByteAccounting to count bytes in application consistently.
+	ByteAccounting common.ByteAccounting
+	// Starts NFF-GO as DPDK secondary process which attaches to
+	// mempools and ports of running primary process, for example to
+	// run monitoring or capture helper alongside production network
+	// function. Ports are configured and polled by primary process,
+	// so SetReceiver and SetSender return errors, packets are
+	// exchanged with primary process by SetReceiverRing and
+	// SetSenderRing. If primary process uses --file-prefix DPDK
+	// argument, the same argument should be in DPDKArgs. BindDevices
+	// and NeedKNI can't be used in secondary process.
+	SecondaryProcess bool
 }
 
 // SystemInit is initialization of system. This function should be always called before graph construction.
@@ -644,13 +786,13 @@ func SystemInit(args *Config) error {
 	}
 
 	if args.SchedulerInterval != 0 {
-		schedTime = args.SchedulerInterval
+		storeSchedTime(args.SchedulerInterval)
 	} else {
-		schedTime = 500
+		storeSchedTime(500)
 	}
 
 	if args.ScaleTime != 0 {
-		schedTime = args.ScaleTime
+		storeSchedTime(args.ScaleTime)
 	}
 
 	checkTime := uint(10000)
@@ -663,7 +805,7 @@ func SystemInit(args *Config) error {
 		debugTime = args.DebugTime
 	}
 
-	if debugTime < schedTime {
+	if debugTime < loadSchedTime() {
 		return common.WrapWithNFError(nil, "debugTime should be larger or equal to schedTime", common.Fail)
 	}
 
@@ -714,25 +856,45 @@ func SystemInit(args *Config) error {
 		needMemoryJumbo = true
 	}
 
-	argc, argv := low.InitDPDKArguments(args.DPDKArgs)
+	dpdkArgs := args.DPDKArgs
+	secondaryProcess = args.SecondaryProcess
+	if secondaryProcess {
+		if len(args.BindDevices) != 0 || args.NeedKNI {
+			return common.WrapWithNFError(nil, "BindDevices and NeedKNI can't be used in secondary process", common.BadArgument)
+		}
+		dpdkArgs = append([]string{"--proc-type=secondary"}, dpdkArgs...)
+		// Names of rings and mempools shouldn't clash with names
+		// used by primary process
+		low.SetObjectPrefix("s" + strconv.Itoa(os.Getpid()) + "_")
+	}
+	argc, argv := low.InitDPDKArguments(dpdkArgs)
 	// We want to add new clone if input ring is approximately 80% full
 	maxPacketsToClone := uint32(sizeMultiplier * burstSize / 5 * 4)
 	// TODO all low level initialization here! Now everything is default.
 	// Init eal
 	common.LogTitle(common.Initialization, "------------***-------- Initializing DPDK --------***------------")
+	if err := bindDevices(args.BindDevices, args.BindDriver); err != nil {
+		return err
+	}
 	if err := low.InitDPDK(argc, argv, burstSize, mbufNumber, mbufCacheSize, needKNI,
 		NoPacketHeadChange, needChainedReassembly, needChainedJumbo, needMemoryJumbo); err != nil {
+		restoreDevices()
 		return err
 	}
+	if secondaryProcess && !low.IsSecondaryProcess() {
+		return common.WrapWithNFError(nil, "DPDK wasn't initialized as secondary process", common.FailToInitDPDK)
+	}
+	idleMaxSleep = args.IdleWakeupLatency
+	latencyEnabled = args.LatencyInstrumentation
+	byteAccounting = args.ByteAccounting
+	low.SetL1ByteAccounting(byteAccounting == common.AccountL1)
+	low.SetIdleSleep(idleMaxSleep)
 	// Init Ports
+	portMaxInIndex = maxInIndex
 	createdPorts = make([]port, low.GetPortsNumber(), low.GetPortsNumber())
 	for i := range createdPorts {
 		createdPorts[i].port = uint16(i)
-		if maxInIndex > low.CheckPortRSS(createdPorts[i].port) {
-			createdPorts[i].InIndex = low.CheckPortRSS(createdPorts[i].port)
-		} else {
-			createdPorts[i].InIndex = maxInIndex
-		}
+		createdPorts[i].InIndex = portInIndex(createdPorts[i].port)
 	}
 	portPair = make(map[types.IPv4Address](*port))
 	ioDevices = make(map[string]interface{})
@@ -772,41 +934,83 @@ func SystemInitPortsAndMemory() error {
 		return common.WrapWithNFError(nil, "Some flows are left open at the end of configuration!", common.OpenedFlowAtTheEnd)
 	}
 	common.LogTitle(common.Initialization, "------------***---------- Creating ports ---------***------------")
+	if err := startPorts(); err != nil {
+		return err
+	}
+	// Init low performance mempool
+	packet.SetNonPerfMempool(low.CreateMempool("slow operations"))
+	if len(selfCheckPairs) != 0 {
+		if err := runSelfCheck(); err != nil {
+			return err
+		}
+	}
+	common.LogTitle(common.Initialization, "------------***------ Starting FlowFunctions -----***------------")
+	return nil
+}
+
+// Maximum number of connection groups of receivers from ports
+var portMaxInIndex int32
+
+// portInIndex returns number of connection groups of receiver from
+// port which is limited by number of RX queues of port.
+func portInIndex(portId uint16) int32 {
+	if rss := low.CheckPortRSS(portId); rss < portMaxInIndex {
+		return rss
+	}
+	return portMaxInIndex
+}
+
+// startPorts creates requested ports which aren't started yet.
+func startPorts() error {
 	for i := range createdPorts {
-		if createdPorts[i].wasRequested {
+		if createdPorts[i].wasRequested && !createdPorts[i].started {
+			if err := checkPortOwner(createdPorts[i].port); err != nil {
+				return err
+			}
 			if err := low.CreatePort(createdPorts[i].port, createdPorts[i].willReceive,
-				true, hwtxchecksum, hwrxpacketstimestamp, createdPorts[i].InIndex, tXQueuesNumberPerPort); err != nil {
+				true, hwtxchecksum, hwrxpacketstimestamp, createdPorts[i].InIndex, tXQueuesNumberPerPort, &createdPorts[i].rss, createdPorts[i].mtu, createdPorts[i].tso); err != nil {
 				return err
 			}
+			createdPorts[i].started = true
 		}
 		createdPorts[i].MAC = GetPortMACAddress(createdPorts[i].port)
 		common.LogDebug(common.Initialization, "Port", createdPorts[i].port, "MAC address:", createdPorts[i].MAC.String())
 	}
-	common.LogTitle(common.Initialization, "------------***------ Starting FlowFunctions -----***------------")
-	// Init low performance mempool
-	packet.SetNonPerfMempool(low.CreateMempool("slow operations"))
 	return nil
 }
 
 // SystemStartScheduler starts scheduler packet processing. Function
 // does not return.
 func SystemStartScheduler() error {
+	if tracing != nil {
+		tracing.prepare()
+	}
 	if err := schedState.systemStart(); err != nil {
 		return common.WrapWithNFError(err, "scheduler start failed", common.Fail)
 	}
 	common.LogTitle(common.Initialization, "------------***---------- NFF-GO Started ---------***------------")
+	if backpressureState != nil {
+		go backpressureState.monitor()
+	}
+	startLinkMonitor()
 
 	if setSIGINTHandler {
 		signalChan := make(chan os.Signal, 1)
 		signal.Notify(signalChan, os.Interrupt)
 		go func() {
-			schedState.schedule(schedTime)
+			schedState.schedule()
 		}()
 		<-signalChan
 		common.LogTitle(common.Debug, "Received an interrupt, stopping everything")
-		SystemStop()
+		if len(boundDevices) != 0 {
+			// Devices can be returned to their drivers only
+			// after DPDK releases them
+			SystemReset()
+		} else {
+			SystemStop()
+		}
 	} else {
-		schedState.schedule(schedTime)
+		schedState.schedule()
 	}
 	return nil
 }
@@ -833,10 +1037,15 @@ func SystemStop() error {
 	schedState.systemStop()
 	for i := range createdPorts {
 		if createdPorts[i].wasRequested {
+			if createdPorts[i].started {
+				low.FlushHWRules(createdPorts[i].port)
+				createdPorts[i].started = false
+			}
 			low.StopPort(createdPorts[i].port)
 			createdPorts[i].wasRequested = false
 			createdPorts[i].willReceive = false
 			createdPorts[i].sendRings = nil
+			createdPorts[i].queueRings = nil
 		}
 		if createdPorts[i].willKNI {
 			err := low.FreeKNI(createdPorts[i].port)
@@ -858,6 +1067,7 @@ func SystemStop() error {
 func SystemReset() {
 	SystemStop()
 	low.StopDPDK()
+	restoreDevices()
 }
 
 // SetSenderFile adds write function to flow graph.
@@ -881,6 +1091,18 @@ func SetReceiverFile(filename string, repcount int32) (OUT *Flow) {
 	return newFlow(rings, 1)
 }
 
+// checkPortOwner returns error in secondary process because ports are
+// configured and polled by primary process.
+func checkPortOwner(portId uint16) error {
+	if secondaryProcess {
+		return common.WrapWithNFError(nil, "Port "+strconv.Itoa(int(portId))+" is owned by primary process, use SetReceiverRing and SetSenderRing in secondary process", common.WrongPort)
+	}
+	if bond, ok := bondSlaves[portId]; ok {
+		return common.WrapWithNFError(nil, "Port "+strconv.Itoa(int(portId))+" is slave of bonded port "+strconv.Itoa(int(bond))+" and can't be used directly", common.WrongPort)
+	}
+	return nil
+}
+
 // SetReceiver adds receive function to flow graph.
 // Gets port number from which packets will be received.
 // Receive queue will be added to port automatically.
@@ -889,55 +1111,98 @@ func SetReceiver(portId uint16) (OUT *Flow, err error) {
 	if portId >= uint16(len(createdPorts)) {
 		return nil, common.WrapWithNFError(nil, "Requested receive port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
 	}
+	if err := checkPortOwner(portId); err != nil {
+		return nil, err
+	}
 	if createdPorts[portId].willReceive {
 		return nil, common.WrapWithNFError(nil, "Requested receive port was already set to receive. Two receives from one port are prohibited.", common.MultipleReceivePort)
 	}
 	createdPorts[portId].wasRequested = true
 	createdPorts[portId].willReceive = true
-	rings := low.CreateRings(burstSize*sizeMultiplier, createdPorts[portId].InIndex)
+	// Rings are placed on NUMA socket of port, so they are local for
+	// receiver and following flow functions
+	socket := low.GetPortSocket(portId)
+	rings := low.CreateRingsOnSocket(burstSize*sizeMultiplier, createdPorts[portId].InIndex, socket)
 	addReceiver(portId, rings, createdPorts[portId].InIndex)
-	return newFlow(rings, createdPorts[portId].InIndex), nil
+	OUT = newFlow(rings, createdPorts[portId].InIndex)
+	OUT.socket = socket
+	return OUT, nil
+}
+
+// OSDeviceType is a type of Linux device created by CreateOSDevice.
+type OSDeviceType int
+
+const (
+	// TapDevice is L2 device, packets have Ethernet header
+	TapDevice OSDeviceType = iota
+	// TunDevice is L3 device, Ethernet header with zero addresses is
+	// added to received packets and is removed from sent ones
+	TunDevice
+)
+
+// CreateOSDevice creates Linux tap or tun device with given name and
+// sets it up. After it SetReceiverOS and SetSenderOS with this name
+// receive packets sent by kernel to device and send packets to kernel.
+// It can be used on machines without DPDK capable NICs or to pass
+// selected traffic to daemons. Device is removed when application
+// exits.
+func CreateOSDevice(device string, deviceType OSDeviceType) error {
+	if _, ok := ioDevices[device]; ok {
+		return common.WrapWithNFError(nil, "Device "+device+" is already used", common.BadSocket)
+	}
+	fd := low.InitTapDevice(device, deviceType == TunDevice)
+	if fd == -1 {
+		return common.WrapWithNFError(nil, "Can't create device "+device, common.BadSocket)
+	}
+	ioDevices[device] = osDevice{socket: fd, l3: deviceType == TunDevice}
+	return nil
+}
+
+// getOSDevice returns device created by CreateOSDevice or raw socket
+// of existing Linux interface.
+func getOSDevice(device string) (osDevice, error) {
+	if v, ok := ioDevices[device]; ok {
+		if d, ok := v.(osDevice); ok {
+			return d, nil
+		}
+		return osDevice{}, common.WrapWithNFError(nil, "Device "+device+" is used by AF_XDP", common.BadSocket)
+	}
+	socketID := low.InitDevice(device)
+	if socketID == -1 {
+		return osDevice{}, common.WrapWithNFError(nil, "Can't initialize socket", common.BadSocket)
+	}
+	d := osDevice{socket: socketID}
+	ioDevices[device] = d
+	return d, nil
 }
 
 // SetReceiverOS adds function receive from Linux interface to flow graph.
 // Gets name of device, will return error if can't initialize socket.
-// Creates RAW socket, returns new opened flow with received packets.
+// Creates RAW socket if device wasn't created by CreateOSDevice,
+// returns new opened flow with received packets.
 func SetReceiverOS(device string) (*Flow, error) {
-	v, ok := ioDevices[device]
-	var socketID int
-	if !ok {
-		socketID = low.InitDevice(device)
-		if socketID == -1 {
-			return nil, common.WrapWithNFError(nil, "Can't initialize socket", common.BadSocket)
-		}
-		ioDevices[device] = socketID
-	} else {
-		socketID = v.(int)
+	d, err := getOSDevice(device)
+	if err != nil {
+		return nil, err
 	}
 	rings := low.CreateRings(burstSize*sizeMultiplier, 1)
-	addOSReceiver(socketID, rings)
+	addOSReceiver(d, rings)
 	return newFlow(rings, 1), nil
 }
 
 // SetSenderOS adds function send from flow graph to Linux interface.
 // Gets name of device, will return error if can't initialize socket.
-// Creates RAW socket, sends packets, closes input flow.
+// Creates RAW socket if device wasn't created by CreateOSDevice,
+// sends packets, closes input flow.
 func SetSenderOS(IN *Flow, device string) error {
 	if err := checkFlow(IN); err != nil {
 		return err
 	}
-	v, ok := ioDevices[device]
-	var socketID int
-	if !ok {
-		socketID = low.InitDevice(device)
-		if socketID == -1 {
-			return common.WrapWithNFError(nil, "Can't initialize socket", common.BadSocket)
-		}
-		ioDevices[device] = socketID
-	} else {
-		socketID = v.(int)
+	d, err := getOSDevice(device)
+	if err != nil {
+		return err
 	}
-	addSenderOS(socketID, finishFlow(IN), IN.inIndexNumber)
+	addSenderOS(d, finishFlow(IN), IN.inIndexNumber)
 	return nil
 }
 
@@ -1048,6 +1313,12 @@ func SetSender(IN *Flow, portId uint16) error {
 	if portId >= uint16(len(createdPorts)) {
 		return common.WrapWithNFError(nil, "Requested send port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
 	}
+	if err := checkPortOwner(portId); err != nil {
+		return err
+	}
+	if createdPorts[portId].queueRings != nil {
+		return common.WrapWithNFError(nil, "Port is already used by SetSenderQueues", common.BadArgument)
+	}
 	createdPorts[portId].wasRequested = true
 	if createdPorts[portId].sendRings == nil {
 		// To allow consequent sends to one port, we need to create a send ring
@@ -1086,18 +1357,31 @@ func SetSenderKNI(IN *Flow, kni *Kni) error {
 // SetCopier adds copy function to flow graph.
 // Gets flow which will be copied.
 func SetCopier(IN *Flow) (OUT *Flow, err error) {
+	return setCopier(IN, false)
+}
+
+// SetCloner adds function which works like SetCopier but doesn't copy
+// data of packets. Packets of returned flow are clones of packets of
+// input flow which share data with them, for example to mirror
+// packets which are forwarded. Packets of both flows should not be
+// changed after cloning, SetCopier should be used in this case.
+func SetCloner(IN *Flow) (OUT *Flow, err error) {
+	return setCopier(IN, true)
+}
+
+func setCopier(IN *Flow, clone bool) (OUT *Flow, err error) {
 	if err := checkFlow(IN); err != nil {
 		return nil, err
 	}
 	ringFirst := low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
 	ringSecond := low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
 	if IN.segment == nil {
-		addCopier(IN.current, ringFirst, ringSecond, IN.inIndexNumber)
+		addCopier(IN.current, ringFirst, ringSecond, clone, IN.inIndexNumber)
 	} else {
 		tRing := low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
 		ms := makeSlice(tRing, IN.segment)
 		segmentInsert(IN, ms, false, nil, 0, 0)
-		addCopier(tRing, ringFirst, ringSecond, IN.inIndexNumber)
+		addCopier(tRing, ringFirst, ringSecond, clone, IN.inIndexNumber)
 		IN.segment = nil
 	}
 	IN.current = ringFirst
@@ -1307,6 +1591,46 @@ func GetNameByPort(port uint16) (string, error) {
 	return low.GetNameByPort(port)
 }
 
+// ResolvePort gets the port id from port identifier which is stable
+// across reboots and driver binds. Identifier can be specified as
+// below:
+//
+// - device name as accepted by GetPortByName, for example- 0000:02:00.0
+// - MAC address of port, for example- 3c:fd:fe:9c:5c:d8
+// - port index, for example- 1
+//
+// Returns error if identifier doesn't match any port or if MAC address
+// belongs to several ports. Should be called after SystemInit.
+func ResolvePort(id string) (uint16, error) {
+	if port, err := low.GetPortByName(id); err == nil {
+		return port, nil
+	}
+	if mac, err := types.StringToMACAddress(id); err == nil {
+		found := -1
+		for i := 0; i < low.GetPortsNumber(); i++ {
+			if types.MACAddress(low.GetPortMACAddress(uint16(i))) != mac {
+				continue
+			}
+			if found != -1 {
+				return 0, common.WrapWithNFError(nil, "MAC address "+id+" is ambiguous: it belongs to ports "+
+					strconv.Itoa(found)+" and "+strconv.Itoa(i), common.WrongPort)
+			}
+			found = i
+		}
+		if found == -1 {
+			return 0, common.WrapWithNFError(nil, "No port with MAC address "+id, common.WrongPort)
+		}
+		return uint16(found), nil
+	}
+	if index, err := strconv.ParseUint(id, 10, 16); err == nil {
+		if int(index) >= low.GetPortsNumber() {
+			return 0, common.WrapWithNFError(nil, "Port index "+id+" is out of range", common.WrongPort)
+		}
+		return uint16(index), nil
+	}
+	return 0, common.WrapWithNFError(nil, "No port with name, MAC address or index "+id, common.WrongPort)
+}
+
 // SetIPForPort sets IP for specified port if it was created. Not thread safe.
 // Return error if requested port isn't exist or wasn't previously requested.
 func SetIPForPort(port uint16, ip types.IPv4Address) error {
@@ -1324,6 +1648,7 @@ func newFlow(rings low.Rings, inIndexNumber int32) *Flow {
 	OUT := new(Flow)
 	OUT.current = rings
 	OUT.inIndexNumber = inIndexNumber
+	OUT.socket = low.AnySocket
 	openFlowsNumber++
 	return OUT
 }
@@ -1332,6 +1657,7 @@ func newFlowSegment(segment *processSegment, previous **Func, inIndexNumber int3
 	OUT := newFlow(nil, inIndexNumber)
 	OUT.segment = segment
 	OUT.previous = previous
+	OUT.socket = segment.socket
 	return OUT
 }
 
@@ -1341,7 +1667,7 @@ func finishFlow(IN *Flow) low.Rings {
 		ring = IN.current
 		closeFlow(IN)
 	} else {
-		ring = low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
+		ring = low.CreateRingsOnSocket(burstSize*sizeMultiplier, IN.inIndexNumber, IN.socket)
 		ms := makeSlice(ring, IN.segment)
 		segmentInsert(IN, ms, true, nil, 0, 0)
 	}
@@ -1359,12 +1685,12 @@ func segmentInsert(IN *Flow, f *Func, willClose bool, context UserContext, setTy
 		return err
 	}
 	if IN.segment == nil {
-		IN.segment = addSegment(IN.current, f, IN.inIndexNumber)
+		IN.segment = addSegment(IN.current, f, IN.inIndexNumber, IN.socket)
 		IN.segment.stype = setType
 	} else {
 		if setType > 0 && IN.segment.stype > 0 && setType != IN.segment.stype {
 			// Try to combine scalar and vector code. Start new segment
-			ring := low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
+			ring := low.CreateRingsOnSocket(burstSize*sizeMultiplier, IN.inIndexNumber, IN.socket)
 			ms := makeSlice(ring, IN.segment)
 			segmentInsert(IN, ms, false, nil, 0, 0)
 			IN.segment = nil
@@ -1385,6 +1711,12 @@ func segmentInsert(IN *Flow, f *Func, willClose bool, context UserContext, setTy
 	}
 	IN.segment.contexts = append(IN.segment.contexts, context)
 	f.contextIndex = len(IN.segment.contexts) - 1
+	f.node = IN.segment.name
+	if f.latency != nil {
+		latencyFuncsLock.Lock()
+		latencyFuncs = append(latencyFuncs, f)
+		latencyFuncsLock.Unlock()
+	}
 	return nil
 }
 
@@ -1404,6 +1736,7 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 	}
 	var currentState reportPair
 	var pause int
+	var idle idleState
 	firstFunc := lp.firstFunc
 	// For scalar part
 	var tempPacket *packet.Packet
@@ -1416,7 +1749,8 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 	def := make([]pair, 30, 30)
 	var currentMask [vBurstSize]bool
 	var answers [vBurstSize]uint8
-	tick := time.NewTicker(time.Duration(schedTime) * time.Millisecond)
+	var traces [vBurstSize]*PacketTrace
+	tick := time.NewTicker(time.Duration(loadSchedTime()) * time.Millisecond)
 	stopper[1] <- 2 // Answer that function is ready
 
 	for {
@@ -1435,13 +1769,14 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 			} else {
 				// For any events with this function we should restart timer
 				// We don't do it regularly without any events due to performance
-				tick = time.NewTicker(time.Duration(schedTime) * time.Millisecond)
+				tick = time.NewTicker(time.Duration(loadSchedTime()) * time.Millisecond)
 				currentState = reportPair{}
 			}
 		case <-tick.C:
 			report <- currentState
 			currentState = reportPair{}
 		default:
+			received := false
 			for q := int32(1); q < inIndex[0]+1; q++ {
 				n := IN[inIndex[q]].DequeueBurst(InputMbufs, burstSize)
 				if n == 0 {
@@ -1457,13 +1792,30 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 					currentState.ZeroAttempts[q-1]++
 					continue
 				}
+				received = true
+				var burstStart time.Time
+				if lp.latency != nil {
+					burstStart = time.Now()
+				}
 
 				if scalar { // Scalar code
 					for i := uint(0); i < n; i++ {
 						currentFunc := firstFunc
 						tempPacket = packet.ExtractPacket(InputMbufs[i])
+						var trace *PacketTrace
+						if tracing != nil {
+							trace = tracing.lookup(InputMbufs[i], tempPacket)
+						}
 						for {
-							nextIndex := currentFunc.sFunc(tempPacket, currentFunc, context[currentFunc.contextIndex])
+							var nextIndex uint
+							if currentFunc.latency == nil {
+								nextIndex = currentFunc.sFunc(tempPacket, currentFunc, context[currentFunc.contextIndex])
+							} else {
+								nextIndex = currentFunc.timedSFunc(tempPacket, context[currentFunc.contextIndex])
+							}
+							if trace != nil {
+								tracing.hop(InputMbufs[i], trace, lp.name, currentFunc, nextIndex, OUT)
+							}
 							if currentFunc.followingNumber == 0 {
 								// We have constructSlice -> put packets to output slices
 								OutputMbufs[nextIndex][countOfPackets[nextIndex]] = InputMbufs[i]
@@ -1486,6 +1838,11 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 					}
 				} else { // Vector code
 					packet.ExtractPackets(tempPackets, InputMbufs, n)
+					if tracing != nil {
+						for i := uint(0); i < n; i++ {
+							traces[i] = tracing.lookup(InputMbufs[i], tempPackets[i])
+						}
+					}
 					def[0].f = firstFunc
 					for i := uint(0); i < burstSize; i++ {
 						def[0].mask[i] = (i < n)
@@ -1493,7 +1850,14 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 					st := 0
 					for st != -1 {
 						cur := def[st].f
-						cur.vFunc(tempPackets, &def[st].mask, &answers, cur, context[cur.contextIndex])
+						if cur.latency == nil {
+							cur.vFunc(tempPackets, &def[st].mask, &answers, cur, context[cur.contextIndex])
+						} else {
+							cur.timedVFunc(tempPackets, &def[st].mask, &answers, context[cur.contextIndex])
+						}
+						if tracing != nil {
+							traceVector(InputMbufs, &traces, &def[st].mask, &answers, lp.name, cur, OUT)
+						}
 						if cur.followingNumber == 0 {
 							// We have constructSlice -> put packets inside ring, it is an end of segment
 							count := FillSliceFromMask(InputMbufs, &def[st].mask, OutputMbufs[0])
@@ -1518,6 +1882,19 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 						st--
 					}
 				}
+				if lp.latency != nil {
+					lp.latency.record(uint64(time.Since(burstStart)), uint64(n))
+				}
+			}
+			if received {
+				idle.busy()
+			} else {
+				runMaintenance()
+				if n := idle.idle(); n != 0 {
+					for q := int32(0); q < inIndex[0]; q++ {
+						currentState.ZeroAttempts[q] += n
+					}
+				}
 			}
 		}
 	}
@@ -1538,12 +1915,12 @@ func recvRSS(parameters interface{}, inIndex []int32, flag *int32, coreID int) {
 			i--
 		}
 	}
-	low.ReceiveRSS(uint16(srp.port.PortId), inIndex, srp.out, flag, coreID, &srp.status[index], &srp.stats)
+	low.ReceiveRSS(uint16(srp.port.PortId), inIndex, srp.out, flag, coreID, &srp.status[index], &receivePause, &srp.stats)
 }
 
 func recvOS(parameters interface{}, inIndex []int32, flag *int32, coreID int) {
 	srp := parameters.(*receiveOSParameters)
-	low.ReceiveOS(srp.socket, srp.out[0], flag, coreID, &srp.stats)
+	low.ReceiveOS(srp.device.socket, srp.device.l3, srp.out[0], flag, coreID, &srp.stats)
 }
 
 func recvXDP(parameters interface{}, inIndex []int32, flag *int32, coreID int) {
@@ -1602,7 +1979,7 @@ func pFastGenerate(parameters interface{}, inIndex []int32, stopper [2]chan int,
 	tempPackets := make([]*packet.Packet, burstSize)
 	var currentState reportPair
 	var pause int
-	tick := time.NewTicker(time.Duration(schedTime) * time.Millisecond)
+	tick := time.NewTicker(time.Duration(loadSchedTime()) * time.Millisecond)
 	stopper[1] <- 2 // Answer that function is ready
 	for {
 		select {
@@ -1618,7 +1995,7 @@ func pFastGenerate(parameters interface{}, inIndex []int32, stopper [2]chan int,
 			} else {
 				// For any events with this function we should restart timer
 				// We don't do it regularly without any events due to performance
-				tick = time.NewTicker(time.Duration(schedTime) * time.Millisecond)
+				tick = time.NewTicker(time.Duration(loadSchedTime()) * time.Millisecond)
 				currentState = reportPair{}
 			}
 		case <-tick.C:
@@ -1674,7 +2051,7 @@ func pcopy(parameters interface{}, inIndex []int32, stopper [2]chan int, report
 	var tempPacket2 *packet.Packet
 	var currentState reportPair
 	var pause int
-	tick := time.NewTicker(time.Duration(schedTime) * time.Millisecond)
+	tick := time.NewTicker(time.Duration(loadSchedTime()) * time.Millisecond)
 	stopper[1] <- 2 // Answer that function is ready
 
 	for {
@@ -1688,7 +2065,7 @@ func pcopy(parameters interface{}, inIndex []int32, stopper [2]chan int, report
 			} else {
 				// For any events with this function we should restart timer
 				// We don't do it regularly without any events due to performance
-				tick = time.NewTicker(time.Duration(schedTime) * time.Millisecond)
+				tick = time.NewTicker(time.Duration(loadSchedTime()) * time.Millisecond)
 				currentState = reportPair{}
 			}
 		case <-tick.C:
@@ -1698,7 +2075,20 @@ func pcopy(parameters interface{}, inIndex []int32, stopper [2]chan int, report
 			for q := int32(1); q < inIndex[0]+1; q++ {
 				n := IN[inIndex[q]].DequeueBurst(bufs1, burstSize)
 
-				if n != 0 {
+				if n != 0 && cp.clone {
+					for i := uint(0); i < n; i++ {
+						tempPacket1 = packet.ExtractPacket(bufs1[i])
+						if bufs2[i] = low.CloneMbuf(tempPacket1.CMbuf, mempool); bufs2[i] == 0 {
+							common.LogFatal(common.Debug, "Cannot clone packet: mempool is empty")
+						}
+						if reportMbits {
+							currentState.V.Bytes += uint64(tempPacket1.GetPacketLen())
+						}
+					}
+					safeEnqueue(OUT[inIndex[q]], bufs1, uint(n))
+					safeEnqueue(OUTCopy[inIndex[q]], bufs2, uint(n))
+					currentState.V.Packets += uint64(n)
+				} else if n != 0 {
 					if err := low.AllocateMbufs(bufs2, mempool, n); err != nil {
 						common.LogFatal(common.Debug, err)
 					}
@@ -1738,7 +2128,7 @@ func send(parameters interface{}, inIndex []int32, flag *int32, coreID int) {
 
 func sendOS(parameters interface{}, inIndex []int32, flag *int32, coreID int) {
 	srp := parameters.(*sendOSParameters)
-	low.SendOS(srp.socket, srp.in, flag, coreID, &srp.stats)
+	low.SendOS(srp.device.socket, srp.device.l3, srp.in, flag, coreID, &srp.stats)
 }
 
 func sendXDP(parameters interface{}, inIndex []int32, flag *int32, coreID int) {
@@ -1763,6 +2153,10 @@ func merge(from low.Rings, to low.Rings) {
 			if parameters.out[0] == from[0] {
 				parameters.out = to
 			}
+		case *receiveRingParameters:
+			if parameters.out[0] == from[0] {
+				parameters.out = to
+			}
 		case *generateParameters:
 			if parameters.out[0] == from[0] {
 				parameters.out = to
@@ -1853,6 +2247,22 @@ func vConstructSlice(packets []*packet.Packet, mask *[vBurstSize]bool, answers *
 	answers[0] = uint8(ve.bufIndex)
 }
 
+func periodic(parameters interface{}, inIndex []int32, stopper [2]chan int) {
+	tp := parameters.(*timerParameters)
+	ticker := time.NewTicker(tp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopper[0]:
+			// It is time to close this clone
+			stopper[1] <- 1
+			return
+		case <-ticker.C:
+			tp.function(tp.context)
+		}
+	}
+}
+
 func write(parameters interface{}, inIndex []int32, stopper [2]chan int) {
 	wp := parameters.(*writeParameters)
 	IN := wp.in
@@ -2006,7 +2416,7 @@ func CreateKniDevice(portId uint16, name string) (*Kni, error) {
 	if createdPorts[portId].willKNI {
 		return nil, common.WrapWithNFError(nil, "Requested KNI port already has KNI. Two KNIs for one port are prohibited.", common.MultipleKNIPort)
 	}
-	if core, coreIndex, err := schedState.getCore(); err != nil {
+	if core, coreIndex, err := schedState.getCore(low.GetPortSocket(portId)); err != nil {
 		return nil, err
 	} else {
 		if err := low.CreateKni(portId, uint(core), name); err != nil {
@@ -2070,6 +2480,20 @@ func (timer *Timer) Stop() {
 	}
 }
 
+// SetTimer adds user defined function which is called every interval
+// with given context, for example for ARP refresh, sessions garbage
+// collection or statistics export. If dedicatedCore is true,
+// separate core is used for function calls. Otherwise function is
+// called from scheduler core between its checks, so it should be
+// fast and interval is effectively rounded up to schedTime.
+func SetTimer(interval time.Duration, function TimerFunction, context UserContext, dedicatedCore bool) error {
+	if interval <= 0 {
+		return common.WrapWithNFError(nil, "Timer interval should be positive", common.BadArgument)
+	}
+	addTimerFunction(interval, function, context, dedicatedCore)
+	return nil
+}
+
 // CheckFatal is a default error handling function, which prints error message and
 // makes os.Exit in case of non nil error. Any other error handler can be used instead.
 func CheckFatal(err error) {
@@ -2085,12 +2509,12 @@ func updatePortStatsOne(stats *common.RXTXStats, pkt *packet.Packet) {
 	if useInterlockedCounters {
 		atomic.AddUint64(&stats.PacketsProcessed, 1)
 		if analyzePacketSizes {
-			atomic.AddUint64(&stats.BytesProcessed, uint64(pkt.GetPacketLen()))
+			atomic.AddUint64(&stats.BytesProcessed, byteAccounting.FrameBytes(pkt.GetPacketLen()))
 		}
 	} else {
 		stats.PacketsProcessed++
 		if analyzePacketSizes {
-			stats.BytesProcessed += uint64(pkt.GetPacketLen())
+			stats.BytesProcessed += byteAccounting.FrameBytes(pkt.GetPacketLen())
 		}
 	}
 }
@@ -2113,7 +2537,14 @@ func calculateSize(packetPtrs []uintptr, number uint) uint64 {
 	size := uint64(0)
 	for i := uint(0); i < number; i++ {
 		tempPacket := packet.ExtractPacket(packetPtrs[i])
-		size += uint64(tempPacket.GetPacketLen())
+		size += byteAccounting.FrameBytes(tempPacket.GetPacketLen())
 	}
 	return size
 }
+
+// GetByteAccounting returns byte accounting set by SystemInit, so
+// application counters can count bytes in the same way as framework
+// counters.
+func GetByteAccounting() common.ByteAccounting {
+	return byteAccounting
+}
@@ -0,0 +1,237 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/pmtu"
+	"github.com/intel-go/nff-go/types"
+)
+
+// Maximum number of fragments of one packet: 64KB packet split by
+// minimal MTU.
+const maxFragments = (1<<16)/8 + 1
+
+// Minimal MTU of GSO. IP and TCP headers take at most 120 bytes, so
+// number of segments is less than maxFragments.
+const minGSOMTU = 576
+
+type fragmentParameters struct {
+	in      low.Rings
+	out     low.Rings
+	mtu     uint
+	cache   *pmtu.Cache
+	mempool *low.Mempool
+	// TCP packets are segmented instead of fragmentation
+	gso bool
+}
+
+func addFragmenter(in low.Rings, out low.Rings, mtu uint, cache *pmtu.Cache, gso bool, inIndexNumber int32) {
+	par := new(fragmentParameters)
+	par.in = in
+	par.out = out
+	par.mtu = mtu
+	par.cache = cache
+	par.gso = gso
+	name := "fragmenter"
+	if gso {
+		name = "gso"
+	}
+	par.mempool = low.CreateMempool(name)
+	schedState.addFF(name, nil, nil, pfragment, par, nil, segmentCopy, inIndexNumber, nil)
+}
+
+// SetFragmenter adds clonable function which splits IPv4 packets
+// longer than mtu bytes without L2 header into fragments. It should
+// be placed before sender to link with smaller MTU. L4 checksums of
+// packets should be already calculated. Packets with Don't Fragment
+// flag which don't fit into mtu are dropped. Other packets are passed
+// unchanged.
+func SetFragmenter(IN *Flow, mtu uint) error {
+	return setFragmenter(IN, mtu, nil, false)
+}
+
+// SetPathMTUFragmenter adds clonable function which works like
+// SetFragmenter but splits packets by path MTU of their destination
+// if cache knows it and it is less than mtu. Cache is usually filled
+// by handler which passes ICMP messages received from network to
+// cache.Learn.
+func SetPathMTUFragmenter(IN *Flow, mtu uint, cache *pmtu.Cache) error {
+	if cache == nil {
+		return common.WrapWithNFError(nil, "Path MTU cache is nil", common.BadArgument)
+	}
+	return setFragmenter(IN, mtu, cache, false)
+}
+
+// SetGSO adds clonable function which splits TCP packets longer than
+// mtu bytes without L2 header into TCP segments in software. It is a
+// fallback for ports without TCP segmentation offload, for example
+// large TCP packets which are sent by host through KNI device should
+// pass it before sender. IPv4 and IPv6 packets with TCP checksum
+// offloading should have pseudo header checksum, other packets get
+// full checksums. Packets which aren't TCP and TCP packets which
+// can't be segmented are passed unchanged.
+func SetGSO(IN *Flow, mtu uint) error {
+	if mtu < minGSOMTU {
+		return common.WrapWithNFError(nil, fmt.Sprintf("MTU of GSO should be at least %d", minGSOMTU), common.BadArgument)
+	}
+	return setFragmenter(IN, mtu, nil, true)
+}
+
+func setFragmenter(IN *Flow, mtu uint, cache *pmtu.Cache, gso bool) error {
+	if err := checkFlow(IN); err != nil {
+		return err
+	}
+	if mtu < types.IPv4MinLen+8 {
+		return common.WrapWithNFError(nil, "MTU is too small for IPv4 fragmentation", common.BadArgument)
+	}
+	out := low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
+	if IN.segment == nil {
+		addFragmenter(IN.current, out, mtu, cache, gso, IN.inIndexNumber)
+	} else {
+		tRing := low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
+		ms := makeSlice(tRing, IN.segment)
+		segmentInsert(IN, ms, false, nil, 0, 0)
+		addFragmenter(tRing, out, mtu, cache, gso, IN.inIndexNumber)
+		IN.segment = nil
+	}
+	IN.current = out
+	return nil
+}
+
+// fragment splits packet if it is necessary and appends resulting
+// packets to out. Packets which can't be sent are appended to drop.
+func fragment(fp *fragmentParameters, pkt uintptr, out []uintptr, drop []uintptr, frags []uintptr, fragPkts []*packet.Packet, now time.Time) ([]uintptr, []uintptr) {
+	p := packet.ExtractPacket(pkt)
+	p.ParseL3()
+	if fp.gso {
+		return segmentTCP(fp, p, pkt, out, drop, frags, fragPkts)
+	}
+	ipv4 := p.GetIPv4()
+	if ipv4 == nil {
+		return append(out, pkt), drop
+	}
+	mtu := fp.mtu
+	if fp.cache != nil {
+		if pathMTU, ok := fp.cache.LookupIPv4(ipv4.DstAddr, now); ok && uint(pathMTU) < mtu {
+			mtu = uint(pathMTU)
+		}
+	}
+	number := p.IPv4FragmentsNumber(mtu)
+	if number == 1 {
+		return append(out, pkt), drop
+	}
+	if number == 0 {
+		return out, append(drop, pkt)
+	}
+	n := number - 1
+	if err := low.AllocateMbufs(frags, fp.mempool, n); err != nil {
+		return out, append(drop, pkt)
+	}
+	packet.ExtractPackets(fragPkts, frags, n)
+	if !p.FragmentIPv4(mtu, fragPkts[:n]) {
+		return out, append(append(drop, pkt), frags[:n]...)
+	}
+	return append(append(out, pkt), frags[:n]...), drop
+}
+
+// segmentTCP splits TCP packet into segments if it is necessary and
+// appends resulting packets to out. Packets which aren't TCP are
+// passed unchanged.
+func segmentTCP(fp *fragmentParameters, p *packet.Packet, pkt uintptr, out []uintptr, drop []uintptr, segs []uintptr, segPkts []*packet.Packet) ([]uintptr, []uintptr) {
+	if p.GetIPv4() == nil && p.GetIPv6() == nil {
+		return append(out, pkt), drop
+	}
+	number := p.TCPSegmentsNumber(fp.mtu)
+	if number <= 1 {
+		return append(out, pkt), drop
+	}
+	n := number - 1
+	if err := low.AllocateMbufs(segs, fp.mempool, n); err != nil {
+		return out, append(drop, pkt)
+	}
+	packet.ExtractPackets(segPkts, segs, n)
+	if !p.SegmentTCP(fp.mtu, segPkts[:n]) {
+		return out, append(append(drop, pkt), segs[:n]...)
+	}
+	return append(append(out, pkt), segs[:n]...), drop
+}
+
+func pfragment(parameters interface{}, inIndex []int32, stopper [2]chan int, report chan reportPair, context []UserContext) {
+	fp := parameters.(*fragmentParameters)
+	IN := fp.in
+	OUT := fp.out
+
+	bufs := make([]uintptr, burstSize)
+	out := make([]uintptr, 0, burstSize)
+	drop := make([]uintptr, 0, burstSize)
+	frags := make([]uintptr, maxFragments)
+	fragPkts := make([]*packet.Packet, maxFragments)
+	var currentState reportPair
+	var pause int
+	tick := time.NewTicker(time.Duration(loadSchedTime()) * time.Millisecond)
+	stopper[1] <- 2 // Answer that function is ready
+
+	for {
+		select {
+		case pause = <-stopper[0]:
+			tick.Stop()
+			if pause == -1 {
+				// It is time to remove this clone
+				stopper[1] <- 1
+				return
+			} else {
+				// For any events with this function we should restart timer
+				// We don't do it regularly without any events due to performance
+				tick = time.NewTicker(time.Duration(loadSchedTime()) * time.Millisecond)
+				currentState = reportPair{}
+			}
+		case <-tick.C:
+			report <- currentState
+			currentState = reportPair{}
+		default:
+			for q := int32(1); q < inIndex[0]+1; q++ {
+				n := IN[inIndex[q]].DequeueBurst(bufs, burstSize)
+
+				if n != 0 {
+					out = out[:0]
+					drop = drop[:0]
+					var now time.Time
+					if fp.cache != nil {
+						now = time.Now()
+					}
+					for i := uint(0); i < n; i++ {
+						if reportMbits {
+							currentState.V.Bytes += uint64(packet.ExtractPacket(bufs[i]).GetPacketLen())
+						}
+						out, drop = fragment(fp, bufs[i], out, drop, frags, fragPkts, now)
+					}
+					if len(out) != 0 {
+						safeEnqueue(OUT[inIndex[q]], out, uint(len(out)))
+					}
+					if len(drop) != 0 {
+						low.DirectStop(len(drop), drop)
+					}
+					currentState.V.Packets += uint64(n)
+				}
+				// GO parks goroutines while Sleep. So Sleep lasts more time than our precision
+				// we just want to slow goroutine down without parking, so loop is OK for this.
+				// time.Now lasts approximately 70ns and this satisfies us
+				if pause != 0 {
+					currentState.ZeroAttempts[q-1]++
+					// pause should be non 0 only if function works with ONE inIndex
+					a := time.Now()
+					for time.Since(a) < time.Duration(pause*int(burstSize))*time.Nanosecond {
+					}
+				}
+			}
+		}
+	}
+}
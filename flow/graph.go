@@ -0,0 +1,219 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+)
+
+type graphEdge struct {
+	from  string
+	label string
+}
+
+type graphWriter struct {
+	w io.Writer
+	// Nodes which put packets to ring
+	producers map[*low.Ring][]graphEdge
+	// Nodes which take packets from ring
+	consumers map[*low.Ring][]string
+	// Rings in order of appearance to make output stable
+	rings []*low.Ring
+	// Number of dumped segment functions
+	funcs int
+	err   error
+}
+
+func (g *graphWriter) printf(format string, a ...interface{}) {
+	if g.err == nil {
+		_, g.err = fmt.Fprintf(g.w, format, a...)
+	}
+}
+
+func (g *graphWriter) produce(rings low.Rings, from string, label string) {
+	if len(rings) != 0 {
+		if g.producers[rings[0]] == nil {
+			g.rings = append(g.rings, rings[0])
+		}
+		g.producers[rings[0]] = append(g.producers[rings[0]], graphEdge{from, label})
+	}
+}
+
+func (g *graphWriter) consume(rings low.Rings, to string) {
+	if len(rings) != 0 {
+		g.consumers[rings[0]] = append(g.consumers[rings[0]], to)
+	}
+}
+
+// DumpGraph writes constructed flow graph to w in Graphviz DOT
+// format. Every flow function is shown as a node, segments are shown
+// as clusters of user handlers, splitters and separators. Mergers
+// don't exist as separate nodes, they are shown as several edges
+// entering one node. If DumpGraph is called after SystemStart, nodes
+// are also labeled with cores which are currently used by them.
+// Graph is read under scheduler lock like in GetNodeStats.
+func DumpGraph(w io.Writer) error {
+	if schedState == nil {
+		return common.WrapWithNFError(nil, "SystemInit should be called before DumpGraph", common.Fail)
+	}
+	schedState.tuningLock.Lock()
+	defer schedState.tuningLock.Unlock()
+	g := &graphWriter{
+		w:         w,
+		producers: make(map[*low.Ring][]graphEdge),
+		consumers: make(map[*low.Ring][]string),
+	}
+	g.printf("digraph nffgo {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for i, ff := range schedState.ff {
+		id := fmt.Sprintf("ff%d", i)
+		label := dotQuote(ff.name + "\n" + ffTypeNames[ff.fType] + ff.coresLabel())
+		switch parameters := ff.Parameters.(type) {
+		case *segmentParameters:
+			g.printf("\tsubgraph cluster_%s {\n\t\tlabel=%s;\n", id, label)
+			first := g.dumpFunc(parameters.firstFunc, parameters, id)
+			g.printf("\t}\n")
+			g.consume(parameters.in, first)
+			continue
+		case *receiveParameters:
+			g.produce(parameters.out, id, "")
+		case *receiveOSParameters:
+			g.produce(parameters.out, id, "")
+		case *receiveXDPParameters:
+			g.produce(parameters.out, id, "")
+		case *receiveRingParameters:
+			g.produce(parameters.out, id, "")
+		case *generateParameters:
+			g.produce(parameters.out, id, "")
+		case *readParameters:
+			g.produce(parameters.out, id, "")
+		case *copyParameters:
+			g.produce(parameters.out, id, "")
+			g.produce(parameters.outCopy, id, "copy")
+		case *KNIParameters:
+			g.produce(parameters.out, id, "")
+		case *priorityMergeParameters:
+			g.produce(parameters.out, id, "")
+			// Input rings belong to different flows, the first one
+			// is consumed below
+			for _, r := range ff.inputRings()[1:] {
+				g.consume(low.Rings{r}, id)
+			}
+		}
+		g.consume(ff.inputRings(), id)
+		g.printf("\t%s [label=%s];\n", id, label)
+	}
+	g.printf("\tstop [shape=point];\n")
+	if len(schedState.StopRing) != 0 {
+		g.consumers[schedState.StopRing[0]] = []string{"stop"}
+	}
+	for _, ring := range g.rings {
+		for _, to := range g.consumers[ring] {
+			for _, e := range g.producers[ring] {
+				if e.label != "" {
+					g.printf("\t%s -> %s [label=\"%s\"];\n", e.from, to, e.label)
+				} else {
+					g.printf("\t%s -> %s;\n", e.from, to)
+				}
+			}
+		}
+	}
+	g.printf("}\n")
+	return g.err
+}
+
+// dumpFunc writes nodes of segment function f and all following
+// functions. Returns DOT identifier of f.
+func (g *graphWriter) dumpFunc(f *Func, par *segmentParameters, prefix string) string {
+	id := fmt.Sprintf("%s_f%d", prefix, g.funcs)
+	g.funcs++
+	var label string
+	switch {
+	case f.sHandleFunction != nil:
+		label = "handler"
+	case f.vHandleFunction != nil:
+		label = "vector handler"
+	case f.sSeparateFunction != nil:
+		label = "separator"
+	case f.vSeparateFunction != nil:
+		label = "vector separator"
+	case f.sDropFunction != nil:
+		label = "drop handler"
+	case f.sSplitFunction != nil:
+		label = "splitter"
+	case f.vSplitFunction != nil:
+		label = "vector splitter"
+	default:
+		label = "partitioner"
+	}
+	g.printf("\t\t%s [label=\"%s\"];\n", id, label)
+	for i, next := range f.next {
+		if next == nil {
+			continue
+		}
+		branch := ""
+		if len(f.next) > 1 {
+			branch = fmt.Sprint(i)
+		}
+		if next.followingNumber == 0 {
+			// Slice function puts packets to output ring of segment
+			g.produce((*par.out)[next.bufIndex], id, branch)
+			continue
+		}
+		nextID := g.dumpFunc(next, par, prefix)
+		if branch != "" {
+			g.printf("\t\t%s -> %s [label=\"%s\"];\n", id, nextID, branch)
+		} else {
+			g.printf("\t\t%s -> %s;\n", id, nextID)
+		}
+	}
+	return id
+}
+
+// coresLabel returns list of cores used by all clones of flow
+// function for node label.
+func (ff *flowFunction) coresLabel() string {
+	if ff.fType == comboKNI {
+		return "\nLinux core"
+	}
+	var cores []string
+	for q := 0; q < ff.instanceNumber; q++ {
+		for c := 0; c < ff.instance[q].cloneNumber; c++ {
+			cores = append(cores, fmt.Sprint(schedState.cores[ff.instance[q].clone[c].index].id))
+		}
+	}
+	if len(cores) == 0 {
+		return ""
+	}
+	return "\ncores: " + strings.Join(cores, ",")
+}
+
+// dotQuote returns s as DOT quoted string. Non printable characters
+// are replaced with their numeric values because some node names
+// contain port numbers converted to characters.
+func dotQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '\n':
+			b.WriteString("\\n")
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case !unicode.IsPrint(r):
+			fmt.Fprint(&b, int(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"math/bits"
+	"sync"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// GRETunnel is an IPv4 GRE tunnel of GRETable.
+type GRETunnel struct {
+	// Local and remote endpoints of tunnel, they are source and
+	// destination addresses of outer header of encapsulated packets.
+	Local  types.IPv4Address
+	Remote types.IPv4Address
+	// Key of tunnel, it is used only if HasKey is true
+	Key    uint32
+	HasKey bool
+	// Packets to these subnets are sent to tunnel by encapsulator
+	Subnets []types.IPv4Subnet
+}
+
+// GRETable is a table of tunnels which is used by SetGREEncapsulator
+// and SetGREDecapsulator. Tunnels can be added and removed while flow
+// graph works.
+type GRETable struct {
+	lock    sync.RWMutex
+	tunnels []GRETunnel
+}
+
+// NewGRETable creates empty table of GRE tunnels.
+func NewGRETable() *GRETable {
+	return new(GRETable)
+}
+
+func (t *GRETunnel) sameEndpoints(local, remote types.IPv4Address, key uint32, hasKey bool) bool {
+	return t.Local == local && t.Remote == remote && t.HasKey == hasKey && (!hasKey || t.Key == key)
+}
+
+// Add adds tunnel to table. Returns error if table has tunnel with the
+// same endpoints and key.
+func (table *GRETable) Add(tunnel GRETunnel) error {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	for i := range table.tunnels {
+		if table.tunnels[i].sameEndpoints(tunnel.Local, tunnel.Remote, tunnel.Key, tunnel.HasKey) {
+			return common.WrapWithNFError(nil, "GRE tunnel from "+tunnel.Local.String()+" to "+tunnel.Remote.String()+" already exists", common.BadArgument)
+		}
+	}
+	tunnel.Subnets = append([]types.IPv4Subnet(nil), tunnel.Subnets...)
+	table.tunnels = append(table.tunnels, tunnel)
+	return nil
+}
+
+// Remove removes tunnel with given endpoints and key. Returns false if
+// table doesn't have such tunnel.
+func (table *GRETable) Remove(local, remote types.IPv4Address, key uint32, hasKey bool) bool {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	for i := range table.tunnels {
+		if table.tunnels[i].sameEndpoints(local, remote, key, hasKey) {
+			table.tunnels = append(table.tunnels[:i], table.tunnels[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// lookupDestination returns tunnel with the longest subnet which
+// contains dst.
+func (table *GRETable) lookupDestination(dst types.IPv4Address) (GRETunnel, bool) {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	best, bestLen := -1, -1
+	for i := range table.tunnels {
+		for _, s := range table.tunnels[i].Subnets {
+			if l := bits.OnesCount32(uint32(s.Mask)); l > bestLen && s.CheckIPv4AddressWithinSubnet(dst) {
+				best, bestLen = i, l
+			}
+		}
+	}
+	if best < 0 {
+		return GRETunnel{}, false
+	}
+	return table.tunnels[best], true
+}
+
+// hasTunnel returns true if table has tunnel with given endpoints.
+func (table *GRETable) hasTunnel(local, remote types.IPv4Address, key uint32, hasKey bool) bool {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	for i := range table.tunnels {
+		if table.tunnels[i].sameEndpoints(local, remote, key, hasKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetGREEncapsulator adds handler which encapsulates IPv4 packets to
+// GRE tunnel of table which has the longest subnet containing their
+// destination. Other packets are passed unchanged. Packets which can't
+// be encapsulated are dropped with DropOther reason. Ethernet addresses
+// of encapsulated packets are not changed.
+func SetGREEncapsulator(IN *Flow, table *GRETable) error {
+	if table == nil {
+		return common.WrapWithNFError(nil, "GRE table is nil", common.BadArgument)
+	}
+	return SetHandlerDropReason(IN, func(pkt *packet.Packet, ctx UserContext) DropReason {
+		pkt.ParseL3()
+		ipv4 := pkt.GetIPv4()
+		if ipv4 == nil {
+			return Pass
+		}
+		tunnel, ok := table.lookupDestination(ipv4.DstAddr)
+		if !ok {
+			return Pass
+		}
+		if !pkt.EncapsulateIPv4GRE(tunnel.Local, tunnel.Remote, tunnel.Key, tunnel.HasKey) {
+			return DropOther
+		}
+		return Pass
+	}, nil)
+}
+
+// SetGREDecapsulator adds handler which decapsulates GRE packets of
+// tunnels of table. GRE packets of unknown tunnels are dropped with
+// "gre-unknown-tunnel" reason and malformed GRE packets are dropped
+// with DropOther reason. Other packets are passed unchanged.
+func SetGREDecapsulator(IN *Flow, table *GRETable) error {
+	if table == nil {
+		return common.WrapWithNFError(nil, "GRE table is nil", common.BadArgument)
+	}
+	dropUnknown, err := RegisterDropReason("gre-unknown-tunnel")
+	if err != nil {
+		return err
+	}
+	return SetHandlerDropReason(IN, func(pkt *packet.Packet, ctx UserContext) DropReason {
+		pkt.ParseL3()
+		ipv4 := pkt.GetIPv4()
+		if ipv4 == nil || ipv4.NextProtoID != types.GRENumber {
+			return Pass
+		}
+		pkt.ParseL4ForIPv4()
+		key, hasKey := pkt.GetGRENoCheck().GetKey()
+		if !table.hasTunnel(ipv4.DstAddr, ipv4.SrcAddr, key, hasKey) {
+			return dropUnknown
+		}
+		if !pkt.DecapsulateIPv4GRE() {
+			return DropOther
+		}
+		return Pass
+	}, nil)
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+)
+
+var (
+	// Serializes graph updates
+	graphLock sync.Mutex
+	// Flow graph is changed between BeginGraphUpdate and
+	// CommitGraphUpdate
+	graphUpdate bool
+	// Number of flow functions before graph update
+	graphUpdateStart int
+)
+
+func systemRunning() bool {
+	return schedState != nil && atomic.LoadInt32(&schedState.stopFlag) == process
+}
+
+// BeginGraphUpdate allows to change flow graph while system is
+// running, for example to add receivers and senders of port attached by
+// AttachPort. Flow graph is changed by usual functions after it and
+// CommitGraphUpdate starts new ports and flow functions. Scheduler
+// doesn't make decisions during update, so update should be short.
+// Statistics functions can be called during update, they see new flow
+// functions without instances.
+func BeginGraphUpdate() error {
+	if !systemRunning() {
+		return common.WrapWithNFError(nil, "BeginGraphUpdate should be called after SystemStart", common.Fail)
+	}
+	graphLock.Lock()
+	schedState.tuningLock.Lock()
+	graphUpdate = true
+	graphUpdateStart = len(schedState.ff)
+	schedState.tuningLock.Unlock()
+	return nil
+}
+
+// CommitGraphUpdate creates ports and starts flow functions which were
+// added after BeginGraphUpdate. All flows should be closed. If update
+// can't be applied, added flow functions are removed and ports which
+// weren't started are released.
+func CommitGraphUpdate() error {
+	if !graphUpdate {
+		return common.WrapWithNFError(nil, "CommitGraphUpdate is called without BeginGraphUpdate", common.Fail)
+	}
+	schedState.tuningLock.Lock()
+	defer func() {
+		graphUpdate = false
+		schedState.tuningLock.Unlock()
+		graphLock.Unlock()
+	}()
+	if openFlowsNumber != 0 {
+		rollbackGraphUpdate()
+		return common.WrapWithNFError(nil, "Some flows are left open at the end of graph update!", common.OpenedFlowAtTheEnd)
+	}
+	if err := startPorts(); err != nil {
+		rollbackGraphUpdate()
+		return err
+	}
+	for _, ff := range schedState.ff[graphUpdateStart:] {
+		if err := ff.startNewInstance(constructNewIndex(ff.inIndexNumber), schedState); err != nil {
+			// Ports are already started, so flow functions which
+			// were started are kept
+			return err
+		}
+	}
+	if int(schedState.maxInIndex) >= len(schedState.nAttempts) {
+		schedState.initMeasure()
+	}
+	return nil
+}
+
+func rollbackGraphUpdate() {
+	schedState.ff = schedState.ff[:graphUpdateStart]
+	for i := range createdPorts {
+		if createdPorts[i].wasRequested && !createdPorts[i].started {
+			resetPort(uint16(i))
+		}
+	}
+	openFlowsNumber = 0
+}
+
+// resetPort forgets all flow functions requested for port.
+func resetPort(portId uint16) {
+	p := &createdPorts[portId]
+	p.wasRequested = false
+	p.willReceive = false
+	p.sendRings = nil
+	p.queueRings = nil
+	p.started = false
+}
+
+// AttachPort probes device while system is running or before
+// SystemStart and returns its port. Device is specified by DPDK device
+// arguments, for example PCI address 0000:03:00.0 of NIC bound to DPDK
+// driver or virtual device net_vhost1,iface=/tmp/sock1. After
+// SystemStart it should be called between BeginGraphUpdate and
+// CommitGraphUpdate which also add receivers and senders of port.
+func AttachPort(devargs string) (uint16, error) {
+	if systemRunning() && !graphUpdate {
+		return 0, common.WrapWithNFError(nil, "AttachPort should be called by BeginGraphUpdate after SystemStart", common.Fail)
+	}
+	if secondaryProcess {
+		return 0, common.WrapWithNFError(nil, "Ports can't be attached by secondary process", common.WrongPort)
+	}
+	portId, err := low.AttachPort(devargs)
+	if err != nil {
+		return 0, err
+	}
+	addPort(portId)
+	createdPorts[portId].InIndex = portInIndex(portId)
+	common.LogDebug(common.Initialization, "Device", devargs, "is attached as port", portId)
+	return portId, nil
+}
+
+// usesPort returns true if flow function receives packets from port or
+// sends packets to port.
+func (ff *flowFunction) usesPort(portId uint16) bool {
+	switch parameters := ff.Parameters.(type) {
+	case *receiveParameters:
+		return uint16(parameters.port.PortId) == portId
+	case *sendParameters:
+		return parameters.port == portId
+	case *sendQueueParameters:
+		return parameters.port == portId
+	}
+	return false
+}
+
+// freeRings frees packets which are left in rings.
+func freeRings(rings low.Rings) {
+	bufs := make([]uintptr, burstSize)
+	for _, r := range rings {
+		for n := r.DequeueBurst(bufs, burstSize); n != 0; n = r.DequeueBurst(bufs, burstSize) {
+			low.DirectStop(int(n), bufs)
+		}
+	}
+}
+
+// DetachPort stops receivers and senders of port, closes port and
+// removes its device, so NIC can be unplugged or virtual device is
+// destroyed. Flow functions between receiver and sender are kept, so
+// when pipeline connects two ports, both of them should be detached.
+// Packets which other receivers send to detached port are dropped.
+func DetachPort(portId uint16) error {
+	if int(portId) >= len(createdPorts) {
+		return common.WrapWithNFError(nil, "Port "+strconv.Itoa(int(portId))+" doesn't exist", common.WrongPort)
+	}
+	if err := checkPortOwner(portId); err != nil {
+		return err
+	}
+	if createdPorts[portId].willKNI {
+		return common.WrapWithNFError(nil, "Port "+strconv.Itoa(int(portId))+" with KNI device can't be detached", common.WrongPort)
+	}
+	if !graphUpdate && schedState != nil {
+		graphLock.Lock()
+		defer graphLock.Unlock()
+	}
+	if schedState != nil {
+		schedState.tuningLock.Lock()
+		defer schedState.tuningLock.Unlock()
+	}
+	if schedState != nil {
+		kept := make([]*flowFunction, 0, len(schedState.ff))
+		removedBeforeUpdate := 0
+		for i, ff := range schedState.ff {
+			if !ff.usesPort(portId) {
+				kept = append(kept, ff)
+				continue
+			}
+			if i < graphUpdateStart {
+				removedBeforeUpdate++
+			}
+			for ff.instanceNumber != 0 {
+				ff.stopInstance(0, -1, schedState)
+			}
+			freeRings(ff.inputRings())
+			common.LogDebug(common.Initialization, "Flow function", ff.name, "of detached port is removed")
+		}
+		if graphUpdate {
+			graphUpdateStart -= removedBeforeUpdate
+		}
+		schedState.ff = kept
+	}
+	if createdPorts[portId].started {
+		low.FlushHWRules(portId)
+	}
+	if err := low.DetachPort(portId); err != nil {
+		return err
+	}
+	resetPort(portId)
+	removeLinkCallbacks(portId)
+	removeBond(portId)
+	for ip, p := range portPair {
+		if p.port == portId {
+			delete(portPair, ip)
+		}
+	}
+	for socket, p := range vhostPorts {
+		if p == portId {
+			delete(vhostPorts, socket)
+		}
+	}
+	for key, p := range memifPorts {
+		if p == portId {
+			delete(memifPorts, key)
+		}
+	}
+	common.LogDebug(common.Initialization, "Port", portId, "is detached")
+	return nil
+}
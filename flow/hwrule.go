@@ -0,0 +1,145 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// HWAction is an action of hardware rule.
+type HWAction uint8
+
+const (
+	// HWActionDrop drops matched packets in NIC
+	HWActionDrop HWAction = iota
+	// HWActionQueue steers matched packets to receive queue
+	HWActionQueue
+)
+
+// HWRule describes rule which is installed to NIC with DPDK rte_flow
+// API and is applied to received IPv4 packets before they reach
+// receiver, for example to drop packets of blacklisted sources or
+// to steer forwarded port to dedicated queue.
+type HWRule struct {
+	// Source and destination subnets, zero subnet matches any address
+	Src, Dst types.IPv4Subnet
+	// IP protocol, zero matches any protocol
+	Proto uint8
+	// TCP or UDP ports, zero port matches any port. Ports can be
+	// used only if Proto is TCP or UDP.
+	SrcPort, DstPort uint16
+	// Rules with lower priority values are matched first
+	Priority uint32
+	Action   HWAction
+	// Receive queue of HWActionQueue, it should be less than number
+	// of queues of port
+	Queue uint16
+}
+
+// HWRuleHandle is a rule installed by AddHWRule.
+type HWRuleHandle struct {
+	port uint16
+	flow *low.HWFlow
+}
+
+func (rule *HWRule) convert(portId uint16) (*low.HWRule, error) {
+	if (rule.SrcPort != 0 || rule.DstPort != 0) && rule.Proto != types.TCPNumber && rule.Proto != types.UDPNumber {
+		return nil, common.WrapWithNFError(nil, "Ports of hardware rule require TCP or UDP protocol", common.BadArgument)
+	}
+	r := &low.HWRule{
+		SrcAddr:  uint32(rule.Src.Addr & rule.Src.Mask),
+		SrcMask:  uint32(rule.Src.Mask),
+		DstAddr:  uint32(rule.Dst.Addr & rule.Dst.Mask),
+		DstMask:  uint32(rule.Dst.Mask),
+		SrcPort:  packet.SwapBytesUint16(rule.SrcPort),
+		DstPort:  packet.SwapBytesUint16(rule.DstPort),
+		Proto:    rule.Proto,
+		Priority: rule.Priority,
+	}
+	if rule.SrcPort != 0 {
+		r.SrcPortMask = 0xffff
+	}
+	if rule.DstPort != 0 {
+		r.DstPortMask = 0xffff
+	}
+	switch rule.Action {
+	case HWActionDrop:
+		r.Drop = true
+	case HWActionQueue:
+		if int32(rule.Queue) >= createdPorts[portId].InIndex {
+			return nil, common.WrapWithNFError(nil, fmt.Sprintf("Port %d has no receive queue %d", portId, rule.Queue), common.BadArgument)
+		}
+		r.Queue = rule.Queue
+	default:
+		return nil, common.WrapWithNFError(nil, "Unknown action of hardware rule", common.BadArgument)
+	}
+	return r, nil
+}
+
+func checkStartedPort(portId uint16) error {
+	if portId >= uint16(len(createdPorts)) {
+		return common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	if !createdPorts[portId].started {
+		return common.WrapWithNFError(nil, fmt.Sprintf("Port %d is not started", portId), common.BadArgument)
+	}
+	return nil
+}
+
+// ValidateHWRule checks that rule can be installed to NIC of port
+// without installing it. Port should be started, so it should be
+// called after SystemInitPortsAndMemory.
+func ValidateHWRule(portId uint16, rule HWRule) error {
+	if err := checkStartedPort(portId); err != nil {
+		return err
+	}
+	r, err := rule.convert(portId)
+	if err != nil {
+		return err
+	}
+	_, err = low.CreateHWRule(portId, r, true)
+	return err
+}
+
+// AddHWRule installs rule to NIC of port. Port should be started, so
+// it should be called after SystemInitPortsAndMemory, rules can be
+// added and removed while packets are processed. Returns error with
+// driver message if NIC doesn't support rule. All rules are removed
+// by SystemStop.
+func AddHWRule(portId uint16, rule HWRule) (*HWRuleHandle, error) {
+	if err := checkStartedPort(portId); err != nil {
+		return nil, err
+	}
+	r, err := rule.convert(portId)
+	if err != nil {
+		return nil, err
+	}
+	flow, err := low.CreateHWRule(portId, r, false)
+	if err != nil {
+		return nil, err
+	}
+	return &HWRuleHandle{port: portId, flow: flow}, nil
+}
+
+// Remove removes rule from NIC.
+func (h *HWRuleHandle) Remove() error {
+	if err := checkStartedPort(h.port); err != nil {
+		return err
+	}
+	return low.DestroyHWRule(h.port, h.flow)
+}
+
+// FlushHWRules removes all rules from NIC of port.
+func FlushHWRules(portId uint16) error {
+	if err := checkStartedPort(portId); err != nil {
+		return err
+	}
+	return low.FlushHWRules(portId)
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"time"
+)
+
+// Maximum sleep of idle flow functions, zero means busy polling
+var idleMaxSleep time.Duration
+
+// Number of consecutive empty polls after which flow function starts
+// sleeping
+const idleSpinPolls = 256
+
+// idleState implements back-off of polling loop in power-saving idle
+// mode. Loop calls idle after every round without packets and busy
+// after every round with packets.
+type idleState struct {
+	polls     uint
+	sleep     time.Duration
+	spinStart time.Time
+	pollTime  time.Duration
+}
+
+// idle returns number of empty polls which loop would make while it
+// slept. Scheduler removes clones by number of empty polls, so loop
+// should add it to its empty polls, otherwise sleeping clones look
+// busy.
+func (s *idleState) idle() uint64 {
+	if idleMaxSleep == 0 {
+		return 0
+	}
+	s.polls++
+	if s.polls == 1 {
+		s.spinStart = time.Now()
+	}
+	if s.polls < idleSpinPolls {
+		return 0
+	}
+	if s.polls == idleSpinPolls {
+		s.pollTime = time.Since(s.spinStart) / idleSpinPolls
+		if s.pollTime == 0 {
+			s.pollTime = 1
+		}
+	}
+	if s.sleep == 0 {
+		s.sleep = time.Microsecond
+	} else if s.sleep < idleMaxSleep {
+		s.sleep *= 2
+	}
+	if s.sleep > idleMaxSleep {
+		s.sleep = idleMaxSleep
+	}
+	start := time.Now()
+	time.Sleep(s.sleep)
+	return uint64(time.Since(start) / s.pollTime)
+}
+
+func (s *idleState) busy() {
+	s.polls = 0
+	s.sleep = 0
+}
@@ -0,0 +1,220 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"math/bits"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/packet"
+)
+
+// Enables timestamping of packets in segments, set by
+// Config.LatencyInstrumentation
+var latencyEnabled bool
+
+// Every power of two of latency is divided into this number of
+// buckets, so percentiles are precise to 1/latencySubBuckets.
+const (
+	latencySubBucketsBits = 3
+	latencySubBuckets     = 1 << latencySubBucketsBits
+	latencyBuckets        = (64 - latencySubBucketsBits + 1) * latencySubBuckets
+)
+
+// latencyHistogram is a histogram of latencies in nanoseconds with
+// logarithmic buckets. It is updated by all clones of flow function
+// simultaneously.
+type latencyHistogram struct {
+	counts [latencyBuckets]uint64
+	max    uint64
+}
+
+func latencyBucket(ns uint64) int {
+	if ns < latencySubBuckets {
+		return int(ns)
+	}
+	e := uint(bits.Len64(ns) - 1)
+	sub := int(ns>>(e-latencySubBucketsBits)) & (latencySubBuckets - 1)
+	return int(e-latencySubBucketsBits+1)*latencySubBuckets + sub
+}
+
+// latencyBucketLimit returns maximum latency of bucket.
+func latencyBucketLimit(b int) uint64 {
+	if b < latencySubBuckets {
+		return uint64(b)
+	}
+	e := uint(b/latencySubBuckets + latencySubBucketsBits - 1)
+	sub := uint64(b % latencySubBuckets)
+	return (latencySubBuckets+sub+1)<<(e-latencySubBucketsBits) - 1
+}
+
+// record adds count packets which were processed during ns
+// nanoseconds.
+func (h *latencyHistogram) record(ns uint64, count uint64) {
+	atomic.AddUint64(&h.counts[latencyBucket(ns)], count)
+	for {
+		max := atomic.LoadUint64(&h.max)
+		if ns <= max || atomic.CompareAndSwapUint64(&h.max, max, ns) {
+			return
+		}
+	}
+}
+
+func (h *latencyHistogram) reset() {
+	for i := range h.counts {
+		atomic.StoreUint64(&h.counts[i], 0)
+	}
+	atomic.StoreUint64(&h.max, 0)
+}
+
+// LatencyStats contains percentiles of processing time of packets.
+// Percentiles are upper bounds of histogram buckets, so they can
+// exceed real values by 1/8.
+type LatencyStats struct {
+	// Number of measured packets
+	Samples uint64
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+	P999    time.Duration
+	Max     time.Duration
+}
+
+func (h *latencyHistogram) stats() LatencyStats {
+	var counts [latencyBuckets]uint64
+	var ls LatencyStats
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+		ls.Samples += counts[i]
+	}
+	ls.Max = time.Duration(atomic.LoadUint64(&h.max))
+	if ls.Samples == 0 {
+		return ls
+	}
+	percentiles := []struct {
+		fraction float64
+		value    *time.Duration
+	}{{0.5, &ls.P50}, {0.9, &ls.P90}, {0.99, &ls.P99}, {0.999, &ls.P999}}
+	var sum uint64
+	p := 0
+	for i := 0; i < latencyBuckets && p < len(percentiles); i++ {
+		sum += counts[i]
+		for p < len(percentiles) && float64(sum) >= percentiles[p].fraction*float64(ls.Samples) {
+			*percentiles[p].value = time.Duration(latencyBucketLimit(i))
+			p++
+		}
+	}
+	// Maximum is exact, bucket limits shouldn't exceed it
+	for _, pc := range percentiles {
+		if *pc.value > ls.Max {
+			*pc.value = ls.Max
+		}
+	}
+	return ls
+}
+
+// HandlerLatency contains processing time of one user function.
+type HandlerLatency struct {
+	// Name of user function, for example main.PublicToPrivateTranslation
+	Name string
+	// Name of segment node which contains this function
+	Node string
+	LatencyStats
+}
+
+// Functions with latency instrumentation in order of graph
+// construction. Functions can be added by graph update while their
+// latency is read.
+var (
+	latencyFuncs     []*Func
+	latencyFuncsLock sync.Mutex
+)
+
+func functionName(functions ...interface{}) string {
+	for _, f := range functions {
+		v := reflect.ValueOf(f)
+		if v.Kind() == reflect.Func && !v.IsNil() {
+			if rf := runtime.FuncForPC(v.Pointer()); rf != nil {
+				return rf.Name()
+			}
+		}
+	}
+	return ""
+}
+
+// instrument saves name of user function for tracing and enables
+// its latency measurement if it was requested in Config.
+func (f *Func) instrument(functions ...interface{}) {
+	f.name = functionName(functions...)
+	if latencyEnabled {
+		f.latency = new(latencyHistogram)
+	}
+}
+
+// timedSFunc calls scalar function and measures its processing time.
+func (f *Func) timedSFunc(pkt *packet.Packet, ctx UserContext) uint {
+	start := time.Now()
+	next := f.sFunc(pkt, f, ctx)
+	f.latency.record(uint64(time.Since(start)), 1)
+	return next
+}
+
+// timedVFunc calls vector function and measures processing time of
+// burst. Every packet of burst gets average processing time.
+func (f *Func) timedVFunc(packets []*packet.Packet, mask *[vBurstSize]bool, answers *[vBurstSize]uint8, ctx UserContext) {
+	var n uint64
+	for i := range mask {
+		if mask[i] {
+			n++
+		}
+	}
+	start := time.Now()
+	f.vFunc(packets, mask, answers, f, ctx)
+	if n != 0 {
+		f.latency.record(uint64(time.Since(start))/n, n)
+	}
+}
+
+// GetHandlerLatency returns processing time of all user handlers,
+// separators and splitters in segments. It is empty unless
+// Config.LatencyInstrumentation is set. Values are accumulated since
+// SystemStart or last ResetLatencyStats.
+func GetHandlerLatency() []HandlerLatency {
+	latencyFuncsLock.Lock()
+	defer latencyFuncsLock.Unlock()
+	ret := make([]HandlerLatency, len(latencyFuncs))
+	for i, f := range latencyFuncs {
+		ret[i] = HandlerLatency{
+			Name:         f.name,
+			Node:         f.node,
+			LatencyStats: f.latency.stats(),
+		}
+	}
+	return ret
+}
+
+// ResetLatencyStats clears latency of all handlers and nodes, so
+// following measurements reflect current traffic only.
+func ResetLatencyStats() {
+	latencyFuncsLock.Lock()
+	for _, f := range latencyFuncs {
+		f.latency.reset()
+	}
+	latencyFuncsLock.Unlock()
+	if schedState == nil {
+		return
+	}
+	schedState.tuningLock.Lock()
+	defer schedState.tuningLock.Unlock()
+	for _, ff := range schedState.ff {
+		if par, ok := ff.Parameters.(*segmentParameters); ok && par.latency != nil {
+			par.latency.reset()
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+)
+
+// LinkFunction is called when link of port goes up or down. speed is
+// a link speed in Mbps, it is zero if link is down.
+type LinkFunction func(port uint16, up bool, speed uint32)
+
+// Interval of link state polling. Link state change interrupts aren't
+// supported by all drivers, so link state is polled.
+const linkCheckInterval = 100 * time.Millisecond
+
+type linkState struct {
+	callbacks []LinkFunction
+	up        bool
+	speed     uint32
+	// State was read at least once
+	known bool
+}
+
+var (
+	linkLock sync.Mutex
+	links    = make(map[uint16]*linkState)
+	// Not zero if monitor goroutine is started, accessed atomically
+	linkMonitorStarted int32
+)
+
+// RegisterLinkCallback registers function which is called when link of
+// port goes up or down. Several functions can be registered for one
+// port, they are called in order of registration. Functions are called
+// from separate goroutine after SystemStart, the first call happens
+// only after link state changes. Current state can be read by
+// GetPortLinkStatus. Callbacks of detached port are removed.
+func RegisterLinkCallback(port uint16, fn LinkFunction) error {
+	if fn == nil {
+		return common.WrapWithNFError(nil, "Link callback should not be nil", common.BadArgument)
+	}
+	if int(port) >= len(createdPorts) {
+		return common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	linkLock.Lock()
+	l := links[port]
+	if l == nil {
+		l = new(linkState)
+		links[port] = l
+	}
+	l.callbacks = append(l.callbacks, fn)
+	linkLock.Unlock()
+	// Callbacks can be registered after start
+	if atomic.LoadInt32(&schedState.stopFlag) == process {
+		startLinkMonitor()
+	}
+	return nil
+}
+
+// GetPortLinkStatus returns true if link of port is up and link speed
+// in Mbps. It doesn't wait for completion of autonegotiation.
+func GetPortLinkStatus(port uint16) (bool, uint32) {
+	return low.GetPortLinkStatus(port)
+}
+
+// getLinkCallbacks returns copy of callbacks of port, so that other
+// modules can report link state changes which they detect.
+func getLinkCallbacks(port uint16) []LinkFunction {
+	linkLock.Lock()
+	defer linkLock.Unlock()
+	if l := links[port]; l != nil {
+		return append([]LinkFunction(nil), l.callbacks...)
+	}
+	return nil
+}
+
+// removeLinkCallbacks removes callbacks of detached port.
+func removeLinkCallbacks(port uint16) {
+	linkLock.Lock()
+	delete(links, port)
+	linkLock.Unlock()
+}
+
+func startLinkMonitor() {
+	linkLock.Lock()
+	empty := len(links) == 0
+	linkLock.Unlock()
+	if empty || !atomic.CompareAndSwapInt32(&linkMonitorStarted, 0, 1) {
+		return
+	}
+	go linkMonitor()
+}
+
+type linkEvent struct {
+	port      uint16
+	up        bool
+	speed     uint32
+	callbacks []LinkFunction
+}
+
+func linkMonitor() {
+	for atomic.LoadInt32(&schedState.stopFlag) == process {
+		for _, e := range checkLinks() {
+			if e.up {
+				common.LogDebug(common.Debug, "Link of port", e.port, "is up, speed", e.speed, "Mbps")
+			} else {
+				common.LogWarning(common.Debug, "Link of port", e.port, "is down")
+			}
+			for _, fn := range e.callbacks {
+				fn(e.port, e.up, e.speed)
+			}
+		}
+		time.Sleep(linkCheckInterval)
+	}
+	atomic.StoreInt32(&linkMonitorStarted, 0)
+}
+
+// checkLinks reads link state of all ports with callbacks and returns
+// changes. Callbacks are called without lock, so they can register
+// other callbacks.
+func checkLinks() []linkEvent {
+	var events []linkEvent
+	linkLock.Lock()
+	defer linkLock.Unlock()
+	for port, l := range links {
+		up, speed := low.GetPortLinkStatus(port)
+		if !up {
+			speed = 0
+		}
+		changed := l.known && up != l.up
+		l.up, l.speed, l.known = up, speed, true
+		if changed {
+			events = append(events, linkEvent{port, up, speed, append([]LinkFunction(nil), l.callbacks...)})
+		}
+	}
+	return events
+}
@@ -0,0 +1,207 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// LLDPConfig is a configuration of LLDP agent of port.
+type LLDPConfig struct {
+	// Port which sends LLDP frames and receives frames of neighbors
+	Port uint16
+	// Chassis ID, MAC address of port is used if it is empty
+	ChassisID string
+	// Port ID, number of port is used if it is empty
+	PortID            string
+	PortDescription   string
+	SystemName        string
+	SystemDescription string
+	// Supported and enabled system capabilities, router capability is
+	// used if both are zero
+	Capabilities        uint16
+	EnabledCapabilities uint16
+	// Interval between LLDP frames, default is 30 seconds. Neighbors
+	// keep information for four intervals.
+	Interval time.Duration
+}
+
+// LLDPNeighbor is information about neighbor which was received by
+// LLDP agent.
+type LLDPNeighbor struct {
+	// Port which received information
+	Port uint16
+	// Source address of the last frame
+	Source types.MACAddress
+	// Information doesn't point to packet data
+	Info    packet.LLDPInfo
+	Updated time.Time
+	Expires time.Time
+}
+
+// Default interval between LLDP frames
+const lldpDefaultInterval = 30 * time.Second
+
+// Neighbors keep information for lldpTTLMultiplier intervals
+const lldpTTLMultiplier = 4
+
+var lldpNeighbors struct {
+	sync.Mutex
+	// Neighbors of ports indexed by chassis ID and port ID
+	ports map[uint16]map[string]*LLDPNeighbor
+}
+
+// SetLLDP adds LLDP agent of port to flow. IN should be flow of
+// packets received from port, agent removes LLDP frames from it and
+// keeps information about neighbors which can be read by
+// GetLLDPNeighbors. LLDP frames are sent to port every interval after
+// SystemStart.
+func SetLLDP(IN *Flow, config LLDPConfig) error {
+	if int(config.Port) >= len(createdPorts) {
+		return common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	if config.Interval == 0 {
+		config.Interval = lldpDefaultInterval
+	}
+	ttl := config.Interval * lldpTTLMultiplier / time.Second
+	if config.Interval < time.Second || ttl > 0xffff {
+		return common.WrapWithNFError(nil, fmt.Sprintf("Wrong LLDP interval %v", config.Interval), common.BadArgument)
+	}
+	mac := types.MACAddress(GetPortMACAddress(config.Port))
+	info := packet.LLDPInfo{
+		ChassisIDSubtype:    packet.LLDPChassisIDMACAddress,
+		ChassisID:           mac[:],
+		PortIDSubtype:       packet.LLDPPortIDLocal,
+		PortID:              []byte(strconv.Itoa(int(config.Port))),
+		TTL:                 uint16(ttl),
+		PortDescription:     []byte(config.PortDescription),
+		SystemName:          []byte(config.SystemName),
+		SystemDescription:   []byte(config.SystemDescription),
+		Capabilities:        config.Capabilities,
+		EnabledCapabilities: config.EnabledCapabilities,
+	}
+	if config.ChassisID != "" {
+		info.ChassisIDSubtype = packet.LLDPChassisIDLocal
+		info.ChassisID = []byte(config.ChassisID)
+	}
+	if config.PortID != "" {
+		info.PortIDSubtype = packet.LLDPPortIDInterfaceName
+		info.PortID = []byte(config.PortID)
+	}
+	if info.Capabilities == 0 && info.EnabledCapabilities == 0 {
+		info.Capabilities = packet.LLDPCapabilityRouter
+		info.EnabledCapabilities = packet.LLDPCapabilityRouter
+	}
+	if _, ok := info.AppendTo(nil); !ok {
+		return common.WrapWithNFError(nil, "LLDP IDs or descriptions are too long", common.BadArgument)
+	}
+
+	lldpNeighbors.Lock()
+	if lldpNeighbors.ports == nil {
+		lldpNeighbors.ports = make(map[uint16]map[string]*LLDPNeighbor)
+	}
+	if lldpNeighbors.ports[config.Port] != nil {
+		lldpNeighbors.Unlock()
+		return common.WrapWithNFError(nil, fmt.Sprintf("LLDP agent of port %d is already set", config.Port), common.BadArgument)
+	}
+	neighbors := make(map[string]*LLDPNeighbor)
+	lldpNeighbors.ports[config.Port] = neighbors
+	lldpNeighbors.Unlock()
+
+	if err := SetHandlerDrop(IN, func(pkt *packet.Packet, ctx UserContext) bool {
+		data := pkt.GetLLDP()
+		if data == nil {
+			return true
+		}
+		var received packet.LLDPInfo
+		if packet.ParseLLDP(data, &received) {
+			updateLLDPNeighbor(neighbors, config.Port, pkt.Ether.SAddr, &received, time.Now())
+		}
+		return false
+	}, nil); err != nil {
+		return err
+	}
+	return SetTimer(config.Interval, func(UserContext) {
+		sendLLDP(config.Port, mac, &info)
+		expireLLDPNeighbors(neighbors, time.Now())
+	}, nil, false)
+}
+
+// updateLLDPNeighbor stores copy of received information, neighbor is
+// removed if TTL is zero.
+func updateLLDPNeighbor(neighbors map[string]*LLDPNeighbor, port uint16, source types.MACAddress, info *packet.LLDPInfo, now time.Time) {
+	key := string(info.ChassisID) + "\x00" + string(info.PortID)
+	lldpNeighbors.Lock()
+	defer lldpNeighbors.Unlock()
+	if info.TTL == 0 {
+		delete(neighbors, key)
+		return
+	}
+	n := &LLDPNeighbor{
+		Port:    port,
+		Source:  source,
+		Info:    *info,
+		Updated: now,
+		Expires: now.Add(time.Duration(info.TTL) * time.Second),
+	}
+	for _, b := range []*[]byte{&n.Info.ChassisID, &n.Info.PortID, &n.Info.PortDescription, &n.Info.SystemName, &n.Info.SystemDescription} {
+		*b = append([]byte(nil), *b...)
+	}
+	neighbors[key] = n
+}
+
+func expireLLDPNeighbors(neighbors map[string]*LLDPNeighbor, now time.Time) {
+	lldpNeighbors.Lock()
+	defer lldpNeighbors.Unlock()
+	for key, n := range neighbors {
+		if now.After(n.Expires) {
+			delete(neighbors, key)
+		}
+	}
+}
+
+func sendLLDP(port uint16, mac types.MACAddress, info *packet.LLDPInfo) {
+	pkt, err := packet.NewPacket()
+	if err != nil {
+		return
+	}
+	if !packet.InitLLDPPacket(pkt, mac, info) {
+		low.DirectStop(1, []uintptr{pkt.ToUintptr()})
+		return
+	}
+	low.DirectSend(pkt.CMbuf, port)
+}
+
+// GetLLDPNeighbors returns neighbors of port which were received by
+// its LLDP agent and haven't expired yet. Neighbors are sorted by
+// chassis ID and port ID.
+func GetLLDPNeighbors(port uint16) []LLDPNeighbor {
+	now := time.Now()
+	lldpNeighbors.Lock()
+	var ret []LLDPNeighbor
+	for _, n := range lldpNeighbors.ports[port] {
+		if !now.After(n.Expires) {
+			ret = append(ret, *n)
+		}
+	}
+	lldpNeighbors.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := &ret[i].Info, &ret[j].Info
+		if string(a.ChassisID) != string(b.ChassisID) {
+			return string(a.ChassisID) < string(b.ChassisID)
+		}
+		return string(a.PortID) < string(b.PortID)
+	})
+	return ret
+}
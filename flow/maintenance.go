@@ -0,0 +1,136 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// MaintenanceFunction is a low-priority periodic job like expiry of
+// table entries, aggregation of statistics or refresh of ARP entries.
+type MaintenanceFunction func(now time.Time)
+
+type maintenanceJob struct {
+	name   string
+	period int64
+	fn     MaintenanceFunction
+	// Time of next run in nanoseconds
+	next int64
+	// Job is claimed by one thread at a time
+	running int32
+
+	runs        uint64
+	backstop    uint64
+	maxDuration int64
+}
+
+// Jobs are added before SystemStart and don't change later, so
+// polling loops read list without lock.
+var maintenanceJobs []*maintenanceJob
+
+// AddMaintenanceJob adds function which is called every period from
+// idle rounds of polling loops of segments, so jobs run on data-path
+// cores between bursts of packets instead of separate goroutines which
+// can be scheduled by Go runtime on cores of polling loops. At most
+// one job is run in every idle round, so job should take much less
+// time than a burst of packets. If polling loops have no idle rounds
+// during the whole period after job is due, job is run by scheduler.
+// Jobs should be added before SystemStart.
+func AddMaintenanceJob(name string, period time.Duration, fn MaintenanceFunction) error {
+	if period <= 0 || fn == nil {
+		return common.WrapWithNFError(nil, "Maintenance job "+name+" should have function and positive period", common.BadArgument)
+	}
+	for _, j := range maintenanceJobs {
+		if j.name == name {
+			return common.WrapWithNFError(nil, "Maintenance job "+name+" is already added", common.BadArgument)
+		}
+	}
+	maintenanceJobs = append(maintenanceJobs, &maintenanceJob{
+		name:   name,
+		period: int64(period),
+		fn:     fn,
+		next:   time.Now().UnixNano() + int64(period),
+	})
+	return nil
+}
+
+// run calls job function if job is due at now and isn't running in
+// other thread. Returns true if function was called.
+func (j *maintenanceJob) run(now time.Time, due int64, backstop bool) bool {
+	t := now.UnixNano()
+	if t < atomic.LoadInt64(&j.next)+due || !atomic.CompareAndSwapInt32(&j.running, 0, 1) {
+		return false
+	}
+	// Other thread could run job before it was claimed
+	if t < atomic.LoadInt64(&j.next)+due {
+		atomic.StoreInt32(&j.running, 0)
+		return false
+	}
+	j.fn(now)
+	d := int64(time.Since(now))
+	if d > atomic.LoadInt64(&j.maxDuration) {
+		atomic.StoreInt64(&j.maxDuration, d)
+	}
+	atomic.AddUint64(&j.runs, 1)
+	if backstop {
+		atomic.AddUint64(&j.backstop, 1)
+	}
+	atomic.StoreInt64(&j.next, t+j.period)
+	atomic.StoreInt32(&j.running, 0)
+	return true
+}
+
+// runMaintenance runs one due job, it is called by polling loops in
+// rounds without packets.
+func runMaintenance() {
+	if len(maintenanceJobs) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, j := range maintenanceJobs {
+		if j.run(now, 0, false) {
+			return
+		}
+	}
+}
+
+// runOverdueMaintenance runs jobs which weren't run by polling loops
+// during their period after they became due. It is called by
+// scheduler.
+func runOverdueMaintenance() {
+	for _, j := range maintenanceJobs {
+		j.run(time.Now(), j.period, true)
+	}
+}
+
+// MaintenanceStats contains statistics of one maintenance job.
+type MaintenanceStats struct {
+	Name string
+	// Number of runs including runs by scheduler
+	Runs uint64
+	// Number of runs by scheduler because polling loops had no idle
+	// rounds
+	Backstop uint64
+	// Maximum duration of job in nanoseconds
+	MaxDuration int64
+}
+
+// GetMaintenanceStats returns statistics of jobs added by
+// AddMaintenanceJob.
+func GetMaintenanceStats() []MaintenanceStats {
+	ret := make([]MaintenanceStats, len(maintenanceJobs))
+	for i, j := range maintenanceJobs {
+		ret[i] = MaintenanceStats{
+			Name:        j.name,
+			Runs:        atomic.LoadUint64(&j.runs),
+			Backstop:    atomic.LoadUint64(&j.backstop),
+			MaxDuration: atomic.LoadInt64(&j.maxDuration),
+		}
+	}
+	return ret
+}
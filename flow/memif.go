@@ -0,0 +1,87 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// MemifConfig describes shared memory packet interface (memif) which
+// connects application with VPP or other memif speaking process.
+type MemifConfig struct {
+	// Control socket, default socket is /run/memif.sock
+	Socket string
+	// Interface identifier, it should be the same in both processes
+	ID uint32
+	// Interface is master if it is true and slave otherwise. VPP
+	// interfaces are usually masters.
+	Master bool
+	// Optional secret which is checked by master
+	Secret string
+}
+
+func (c *MemifConfig) key() string {
+	return fmt.Sprintf("%s:%d", c.Socket, c.ID)
+}
+
+// Ports of memif interfaces created by SetReceiverMemif and
+// SetSenderMemif
+var memifPorts = make(map[string]uint16)
+
+// Number of created memif devices, it is used for unique device names
+var memifDevices int
+
+// memifPort returns port of memif interface creating it at the first
+// call.
+func memifPort(config *MemifConfig) (uint16, error) {
+	if portId, ok := memifPorts[config.key()]; ok {
+		return portId, nil
+	}
+	role := "slave"
+	if config.Master {
+		role = "master"
+	}
+	args := fmt.Sprintf("role=%s,id=%d", role, config.ID)
+	if config.Socket != "" {
+		args += ",socket=" + config.Socket
+	}
+	if config.Secret != "" {
+		args += ",secret=" + config.Secret
+	}
+	portId, err := addVdev(fmt.Sprintf("net_memif%d", memifDevices), args)
+	if err != nil {
+		return 0, common.WrapWithNFError(err, "Can't create memif interface, NFF-GO should be built with memif support", common.BadArgument)
+	}
+	memifDevices++
+	memifPorts[config.key()] = portId
+	common.LogDebug(common.Initialization, "memif interface", config.key(), "is port", portId)
+	return portId, nil
+}
+
+// SetReceiverMemif adds receive function from memif interface to flow
+// graph. Receiving and sending with the same interface use one port,
+// so application can be a function of service chain between VPP
+// instances. Should be called after SystemInit and before
+// SystemInitPortsAndMemory. Returns new opened flow with packets sent
+// by peer.
+func SetReceiverMemif(config MemifConfig) (*Flow, error) {
+	portId, err := memifPort(&config)
+	if err != nil {
+		return nil, err
+	}
+	return SetReceiver(portId)
+}
+
+// SetSenderMemif adds send function to memif interface to flow graph.
+// Packets are dropped while peer is not connected.
+func SetSenderMemif(IN *Flow, config MemifConfig) error {
+	portId, err := memifPort(&config)
+	if err != nil {
+		return err
+	}
+	return SetSender(IN, portId)
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+)
+
+// MinMTU is the smallest MTU of port, it is a minimal MTU of IPv4.
+const MinMTU = 68
+
+// SetPortMTU sets MTU of port, maximum length of received frames is
+// set to fit MTU with Ethernet header, two VLAN tags and CRC. Memory
+// pools which are created after this call have mbufs large enough for
+// such frames, so frames aren't chained or truncated. It should be
+// called after SystemInit and before any flow functions are created
+// because they create their memory pools. MTU is applied by
+// SystemStart.
+func SetPortMTU(portId uint16, mtu uint16) error {
+	if portId >= uint16(len(createdPorts)) {
+		return common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	p := &createdPorts[portId]
+	if p.wasRequested {
+		return common.WrapWithNFError(nil, fmt.Sprintf("MTU of port %d should be set before SetReceiver and SetSender", portId), common.BadArgument)
+	}
+	maxFrameLen := low.CheckPortMaxRxPktLen(portId)
+	if mtu < MinMTU || low.MaxFrameLen(mtu) > maxFrameLen {
+		return common.WrapWithNFError(nil, fmt.Sprintf("MTU of port %d should be between %d and %d", portId,
+			MinMTU, int(maxFrameLen)-low.FrameOverhead), common.BadArgument)
+	}
+	if err := low.SetMbufBufSize(low.MaxFrameLen(mtu)); err != nil {
+		return err
+	}
+	p.mtu = mtu
+	return nil
+}
+
+// GetPortMTU returns MTU of port. It returns MTU set by SetPortMTU
+// until port is started.
+func GetPortMTU(portId uint16) (uint16, error) {
+	if portId >= uint16(len(createdPorts)) {
+		return 0, common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	if !createdPorts[portId].started && createdPorts[portId].mtu != 0 {
+		return createdPorts[portId].mtu, nil
+	}
+	return low.GetPortMTU(portId)
+}
+
+// SetPortTSO enables TCP segmentation offload of port. Packets which
+// are sent to port can be prepared for segmentation by
+// SetTXTCPSegOLFlags of packet. Returns error if port doesn't support
+// TCP segmentation offload, SetGSO should be used in this case. It
+// should be called before SystemStart.
+func SetPortTSO(portId uint16) error {
+	if portId >= uint16(len(createdPorts)) {
+		return common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	if !low.CheckPortTSO(portId) {
+		return common.WrapWithNFError(nil, fmt.Sprintf("Port %d doesn't support TCP segmentation offload", portId), common.BadArgument)
+	}
+	createdPorts[portId].tso = true
+	return nil
+}
@@ -53,28 +53,21 @@ func handleARPICMPRequests(current *packet.Packet, context UserContext) bool {
 				return true
 			}
 
-			// Return a packet back to sender
-			answerPacket, err := packet.NewPacket()
+			// Return echo data back to sender
+			current.ParseL7(types.ICMPNumber)
+			data, ok := current.GetL7Bytes()
+			if !ok {
+				return true
+			}
+			answerPacket, err := packet.NewBuilder().
+				Ether(current.Ether.DAddr, current.Ether.SAddr).
+				IPv4(ipv4.DstAddr, ipv4.SrcAddr).
+				ICMP(types.ICMPTypeEchoResponse, 0, packet.SwapBytesUint16(icmp.Identifier), packet.SwapBytesUint16(icmp.SeqNum)).
+				Payload(data).
+				Build()
 			if err != nil {
 				common.LogFatal(common.Debug, err)
 			}
-			// TODO need to initilize new packet instead of copying
-			packet.GeneratePacketFromByte(answerPacket, current.GetRawPacketBytes())
-			answerPacket.Ether.DAddr = current.Ether.SAddr
-			answerPacket.Ether.SAddr = current.Ether.DAddr
-			answerPacket.ParseL3()
-			(answerPacket.GetIPv4NoCheck()).DstAddr = ipv4.SrcAddr
-			(answerPacket.GetIPv4NoCheck()).SrcAddr = ipv4.DstAddr
-			answerPacket.ParseL4ForIPv4()
-			(answerPacket.GetICMPNoCheck()).Type = types.ICMPTypeEchoResponse
-			ipv4.HdrChecksum = packet.SwapBytesUint16(packet.CalculateIPv4Checksum(ipv4))
-			answerPacket.ParseL7(types.ICMPNumber)
-			(answerPacket.GetICMPNoCheck()).Cksum = packet.SwapBytesUint16(
-				packet.CalculateIPv4ICMPChecksum(
-					answerPacket.GetIPv4NoCheck(),
-					answerPacket.GetICMPNoCheck(),
-					answerPacket.Data))
-
 			answerPacket.SendPacket(port.port)
 
 			return false
@@ -0,0 +1,110 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+)
+
+type priorityMergeParameters struct {
+	high low.Rings
+	// Rings of all best effort flows
+	bestEffort low.Rings
+	out        low.Rings
+	// Maximum number of consecutive bursts taken from high priority
+	// flow while best effort flows wait, zero means no limit
+	starvationLimit uint
+	stats           common.RXTXStats
+}
+
+func addPriorityMerger(high low.Rings, bestEffort low.Rings, out low.Rings, starvationLimit uint) {
+	par := new(priorityMergeParameters)
+	par.high = high
+	par.bestEffort = bestEffort
+	par.out = out
+	par.starvationLimit = starvationLimit
+	schedState.addFF("priority merger", priorityMerge, nil, nil, par, nil, readWrite, 1, &par.stats)
+}
+
+// SetPriorityMerger merges flows so that packets of high priority
+// flow are always sent before packets of best effort flows, for
+// example control traffic received from KNI before data traffic. Best
+// effort flows are served in round robin order only when high priority
+// flow is empty. If starvationLimit is not zero, one burst of best
+// effort packets is taken after every starvationLimit consecutive
+// bursts of high priority packets, so best effort flows are not
+// starved completely. This function isn't cloned. Returns new opened
+// flow with merged packets.
+func SetPriorityMerger(starvationLimit uint, high *Flow, bestEffort ...*Flow) (OUT *Flow, err error) {
+	if err := checkFlow(high); err != nil {
+		return nil, err
+	}
+	if len(bestEffort) == 0 {
+		return nil, common.WrapWithNFError(nil, "Priority merger requires at least one best effort flow", common.BadArgument)
+	}
+	for _, f := range bestEffort {
+		if err := checkFlow(f); err != nil {
+			return nil, err
+		}
+	}
+	highRings := finishFlow(high)
+	var bestEffortRings low.Rings
+	for _, f := range bestEffort {
+		bestEffortRings = append(bestEffortRings, finishFlow(f)...)
+	}
+	rings := low.CreateRings(burstSize*sizeMultiplier, 1)
+	addPriorityMerger(highRings, bestEffortRings, rings, starvationLimit)
+	return newFlow(rings, 1), nil
+}
+
+// dequeueAny takes one burst from first not empty ring starting from
+// ring with index next. Returns number of packets and index of ring
+// to start from next time.
+func dequeueAny(rings low.Rings, next int, buf []uintptr) (uint, int) {
+	for i := 0; i < len(rings); i++ {
+		q := (next + i) % len(rings)
+		if n := rings[q].DequeueBurst(buf, burstSize); n != 0 {
+			return n, (q + 1) % len(rings)
+		}
+	}
+	return 0, next
+}
+
+func priorityMerge(parameters interface{}, inIndex []int32, stopper [2]chan int) {
+	pp := parameters.(*priorityMergeParameters)
+	buf := make([]uintptr, burstSize)
+	var highNext, bestEffortNext int
+	var consecutive uint
+	for {
+		select {
+		case <-stopper[0]:
+			// It is time to close this clone
+			stopper[1] <- 1
+			return
+		default:
+			n, next := dequeueAny(pp.high, highNext, buf)
+			if n != 0 {
+				highNext = next
+				if countersEnabledInApplication {
+					updatePortStats(&pp.stats, buf, n)
+				}
+				safeEnqueue(pp.out[0], buf, n)
+				consecutive++
+				if pp.starvationLimit == 0 || consecutive < pp.starvationLimit {
+					continue
+				}
+			}
+			consecutive = 0
+			n, bestEffortNext = dequeueAny(pp.bestEffort, bestEffortNext, buf)
+			if n != 0 {
+				if countersEnabledInApplication {
+					updatePortStats(&pp.stats, buf, n)
+				}
+				safeEnqueue(pp.out[0], buf, n)
+			}
+		}
+	}
+}
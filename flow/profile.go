@@ -0,0 +1,163 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// SizeWeight is a size of generated Ethernet frames without FCS and
+// its weight in generated traffic.
+type SizeWeight struct {
+	Size   uint
+	Weight uint
+}
+
+// IMIX is a simple IMIX traffic mix: 7 parts of frames with 40 bytes
+// IPv4 packets, 4 parts of 576 bytes and 1 part of 1500 bytes.
+var IMIX = []SizeWeight{{60, 7}, {590, 4}, {1514, 1}}
+
+// AddressRange is an inclusive range of IPv4 addresses.
+type AddressRange struct {
+	Min, Max types.IPv4Address
+}
+
+// PortRange is an inclusive range of ports in host byte order.
+type PortRange struct {
+	Min, Max uint16
+}
+
+// GeneratorProfile describes traffic generated by SetGeneratorProfile.
+// Every generated packet gets size from Sizes and addresses and ports
+// from ranges chosen randomly.
+type GeneratorProfile struct {
+	// Frame sizes with their weights. If empty, all frames are 60
+	// bytes long.
+	Sizes            []SizeWeight
+	SrcMAC, DstMAC   types.MACAddress
+	SrcIP, DstIP     AddressRange
+	SrcPort, DstPort PortRange
+	// types.TCPNumber or types.UDPNumber. UDP is used if zero.
+	Proto uint8
+	// Number of generated packets per second
+	Rate uint64
+}
+
+type profileContext struct {
+	profile *GeneratorProfile
+	// Cumulative weights of sizes
+	weights []uint
+	rnd     *rand.Rand
+}
+
+func (pc profileContext) Copy() interface{} {
+	pc.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return pc
+}
+
+func (pc profileContext) Delete() {
+}
+
+// SetGeneratorProfile adds clonable generate function which creates
+// IPv4 TCP or UDP packets according to profile. Returns new open flow
+// with generated packets and channel which can be used for
+// dynamically changing target speed like SetFastGenerator.
+func SetGeneratorProfile(profile GeneratorProfile) (OUT *Flow, tc chan uint64, err error) {
+	if profile.Proto == 0 {
+		profile.Proto = types.UDPNumber
+	}
+	headers := types.EtherLen + types.IPv4MinLen + types.UDPLen
+	switch profile.Proto {
+	case types.UDPNumber:
+	case types.TCPNumber:
+		headers = types.EtherLen + types.IPv4MinLen + types.TCPMinLen
+	default:
+		return nil, nil, common.WrapWithNFError(nil, "Generator profile protocol should be TCP or UDP", common.BadArgument)
+	}
+	if len(profile.Sizes) == 0 {
+		profile.Sizes = []SizeWeight{{60, 1}}
+	}
+	pc := profileContext{profile: &profile}
+	var total uint
+	for _, s := range profile.Sizes {
+		if s.Size < uint(headers) {
+			return nil, nil, common.WrapWithNFError(nil, "Generator profile frame size is less than headers size", common.BadArgument)
+		}
+		total += s.Weight
+		pc.weights = append(pc.weights, total)
+	}
+	if total == 0 {
+		return nil, nil, common.WrapWithNFError(nil, "Generator profile sizes have zero weights", common.BadArgument)
+	}
+	if packet.SwapBytesIPv4Addr(profile.SrcIP.Min) > packet.SwapBytesIPv4Addr(profile.SrcIP.Max) ||
+		packet.SwapBytesIPv4Addr(profile.DstIP.Min) > packet.SwapBytesIPv4Addr(profile.DstIP.Max) ||
+		profile.SrcPort.Min > profile.SrcPort.Max || profile.DstPort.Min > profile.DstPort.Max {
+		return nil, nil, common.WrapWithNFError(nil, "Generator profile range minimum exceeds maximum", common.BadArgument)
+	}
+	return SetFastGenerator(generateProfile, profile.Rate, pc)
+}
+
+func generateProfile(pkt *packet.Packet, context UserContext) {
+	pc := context.(profileContext)
+	p := pc.profile
+	r := uint(pc.rnd.Intn(int(pc.weights[len(pc.weights)-1])))
+	size := p.Sizes[0].Size
+	for i, w := range pc.weights {
+		if r < w {
+			size = p.Sizes[i].Size
+			break
+		}
+	}
+	if p.Proto == types.TCPNumber {
+		if !packet.InitEmptyIPv4TCPPacket(pkt, size-types.EtherLen-types.IPv4MinLen-types.TCPMinLen) {
+			return
+		}
+	} else if !packet.InitEmptyIPv4UDPPacket(pkt, size-types.EtherLen-types.IPv4MinLen-types.UDPLen) {
+		return
+	}
+	pkt.Ether.SAddr = p.SrcMAC
+	pkt.Ether.DAddr = p.DstMAC
+	ipv4 := pkt.GetIPv4NoCheck()
+	ipv4.SrcAddr = randomAddress(pc.rnd, p.SrcIP)
+	ipv4.DstAddr = randomAddress(pc.rnd, p.DstIP)
+	srcPort := packet.SwapBytesUint16(randomPort(pc.rnd, p.SrcPort))
+	dstPort := packet.SwapBytesUint16(randomPort(pc.rnd, p.DstPort))
+	if p.Proto == types.TCPNumber {
+		tcp := pkt.GetTCPNoCheck()
+		tcp.SrcPort = srcPort
+		tcp.DstPort = dstPort
+	} else {
+		udp := pkt.GetUDPNoCheck()
+		udp.SrcPort = srcPort
+		udp.DstPort = dstPort
+	}
+	if hwtxchecksum {
+		packet.SetHWOffloadingHdrChecksum(pkt)
+	} else {
+		ipv4.HdrChecksum = packet.SwapBytesUint16(packet.CalculateIPv4Checksum(ipv4))
+		if p.Proto == types.TCPNumber {
+			tcp := pkt.GetTCPNoCheck()
+			tcp.Cksum = packet.SwapBytesUint16(packet.CalculateIPv4TCPChecksum(ipv4, tcp, pkt.Data))
+		} else {
+			udp := pkt.GetUDPNoCheck()
+			udp.DgramCksum = packet.SwapBytesUint16(packet.CalculateIPv4UDPChecksum(ipv4, udp, pkt.Data))
+		}
+	}
+}
+
+func randomAddress(rnd *rand.Rand, r AddressRange) types.IPv4Address {
+	min := uint64(packet.SwapBytesIPv4Addr(r.Min))
+	max := uint64(packet.SwapBytesIPv4Addr(r.Max))
+	return packet.SwapBytesIPv4Addr(types.IPv4Address(min + uint64(rnd.Int63n(int64(max-min+1)))))
+}
+
+func randomPort(rnd *rand.Rand, r PortRange) uint16 {
+	return r.Min + uint16(rnd.Intn(int(r.Max-r.Min)+1))
+}
@@ -0,0 +1,274 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// fragmentKey identifies packet which fragments belong to. IPv4
+// addresses occupy first bytes of address arrays.
+type fragmentKey struct {
+	src, dst [types.IPv6AddrLen]byte
+	id       uint32
+	proto    uint8
+	ipv6     bool
+}
+
+// Maximum number of fragments of one packet. Packets which are split
+// into more fragments are dropped, so that tiny fragments can't hold
+// many mbufs in one table entry.
+const reassemblyMaxFragments = 64
+
+type fragmentPiece struct {
+	offset, end uint
+	pkt         uintptr
+}
+
+// reassemblyEntry keeps received fragments of one packet.
+type reassemblyEntry struct {
+	pieces []fragmentPiece
+	// Length of packet data, it is known when last fragment is received
+	total uint
+	// Number of received data bytes
+	received uint
+	created  time.Time
+}
+
+type reassembleParameters struct {
+	in      low.Rings
+	out     low.Rings
+	size    int
+	timeout time.Duration
+	table   map[fragmentKey]*reassemblyEntry
+	stats   common.RXTXStats
+}
+
+func addReassembler(in low.Rings, out low.Rings, size int, timeout time.Duration, inIndexNumber int32) {
+	par := new(reassembleParameters)
+	par.in = in
+	par.out = out
+	par.size = size
+	par.timeout = timeout
+	par.table = make(map[fragmentKey]*reassemblyEntry, size)
+	schedState.addFF("reassembler", reassemble, nil, nil, par, nil, readWrite, inIndexNumber, &par.stats)
+}
+
+// SetReassembler adds function which reassembles IPv4 and IPv6
+// fragments into whole packets, so following handlers can see L4
+// headers of all packets. size is a maximum number of packets which
+// can be reassembled simultaneously. Fragments of packets which were
+// not reassembled during timeout are dropped as well as overlapping
+// fragments and fragments which don't fit into table. Packet can have
+// at most 64 fragments, all fragments of packet are dropped when more
+// of them arrive. Reassembled packet should fit into one mbuf, so
+// MemoryJumbo mode is required for packets longer than standard MTU.
+// Only IPv6 fragment header which directly follows IPv6 header is
+// supported. Other packets are passed unchanged. This function isn't
+// cloned.
+func SetReassembler(IN *Flow, size int, timeout time.Duration) error {
+	if err := checkFlow(IN); err != nil {
+		return err
+	}
+	if size <= 0 || timeout <= 0 {
+		return common.WrapWithNFError(nil, "Reassembly table size and timeout should be positive", common.BadArgument)
+	}
+	out := low.CreateRings(burstSize*sizeMultiplier, 1)
+	if IN.segment == nil {
+		addReassembler(IN.current, out, size, timeout, IN.inIndexNumber)
+	} else {
+		tRing := low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
+		ms := makeSlice(tRing, IN.segment)
+		segmentInsert(IN, ms, false, nil, 0, 0)
+		addReassembler(tRing, out, size, timeout, IN.inIndexNumber)
+		IN.segment = nil
+	}
+	IN.current = out
+	IN.inIndexNumber = 1
+	return nil
+}
+
+// fragmentOf returns key and position of fragment data. Returns false
+// if packet is not a fragment.
+func fragmentOf(pkt *packet.Packet) (key fragmentKey, offset, length uint, more bool, ok bool) {
+	pkt.ParseL3()
+	if ipv4 := pkt.GetIPv4(); ipv4 != nil {
+		flags := packet.SwapBytesUint16(ipv4.FragmentOffset)
+		offset = uint(flags&types.IPv4FragmentOffsetMask) << 3
+		more = flags&types.IPv4MoreFragments != 0
+		if offset == 0 && !more {
+			return
+		}
+		src := types.IPv4ToBytes(ipv4.SrcAddr)
+		dst := types.IPv4ToBytes(ipv4.DstAddr)
+		copy(key.src[:], src[:])
+		copy(key.dst[:], dst[:])
+		key.id = uint32(ipv4.PacketID)
+		key.proto = ipv4.NextProtoID
+		ihl := uint(ipv4.VersionIhl&0x0f) << 2
+		total := uint(packet.SwapBytesUint16(ipv4.TotalLength))
+		if total < ihl {
+			return
+		}
+		length = total - ihl
+	} else if fh := pkt.GetIPv6Fragment(); fh != nil {
+		ipv6 := pkt.GetIPv6NoCheck()
+		flags := packet.SwapBytesUint16(fh.FragmentOffset)
+		offset = uint(flags &^ 7)
+		more = flags&packet.IPv6MoreFragments != 0
+		key.src = ipv6.SrcAddr
+		key.dst = ipv6.DstAddr
+		key.id = fh.Identification
+		key.ipv6 = true
+		payload := uint(packet.SwapBytesUint16(ipv6.PayloadLen))
+		if payload < types.IPv6FragmentLen {
+			return
+		}
+		length = payload - types.IPv6FragmentLen
+	} else {
+		return
+	}
+	if more && length%8 != 0 {
+		// Only last fragment can have length which is not multiple of 8
+		return
+	}
+	ok = true
+	return
+}
+
+// add inserts fragment into entry. Returns false if fragment
+// overlaps with already received ones or if entry already has
+// maximum number of fragments.
+func (e *reassemblyEntry) add(offset, length uint, more bool, pkt uintptr) bool {
+	if len(e.pieces) >= reassemblyMaxFragments {
+		return false
+	}
+	end := offset + length
+	if !more {
+		if e.total != 0 && e.total != end ||
+			len(e.pieces) != 0 && e.pieces[len(e.pieces)-1].end > end {
+			return false
+		}
+		e.total = end
+	}
+	i := len(e.pieces)
+	for i > 0 && e.pieces[i-1].offset > offset {
+		i--
+	}
+	if i > 0 && e.pieces[i-1].end > offset || i < len(e.pieces) && e.pieces[i].offset < end ||
+		e.total != 0 && end > e.total {
+		return false
+	}
+	e.pieces = append(e.pieces, fragmentPiece{})
+	copy(e.pieces[i+1:], e.pieces[i:])
+	e.pieces[i] = fragmentPiece{offset, end, pkt}
+	e.received += length
+	return true
+}
+
+func (e *reassemblyEntry) complete() bool {
+	return e.total != 0 && e.received == e.total
+}
+
+func (e *reassemblyEntry) packets(buf []uintptr) []uintptr {
+	for _, p := range e.pieces {
+		buf = append(buf, p.pkt)
+	}
+	return buf
+}
+
+func reassemble(parameters interface{}, inIndex []int32, stopper [2]chan int) {
+	rp := parameters.(*reassembleParameters)
+	buf := make([]uintptr, burstSize)
+	out := make([]uintptr, 0, burstSize)
+	drop := make([]uintptr, 0, burstSize)
+	var fragments []*packet.Packet
+	lastExpire := time.Now()
+	for {
+		select {
+		case <-stopper[0]:
+			// It is time to close this clone
+			stopper[1] <- 1
+			return
+		default:
+			for q := int32(0); q < inIndex[0]; q++ {
+				n := rp.in[q].DequeueBurst(buf, burstSize)
+				if n == 0 {
+					continue
+				}
+				if countersEnabledInApplication {
+					updatePortStats(&rp.stats, buf, n)
+				}
+				now := time.Now()
+				for i := uint(0); i < n; i++ {
+					pkt := packet.ExtractPacket(buf[i])
+					key, offset, length, more, ok := fragmentOf(pkt)
+					if !ok {
+						out = append(out, buf[i])
+						continue
+					}
+					e := rp.table[key]
+					if e == nil {
+						if len(rp.table) >= rp.size {
+							drop = append(drop, buf[i])
+							continue
+						}
+						e = &reassemblyEntry{created: now}
+						rp.table[key] = e
+					}
+					if !e.add(offset, length, more, buf[i]) {
+						drop = e.packets(append(drop, buf[i]))
+						delete(rp.table, key)
+						continue
+					}
+					if !e.complete() {
+						continue
+					}
+					delete(rp.table, key)
+					fragments = fragments[:0]
+					for _, p := range e.pieces {
+						f := packet.ExtractPacket(p.pkt)
+						f.ParseL3()
+						fragments = append(fragments, f)
+					}
+					if packet.ReassembleFragments(fragments) {
+						out = append(out, e.pieces[0].pkt)
+						for _, p := range e.pieces[1:] {
+							drop = append(drop, p.pkt)
+						}
+					} else {
+						drop = e.packets(drop)
+					}
+				}
+				if len(out) != 0 {
+					safeEnqueue(rp.out[0], out, uint(len(out)))
+					out = out[:0]
+				}
+				if len(drop) != 0 {
+					low.DirectStop(len(drop), drop)
+					drop = drop[:0]
+				}
+			}
+			if now := time.Now(); now.Sub(lastExpire) > rp.timeout/4 {
+				lastExpire = now
+				for key, e := range rp.table {
+					if now.Sub(e.created) > rp.timeout {
+						drop = e.packets(drop)
+						delete(rp.table, key)
+					}
+				}
+				if len(drop) != 0 {
+					low.DirectStop(len(drop), drop)
+					drop = drop[:0]
+				}
+			}
+		}
+	}
+}
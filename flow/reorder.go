@@ -0,0 +1,337 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// Sequence tag is kept in mbuf sequence number field. High bits
+// contain index of flow group, low bits contain sequence number of
+// packet inside this group.
+const (
+	seqBits       = 20
+	seqMask       = 1<<seqBits - 1
+	maxFlowGroups = 1 << (32 - seqBits)
+)
+
+// FlowKeyFunction returns key of flow which packet belongs to. Order
+// of packets is restored between packets with the same key.
+type FlowKeyFunction func(*packet.Packet) uint32
+
+// Sequencer describes sequence tags which are assigned to packets by
+// SetSequencer and used by SetReorderer to restore order of packets
+// after parallel processing. Flows are distributed among flow groups
+// by their keys, order is restored inside every group, so packets of
+// different groups don't wait for each other. Several flows can be
+// tagged by one sequencer, tags are assigned from one sequence of
+// every group in order in which packets reach sequencers.
+type Sequencer struct {
+	groups uint32
+	key    FlowKeyFunction
+	// Next sequence number of every flow group, accessed atomically
+	counters []uint32
+	// Maximum number of packets which can wait for a missing one in
+	// every flow group
+	window uint32
+	// Maximum time which packets can wait for a missing one. Packets
+	// which were dropped during processing are skipped after it.
+	timeout time.Duration
+}
+
+// NewSequencer creates sequencer with given number of flow groups,
+// reordering window and timeout. Window is rounded up to power of
+// two. If key is nil, flow key is computed from IPv4 addresses,
+// protocol and TCP or UDP ports.
+func NewSequencer(groups uint, key FlowKeyFunction, window uint, timeout time.Duration) (*Sequencer, error) {
+	if groups == 0 || groups > maxFlowGroups {
+		return nil, common.WrapWithNFError(nil, "Number of flow groups should be from 1 to 4096", common.BadArgument)
+	}
+	if window == 0 || window >= 1<<(seqBits-1) {
+		return nil, common.WrapWithNFError(nil, "Reordering window is out of range", common.BadArgument)
+	}
+	if key == nil {
+		key = ipv4FlowKey
+	}
+	// Window divides range of sequence numbers, so buffer index
+	// doesn't jump when sequence number wraps around.
+	w := uint32(1)
+	for w < uint32(window) {
+		w <<= 1
+	}
+	return &Sequencer{
+		groups:   uint32(groups),
+		key:      key,
+		counters: make([]uint32, groups),
+		window:   w,
+		timeout:  timeout,
+	}, nil
+}
+
+// tag assigns next sequence tag of flow group to packet.
+func (s *Sequencer) tag(pkt *packet.Packet) {
+	group := s.key(pkt) % s.groups
+	seq := (atomic.AddUint32(&s.counters[group], 1) - 1) & seqMask
+	low.SetSeqnMbuf(pkt.CMbuf, group<<seqBits|seq)
+}
+
+func ipv4FlowKey(pkt *packet.Packet) uint32 {
+	pkt.ParseL3()
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil {
+		return 0
+	}
+	key := uint32(ipv4.SrcAddr) ^ uint32(ipv4.DstAddr)*31 ^ uint32(ipv4.NextProtoID)
+	pkt.ParseL4ForIPv4()
+	switch ipv4.NextProtoID {
+	case types.TCPNumber:
+		tcp := pkt.GetTCPNoCheck()
+		key ^= uint32(tcp.SrcPort)<<16 | uint32(tcp.DstPort)
+	case types.UDPNumber:
+		udp := pkt.GetUDPNoCheck()
+		key ^= uint32(udp.SrcPort)<<16 | uint32(udp.DstPort)
+	}
+	// Mix bits so that modulo uses all of them
+	key ^= key >> 16
+	key *= 0x45d9f3b
+	key ^= key >> 16
+	return key
+}
+
+type sequencerParameters struct {
+	in    low.Rings
+	out   low.Rings
+	seq   *Sequencer
+	stats common.RXTXStats
+}
+
+func addSequencer(in low.Rings, out low.Rings, s *Sequencer, inIndexNumber int32) {
+	par := new(sequencerParameters)
+	par.in = in
+	par.out = out
+	par.seq = s
+	schedState.addFF("sequencer", sequence, nil, nil, par, nil, readWrite, inIndexNumber, &par.stats)
+}
+
+// reorderGroup is a reordering state of one flow group.
+type reorderGroup struct {
+	// Sequence number of next packet which should be sent
+	next uint32
+	// Waiting packets by sequence number modulo window
+	buffer  []uintptr
+	waiting uint32
+	// Time when packets started to wait for next one
+	since time.Time
+}
+
+type reordererParameters struct {
+	in     low.Rings
+	out    low.Rings
+	seq    *Sequencer
+	groups []reorderGroup
+	stats  common.RXTXStats
+}
+
+func addReorderer(in low.Rings, out low.Rings, s *Sequencer, inIndexNumber int32) {
+	par := new(reordererParameters)
+	par.in = in
+	par.out = out
+	par.seq = s
+	par.groups = make([]reorderGroup, s.groups)
+	for i := range par.groups {
+		par.groups[i].buffer = make([]uintptr, s.window)
+	}
+	schedState.addFF("reorderer", reorder, nil, nil, par, nil, readWrite, inIndexNumber, &par.stats)
+}
+
+// SetSequencer adds function which assigns sequence tags to packets
+// according to sequencer. This function isn't cloned, so it should
+// be placed before parallel processing, for example right after
+// receiver. Packets which bypass parallel processing, for example
+// packets injected by KNI, can be tagged by the same sequencer, so
+// that SetReorderer or SetOrderedMerger doesn't let them overtake
+// packets of the same flow which were tagged before them. Returns new
+// opened flow with tagged packets.
+func SetSequencer(IN *Flow, s *Sequencer) (OUT *Flow, err error) {
+	if err := checkFlow(IN); err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, common.WrapWithNFError(nil, "Sequencer is nil", common.BadArgument)
+	}
+	rings := low.CreateRings(burstSize*sizeMultiplier, 1)
+	addSequencer(finishFlow(IN), rings, s, IN.inIndexNumber)
+	return newFlow(rings, 1), nil
+}
+
+// SetReorderer adds function which restores order of packets tagged
+// by SetSequencer with the same sequencer. Packets of every flow
+// group are sent in order of their tags. Packets which were dropped
+// during processing are waited for during timeout of sequencer or
+// until window is full. Packets which arrive after their turn was
+// skipped are sent immediately. This function isn't cloned. Returns
+// new opened flow with ordered packets.
+func SetReorderer(IN *Flow, s *Sequencer) (OUT *Flow, err error) {
+	if err := checkFlow(IN); err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, common.WrapWithNFError(nil, "Sequencer is nil", common.BadArgument)
+	}
+	rings := low.CreateRings(burstSize*sizeMultiplier, 1)
+	addReorderer(finishFlow(IN), rings, s, IN.inIndexNumber)
+	return newFlow(rings, 1), nil
+}
+
+// SetOrderedMerger merges flows like SetMerger and restores order of
+// packets like SetReorderer. All packets of input flows should be
+// tagged by SetSequencer with sequencer s, usually one flow is a fast
+// path and others are slow paths, for example through KNI. Packets of
+// one flow are sent in order in which they were tagged, so packets
+// which took slow path don't overtake packets of the same flow which
+// were tagged before them and don't fall behind packets tagged after
+// them. This function isn't cloned. Returns new opened flow with
+// ordered packets.
+func SetOrderedMerger(s *Sequencer, InArray ...*Flow) (OUT *Flow, err error) {
+	merged, err := SetMerger(InArray...)
+	if err != nil {
+		return nil, err
+	}
+	return SetReorderer(merged, s)
+}
+
+func sequence(parameters interface{}, inIndex []int32, stopper [2]chan int) {
+	sp := parameters.(*sequencerParameters)
+	s := sp.seq
+	buf := make([]uintptr, burstSize)
+	for {
+		select {
+		case <-stopper[0]:
+			// It is time to close this clone
+			stopper[1] <- 1
+			return
+		default:
+			for q := int32(0); q < inIndex[0]; q++ {
+				n := sp.in[q].DequeueBurst(buf, burstSize)
+				if n == 0 {
+					continue
+				}
+				if countersEnabledInApplication {
+					updatePortStats(&sp.stats, buf, n)
+				}
+				for i := uint(0); i < n; i++ {
+					s.tag(packet.ExtractPacket(buf[i]))
+				}
+				safeEnqueue(sp.out[0], buf, n)
+			}
+		}
+	}
+}
+
+func reorder(parameters interface{}, inIndex []int32, stopper [2]chan int) {
+	rp := parameters.(*reordererParameters)
+	s := rp.seq
+	buf := make([]uintptr, burstSize)
+	out := make([]uintptr, 0, burstSize)
+	flush := func() {
+		if len(out) != 0 {
+			safeEnqueue(rp.out[0], out, uint(len(out)))
+			out = out[:0]
+		}
+	}
+	send := func(p uintptr) {
+		out = append(out, p)
+		if len(out) == burstSize {
+			flush()
+		}
+	}
+	// release sends waiting packets of group which have their turn.
+	release := func(g *reorderGroup) {
+		for g.waiting != 0 {
+			i := g.next % s.window
+			if g.buffer[i] == 0 {
+				break
+			}
+			send(g.buffer[i])
+			g.buffer[i] = 0
+			g.waiting--
+			g.next = (g.next + 1) & seqMask
+		}
+	}
+	// skip sends waiting packets with sequence numbers less than
+	// given one, forgetting about missing ones.
+	skip := func(g *reorderGroup, until uint32) {
+		for g.next != until && g.waiting != 0 {
+			i := g.next % s.window
+			if g.buffer[i] != 0 {
+				send(g.buffer[i])
+				g.buffer[i] = 0
+				g.waiting--
+			}
+			g.next = (g.next + 1) & seqMask
+		}
+		g.next = until
+		release(g)
+	}
+	for {
+		select {
+		case <-stopper[0]:
+			// It is time to close this clone
+			stopper[1] <- 1
+			return
+		default:
+			now := time.Now()
+			for q := int32(0); q < inIndex[0]; q++ {
+				n := rp.in[q].DequeueBurst(buf, burstSize)
+				if n == 0 {
+					continue
+				}
+				if countersEnabledInApplication {
+					updatePortStats(&rp.stats, buf, n)
+				}
+				for i := uint(0); i < n; i++ {
+					tag := low.GetSeqnMbuf(packet.ExtractPacket(buf[i]).CMbuf)
+					g := &rp.groups[(tag>>seqBits)%s.groups]
+					seq := tag & seqMask
+					d := (seq - g.next) & seqMask
+					if d >= 1<<(seqBits-1) {
+						// Turn of this packet was skipped
+						send(buf[i])
+						continue
+					}
+					if d >= s.window {
+						// Window is full, stop waiting for the
+						// oldest missing packets
+						skip(g, (seq-s.window+1)&seqMask)
+					}
+					if g.waiting == 0 {
+						g.since = now
+					}
+					g.buffer[seq%s.window] = buf[i]
+					g.waiting++
+					release(g)
+				}
+			}
+			for i := range rp.groups {
+				g := &rp.groups[i]
+				if g.waiting != 0 && now.Sub(g.since) > s.timeout {
+					// Skip missing packets up to the first waiting one
+					for g.buffer[g.next%s.window] == 0 {
+						g.next = (g.next + 1) & seqMask
+					}
+					release(g)
+					g.since = now
+				}
+			}
+			flush()
+		}
+	}
+}
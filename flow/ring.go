@@ -0,0 +1,97 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+)
+
+// Shared rings used by SetReceiverRing and SetSenderRing
+var sharedRings = make(map[string]*low.Ring)
+
+// sharedRing returns ring with given name which is shared between
+// processes. Ring is created by process which uses it first.
+func sharedRing(name string) (*low.Ring, error) {
+	if ring, ok := sharedRings[name]; ok {
+		return ring, nil
+	}
+	ring := low.LookupOrCreateRing(name, burstSize*sizeMultiplier, low.AnySocket)
+	if ring == nil {
+		return nil, common.WrapWithNFError(nil, "Can't create shared ring "+name, common.BadArgument)
+	}
+	sharedRings[name] = ring
+	common.LogDebug(common.Initialization, "shared ring", name, "is ready")
+	return ring, nil
+}
+
+type receiveRingParameters struct {
+	out    low.Rings
+	shared *low.Ring
+	stats  common.RXTXStats
+}
+
+func addRingReceiver(shared *low.Ring, out low.Rings) {
+	par := new(receiveRingParameters)
+	par.shared = shared
+	par.out = out
+	schedState.addFF("ring receiver", nil, recvRing, nil, par, nil, sendReceiveKNI, 0, &par.stats)
+}
+
+type sendRingParameters struct {
+	in     low.Rings
+	shared *low.Ring
+	stats  common.RXTXStats
+}
+
+func addRingSender(shared *low.Ring, in low.Rings, inIndexNumber int32) {
+	par := new(sendRingParameters)
+	par.shared = shared
+	par.in = in
+	schedState.addFF("ring sender", nil, sendRing, nil, par, nil, sendReceiveKNI, inIndexNumber, &par.stats)
+}
+
+func recvRing(parameters interface{}, inIndex []int32, flag *int32, coreID int) {
+	srp := parameters.(*receiveRingParameters)
+	low.ReceiveRing(srp.shared, srp.out[0], flag, coreID, &srp.stats)
+}
+
+func sendRing(parameters interface{}, inIndex []int32, flag *int32, coreID int) {
+	srp := parameters.(*sendRingParameters)
+	low.SendRing(srp.shared, srp.in, flag, coreID, &srp.stats)
+}
+
+// SetReceiverRing adds receive function from named DPDK ring to flow
+// graph. Ring port connects flow graphs of different processes, for
+// example NAT in primary process and firewall in secondary process:
+// NAT sends packets with SetSenderRing(flow, "nat2fw") and firewall
+// receives them with SetReceiverRing("nat2fw"). Processes should run as
+// DPDK primary and secondary processes, so they share mempools and
+// rings. Ring is created by the first process which uses it.
+func SetReceiverRing(name string) (*Flow, error) {
+	shared, err := sharedRing(name)
+	if err != nil {
+		return nil, err
+	}
+	rings := low.CreateRings(burstSize*sizeMultiplier, 1)
+	addRingReceiver(shared, rings)
+	return newFlow(rings, 1), nil
+}
+
+// SetSenderRing adds send function to named DPDK ring to flow graph.
+// Ring is shared with other process as described for SetReceiverRing.
+// Packets are dropped if ring is full, for example if other process
+// is stopped. Closes input flow.
+func SetSenderRing(IN *Flow, name string) error {
+	if err := checkFlow(IN); err != nil {
+		return err
+	}
+	shared, err := sharedRing(name)
+	if err != nil {
+		return err
+	}
+	addRingSender(shared, finishFlow(IN), IN.inIndexNumber)
+	return nil
+}
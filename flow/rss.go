@@ -0,0 +1,116 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+)
+
+// Hash fields of receive side scaling, they can be combined
+const (
+	RSSIP        = low.RSSIP
+	RSSTCP       = low.RSSTCP
+	RSSUDP       = low.RSSUDP
+	RSSSCTP      = low.RSSSCTP
+	RSSL2Payload = low.RSSL2Payload
+)
+
+// RSSConfig describes receive side scaling of port.
+type RSSConfig struct {
+	// Toeplitz hash key, its length should be supported by NIC,
+	// usually it is 40 or 52 bytes. Default key of NIC is used if it
+	// is empty.
+	Key []byte
+	// Packet fields which are hashed, combination of RSS* constants.
+	// All fields supported by NIC are hashed if it is zero.
+	HashFields uint64
+	// Number of receive queues, which is also a maximum number of
+	// receiver clones. Zero keeps default number of queues.
+	Queues int32
+	// Redirection table, packet with hash h is received by queue
+	// RETA[h % N] where N is a size of NIC table. If RETA is shorter
+	// than NIC table, it is repeated. Default table of NIC which
+	// distributes hashes evenly is used if it is empty.
+	RETA []uint16
+}
+
+// SymmetricRSSKey returns Toeplitz hash key of given length which
+// gives the same hash for packets with swapped source and destination
+// addresses and ports. With such key both directions of connection
+// are received by the same queue and are handled by the same receiver
+// clone, so connection state can be kept without locking.
+func SymmetricRSSKey(length int) []byte {
+	key := make([]byte, length)
+	for i := range key {
+		if i%2 == 0 {
+			key[i] = 0x6d
+		} else {
+			key[i] = 0x5a
+		}
+	}
+	return key
+}
+
+// SetPortRSS sets receive side scaling configuration of port. It
+// should be called after SystemInit and before SetReceiver of port
+// because number of queues defines number of receiver rings. Key,
+// hash fields and redirection table are applied by SystemStart.
+func SetPortRSS(portId uint16, config RSSConfig) error {
+	if portId >= uint16(len(createdPorts)) {
+		return common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	p := &createdPorts[portId]
+	if p.willReceive {
+		return common.WrapWithNFError(nil, fmt.Sprintf("RSS of port %d should be set before SetReceiver", portId), common.BadArgument)
+	}
+	queues := p.InIndex
+	if config.Queues != 0 {
+		// Number of queues is limited by NIC and by MaxInIndex of Config
+		maxQueues := low.CheckPortRSS(portId)
+		if limit := int32(len(schedState.StopRing)); limit < maxQueues {
+			maxQueues = limit
+		}
+		if config.Queues < 0 || config.Queues > maxQueues {
+			return common.WrapWithNFError(nil, fmt.Sprintf("Port %d can't have %d receive queues, maximum is %d",
+				portId, config.Queues, maxQueues), common.BadArgument)
+		}
+		queues = config.Queues
+	}
+	for _, q := range config.RETA {
+		if int32(q) >= queues {
+			return common.WrapWithNFError(nil, fmt.Sprintf("RSS redirection table of port %d refers to queue %d, port has %d queues",
+				portId, q, queues), common.BadArgument)
+		}
+	}
+	p.InIndex = queues
+	p.rss = low.RSSConf{
+		Key:        append([]byte(nil), config.Key...),
+		HashFields: config.HashFields,
+		RETA:       append([]uint16(nil), config.RETA...),
+	}
+	return nil
+}
+
+// GetPortRSS returns receive side scaling configuration of started
+// port as it is reported by NIC. Redirection table has full size of
+// NIC table.
+func GetPortRSS(portId uint16) (RSSConfig, error) {
+	if portId >= uint16(len(createdPorts)) {
+		return RSSConfig{}, common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	conf, err := low.GetPortRSS(portId)
+	if err != nil {
+		return RSSConfig{}, err
+	}
+	return RSSConfig{
+		Key:        conf.Key,
+		HashFields: conf.HashFields,
+		Queues:     createdPorts[portId].InIndex,
+		RETA:       conf.RETA,
+	}, nil
+}
@@ -22,6 +22,7 @@ package flow
 import (
 	"runtime"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -125,11 +126,21 @@ type flowFunction struct {
 	context       *[]UserContext
 	fType         ffType
 	inIndexNumber int32
+	// Send and receive statistics of this flow function if it has them
+	stats *common.RXTXStats
+	// NUMA socket whose cores are preferred for clones of this flow function
+	socket int
+	// Indexes of cores set by SetAffinity, empty if flow function isn't pinned
+	cores []int
+	// Limits of number of clones of every instance set by
+	// SetNodeClones, zero maxClones means no limit
+	minClones int
+	maxClones int
 }
 
 // Adding every flow function to scheduler list
 func (scheduler *scheduler) addFF(name string, ucfn uncloneFlowFunction, Cfn cFlowFunction, cfn cloneFlowFunction,
-	par interface{}, context *[]UserContext, fType ffType, inIndexNumber int32, rxtxstats *common.RXTXStats) {
+	par interface{}, context *[]UserContext, fType ffType, inIndexNumber int32, rxtxstats *common.RXTXStats) *flowFunction {
 	ff := new(flowFunction)
 	nameC := 1
 	tName := name + strconv.Itoa(nameC)
@@ -147,13 +158,20 @@ func (scheduler *scheduler) addFF(name string, ucfn uncloneFlowFunction, Cfn cFl
 	ff.context = context
 	ff.fType = fType
 	ff.inIndexNumber = inIndexNumber
+	ff.stats = rxtxstats
+	ff.socket = low.AnySocket
+	// Flow functions can be added by graph update while statistics
+	// functions read them
+	scheduler.tuningLock.Lock()
 	if inIndexNumber > scheduler.maxInIndex {
 		scheduler.maxInIndex = inIndexNumber
 	}
 	scheduler.ff = append(scheduler.ff, ff)
+	scheduler.tuningLock.Unlock()
 	if countersEnabledInFramework && rxtxstats != nil {
 		registerRXTXStatitics(rxtxstats, tName)
 	}
+	return ff
 }
 
 type scheduler struct {
@@ -172,16 +190,25 @@ type scheduler struct {
 	stopFlag           int32
 	maxRecv            int
 	Timers             []*Timer
+	periodic           []*timerParameters
 	nAttempts          []uint64
 	pAttempts          []uint64
 	maxInIndex         int32
 	measureRings       low.Rings
 	coreIndex          int
+	stopCoreIndex      int
+	// Ratio of idle time to schedTime above which clones are removed
+	scaleDownThreshold float64
+	// Protects scheduling decisions from changes by tuning functions
+	tuningLock sync.Mutex
 }
 
 type core struct {
 	id     int
+	socket int
 	isfree bool
+	// Core is pinned to flow function by SetAffinity
+	reserved bool
 }
 
 func newScheduler(cpus []int, schedulerOff bool, schedulerOffRemove bool, stopDedicatedCore bool,
@@ -191,7 +218,7 @@ func newScheduler(cpus []int, schedulerOff bool, schedulerOffRemove bool, stopDe
 	scheduler := new(scheduler)
 	scheduler.cores = make([]core, coresNumber, coresNumber)
 	for i, cpu := range cpus {
-		scheduler.cores[i] = core{id: cpu, isfree: true}
+		scheduler.cores[i] = core{id: cpu, socket: low.GetCoreSocket(cpu), isfree: true}
 	}
 	scheduler.off = schedulerOff
 	scheduler.offRemove = schedulerOff || schedulerOffRemove
@@ -200,6 +227,7 @@ func newScheduler(cpus []int, schedulerOff bool, schedulerOffRemove bool, stopDe
 	scheduler.checkTime = checkTime
 	scheduler.debugTime = debugTime
 	scheduler.maxPacketsToClone = maxPacketsToClone
+	scheduler.scaleDownThreshold = defaultScaleDownThreshold
 	scheduler.maxRecv = maxRecv
 	scheduler.unrestrictedClones = unrestrictedClones
 	scheduler.pAttempts = make([]uint64, len(scheduler.cores), len(scheduler.cores))
@@ -209,8 +237,11 @@ func newScheduler(cpus []int, schedulerOff bool, schedulerOffRemove bool, stopDe
 
 func (scheduler *scheduler) systemStart() (err error) {
 	scheduler.stopFlag = process
+	if err = scheduler.applyAffinity(); err != nil {
+		return err
+	}
 	var core int
-	if core, scheduler.coreIndex, err = scheduler.getCore(); err != nil {
+	if core, scheduler.coreIndex, err = scheduler.getCore(low.AnySocket); err != nil {
 		return err
 	}
 	common.LogDebug(common.Initialization, "Start SCHEDULER at", core, "core")
@@ -218,7 +249,7 @@ func (scheduler *scheduler) systemStart() (err error) {
 		common.LogFatal(common.Initialization, "Failed to set affinity to", core, "core: ", err)
 	}
 	if scheduler.stopDedicatedCore {
-		if core, _, err = scheduler.getCore(); err != nil {
+		if core, scheduler.stopCoreIndex, err = scheduler.getCore(low.AnySocket); err != nil {
 			return err
 		}
 		common.LogDebug(common.Initialization, "Start STOP at", core, "core")
@@ -238,12 +269,18 @@ func (scheduler *scheduler) systemStart() (err error) {
 			return err
 		}
 	}
+	scheduler.initMeasure()
+	return nil
+}
+
+// initMeasure measures performance of segments for every possible
+// number of connection groups.
+func (scheduler *scheduler) initMeasure() {
 	scheduler.measureRings = low.CreateRings(burstSize*sizeMultiplier, scheduler.maxInIndex+1)
 	scheduler.nAttempts = make([]uint64, scheduler.maxInIndex+1, scheduler.maxInIndex+1)
 	for i := int32(1); i < scheduler.maxInIndex+1; i++ {
 		scheduler.nAttempts[i] = scheduler.measure(int32(i), 1)
 	}
-	return nil
 }
 
 func (ff *flowFunction) startNewInstance(inIndex []int32, scheduler *scheduler) (err error) {
@@ -265,7 +302,11 @@ func (ffi *instance) startNewClone(scheduler *scheduler, n int) (err error) {
 	var core int
 	var index int
 	if ff.fType != comboKNI {
-		core, index, err = scheduler.getCore()
+		if len(ff.cores) != 0 {
+			core, index, err = scheduler.getPinnedCore(ff)
+		} else {
+			core, index, err = scheduler.getCore(ff.socket)
+		}
 		if err != nil {
 			common.LogWarning(common.Debug, "Can't start new clone for", ff.name, "instance", n)
 			return err
@@ -349,25 +390,35 @@ func (scheduler *scheduler) systemStop() {
 		// We need to wait because scheduler can sleep at this moment
 		runtime.Gosched()
 	}
+	// Statistics functions can read instances from other goroutines
+	scheduler.tuningLock.Lock()
 	for i := range scheduler.ff {
 		for scheduler.ff[i].instanceNumber != 0 {
 			scheduler.ff[i].stopInstance(0, -1, scheduler)
 		}
 	}
+	scheduler.ff = nil
+	scheduler.tuningLock.Unlock()
 	scheduler.setCoreByIndex(scheduler.coreIndex)
 	if scheduler.stopDedicatedCore {
-		scheduler.setCoreByIndex(scheduler.coreIndex + 1)
+		scheduler.setCoreByIndex(scheduler.stopCoreIndex)
+	}
+	for i := range scheduler.cores {
+		scheduler.cores[i].reserved = false
 	}
-	scheduler.ff = nil
 }
 
 // Main loop after framework was started
-func (scheduler *scheduler) schedule(schedTime uint) {
+func (scheduler *scheduler) schedule() {
 	tick := time.Tick(time.Duration(scheduler.checkTime) * time.Millisecond)
 	debugTick := time.Tick(time.Duration(scheduler.debugTime) * time.Millisecond)
 	checkRequired := false
+	scheduler.tuningLock.Lock()
+	interval := loadSchedTime()
+	scheduler.tuningLock.Unlock()
 	for atomic.LoadInt32(&scheduler.stopFlag) == process {
-		time.Sleep(time.Millisecond * time.Duration(schedTime))
+		time.Sleep(time.Millisecond * time.Duration(interval))
+		runOverdueMaintenance()
 		// We have an array of Timers which can be increated by AddTimer function
 		// Timer has duration and handler common for all Timer variants, so firstly
 		// we check that timer ticker channel is ready:
@@ -398,6 +449,25 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 			default:
 			}
 		}
+		// Timer functions which don't have dedicated cores are called here
+		now := time.Now()
+		for _, tp := range scheduler.periodic {
+			if now.Before(tp.next) {
+				continue
+			}
+			tp.function(tp.context)
+			// Skip missed calls if function or scheduler was too slow
+			if tp.next = tp.next.Add(tp.interval); tp.next.Before(now) {
+				tp.next = now.Add(tp.interval)
+			}
+		}
+		// Tuning functions can't change parameters while decisions are made
+		scheduler.tuningLock.Lock()
+		// New flow functions of graph update aren't started yet
+		if graphUpdate {
+			scheduler.tuningLock.Unlock()
+			continue
+		}
 		select {
 		case <-tick:
 			checkRequired = true
@@ -414,7 +484,7 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 				}
 			}
 			for i := range scheduler.ff {
-				scheduler.ff[i].printDebug(schedTime)
+				scheduler.ff[i].printDebug(loadSchedTime())
 			}
 			if scheduler.Dropped != 0 {
 				common.LogDrop(common.Debug, "Flow functions together dropped", scheduler.Dropped, "packets")
@@ -434,7 +504,7 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 			if ff.fType == fastGenerate {
 				select {
 				case temp := <-(ff.Parameters.(*generateParameters)).targetChannel:
-					if float64(temp)/(1000 /*milliseconds*/ /float64(schedTime)) < float64(burstSize) {
+					if float64(temp)/(1000 /*milliseconds*/ /float64(loadSchedTime())) < float64(burstSize) {
 						// TargetSpeed per schedTime should be more than burstSize because one burstSize packets in
 						// one schedTime seconds are out minimal scheduling part. We can't make generate speed less than this.
 						common.LogWarning(common.Debug, "Target speed per schedTime should be more than burstSize - not changing")
@@ -456,7 +526,7 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 						ffi := ff.instance[q]
 						if ffi.cloneNumber > 1 {
 							ffi.reportedState.ZeroAttempts[0] = ffi.reportedState.ZeroAttempts[0] * scheduler.pAttempts[ffi.cloneNumber]
-							if ffi.reportedState.ZeroAttempts[0] > uint64(schedTime)*uint64(1000000*1.05) || ffi.decreasedSpeed > ffi.reportedState.V.Packets {
+							if ffi.cloneNumber > ff.minClones && (ffi.reportedState.ZeroAttempts[0] > scheduler.idleLimit() || ffi.decreasedSpeed > ffi.reportedState.V.Packets) {
 								// Save current speed as speed of flow function with this number of clones before removing
 								ffi.increasedSpeed = ffi.reportedState.V.Packets
 								ffi.decreasedSpeed = 0
@@ -479,7 +549,7 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 						}
 					}
 					if maxZeroAttempts0 != -1 && maxZeroAttempts1 != -1 && ff.instance[maxZeroAttempts1].reportedState.ZeroAttempts[0] != 0 {
-						if ff.instance[maxZeroAttempts0].reportedState.ZeroAttempts[0]+ff.instance[maxZeroAttempts1].reportedState.ZeroAttempts[0] > uint64(schedTime)*uint64(1.05*1000000) {
+						if ff.instance[maxZeroAttempts0].reportedState.ZeroAttempts[0]+ff.instance[maxZeroAttempts1].reportedState.ZeroAttempts[0] > scheduler.idleLimit() {
 							toInstance := ff.instance[maxZeroAttempts1]
 							ff.stopInstance(maxZeroAttempts0, maxZeroAttempts1, scheduler)
 							toInstance.updatePause(0)
@@ -487,12 +557,12 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 					}
 				case fastGenerate: // Only clones, no instances
 					targetSpeed := (ff.Parameters.(*generateParameters)).targetSpeed
-					speedPKTS := float64(ff.instance[0].reportedState.V.normalize(schedTime).Packets)
+					speedPKTS := float64(ff.instance[0].reportedState.V.normalize(loadSchedTime()).Packets)
 					// 3. Current speed is much bigger than target speed
 					if speedPKTS > 1.1*targetSpeed {
 						ffi := ff.instance[0]
 						// 4. TODO strange heuristic, it is required to check this
-						if ffi.cloneNumber > 1 && targetSpeed/float64(ffi.cloneNumber+1)*float64(ffi.cloneNumber) > speedPKTS {
+						if ffi.cloneNumber > 1 && ffi.cloneNumber > ff.minClones && targetSpeed/float64(ffi.cloneNumber+1)*float64(ffi.cloneNumber) > speedPKTS {
 							ff.stopClone(ffi, scheduler)
 							ffi.updatePause(0)
 							continue
@@ -557,7 +627,18 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 								ffi.removed = false
 								continue
 							}
-							if ffi.inIndex[0] == 1 && scheduler.unrestrictedClones && ffi.checkInputRingClonable(scheduler.maxPacketsToClone) &&
+							cloneable := ffi.inIndex[0] == 1 && scheduler.unrestrictedClones
+							if cloneable && ffi.cloneNumber < ff.minClones {
+								// Clones required by SetNodeClones are added without ring and speed checks
+								if scheduler.pAttempts[ffi.cloneNumber+1] == 0 {
+									scheduler.pAttempts[ffi.cloneNumber+1] = scheduler.measure(1, ffi.cloneNumber+1)
+								}
+								if ffi.startNewClone(scheduler, q) == nil {
+									ffi.updatePause(ffi.cloneNumber - 1)
+								}
+								continue
+							}
+							if cloneable && ff.canAddClone(ffi) && ffi.checkInputRingClonable(scheduler.maxPacketsToClone) &&
 								ffi.checkOutputRingClonable(scheduler.maxPacketsToClone) &&
 								(ffi.increasedSpeed == 0 || ffi.increasedSpeed > ffi.reportedState.V.Packets) {
 								if scheduler.pAttempts[ffi.cloneNumber+1] == 0 {
@@ -581,10 +662,10 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 					}
 				case fastGenerate: // Only clones, no instances
 					// 3. speed is not enough
-					if float64(ff.instance[0].reportedState.V.normalize(schedTime).Packets) < (ff.Parameters.(*generateParameters)).targetSpeed {
+					if float64(ff.instance[0].reportedState.V.normalize(loadSchedTime()).Packets) < (ff.Parameters.(*generateParameters)).targetSpeed {
 						if ff.instance[0].pause != 0 {
 							ff.instance[0].updatePause(int((1 - generatePauseStep) * float64(ff.instance[0].pause)))
-						} else if ff.instance[0].checkOutputRingClonable(scheduler.maxPacketsToClone) {
+						} else if ff.canAddClone(ff.instance[0]) && ff.instance[0].checkOutputRingClonable(scheduler.maxPacketsToClone) {
 							// 3. there is no pause
 							if ff.instance[0].startNewClone(scheduler, 0) == nil {
 								ff.instance[0].updatePause(0)
@@ -608,6 +689,8 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 				}
 			}
 		}
+		interval = loadSchedTime()
+		scheduler.tuningLock.Unlock()
 		checkRequired = false
 		runtime.Gosched()
 	}
@@ -702,17 +785,31 @@ func (scheduler *scheduler) setCoreByIndex(i int) {
 	scheduler.usedCores--
 }
 
-func (scheduler *scheduler) getCore() (int, int, error) {
+// getCore returns free core which isn't reserved by SetAffinity. Cores
+// of given NUMA socket are preferred, core of other socket is returned
+// only if there are no free cores on given socket.
+func (scheduler *scheduler) getCore(socket int) (int, int, error) {
+	if socket != low.AnySocket {
+		for i := range scheduler.cores {
+			if scheduler.cores[i].isfree == true && !scheduler.cores[i].reserved && scheduler.cores[i].socket == socket {
+				return scheduler.takeCore(i)
+			}
+		}
+	}
 	for i := range scheduler.cores {
-		if scheduler.cores[i].isfree == true {
-			scheduler.cores[i].isfree = false
-			scheduler.usedCores++
-			return scheduler.cores[i].id, i, nil
+		if scheduler.cores[i].isfree == true && !scheduler.cores[i].reserved {
+			return scheduler.takeCore(i)
 		}
 	}
 	return 0, 0, common.WrapWithNFError(nil, "Requested number of cores isn't enough.", common.NotEnoughCores)
 }
 
+func (scheduler *scheduler) takeCore(i int) (int, int, error) {
+	scheduler.cores[i].isfree = false
+	scheduler.usedCores++
+	return scheduler.cores[i].id, i, nil
+}
+
 func (ffi *instance) checkInputRingClonable(min uint32) bool {
 	switch ffi.ff.Parameters.(type) {
 	case *segmentParameters:
@@ -800,7 +897,7 @@ func constructNewIndex(inIndexNumber int32) []int32 {
 // replaced by "inline" time measurements which will cost
 // 3 time.Now = ~210nn per each burstSize (32) packets.
 func (scheduler *scheduler) measure(N int32, clones int) uint64 {
-	core, index, err := scheduler.getCore()
+	core, index, err := scheduler.getCore(low.AnySocket)
 	if err != nil {
 		return 0
 	}
@@ -817,12 +914,12 @@ func (scheduler *scheduler) measure(N int32, clones int) uint64 {
 	report := make(chan reportPair, 20)
 	var reportedState reportPair
 	var avg uint64
-	t := schedTime
+	t := loadSchedTime()
 	pause := clones - 1
 	if pause == 0 {
-		schedTime = 5
+		storeSchedTime(5)
 	} else {
-		schedTime = 15
+		storeSchedTime(15)
 	}
 	for o := 0; o < 5; o++ {
 		go func() {
@@ -840,11 +937,11 @@ func (scheduler *scheduler) measure(N int32, clones int) uint64 {
 		for len(report) > 0 {
 			<-report
 		}
-		avg += uint64(schedTime) * 1000000 / reportedState.ZeroAttempts[0]
+		avg += uint64(loadSchedTime()) * 1000000 / reportedState.ZeroAttempts[0]
 		reportedState.ZeroAttempts[0] = 0
 	}
 	scheduler.setCoreByIndex(index)
-	schedTime = t
+	storeSchedTime(t)
 	close(stopper[0])
 	close(stopper[1])
 	close(report)
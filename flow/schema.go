@@ -0,0 +1,116 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// StatsAPIVersion is a version of telemetry requests of HTTP server
+// started by Config.StatsHTTPAddress. Requests of this version have
+// prefix /v1/, older /json/ requests return the same data.
+const StatsAPIVersion = 1
+
+// Framework statistics, descriptions are checked against structures
+// at start, so field can't be added or renamed without updating
+// schema.
+var frameworkStats = []common.StatsGroup{
+	{
+		Name:    "rxtx",
+		Version: StatsAPIVersion,
+		Path:    "/v1/rxtx/{node}",
+		Help:    "Counters of receive and send nodes, list of nodes is returned by /v1/rxtx",
+		Fields: common.MustDescribeStats(common.RXTXStats{}, map[string]common.StatField{
+			"PacketsProcessed": {Type: common.StatCounter, Unit: "packets", Help: "Packets received or sent by node"},
+			"PacketsDropped":   {Type: common.StatCounter, Unit: "packets", Help: "Packets which node failed to send"},
+			"BytesProcessed":   {Type: common.StatCounter, Unit: "bytes", Help: "Bytes of processed packets counted according to ByteAccounting of config"},
+		}),
+	},
+	{
+		Name:    "drops",
+		Version: StatsAPIVersion,
+		Path:    "/v1/drops",
+		Help:    "Packets dropped by handlers for every drop reason, reasons without drops are omitted",
+		Fields: common.MustDescribeStats(DropStats{}, map[string]common.StatField{
+			"Node":    {Type: common.StatLabel, Help: "Segment node with handler which dropped packets"},
+			"Reason":  {Type: common.StatLabel, Help: "Name of drop reason"},
+			"Packets": {Type: common.StatCounter, Unit: "packets", Help: "Dropped packets"},
+		}),
+	},
+	{
+		Name:    "latency",
+		Version: StatsAPIVersion,
+		Path:    "/v1/latency",
+		Help:    "Processing time percentiles of user handlers if latency instrumentation is enabled",
+		Fields: common.MustDescribeStats(HandlerLatency{}, map[string]common.StatField{
+			"Name":    {Type: common.StatLabel, Help: "User function"},
+			"Node":    {Type: common.StatLabel, Help: "Segment node which contains function"},
+			"Samples": {Type: common.StatCounter, Unit: "packets", Help: "Measured packets"},
+			"P50":     {Type: common.StatGauge, Unit: "ns", Help: "Median processing time"},
+			"P90":     {Type: common.StatGauge, Unit: "ns", Help: "90th percentile of processing time"},
+			"P99":     {Type: common.StatGauge, Unit: "ns", Help: "99th percentile of processing time"},
+			"P999":    {Type: common.StatGauge, Unit: "ns", Help: "99.9th percentile of processing time"},
+			"Max":     {Type: common.StatGauge, Unit: "ns", Help: "Maximum processing time"},
+		}),
+	},
+	{
+		Name:    "maintenance",
+		Version: StatsAPIVersion,
+		Path:    "/v1/maintenance",
+		Help:    "Periodic maintenance jobs which run in idle rounds of polling loops",
+		Fields: common.MustDescribeStats(MaintenanceStats{}, map[string]common.StatField{
+			"Name":        {Type: common.StatLabel, Help: "Maintenance job"},
+			"Runs":        {Type: common.StatCounter, Unit: "runs", Help: "Runs of job"},
+			"Backstop":    {Type: common.StatCounter, Unit: "runs", Help: "Runs by scheduler because polling loops had no idle rounds during period"},
+			"MaxDuration": {Type: common.StatGauge, Unit: "ns", Help: "Maximum duration of job"},
+		}),
+	},
+}
+
+var (
+	statsGroupsLock sync.Mutex
+	// Statistics groups registered by application
+	appStats []common.StatsGroup
+)
+
+// RegisterStatsGroup adds description of application statistics to
+// schema returned by GetStatsSchema and /v1/schema request. Fields of
+// group are usually built by common.DescribeStats.
+func RegisterStatsGroup(group common.StatsGroup) error {
+	if group.Name == "" || group.Version <= 0 {
+		return common.WrapWithNFError(nil, "Statistics group should have name and positive version", common.BadArgument)
+	}
+	statsGroupsLock.Lock()
+	defer statsGroupsLock.Unlock()
+	for _, groups := range [][]common.StatsGroup{frameworkStats, appStats} {
+		for _, g := range groups {
+			if g.Name == group.Name {
+				return common.WrapWithNFError(nil, "Statistics group "+group.Name+" is already registered", common.BadArgument)
+			}
+		}
+	}
+	appStats = append(appStats, group)
+	return nil
+}
+
+// GetStatsSchema returns description of framework statistics and
+// statistics registered by application.
+func GetStatsSchema() common.StatsSchema {
+	statsGroupsLock.Lock()
+	defer statsGroupsLock.Unlock()
+	groups := make([]common.StatsGroup, 0, len(frameworkStats)+len(appStats))
+	return common.StatsSchema{Groups: append(append(groups, frameworkStats...), appStats...)}
+}
+
+func handleJSONSchema(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(GetStatsSchema())
+}
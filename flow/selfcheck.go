@@ -0,0 +1,218 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// SelfCheckPair describes two ports which should be connected with
+// each other directly or through L2 network.
+type SelfCheckPair struct {
+	// Port which sends probe frames
+	From uint16
+	// Port which should receive probe frames
+	To uint16
+	// VLAN ID of probe frames, zero for untagged frames
+	VLAN uint16
+}
+
+// IEEE 802 local experimental EtherType
+const selfCheckEtherType = 0x88b5
+
+// Interval between retransmissions of probe frames
+const selfCheckInterval = 100 * time.Millisecond
+
+var selfCheckMagic = []byte("NFF-GO self-check")
+
+var (
+	selfCheckPairs   []SelfCheckPair
+	selfCheckTimeout time.Duration
+)
+
+// SetSelfCheck adds startup check of cabling and VLAN configuration.
+// SystemStart sends probe frame from the first port of every pair
+// after ports are started and expects it to be received by the
+// second port during timeout. If any probe is lost or is received by
+// other port, SystemStart fails with error which describes all
+// failed pairs. Receiving ports should be used by SetReceiver. All
+// packets received during check are dropped. Pairs are directional,
+// so both directions should be specified to check both of them.
+func SetSelfCheck(pairs []SelfCheckPair, timeout time.Duration) error {
+	if len(pairs) == 0 || timeout <= 0 {
+		return common.WrapWithNFError(nil, "Self-check requires port pairs and positive timeout", common.BadArgument)
+	}
+	for _, p := range pairs {
+		if p.VLAN > 0xfff {
+			return common.WrapWithNFError(nil, fmt.Sprintf("Wrong self-check VLAN ID %d", p.VLAN), common.BadArgument)
+		}
+	}
+	selfCheckPairs = append([]SelfCheckPair(nil), pairs...)
+	selfCheckTimeout = timeout
+	return nil
+}
+
+// selfCheckProbe is a state of probe frame of one pair.
+type selfCheckProbe struct {
+	pair  SelfCheckPair
+	frame []byte
+	// Ports which received probe and VLAN IDs of received frames
+	received map[uint16]uint16
+}
+
+func newSelfCheckProbe(pair SelfCheckPair, index int, nonce uint32) *selfCheckProbe {
+	mac := GetPortMACAddress(pair.From)
+	frame := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	frame = append(frame, mac[:]...)
+	if pair.VLAN != 0 {
+		frame = append(frame, types.VLANNumber>>8, types.VLANNumber&0xff, byte(pair.VLAN>>8), byte(pair.VLAN))
+	}
+	frame = append(frame, selfCheckEtherType>>8, selfCheckEtherType&0xff)
+	frame = append(frame, selfCheckMagic...)
+	frame = append(frame, byte(index>>8), byte(index))
+	frame = append(frame, byte(nonce>>24), byte(nonce>>16), byte(nonce>>8), byte(nonce))
+	for len(frame) < 60 {
+		frame = append(frame, 0)
+	}
+	return &selfCheckProbe{pair: pair, frame: frame, received: make(map[uint16]uint16)}
+}
+
+// parseSelfCheckFrame returns index of probe, nonce and VLAN ID of
+// probe frame. Returns false if frame is not a probe.
+func parseSelfCheckFrame(frame []byte) (index int, nonce uint32, vlan uint16, ok bool) {
+	if len(frame) < types.EtherLen {
+		return
+	}
+	data := frame[types.EtherLen-2:]
+	if binary.BigEndian.Uint16(data) == types.VLANNumber && len(data) >= types.VLANLen+2 {
+		vlan = binary.BigEndian.Uint16(data[2:]) & 0xfff
+		data = data[types.VLANLen:]
+	}
+	if binary.BigEndian.Uint16(data) != selfCheckEtherType {
+		return
+	}
+	data = data[2:]
+	if len(data) < len(selfCheckMagic)+6 || !bytes.Equal(data[:len(selfCheckMagic)], selfCheckMagic) {
+		return
+	}
+	data = data[len(selfCheckMagic):]
+	return int(binary.BigEndian.Uint16(data)), binary.BigEndian.Uint32(data[2:]), vlan, true
+}
+
+// diagnose returns description of check failure or empty string if
+// probe was received as expected.
+func (p *selfCheckProbe) diagnose() string {
+	vlan, ok := p.received[p.pair.To]
+	switch {
+	case ok && vlan == p.pair.VLAN:
+		return ""
+	case ok:
+		return fmt.Sprintf("probe from port %d was received by port %d with VLAN %d instead of %d, check VLAN configuration",
+			p.pair.From, p.pair.To, vlan, p.pair.VLAN)
+	case len(p.received) != 0:
+		var ports []string
+		for port := range p.received {
+			ports = append(ports, fmt.Sprint(port))
+		}
+		return fmt.Sprintf("probe from port %d was received by port(s) %s instead of %d, check cabling",
+			p.pair.From, strings.Join(ports, ", "), p.pair.To)
+	default:
+		diag := fmt.Sprintf("probe from port %d wasn't received by port %d, check cabling and link state", p.pair.From, p.pair.To)
+		if p.pair.VLAN != 0 {
+			diag += fmt.Sprintf(" and that VLAN %d is allowed", p.pair.VLAN)
+		}
+		return diag
+	}
+}
+
+func (p *selfCheckProbe) send() {
+	pkt, err := packet.NewPacket()
+	if err != nil {
+		return
+	}
+	if !packet.GeneratePacketFromByte(pkt, p.frame) {
+		low.DirectStop(1, []uintptr{pkt.ToUintptr()})
+		return
+	}
+	low.DirectSend(pkt.CMbuf, p.pair.From)
+}
+
+// runSelfCheck performs check of port pairs set by SetSelfCheck.
+func runSelfCheck() error {
+	for _, p := range selfCheckPairs {
+		for _, port := range []uint16{p.From, p.To} {
+			if int(port) >= len(createdPorts) || !createdPorts[port].wasRequested {
+				return common.WrapWithNFError(nil, fmt.Sprintf("Self-check port %d is not used", port), common.SelfCheckFailed)
+			}
+		}
+		if !createdPorts[p.To].willReceive {
+			return common.WrapWithNFError(nil, fmt.Sprintf("Self-check port %d doesn't receive packets", p.To), common.SelfCheckFailed)
+		}
+	}
+	common.LogTitle(common.Initialization, "------------***-------- Checking port pairs --------***----------")
+	nonce := rand.Uint32()
+	probes := make([]*selfCheckProbe, len(selfCheckPairs))
+	for i, p := range selfCheckPairs {
+		probes[i] = newSelfCheckProbe(p, i, nonce)
+	}
+	buf := make([]uintptr, burstSize)
+	deadline := time.Now().Add(selfCheckTimeout)
+	var nextSend time.Time
+	for done := false; !done && time.Now().Before(deadline); {
+		if now := time.Now(); !now.Before(nextSend) {
+			for _, p := range probes {
+				if _, ok := p.received[p.pair.To]; !ok {
+					p.send()
+				}
+			}
+			nextSend = now.Add(selfCheckInterval)
+		}
+		for i := range createdPorts {
+			if !createdPorts[i].willReceive {
+				continue
+			}
+			for q := int32(0); q < createdPorts[i].InIndex; q++ {
+				n := low.DirectReceive(createdPorts[i].port, uint16(q), buf)
+				for j := uint(0); j < n; j++ {
+					index, got, vlan, ok := parseSelfCheckFrame(packet.ExtractPacket(buf[j]).GetRawPacketBytes())
+					if ok && got == nonce && index < len(probes) {
+						probes[index].received[createdPorts[i].port] = vlan
+					}
+				}
+				if n != 0 {
+					low.DirectStop(int(n), buf)
+				}
+			}
+		}
+		done = true
+		for _, p := range probes {
+			if _, ok := p.received[p.pair.To]; !ok {
+				done = false
+			}
+		}
+	}
+	var failures []string
+	for _, p := range probes {
+		if diag := p.diagnose(); diag != "" {
+			failures = append(failures, diag)
+		} else {
+			common.LogDebug(common.Initialization, "Self-check of ports", p.pair.From, "->", p.pair.To, "passed")
+		}
+	}
+	if len(failures) != 0 {
+		return common.WrapWithNFError(nil, "Self-check failed: "+strings.Join(failures, "; "), common.SelfCheckFailed)
+	}
+	return nil
+}
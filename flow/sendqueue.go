@@ -0,0 +1,92 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"strconv"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+)
+
+type sendQueueParameters struct {
+	in    low.Rings
+	port  uint16
+	queue uint16
+	stats common.RXTXStats
+}
+
+func addQueueSender(port uint16, queue uint16, in low.Rings, inIndexNumber int32) {
+	par := new(sendQueueParameters)
+	par.port = port
+	par.queue = queue
+	par.in = in
+	ff := schedState.addFF("senderPort"+strconv.Itoa(int(port))+"Queue"+strconv.Itoa(int(queue)),
+		nil, sendQueue, nil, par, nil, sendReceiveKNI, inIndexNumber, &par.stats)
+	ff.socket = low.GetPortSocket(port)
+}
+
+func sendQueue(parameters interface{}, inIndex []int32, flag *int32, coreID int) {
+	sqp := parameters.(*sendQueueParameters)
+	low.SendQueue(sqp.port, sqp.queue, sqp.in, flag, coreID, &sqp.stats)
+}
+
+// SetSenderQueues adds send function which selects TX queue of port
+// for every packet. User defined queueFunction returns queue index
+// which should be less than queues, like SplitFunction returns flow
+// index. Every queue is served by its own sender on its own core, so
+// queues don't contend with each other, for example high priority
+// traffic can be sent to separate queue. queues can't exceed number of
+// TX queues of port set by Config.TXQueuesNumberPerPort, because two
+// senders can't share one TX queue. Port can't be used by both
+// SetSender and SetSenderQueues because they would share TX queues.
+// Several SetSenderQueues calls for one port should use the same
+// number of queues, their packets are merged.
+func SetSenderQueues(IN *Flow, portId uint16, queues uint, queueFunction SplitFunction, context UserContext) error {
+	if err := checkFlow(IN); err != nil {
+		return err
+	}
+	if portId >= uint16(len(createdPorts)) {
+		return common.WrapWithNFError(nil, "Requested send port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	if err := checkPortOwner(portId); err != nil {
+		return err
+	}
+	p := &createdPorts[portId]
+	if queues == 0 || queues > uint(tXQueuesNumberPerPort) {
+		return common.WrapWithNFError(nil, "Number of TX queues should be from 1 to "+
+			strconv.Itoa(tXQueuesNumberPerPort), common.BadArgument)
+	}
+	if p.sendRings != nil {
+		return common.WrapWithNFError(nil, "Port "+strconv.Itoa(int(portId))+" is already used by SetSender", common.BadArgument)
+	}
+	if p.queueRings != nil && len(p.queueRings) != int(queues) {
+		return common.WrapWithNFError(nil, "Port "+strconv.Itoa(int(portId))+" is already used with other number of TX queues", common.BadArgument)
+	}
+	outs, err := SetSplitter(IN, queueFunction, queues, context)
+	if err != nil {
+		return err
+	}
+	p.wasRequested = true
+	if p.queueRings == nil {
+		// Rings should be able to merge flows with any number of
+		// connection groups, see SetSender
+		var max int32
+		for i := range createdPorts {
+			if createdPorts[i].InIndex > max {
+				max = createdPorts[i].InIndex
+			}
+		}
+		p.queueRings = make([]low.Rings, queues)
+		for q := range p.queueRings {
+			p.queueRings[q] = low.CreateRingsOnSocket(burstSize*sizeMultiplier, max, low.GetPortSocket(portId))
+			addQueueSender(portId, uint16(q), p.queueRings[q], IN.inIndexNumber)
+		}
+	}
+	for q, out := range outs {
+		mergeOneFlow(out, p.queueRings[q])
+	}
+	return nil
+}
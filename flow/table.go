@@ -0,0 +1,286 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/conntrack"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// FiveTuple is a key of Table entry, it is the same as key of
+// conntrack tables. Unlike conntrack, Table is directional, so both
+// directions of connection need their own entries if necessary.
+type FiveTuple = conntrack.Tuple
+
+// GetFiveTuple extracts 5-tuple from IPv4 packet. Ports are zero for
+// protocols other than TCP, UDP, UDP-Lite and DCCP. Returns false if packet is not
+// IPv4.
+func GetFiveTuple(pkt *packet.Packet) (FiveTuple, bool) {
+	pkt.ParseL3()
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil {
+		return FiveTuple{}, false
+	}
+	t := FiveTuple{
+		SrcAddr: ipv4.SrcAddr,
+		DstAddr: ipv4.DstAddr,
+		Proto:   ipv4.NextProtoID,
+	}
+	pkt.ParseL4ForIPv4()
+	switch ipv4.NextProtoID {
+	case types.TCPNumber:
+		tcp := pkt.GetTCPNoCheck()
+		t.SrcPort, t.DstPort = tcp.SrcPort, tcp.DstPort
+	case types.UDPNumber:
+		udp := pkt.GetUDPNoCheck()
+		t.SrcPort, t.DstPort = udp.SrcPort, udp.DstPort
+	case types.UDPLiteNumber:
+		udp := pkt.GetUDPLiteNoCheck()
+		t.SrcPort, t.DstPort = udp.SrcPort, udp.DstPort
+	case types.DCCPNumber:
+		dccp := pkt.GetDCCPNoCheck()
+		t.SrcPort, t.DstPort = dccp.SrcPort, dccp.DstPort
+	}
+	return t, true
+}
+
+// EvictionReason shows why entry was removed from Table.
+type EvictionReason uint8
+
+const (
+	// EntryExpired means that entry timeout passed since its last use
+	EntryExpired EvictionReason = iota
+	// EntryDeleted means that entry was deleted by user
+	EntryDeleted
+)
+
+// EvictionFunction is called for every entry which is removed from
+// Table. It is called from goroutine which removes entry.
+type EvictionFunction func(e *TableEntry, reason EvictionReason)
+
+// TableEntry is an entry of Table. Key and Value are set on insertion
+// and shouldn't be changed, if value should be changed it should
+// contain pointer to user data which is synchronized by user.
+type TableEntry struct {
+	Key   FiveTuple
+	Value interface{}
+	// Time of expiration and timeout in nanoseconds, accessed
+	// atomically
+	expires int64
+	timeout int64
+	// Next entry in bucket list, accessed atomically
+	next    unsafe.Pointer
+	removed uint32
+}
+
+// Touch restarts entry timer.
+func (e *TableEntry) Touch(now time.Time) {
+	atomic.StoreInt64(&e.expires, now.UnixNano()+atomic.LoadInt64(&e.timeout))
+}
+
+// SetTimeout changes timeout of entry and restarts its timer.
+func (e *TableEntry) SetTimeout(timeout time.Duration, now time.Time) {
+	atomic.StoreInt64(&e.timeout, int64(timeout))
+	e.Touch(now)
+}
+
+// Expires returns time when entry expires if it is not touched.
+func (e *TableEntry) Expires() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&e.expires))
+}
+
+// Removed returns true if entry was removed from table.
+func (e *TableEntry) Removed() bool {
+	return atomic.LoadUint32(&e.removed) != 0
+}
+
+func (e *TableEntry) getNext() *TableEntry {
+	return (*TableEntry)(atomic.LoadPointer(&e.next))
+}
+
+// tableShard has buckets with lists of entries. Lists are changed
+// under mutex, readers traverse them without locks. Removed entries
+// keep their next pointers, so readers which stand on them continue
+// traversal correctly.
+type tableShard struct {
+	sync.Mutex
+	buckets []unsafe.Pointer
+}
+
+// Table is a hash table of flows keyed by 5-tuple. Lookup is lock
+// free, insertion and deletion lock only one shard of table. Every
+// entry has a timer which is restarted by Touch, entries are expired
+// by Expire which should be called periodically, for example from
+// SetTimer handler. Table can be used by several flow functions and
+// their clones simultaneously.
+type Table struct {
+	shards     [conntrack.ShardsNumber]tableShard
+	bucketMask uint32
+	maxEntries int64
+	count      int64
+	timeout    time.Duration
+	evict      EvictionFunction
+}
+
+// NewTable creates table for maxEntries entries with default entry
+// timeout. evict is called for every removed entry if it is not nil.
+func NewTable(maxEntries int, timeout time.Duration, evict EvictionFunction) (*Table, error) {
+	if maxEntries <= 0 || timeout <= 0 {
+		return nil, common.WrapWithNFError(nil, "Table size and timeout should be positive", common.BadArgument)
+	}
+	buckets := uint32(1)
+	for int(buckets)*conntrack.ShardsNumber < maxEntries {
+		buckets <<= 1
+	}
+	t := &Table{
+		bucketMask: buckets - 1,
+		maxEntries: int64(maxEntries),
+		timeout:    timeout,
+		evict:      evict,
+	}
+	for i := range t.shards {
+		t.shards[i].buckets = make([]unsafe.Pointer, buckets)
+	}
+	return t, nil
+}
+
+func (t *Table) bucket(key FiveTuple) (*tableShard, *unsafe.Pointer) {
+	h := key.Hash()
+	s := &t.shards[h&(conntrack.ShardsNumber-1)]
+	return s, &s.buckets[(h/conntrack.ShardsNumber)&t.bucketMask]
+}
+
+func find(head *unsafe.Pointer, key FiveTuple) *TableEntry {
+	for e := (*TableEntry)(atomic.LoadPointer(head)); e != nil; e = e.getNext() {
+		if e.Key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+// Lookup returns entry with given key or nil if there is no such
+// entry. Lookup doesn't restart entry timer.
+func (t *Table) Lookup(key FiveTuple) *TableEntry {
+	_, head := t.bucket(key)
+	return find(head, key)
+}
+
+// Insert adds entry with given key and value if there is no entry
+// with this key already. Returns entry from table and true if new
+// entry was inserted. Returns error if table is full.
+func (t *Table) Insert(key FiveTuple, value interface{}, now time.Time) (*TableEntry, bool, error) {
+	s, head := t.bucket(key)
+	if e := find(head, key); e != nil {
+		return e, false, nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	// Entry could be inserted by another goroutine
+	if e := find(head, key); e != nil {
+		return e, false, nil
+	}
+	if atomic.AddInt64(&t.count, 1) > t.maxEntries {
+		atomic.AddInt64(&t.count, -1)
+		return nil, false, common.WrapWithNFError(nil, "Flow table is full", common.TableIsFull)
+	}
+	e := &TableEntry{
+		Key:     key,
+		Value:   value,
+		timeout: int64(t.timeout),
+		next:    atomic.LoadPointer(head),
+	}
+	e.Touch(now)
+	atomic.StorePointer(head, unsafe.Pointer(e))
+	return e, true, nil
+}
+
+// remove unlinks entry from its bucket. Shard should be locked.
+// Returns false if entry was already removed.
+func (t *Table) remove(head *unsafe.Pointer, e *TableEntry) bool {
+	for p := head; ; {
+		cur := (*TableEntry)(atomic.LoadPointer(p))
+		if cur == nil {
+			return false
+		}
+		if cur == e {
+			atomic.StorePointer(p, atomic.LoadPointer(&e.next))
+			atomic.StoreUint32(&e.removed, 1)
+			atomic.AddInt64(&t.count, -1)
+			return true
+		}
+		p = &cur.next
+	}
+}
+
+// Delete removes entry from table and calls eviction function for
+// it. Returns false if entry was already removed.
+func (t *Table) Delete(e *TableEntry) bool {
+	s, head := t.bucket(e.Key)
+	s.Lock()
+	removed := t.remove(head, e)
+	s.Unlock()
+	if removed && t.evict != nil {
+		t.evict(e, EntryDeleted)
+	}
+	return removed
+}
+
+// Expire removes entries whose timers expired by given time and calls
+// eviction function for them. Returns number of removed entries.
+func (t *Table) Expire(now time.Time) int {
+	deadline := now.UnixNano()
+	number := 0
+	var expired []*TableEntry
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.Lock()
+		for b := range s.buckets {
+			for e := (*TableEntry)(s.buckets[b]); e != nil; e = e.getNext() {
+				if atomic.LoadInt64(&e.expires) <= deadline && t.remove(&s.buckets[b], e) {
+					expired = append(expired, e)
+				}
+			}
+		}
+		s.Unlock()
+		number += len(expired)
+		// Eviction function is called without lock, so it can use table
+		if t.evict != nil {
+			for _, e := range expired {
+				t.evict(e, EntryExpired)
+			}
+		}
+		expired = expired[:0]
+	}
+	return number
+}
+
+// Len returns number of entries in table.
+func (t *Table) Len() int {
+	return int(atomic.LoadInt64(&t.count))
+}
+
+// Range calls f for every entry of table until f returns false.
+// Entries which are inserted or removed during iteration may be
+// skipped or visited.
+func (t *Table) Range(f func(*TableEntry) bool) {
+	for i := range t.shards {
+		s := &t.shards[i]
+		for b := range s.buckets {
+			for e := (*TableEntry)(atomic.LoadPointer(&s.buckets[b])); e != nil; e = e.getNext() {
+				if !f(e) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/pktfilter"
+)
+
+// Traces of packets which don't leave segments during this time are
+// considered lost, for example if packet was freed by user function.
+const traceTimeout = time.Second
+
+// TraceFilter selects packets which should be traced. It is called
+// for every packet entering segment of user functions which is not
+// traced yet, so it should be fast.
+type TraceFilter func(*packet.Packet) bool
+
+// TraceExpression returns TraceFilter which selects packets matching
+// filter expression, see package pktfilter for its syntax.
+func TraceExpression(expr string) (TraceFilter, error) {
+	f, err := pktfilter.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return func(pkt *packet.Packet) bool {
+		return f.Match(pkt.GetRawPacketBytes())
+	}, nil
+}
+
+// TraceHop describes processing of traced packet by one user function.
+type TraceHop struct {
+	// Name of segment node, the same as in GetNodeStats
+	Node string
+	// Name of user function, empty for functions created by framework
+	Function string
+	Time     time.Time
+	// Result of function: "handled" for handlers, "true" or "false"
+	// for separators, "pass" or "drop" for drop reason handlers, "flow
+	// N" for splitters and "output" for the end of segment
+	Verdict string
+}
+
+// PacketTrace is a path of traced packet through flow graph.
+type PacketTrace struct {
+	Hops []TraceHop
+	// Final destination of packet: "stop" if packet was dropped,
+	// name of node which received packet from segment, for example
+	// sender, or "lost" if trace timed out
+	Result string
+}
+
+type activeTrace struct {
+	trace   *PacketTrace
+	started time.Time
+}
+
+type tracer struct {
+	filter  TraceFilter
+	limit   int
+	handler func(*PacketTrace)
+	// Names of nodes which aren't segments for all their input rings
+	consumers map[*low.Ring]string
+	sync.Mutex
+	// Traces of packets inside segments, keyed by mbuf address
+	active map[uintptr]activeTrace
+	done   []*PacketTrace
+}
+
+// Enabled tracer or nil if tracing is disabled
+var tracing *tracer
+
+// SetTracing enables debug mode in which packets matching filter get
+// trace records. Trace lists every user function which processed
+// packet with timestamps and verdicts and is finished when packet
+// leaves segments to sender, stopper or other node. Finished traces
+// are passed to handler if it isn't nil and are kept until they are
+// taken by GetPacketTraces. At most limit packets are traced at the
+// same time and at most limit finished traces are kept, older traces
+// are discarded. Only user functions are traced, so trace starts at
+// the first segment after receiver. Tracing slows down all segments
+// and should be used for debugging only. It should be called before
+// SystemStart.
+func SetTracing(filter TraceFilter, limit int, handler func(*PacketTrace)) error {
+	if filter == nil || limit <= 0 {
+		return common.WrapWithNFError(nil, "Tracing requires filter and positive limit", common.BadArgument)
+	}
+	tracing = &tracer{
+		filter:  filter,
+		limit:   limit,
+		handler: handler,
+		active:  make(map[uintptr]activeTrace),
+	}
+	return nil
+}
+
+// GetPacketTraces returns finished traces and removes them. Traces
+// which timed out are returned with "lost" result.
+func GetPacketTraces() []*PacketTrace {
+	if tracing == nil {
+		return nil
+	}
+	t := tracing
+	t.Lock()
+	lost := t.expire(time.Now())
+	ret := t.done
+	t.done = nil
+	t.Unlock()
+	t.report(lost)
+	return ret
+}
+
+// prepare finds nodes which consume output rings of segments. It is
+// called when graph is complete.
+func (t *tracer) prepare() {
+	t.consumers = make(map[*low.Ring]string)
+	for _, ff := range schedState.ff {
+		if _, ok := ff.Parameters.(*segmentParameters); ok {
+			continue
+		}
+		for _, r := range ff.inputRings() {
+			t.consumers[r] = ff.name
+		}
+	}
+	for _, r := range schedState.StopRing {
+		t.consumers[r] = "stop"
+	}
+}
+
+// lookup returns trace of packet. New trace is started if packet
+// matches filter.
+func (t *tracer) lookup(mbuf uintptr, pkt *packet.Packet) *PacketTrace {
+	t.Lock()
+	if at, ok := t.active[mbuf]; ok {
+		t.Unlock()
+		return at.trace
+	}
+	var trace *PacketTrace
+	var lost []*PacketTrace
+	if t.filter(pkt) {
+		now := time.Now()
+		if len(t.active) >= t.limit {
+			lost = t.expire(now)
+		}
+		if len(t.active) < t.limit {
+			trace = new(PacketTrace)
+			t.active[mbuf] = activeTrace{trace: trace, started: now}
+		}
+	}
+	t.Unlock()
+	t.report(lost)
+	return trace
+}
+
+// hop records result of function f which returned index. Trace is
+// finished if function sent packet from segment to node which is
+// not segment.
+func (t *tracer) hop(mbuf uintptr, trace *PacketTrace, node string, f *Func, index uint, out []low.Rings) {
+	h := TraceHop{Node: node, Function: f.name, Time: time.Now()}
+	switch {
+	case f.followingNumber == 0:
+		h.Verdict = "output"
+	case f.sDropFunction != nil && index == 0:
+		h.Verdict = "drop"
+	case f.sDropFunction != nil:
+		h.Verdict = "pass"
+	case f.sHandleFunction != nil || f.vHandleFunction != nil:
+		h.Verdict = "handled"
+	case f.sSeparateFunction != nil || f.vSeparateFunction != nil:
+		h.Verdict = strconv.FormatBool(index != 0)
+	default:
+		h.Verdict = "flow " + strconv.Itoa(int(index))
+	}
+	finished := false
+	t.Lock()
+	trace.Hops = append(trace.Hops, h)
+	if f.followingNumber == 0 && len(out[index]) != 0 {
+		if result, ok := t.consumers[out[index][0]]; ok {
+			delete(t.active, mbuf)
+			t.finish(trace, result)
+			finished = true
+		}
+	}
+	t.Unlock()
+	if finished {
+		t.report([]*PacketTrace{trace})
+	}
+}
+
+// finish stores finished trace, it should be called with lock.
+func (t *tracer) finish(trace *PacketTrace, result string) {
+	trace.Result = result
+	if len(t.done) >= t.limit {
+		t.done = t.done[1:]
+	}
+	t.done = append(t.done, trace)
+}
+
+// expire finishes traces which timed out and returns them, it should
+// be called with lock.
+func (t *tracer) expire(now time.Time) []*PacketTrace {
+	var lost []*PacketTrace
+	for mbuf, at := range t.active {
+		if now.Sub(at.started) > traceTimeout {
+			delete(t.active, mbuf)
+			t.finish(at.trace, "lost")
+			lost = append(lost, at.trace)
+		}
+	}
+	return lost
+}
+
+// report passes finished traces to user handler without lock.
+func (t *tracer) report(traces []*PacketTrace) {
+	if t.handler != nil {
+		for _, trace := range traces {
+			t.handler(trace)
+		}
+	}
+}
+
+// traceVector records results of vector function for all traced
+// packets selected by mask.
+func traceVector(mbufs []uintptr, traces *[vBurstSize]*PacketTrace, mask *[vBurstSize]bool,
+	answers *[vBurstSize]uint8, node string, f *Func, out []low.Rings) {
+	for i := range traces {
+		if !mask[i] || traces[i] == nil {
+			continue
+		}
+		// Output function returns one index for all packets
+		index := uint(answers[i])
+		if f.followingNumber == 0 {
+			index = uint(answers[0])
+		}
+		tracing.hop(mbufs[i], traces[i], node, f, index, out)
+		if f.followingNumber == 0 {
+			traces[i] = nil
+		}
+	}
+}
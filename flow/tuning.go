@@ -0,0 +1,130 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// Default ratio of idle time to scheduler interval above which clones
+// are removed
+const defaultScaleDownThreshold = 1.05
+
+// SchedulerTuning contains parameters of scheduler which can be
+// changed while system is running.
+type SchedulerTuning struct {
+	// Time between scheduler decisions and measurements of flow
+	// functions speed. It is rounded to milliseconds and can't be
+	// larger than Config.DebugTime.
+	Interval time.Duration
+	// Fill level of input ring from 0 to 1 above which scheduler adds
+	// clones of flow function. Lower values make scheduler react to
+	// traffic faster and use more cores. Default value is 0.8.
+	ScaleUpThreshold float64
+	// Ratio of time which clones of flow function spend polling empty
+	// rings to Interval above which scheduler removes clones. Lower
+	// values make scheduler free cores earlier, higher values keep
+	// clones for traffic bursts. Default value is 1.05.
+	ScaleDownThreshold float64
+}
+
+// idleLimit returns number of nanoseconds of idle polling during
+// schedTime above which clones are removed.
+func (scheduler *scheduler) idleLimit() uint64 {
+	return uint64(float64(loadSchedTime()) * 1000000 * scheduler.scaleDownThreshold)
+}
+
+// canAddClone checks limit of clones set by SetNodeClones.
+func (ff *flowFunction) canAddClone(ffi *instance) bool {
+	return ff.maxClones == 0 || ffi.cloneNumber < ff.maxClones
+}
+
+// GetSchedulerTuning returns current scheduler parameters. It should
+// be called after SystemInit.
+func GetSchedulerTuning() SchedulerTuning {
+	if schedState == nil {
+		return SchedulerTuning{}
+	}
+	schedState.tuningLock.Lock()
+	defer schedState.tuningLock.Unlock()
+	return SchedulerTuning{
+		Interval:           time.Duration(loadSchedTime()) * time.Millisecond,
+		ScaleUpThreshold:   float64(schedState.maxPacketsToClone) / float64(burstSize*sizeMultiplier),
+		ScaleDownThreshold: schedState.scaleDownThreshold,
+	}
+}
+
+// SetSchedulerTuning changes scheduler parameters. Zero fields keep
+// their current values. It can be called after SystemInit including
+// time when system is running, changes are applied before the next
+// scheduler decision.
+func SetSchedulerTuning(t SchedulerTuning) error {
+	if schedState == nil {
+		return common.WrapWithNFError(nil, "Scheduler can be tuned only after SystemInit", common.Fail)
+	}
+	if t.Interval < 0 || t.ScaleUpThreshold < 0 || t.ScaleUpThreshold > 1 || t.ScaleDownThreshold < 0 {
+		return common.WrapWithNFError(nil, "Scheduler interval and thresholds shouldn't be negative, scale up threshold shouldn't exceed 1", common.BadArgument)
+	}
+	interval := uint(t.Interval / time.Millisecond)
+	if t.Interval != 0 && interval == 0 {
+		return common.WrapWithNFError(nil, "Scheduler interval should be at least one millisecond", common.BadArgument)
+	}
+	if interval > schedState.debugTime {
+		return common.WrapWithNFError(nil, "debugTime should be larger or equal to scheduler interval", common.BadArgument)
+	}
+	schedState.tuningLock.Lock()
+	defer schedState.tuningLock.Unlock()
+	if t.ScaleUpThreshold != 0 {
+		schedState.maxPacketsToClone = uint32(t.ScaleUpThreshold * float64(burstSize*sizeMultiplier))
+	}
+	if t.ScaleDownThreshold != 0 {
+		schedState.scaleDownThreshold = t.ScaleDownThreshold
+	}
+	if interval != 0 && interval != loadSchedTime() {
+		storeSchedTime(interval)
+		// Flow functions restart their report timers with new
+		// interval when they get new pause value
+		for _, ff := range schedState.ff {
+			if ff.fType == segmentCopy || ff.fType == fastGenerate {
+				for _, ffi := range ff.instance {
+					ffi.updatePause(ffi.pause)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// SetNodeClones limits number of clones of every instance of flow
+// function with given name. Names of flow functions are the same as in
+// statistics and in DumpGraph output. Scheduler doesn't remove clones
+// below min and doesn't add clones above max even if traffic requires
+// it, zero max means no limit. Only segments of handlers, separators,
+// splitters and fast generators have clones. It can be called after
+// graph construction including time when system is running.
+func SetNodeClones(node string, min, max int) error {
+	if min < 0 || max < 0 || (max != 0 && min > max) {
+		return common.WrapWithNFError(nil, "Clone limits of "+node+" should satisfy 0 <= min <= max", common.BadArgument)
+	}
+	if schedState == nil {
+		return common.WrapWithNFError(nil, "Clone limits can be set only after SystemInit", common.Fail)
+	}
+	schedState.tuningLock.Lock()
+	defer schedState.tuningLock.Unlock()
+	for _, ff := range schedState.ff {
+		if ff.name != node {
+			continue
+		}
+		if ff.fType != segmentCopy && ff.fType != fastGenerate {
+			return common.WrapWithNFError(nil, "Flow function "+node+" can't be cloned", common.BadArgument)
+		}
+		ff.minClones = min
+		ff.maxClones = max
+		return nil
+	}
+	return common.WrapWithNFError(nil, "Flow function "+node+" doesn't exist", common.BadArgument)
+}
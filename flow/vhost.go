@@ -0,0 +1,110 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+)
+
+// Ports of vhost-user sockets created by SetReceiverVhost and
+// SetSenderVhost
+var vhostPorts = make(map[string]uint16)
+
+// Number of created vhost devices, it is used for unique device names
+var vhostDevices int
+
+// addPort adds port which was created after SystemInit to created
+// ports.
+func addPort(portId uint16) {
+	for uint16(len(createdPorts)) <= portId {
+		createdPorts = append(createdPorts, port{port: uint16(len(createdPorts))})
+	}
+	// Slice can be reallocated
+	for ip, p := range portPair {
+		portPair[ip] = &createdPorts[p.port]
+	}
+	createdPorts[portId].InIndex = 1
+}
+
+// addVdev creates virtual device with one queue after SystemInit and
+// adds its port to created ports.
+func addVdev(name, args string) (uint16, error) {
+	if !graphUpdate {
+		for i := range createdPorts {
+			if createdPorts[i].started {
+				return 0, common.WrapWithNFError(nil, "Virtual device "+name+" should be created before SystemInitPortsAndMemory or by BeginGraphUpdate", common.BadArgument)
+			}
+		}
+	}
+	portId, err := low.AddVdev(name, args)
+	if err != nil {
+		return 0, err
+	}
+	addPort(portId)
+	return portId, nil
+}
+
+// vhostPort returns port of vhost-user socket creating it at the
+// first call.
+func vhostPort(socket string) (uint16, error) {
+	if portId, ok := vhostPorts[socket]; ok {
+		return portId, nil
+	}
+	name := fmt.Sprintf("net_vhost%d", vhostDevices)
+	portId, err := addVdev(name, "iface="+socket+",queues=1")
+	if err != nil {
+		return 0, err
+	}
+	vhostDevices++
+	vhostPorts[socket] = portId
+	common.LogDebug(common.Initialization, "vhost-user socket", socket, "is port", portId)
+	return portId, nil
+}
+
+// SetReceiverVhost adds receive function from vhost-user socket to
+// flow graph. Socket is created by DPDK vhost driver in server mode,
+// QEMU guest connects to it with virtio-net device, for example
+//
+//	-chardev socket,id=char0,path=/tmp/vhost0
+//	-netdev type=vhost-user,id=net0,chardev=char0
+//	-device virtio-net-pci,netdev=net0
+//
+// Guest memory should be shared and backed by huge pages. Socket is
+// a DPDK port with one queue, receiving and sending with the same
+// socket use one port, so application can be a router for virtual
+// machines without virtual switch. Should be called after SystemInit
+// and before SystemInitPortsAndMemory. Returns new opened flow with
+// packets sent by guest.
+func SetReceiverVhost(socket string) (*Flow, error) {
+	portId, err := vhostPort(socket)
+	if err != nil {
+		return nil, err
+	}
+	return SetReceiver(portId)
+}
+
+// SetSenderVhost adds send function to vhost-user socket to flow
+// graph. Socket is created as described for SetReceiverVhost. Packets
+// are dropped while guest is not connected.
+func SetSenderVhost(IN *Flow, socket string) error {
+	portId, err := vhostPort(socket)
+	if err != nil {
+		return err
+	}
+	return SetSender(IN, portId)
+}
+
+// GetVhostPort returns port of vhost-user socket which was created by
+// SetReceiverVhost or SetSenderVhost, for example to read its
+// statistics.
+func GetVhostPort(socket string) (uint16, error) {
+	if portId, ok := vhostPorts[socket]; ok {
+		return portId, nil
+	}
+	return 0, common.WrapWithNFError(nil, "No vhost-user port for socket "+socket, common.WrongPort)
+}
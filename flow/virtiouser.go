@@ -0,0 +1,78 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// VirtioUser is an exception path to Linux kernel which replaces KNI.
+// It is a virtio-user DPDK port with vhost-net backend, so kernel
+// sees it as TAP interface. Unlike KNI it doesn't need out of tree
+// kernel module and dedicated core.
+type VirtioUser struct {
+	portId uint16
+	name   string
+}
+
+// Number of created virtio-user devices
+var virtioUsers int
+
+// CreateVirtioUserDevice creates Linux interface with given name for
+// exception path of port. Interface has MAC address of port, so
+// kernel can answer ARP requests and handle packets which were sent
+// to port address. Interface is created down and should be set up by
+// user after SystemInitPortsAndMemory. /dev/vhost-net should be
+// accessible. Should be called after SystemInit and before
+// SystemInitPortsAndMemory.
+func CreateVirtioUserDevice(portId uint16, name string) (*VirtioUser, error) {
+	if portId >= uint16(len(createdPorts)) {
+		return nil, common.WrapWithNFError(nil, "Requested exception path port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	mac := GetPortMACAddress(portId)
+	args := fmt.Sprintf("path=/dev/vhost-net,queues=1,queue_size=1024,iface=%s,mac=%02x:%02x:%02x:%02x:%02x:%02x",
+		name, mac[0], mac[1], mac[2], mac[3], mac[4], mac[5])
+	devPort, err := addVdev(fmt.Sprintf("virtio_user%d", virtioUsers), args)
+	if err != nil {
+		return nil, common.WrapWithNFError(err, "Can't create virtio-user device "+name, common.FailToCreateKNI)
+	}
+	virtioUsers++
+	return &VirtioUser{portId: devPort, name: name}, nil
+}
+
+// GetPort returns DPDK port of virtio-user device, for example to
+// read its statistics.
+func (v *VirtioUser) GetPort() uint16 {
+	return v.portId
+}
+
+// GetName returns name of Linux interface.
+func (v *VirtioUser) GetName() string {
+	return v.name
+}
+
+// SetReceiverVirtioUser adds function receive from virtio-user device
+// to flow graph. Returns new opened flow with packets sent by kernel.
+func SetReceiverVirtioUser(v *VirtioUser) (OUT *Flow, err error) {
+	return SetReceiver(v.portId)
+}
+
+// SetSenderVirtioUser adds function sending to virtio-user device to
+// flow graph. Packets of flow are received by kernel.
+func SetSenderVirtioUser(IN *Flow, v *VirtioUser) error {
+	return SetSender(IN, v.portId)
+}
+
+// SetSenderReceiverVirtioUser adds function send to and receive from
+// virtio-user device to flow graph. Returns new opened flow with
+// packets sent by kernel.
+func SetSenderReceiverVirtioUser(IN *Flow, v *VirtioUser) (OUT *Flow, err error) {
+	if err := SetSender(IN, v.portId); err != nil {
+		return nil, err
+	}
+	return SetReceiver(v.portId)
+}
@@ -0,0 +1,333 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// VRRPState is a state of virtual router.
+type VRRPState int
+
+// States of virtual router
+const (
+	VRRPInit VRRPState = iota
+	VRRPBackup
+	VRRPMaster
+)
+
+func (s VRRPState) String() string {
+	switch s {
+	case VRRPInit:
+		return "init"
+	case VRRPBackup:
+		return "backup"
+	case VRRPMaster:
+		return "master"
+	}
+	return "unknown"
+}
+
+// VRRPConfig is a configuration of IPv4 virtual router of port.
+type VRRPConfig struct {
+	// Port which shares virtual addresses with other routers
+	Port uint16
+	// Virtual router identifier, it should be from 1 to 255
+	VRID uint8
+	// Priority of router, default is 100. Router which owns virtual
+	// addresses should have priority 255.
+	Priority uint8
+	// VRRP version, 2 or 3, default is 3
+	Version uint8
+	// Advertisement interval of master, default is 1 second
+	Interval time.Duration
+	// Primary address of port, it is a source address of
+	// advertisements
+	Address          types.IPv4Address
+	VirtualAddresses []types.IPv4Address
+	// Master with lower priority isn't preempted if it is set
+	NoPreempt bool
+	// Function which is called when state of router changes, it is
+	// called from timer or handler of packets
+	StateChanged func(port uint16, vrid uint8, state VRRPState)
+}
+
+// Granularity of VRRP timers
+const vrrpTick = 10 * time.Millisecond
+
+type vrrpRouter struct {
+	sync.Mutex
+	config VRRPConfig
+	mac    types.MACAddress
+	adv    packet.VRRPAdvertisement
+	state  VRRPState
+	// Advertisement interval of current master
+	masterInterval time.Duration
+	// Time of the next advertisement in master state
+	adverTimer time.Time
+	// Time when master is considered down in backup state
+	masterDownTimer time.Time
+}
+
+var vrrpRouters struct {
+	sync.Mutex
+	// Routers indexed by port and virtual router identifier
+	routers map[uint32]*vrrpRouter
+}
+
+// SetVRRP adds IPv4 virtual router of port to flow. IN should be flow
+// of packets received from port. Router removes VRRP advertisements of
+// its virtual router from IN and answers ARP requests for virtual
+// addresses in master state, ARP requests for virtual addresses are
+// removed in backup state. Port receives packets sent to virtual MAC
+// address in master state. Router starts after SystemStart.
+func SetVRRP(IN *Flow, config VRRPConfig) error {
+	if int(config.Port) >= len(createdPorts) {
+		return common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	if config.Priority == packet.VRRPPriorityStop {
+		config.Priority = packet.VRRPPriorityDefault
+	}
+	if config.Version == 0 {
+		config.Version = 3
+	}
+	if config.Interval == 0 {
+		config.Interval = time.Second
+	}
+	if config.VRID == 0 || len(config.VirtualAddresses) == 0 || len(config.VirtualAddresses) > 0xff ||
+		config.Version != 2 && config.Version != 3 {
+		return common.WrapWithNFError(nil, "Wrong VRRP identifier, version or number of virtual addresses", common.BadArgument)
+	}
+	minInterval, maxInterval := vrrpTick, 0xfff*vrrpTick
+	if config.Version == 2 {
+		minInterval, maxInterval = time.Second, 0xff*time.Second
+	}
+	if config.Interval < minInterval || config.Interval > maxInterval {
+		return common.WrapWithNFError(nil, fmt.Sprintf("Wrong VRRP interval %v", config.Interval), common.BadArgument)
+	}
+	r := &vrrpRouter{
+		config: config,
+		mac:    packet.VRRPVirtualMAC(config.VRID, false),
+		adv: packet.VRRPAdvertisement{
+			Version:   config.Version,
+			VRID:      config.VRID,
+			Priority:  config.Priority,
+			Interval:  config.Interval,
+			IPv4Addrs: config.VirtualAddresses,
+		},
+		masterInterval: config.Interval,
+	}
+
+	key := uint32(config.Port)<<8 | uint32(config.VRID)
+	vrrpRouters.Lock()
+	if vrrpRouters.routers == nil {
+		vrrpRouters.routers = make(map[uint32]*vrrpRouter)
+	}
+	if vrrpRouters.routers[key] != nil {
+		vrrpRouters.Unlock()
+		return common.WrapWithNFError(nil, fmt.Sprintf("VRRP router %d of port %d is already set", config.VRID, config.Port), common.BadArgument)
+	}
+	vrrpRouters.routers[key] = r
+	vrrpRouters.Unlock()
+
+	if err := SetHandlerDrop(IN, func(pkt *packet.Packet, ctx UserContext) bool {
+		return r.handle(pkt)
+	}, nil); err != nil {
+		return err
+	}
+	return SetTimer(vrrpTick, func(UserContext) {
+		r.tick(time.Now())
+	}, nil, false)
+}
+
+// GetVRRPState returns state of virtual router of port. Routers which
+// aren't set are in init state.
+func GetVRRPState(port uint16, vrid uint8) VRRPState {
+	vrrpRouters.Lock()
+	r := vrrpRouters.routers[uint32(port)<<8|uint32(vrid)]
+	vrrpRouters.Unlock()
+	if r == nil {
+		return VRRPInit
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.state
+}
+
+// skewTime returns skew time of router for current master interval.
+func (r *vrrpRouter) skewTime() time.Duration {
+	if r.config.Version == 2 {
+		return time.Duration(256-int(r.config.Priority)) * time.Second / 256
+	}
+	return time.Duration(256-int(r.config.Priority)) * r.masterInterval / 256
+}
+
+func (r *vrrpRouter) masterDownInterval() time.Duration {
+	return 3*r.masterInterval + r.skewTime()
+}
+
+// tick handles timers of router.
+func (r *vrrpRouter) tick(now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	switch r.state {
+	case VRRPInit:
+		if r.config.Priority == packet.VRRPPriorityOwner {
+			r.becomeMaster(now)
+		} else {
+			r.becomeBackup(now, r.config.Interval)
+		}
+	case VRRPBackup:
+		if !now.Before(r.masterDownTimer) {
+			r.becomeMaster(now)
+		}
+	case VRRPMaster:
+		if !now.Before(r.adverTimer) {
+			r.sendAdvertisement()
+			r.adverTimer = now.Add(r.config.Interval)
+		}
+	}
+}
+
+// handle processes VRRP advertisements and ARP requests, returns false
+// for packets which are consumed by router.
+func (r *vrrpRouter) handle(pkt *packet.Packet) bool {
+	pkt.ParseL3()
+	if arp := pkt.GetARP(); arp != nil {
+		if packet.SwapBytesUint16(arp.Operation) != packet.ARPRequest || !r.isVirtual(types.ArrayToIPv4(arp.TPA)) {
+			return true
+		}
+		r.Lock()
+		master := r.state == VRRPMaster
+		r.Unlock()
+		if master {
+			r.sendARPReply(arp)
+		}
+		return false
+	}
+	data := pkt.GetVRRP()
+	if data == nil {
+		return true
+	}
+	var adv packet.VRRPAdvertisement
+	if !packet.ParseVRRP(data, false, &adv) || adv.VRID != r.config.VRID {
+		return adv.VRID != r.config.VRID
+	}
+	if adv.Version == r.config.Version {
+		r.receive(&adv, pkt.GetIPv4NoCheck().SrcAddr, time.Now())
+	}
+	return false
+}
+
+func (r *vrrpRouter) isVirtual(addr types.IPv4Address) bool {
+	for _, a := range r.config.VirtualAddresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// receive handles advertisement of other router.
+func (r *vrrpRouter) receive(adv *packet.VRRPAdvertisement, src types.IPv4Address, now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	interval := adv.Interval
+	if r.config.Version == 2 {
+		interval = r.config.Interval
+	}
+	switch r.state {
+	case VRRPBackup:
+		if adv.Priority == packet.VRRPPriorityStop {
+			r.masterDownTimer = now.Add(r.skewTime())
+		} else if r.config.NoPreempt || adv.Priority >= r.config.Priority {
+			r.becomeBackup(now, interval)
+		}
+	case VRRPMaster:
+		if adv.Priority == packet.VRRPPriorityStop {
+			r.sendAdvertisement()
+			r.adverTimer = now.Add(r.config.Interval)
+		} else if adv.Priority > r.config.Priority ||
+			adv.Priority == r.config.Priority && packet.SwapBytesIPv4Addr(src) > packet.SwapBytesIPv4Addr(r.config.Address) {
+			r.becomeBackup(now, interval)
+		}
+	}
+}
+
+func (r *vrrpRouter) becomeMaster(now time.Time) {
+	if err := low.AddPortMACAddress(r.config.Port, r.mac); err != nil {
+		common.LogWarning(common.Debug, err)
+	}
+	r.sendAdvertisement()
+	for _, addr := range r.config.VirtualAddresses {
+		r.sendGARP(addr)
+	}
+	r.adverTimer = now.Add(r.config.Interval)
+	r.setState(VRRPMaster)
+}
+
+func (r *vrrpRouter) becomeBackup(now time.Time, masterInterval time.Duration) {
+	if r.state == VRRPMaster {
+		if err := low.RemovePortMACAddress(r.config.Port, r.mac); err != nil {
+			common.LogWarning(common.Debug, err)
+		}
+	}
+	r.masterInterval = masterInterval
+	r.masterDownTimer = now.Add(r.masterDownInterval())
+	r.setState(VRRPBackup)
+}
+
+func (r *vrrpRouter) setState(state VRRPState) {
+	if r.state == state {
+		return
+	}
+	r.state = state
+	if r.config.StateChanged != nil {
+		r.config.StateChanged(r.config.Port, r.config.VRID, state)
+	}
+}
+
+func (r *vrrpRouter) sendAdvertisement() {
+	pkt, err := packet.NewPacket()
+	if err != nil {
+		return
+	}
+	if !packet.InitVRRPPacket(pkt, r.config.Address, &r.adv) {
+		low.DirectStop(1, []uintptr{pkt.ToUintptr()})
+		return
+	}
+	low.DirectSend(pkt.CMbuf, r.config.Port)
+}
+
+func (r *vrrpRouter) sendGARP(addr types.IPv4Address) {
+	pkt, err := packet.NewPacket()
+	if err != nil {
+		return
+	}
+	if !packet.InitGARPAnnouncementRequestPacket(pkt, r.mac, addr) {
+		low.DirectStop(1, []uintptr{pkt.ToUintptr()})
+		return
+	}
+	low.DirectSend(pkt.CMbuf, r.config.Port)
+}
+
+func (r *vrrpRouter) sendARPReply(arp *packet.ARPHdr) {
+	pkt, err := packet.NewPacket()
+	if err != nil {
+		return
+	}
+	if !packet.InitARPReplyPacket(pkt, r.mac, arp.SHA, types.ArrayToIPv4(arp.TPA), types.ArrayToIPv4(arp.SPA)) {
+		low.DirectStop(1, []uintptr{pkt.ToUintptr()})
+		return
+	}
+	low.DirectSend(pkt.CMbuf, r.config.Port)
+}
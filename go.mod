@@ -1,20 +1,28 @@
 module github.com/intel-go/nff-go
 
+go 1.27.1
+
 require (
-	github.com/docker/distribution v2.7.1+incompatible // indirect
 	github.com/docker/docker v1.13.1
 	github.com/docker/go-connections v0.4.0
-	github.com/docker/go-units v0.3.3 // indirect
 	github.com/flier/gohs v1.0.0
-	github.com/google/gopacket v1.1.16 // indirect
+	github.com/google/gopacket v1.1.16
+	github.com/pkg/errors v0.8.1
+	github.com/vishvananda/netlink v1.0.0
+	golang.org/x/sys v0.0.0-20190204203706-41f3e6584952
+)
+
+require (
+	github.com/docker/distribution v2.7.1+incompatible // indirect
+	github.com/docker/go-units v0.3.3 // indirect
 	github.com/gopherjs/gopherjs v0.0.0-20181103185306-d547d1d9531e // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.0.0 // indirect
+	github.com/jtolds/gls v4.2.1+incompatible // indirect
 	github.com/opencontainers/go-digest v1.0.0-rc1 // indirect
-	github.com/pkg/errors v0.8.1
 	github.com/smartystreets/assertions v0.0.0-20190116191733-b6c0e53d7304 // indirect
 	github.com/smartystreets/goconvey v0.0.0-20181108003508-044398e4856c // indirect
-	github.com/vishvananda/netlink v1.0.0 // indirect
 	github.com/vishvananda/netns v0.0.0-20180720170159-13995c7128cc // indirect
 	golang.org/x/net v0.0.0-20190125091013-d26f9f9a57f3 // indirect
-	golang.org/x/sys v0.0.0-20190204203706-41f3e6584952 // indirect
 	golang.org/x/tools v0.0.0-20190205201329-379209517ffe // indirect
 )
@@ -18,11 +18,14 @@ package low
 import "C"
 
 import (
+	"fmt"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/intel-go/nff-go/asm"
@@ -38,16 +41,61 @@ var (
 
 var ringName = 1
 
+// Prefix of names of rings and mempools, see SetObjectPrefix
+var objectPrefix string
+
+// SetObjectPrefix sets prefix of names of rings and mempools created
+// by this process. Secondary DPDK process should use unique prefix
+// because names without it are used by primary process. It should be
+// called before any ring or mempool is created.
+func SetObjectPrefix(prefix string) {
+	objectPrefix = prefix
+	cprefix := C.CString(prefix)
+	defer C.free(unsafe.Pointer(cprefix))
+	C.setObjectPrefix(cprefix)
+}
+
+// IsSecondaryProcess returns true if DPDK was initialized as secondary
+// process which shares memory and ports of primary process.
+func IsSecondaryProcess() bool {
+	return C.rte_eal_process_type() == C.RTE_PROC_SECONDARY
+}
+
 // DirectStop frees mbufs.
 func DirectStop(pktsForFreeNumber int, buf []uintptr) {
 	C.directStop(C.int(pktsForFreeNumber), (**C.struct_rte_mbuf)(unsafe.Pointer(&(buf[0]))))
 }
 
+// CloneMbuf creates indirect mbufs from mempool which share data of
+// mbuf and all its chained segments. Data of mbuf is freed when mbuf
+// and all its clones are freed. Returns zero if mempool is empty.
+func CloneMbuf(mb *Mbuf, mempool *Mempool) uintptr {
+	return uintptr(unsafe.Pointer(C.cloneMbuf((*C.struct_rte_mbuf)(mb), (*C.struct_rte_mempool)(mempool))))
+}
+
+// RefcntUpdateMbuf adds value to reference counters of all segments
+// of mbuf. Mbuf is returned to mempool when it is freed as many times
+// as its reference counter says.
+func RefcntUpdateMbuf(mb *Mbuf, value int16) {
+	C.refcntUpdateMbuf((*C.struct_rte_mbuf)(mb), C.int16_t(value))
+}
+
+// GetRefcntMbuf returns reference counter of mbuf or of mbuf which
+// owns data of cloned mbuf.
+func GetRefcntMbuf(mb *Mbuf) uint16 {
+	return uint16(C.refcntReadMbuf((*C.struct_rte_mbuf)(mb)))
+}
+
 // DirectSend sends one mbuf.
 func DirectSend(m *Mbuf, port uint16) bool {
 	return bool(C.directSend((*C.struct_rte_mbuf)(m), C.uint16_t(port)))
 }
 
+// DirectReceive receives up to len(buf) mbufs from given queue of port.
+func DirectReceive(port uint16, queue uint16, buf []uintptr) uint {
+	return uint(C.directReceive(C.uint16_t(port), C.uint16_t(queue), (**C.struct_rte_mbuf)(unsafe.Pointer(&(buf[0]))), C.uint16_t(len(buf))))
+}
+
 // Ring is a ring buffer for pointers
 type Ring C.struct_nff_go_ring
 type Rings []*Ring
@@ -93,6 +141,34 @@ func GetPortMACAddress(port uint16) [types.EtherAddrLen]uint8 {
 	return mac
 }
 
+// AddPortMACAddress adds MAC address to addresses for which port
+// receives packets in addition to its own address.
+func AddPortMACAddress(port uint16, mac [types.EtherAddrLen]uint8) error {
+	var cmac C.struct_rte_ether_addr
+	for i := range mac {
+		cmac.addr_bytes[i] = C.uint8_t(mac[i])
+	}
+	if ret := C.rte_eth_dev_mac_addr_add(C.uint16_t(port), &cmac, 0); ret < 0 {
+		msg := common.LogError(common.Debug, "AddPortMACAddress cannot add address to port", port, "error", ret)
+		return common.WrapWithNFError(nil, msg, common.WrongPort)
+	}
+	return nil
+}
+
+// RemovePortMACAddress removes MAC address which was added by
+// AddPortMACAddress.
+func RemovePortMACAddress(port uint16, mac [types.EtherAddrLen]uint8) error {
+	var cmac C.struct_rte_ether_addr
+	for i := range mac {
+		cmac.addr_bytes[i] = C.uint8_t(mac[i])
+	}
+	if ret := C.rte_eth_dev_mac_addr_remove(C.uint16_t(port), &cmac); ret < 0 {
+		msg := common.LogError(common.Debug, "RemovePortMACAddress cannot remove address from port", port, "error", ret)
+		return common.WrapWithNFError(nil, msg, common.WrongPort)
+	}
+	return nil
+}
+
 // GetPortByName gets the port id from device name. The device name should be
 // specified as below:
 //
@@ -115,6 +191,52 @@ func GetPortByName(name string) (uint16, error) {
 	}
 }
 
+// AddVdev creates virtual device with given DPDK driver arguments
+// after EAL initialization, for example net_vhost0 device with
+// "iface=/tmp/sock0" arguments, and returns its port.
+func AddVdev(name, args string) (uint16, error) {
+	var port C.uint16_t
+	if ret := C.add_vdev(C.CString(name), C.CString(args), &port); ret < 0 {
+		msg := common.LogError(common.Debug, "AddVdev cannot create device", name, "with arguments", args, "error", ret)
+		return 0, common.WrapWithNFError(nil, msg, common.BadArgument)
+	}
+	return uint16(port), nil
+}
+
+// AttachPort probes device at runtime and returns its port. Device is
+// specified by DPDK device arguments, for example PCI address
+// 0000:03:00.0 or virtual device net_vhost1,iface=/tmp/sock1.
+func AttachPort(devargs string) (uint16, error) {
+	var port C.uint16_t
+	cdevargs := C.CString(devargs)
+	defer C.free(unsafe.Pointer(cdevargs))
+	cname := C.CString(strings.SplitN(devargs, ",", 2)[0])
+	defer C.free(unsafe.Pointer(cname))
+	if ret := C.attach_port(cdevargs, cname, &port); ret < 0 {
+		msg := common.LogError(common.Debug, "AttachPort cannot attach device", devargs, "error", ret)
+		return 0, common.WrapWithNFError(nil, msg, common.BadArgument)
+	}
+	return uint16(port), nil
+}
+
+// DetachPort stops and closes port and removes its device, so that
+// NIC can be unplugged or virtual device is destroyed.
+func DetachPort(port uint16) error {
+	if ret := C.detach_port(C.uint16_t(port)); ret < 0 {
+		msg := common.LogError(common.Debug, "DetachPort cannot detach port", port, "error", ret)
+		return common.WrapWithNFError(nil, msg, common.WrongPort)
+	}
+	return nil
+}
+
+// GetPortLinkStatus returns true if link of port is up and link
+// speed in Mbps. It doesn't wait for completion of autonegotiation.
+func GetPortLinkStatus(port uint16) (bool, uint32) {
+	var speed C.uint32_t
+	up := C.port_link_status(C.uint16_t(port), &speed)
+	return up != 0, uint32(speed)
+}
+
 // GetNameByPort gets the device name from port id. The device name is specified as below:
 //
 // - PCIe address (Domain:Bus:Device.Function), for example- 0000:02:00.0
@@ -143,10 +265,12 @@ func GetPacketDataStartPointer(mb *Mbuf) uintptr {
 
 var packetStructSize int
 
-// SetPacketStructSize sets the size of the packet.
-func SetPacketStructSize(t int) error {
-	if t > C.RTE_PKTMBUF_HEADROOM {
-		msg := common.LogError(common.Initialization, "Packet structure can't be placed inside mbuf.",
+// SetPacketStructSize sets the size of the packet. Headroom which is
+// left after packet structure should be at least minHeadroom bytes.
+func SetPacketStructSize(t int, minHeadroom int) error {
+	if t+minHeadroom > C.RTE_PKTMBUF_HEADROOM {
+		msg := common.LogError(common.Initialization, "Packet structure and", minHeadroom,
+			"bytes for prepended headers can't be placed inside mbuf headroom.",
 			"Increase CONFIG_RTE_PKTMBUF_HEADROOM in dpdk/config/common_base and rebuild dpdk.")
 		return common.WrapWithNFError(nil, msg, common.PktMbufHeadRoomTooSmall)
 	}
@@ -163,7 +287,7 @@ func SetPacketStructSize(t int) error {
 // TODO 4 following functions support only not chained mbufs now
 // Heavily based on DPDK rte_pktmbuf_prepend
 func PrependMbuf(mb *Mbuf, length uint) bool {
-	if C.uint16_t(length) > mb.data_off-C.uint16_t(packetStructSize) {
+	if length+uint(packetStructSize) > uint(mb.data_off) {
 		return false
 	}
 	mb.data_off -= C.uint16_t(length)
@@ -175,7 +299,7 @@ func PrependMbuf(mb *Mbuf, length uint) bool {
 // AppendMbuf appends length bytes to mbuf.
 // Heavily based on DPDK rte_pktmbuf_append
 func AppendMbuf(mb *Mbuf, length uint) bool {
-	if C.uint16_t(length) > mb.buf_len-mb.data_off-mb.data_len {
+	if length > uint(mb.buf_len-mb.data_off-mb.data_len) {
 		return false
 	}
 	mb.data_len += C.uint16_t(length)
@@ -186,7 +310,7 @@ func AppendMbuf(mb *Mbuf, length uint) bool {
 // AdjMbuf removes length bytes at mbuf beginning.
 // Heavily based on DPDK rte_pktmbuf_adj
 func AdjMbuf(m *Mbuf, length uint) bool {
-	if C.uint16_t(length) > m.data_len {
+	if length > uint(m.data_len) {
 		return false
 	}
 	m.data_off += C.uint16_t(length)
@@ -198,7 +322,7 @@ func AdjMbuf(m *Mbuf, length uint) bool {
 // TrimMbuf removes length bytes at the mbuf end.
 // Heavily based on DPDK rte_pktmbuf_trim
 func TrimMbuf(m *Mbuf, length uint) bool {
-	if C.uint16_t(length) > m.data_len {
+	if length > uint(m.data_len) {
 		return false
 	}
 	m.data_len -= C.uint16_t(length)
@@ -258,6 +382,67 @@ func SetTXIPv6TCPOLFlags(mb *Mbuf, l2len, l3len uint32) {
 	setMbufLen(mb, l2len, l3len)
 }
 
+// SetTXIPv6OLFlags sets mbuf flags for IPv6 packet without checksum
+// calculation hardware offloading of L4 header.
+func SetTXIPv6OLFlags(mb *Mbuf, l2len, l3len uint32) {
+	// PKT_TX_IPV6
+	mb.ol_flags = 1 << 56
+	setMbufLen(mb, l2len, l3len)
+}
+
+// Types of tunnels for checksum calculation hardware offloading
+const (
+	TXTunnelVXLAN  = 1
+	TXTunnelGRE    = 2
+	TXTunnelGeneve = 4
+)
+
+// SetTXOuterOLFlags adds mbuf flags for outer IPv4 header and outer
+// UDP checksum calculation hardware offloading of tunnel packet to
+// flags of encapsulated packet. Flags of encapsulated packet should
+// be set before, their L2 length should include outer L4 header,
+// tunnel header and inner L2 header.
+func SetTXOuterOLFlags(mb *Mbuf, tunnel uint64, outerIPv4, outerUDP bool, outerL2len, outerL3len uint32) {
+	// PKT_TX_TUNNEL_*
+	flags := tunnel << 45
+	if outerIPv4 {
+		// PKT_TX_OUTER_IP_CKSUM | PKT_TX_OUTER_IPV4
+		flags |= 1<<58 | 1<<59
+	} else {
+		// PKT_TX_OUTER_IPV6
+		flags |= 1 << 60
+	}
+	if outerUDP {
+		// PKT_TX_OUTER_UDP_CKSUM
+		flags |= 1 << 41
+	}
+	mb.ol_flags |= C.uint64_t(flags)
+	// Assign outer_l3_len:9 and outer_l2_len:7 fields in rte_mbuf
+	mb.anon5[5] = uint8(outerL3len)
+	mb.anon5[6] = uint8((outerL3len>>8)&1) | uint8(outerL2len<<1)
+}
+
+// SetTXTCPSegOLFlags sets mbuf flags and lengths of headers for TCP
+// segmentation offloading. Packet is split into segments with segSize
+// bytes of TCP data.
+func SetTXTCPSegOLFlags(mb *Mbuf, ipv4 bool, l2len, l3len, l4len, segSize uint32) {
+	// PKT_TX_TCP_SEG | PKT_TX_TCP_CKSUM
+	flags := uint64(1<<50 | 1<<52)
+	if ipv4 {
+		// PKT_TX_IP_CKSUM | PKT_TX_IPV4
+		flags |= 1<<54 | 1<<55
+	} else {
+		// PKT_TX_IPV6
+		flags |= 1 << 56
+	}
+	mb.ol_flags = C.uint64_t(flags)
+	// Assign l2_len:7, l3_len:9, l4_len:8 and tso_segsz:16 fields in rte_mbuf
+	offload := uint64(l2len&0x7f) | uint64(l3len&0x1ff)<<7 | uint64(l4len&0xff)<<16 | uint64(segSize&0xffff)<<24
+	for i := range mb.anon5 {
+		mb.anon5[i] = uint8(offload >> (8 * uint(i)))
+	}
+}
+
 // These constants are used by packet package to parse protocol headers
 const (
 	RtePtypeL2Ether = C.RTE_PTYPE_L2_ETHER
@@ -279,20 +464,54 @@ func LookupRing(name string) *Ring {
 	return (*Ring)(unsafe.Pointer(C.nff_go_ring_lookup(C.CString(name))))
 }
 
+// LookupOrCreateRing returns ring with given name creating it if it
+// doesn't exist yet. Named rings are visible to all DPDK processes
+// which share memory with primary process, so they can be used to
+// exchange packets between processes. Returns nil if ring doesn't
+// exist and can't be created.
+func LookupOrCreateRing(name string, count uint, socket int) *Ring {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	if ring := C.nff_go_ring_lookup(cname); ring != nil {
+		return (*Ring)(unsafe.Pointer(ring))
+	}
+	ring := C.nff_go_ring_create(cname, C.uint(count), C.int(socket), 0x0000)
+	if ring.DPDK_ring == nil {
+		C.free(unsafe.Pointer(ring))
+		return nil
+	}
+	return (*Ring)(unsafe.Pointer(ring))
+}
+
+// AnySocket means that memory can be allocated on any NUMA socket.
+const AnySocket = -1
+
 // CreateRing creates ring with given name and count.
 func CreateRing(count uint) *Ring {
-	name := strconv.Itoa(ringName)
+	return CreateRingOnSocket(count, AnySocket)
+}
+
+// CreateRingOnSocket creates ring with given count in memory of given
+// NUMA socket.
+func CreateRingOnSocket(count uint, socket int) *Ring {
+	name := objectPrefix + strconv.Itoa(ringName)
 	ringName++
 
 	// Flag 0x0000 means ring default mode which is Multiple Consumer / Multiple Producer
-	return (*Ring)(unsafe.Pointer(C.nff_go_ring_create(C.CString(name), C.uint(count), C.SOCKET_ID_ANY, 0x0000)))
+	return (*Ring)(unsafe.Pointer(C.nff_go_ring_create(C.CString(name), C.uint(count), C.int(socket), 0x0000)))
 }
 
 // CreateRings creates ring with given name and count.
 func CreateRings(count uint, inIndexNumber int32) Rings {
+	return CreateRingsOnSocket(count, inIndexNumber, AnySocket)
+}
+
+// CreateRingsOnSocket creates inIndexNumber rings with given count in
+// memory of given NUMA socket.
+func CreateRingsOnSocket(count uint, inIndexNumber int32, socket int) Rings {
 	rings := make(Rings, inIndexNumber, inIndexNumber)
 	for i := int32(0); i < inIndexNumber; i++ {
-		rings[i] = CreateRing(count)
+		rings[i] = CreateRingOnSocket(count, socket)
 	}
 	return rings
 }
@@ -499,13 +718,20 @@ func (ring *Ring) GetRingCount() uint32 {
 	return uint32((ring.DPDK_ring.prod.tail - ring.DPDK_ring.cons.tail) & ring.DPDK_ring.mask)
 }
 
-// ReceiveRSS - get packets from port and enqueue on a Ring.
-func ReceiveRSS(port uint16, inIndex []int32, OUT Rings, flag *int32, coreID int, race *int32, stats *common.RXTXStats) {
+// GetRingCapacity gets maximum number of objects in ring.
+func (ring *Ring) GetRingCapacity() uint32 {
+	return uint32(ring.DPDK_ring.capacity)
+}
+
+// ReceiveRSS - get packets from port and enqueue on a Ring. Port
+// isn't polled while pause is not zero.
+func ReceiveRSS(port uint16, inIndex []int32, OUT Rings, flag *int32, coreID int, race *int32, pause *int32, stats *common.RXTXStats) {
 	if C.rte_eth_dev_socket_id(C.uint16_t(port)) != C.int(C.rte_lcore_to_socket_id(C.uint(coreID))) {
 		common.LogWarning(common.Initialization, "Receive port", port, "is on remote NUMA node to polling thread - not optimal performance.")
 	}
 	C.receiveRSS(C.uint16_t(port), (*C.int32_t)(unsafe.Pointer(&(inIndex[0]))), C.extractDPDKRings((**C.struct_nff_go_ring)(unsafe.Pointer(&(OUT[0]))), C.int32_t(len(OUT))),
-		(*C.int)(unsafe.Pointer(flag)), C.int(coreID), (*C.int)(unsafe.Pointer(race)), (*C.RXTXStats)(unsafe.Pointer(stats)))
+		(*C.int)(unsafe.Pointer(flag)), C.int(coreID), (*C.int)(unsafe.Pointer(race)), (*C.int)(unsafe.Pointer(pause)),
+		(*C.RXTXStats)(unsafe.Pointer(stats)))
 }
 
 func SrKNI(port uint16, flag *int32, coreID int, recv bool, OUT Rings, send bool, IN Rings, stats *common.RXTXStats) {
@@ -537,6 +763,19 @@ func Send(port uint16, IN Rings, unrestrictedClones bool, flag *int32, coreID in
 		C.int32_t(totalSendTreads))
 }
 
+// SendQueue - dequeue packets from all rings and send them to one
+// TX queue of port.
+func SendQueue(port, queue uint16, IN Rings, flag *int32, coreID int, stats *common.RXTXStats) {
+	if C.rte_eth_dev_socket_id(C.uint16_t(port)) != C.int(C.rte_lcore_to_socket_id(C.uint(coreID))) {
+		common.LogWarning(common.Initialization, "Send port", port, "is on remote NUMA node to polling thread - not optimal performance.")
+	}
+	C.nff_go_send_queue(C.uint16_t(port), C.uint16_t(queue),
+		C.extractDPDKRings((**C.struct_nff_go_ring)(unsafe.Pointer(&(IN[0]))), C.int32_t(len(IN))),
+		C.int32_t(len(IN)),
+		(*C.int)(unsafe.Pointer(flag)), C.int(coreID),
+		(*C.RXTXStats)(unsafe.Pointer(stats)))
+}
+
 // Stop - dequeue and free packets.
 func Stop(IN Rings, flag *int32, coreID int, stats *common.RXTXStats) {
 	C.nff_go_stop(C.extractDPDKRings((**C.struct_nff_go_ring)(unsafe.Pointer(&(IN[0]))), C.int32_t(len(IN))), C.int(len(IN)), (*C.int)(unsafe.Pointer(flag)), C.int(coreID), (*C.RXTXStats)(unsafe.Pointer(stats)))
@@ -574,6 +813,21 @@ func InitDPDK(argc C.int, argv **C.char, burstSize uint, mbufNumber uint, mbufCa
 	return nil
 }
 
+// SetIdleSleep enables power-saving idle mode of receivers from ports
+// and senders to ports. After a series of empty polls they sleep with
+// exponentially growing time up to maxSleep. Zero maxSleep means busy
+// polling.
+func SetIdleSleep(maxSleep time.Duration) {
+	C.IDLE_MAX_SLEEP = C.uint32_t(maxSleep / time.Microsecond)
+}
+
+// SetL1ByteAccounting enables counting of frame check sequence,
+// padding, preamble and interframe gap in byte counters of receivers
+// and senders.
+func SetL1ByteAccounting(enabled bool) {
+	C.l1_byte_accounting = C.bool(enabled)
+}
+
 func StopDPDK() {
 	C.rte_eal_cleanup()
 }
@@ -609,26 +863,201 @@ func CheckPortMaxTXQueues(port uint16) int32 {
 	return int32(C.check_max_port_tx_queues(C.uint16_t(port)))
 }
 
+// CheckPortTSO returns true if port supports TCP segmentation offload.
+func CheckPortTSO(port uint16) bool {
+	return bool(C.check_port_tso(C.uint16_t(port)))
+}
+
+// CheckPortMaxRxPktLen returns length of the longest frame which can be
+// received by port.
+func CheckPortMaxRxPktLen(port uint16) uint {
+	return uint(C.check_max_port_rx_pkt_len(C.uint16_t(port)))
+}
+
+// GetPortMTU returns current MTU of port.
+func GetPortMTU(port uint16) (uint16, error) {
+	var mtu C.uint16_t
+	if ret := C.rte_eth_dev_get_mtu(C.uint16_t(port), &mtu); ret != 0 {
+		msg := common.LogError(common.Debug, "Cannot get MTU of port", port, ", dpdk returned:", ret)
+		return 0, common.WrapWithNFError(nil, msg, common.BadArgument)
+	}
+	return uint16(mtu), nil
+}
+
+// MaxFrameLen returns length of the longest frame of port with given
+// MTU. Returns 0 if MTU is 0.
+func MaxFrameLen(mtu uint16) uint {
+	if mtu == 0 {
+		return 0
+	}
+	return uint(mtu) + FrameOverhead
+}
+
+// FrameOverhead is a number of bytes which are added to MTU to get
+// length of the longest frame: Ethernet header, two VLAN tags and CRC.
+const FrameOverhead = types.EtherLen + 2*types.VLANLen + 4
+
+// Maximum size of mbuf data room, data_room_size of DPDK is 16 bits
+const maxMbufBufSize = 0xffff - C.RTE_PKTMBUF_HEADROOM
+
+var mbufBufSize uint
+
+// SetMbufBufSize sets minimal size of data of mbufs in memory pools
+// which are created after this call, so that frames of given length
+// fit into one mbuf. Size isn't decreased below default size.
+func SetMbufBufSize(frameLen uint) error {
+	if frameLen > maxMbufBufSize {
+		return common.WrapWithNFError(nil, fmt.Sprintf("Frames longer than %d bytes don't fit into mbuf", maxMbufBufSize), common.BadArgument)
+	}
+	if frameLen > mbufBufSize {
+		mbufBufSize = frameLen
+	}
+	return nil
+}
+
+// Hash fields of receive side scaling
+const (
+	RSSIP        = uint64(C.ETH_RSS_IP)
+	RSSTCP       = uint64(C.ETH_RSS_TCP)
+	RSSUDP       = uint64(C.ETH_RSS_UDP)
+	RSSSCTP      = uint64(C.ETH_RSS_SCTP)
+	RSSL2Payload = uint64(C.ETH_RSS_L2_PAYLOAD)
+)
+
+// Maximum sizes of RSS hash key and redirection table
+const (
+	maxRSSKeySize = 255
+	maxRETASize   = C.APP_RETA_SIZE_MAX * C.RTE_RETA_GROUP_SIZE
+)
+
+// RSSConf is a configuration of receive side scaling of port. Empty
+// Key, zero HashFields and empty RETA mean defaults of NIC.
+type RSSConf struct {
+	Key        []byte
+	HashFields uint64
+	RETA       []uint16
+}
+
+// GetPortRSS returns RSS configuration of started port.
+func GetPortRSS(port uint16) (RSSConf, error) {
+	key := make([]byte, maxRSSKeySize)
+	reta := make([]uint16, maxRETASize)
+	keyLen := C.uint8_t(len(key))
+	retaSize := C.uint16_t(len(reta))
+	var hashFields C.uint64_t
+	if C.port_get_rss(C.uint16_t(port), (*C.uint8_t)(unsafe.Pointer(&key[0])), &keyLen, &hashFields,
+		(*C.uint16_t)(unsafe.Pointer(&reta[0])), &retaSize) != 0 {
+		return RSSConf{}, common.WrapWithNFError(nil, fmt.Sprintf("Can't get RSS configuration of port %d", port), common.FailToInitPort)
+	}
+	return RSSConf{Key: key[:keyLen], HashFields: uint64(hashFields), RETA: reta[:retaSize]}, nil
+}
+
+// HWRule is a hardware rule of NIC. Addresses and ports are in
+// network byte order, zero masks match any value.
+type HWRule struct {
+	SrcAddr, SrcMask, DstAddr, DstMask         uint32
+	SrcPort, SrcPortMask, DstPort, DstPortMask uint16
+	Proto                                      uint8
+	Priority                                   uint32
+	Drop                                       bool
+	Queue                                      uint16
+}
+
+// HWFlow is a rule installed to NIC.
+type HWFlow C.struct_rte_flow
+
+// CreateHWRule installs rule to NIC of port. If validate is true,
+// rule is only checked by driver and nil is returned.
+func CreateHWRule(port uint16, rule *HWRule, validate bool) (*HWFlow, error) {
+	r := C.struct_nff_go_hw_rule{
+		src_addr:      C.uint32_t(rule.SrcAddr),
+		src_mask:      C.uint32_t(rule.SrcMask),
+		dst_addr:      C.uint32_t(rule.DstAddr),
+		dst_mask:      C.uint32_t(rule.DstMask),
+		src_port:      C.uint16_t(rule.SrcPort),
+		src_port_mask: C.uint16_t(rule.SrcPortMask),
+		dst_port:      C.uint16_t(rule.DstPort),
+		dst_port_mask: C.uint16_t(rule.DstPortMask),
+		proto:         C.uint8_t(rule.Proto),
+		priority:      C.uint32_t(rule.Priority),
+		drop:          C.bool(rule.Drop),
+		queue:         C.uint16_t(rule.Queue),
+	}
+	var ret C.int
+	var message *C.char
+	flow := C.create_hw_rule(C.uint16_t(port), &r, C.bool(validate), &ret, &message)
+	if ret != 0 {
+		msg := fmt.Sprintf("Hardware rule is rejected by port %d", port)
+		if message != nil {
+			msg += ": " + C.GoString(message)
+		}
+		return nil, common.WrapWithNFError(nil, msg, common.BadArgument)
+	}
+	return (*HWFlow)(flow), nil
+}
+
+// DestroyHWRule removes rule from NIC of port.
+func DestroyHWRule(port uint16, flow *HWFlow) error {
+	var flowError C.struct_rte_flow_error
+	if C.rte_flow_destroy(C.uint16_t(port), (*C.struct_rte_flow)(flow), &flowError) != 0 {
+		return common.WrapWithNFError(nil, fmt.Sprintf("Can't remove hardware rule of port %d", port), common.Fail)
+	}
+	return nil
+}
+
+// FlushHWRules removes all rules from NIC of port.
+func FlushHWRules(port uint16) error {
+	var flowError C.struct_rte_flow_error
+	if C.rte_flow_flush(C.uint16_t(port), &flowError) != 0 {
+		return common.WrapWithNFError(nil, fmt.Sprintf("Can't remove hardware rules of port %d", port), common.Fail)
+	}
+	return nil
+}
+
 // CreatePort initializes a new port using global settings and parameters.
 func CreatePort(port uint16, willReceive bool, promiscuous bool, hwtxchecksum,
-	hwrxpacketstimestamp bool, inIndex int32, tXQueuesNumberPerPort int) error {
+	hwrxpacketstimestamp bool, inIndex int32, tXQueuesNumberPerPort int, rss *RSSConf, mtu uint16, tso bool) error {
 	var mempools **C.struct_rte_mempool
 	if willReceive {
-		m := CreateMempools("receive", inIndex)
+		m := CreateMempoolsOnSocket("receive", inIndex, GetPortSocket(port))
 		mempools = (**C.struct_rte_mempool)(unsafe.Pointer(&(m[0])))
 	} else {
 		mempools = nil
 	}
+	if rss == nil {
+		rss = new(RSSConf)
+	}
+	var key *C.uint8_t
+	var reta *C.uint16_t
+	if len(rss.Key) > maxRSSKeySize {
+		return common.WrapWithNFError(nil, fmt.Sprintf("RSS hash key of port %d is too long", port), common.BadArgument)
+	}
+	if len(rss.Key) != 0 {
+		key = (*C.uint8_t)(unsafe.Pointer(&rss.Key[0]))
+	}
+	if len(rss.RETA) != 0 {
+		reta = (*C.uint16_t)(unsafe.Pointer(&rss.RETA[0]))
+	}
 	if C.port_init(C.uint16_t(port), C.bool(willReceive), mempools,
-		C._Bool(promiscuous), C._Bool(hwtxchecksum), C._Bool(hwrxpacketstimestamp), C.int32_t(inIndex), C.int32_t(tXQueuesNumberPerPort)) != 0 {
+		C._Bool(promiscuous), C._Bool(hwtxchecksum), C._Bool(hwrxpacketstimestamp), C.int32_t(inIndex), C.int32_t(tXQueuesNumberPerPort),
+		key, C.uint8_t(len(rss.Key)), C.uint64_t(rss.HashFields), reta, C.uint16_t(len(rss.RETA)),
+		C.uint16_t(mtu), C.uint32_t(MaxFrameLen(mtu)), C.bool(tso)) != 0 {
 		msg := common.LogError(common.Initialization, "Cannot init port ", port, "!")
 		return common.WrapWithNFError(nil, msg, common.FailToInitPort)
 	}
 	return nil
 }
 
-// CreateMempool creates and returns a new memory pool.
+// CreateMempool creates and returns a new memory pool on NUMA socket
+// of calling thread.
 func CreateMempool(name string) *Mempool {
+	return CreateMempoolOnSocket(name, AnySocket)
+}
+
+// CreateMempoolOnSocket creates and returns a new memory pool on given
+// NUMA socket. If socket is AnySocket, memory pool is created on NUMA
+// socket of calling thread.
+func CreateMempoolOnSocket(name string, socket int) *Mempool {
 	nameC := 1
 	tName := name
 	for i := range usedMempools {
@@ -637,19 +1066,40 @@ func CreateMempool(name string) *Mempool {
 			nameC++
 		}
 	}
-	mempool := C.createMempool(C.uint32_t(mbufNumberT), C.uint32_t(mbufCacheSizeT))
+	mempool := C.createMempool(C.uint32_t(mbufNumberT), C.uint32_t(mbufCacheSizeT), C.int(socket), C.uint32_t(mbufBufSize))
 	usedMempools = append(usedMempools, mempoolPair{mempool, tName})
 	return (*Mempool)(mempool)
 }
 
 func CreateMempools(name string, inIndex int32) []*Mempool {
+	return CreateMempoolsOnSocket(name, inIndex, AnySocket)
+}
+
+// CreateMempoolsOnSocket creates inIndex memory pools on given NUMA
+// socket.
+func CreateMempoolsOnSocket(name string, inIndex int32, socket int) []*Mempool {
 	m := make([]*Mempool, inIndex, inIndex)
 	for i := int32(0); i < inIndex; i++ {
-		m[i] = CreateMempool(name)
+		m[i] = CreateMempoolOnSocket(name, socket)
 	}
 	return m
 }
 
+// GetPortSocket returns NUMA socket of port or AnySocket if it can't
+// be determined.
+func GetPortSocket(port uint16) int {
+	socket := int(C.rte_eth_dev_socket_id(C.uint16_t(port)))
+	if socket < 0 {
+		return AnySocket
+	}
+	return socket
+}
+
+// GetCoreSocket returns NUMA socket of CPU core.
+func GetCoreSocket(coreID int) int {
+	return int(C.rte_lcore_to_socket_id(C.uint(coreID)))
+}
+
 // SetAffinity sets cpu affinity mask.
 func SetAffinity(coreID int) error {
 	// go tool trace shows that each proc executes different goroutine. However it is expected behavior
@@ -708,6 +1158,61 @@ func GetDataLenMbuf(mb *Mbuf) uint {
 	return uint(mb.data_len)
 }
 
+// GetSegsNumberMbuf returns number of segments in a given chain of Mbufs
+func GetSegsNumberMbuf(mb *Mbuf) uint {
+	return uint(mb.nb_segs)
+}
+
+// GetTailroomMbuf returns number of bytes which can be appended to a given Mbuf
+func GetTailroomMbuf(mb *Mbuf) uint {
+	return uint(mb.buf_len - mb.data_off - mb.data_len)
+}
+
+// Maximum number of segments in a chain of Mbufs, it is equal to DPDK RTE_MBUF_MAX_NB_SEGS
+const maxSegsNumber = 0xffff
+
+// ChainMbuf appends chain of tail Mbufs to the last segment of head chain.
+// Heavily based on DPDK rte_pktmbuf_chain
+func ChainMbuf(head *Mbuf, tail *Mbuf) bool {
+	if uint(head.nb_segs)+uint(tail.nb_segs) > maxSegsNumber {
+		return false
+	}
+	last := head
+	for last.next != nil {
+		last = (*Mbuf)(last.next)
+	}
+	last.next = (*C.struct_rte_mbuf)(tail)
+	head.nb_segs += tail.nb_segs
+	head.pkt_len += tail.pkt_len
+	// pkt_len is valid only in the first segment
+	tail.pkt_len = C.uint32_t(tail.data_len)
+	return true
+}
+
+// DetachChainMbuf detaches following segments from the first segment of
+// a given chain of Mbufs and returns them as a separate chain.
+func DetachChainMbuf(mb *Mbuf) *Mbuf {
+	next := (*Mbuf)(mb.next)
+	if next != nil {
+		next.nb_segs = mb.nb_segs - 1
+		next.pkt_len = mb.pkt_len - C.uint32_t(mb.data_len)
+	}
+	mb.next = nil
+	mb.nb_segs = 1
+	mb.pkt_len = C.uint32_t(mb.data_len)
+	return next
+}
+
+// GetSeqnMbuf returns sequence number of a given Mbuf
+func GetSeqnMbuf(mb *Mbuf) uint32 {
+	return uint32(mb.seqn)
+}
+
+// SetSeqnMbuf sets sequence number of a given Mbuf
+func SetSeqnMbuf(mb *Mbuf, seqn uint32) {
+	mb.seqn = C.uint32_t(seqn)
+}
+
 // Statistics print statistics about current
 // speed of stop ring, recv/send speed and drops.
 func Statistics(N float32) {
@@ -730,9 +1235,22 @@ func ReportMempoolsState() {
 	}
 }
 
+// GetMempoolsFree returns the smallest fraction of free mbufs among
+// used mempools. It returns 1 if no mempools are used.
+func GetMempoolsFree() float64 {
+	free := 1.0
+	for _, m := range usedMempools {
+		use := uint(C.getMempoolSpace(m.mempool))
+		if f := float64(mbufNumberT-use) / float64(mbufNumberT); f < free {
+			free = f
+		}
+	}
+	return free
+}
+
 // CreateKni creates a KNI device
 func CreateKni(portId uint16, core uint, name string) error {
-	mempool := (*C.struct_rte_mempool)(CreateMempool("KNI"))
+	mempool := (*C.struct_rte_mempool)(CreateMempoolOnSocket("KNI", GetPortSocket(portId)))
 	if C.create_kni(C.uint16_t(portId), C.uint32_t(core), C.CString(name), mempool) != 0 {
 		return common.WrapWithNFError(nil, "Error with KNI allocation\n", common.FailToCreateKNI)
 	}
@@ -778,6 +1296,12 @@ func CheckHWTXChecksumCapability(port uint16) bool {
 	return bool(C.check_hwtxchecksum_capability(C.uint16_t(port)))
 }
 
+// CheckHWTXTunnelChecksumCapability returns true if port can
+// calculate outer IPv4 and UDP checksums of tunnel packets.
+func CheckHWTXTunnelChecksumCapability(port uint16) bool {
+	return bool(C.check_hwtxtunnelchecksum_capability(C.uint16_t(port)))
+}
+
 func CheckHWRXPacketsTimestamp(port uint16) bool {
 	return bool(C.check_hwrxpackets_timestamp_capability(C.uint16_t(port)))
 }
@@ -786,14 +1310,41 @@ func InitDevice(device string) int {
 	return int(C.initDevice(C.CString(device)))
 }
 
-func ReceiveOS(socket int, OUT *Ring, flag *int32, coreID int, stats *common.RXTXStats) {
+// InitTapDevice creates Linux tap device or tun device if tun is true
+// and sets it up. Returns file descriptor of device or -1 if device
+// can't be created. Device is removed when application exits.
+func InitTapDevice(device string, tun bool) int {
+	return int(C.initTapDevice(C.CString(device), C.bool(tun)))
+}
+
+// ReceiveOS receives packets from raw socket or from tap or tun device
+// file. If l3 is true, packets don't have Ethernet header and it is
+// added.
+func ReceiveOS(socket int, l3 bool, OUT *Ring, flag *int32, coreID int, stats *common.RXTXStats) {
 	m := CreateMempool("receiveOS")
-	C.receiveOS(C.int(socket), OUT.DPDK_ring, (*C.struct_rte_mempool)(unsafe.Pointer(m)),
+	C.receiveOS(C.int(socket), C.bool(l3), OUT.DPDK_ring, (*C.struct_rte_mempool)(unsafe.Pointer(m)),
 		(*C.int)(unsafe.Pointer(flag)), C.int(coreID), (*C.RXTXStats)(unsafe.Pointer(stats)))
 }
 
-func SendOS(socket int, IN Rings, flag *int32, coreID int, stats *common.RXTXStats) {
-	C.sendOS(C.int(socket), C.extractDPDKRings((**C.struct_nff_go_ring)(unsafe.Pointer(&(IN[0]))),
+// SendOS sends packets to raw socket or to tap or tun device file. If
+// l3 is true, Ethernet header is removed before sending.
+func SendOS(socket int, l3 bool, IN Rings, flag *int32, coreID int, stats *common.RXTXStats) {
+	C.sendOS(C.int(socket), C.bool(l3), C.extractDPDKRings((**C.struct_nff_go_ring)(unsafe.Pointer(&(IN[0]))),
+		C.int32_t(len(IN))), C.int32_t(len(IN)), (*C.int)(unsafe.Pointer(flag)), C.int(coreID),
+		(*C.RXTXStats)(unsafe.Pointer(stats)))
+}
+
+// ReceiveRing moves packets from ring shared with other process to
+// flow graph.
+func ReceiveRing(shared *Ring, OUT *Ring, flag *int32, coreID int, stats *common.RXTXStats) {
+	C.receiveRing(shared.DPDK_ring, OUT.DPDK_ring, (*C.int)(unsafe.Pointer(flag)), C.int(coreID),
+		(*C.RXTXStats)(unsafe.Pointer(stats)))
+}
+
+// SendRing moves packets from flow graph to ring shared with other
+// process.
+func SendRing(shared *Ring, IN Rings, flag *int32, coreID int, stats *common.RXTXStats) {
+	C.sendRing(shared.DPDK_ring, C.extractDPDKRings((**C.struct_nff_go_ring)(unsafe.Pointer(&(IN[0]))),
 		C.int32_t(len(IN))), C.int32_t(len(IN)), (*C.int)(unsafe.Pointer(flag)), C.int(coreID),
 		(*C.RXTXStats)(unsafe.Pointer(stats)))
 }
@@ -9,16 +9,20 @@
 #include <assert.h>
 #include <rte_eal.h>
 #include <rte_ethdev.h>
+#include <rte_dev.h>
 #include <rte_mbuf.h>
 #include <rte_ring.h>
 #include <unistd.h>
 #include <stdbool.h>
 
 #include <rte_cycles.h>
+#include <rte_pause.h>
 #include <rte_ip_frag.h>
 #include <rte_bus_pci.h>
 #include <rte_kni.h>
 #include <rte_lpm.h>
+#include <rte_flow.h>
+#include <rte_errno.h>
 
 #include <sys/socket.h>
 #include <linux/if_ether.h>     // ETH_P_ALL
@@ -29,6 +33,9 @@
 #include <sys/ioctl.h>          // ioctl
 #include <net/if.h>             // ifreq
 #include <netpacket/packet.h>   // sockaddr_ll
+#include <linux/if_tun.h>       // TUNSETIFF
+#include <fcntl.h>              // open
+#include <errno.h>              // errno
 
 #define process 1
 #define stopRequest 2
@@ -64,12 +71,18 @@
 // 24 offset is L2 offset and is always begining of packet
 // 32 offset is CMbuf offset and is initilized when mempool is created
 // 40 offset is Next field. Should be 0. Will be filled later if required
+// 62 offset is bit mask of set metadata fields. Should be 0.
 #define mbufInitL2(buf) \
 *(char **)((char *)(buf) + mbufStructSize + 24) = (char *)(buf) + defaultStart;
 #define mbufInitCMbuf(buf) \
 *(char **)((char *)(buf) + mbufStructSize + 32) = (char *)(buf);
 #define mbufInitNextChain(buf) \
 *(char **)((char *)(buf) + mbufStructSize + 40) = 0;
+#define mbufInitMetadata(buf) \
+*(uint16_t *)((char *)(buf) + mbufStructSize + 62) = 0;
+// 48 offset is metadata area with bit mask of set fields, 16 bytes
+#define mbufCopyMetadata(dst, src) \
+memcpy((char *)(dst) + mbufStructSize + 48, (char *)(src) + mbufStructSize + 48, 16);
 
 // Firstly we set "next" packet pointer (+40) to the packet from next mbuf
 // Secondly we know that followed mbufs don't contain L2 and L3 headers. We assume that they start with a data
@@ -142,6 +155,9 @@ bool analyze_packet_sizes = true;
 bool analyze_packet_sizes = false;
 #endif
 
+// Count FCS, padding, preamble and interframe gap in byte counters
+bool l1_byte_accounting = false;
+
 volatile long long receive_received = 0, receive_pushed = 0;
 volatile long long send_required = 0, send_sent = 0;
 volatile long long stop_freed = 0;
@@ -154,7 +170,14 @@ int mbufStructSize;
 int headroomSize;
 int defaultStart;
 bool L2CanBeChanged;
-char mempoolName[9] = "mempool1\0";
+// Prefix of names of mempools and rings. Secondary process uses its own
+// prefix because objects of primary process have the same names.
+char objectPrefix[16] = "";
+int mempoolNumber = 1;
+
+void setObjectPrefix(const char *prefix) {
+	snprintf(objectPrefix, sizeof(objectPrefix), "%s", prefix);
+}
 
 __m128 zero128 = {0, 0, 0, 0};
 #ifdef RTE_MACHINE_CPUFLAG_AVX
@@ -178,6 +201,29 @@ bool MEMORY_JUMBO;
 bool JUMBO;
 bool CHAINED;
 
+// Maximum sleep of idle polling loops in microseconds, zero means
+// busy polling
+uint32_t IDLE_MAX_SLEEP = 0;
+// Number of consecutive empty polls after which loop starts sleeping
+#define IDLE_SPIN_POLLS 256
+
+// idleBackoff is called by polling loops after every round without
+// packets. Loops spin with pause instruction first, then sleep with
+// exponentially growing time up to IDLE_MAX_SLEEP microseconds.
+static inline void idleBackoff(uint32_t *idle) {
+	if (likely(IDLE_MAX_SLEEP == 0)) {
+		return;
+	}
+	(*idle)++;
+	if (*idle < IDLE_SPIN_POLLS) {
+		rte_pause();
+		return;
+	}
+	uint32_t shift = *idle - IDLE_SPIN_POLLS;
+	uint32_t sleep = shift < 16 ? 1u << shift : IDLE_MAX_SLEEP;
+	usleep(sleep < IDLE_MAX_SLEEP ? sleep : IDLE_MAX_SLEEP);
+}
+
 struct cPort {
 	uint16_t PortId;
 	uint8_t QueuesNumber;
@@ -263,6 +309,60 @@ uint16_t check_max_port_tx_queues(uint16_t port) {
         return dev_info.max_tx_queues;
 }
 
+bool check_port_tso(uint16_t port) {
+	struct rte_eth_dev_info dev_info;
+	memset(&dev_info, 0, sizeof(dev_info));
+	rte_eth_dev_info_get(port, &dev_info);
+	return (dev_info.tx_offload_capa & DEV_TX_OFFLOAD_TCP_TSO) != 0;
+}
+
+uint32_t check_max_port_rx_pkt_len(uint16_t port) {
+	struct rte_eth_dev_info dev_info;
+	memset(&dev_info, 0, sizeof(dev_info));
+	rte_eth_dev_info_get(port, &dev_info);
+	return dev_info.max_rx_pktlen;
+}
+
+// Creates virtual device after EAL initialization and returns its port
+int add_vdev(const char *name, const char *args, uint16_t *port) {
+	int ret = rte_eal_hotplug_add("vdev", name, args);
+	if (ret < 0)
+		return ret;
+	return rte_eth_dev_get_port_by_name(name, port);
+}
+
+// Probes device with given DPDK device arguments at runtime, for
+// example PCI address or virtual device with arguments, and returns
+// its port. Device name is part of arguments before the first comma.
+int attach_port(const char *devargs, const char *name, uint16_t *port) {
+	int ret = rte_dev_probe(devargs);
+	if (ret < 0)
+		return ret;
+	return rte_eth_dev_get_port_by_name(name, port);
+}
+
+// Stops and closes port and removes its device.
+int detach_port(uint16_t port) {
+	struct rte_eth_dev_info dev_info;
+	memset(&dev_info, 0, sizeof(dev_info));
+	rte_eth_dev_info_get(port, &dev_info);
+	if (dev_info.device == NULL)
+		return -ENODEV;
+	rte_eth_dev_stop(port);
+	rte_eth_dev_close(port);
+	return rte_dev_remove(dev_info.device);
+}
+
+// Returns link status of port without waiting for completion of
+// autonegotiation. Speed is set in Mbps.
+int port_link_status(uint16_t port, uint32_t *speed) {
+	struct rte_eth_link link;
+	memset(&link, 0, sizeof(link));
+	rte_eth_link_get_nowait(port, &link);
+	*speed = link.link_speed;
+	return link.link_status == ETH_LINK_UP;
+}
+
 uint16_t check_current_port_tx_queues(uint16_t port) {
         struct rte_eth_dev_info dev_info;
         memset(&dev_info, 0, sizeof(dev_info));
@@ -272,7 +372,154 @@ uint16_t check_current_port_tx_queues(uint16_t port) {
 
 // Initializes a given port using global settings and with the RX buffers
 // coming from the mbuf_pool passed as a parameter.
-int port_init(uint16_t port, bool willReceive, struct rte_mempool **mbuf_pools, bool promiscuous, bool hwtxchecksum, bool hwrxpacketstimestamp, int32_t inIndex, int32_t tx_queues) {
+// Fills redirection table of port with given queues. Table of port is
+// filled cyclically if given table is shorter.
+static int set_port_reta(uint16_t port, uint16_t *reta, uint16_t reta_size, uint16_t dev_reta_size) {
+	struct rte_eth_rss_reta_entry64 reta_conf[APP_RETA_SIZE_MAX];
+
+	if (dev_reta_size == 0 || dev_reta_size > APP_RETA_SIZE_MAX * RTE_RETA_GROUP_SIZE) {
+		printf("Port %d has unsupported RSS redirection table size %d\n", port, dev_reta_size);
+		return -ENOTSUP;
+	}
+	memset(reta_conf, 0, sizeof(reta_conf));
+	for (uint16_t i = 0; i < dev_reta_size; i++) {
+		uint16_t idx = i / RTE_RETA_GROUP_SIZE;
+		uint16_t shift = i % RTE_RETA_GROUP_SIZE;
+		reta_conf[idx].mask |= 1ULL << shift;
+		reta_conf[idx].reta[shift] = reta[i % reta_size];
+	}
+	return rte_eth_dev_rss_reta_update(port, reta_conf, dev_reta_size);
+}
+
+// Reads RSS hash key, hash fields and redirection table of started
+// port. key_len and reta_size are sizes of buffers on input and sizes
+// of key and table on output.
+int port_get_rss(uint16_t port, uint8_t *key, uint8_t *key_len, uint64_t *rss_hf, uint16_t *reta, uint16_t *reta_size) {
+	struct rte_eth_dev_info dev_info;
+	struct rte_eth_rss_conf rss_conf;
+	struct rte_eth_rss_reta_entry64 reta_conf[APP_RETA_SIZE_MAX];
+
+	memset(&dev_info, 0, sizeof(dev_info));
+	rte_eth_dev_info_get(port, &dev_info);
+	if (dev_info.hash_key_size > *key_len || dev_info.reta_size > *reta_size ||
+		dev_info.reta_size > APP_RETA_SIZE_MAX * RTE_RETA_GROUP_SIZE) {
+		return -ENOTSUP;
+	}
+	memset(&rss_conf, 0, sizeof(rss_conf));
+	rss_conf.rss_key = key;
+	rss_conf.rss_key_len = dev_info.hash_key_size;
+	int ret = rte_eth_dev_rss_hash_conf_get(port, &rss_conf);
+	if (ret != 0)
+		return ret;
+	*key_len = rss_conf.rss_key_len;
+	*rss_hf = rss_conf.rss_hf;
+
+	memset(reta_conf, 0, sizeof(reta_conf));
+	for (uint16_t i = 0; i < dev_info.reta_size / RTE_RETA_GROUP_SIZE; i++) {
+		reta_conf[i].mask = ~0ULL;
+	}
+	ret = rte_eth_dev_rss_reta_query(port, reta_conf, dev_info.reta_size);
+	if (ret != 0)
+		return ret;
+	for (uint16_t i = 0; i < dev_info.reta_size; i++) {
+		reta[i] = reta_conf[i / RTE_RETA_GROUP_SIZE].reta[i % RTE_RETA_GROUP_SIZE];
+	}
+	*reta_size = dev_info.reta_size;
+	return 0;
+}
+
+// Hardware rule, addresses and ports are in network byte order, zero
+// masks match any value
+struct nff_go_hw_rule {
+	uint32_t src_addr, src_mask, dst_addr, dst_mask;
+	uint16_t src_port, src_port_mask, dst_port, dst_port_mask;
+	uint8_t proto;
+	uint32_t priority;
+	bool drop;
+	uint16_t queue;
+};
+
+// Validates or creates rte_flow rule which matches IPv4 packets with
+// optional TCP or UDP ports. Returns created rule or NULL and error
+// message of driver.
+struct rte_flow *create_hw_rule(uint16_t port, struct nff_go_hw_rule *r, bool validate_only, int *ret, const char **message) {
+	struct rte_flow_attr attr;
+	struct rte_flow_item pattern[4];
+	struct rte_flow_action action[2];
+	struct rte_flow_item_ipv4 ip_spec, ip_mask;
+	struct rte_flow_item_tcp tcp_spec, tcp_mask;
+	struct rte_flow_item_udp udp_spec, udp_mask;
+	struct rte_flow_action_queue queue = { .index = r->queue };
+	struct rte_flow_error error;
+	struct rte_flow *flow = NULL;
+
+	memset(&attr, 0, sizeof(attr));
+	attr.ingress = 1;
+	attr.priority = r->priority;
+
+	memset(pattern, 0, sizeof(pattern));
+	memset(&ip_spec, 0, sizeof(ip_spec));
+	memset(&ip_mask, 0, sizeof(ip_mask));
+	ip_spec.hdr.src_addr = r->src_addr;
+	ip_mask.hdr.src_addr = r->src_mask;
+	ip_spec.hdr.dst_addr = r->dst_addr;
+	ip_mask.hdr.dst_addr = r->dst_mask;
+	if (r->proto != 0) {
+		ip_spec.hdr.next_proto_id = r->proto;
+		ip_mask.hdr.next_proto_id = 0xff;
+	}
+	pattern[0].type = RTE_FLOW_ITEM_TYPE_ETH;
+	pattern[1].type = RTE_FLOW_ITEM_TYPE_IPV4;
+	pattern[1].spec = &ip_spec;
+	pattern[1].mask = &ip_mask;
+	pattern[2].type = RTE_FLOW_ITEM_TYPE_END;
+	if (r->proto == IPPROTO_TCP) {
+		memset(&tcp_spec, 0, sizeof(tcp_spec));
+		memset(&tcp_mask, 0, sizeof(tcp_mask));
+		tcp_spec.hdr.src_port = r->src_port;
+		tcp_mask.hdr.src_port = r->src_port_mask;
+		tcp_spec.hdr.dst_port = r->dst_port;
+		tcp_mask.hdr.dst_port = r->dst_port_mask;
+		pattern[2].type = RTE_FLOW_ITEM_TYPE_TCP;
+		pattern[2].spec = &tcp_spec;
+		pattern[2].mask = &tcp_mask;
+		pattern[3].type = RTE_FLOW_ITEM_TYPE_END;
+	} else if (r->proto == IPPROTO_UDP) {
+		memset(&udp_spec, 0, sizeof(udp_spec));
+		memset(&udp_mask, 0, sizeof(udp_mask));
+		udp_spec.hdr.src_port = r->src_port;
+		udp_mask.hdr.src_port = r->src_port_mask;
+		udp_spec.hdr.dst_port = r->dst_port;
+		udp_mask.hdr.dst_port = r->dst_port_mask;
+		pattern[2].type = RTE_FLOW_ITEM_TYPE_UDP;
+		pattern[2].spec = &udp_spec;
+		pattern[2].mask = &udp_mask;
+		pattern[3].type = RTE_FLOW_ITEM_TYPE_END;
+	}
+
+	memset(action, 0, sizeof(action));
+	if (r->drop) {
+		action[0].type = RTE_FLOW_ACTION_TYPE_DROP;
+	} else {
+		action[0].type = RTE_FLOW_ACTION_TYPE_QUEUE;
+		action[0].conf = &queue;
+	}
+	action[1].type = RTE_FLOW_ACTION_TYPE_END;
+
+	memset(&error, 0, sizeof(error));
+	*ret = rte_flow_validate(port, &attr, pattern, action, &error);
+	if (*ret == 0 && !validate_only) {
+		flow = rte_flow_create(port, &attr, pattern, action, &error);
+		if (flow == NULL) {
+			*ret = -rte_errno;
+		}
+	}
+	*message = error.message;
+	return flow;
+}
+
+int port_init(uint16_t port, bool willReceive, struct rte_mempool **mbuf_pools, bool promiscuous, bool hwtxchecksum, bool hwrxpacketstimestamp, int32_t inIndex, int32_t tx_queues,
+	uint8_t *rss_key, uint8_t rss_key_len, uint64_t rss_hf, uint16_t *reta, uint16_t reta_size, uint16_t mtu, uint32_t max_rx_pkt_len, bool tso) {
 	uint16_t rx_rings, tx_rings = tx_queues;
 
 	struct rte_eth_dev_info dev_info;
@@ -301,12 +548,36 @@ int port_init(uint16_t port, bool willReceive, struct rte_mempool **mbuf_pools,
 		.rx_adv_conf.rss_conf.rss_hf = dev_info.flow_type_rss_offloads
 	};
 
+	if (rss_key_len != 0) {
+		port_conf_default.rx_adv_conf.rss_conf.rss_key = rss_key;
+		port_conf_default.rx_adv_conf.rss_conf.rss_key_len = rss_key_len;
+	}
+	if (rss_hf != 0) {
+		if ((rss_hf & dev_info.flow_type_rss_offloads) != rss_hf) {
+			printf("Warning! Port %d does not support all requested RSS hash fields. Using supported fields 0x%llx\n",
+				port, (unsigned long long)(rss_hf & dev_info.flow_type_rss_offloads));
+		}
+		port_conf_default.rx_adv_conf.rss_conf.rss_hf = rss_hf & dev_info.flow_type_rss_offloads;
+	}
+
 	if (JUMBO) {
 		port_conf_default.rxmode.max_rx_pkt_len = dev_info.max_rx_pktlen;
 		port_conf_default.rxmode.offloads = DEV_RX_OFFLOAD_JUMBO_FRAME;
 	}
+	if (max_rx_pkt_len > RTE_ETHER_MAX_LEN) {
+		if (max_rx_pkt_len > dev_info.max_rx_pktlen) {
+			fprintf(stderr, "ERROR: Port %u can't receive frames longer than %u bytes\n", port, dev_info.max_rx_pktlen);
+			return -EINVAL;
+		}
+		port_conf_default.rxmode.max_rx_pkt_len = max_rx_pkt_len;
+		port_conf_default.rxmode.offloads |= DEV_RX_OFFLOAD_JUMBO_FRAME;
+	}
 	if (CHAINED) {
-		port_conf_default.txmode.offloads = DEV_TX_OFFLOAD_MULTI_SEGS;
+		if (dev_info.tx_offload_capa & DEV_TX_OFFLOAD_MULTI_SEGS) {
+			port_conf_default.txmode.offloads = DEV_TX_OFFLOAD_MULTI_SEGS;
+		} else {
+			fprintf(stderr, "WARNING: Port %u doesn't support sending of chained mbufs\n", port);
+		}
 	}
 
 	if (hwtxchecksum) {
@@ -314,6 +585,12 @@ int port_init(uint16_t port, bool willReceive, struct rte_mempool **mbuf_pools,
         port_conf_default.txmode.offloads = dev_info.tx_offload_capa;
 	}
 
+	if (tso) {
+		/* TCP segmentation requires checksum offloading */
+		port_conf_default.txmode.offloads |= dev_info.tx_offload_capa &
+			(DEV_TX_OFFLOAD_TCP_TSO | DEV_TX_OFFLOAD_IPV4_CKSUM | DEV_TX_OFFLOAD_TCP_CKSUM | DEV_TX_OFFLOAD_MULTI_SEGS);
+	}
+
     if (hwrxpacketstimestamp) {
         /* Enable hardware timestamping */
         port_conf_default.rxmode.offloads |= dev_info.rx_offload_capa & DEV_RX_OFFLOAD_TIMESTAMP;
@@ -324,6 +601,14 @@ int port_init(uint16_t port, bool willReceive, struct rte_mempool **mbuf_pools,
 	if (retval != 0)
 		return retval;
 
+	if (mtu != 0) {
+		retval = rte_eth_dev_set_mtu(port, mtu);
+		if (retval != 0) {
+			fprintf(stderr, "ERROR: Can't set MTU %u of port %u\n", mtu, port);
+			return retval;
+		}
+	}
+
 	/* Allocate and set up RX queues per Ethernet port. */
 	for (uint16_t q = 0; q < rx_rings; q++) {
 		retval = rte_eth_rx_queue_setup(port, q, RX_RING_SIZE,
@@ -345,6 +630,12 @@ int port_init(uint16_t port, bool willReceive, struct rte_mempool **mbuf_pools,
 	if (retval < 0)
 		return retval;
 
+	if (reta_size != 0 && rx_rings != 0) {
+		retval = set_port_reta(port, reta, reta_size, dev_info.reta_size);
+		if (retval != 0)
+			return retval;
+	}
+
 	if (promiscuous == true) {
 		/* Enable RX in promiscuous mode for the Ethernet device. */
 		rte_eth_promiscuous_enable(port);
@@ -380,6 +671,14 @@ int port_init(uint16_t port, bool willReceive, struct rte_mempool **mbuf_pools,
 __attribute__((always_inline))
 static inline uint64_t calculateSize(struct rte_mbuf *bufs[BURST_SIZE], uint16_t number) {
 	uint64_t size = 0;
+	if (unlikely(l1_byte_accounting)) {
+		for (uint32_t i = 0; i < number; i++) {
+			// FCS, padding to minimum frame, preamble with SFD and interframe gap
+			uint32_t len = bufs[i]->pkt_len + RTE_ETHER_CRC_LEN;
+			size += (len < RTE_ETHER_MIN_LEN ? RTE_ETHER_MIN_LEN : len) + 20;
+		}
+		return size;
+	}
 	for (uint32_t i = 0; i < number; i++) {
 		size += bufs[i]->pkt_len;
 	}
@@ -400,11 +699,12 @@ static inline struct rte_mbuf* reassemble(struct rte_ip_frag_tbl*, struct rte_mb
 
 __attribute__((always_inline))
 static inline uint16_t handleReceived(struct rte_mbuf *bufs[BURST_SIZE], uint16_t rx_pkts_number, struct rte_ip_frag_tbl* tbl, struct rte_ip_frag_death_row* death_row) {
-	if (L2CanBeChanged == true) {
-		for (uint16_t i = 0; i < rx_pkts_number; i++) {
-			// TODO prefetch
+	for (uint16_t i = 0; i < rx_pkts_number; i++) {
+		// TODO prefetch
+		if (L2CanBeChanged == true) {
 			mbufInitL2(bufs[i]);
 		}
+		mbufInitMetadata(bufs[i]);
 	}
 	if (CHAINED) {
 		uint16_t temp_number = 0;
@@ -484,11 +784,19 @@ static inline struct rte_mbuf* reassemble(struct rte_ip_frag_tbl* tbl, struct rt
 	return buf;
 }
 
-void receiveRSS(uint16_t port, volatile int32_t *inIndex, struct rte_ring **out_rings, volatile int *flag, int coreId, volatile int *race, RXTXStats *stats) {
+void receiveRSS(uint16_t port, volatile int32_t *inIndex, struct rte_ring **out_rings, volatile int *flag, int coreId, volatile int *race, volatile int *pause, RXTXStats *stats) {
 	setAffinity(coreId);
 	struct rte_mbuf *bufs[BURST_SIZE];
+	uint32_t idle = 0;
 	REASSEMBLY_INIT
 	while (*flag == process) {
+		if (unlikely(*pause != 0)) {
+			// Rings of flow graph are crowded, packets are left in NIC
+			// instead of being dropped inside graph
+			__atomic_store_n(race, recvDone, __ATOMIC_RELAXED);
+			continue;
+		}
+		bool received = false;
 		for (int q = 0; q < inIndex[0]; q++) {
 			// Get packets from port
 			uint16_t rx_pkts_number = rte_eth_rx_burst(port, inIndex[q+1], bufs, BURST_SIZE);
@@ -496,6 +804,7 @@ void receiveRSS(uint16_t port, volatile int32_t *inIndex, struct rte_ring **out_
 			if (unlikely(rx_pkts_number == 0)) {
 				continue;
 			}
+			received = true;
 			rx_pkts_number = handleReceived(bufs, rx_pkts_number, tbl, pdeath_row);
 
 			uint16_t pushed_pkts_number = rte_ring_enqueue_burst(out_rings[inIndex[q+1]], (void*)bufs, rx_pkts_number, NULL);
@@ -509,6 +818,11 @@ void receiveRSS(uint16_t port, volatile int32_t *inIndex, struct rte_ring **out_
 			__sync_fetch_and_add(&receive_pushed, pushed_pkts_number);
 #endif // DEBUG
 		}
+		if (received) {
+			idle = 0;
+		} else {
+			idleBackoff(&idle);
+		}
 	}
 	free(out_rings);
 	__atomic_store_n(race, recvNotUsed, __ATOMIC_RELAXED);
@@ -571,13 +885,16 @@ void nff_go_send(uint16_t port, struct rte_ring **in_rings, int32_t inIndexNumbe
 	int rx_qend = inIndexNumber / totalSendTreads * (sendThreadIndex + 1);
 	printf("Starting send with %d to %d RX queues and %d to %d TX on core %d\n",
         rx_qstart, rx_qend, tx_qstart, tx_qend, coreId);
+	uint32_t idle = 0;
 	while (*flag == process) {
+		bool sent = false;
 		for (int q = rx_qstart; q < rx_qend; q++) {
 			// Get packets for TX from ring
 			uint16_t pkts_for_tx_number = rte_ring_mc_dequeue_burst(in_rings[q], (void*)bufs, BURST_SIZE, NULL);
 
 			if (unlikely(pkts_for_tx_number == 0))
 				continue;
+			sent = true;
 
             tx_pkts_number = 0;
             int tx_attempts_counter = 0;
@@ -607,6 +924,52 @@ void nff_go_send(uint16_t port, struct rte_ring **in_rings, int32_t inIndexNumbe
 			__sync_fetch_and_add(&send_sent, tx_pkts_number);
 #endif
 		}
+		if (sent) {
+			idle = 0;
+		} else {
+			idleBackoff(&idle);
+		}
+	}
+	free(in_rings);
+	*flag = wasStopped;
+}
+
+// Sends packets from all input rings to one TX queue of port. Queue
+// should be less than number of port TX queues.
+void nff_go_send_queue(uint16_t port, uint16_t queue, struct rte_ring **in_rings, int32_t inIndexNumber, volatile int *flag, int coreId, RXTXStats *stats) {
+	setAffinity(coreId);
+
+	struct rte_mbuf *bufs[BURST_SIZE];
+	uint16_t tx_pkts_number;
+	uint32_t idle = 0;
+	while (*flag == process) {
+		bool sent = false;
+		for (int q = 0; q < inIndexNumber; q++) {
+			// Get packets for TX from ring
+			uint16_t pkts_for_tx_number = rte_ring_mc_dequeue_burst(in_rings[q], (void*)bufs, BURST_SIZE, NULL);
+
+			if (unlikely(pkts_for_tx_number == 0))
+				continue;
+			sent = true;
+
+			tx_pkts_number = 0;
+			int tx_attempts_counter = 0;
+			do {
+				uint16_t iteration_tx_pkts = rte_eth_tx_burst(port, queue, bufs + tx_pkts_number, pkts_for_tx_number - tx_pkts_number);
+				tx_pkts_number += iteration_tx_pkts;
+				tx_attempts_counter++;
+			} while (tx_pkts_number < pkts_for_tx_number && tx_attempts_counter <= TX_ATTEMPTS);
+
+			UPDATE_COUNTERS(tx_pkts_number, calculateSize(bufs, tx_pkts_number), pkts_for_tx_number - tx_pkts_number);
+
+			// Free any unsent packets
+			handleUnpushed(bufs, tx_pkts_number, pkts_for_tx_number);
+		}
+		if (sent) {
+			idle = 0;
+		} else {
+			idleBackoff(&idle);
+		}
 	}
 	free(in_rings);
 	*flag = wasStopped;
@@ -648,6 +1011,43 @@ void directStop(int pkts_for_free_number, struct rte_mbuf **bufs) {
 	}
 }
 
+// Clone shares data of mbuf chain, so Ether fields of cloned
+// packets should point to data of original mbufs. Ether fields of all
+// segments are set before segments are linked, because linking copies
+// them to Data fields of following segments. Clone gets metadata of
+// original packet instead of metadata of previous user of mbuf.
+struct rte_mbuf * cloneMbuf(struct rte_mbuf *mbuf, struct rte_mempool *mempool) {
+	struct rte_mbuf *clone = rte_pktmbuf_clone(mbuf, mempool);
+	if (clone == NULL) {
+		return NULL;
+	}
+	for (struct rte_mbuf *seg = clone; seg != NULL; seg = seg->next) {
+		*(char **)((char *)(seg) + mbufStructSize + 24) = rte_pktmbuf_mtod(seg, char *);
+		mbufInitMetadata(seg);
+	}
+	for (struct rte_mbuf *seg = clone; seg != NULL; seg = seg->next) {
+		if (seg->next != NULL) {
+			mbufSetNext(seg);
+		} else {
+			mbufInitNextChain(seg);
+		}
+	}
+	mbufCopyMetadata(clone, mbuf);
+	return clone;
+}
+
+void refcntUpdateMbuf(struct rte_mbuf *mbuf, int16_t value) {
+	rte_pktmbuf_refcnt_update(mbuf, value);
+}
+
+// Data of cloned mbuf belongs to direct mbuf, so its counter is read
+uint16_t refcntReadMbuf(struct rte_mbuf *mbuf) {
+	if (RTE_MBUF_CLONED(mbuf)) {
+		mbuf = rte_mbuf_from_indirect(mbuf);
+	}
+	return rte_mbuf_refcnt_read(mbuf);
+}
+
 bool directSend(struct rte_mbuf *mbuf, uint16_t port) {
 	// try to send one packet to specified port, zero queue
 	if (rte_eth_tx_burst(port, 0, &mbuf, 1) == 1) {
@@ -658,6 +1058,10 @@ bool directSend(struct rte_mbuf *mbuf, uint16_t port) {
 	}
 }
 
+uint16_t directReceive(uint16_t port, uint16_t queue, struct rte_mbuf **bufs, uint16_t n) {
+	return rte_eth_rx_burst(port, queue, bufs, n);
+}
+
 char ** makeArgv(int n) {
 	return (char**) malloc(n * sizeof(char**));
 }
@@ -799,24 +1203,34 @@ int allocateMbufs(struct rte_mempool *mempool, struct rte_mbuf **bufs, unsigned
 			if (CHAINED) {
 				mbufInitNextChain(bufs[i]);
 			}
+			mbufInitMetadata(bufs[i]);
 		}
 	}
 	return ret;
 }
 
-struct rte_mempool * createMempool(uint32_t num_mbufs, uint32_t mbuf_cache_size) {
+struct rte_mempool * createMempool(uint32_t num_mbufs, uint32_t mbuf_cache_size, int socket_id, uint32_t buf_size) {
 	struct rte_mempool *mbuf_pool;
 
+	if (socket_id == SOCKET_ID_ANY) {
+		socket_id = rte_socket_id();
+	}
+
 	int mbufSize = RTE_MBUF_DEFAULT_BUF_SIZE;
 	if (MEMORY_JUMBO) {
 		mbufSize = MAX_JUMBO_PKT_LEN;
 	}
+	// Buffers are enlarged to fit frames of ports with large MTU
+	if (buf_size + RTE_PKTMBUF_HEADROOM > mbufSize) {
+		mbufSize = buf_size + RTE_PKTMBUF_HEADROOM;
+	}
 
-	/* Creates a new mempool in memory to hold the mbufs. */
-	mbuf_pool = rte_pktmbuf_pool_create(mempoolName, num_mbufs,
-		mbuf_cache_size, 0, mbufSize, rte_socket_id());
+	char name[RTE_MEMPOOL_NAMESIZE];
+	snprintf(name, sizeof(name), "%smempool%d", objectPrefix, mempoolNumber++);
 
-	mempoolName[7]++;
+	/* Creates a new mempool in memory to hold the mbufs. */
+	mbuf_pool = rte_pktmbuf_pool_create(name, num_mbufs,
+		mbuf_cache_size, 0, mbufSize, socket_id);
 
 	if (mbuf_pool == NULL)
 		rte_exit(EXIT_FAILURE, "Cannot create mbuf pool\n");
@@ -831,6 +1245,7 @@ struct rte_mempool * createMempool(uint32_t num_mbufs, uint32_t mbuf_cache_size)
 		mbufInitL2(temp[i])
 		mbufInitCMbuf(temp[i])
 		mbufInitNextChain(temp[i])
+		mbufInitMetadata(temp[i])
 	}
 	for (int i = 0; i < num_mbufs; i++) {
 		rte_pktmbuf_free(temp[i]);
@@ -861,9 +1276,13 @@ struct rte_ring** extractDPDKRings(struct nff_go_ring** r, int32_t inIndexNumber
 
 struct nff_go_ring *
 nff_go_ring_lookup(const char *name) {
+	struct rte_ring *ring = rte_ring_lookup(name);
+	if (ring == NULL) {
+		return NULL;
+	}
 	struct nff_go_ring* r = malloc(sizeof(struct nff_go_ring));
 
-	r->DPDK_ring = rte_ring_lookup(name);
+	r->DPDK_ring = ring;
 	// Ring elements are located immidiately behind rte_ring structure
 	// So ring[1] is pointed to the beginning of this data
 	r->internal_DPDK_ring = &(r->DPDK_ring)[1];
@@ -948,6 +1367,19 @@ bool check_hwtxchecksum_capability(uint16_t port_id) {
 	return (dev_info.tx_offload_capa & flags) == flags;
 }
 
+bool check_hwtxtunnelchecksum_capability(uint16_t port_id) {
+	uint64_t flags = DEV_TX_OFFLOAD_OUTER_IPV4_CKSUM |
+		DEV_TX_OFFLOAD_OUTER_UDP_CKSUM;
+	struct rte_eth_dev_info dev_info;
+
+	if (port_id >= rte_eth_dev_count())
+		return false;
+
+	memset(&dev_info, 0, sizeof(dev_info));
+	rte_eth_dev_info_get(port_id, &dev_info);
+	return (dev_info.tx_offload_capa & flags) == flags;
+}
+
 bool check_hwrxpackets_timestamp_capability(uint16_t port_id) {
 	uint64_t flags = DEV_RX_OFFLOAD_TIMESTAMP;
 	struct rte_eth_dev_info dev_info;
@@ -997,23 +1429,68 @@ int initDevice(char *name) {
 	return s;
 }
 
-void receiveOS(int socket, struct rte_ring *out_ring, struct rte_mempool *m, volatile int *flag, int coreId, RXTXStats *stats) {
+// Creates Linux tap device or tun device without packet information
+// header and sets it up. Returns file descriptor of device.
+int initTapDevice(char *name, bool tun) {
+	int fd = open("/dev/net/tun", O_RDWR);
+	if (fd < 0) {
+		fprintf(stderr, "ERROR: Can't open /dev/net/tun: %s\n", strerror(errno));
+		return -1;
+	}
+
+	struct ifreq ifr;
+	memset(&ifr, 0, sizeof(ifr));
+	snprintf(ifr.ifr_name, sizeof(ifr.ifr_name), "%s", name);
+	ifr.ifr_flags = (tun ? IFF_TUN : IFF_TAP) | IFF_NO_PI;
+	if (ioctl(fd, TUNSETIFF, &ifr) < 0) {
+		fprintf(stderr, "ERROR: Can't create device %s: %s\n", name, strerror(errno));
+		close(fd);
+		return -1;
+	}
+
+	int s = socket(AF_INET, SOCK_DGRAM, 0);
+	if (s < 0 || ioctl(s, SIOCGIFFLAGS, &ifr) < 0) {
+		fprintf(stderr, "ERROR: Can't get flags of device %s\n", name);
+		close(fd);
+		return -1;
+	}
+	ifr.ifr_flags |= IFF_UP;
+	if (ioctl(s, SIOCSIFFLAGS, &ifr) < 0) {
+		fprintf(stderr, "ERROR: Can't set up device %s\n", name);
+		close(s);
+		close(fd);
+		return -1;
+	}
+	close(s);
+	return fd;
+}
+
+// Packets of tun devices don't have Ethernet header. It is added with
+// zero addresses after receiving and is removed before sending.
+void receiveOS(int socket, bool l3, struct rte_ring *out_ring, struct rte_mempool *m, volatile int *flag, int coreId, RXTXStats *stats) {
 	setAffinity(coreId);
 	const int recvOSBusrst = BURST_SIZE;
 	struct rte_mbuf *bufs[recvOSBusrst];
+	const int offset = l3 ? sizeof(struct rte_ether_hdr) : 0;
 	REASSEMBLY_INIT
 	while (*flag == process) {
 		// Get packets from OS
 		allocateMbufs(m, bufs, recvOSBusrst);
 		//int step = 0;
 		for (int i = 0; i < recvOSBusrst; i++) {
-			int bytes_received = recv(socket, (char *)(bufs[i]) + defaultStart, ETH_FRAME_LEN, 0);
-			if (unlikely(bytes_received == 0)) {
+			char *start = (char *)(bufs[i]) + defaultStart;
+			int bytes_received = read(socket, start + offset, ETH_FRAME_LEN - offset);
+			if (unlikely(bytes_received <= 0)) {
 				//step++;
 				i--;
 				continue;
 			}
-			rte_pktmbuf_append(bufs[i], bytes_received);
+			if (l3) {
+				struct rte_ether_hdr *eth = (struct rte_ether_hdr *)start;
+				memset(eth, 0, sizeof(*eth));
+				eth->ether_type = (start[offset] >> 4) == 6 ? htons(RTE_ETHER_TYPE_IPV6) : htons(RTE_ETHER_TYPE_IPV4);
+			}
+			rte_pktmbuf_append(bufs[i], bytes_received + offset);
 		}
 		uint16_t rx_pkts_number = handleReceived(bufs, recvOSBusrst, tbl, pdeath_row);
 		uint16_t pushed_pkts_number = rte_ring_enqueue_burst(out_ring, (void*)bufs, rx_pkts_number, NULL);
@@ -1030,18 +1507,21 @@ void receiveOS(int socket, struct rte_ring *out_ring, struct rte_mempool *m, vol
 	*flag = wasStopped;
 }
 
-void sendOS(int socket, struct rte_ring **in_rings, int32_t inIndexNumber, volatile int *flag, int coreId, RXTXStats *stats) {
+void sendOS(int socket, bool l3, struct rte_ring **in_rings, int32_t inIndexNumber, volatile int *flag, int coreId, RXTXStats *stats) {
 	setAffinity(coreId);
 
 	struct rte_mbuf *bufs[BURST_SIZE];
 	uint16_t buf;
+	const int offset = l3 ? sizeof(struct rte_ether_hdr) : 0;
 	while (*flag == process) {
 		for (int q = 0; q < inIndexNumber; q++) {
 			// Get packets for TX from ring
 			uint16_t pkts_for_tx_number = rte_ring_mc_dequeue_burst(in_rings[q], (void*)bufs, BURST_SIZE, NULL);
 
 			for (int i = 0; i < pkts_for_tx_number; i++) {
-				send(socket, (char *)(bufs[i]) + defaultStart, rte_pktmbuf_pkt_len(bufs[i]), 0);
+				if (likely(rte_pktmbuf_pkt_len(bufs[i]) > offset)) {
+					write(socket, (char *)(bufs[i]) + defaultStart + offset, rte_pktmbuf_pkt_len(bufs[i]) - offset);
+				}
 			}
 
             UPDATE_COUNTERS(pkts_for_tx_number, calculateSize(bufs, pkts_for_tx_number), 0);
@@ -1058,6 +1538,51 @@ void sendOS(int socket, struct rte_ring **in_rings, int32_t inIndexNumber, volat
 	*flag = wasStopped;
 }
 
+// Shared rings connect flow graphs of DPDK primary and secondary
+// processes. Mbufs are allocated from mempools which are shared by
+// processes, so they are passed between processes by pointers.
+void receiveRing(struct rte_ring *shared_ring, struct rte_ring *out_ring, volatile int *flag, int coreId, RXTXStats *stats) {
+	setAffinity(coreId);
+
+	struct rte_mbuf *bufs[BURST_SIZE];
+	while (*flag == process) {
+		uint16_t rx_pkts_number = rte_ring_mc_dequeue_burst(shared_ring, (void*)bufs, BURST_SIZE, NULL);
+		if (rx_pkts_number == 0) {
+			continue;
+		}
+		uint16_t pushed_pkts_number = rte_ring_enqueue_burst(out_ring, (void*)bufs, rx_pkts_number, NULL);
+
+		UPDATE_COUNTERS(pushed_pkts_number, calculateSize(bufs, pushed_pkts_number), rx_pkts_number - pushed_pkts_number);
+
+		// Free any packets which can't be pushed to the ring. The ring is probably full.
+		handleUnpushed(bufs, pushed_pkts_number, rx_pkts_number);
+	}
+	*flag = wasStopped;
+}
+
+void sendRing(struct rte_ring *shared_ring, struct rte_ring **in_rings, int32_t inIndexNumber, volatile int *flag, int coreId, RXTXStats *stats) {
+	setAffinity(coreId);
+
+	struct rte_mbuf *bufs[BURST_SIZE];
+	while (*flag == process) {
+		for (int q = 0; q < inIndexNumber; q++) {
+			// Get packets for TX from ring
+			uint16_t pkts_for_tx_number = rte_ring_mc_dequeue_burst(in_rings[q], (void*)bufs, BURST_SIZE, NULL);
+			if (pkts_for_tx_number == 0) {
+				continue;
+			}
+			uint16_t tx_pkts_number = rte_ring_mp_enqueue_burst(shared_ring, (void*)bufs, pkts_for_tx_number, NULL);
+
+			UPDATE_COUNTERS(tx_pkts_number, calculateSize(bufs, tx_pkts_number), pkts_for_tx_number - tx_pkts_number);
+
+			// Free any packets which can't be pushed to shared ring. Peer process is probably slow or stopped.
+			handleUnpushed(bufs, tx_pkts_number, pkts_for_tx_number);
+		}
+	}
+	free(in_rings);
+	*flag = wasStopped;
+}
+
 // ---------- XDP socket section ----------
 
 #ifdef NFF_GO_SUPPORT_XDP
@@ -0,0 +1,12 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build memif
+
+package low
+
+/*
+#cgo LDFLAGS: -Wl,--whole-archive -lrte_pmd_memif -Wl,--no-whole-archive
+*/
+import "C"
@@ -76,3 +76,26 @@ func TestReorder(t *testing.T) {
 		}
 	}
 }
+
+func TestJumboMbuf(t *testing.T) {
+	frameLen := MaxFrameLen(9000)
+	if err := SetMbufBufSize(frameLen); err != nil {
+		t.Fatal(err)
+	}
+	mempool := CreateMempool("jumbo")
+	var mb uintptr
+	if err := AllocateMbuf(&mb, mempool); err != nil {
+		t.Fatal(err)
+	}
+	m := (*Mbuf)(unsafe.Pointer(mb))
+	if GetTailroomMbuf(m) < frameLen || !AppendMbuf(m, frameLen) || GetPktLenMbuf(m) != frameLen {
+		t.Fatalf("Frame of %d bytes doesn't fit into mbuf with tailroom %d", frameLen, GetTailroomMbuf(m))
+	}
+	// Lengths which are truncated to 16 bits shouldn't pass checks
+	if AppendMbuf(m, 1<<16) || PrependMbuf(m, 1<<16) || AdjMbuf(m, 1<<16) || TrimMbuf(m, 1<<16) {
+		t.Error("Length larger than 16 bits was accepted")
+	}
+	if SetMbufBufSize(1<<16) == nil {
+		t.Error("Too large buffer size was accepted")
+	}
+}
@@ -66,6 +66,22 @@ endif
 CFLAGS += -DNFF_GO_SUPPORT_XDP
 endif
 
+ifdef NFF_GO_MEMIF_SUPPORT
+ifeq (,$(findstring memif,$(GO_BUILD_TAGS)))
+export GO_BUILD_TAGS += memif
+endif
+endif
+
+# Build profile selects optional subsystems of network functions.
+# nat-minimal profile excludes control server and IPv6 support of
+# egress gateway. Single subsystems can be excluded by no_* tags in
+# GO_BUILD_TAGS, for example no_control.
+ifeq ($(NFF_GO_PROFILE),nat-minimal)
+ifeq (,$(findstring nat_minimal,$(GO_BUILD_TAGS)))
+export GO_BUILD_TAGS += nat_minimal
+endif
+endif
+
 export CGO_CFLAGS = $(CFLAGS)
 
 export CGO_LDFLAGS =				\
@@ -24,18 +24,47 @@
 //		L2ACLPort
 //		L3ACLPermit
 // 		L3ACLPort
+//
+// Rules syntax
+//
+// Addresses of L3 rules can be comma separated lists of subnets and ports can be
+// comma separated lists of ports and "min:max" ranges, for example
+// "10.0.0.0/8,192.168.0.0/16" or "80,443,8000:8080". Lists can be negated by "!"
+// prefix, so rule with "!10.0.0.0/8" source address matches packets from other
+// addresses. ANY can't be negated or listed.
+//
+// L3 rules match IPv4 and IPv6 packets. Rule with ANY addresses matches both, rule
+// with addresses matches packets of their IP version. L4 protocol of IPv6 packet is
+// taken after extension headers. ICMP rules match ICMPv6 in IPv6 packets, ICMPv6
+// rules match only IPv6 packets.
+//
+// L3 rules can have payload condition, which is a substring or regular expression with
+// "re:" prefix, for example "example.com" or "re:\.example\.(com|org)". It is the
+// seventh column of text table and "Payload" field of JSON rules. Substrings can be Go
+// quoted strings with escapes, for example "Host:\x20example.com" including quotes,
+// because columns of text table can't contain spaces. Conditions are checked over the
+// first L7ACLDepth bytes of TCP, UDP or ICMP payload, so rules can block for example
+// TLS server names or HTTP hosts. Regular expressions are checked in linear time.
+//
+// Large sets of L3 rules are compiled by "get" functions to multi-bit tries of source
+// and destination addresses, so that only rules which can match packet addresses are
+// checked. Result is the same as result of checking rules one by one.
 package packet
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/intel-go/nff-go/common"
 	"github.com/intel-go/nff-go/types"
@@ -59,8 +88,13 @@ type rawL3Rule struct {
 	SrcPort      string
 	DstPort      string
 	OutputNumber string
+	Payload      string
 }
 
+// L7ACLDepth is a number of the first payload bytes which are checked
+// by payload conditions of L3 rules.
+const L7ACLDepth = 512
+
 type rawL3Rules struct {
 	L3Rules []rawL3Rule
 }
@@ -162,11 +196,14 @@ func GetL3ACLFromTextTable(filename string) (*L3Rules, error) {
 		lines := strings.Fields(temp)
 		if len(lines) == 5 {
 			lines = append(lines, "false")
-		} else if len(lines) != 6 {
+		}
+		if len(lines) == 6 {
+			lines = append(lines, "ANY")
+		} else if len(lines) != 7 {
 			return nil, common.WrapWithNFError(nil, "Incomplete 5-tuple for rule parsing", common.ParseRuleErr)
 		}
 		rawRules.L3Rules = append(rawRules.L3Rules, rawL3Rule{SrcAddr: lines[0], DstAddr: lines[1],
-			ID: lines[2], SrcPort: lines[3], DstPort: lines[4], OutputNumber: lines[5]})
+			ID: lines[2], SrcPort: lines[3], DstPort: lines[4], OutputNumber: lines[5], Payload: lines[6]})
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, common.WrapWithNFError(err, "file error during rules parsing", common.FileErr)
@@ -177,6 +214,66 @@ func GetL3ACLFromTextTable(filename string) (*L3Rules, error) {
 	return &rules, rawL3Parse(&rawRules, &rules)
 }
 
+// L3ACL is a set of L3 rules which can be replaced at runtime without
+// stopping packet processing. Rules are replaced by pointer swap, so
+// handlers which got rules by Rules call finish checking of packet
+// with old rules, and the following packets are checked with new ones,
+// so rules can be reloaded for example by file watcher.
+type L3ACL struct {
+	rules atomic.Value
+	// Serializes writers, readers don't take it
+	mutex sync.Mutex
+}
+
+// NewL3ACL returns L3ACL with given rules, empty rules are used if
+// rules is nil.
+func NewL3ACL(rules *L3Rules) *L3ACL {
+	acl := new(L3ACL)
+	acl.SetRules(rules)
+	return acl
+}
+
+// Rules returns current rules of acl. Returned rules are never
+// changed, so they can be used without synchronization.
+func (acl *L3ACL) Rules() *L3Rules {
+	return acl.rules.Load().(*L3Rules)
+}
+
+// SetRules atomically replaces rules of acl and returns previous rules.
+// Rules should not be changed after they are set.
+func (acl *L3ACL) SetRules(rules *L3Rules) *L3Rules {
+	if rules == nil {
+		rules = new(L3Rules)
+	}
+	acl.mutex.Lock()
+	defer acl.mutex.Unlock()
+	old, _ := acl.rules.Load().(*L3Rules)
+	acl.rules.Store(rules)
+	return old
+}
+
+// ReloadFromJSON replaces rules of acl with rules from JSON file. Rules
+// aren't changed if file can't be parsed.
+func (acl *L3ACL) ReloadFromJSON(filename string) error {
+	rules, err := GetL3ACLFromJSON(filename)
+	if err != nil {
+		return err
+	}
+	acl.SetRules(rules)
+	return nil
+}
+
+// ReloadFromTextTable replaces rules of acl with rules from text table
+// file. Rules aren't changed if file can't be parsed.
+func (acl *L3ACL) ReloadFromTextTable(filename string) error {
+	rules, err := GetL3ACLFromTextTable(filename)
+	if err != nil {
+		return err
+	}
+	acl.SetRules(rules)
+	return nil
+}
+
 func rawL2Parse(rules *rawL2Rules, jp *L2Rules) error {
 	jup := rules.L2Rules
 	jp.eth = make([]l2Rules, len(jup))
@@ -236,6 +333,11 @@ func rawL3Parse(rules *rawL3Rules, jp *L3Rules) error {
 	jp.ip4 = make([]l3Rules4, 0, len(jup))
 	jp.ip6 = make([]l3Rules6, 0, len(jup))
 	for i := 0; i < len(jup); i++ {
+		temp4 = l3Rules4{}
+		temp6 = l3Rules6{}
+		// ICMP rules match ICMPv6 in IPv6 packets, ICMPv6 rules match
+		// only IPv6 packets
+		var icmp, icmpv6 bool
 		// Parse L4 ID
 		switch jup[i].ID {
 		case "ANY":
@@ -250,57 +352,96 @@ func rawL3Parse(rules *rawL3Rules, jp *L3Rules) error {
 		case "icmp", "ICMP", "Icmp", "0x01", "1":
 			l4temp.ID = types.ICMPNumber
 			l4temp.IDMask = 0xff
-			if jup[i].SrcPort != "ANY" || jup[i].DstPort != "ANY" {
-				return common.WrapWithNFError(nil, "Incorrect request: for ICMP rule Source port and Destination port should be ANY", common.IncorrectArgInRules)
-			}
+			icmp = true
+		case "icmpv6", "ICMPv6", "Icmpv6", "0x3a", "58":
+			l4temp.ID = types.ICMPv6Number
+			l4temp.IDMask = 0xff
+			icmpv6 = true
 		default:
 			return common.WrapWithNFError(nil, fmt.Sprintf("Incorrect  L4 protocol ID: %v", jup[i].ID), common.IncorrectArgInRules)
 		}
+		if (icmp || icmpv6) && (jup[i].SrcPort != "ANY" || jup[i].DstPort != "ANY") {
+			return common.WrapWithNFError(nil, "Incorrect request: for ICMP rule Source port and Destination port should be ANY", common.IncorrectArgInRules)
+		}
 		var err error
+		var srcPorts, dstPorts l4PortRange
 		// Parse L4 ports
-		l4temp.SrcPortMin, l4temp.SrcPortMax, validSrc, err = parseL4Port(jup[i].SrcPort)
+		srcPorts, l4temp.SrcPortList, l4temp.SrcPortNot, validSrc, err = parseL4PortList(jup[i].SrcPort)
 		if err != nil {
 			return err
 		}
-		l4temp.DstPortMin, l4temp.DstPortMax, validDst, err = parseL4Port(jup[i].DstPort)
+		dstPorts, l4temp.DstPortList, l4temp.DstPortNot, validDst, err = parseL4PortList(jup[i].DstPort)
 		if err != nil {
 			return err
 		}
+		l4temp.SrcPortMin, l4temp.SrcPortMax = srcPorts.Min, srcPorts.Max
+		l4temp.DstPortMin, l4temp.DstPortMax = dstPorts.Min, dstPorts.Max
 		l4temp.valid = validSrc || validDst
+		l4temp6 := l4temp
+		if icmp {
+			l4temp6.ID = types.ICMPv6Number
+		}
 
-		// Parse L3 addresses
-		if jup[i].SrcAddr == "ANY" {
-			srcLen = 0
-		} else {
-			_, srcAddr, _ = net.ParseCIDR(jup[i].SrcAddr)
+		// Parse L3 addresses. The first subnet of list is stored in
+		// address and mask of rule, other subnets are stored in list.
+		srcNets, srcNot, err := parseAddrList(jup[i].SrcAddr)
+		if err != nil {
+			return err
+		}
+		srcLen = 0
+		if srcNets != nil {
+			srcAddr = srcNets[0]
 			srcLen = uint8(len(srcAddr.IP))
 		}
-		if jup[i].DstAddr == "ANY" {
-			dstLen = 0
-		} else {
-			_, dstAddr, _ = net.ParseCIDR(jup[i].DstAddr)
+		dstNets, dstNot, err := parseAddrList(jup[i].DstAddr)
+		if err != nil {
+			return err
+		}
+		dstLen = 0
+		if dstNets != nil {
+			dstAddr = dstNets[0]
 			dstLen = uint8(len(dstAddr.IP))
 		}
+		l7temp, err := parseL7Rule(jup[i].Payload)
+		if err != nil {
+			return err
+		}
+		temp4.L7, temp6.L7 = l7temp, l7temp
+		if icmpv6 && (srcLen == 4 || dstLen == 4) {
+			return common.WrapWithNFError(nil, "Incorrect request: ICMPv6 in IPv4 rule", common.IncorrectArgInRules)
+		}
+		if srcLen == 4 {
+			temp4.SrcList, temp4.SrcNot = parseSubnets4(srcNets[1:]), srcNot
+		} else if srcLen == 16 {
+			temp6.SrcList, temp6.SrcNot = parseSubnets6(srcNets[1:]), srcNot
+		}
+		if dstLen == 4 {
+			temp4.DstList, temp4.DstNot = parseSubnets4(dstNets[1:]), dstNot
+		} else if dstLen == 16 {
+			temp6.DstList, temp6.DstNot = parseSubnets6(dstNets[1:]), dstNot
+		}
 
 		if srcLen == 0 {
 			temp4.SrcAddr, temp4.SrcMask = parseAddr4(zero4)
 			temp6.SrcAddr, temp6.SrcMask = parseAddr6(zero6)
 			if dstLen == 0 {
 				// Both src and dst are ANY. Need to create rules for both ip4 and ip6
-				temp4.DstAddr, temp4.DstMask = parseAddr4(zero4)
-				temp4.OutputNumber, err = parseRuleResult(jup[i].OutputNumber)
-				if err != nil {
-					return err
+				if !icmpv6 {
+					temp4.DstAddr, temp4.DstMask = parseAddr4(zero4)
+					temp4.OutputNumber, err = parseRuleResult(jup[i].OutputNumber)
+					if err != nil {
+						return err
+					}
+					temp4.L4 = l4temp
+					jp.ip4 = append(jp.ip4, temp4)
 				}
-				temp4.L4 = l4temp
-				jp.ip4 = append(jp.ip4, temp4)
 
 				temp6.DstAddr, temp6.DstMask = parseAddr6(zero6)
 				temp6.OutputNumber, err = parseRuleResult(jup[i].OutputNumber)
 				if err != nil {
 					return err
 				}
-				temp6.L4 = l4temp
+				temp6.L4 = l4temp6
 				jp.ip6 = append(jp.ip6, temp6)
 			} else if dstLen == 4 {
 				temp4.DstAddr, temp4.DstMask = parseAddr4(dstAddr)
@@ -316,7 +457,7 @@ func rawL3Parse(rules *rawL3Rules, jp *L3Rules) error {
 				if err != nil {
 					return err
 				}
-				temp6.L4 = l4temp
+				temp6.L4 = l4temp6
 				jp.ip6 = append(jp.ip6, temp6)
 			}
 		} else if srcLen == 4 {
@@ -347,10 +488,11 @@ func rawL3Parse(rules *rawL3Rules, jp *L3Rules) error {
 			if err != nil {
 				return err
 			}
-			temp6.L4 = l4temp
+			temp6.L4 = l4temp6
 			jp.ip6 = append(jp.ip6, temp6)
 		}
 	}
+	jp.compile()
 	return nil
 }
 
@@ -382,6 +524,99 @@ func parseL4Port(port string) (uint16, uint16, bool, error) {
 	return uint16(tMin), uint16(tMax), valid, nil
 }
 
+// parseL4PortList parses port, range of ports or comma separated list
+// of them, which can be negated by "!" prefix. Returns the first range,
+// other ranges of list, negation and false if all ports are accepted.
+func parseL4PortList(ports string) (l4PortRange, []l4PortRange, bool, bool, error) {
+	var first l4PortRange
+	var list []l4PortRange
+	not := strings.HasPrefix(ports, "!")
+	s := strings.Split(strings.TrimPrefix(ports, "!"), ",")
+	for i := range s {
+		tMin, tMax, valid, err := parseL4Port(s[i])
+		if err != nil {
+			return l4PortRange{}, nil, false, false, err
+		}
+		if !valid {
+			if not || len(s) != 1 {
+				return l4PortRange{}, nil, false, false, common.WrapWithNFError(nil, fmt.Sprintf("Incorrect request: ANY port can't be negated or listed, port: %v", ports), common.IncorrectArgInRules)
+			}
+			return l4PortRange{Min: tMin, Max: tMax}, nil, false, false, nil
+		}
+		if i == 0 {
+			first = l4PortRange{Min: tMin, Max: tMax}
+		} else {
+			list = append(list, l4PortRange{Min: tMin, Max: tMax})
+		}
+	}
+	return first, list, not, true, nil
+}
+
+// parseAddrList parses subnet or comma separated list of subnets of
+// the same IP version, which can be negated by "!" prefix. Returns nil
+// for ANY address.
+func parseAddrList(addr string) ([]*net.IPNet, bool, error) {
+	if addr == "ANY" {
+		return nil, false, nil
+	}
+	not := strings.HasPrefix(addr, "!")
+	s := strings.Split(strings.TrimPrefix(addr, "!"), ",")
+	nets := make([]*net.IPNet, len(s))
+	for i := range s {
+		_, subnet, err := net.ParseCIDR(s[i])
+		if err != nil {
+			return nil, false, common.WrapWithNFError(err, fmt.Sprintf("Incorrect address: %v", addr), common.IncorrectArgInRules)
+		}
+		if i != 0 && len(subnet.IP) != len(nets[0].IP) {
+			return nil, false, common.WrapWithNFError(nil, "Incorrect request: IPv4 + IPv6 in one list", common.IncorrectArgInRules)
+		}
+		nets[i] = subnet
+	}
+	return nets, not, nil
+}
+
+func parseSubnets4(nets []*net.IPNet) []types.IPv4Subnet {
+	var list []types.IPv4Subnet
+	for i := range nets {
+		addr, mask := parseAddr4(nets[i])
+		list = append(list, types.IPv4Subnet{Addr: addr, Mask: mask})
+	}
+	return list
+}
+
+func parseSubnets6(nets []*net.IPNet) []types.IPv6Subnet {
+	var list []types.IPv6Subnet
+	for i := range nets {
+		addr, mask := parseAddr6(nets[i])
+		list = append(list, types.IPv6Subnet{Addr: addr, Mask: mask})
+	}
+	return list
+}
+
+// parseL7Rule parses payload condition which is a substring, Go quoted
+// string with escapes or regular expression with "re:" prefix. Returns
+// nil for empty or ANY condition.
+func parseL7Rule(payload string) (*l7Rules, error) {
+	if payload == "" || payload == "ANY" {
+		return nil, nil
+	}
+	if strings.HasPrefix(payload, "re:") {
+		re, err := regexp.Compile(payload[len("re:"):])
+		if err != nil {
+			return nil, common.WrapWithNFError(err, fmt.Sprintf("Incorrect payload regular expression: %v", payload), common.IncorrectArgInRules)
+		}
+		return &l7Rules{Regexp: re}, nil
+	}
+	if strings.HasPrefix(payload, "\"") {
+		unquoted, err := strconv.Unquote(payload)
+		if err != nil || unquoted == "" {
+			return nil, common.WrapWithNFError(err, fmt.Sprintf("Incorrect payload string: %v", payload), common.IncorrectArgInRules)
+		}
+		payload = unquoted
+	}
+	return &l7Rules{Substring: []byte(payload)}, nil
+}
+
 func parseRuleResult(rule string) (uint, error) {
 	switch rule {
 	case "Accept", "true":
@@ -420,6 +655,11 @@ type l2Rules struct {
 	ID           uint16
 }
 
+type l4PortRange struct {
+	Min uint16
+	Max uint16
+}
+
 type l4Rules struct {
 	ID         uint8
 	IDMask     uint8
@@ -428,6 +668,19 @@ type l4Rules struct {
 	SrcPortMax uint16
 	DstPortMin uint16
 	DstPortMax uint16
+	// Other ranges of port lists
+	SrcPortList []l4PortRange
+	DstPortList []l4PortRange
+	// Rule matches ports which are not in ranges
+	SrcPortNot bool
+	DstPortNot bool
+}
+
+// l7Rules is a payload condition, either substring or regular
+// expression is set.
+type l7Rules struct {
+	Substring []byte
+	Regexp    *regexp.Regexp
 }
 
 type l3Rules4 struct {
@@ -437,6 +690,14 @@ type l3Rules4 struct {
 	SrcMask      types.IPv4Address
 	DstMask      types.IPv4Address
 	L4           l4Rules
+	// Payload condition, nil if payload isn't checked
+	L7 *l7Rules
+	// Other subnets of address lists
+	SrcList []types.IPv4Subnet
+	DstList []types.IPv4Subnet
+	// Rule matches addresses which are not in subnets
+	SrcNot bool
+	DstNot bool
 }
 
 type l3Rules6 struct {
@@ -446,12 +707,23 @@ type l3Rules6 struct {
 	SrcMask      types.IPv6Address
 	DstMask      types.IPv6Address
 	L4           l4Rules
+	// Payload condition, nil if payload isn't checked
+	L7 *l7Rules
+	// Other subnets of address lists
+	SrcList []types.IPv6Subnet
+	DstList []types.IPv6Subnet
+	// Rule matches addresses which are not in subnets
+	SrcNot bool
+	DstNot bool
 }
 
 // L3Rules - struct for rules of l3 level
 type L3Rules struct {
 	ip4 []l3Rules4
 	ip6 []l3Rules6
+	// Tries of large rule sets, nil for small ones
+	trie4 *l3ACLTrie
+	trie6 *l3ACLTrie
 }
 
 // L2Rules - struct for rules of l2 level
@@ -509,57 +781,138 @@ func (pkt *Packet) l4ACL(L4 *l4Rules) bool {
 	// Src and Dst port numbers placed at the same offset from L4 start in both tcp and udp
 	l4 := (*UDPHdr)(pkt.L4)
 	srcPort := SwapBytesUint16(l4.SrcPort)
-	if srcPort < L4.SrcPortMin || srcPort > L4.SrcPortMax {
+	if !portMatches(srcPort, L4.SrcPortMin, L4.SrcPortMax, L4.SrcPortList, L4.SrcPortNot) {
 		return false
 	}
 	dstPort := SwapBytesUint16(l4.DstPort)
-	if dstPort < L4.DstPortMin || dstPort > L4.DstPortMax {
+	if !portMatches(dstPort, L4.DstPortMin, L4.DstPortMax, L4.DstPortList, L4.DstPortNot) {
 		return false
 	}
 	return true
 }
 
+func portMatches(port, min, max uint16, list []l4PortRange, not bool) bool {
+	match := port >= min && port <= max
+	for i := 0; !match && i < len(list); i++ {
+		match = port >= list[i].Min && port <= list[i].Max
+	}
+	return match != not
+}
+
+func addr4Matches(addr, ruleAddr, mask types.IPv4Address, list []types.IPv4Subnet, not bool) bool {
+	match := (ruleAddr^addr)&mask == 0
+	for i := 0; !match && i < len(list); i++ {
+		match = list[i].CheckIPv4AddressWithinSubnet(addr)
+	}
+	return match != not
+}
+
+func addr6Matches(addr, ruleAddr, mask types.IPv6Address, list []types.IPv6Subnet, not bool) bool {
+	match := true
+	for i := 0; match && i < 16; i++ {
+		match = (ruleAddr[i]^addr[i])&mask[i] == 0
+	}
+	for i := 0; !match && i < len(list); i++ {
+		match = list[i].CheckIPv6AddressWithinSubnet(addr)
+	}
+	return match != not
+}
+
 func (pkt *Packet) l3ACL(rules *L3Rules) uint {
 	ipv4, ipv6, _ := pkt.ParseAllKnownL3()
 	if ipv4 != nil {
-		for _, rule := range rules.ip4 {
-			if ((rule.SrcAddr ^ ipv4.SrcAddr) & rule.SrcMask) != 0 {
-				continue
-			}
-			if ((rule.DstAddr ^ ipv4.DstAddr) & rule.DstMask) != 0 {
-				continue
-			}
-			if ((rule.L4.ID ^ ipv4.NextProtoID) & rule.L4.IDMask) != 0 {
-				continue
-			}
-			if rule.L4.valid {
-				pkt.ParseL4ForIPv4()
-				if !pkt.l4ACL(&rule.L4) {
-					continue
+		if rules.trie4 != nil {
+			var c aclCandidates
+			src, dst := ipv4Bytes(ipv4.SrcAddr), ipv4Bytes(ipv4.DstAddr)
+			rules.trie4.candidates(src[:], dst[:], &c)
+			for i, ok := c.next(); ok; i, ok = c.next() {
+				if pkt.l3ACLRule4(ipv4, &rules.ip4[i]) {
+					return rules.ip4[i].OutputNumber
 				}
 			}
-			return rule.OutputNumber
+			return 0
+		}
+		for i := range rules.ip4 {
+			if pkt.l3ACLRule4(ipv4, &rules.ip4[i]) {
+				return rules.ip4[i].OutputNumber
+			}
 		}
 	} else if ipv6 != nil {
-	IPv6:
-		for _, rule := range rules.ip6 {
-			for i := 0; i < 16; i++ {
-				if ((rule.SrcAddr[i]^ipv6.SrcAddr[i])&rule.SrcMask[i] != 0) ||
-					((rule.DstAddr[i]^ipv6.DstAddr[i])&rule.DstMask[i] != 0) {
-					continue IPv6
+		// L4 protocol is taken after extension headers. Packets with
+		// malformed extension headers match only rules with ANY protocol
+		// and ports, non-first fragments don't match rules with ports.
+		proto, l4ok := pkt.ParseL4ForIPv6Ext()
+		l4ok = l4ok && proto != types.IPv6FragmentNumber
+		if rules.trie6 != nil {
+			var c aclCandidates
+			rules.trie6.candidates(ipv6.SrcAddr[:], ipv6.DstAddr[:], &c)
+			for i, ok := c.next(); ok; i, ok = c.next() {
+				if pkt.l3ACLRule6(ipv6, proto, l4ok, &rules.ip6[i]) {
+					return rules.ip6[i].OutputNumber
 				}
 			}
-			if ((rule.L4.ID ^ ipv6.Proto) & rule.L4.IDMask) != 0 {
-				continue
-			}
-			pkt.ParseL4ForIPv6()
-			if !pkt.l4ACL(&rule.L4) {
-				continue
+			return 0
+		}
+		for i := range rules.ip6 {
+			if pkt.l3ACLRule6(ipv6, proto, l4ok, &rules.ip6[i]) {
+				return rules.ip6[i].OutputNumber
 			}
-			return rule.OutputNumber
 		}
-	} else {
-		return 0
 	}
 	return 0
 }
+
+func (pkt *Packet) l3ACLRule4(ipv4 *IPv4Hdr, rule *l3Rules4) bool {
+	if !addr4Matches(ipv4.SrcAddr, rule.SrcAddr, rule.SrcMask, rule.SrcList, rule.SrcNot) {
+		return false
+	}
+	if !addr4Matches(ipv4.DstAddr, rule.DstAddr, rule.DstMask, rule.DstList, rule.DstNot) {
+		return false
+	}
+	if ((rule.L4.ID ^ ipv4.NextProtoID) & rule.L4.IDMask) != 0 {
+		return false
+	}
+	if rule.L4.valid || rule.L7 != nil {
+		pkt.ParseL4ForIPv4()
+	}
+	if rule.L4.valid && !pkt.l4ACL(&rule.L4) {
+		return false
+	}
+	return rule.L7 == nil || pkt.l7ACL(ipv4.NextProtoID, rule.L7)
+}
+
+func (pkt *Packet) l3ACLRule6(ipv6 *IPv6Hdr, proto uint8, l4ok bool, rule *l3Rules6) bool {
+	if !addr6Matches(ipv6.SrcAddr, rule.SrcAddr, rule.SrcMask, rule.SrcList, rule.SrcNot) ||
+		!addr6Matches(ipv6.DstAddr, rule.DstAddr, rule.DstMask, rule.DstList, rule.DstNot) {
+		return false
+	}
+	if ((rule.L4.ID ^ proto) & rule.L4.IDMask) != 0 {
+		return false
+	}
+	if rule.L4.valid && (!l4ok || !pkt.l4ACL(&rule.L4)) {
+		return false
+	}
+	return rule.L7 == nil || l4ok && pkt.l7ACL(proto, rule.L7)
+}
+
+// l7ACL checks the first L7ACLDepth bytes of payload after TCP, UDP or
+// ICMP header. L4 should be parsed.
+func (pkt *Packet) l7ACL(proto uint8, L7 *l7Rules) bool {
+	switch proto {
+	case types.TCPNumber, types.UDPNumber, types.ICMPNumber, types.ICMPv6Number:
+		pkt.ParseL7(uint(proto))
+	default:
+		return false
+	}
+	data, ok := pkt.GetL7Bytes()
+	if !ok {
+		return false
+	}
+	if len(data) > L7ACLDepth {
+		data = data[:L7ACLDepth]
+	}
+	if L7.Regexp != nil {
+		return L7.Regexp.Match(data)
+	}
+	return bytes.Contains(data, L7.Substring)
+}
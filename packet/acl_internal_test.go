@@ -331,6 +331,11 @@ func generateTestL3Rules(rulesCtxt rawL3RuleTestCtxt, orig, ipv4, ipv6 bool) []t
 					}
 					if ipv6 {
 						// Generate IPv6 rules
+						// ICMP rules match ICMPv6 in IPv6 packets
+						id6 := uint8(id.gtID.id)
+						if id6 == types.ICMPNumber {
+							id6 = types.ICMPv6Number
+						}
 						for _, src6 := range rulesCtxt.srcs6 {
 							for _, dst6 := range rulesCtxt.dsts6 {
 								ruleWant := l3Rules6{
@@ -341,7 +346,7 @@ func generateTestL3Rules(rulesCtxt rawL3RuleTestCtxt, orig, ipv4, ipv6 bool) []t
 									DstMask:      dst6.gtAddr6.mask,
 									L4: l4Rules{
 										IDMask:     uint8(id.gtID.idmsk),
-										ID:         uint8(id.gtID.id),
+										ID:         id6,
 										valid:      sport.gtPort.valid || dport.gtPort.valid,
 										SrcPortMin: sport.gtPort.min,
 										SrcPortMax: sport.gtPort.max,
@@ -496,6 +501,239 @@ func TestGetL3ACLFromTextTable(t *testing.T) {
 	removeIfEmpty(tmpdir)
 }
 
+func TestL3ACLReload(t *testing.T) {
+	acl := NewL3ACL(nil)
+	if rules := acl.Rules(); rules == nil || len(rules.ip4) != 0 || len(rules.ip6) != 0 {
+		t.Fatalf("NewL3ACL(nil) should have empty rules, got %+v", rules)
+	}
+
+	tmpdir := createTmpDir("tmpL3ACLReloadConfigs")
+	tmpfile := createTmpFile(tmpdir, "reload_tmp_test.orig")
+	if _, err := tmpfile.WriteString("# Rules\n10.0.0.0/8 ANY tcp ANY 80 Accept\nANY ANY ANY ANY ANY Reject\n"); err != nil {
+		log.Fatal(err)
+	}
+	if err := acl.ReloadFromTextTable(tmpfile.Name()); err != nil {
+		t.Fatalf("ReloadFromTextTable returned error %s", err)
+	}
+	loaded := acl.Rules()
+	if len(loaded.ip4) != 2 || loaded.ip4[0].OutputNumber != 1 || loaded.ip4[1].OutputNumber != 0 {
+		t.Errorf("Incorrect reloaded ipv4 rules: %+v", loaded.ip4)
+	}
+
+	badfile := createTmpFile(tmpdir, "reload_bad_tmp_test.orig")
+	if _, err := badfile.WriteString("10.0.0.0/8 ANY tcp\n"); err != nil {
+		log.Fatal(err)
+	}
+	if err := acl.ReloadFromTextTable(badfile.Name()); err == nil {
+		t.Errorf("ReloadFromTextTable should fail on incomplete rule")
+	}
+	if err := acl.ReloadFromJSON(badfile.Name()); err == nil {
+		t.Errorf("ReloadFromJSON should fail on text table")
+	}
+	if acl.Rules() != loaded {
+		t.Errorf("Rules should not be changed by failed reload")
+	}
+
+	if old := acl.SetRules(nil); old != loaded {
+		t.Errorf("SetRules should return previous rules")
+	}
+	if rules := acl.Rules(); rules == loaded || len(rules.ip4) != 0 {
+		t.Errorf("SetRules(nil) should set empty rules, got %+v", rules)
+	}
+
+	closeAndRemove(badfile)
+	closeAndRemove(tmpfile)
+	removeIfEmpty(tmpdir)
+}
+
+// Tests for lists and negation of addresses and ports in L3 rules
+func TestInternal_l3ACL_listsAndNegation(t *testing.T) {
+	pkt4 := getIPv4TCPTestPacket() // 127.0.0.1:1234 -> 128.9.9.5:5678
+	pkt6 := getIPv6TCPTestPacket() // dead::beaf:1234 -> dead::beaf:5678
+
+	tests := []struct {
+		src, dst, sport, dport string
+		ok4, ok6               bool
+	}{
+		{"10.0.0.0/8,127.0.0.0/8", "ANY", "ANY", "ANY", true, false},
+		{"!10.0.0.0/8,127.0.0.0/8", "ANY", "ANY", "ANY", false, false},
+		{"!10.0.0.0/8", "128.9.9.5/32", "ANY", "ANY", true, false},
+		{"ANY", "!128.9.0.0/16", "ANY", "ANY", false, false},
+		{"beef::/16,dead::/16", "ANY", "ANY", "ANY", false, true},
+		{"!dead::/16", "ANY", "ANY", "ANY", false, false},
+		{"ANY", "!beef::/16", "ANY", "ANY", false, true},
+		{"ANY", "ANY", "1000:2000", "!5678", false, false},
+		{"ANY", "ANY", "80,1234", "5000:5600,5678", true, true},
+		{"ANY", "ANY", "!1:1023", "ANY", true, true},
+		{"ANY", "ANY", "!1:1023,1234", "ANY", false, false},
+	}
+	for _, test := range tests {
+		raw := rawL3Rules{L3Rules: []rawL3Rule{{SrcAddr: test.src, DstAddr: test.dst, ID: "TCP",
+			SrcPort: test.sport, DstPort: test.dport, OutputNumber: "Accept"}}}
+		var rules L3Rules
+		if err := rawL3Parse(&raw, &rules); err != nil {
+			t.Errorf("Rule %+v returned error %s", test, err)
+			continue
+		}
+		if got := pkt4.L3ACLPermit(&rules); got != test.ok4 {
+			t.Errorf("Incorrect result for IPv4 packet and rule %+v: got %t", test, got)
+		}
+		if got := pkt6.L3ACLPermit(&rules); got != test.ok6 {
+			t.Errorf("Incorrect result for IPv6 packet and rule %+v: got %t", test, got)
+		}
+	}
+
+	incorrect := []rawL3Rule{
+		{SrcAddr: "!ANY", DstAddr: "ANY", ID: "ANY", SrcPort: "ANY", DstPort: "ANY"},
+		{SrcAddr: "10.0.0.0/8,dead::/16", DstAddr: "ANY", ID: "ANY", SrcPort: "ANY", DstPort: "ANY"},
+		{SrcAddr: "10.0.0.0", DstAddr: "ANY", ID: "ANY", SrcPort: "ANY", DstPort: "ANY"},
+		{SrcAddr: "ANY", DstAddr: "ANY", ID: "TCP", SrcPort: "!ANY", DstPort: "ANY"},
+		{SrcAddr: "ANY", DstAddr: "ANY", ID: "TCP", SrcPort: "80,ANY", DstPort: "ANY"},
+		{SrcAddr: "ANY", DstAddr: "ANY", ID: "TCP", SrcPort: "ANY", DstPort: "80,"},
+	}
+	for _, rule := range incorrect {
+		rule.OutputNumber = "Accept"
+		var rules L3Rules
+		if err := rawL3Parse(&rawL3Rules{L3Rules: []rawL3Rule{rule}}, &rules); err == nil {
+			t.Errorf("Rule %+v should return error", rule)
+		}
+	}
+}
+
+// Tests for ICMP and ICMPv6 rules and IPv6 packets with extension headers
+func TestInternal_l3ACL_dualStack(t *testing.T) {
+	pkt4 := getIPv4ICMPTestPacket()
+	pkt6 := getIPv6ICMPTestPacket()
+
+	// IPv6 TCP packet to port 80 with destination options header
+	src, dst := types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 1}, types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 2}
+	frame, err := NewBuilder().Ether(types.MACAddress{}, types.MACAddress{}).IPv6(src, dst).
+		TCP(1234, 80, 1, 0, types.TCPFlagSyn).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l3 := types.EtherLen
+	l4 := l3 + types.IPv6Len
+	ext := append(append(append([]byte(nil), frame[:l4]...), types.TCPNumber, 0, 1, 4, 0, 0, 0, 0), frame[l4:]...)
+	ext[l3+6] = types.IPv6DestOptsNumber
+	ext[l3+5] += 8
+	pktExt := getPacket()
+	if !GeneratePacketFromByte(pktExt, ext) {
+		t.Fatal("Can't generate packet with extension header")
+	}
+
+	tests := []struct {
+		src, dst, id, dport string
+		ok4, ok6, okExt     bool
+	}{
+		{"ANY", "ANY", "ICMP", "ANY", true, true, false},
+		{"ANY", "ANY", "ICMPv6", "ANY", false, true, false},
+		{"ANY", "dead::/16", "ICMP", "ANY", false, true, false},
+		{"ANY", "ANY", "TCP", "80", false, false, true},
+		{"2001:db8::/32", "ANY", "TCP", "443,80", false, false, true},
+		{"ANY", "ANY", "TCP", "81", false, false, false},
+		{"ANY", "ANY", "UDP", "ANY", false, false, false},
+	}
+	for _, test := range tests {
+		raw := rawL3Rules{L3Rules: []rawL3Rule{{SrcAddr: test.src, DstAddr: test.dst, ID: test.id,
+			SrcPort: "ANY", DstPort: test.dport, OutputNumber: "Accept"}}}
+		var rules L3Rules
+		if err := rawL3Parse(&raw, &rules); err != nil {
+			t.Errorf("Rule %+v returned error %s", test, err)
+			continue
+		}
+		if got := pkt4.L3ACLPermit(&rules); got != test.ok4 {
+			t.Errorf("Incorrect result for IPv4 packet and rule %+v: got %t", test, got)
+		}
+		if got := pkt6.L3ACLPermit(&rules); got != test.ok6 {
+			t.Errorf("Incorrect result for IPv6 packet and rule %+v: got %t", test, got)
+		}
+		if got := pktExt.L3ACLPermit(&rules); got != test.okExt {
+			t.Errorf("Incorrect result for IPv6 packet with extension header and rule %+v: got %t", test, got)
+		}
+	}
+
+	var rules L3Rules
+	raw := rawL3Rules{L3Rules: []rawL3Rule{{SrcAddr: "10.0.0.0/8", DstAddr: "ANY", ID: "ICMPv6",
+		SrcPort: "ANY", DstPort: "ANY", OutputNumber: "Accept"}}}
+	if err := rawL3Parse(&raw, &rules); err == nil {
+		t.Errorf("ICMPv6 rule with IPv4 address should return error")
+	}
+}
+
+// Tests for payload conditions of L3 rules
+func TestInternal_l3ACL_payload(t *testing.T) {
+	var mac types.MACAddress
+	src4, dst4 := types.BytesToIPv4(10, 0, 0, 1), types.BytesToIPv4(10, 0, 0, 2)
+	src6, dst6 := types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 1}, types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 2}
+	deep := append(make([]byte, L7ACLDepth), "www.example.com"...)
+	builders := []*Builder{
+		NewBuilder().Ether(mac, mac).IPv4(src4, dst4).TCP(1234, 80, 1, 0, types.TCPFlagAck).
+			Payload([]byte("GET / HTTP/1.1\r\nHost: www.example.com\r\n\r\n")),
+		NewBuilder().Ether(mac, mac).IPv6(src6, dst6).UDP(1234, 53).
+			Payload([]byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x03www\x07example\x03org\x00\x00\x01\x00\x01")),
+		NewBuilder().Ether(mac, mac).IPv4(src4, dst4).TCP(1234, 80, 1, 0, types.TCPFlagAck).Payload(deep),
+	}
+	pkts := make([]*Packet, len(builders))
+	for i := range builders {
+		pkts[i] = getPacket()
+		if err := builders[i].BuildTo(pkts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		id, payload string
+		ok          []bool
+	}{
+		{"ANY", "ANY", []bool{true, true, true}},
+		{"TCP", "example.com", []bool{true, false, false}},
+		{"ANY", "re:Host:\\s+[a-z.]+\\.example\\.com", []bool{true, false, false}},
+		{"ANY", "re:\\x07example\\x03(com|org)", []bool{false, true, false}},
+		{"ANY", `"Host:\x20www"`, []bool{true, false, false}},
+		{"UDP", "GET", []bool{false, false, false}},
+		{"ANY", "re:^POST", []bool{false, false, false}},
+	}
+	for _, test := range tests {
+		raw := rawL3Rules{L3Rules: []rawL3Rule{{SrcAddr: "ANY", DstAddr: "ANY", ID: test.id,
+			SrcPort: "ANY", DstPort: "ANY", OutputNumber: "Accept", Payload: test.payload}}}
+		var rules L3Rules
+		if err := rawL3Parse(&raw, &rules); err != nil {
+			t.Errorf("Rule %+v returned error %s", test, err)
+			continue
+		}
+		for i := range pkts {
+			if got := pkts[i].L3ACLPermit(&rules); got != test.ok[i] {
+				t.Errorf("Incorrect result for packet %d and rule %+v: got %t", i, test, got)
+			}
+		}
+	}
+
+	for _, payload := range []string{"re:(", `"unterminated`, `""`} {
+		raw := rawL3Rules{L3Rules: []rawL3Rule{{SrcAddr: "ANY", DstAddr: "ANY", ID: "ANY",
+			SrcPort: "ANY", DstPort: "ANY", OutputNumber: "Accept", Payload: payload}}}
+		var rules L3Rules
+		if err := rawL3Parse(&raw, &rules); err == nil {
+			t.Errorf("Payload %s should return error", payload)
+		}
+	}
+
+	tmpdir := createTmpDir("tmpL3ACLPayloadConfigs")
+	tmpfile := createTmpFile(tmpdir, "payload_tmp_test.orig")
+	if _, err := tmpfile.WriteString("ANY ANY TCP ANY 443 Reject example.com\nANY ANY ANY ANY ANY Accept\n"); err != nil {
+		log.Fatal(err)
+	}
+	rules, err := GetL3ACLFromTextTable(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("GetL3ACLFromTextTable returned error %s", err)
+	}
+	if rules.ip4[0].L7 == nil || string(rules.ip4[0].L7.Substring) != "example.com" || rules.ip4[1].L7 != nil {
+		t.Errorf("Incorrect payload conditions of parsed rules: %+v", rules.ip4)
+	}
+	closeAndRemove(tmpfile)
+	removeIfEmpty(tmpdir)
+}
+
 // Tests for l4ACL internal function on TCP packet
 // This functions is called only inside l3ACL, so we need to test it standalone first
 func TestInternal_l4ACL_packetIPv4_TCP(t *testing.T) {
@@ -0,0 +1,219 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Rule sets with more rules of one IP version are compiled to tries
+// by rules parsers. Smaller sets are checked linearly.
+const l3ACLTrieThreshold = 64
+
+// Maximum number of rule lists which are found by trie lookup: root
+// and one list for each byte of IPv6 address.
+const aclTrieMaxLists = types.IPv6AddrLen + 1
+
+// aclTrieNode is a node of multi-bit trie with 8 bit stride. Rules of
+// node are sorted indexes of rules with prefixes which contain all
+// addresses below node.
+type aclTrieNode struct {
+	rules    []int
+	children map[byte]*aclTrieNode
+}
+
+func (node *aclTrieNode) child(b byte) *aclTrieNode {
+	if node.children == nil {
+		node.children = make(map[byte]*aclTrieNode)
+	}
+	c := node.children[b]
+	if c == nil {
+		c = new(aclTrieNode)
+		node.children[b] = c
+	}
+	return c
+}
+
+// addRule adds index of rule to node. Rules are added in increasing
+// order, so duplicates of rules with several subnets are at the end.
+func (node *aclTrieNode) addRule(index int) {
+	if n := len(node.rules); n == 0 || node.rules[n-1] != index {
+		node.rules = append(node.rules, index)
+	}
+}
+
+// aclTrie finds rules which can match address field of packet. Rules
+// with negated addresses or non-prefix masks are stored in root, so
+// they are found for all addresses.
+type aclTrie struct {
+	root aclTrieNode
+}
+
+// add adds rule with address field matching subnet with given address
+// and mask in network byte order. Prefixes which end inside of byte
+// are expanded to all values of this byte.
+func (t *aclTrie) add(index int, addr, mask []byte) {
+	k := 0
+	for k < len(mask) && mask[k] == 0xff {
+		k++
+	}
+	for i := k + 1; i < len(mask); i++ {
+		if mask[i] != 0 {
+			t.root.addRule(index)
+			return
+		}
+	}
+	node := &t.root
+	for i := 0; i < k; i++ {
+		node = node.child(addr[i])
+	}
+	if k == len(mask) || mask[k] == 0 {
+		node.addRule(index)
+		return
+	}
+	for v := 0; v < 256; v++ {
+		if byte(v)&mask[k] == addr[k]&mask[k] {
+			node.child(byte(v)).addRule(index)
+		}
+	}
+}
+
+// lookup adds to c lists of rules which can match addr.
+func (t *aclTrie) lookup(addr []byte, c *aclCandidates) {
+	node := &t.root
+	c.add(node.rules)
+	for i := range addr {
+		if node = node.children[addr[i]]; node == nil {
+			return
+		}
+		c.add(node.rules)
+	}
+}
+
+// aclCandidates iterates over union of sorted lists of rule indexes
+// in increasing order.
+type aclCandidates struct {
+	lists [aclTrieMaxLists][]int
+	n     int
+	count int
+}
+
+func (c *aclCandidates) add(rules []int) {
+	if len(rules) != 0 {
+		c.lists[c.n] = rules
+		c.n++
+		c.count += len(rules)
+	}
+}
+
+// next returns the least index which wasn't returned yet and false
+// when all indexes are returned.
+func (c *aclCandidates) next() (int, bool) {
+	min := -1
+	for i := 0; i < c.n; i++ {
+		if len(c.lists[i]) != 0 && (min < 0 || c.lists[i][0] < min) {
+			min = c.lists[i][0]
+		}
+	}
+	if min < 0 {
+		return 0, false
+	}
+	for i := 0; i < c.n; i++ {
+		if len(c.lists[i]) != 0 && c.lists[i][0] == min {
+			c.lists[i] = c.lists[i][1:]
+		}
+	}
+	return min, true
+}
+
+// l3ACLTrie finds rules which can match packet by source and by
+// destination addresses. Candidates of the address which gives less of
+// them are checked by all fields of rules in order of rules, so the
+// first matching rule is found like by linear check.
+type l3ACLTrie struct {
+	src aclTrie
+	dst aclTrie
+}
+
+func (t *l3ACLTrie) candidates(src, dst []byte, c *aclCandidates) {
+	var d aclCandidates
+	t.src.lookup(src, c)
+	t.dst.lookup(dst, &d)
+	if d.count < c.count {
+		*c = d
+	}
+}
+
+func ipv4Bytes(addr types.IPv4Address) [types.IPv4AddrLen]byte {
+	var b [types.IPv4AddrLen]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(addr))
+	return b
+}
+
+func addSubnet4(t *aclTrie, index int, addr, mask types.IPv4Address) {
+	a, m := ipv4Bytes(addr), ipv4Bytes(mask)
+	t.add(index, a[:], m[:])
+}
+
+func newL3ACLTrie4(rules []l3Rules4) *l3ACLTrie {
+	t := new(l3ACLTrie)
+	for i := range rules {
+		r := &rules[i]
+		if r.SrcNot {
+			t.src.root.addRule(i)
+		} else {
+			addSubnet4(&t.src, i, r.SrcAddr, r.SrcMask)
+			for _, sn := range r.SrcList {
+				addSubnet4(&t.src, i, sn.Addr, sn.Mask)
+			}
+		}
+		if r.DstNot {
+			t.dst.root.addRule(i)
+		} else {
+			addSubnet4(&t.dst, i, r.DstAddr, r.DstMask)
+			for _, sn := range r.DstList {
+				addSubnet4(&t.dst, i, sn.Addr, sn.Mask)
+			}
+		}
+	}
+	return t
+}
+
+func newL3ACLTrie6(rules []l3Rules6) *l3ACLTrie {
+	t := new(l3ACLTrie)
+	for i := range rules {
+		r := &rules[i]
+		if r.SrcNot {
+			t.src.root.addRule(i)
+		} else {
+			t.src.add(i, r.SrcAddr[:], r.SrcMask[:])
+			for j := range r.SrcList {
+				t.src.add(i, r.SrcList[j].Addr[:], r.SrcList[j].Mask[:])
+			}
+		}
+		if r.DstNot {
+			t.dst.root.addRule(i)
+		} else {
+			t.dst.add(i, r.DstAddr[:], r.DstMask[:])
+			for j := range r.DstList {
+				t.dst.add(i, r.DstList[j].Addr[:], r.DstList[j].Mask[:])
+			}
+		}
+	}
+	return t
+}
+
+// compile builds tries of rules if there are many of them.
+func (rules *L3Rules) compile() {
+	rules.trie4, rules.trie6 = nil, nil
+	if len(rules.ip4) > l3ACLTrieThreshold {
+		rules.trie4 = newL3ACLTrie4(rules.ip4)
+	}
+	if len(rules.ip6) > l3ACLTrieThreshold {
+		rules.trie6 = newL3ACLTrie6(rules.ip6)
+	}
+}
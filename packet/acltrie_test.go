@@ -0,0 +1,118 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+// Addresses and ports are generated from small ranges, so that rules
+// overlap and packets match several of them.
+func randomACLSubnet(rng *rand.Rand, ipv4 bool) string {
+	if ipv4 {
+		lens := []int{8, 12, 16, 20, 23, 24, 28, 30, 32}
+		return fmt.Sprintf("10.%d.%d.%d/%d", rng.Intn(4), rng.Intn(4), rng.Intn(256), lens[rng.Intn(len(lens))])
+	}
+	lens := []int{16, 32, 44, 48, 64, 100, 126, 128}
+	return fmt.Sprintf("2001:db8:%x::%x/%d", rng.Intn(4)<<12, rng.Intn(256), lens[rng.Intn(len(lens))])
+}
+
+func randomACLAddr(rng *rand.Rand, ipv4 bool) string {
+	if rng.Intn(5) == 0 {
+		return "ANY"
+	}
+	subnets := []string{randomACLSubnet(rng, ipv4)}
+	for rng.Intn(4) == 0 {
+		subnets = append(subnets, randomACLSubnet(rng, ipv4))
+	}
+	addr := strings.Join(subnets, ",")
+	if rng.Intn(8) == 0 {
+		addr = "!" + addr
+	}
+	return addr
+}
+
+func randomACLPort(rng *rand.Rand) string {
+	switch rng.Intn(4) {
+	case 0:
+		return fmt.Sprint(rng.Intn(8))
+	case 1:
+		min := rng.Intn(8)
+		return fmt.Sprintf("!%d:%d", min, min+rng.Intn(4))
+	default:
+		return "ANY"
+	}
+}
+
+func randomACLRules(rng *rand.Rand, n int, ipv4 bool) *rawL3Rules {
+	ids := []string{"ANY", "TCP", "UDP"}
+	outputs := []string{"Accept", "Reject", "2", "3"}
+	rules := new(rawL3Rules)
+	for i := 0; i < n; i++ {
+		rules.L3Rules = append(rules.L3Rules, rawL3Rule{
+			SrcAddr:      randomACLAddr(rng, ipv4),
+			DstAddr:      randomACLAddr(rng, ipv4),
+			ID:           ids[rng.Intn(len(ids))],
+			SrcPort:      randomACLPort(rng),
+			DstPort:      randomACLPort(rng),
+			OutputNumber: outputs[rng.Intn(len(outputs))],
+		})
+	}
+	return rules
+}
+
+func setRandomACLPorts(rng *rand.Rand, pkt *Packet) {
+	l4 := (*UDPHdr)(pkt.L4)
+	l4.SrcPort = SwapBytesUint16(uint16(rng.Intn(12)))
+	l4.DstPort = SwapBytesUint16(uint16(rng.Intn(12)))
+}
+
+// Compiled rules should give the same result as linear check of rules.
+func TestL3ACLTrie(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var rules L3Rules
+	raw := randomACLRules(rng, 500, true)
+	raw.L3Rules = append(raw.L3Rules, randomACLRules(rng, 500, false).L3Rules...)
+	if err := rawL3Parse(raw, &rules); err != nil {
+		t.Fatal(err)
+	}
+	if rules.trie4 == nil || rules.trie6 == nil {
+		t.Fatal("Large rule sets should be compiled")
+	}
+	linear := L3Rules{ip4: rules.ip4, ip6: rules.ip6}
+
+	pkt4 := getIPv4TCPTestPacket()
+	for i := 0; i < 10000; i++ {
+		ipv4 := pkt4.GetIPv4()
+		ipv4.SrcAddr = types.BytesToIPv4(10, byte(rng.Intn(4)), byte(rng.Intn(4)), byte(rng.Intn(256)))
+		ipv4.DstAddr = types.BytesToIPv4(10, byte(rng.Intn(4)), byte(rng.Intn(4)), byte(rng.Intn(256)))
+		setRandomACLPorts(rng, pkt4)
+		got, want := pkt4.L3ACLPort(&rules), pkt4.L3ACLPort(&linear)
+		if got != want {
+			t.Fatalf("Incorrect result for %v -> %v: got %d, want %d", ipv4.SrcAddr, ipv4.DstAddr, got, want)
+		}
+	}
+
+	pkt6 := getIPv6TCPTestPacket()
+	for i := 0; i < 10000; i++ {
+		ipv6 := pkt6.GetIPv6()
+		ipv6.SrcAddr = types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 4: byte(rng.Intn(4) << 4), 15: byte(rng.Intn(256))}
+		ipv6.DstAddr = types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 4: byte(rng.Intn(4) << 4), 15: byte(rng.Intn(256))}
+		setRandomACLPorts(rng, pkt6)
+		got, want := pkt6.L3ACLPort(&rules), pkt6.L3ACLPort(&linear)
+		if got != want {
+			t.Fatalf("Incorrect result for %v -> %v: got %d, want %d", ipv6.SrcAddr, ipv6.DstAddr, got, want)
+		}
+	}
+}
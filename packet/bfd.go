@@ -0,0 +1,190 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// BFD constants (RFC 5880 and RFC 5881)
+const (
+	// Destination port of single hop control packets
+	UDPPortBFD     = 3784
+	SwapUDPPortBFD = 0xc80e
+	// Source ports of control packets are in range from
+	// BFDMinSrcPort to 65535
+	BFDMinSrcPort = 49152
+
+	BFDVersion = 1
+	// TTL or hop limit of single hop control packets
+	BFDTTL = 255
+
+	BFDStateAdminDown = 0
+	BFDStateDown      = 1
+	BFDStateInit      = 2
+	BFDStateUp        = 3
+
+	BFDDiagNone                  = 0
+	BFDDiagDetectionTimeExpired  = 1
+	BFDDiagEchoFailed            = 2
+	BFDDiagNeighborDown          = 3
+	BFDDiagForwardingPlaneReset  = 4
+	BFDDiagPathDown              = 5
+	BFDDiagConcatenatedPathDown  = 6
+	BFDDiagAdministrativelyDown  = 7
+	BFDDiagReverseConcatPathDown = 8
+
+	BFDFlagPoll           = 0x20
+	BFDFlagFinal          = 0x10
+	BFDFlagCPIndependent  = 0x08
+	BFDFlagAuthentication = 0x04
+	BFDFlagDemand         = 0x02
+	BFDFlagMultipoint     = 0x01
+
+	bfdLen      = 24
+	bfdDiagMask = 0x1f
+	bfdFlagMask = 0x3f
+)
+
+// BFDControl is a BFD control packet without authentication section.
+// Intervals have precision of microseconds.
+type BFDControl struct {
+	Diag                      uint8
+	State                     uint8
+	Flags                     uint8
+	DetectMult                uint8
+	MyDiscriminator           uint32
+	YourDiscriminator         uint32
+	DesiredMinTxInterval      time.Duration
+	RequiredMinRxInterval     time.Duration
+	RequiredMinEchoRxInterval time.Duration
+}
+
+func (c *BFDControl) String() string {
+	return fmt.Sprintf("BFD: state = %d, diag = %d, flags = 0x%02x, multiplier = %d, my discriminator = %d, your discriminator = %d, min TX = %v, min RX = %v",
+		c.State, c.Diag, c.Flags, c.DetectMult, c.MyDiscriminator, c.YourDiscriminator,
+		c.DesiredMinTxInterval, c.RequiredMinRxInterval)
+}
+
+func bfdInterval(b []byte) time.Duration {
+	return time.Duration(binary.BigEndian.Uint32(b)) * time.Microsecond
+}
+
+func bfdMicroseconds(d time.Duration) uint32 {
+	us := d / time.Microsecond
+	if us > 0xffffffff {
+		return 0xffffffff
+	}
+	return uint32(us)
+}
+
+// ParseBFD fills c from BFD control packet. Returns false if packet
+// should be discarded according to RFC 5880, packets with
+// authentication are discarded because authentication isn't
+// supported.
+func ParseBFD(data []byte, c *BFDControl) bool {
+	*c = BFDControl{}
+	if len(data) < bfdLen || data[0]>>5 != BFDVersion || int(data[3]) < bfdLen || int(data[3]) > len(data) {
+		return false
+	}
+	c.Diag = data[0] & bfdDiagMask
+	c.State = data[1] >> 6
+	c.Flags = data[1] & bfdFlagMask
+	c.DetectMult = data[2]
+	c.MyDiscriminator = binary.BigEndian.Uint32(data[4:])
+	c.YourDiscriminator = binary.BigEndian.Uint32(data[8:])
+	c.DesiredMinTxInterval = bfdInterval(data[12:])
+	c.RequiredMinRxInterval = bfdInterval(data[16:])
+	c.RequiredMinEchoRxInterval = bfdInterval(data[20:])
+	if c.DetectMult == 0 || c.Flags&(BFDFlagMultipoint|BFDFlagAuthentication) != 0 || c.MyDiscriminator == 0 ||
+		c.YourDiscriminator == 0 && c.State != BFDStateDown && c.State != BFDStateAdminDown {
+		return false
+	}
+	return true
+}
+
+// AppendTo appends BFD control packet to b.
+func (c *BFDControl) AppendTo(b []byte) []byte {
+	var buf [bfdLen]byte
+	buf[0] = BFDVersion<<5 | c.Diag&bfdDiagMask
+	buf[1] = c.State<<6 | c.Flags&bfdFlagMask
+	buf[2] = c.DetectMult
+	buf[3] = bfdLen
+	binary.BigEndian.PutUint32(buf[4:], c.MyDiscriminator)
+	binary.BigEndian.PutUint32(buf[8:], c.YourDiscriminator)
+	binary.BigEndian.PutUint32(buf[12:], bfdMicroseconds(c.DesiredMinTxInterval))
+	binary.BigEndian.PutUint32(buf[16:], bfdMicroseconds(c.RequiredMinRxInterval))
+	binary.BigEndian.PutUint32(buf[20:], bfdMicroseconds(c.RequiredMinEchoRxInterval))
+	return append(b, buf[:]...)
+}
+
+// GetBFD returns BFD control packet of IPv4 or IPv6 UDP packet or nil
+// if packet isn't single hop BFD control packet or its TTL or hop
+// limit isn't 255. L3 header should be parsed. Control packet is a
+// slice of the first segment of packet.
+func (packet *Packet) GetBFD() []byte {
+	var data []byte
+	if ipv4 := packet.GetIPv4(); ipv4 != nil {
+		if ipv4.NextProtoID != types.UDPNumber || ipv4.TimeToLive != BFDTTL ||
+			SwapBytesUint16(ipv4.FragmentOffset)&(types.IPv4MoreFragments|types.IPv4FragmentOffsetMask) != 0 {
+			return nil
+		}
+		data = l3Payload(packet, uint(ipv4.VersionIhl&0x0f)<<2, uint(SwapBytesUint16(ipv4.TotalLength)))
+	} else if ipv6 := packet.GetIPv6(); ipv6 != nil {
+		if ipv6.Proto != types.UDPNumber || ipv6.HopLimits != BFDTTL {
+			return nil
+		}
+		data = l3Payload(packet, types.IPv6Len, types.IPv6Len+uint(SwapBytesUint16(ipv6.PayloadLen)))
+	}
+	if len(data) < types.UDPLen {
+		return nil
+	}
+	udp := (*UDPHdr)(unsafe.Pointer(&data[0]))
+	length := int(SwapBytesUint16(udp.DgramLen))
+	if udp.DstPort != SwapUDPPortBFD || length < types.UDPLen || length > len(data) {
+		return nil
+	}
+	return data[types.UDPLen:length]
+}
+
+// InitBFDPacket initializes empty packet as single hop BFD control
+// packet over IPv4 with UDP checksum. Returns false if packet can't be
+// initialized.
+func InitBFDPacket(packet *Packet, srcMAC, dstMAC types.MACAddress, srcIP, dstIP types.IPv4Address, srcPort uint16, c *BFDControl) bool {
+	frame := make([]byte, types.EtherLen+types.IPv4MinLen+types.UDPLen, 128)
+	copy(frame, dstMAC[:])
+	copy(frame[types.EtherAddrLen:], srcMAC[:])
+	frame[12], frame[13] = types.IPV4Number>>8, types.IPV4Number&0xff
+	frame = c.AppendTo(frame)
+	ipv4 := (*IPv4Hdr)(unsafe.Pointer(&frame[types.EtherLen]))
+	*ipv4 = IPv4Hdr{
+		VersionIhl:    0x45,
+		TypeOfService: 0xc0,
+		TotalLength:   SwapBytesUint16(uint16(len(frame) - types.EtherLen)),
+		TimeToLive:    BFDTTL,
+		NextProtoID:   types.UDPNumber,
+		SrcAddr:       srcIP,
+		DstAddr:       dstIP,
+	}
+	ipv4.HdrChecksum = SwapBytesUint16(CalculateIPv4Checksum(ipv4))
+	data := frame[types.EtherLen+types.IPv4MinLen:]
+	udp := (*UDPHdr)(unsafe.Pointer(&data[0]))
+	*udp = UDPHdr{
+		SrcPort:  SwapBytesUint16(srcPort),
+		DstPort:  SwapUDPPortBFD,
+		DgramLen: SwapBytesUint16(uint16(len(data))),
+	}
+	cksum := multicastChecksum(data, pseudoHdrChecksum(unsafe.Pointer(ipv4), true, types.UDPNumber, uint(len(data))))
+	if cksum == 0 {
+		cksum = 0xffff
+	}
+	udp.DgramCksum = SwapBytesUint16(cksum)
+	return GeneratePacketFromByte(packet, frame)
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+var testBFDControl = BFDControl{
+	State:                 BFDStateUp,
+	Flags:                 BFDFlagPoll,
+	DetectMult:            3,
+	MyDiscriminator:       0x12345678,
+	YourDiscriminator:     0x9abcdef0,
+	DesiredMinTxInterval:  50 * time.Millisecond,
+	RequiredMinRxInterval: 100 * time.Millisecond,
+}
+
+func TestBFDAppendParse(t *testing.T) {
+	data := testBFDControl.AppendTo(nil)
+	if len(data) != bfdLen || data[0] != 0x20 || data[1] != 0xe0 {
+		t.Fatalf("Wrong BFD control packet %x", data)
+	}
+	var c BFDControl
+	if !ParseBFD(data, &c) || c != testBFDControl {
+		t.Errorf("Parsed %v instead of %v", &c, &testBFDControl)
+	}
+
+	bad := []BFDControl{testBFDControl, testBFDControl, testBFDControl, testBFDControl}
+	bad[0].DetectMult = 0
+	bad[1].MyDiscriminator = 0
+	bad[2].YourDiscriminator = 0
+	bad[3].Flags |= BFDFlagMultipoint
+	for i := range bad {
+		if ParseBFD(bad[i].AppendTo(nil), &c) {
+			t.Errorf("Invalid control packet %v was parsed", &bad[i])
+		}
+	}
+	// Your discriminator is zero while session is down
+	down := testBFDControl
+	down.State = BFDStateDown
+	down.YourDiscriminator = 0
+	if !ParseBFD(down.AppendTo(nil), &c) {
+		t.Error("Control packet of down session wasn't parsed")
+	}
+	if ParseBFD(data[:bfdLen-1], &c) {
+		t.Error("Truncated control packet was parsed")
+	}
+}
+
+func TestBFDPacket(t *testing.T) {
+	srcMAC := types.MACAddress{0x02, 0, 0, 0, 0, 0x01}
+	dstMAC := types.MACAddress{0x02, 0, 0, 0, 0, 0x02}
+	srcIP := types.BytesToIPv4(192, 0, 2, 1)
+	dstIP := types.BytesToIPv4(192, 0, 2, 2)
+	pkt := getPacket()
+	if !InitBFDPacket(pkt, srcMAC, dstMAC, srcIP, dstIP, BFDMinSrcPort, &testBFDControl) {
+		t.Fatal("InitBFDPacket failed")
+	}
+	pkt.ParseL3()
+	ipv4 := pkt.GetIPv4()
+	pkt.ParseL4ForIPv4()
+	udp := pkt.GetUDPForIPv4()
+	if ipv4 == nil || udp == nil || ipv4.TimeToLive != BFDTTL || udp.DstPort != SwapUDPPortBFD {
+		t.Fatalf("Wrong BFD packet %x", pkt.GetRawPacketBytes())
+	}
+	if CalculateIPv4UDPChecksum(ipv4, udp, unsafe.Pointer(uintptr(unsafe.Pointer(udp))+types.UDPLen)) != SwapBytesUint16(udp.DgramCksum) {
+		t.Error("Wrong UDP checksum")
+	}
+	var c BFDControl
+	if !ParseBFD(pkt.GetBFD(), &c) || c != testBFDControl {
+		t.Errorf("Can't parse BFD packet %x", pkt.GetRawPacketBytes())
+	}
+	ipv4.TimeToLive--
+	if pkt.GetBFD() != nil {
+		t.Error("GetBFD returned control packet which was forwarded")
+	}
+}
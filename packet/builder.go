@@ -0,0 +1,331 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+	"unsafe"
+
+	. "github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/types"
+)
+
+// Default values of headers added by Builder
+const (
+	builderTTL       = 64
+	builderTCPWindow = 0xffff
+)
+
+// Builder builds packets from headers which are added from L2 to
+// payload, for example:
+//
+//	pkt, err := packet.NewBuilder().Ether(srcMAC, dstMAC).IPv4(srcIP, dstIP).UDP(srcPort, dstPort).Payload(data).Build()
+//
+// EtherTypes, protocols, lengths and checksums are filled when packet
+// is built. The first error of adding headers is returned by Build,
+// BuildTo and Bytes. Builder can build several identical packets.
+type Builder struct {
+	frame []byte
+	// Offset of EtherType of the last L2 header
+	etherType int
+	// Offsets of L3 and L4 headers, zero if they aren't added
+	l3, l4    int
+	l3Type    uint16
+	l4Proto   uint8
+	hasL2     bool
+	noHeaders bool
+	err       error
+}
+
+// NewBuilder returns builder of empty packet.
+func NewBuilder() *Builder {
+	return &Builder{frame: make([]byte, 0, 128)}
+}
+
+func (b *Builder) fail(msg string) *Builder {
+	if b.err == nil {
+		b.err = WrapWithNFError(nil, msg, BadArgument)
+	}
+	return b
+}
+
+func (b *Builder) setEtherType(etherType uint16) {
+	binary.BigEndian.PutUint16(b.frame[b.etherType:], etherType)
+}
+
+// Ether adds Ethernet header from src to dst. It should be the first
+// header of packet.
+func (b *Builder) Ether(src, dst types.MACAddress) *Builder {
+	if len(b.frame) != 0 {
+		return b.fail("Ethernet header should be the first header of packet")
+	}
+	b.frame = append(b.frame, dst[:]...)
+	b.frame = append(b.frame, src[:]...)
+	b.etherType = len(b.frame)
+	b.frame = append(b.frame, 0, 0)
+	b.hasL2 = true
+	return b
+}
+
+// VLAN adds VLAN tag with given TCI after Ethernet header or other
+// VLAN tag.
+func (b *Builder) VLAN(tci uint16) *Builder {
+	if !b.hasL2 || b.l3 != 0 || b.noHeaders {
+		return b.fail("VLAN tag should follow Ethernet header or other VLAN tag")
+	}
+	b.setEtherType(types.VLANNumber)
+	b.frame = append(b.frame, byte(tci>>8), byte(tci), 0, 0)
+	b.etherType = len(b.frame) - 2
+	return b
+}
+
+func (b *Builder) addL3(etherType uint16, length int) bool {
+	if !b.hasL2 || b.l3 != 0 || b.noHeaders {
+		b.fail("L3 header should follow Ethernet header or VLAN tag")
+		return false
+	}
+	b.setEtherType(etherType)
+	b.l3 = len(b.frame)
+	b.l3Type = etherType
+	b.frame = append(b.frame, make([]byte, length)...)
+	return true
+}
+
+func (b *Builder) ipv4() *IPv4Hdr {
+	return (*IPv4Hdr)(unsafe.Pointer(&b.frame[b.l3]))
+}
+
+func (b *Builder) ipv6() *IPv6Hdr {
+	return (*IPv6Hdr)(unsafe.Pointer(&b.frame[b.l3]))
+}
+
+// IPv4 adds IPv4 header from src to dst with TTL 64.
+func (b *Builder) IPv4(src, dst types.IPv4Address) *Builder {
+	if b.addL3(types.IPV4Number, types.IPv4MinLen) {
+		*b.ipv4() = IPv4Hdr{
+			VersionIhl:  types.IPv4VersionIhl,
+			TimeToLive:  builderTTL,
+			NextProtoID: types.NoNextHeader,
+			SrcAddr:     src,
+			DstAddr:     dst,
+		}
+	}
+	return b
+}
+
+// IPv6 adds IPv6 header from src to dst with hop limit 64.
+func (b *Builder) IPv6(src, dst types.IPv6Address) *Builder {
+	if b.addL3(types.IPV6Number, types.IPv6Len) {
+		*b.ipv6() = IPv6Hdr{
+			VtcFlow:   types.IPv6VtcFlow,
+			Proto:     types.NoNextHeader,
+			HopLimits: builderTTL,
+			SrcAddr:   src,
+			DstAddr:   dst,
+		}
+	}
+	return b
+}
+
+// TTL sets TTL of IPv4 header or hop limit of IPv6 header.
+func (b *Builder) TTL(ttl uint8) *Builder {
+	switch b.l3Type {
+	case types.IPV4Number:
+		b.ipv4().TimeToLive = ttl
+	case types.IPV6Number:
+		b.ipv6().HopLimits = ttl
+	default:
+		return b.fail("TTL can be set only after IPv4 or IPv6 header")
+	}
+	return b
+}
+
+// ARP adds IPv4 ARP header with given operation after Ethernet header
+// or VLAN tag. Other headers can't be added after it.
+func (b *Builder) ARP(operation uint16, sha types.MACAddress, spa types.IPv4Address, tha types.MACAddress, tpa types.IPv4Address) *Builder {
+	if b.addL3(types.ARPNumber, types.ARPLen) {
+		*(*ARPHdr)(unsafe.Pointer(&b.frame[b.l3])) = ARPHdr{
+			HType:     SwapBytesUint16(1),
+			PType:     SwapBytesUint16(types.IPV4Number),
+			HLen:      types.EtherAddrLen,
+			PLen:      types.IPv4AddrLen,
+			Operation: SwapBytesUint16(operation),
+			SHA:       sha,
+			SPA:       types.IPv4ToBytes(spa),
+			THA:       tha,
+			TPA:       types.IPv4ToBytes(tpa),
+		}
+		b.noHeaders = true
+	}
+	return b
+}
+
+func (b *Builder) addL4(proto uint8, length int) bool {
+	if b.l4 != 0 || b.noHeaders {
+		b.fail("L4 header should follow IPv4 or IPv6 header")
+		return false
+	}
+	switch b.l3Type {
+	case types.IPV4Number:
+		b.ipv4().NextProtoID = proto
+	case types.IPV6Number:
+		b.ipv6().Proto = proto
+	default:
+		b.fail("L4 header should follow IPv4 or IPv6 header")
+		return false
+	}
+	b.l4 = len(b.frame)
+	b.l4Proto = proto
+	b.frame = append(b.frame, make([]byte, length)...)
+	return true
+}
+
+// UDP adds UDP header with given ports after IPv4 or IPv6 header.
+func (b *Builder) UDP(srcPort, dstPort uint16) *Builder {
+	if b.addL4(types.UDPNumber, types.UDPLen) {
+		*(*UDPHdr)(unsafe.Pointer(&b.frame[b.l4])) = UDPHdr{
+			SrcPort: SwapBytesUint16(srcPort),
+			DstPort: SwapBytesUint16(dstPort),
+		}
+	}
+	return b
+}
+
+// TCP adds TCP header without options after IPv4 or IPv6 header.
+func (b *Builder) TCP(srcPort, dstPort uint16, seq, ack uint32, flags types.TCPFlags) *Builder {
+	if b.addL4(types.TCPNumber, types.TCPMinLen) {
+		*(*TCPHdr)(unsafe.Pointer(&b.frame[b.l4])) = TCPHdr{
+			SrcPort:  SwapBytesUint16(srcPort),
+			DstPort:  SwapBytesUint16(dstPort),
+			SentSeq:  SwapBytesUint32(seq),
+			RecvAck:  SwapBytesUint32(ack),
+			DataOff:  types.TCPMinDataOffset,
+			TCPFlags: flags,
+			RxWin:    SwapBytesUint16(builderTCPWindow),
+		}
+	}
+	return b
+}
+
+// ICMP adds ICMP header after IPv4 header or ICMPv6 header after IPv6
+// header. Identifier and sequence number are used by echo messages,
+// they should be zero for other messages.
+func (b *Builder) ICMP(typ, code uint8, id, seq uint16) *Builder {
+	proto := uint8(types.ICMPNumber)
+	if b.l3Type == types.IPV6Number {
+		proto = types.ICMPv6Number
+	}
+	if b.addL4(proto, types.ICMPLen) {
+		*(*ICMPHdr)(unsafe.Pointer(&b.frame[b.l4])) = ICMPHdr{
+			Type:       typ,
+			Code:       code,
+			Identifier: SwapBytesUint16(id),
+			SeqNum:     SwapBytesUint16(seq),
+		}
+	}
+	return b
+}
+
+// Payload adds data after the last header. Headers can't be added
+// after payload.
+func (b *Builder) Payload(data []byte) *Builder {
+	if !b.hasL2 {
+		return b.fail("Payload should follow Ethernet header")
+	}
+	b.frame = append(b.frame, data...)
+	b.noHeaders = true
+	return b
+}
+
+// finish fills lengths and checksums of headers.
+func (b *Builder) finish() error {
+	if b.err != nil {
+		return b.err
+	}
+	if !b.hasL2 {
+		return WrapWithNFError(nil, "Packet should have Ethernet header", BadArgument)
+	}
+	switch b.l3Type {
+	case types.IPV4Number:
+		if len(b.frame)-b.l3 > 0xffff {
+			return WrapWithNFError(nil, "IPv4 packet is too long", BadArgument)
+		}
+		ipv4 := b.ipv4()
+		ipv4.TotalLength = SwapBytesUint16(uint16(len(b.frame) - b.l3))
+		ipv4.HdrChecksum = SwapBytesUint16(CalculateIPv4Checksum(ipv4))
+	case types.IPV6Number:
+		if len(b.frame)-b.l3-types.IPv6Len > 0xffff {
+			return WrapWithNFError(nil, "IPv6 packet is too long", BadArgument)
+		}
+		b.ipv6().PayloadLen = SwapBytesUint16(uint16(len(b.frame) - b.l3 - types.IPv6Len))
+	}
+	if b.l4 == 0 {
+		return nil
+	}
+	l3 := unsafe.Pointer(&b.frame[b.l3])
+	l4 := b.frame[b.l4:]
+	pseudo := pseudoHdrChecksum(l3, b.l3Type == types.IPV4Number, b.l4Proto, uint(len(l4)))
+	switch b.l4Proto {
+	case types.UDPNumber:
+		udp := (*UDPHdr)(unsafe.Pointer(&l4[0]))
+		udp.DgramLen = SwapBytesUint16(uint16(len(l4)))
+		udp.DgramCksum = 0
+		cksum := multicastChecksum(l4, pseudo)
+		if cksum == 0 {
+			cksum = 0xffff
+		}
+		udp.DgramCksum = SwapBytesUint16(cksum)
+	case types.TCPNumber:
+		tcp := (*TCPHdr)(unsafe.Pointer(&l4[0]))
+		tcp.Cksum = 0
+		tcp.Cksum = SwapBytesUint16(multicastChecksum(l4, pseudo))
+	case types.ICMPNumber:
+		icmp := (*ICMPHdr)(unsafe.Pointer(&l4[0]))
+		icmp.Cksum = 0
+		icmp.Cksum = SwapBytesUint16(multicastChecksum(l4, 0))
+	case types.ICMPv6Number:
+		icmp := (*ICMPHdr)(unsafe.Pointer(&l4[0]))
+		icmp.Cksum = 0
+		icmp.Cksum = SwapBytesUint16(multicastChecksum(l4, pseudo))
+	}
+	return nil
+}
+
+// Bytes returns copy of built frame.
+func (b *Builder) Bytes() ([]byte, error) {
+	if err := b.finish(); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b.frame...), nil
+}
+
+// BuildTo initializes empty packet with built frame. Returns error if
+// headers were added incorrectly or packet can't be initialized.
+func (b *Builder) BuildTo(packet *Packet) error {
+	if err := b.finish(); err != nil {
+		return err
+	}
+	if !GeneratePacketFromByte(packet, b.frame) {
+		return WrapWithNFError(nil, "Can't initialize built packet", AllocMbufErr)
+	}
+	return nil
+}
+
+// Build allocates packet from mempool and initializes it with built
+// frame.
+func (b *Builder) Build() (*Packet, error) {
+	if err := b.finish(); err != nil {
+		return nil, err
+	}
+	packet, err := NewPacket()
+	if err != nil {
+		return nil, err
+	}
+	if !GeneratePacketFromByte(packet, b.frame) {
+		packet.DecRefCount()
+		return nil, WrapWithNFError(nil, "Can't initialize built packet", AllocMbufErr)
+	}
+	return packet, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+var (
+	testBuilderSrcMAC = types.MACAddress{0x02, 0, 0, 0, 0, 0x01}
+	testBuilderDstMAC = types.MACAddress{0x02, 0, 0, 0, 0, 0x02}
+	testBuilderSrcIP  = types.BytesToIPv4(192, 0, 2, 1)
+	testBuilderDstIP  = types.BytesToIPv4(192, 0, 2, 2)
+	testBuilderSrcIP6 = types.IPv6Address{0xfe, 0x80, 15: 0x01}
+	testBuilderDstIP6 = types.IPv6Address{0xfe, 0x80, 15: 0x02}
+)
+
+func TestBuilderIPv4UDP(t *testing.T) {
+	payload := []byte("builder payload")
+	pkt, err := NewBuilder().
+		Ether(testBuilderSrcMAC, testBuilderDstMAC).
+		IPv4(testBuilderSrcIP, testBuilderDstIP).
+		TTL(32).
+		UDP(1234, 5678).
+		Payload(payload).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.Ether.SAddr != testBuilderSrcMAC || pkt.Ether.DAddr != testBuilderDstMAC {
+		t.Errorf("Wrong Ethernet header %v", pkt.Ether)
+	}
+	pkt.ParseL3()
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil || ipv4.SrcAddr != testBuilderSrcIP || ipv4.DstAddr != testBuilderDstIP || ipv4.TimeToLive != 32 {
+		t.Fatalf("Wrong IPv4 header %v", ipv4)
+	}
+	if int(SwapBytesUint16(ipv4.TotalLength)) != types.IPv4MinLen+types.UDPLen+len(payload) ||
+		CalculateIPv4Checksum(ipv4) != SwapBytesUint16(ipv4.HdrChecksum) {
+		t.Errorf("Wrong IPv4 length or checksum %x", pkt.GetRawPacketBytes())
+	}
+	pkt.ParseL4ForIPv4()
+	udp := pkt.GetUDPForIPv4()
+	if udp == nil || SwapBytesUint16(udp.SrcPort) != 1234 || SwapBytesUint16(udp.DstPort) != 5678 ||
+		int(SwapBytesUint16(udp.DgramLen)) != types.UDPLen+len(payload) {
+		t.Fatalf("Wrong UDP header %v", udp)
+	}
+	pkt.ParseL7(types.UDPNumber)
+	if CalculateIPv4UDPChecksum(ipv4, udp, pkt.Data) != SwapBytesUint16(udp.DgramCksum) {
+		t.Error("Wrong UDP checksum")
+	}
+	if data, ok := pkt.GetL7Bytes(); !ok || !bytes.Equal(data, payload) {
+		t.Errorf("Wrong payload %q", data)
+	}
+}
+
+func TestBuilderIPv6(t *testing.T) {
+	payload := []byte{1, 2, 3, 4, 5}
+	b := NewBuilder().
+		Ether(testBuilderSrcMAC, testBuilderDstMAC).
+		VLAN(100).
+		IPv6(testBuilderSrcIP6, testBuilderDstIP6).
+		ICMP(types.ICMPv6TypeEchoRequest, 0, 7, 9).
+		Payload(payload)
+	pkt := getPacket()
+	if err := b.BuildTo(pkt); err != nil {
+		t.Fatal(err)
+	}
+	if vlan := pkt.GetVLAN(); vlan == nil || vlan.GetVLANTagIdentifier() != 100 {
+		t.Fatalf("Wrong VLAN tag %v", vlan)
+	}
+	pkt.ParseL3CheckVLAN()
+	ipv6 := pkt.GetIPv6CheckVLAN()
+	if ipv6 == nil || ipv6.Proto != types.ICMPv6Number || int(SwapBytesUint16(ipv6.PayloadLen)) != types.ICMPLen+len(payload) {
+		t.Fatalf("Wrong IPv6 header %v", ipv6)
+	}
+	pkt.ParseL4ForIPv6()
+	icmp := pkt.GetICMPNoCheck()
+	pkt.ParseL7(types.ICMPv6Number)
+	if icmp.Type != types.ICMPv6TypeEchoRequest || SwapBytesUint16(icmp.Identifier) != 7 || SwapBytesUint16(icmp.SeqNum) != 9 ||
+		CalculateIPv6ICMPChecksum(ipv6, icmp, pkt.Data) != SwapBytesUint16(icmp.Cksum) {
+		t.Errorf("Wrong ICMPv6 header %v", icmp)
+	}
+
+	// Builder builds identical packets
+	first, _ := b.Bytes()
+	second, err := b.Bytes()
+	if err != nil || !bytes.Equal(first, second) || !bytes.Equal(first, pkt.GetRawPacketBytes()) {
+		t.Errorf("Built frames are different:\n%x\n%x\n%x", first, second, pkt.GetRawPacketBytes())
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	builders := map[string]*Builder{
+		"no Ethernet header":     NewBuilder().IPv4(testBuilderSrcIP, testBuilderDstIP),
+		"two L3 headers":         NewBuilder().Ether(testBuilderSrcMAC, testBuilderDstMAC).IPv4(testBuilderSrcIP, testBuilderDstIP).IPv6(testBuilderSrcIP6, testBuilderDstIP6),
+		"L4 without L3":          NewBuilder().Ether(testBuilderSrcMAC, testBuilderDstMAC).UDP(1, 2),
+		"L4 after ARP":           NewBuilder().Ether(testBuilderSrcMAC, testBuilderDstMAC).ARP(ARPRequest, testBuilderSrcMAC, testBuilderSrcIP, types.MACAddress{}, testBuilderDstIP).UDP(1, 2),
+		"header after payload":   NewBuilder().Ether(testBuilderSrcMAC, testBuilderDstMAC).Payload([]byte{1}).VLAN(1),
+		"TTL without IP header":  NewBuilder().Ether(testBuilderSrcMAC, testBuilderDstMAC).TTL(1),
+		"too long IPv4 packet":   NewBuilder().Ether(testBuilderSrcMAC, testBuilderDstMAC).IPv4(testBuilderSrcIP, testBuilderDstIP).Payload(make([]byte, 0x10000)),
+		"second Ethernet header": NewBuilder().Ether(testBuilderSrcMAC, testBuilderDstMAC).Ether(testBuilderSrcMAC, testBuilderDstMAC),
+	}
+	for name, b := range builders {
+		if _, err := b.Bytes(); err == nil {
+			t.Errorf("Packet with %s was built", name)
+		}
+	}
+
+	arp, err := NewBuilder().
+		Ether(testBuilderSrcMAC, testBuilderDstMAC).
+		ARP(ARPReply, testBuilderSrcMAC, testBuilderSrcIP, testBuilderDstMAC, testBuilderDstIP).
+		Bytes()
+	if err != nil || len(arp) != types.EtherLen+types.ARPLen || arp[12] != 0x08 || arp[13] != 0x06 || arp[21] != ARPReply {
+		t.Errorf("Wrong ARP packet %x, %v", arp, err)
+	}
+}
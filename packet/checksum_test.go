@@ -5,9 +5,12 @@
 package packet
 
 import (
+	"encoding/hex"
 	"github.com/intel-go/nff-go/types"
 	"net"
+	"reflect"
 	"testing"
+	"unsafe"
 )
 
 const N uint = 20
@@ -156,6 +159,96 @@ func TestCalculateIPv6ICMPChecksum(t *testing.T) {
 	}
 }
 
+// IPv4 header from 192.168.1.1 to 10.0.0.2 without protocol and length
+const partialChecksumIPv4Hdr = "450000000000000040000000c0a801010a000002"
+
+// Payload of DCCP and UDP-Lite packets
+const partialChecksumPayload = "0102030405060708090a0b0c0d0e0f10111213141516"
+
+// ipv4FromHex makes IPv4 packet from hex encoded L4 packet. Returns
+// pointers to IPv4 header and L4 header.
+func ipv4FromHex(t *testing.T, proto uint8, l4 string) (*IPv4Hdr, unsafe.Pointer) {
+	buf, err := hex.DecodeString(partialChecksumIPv4Hdr + l4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipv4 := (*IPv4Hdr)(unsafe.Pointer(&buf[0]))
+	ipv4.TotalLength = SwapBytesUint16(uint16(len(buf)))
+	ipv4.NextProtoID = proto
+	return ipv4, unsafe.Pointer(&buf[types.IPv4MinLen])
+}
+
+func TestCalculateIPv4DCCPChecksum(t *testing.T) {
+	// Data packet with extended sequence number and different
+	// checksum coverage
+	tests := []struct {
+		cscov string
+		want  uint16
+	}{
+		{"00", 0x9681},
+		{"01", 0x1005},
+		{"03", 0xffee},
+	}
+	for _, test := range tests {
+		ipv4, l4 := ipv4FromHex(t, types.DCCPNumber, "04d2162e04"+test.cscov+"12340500000000000007"+partialChecksumPayload)
+		got := CalculateIPv4DCCPChecksum(ipv4, (*DCCPHdr)(l4))
+		if got != test.want {
+			t.Errorf("Incorrect result for CsCov %s:\ngot: %x, \nwant: %x\n\n", test.cscov, got, test.want)
+		}
+	}
+}
+
+func TestCalculateIPv4UDPLiteChecksum(t *testing.T) {
+	tests := []struct {
+		coverage string
+		want     uint16
+		ok       bool
+	}{
+		{"0000", 0x9f29, true},
+		{"0008", 0x18a6, true},
+		{"000c", 0x149c, true},
+		{"0004", 0, false},
+		{"0100", 0, false},
+	}
+	for _, test := range tests {
+		ipv4, l4 := ipv4FromHex(t, types.UDPLiteNumber, "04d2162e"+test.coverage+"5678"+partialChecksumPayload)
+		got, ok := CalculateIPv4UDPLiteChecksum(ipv4, (*UDPLiteHdr)(l4))
+		if got != test.want || ok != test.ok {
+			t.Errorf("Incorrect result for coverage %s:\ngot: %x %v, \nwant: %x %v\n\n", test.coverage, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// SCTP packet with SACK chunk and DATA chunk with padding
+const sctpChunks = "030000102802434500002000000000000003001300000001000100020000000361626300"
+
+func TestCalculateIPv4SCTPChecksum(t *testing.T) {
+	// Checksum field isn't covered by checksum
+	for _, cksum := range []string{"00000000", "2bf2024e"} {
+		ipv4, l4 := ipv4FromHex(t, types.SCTPNumber, "0b80400021441523"+cksum+sctpChunks)
+		sctp := (*SCTPHdr)(l4)
+		if got := CalculateIPv4SCTPChecksum(ipv4, sctp); got != 0x31f2064e {
+			t.Errorf("Incorrect result:\ngot: %x, \nwant: %x\n\n", got, 0x31f2064e)
+		}
+	}
+}
+
+func TestSCTPChunks(t *testing.T) {
+	_, l4 := ipv4FromHex(t, types.SCTPNumber, "0b8040002144152300000000"+sctpChunks)
+	sctp := (*SCTPHdr)(l4)
+	var chunks []uint8
+	if !SCTPChunks(sctp, 48, func(chunk *SCTPChunkHdr) bool {
+		chunks = append(chunks, chunk.Type)
+		return true
+	}) || !reflect.DeepEqual(chunks, []uint8{SCTPSack, SCTPData}) {
+		t.Errorf("Incorrect chunks %v", chunks)
+	}
+	// The second chunk is truncated
+	if SCTPChunks(sctp, 40, func(chunk *SCTPChunkHdr) bool { return true }) {
+		t.Error("Truncated chunk is accepted")
+	}
+}
+
 func initIPv4AddrsLocal(pkt *Packet) {
 	ipv4 := pkt.GetIPv4()
 	ipv4.SrcAddr = types.SliceToIPv4(net.ParseIP("131.151.32.21").To4())
@@ -0,0 +1,132 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// DCCPHdr is a generic DCCP header without sequence number which has
+// different length depending on X bit.
+type DCCPHdr struct {
+	SrcPort    uint16 // DCCP source port
+	DstPort    uint16 // DCCP destination port
+	DataOff    uint8  // Header length in 32-bit words
+	CCValCsCov uint8  // Congestion control value and checksum coverage
+	Cksum      uint16 // DCCP checksum
+	ResTypeX   uint8  // Reserved bits, packet type and extended sequence number flag
+}
+
+// DCCP packet types
+const (
+	DCCPRequest uint8 = iota
+	DCCPResponse
+	DCCPData
+	DCCPAck
+	DCCPDataAck
+	DCCPCloseReq
+	DCCPClose
+	DCCPReset
+	DCCPSync
+	DCCPSyncAck
+)
+
+func (hdr *DCCPHdr) String() string {
+	r0 := "        L4 protocol: DCCP\n"
+	r1 := fmt.Sprintf("        L4 Source: %d\n", SwapBytesUint16(hdr.SrcPort))
+	r2 := fmt.Sprintf("        L4 Destination: %d\n", SwapBytesUint16(hdr.DstPort))
+	r3 := fmt.Sprintf("        DCCP Type: %d\n", hdr.Type())
+	return r0 + r1 + r2 + r3
+}
+
+// Type returns DCCP packet type.
+func (hdr *DCCPHdr) Type() uint8 {
+	return (hdr.ResTypeX >> 1) & 0x0f
+}
+
+// ExtendedSeq returns true if packet has 48 bit sequence number.
+func (hdr *DCCPHdr) ExtendedSeq() bool {
+	return hdr.ResTypeX&1 != 0
+}
+
+// ChecksumCoverage returns CsCov field. Zero means that checksum
+// covers whole packet, otherwise checksum covers header and
+// (CsCov - 1) * 4 bytes of application data.
+func (hdr *DCCPHdr) ChecksumCoverage() uint8 {
+	return hdr.CCValCsCov & 0x0f
+}
+
+// HeaderLen returns length of DCCP header with options in bytes.
+func (hdr *DCCPHdr) HeaderLen() int {
+	return int(hdr.DataOff) * 4
+}
+
+// GetDCCPForIPv4 ensures if L4 type is DCCP and cast L4 pointer to *DCCPHdr type.
+func (packet *Packet) GetDCCPForIPv4() *DCCPHdr {
+	if packet.GetIPv4NoCheck().NextProtoID == types.DCCPNumber {
+		return (*DCCPHdr)(packet.L4)
+	}
+	return nil
+}
+
+// GetDCCPForIPv6 ensures if L4 type is DCCP and cast L4 pointer to *DCCPHdr type.
+func (packet *Packet) GetDCCPForIPv6() *DCCPHdr {
+	if packet.GetIPv6NoCheck().Proto == types.DCCPNumber {
+		return (*DCCPHdr)(packet.L4)
+	}
+	return nil
+}
+
+// GetDCCPNoCheck casts L4 pointer to *DCCPHdr type.
+func (packet *Packet) GetDCCPNoCheck() *DCCPHdr {
+	return (*DCCPHdr)(packet.L4)
+}
+
+// dccpCoverage returns number of bytes of DCCP packet with given
+// length which are covered by checksum.
+func dccpCoverage(dccp *DCCPHdr, length int) int {
+	cscov := dccp.ChecksumCoverage()
+	if cscov == 0 {
+		return length
+	}
+	covered := dccp.HeaderLen() + int(cscov-1)*4
+	if covered > length {
+		return length
+	}
+	return covered
+}
+
+// calculateCoveredChecksum sums covered bytes of L4 packet without its
+// checksum field.
+func calculateCoveredChecksum(l4 unsafe.Pointer, covered int, cksum uint16) uint32 {
+	return calculateDataChecksum(l4, covered, 0) - uint32(SwapBytesUint16(cksum))
+}
+
+// CalculateIPv4DCCPChecksum calculates DCCP checksum for case if L3
+// protocol is IPv4. Checksum covers part of packet according to
+// CsCov field.
+func CalculateIPv4DCCPChecksum(hdr *IPv4Hdr, dccp *DCCPHdr) uint16 {
+	length := int(SwapBytesUint16(hdr.TotalLength)) - int(hdr.VersionIhl&0x0f)*4
+	sum := calculateCoveredChecksum(unsafe.Pointer(dccp), dccpCoverage(dccp, length), dccp.Cksum) +
+		calculateIPv4AddrChecksum(hdr) +
+		uint32(hdr.NextProtoID) +
+		uint32(length)
+	return ^reduceChecksum(sum)
+}
+
+// CalculateIPv6DCCPChecksum calculates DCCP checksum for case if L3
+// protocol is IPv6. Checksum covers part of packet according to
+// CsCov field.
+func CalculateIPv6DCCPChecksum(hdr *IPv6Hdr, dccp *DCCPHdr) uint16 {
+	length := int(SwapBytesUint16(hdr.PayloadLen))
+	sum := calculateCoveredChecksum(unsafe.Pointer(dccp), dccpCoverage(dccp, length), dccp.Cksum) +
+		calculateIPv6AddrChecksum(hdr) +
+		uint32(hdr.Proto) +
+		uint32(length)
+	return ^reduceChecksum(sum)
+}
@@ -0,0 +1,328 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// DHCPv4Hdr is a fixed part of DHCPv4 message (RFC 2131) with magic
+// cookie which follows UDP header. It is followed by options.
+type DHCPv4Hdr struct {
+	Op     uint8  // Message op code, DHCPv4BootRequest or DHCPv4BootReply
+	HType  uint8  // Hardware address type, 1 for Ethernet
+	HLen   uint8  // Hardware address length
+	Hops   uint8  // Used by relay agents
+	XID    uint32 // Transaction ID
+	Secs   uint16 // Seconds elapsed since client began acquisition
+	Flags  uint16 // Broadcast flag
+	CIAddr types.IPv4Address
+	YIAddr types.IPv4Address
+	SIAddr types.IPv4Address
+	GIAddr types.IPv4Address
+	CHAddr [16]uint8 // Client hardware address
+	SName  [64]uint8
+	File   [128]uint8
+	Magic  uint32
+}
+
+// DHCPv4 constants
+const (
+	UDPPortDHCPv4Server     = 67
+	UDPPortDHCPv4Client     = 68
+	SwapUDPPortDHCPv4Server = 0x4300
+	SwapUDPPortDHCPv4Client = 0x4400
+
+	DHCPv4BootRequest = 1
+	DHCPv4BootReply   = 2
+
+	DHCPv4FlagBroadcast = 0x8000
+	DHCPv4Magic         = 0x63825363
+
+	// Message types
+	DHCPv4Discover = 1
+	DHCPv4Offer    = 2
+	DHCPv4Request  = 3
+	DHCPv4Decline  = 4
+	DHCPv4Ack      = 5
+	DHCPv4Nak      = 6
+	DHCPv4Release  = 7
+	DHCPv4Inform   = 8
+
+	// Option codes
+	DHCPv4OptionPad              = 0
+	DHCPv4OptionSubnetMask       = 1
+	DHCPv4OptionRouter           = 3
+	DHCPv4OptionDNS              = 6
+	DHCPv4OptionHostName         = 12
+	DHCPv4OptionRequestedIP      = 50
+	DHCPv4OptionLeaseTime        = 51
+	DHCPv4OptionMessageType      = 53
+	DHCPv4OptionServerID         = 54
+	DHCPv4OptionParameterRequest = 55
+	DHCPv4OptionRenewalTime      = 58
+	DHCPv4OptionRebindingTime    = 59
+	DHCPv4OptionClientID         = 61
+	DHCPv4OptionEnd              = 255
+
+	// Length of DHCPv4Hdr including magic cookie
+	DHCPv4HdrLen = 240
+	// Minimum length of BOOTP message, shorter messages are padded
+	dhcpv4MinLen     = 300
+	dhcpv4MaxOptions = 1500
+)
+
+var (
+	dhcpv4BroadcastMAC  = types.MACAddress{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	dhcpv4BroadcastIPv4 = types.BytesToIPv4(255, 255, 255, 255)
+)
+
+// DHCPv4Option is an option of DHCPv4 message, data doesn't include
+// code and length.
+type DHCPv4Option struct {
+	Code uint8
+	Data []byte
+}
+
+// DHCPv4OptionIPv4 returns option with IPv4 addresses.
+func DHCPv4OptionIPv4(code uint8, addrs ...types.IPv4Address) DHCPv4Option {
+	data := make([]byte, 0, len(addrs)*types.IPv4AddrLen)
+	for _, addr := range addrs {
+		data = append(data, byte(addr), byte(addr>>8), byte(addr>>16), byte(addr>>24))
+	}
+	return DHCPv4Option{Code: code, Data: data}
+}
+
+// DHCPv4OptionUint32 returns option with 32 bit value like lease time.
+func DHCPv4OptionUint32(code uint8, v uint32) DHCPv4Option {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, v)
+	return DHCPv4Option{Code: code, Data: data}
+}
+
+// DHCPv4Message describes DHCPv4 message which is built by
+// InitDHCPv4Packet. Message type option is added before other options.
+type DHCPv4Message struct {
+	Type      uint8
+	XID       uint32
+	ClientMAC types.MACAddress
+	// Client address in Request, Inform and Release messages
+	ClientIP types.IPv4Address
+	// Address which is assigned to client in Offer and Ack messages
+	YourIP types.IPv4Address
+	// Address of next server
+	ServerIP types.IPv4Address
+	// Replies to client without address should be broadcast
+	Broadcast bool
+	Options   []DHCPv4Option
+}
+
+func (hdr *DHCPv4Hdr) String() string {
+	return fmt.Sprintf("DHCPv4: op = %d, xid = 0x%08x, flags = 0x%04x, ciaddr = %v, yiaddr = %v, siaddr = %v, giaddr = %v, chaddr = %v",
+		hdr.Op, SwapBytesUint32(hdr.XID), SwapBytesUint16(hdr.Flags), hdr.CIAddr, hdr.YIAddr, hdr.SIAddr, hdr.GIAddr,
+		types.MACAddress{hdr.CHAddr[0], hdr.CHAddr[1], hdr.CHAddr[2], hdr.CHAddr[3], hdr.CHAddr[4], hdr.CHAddr[5]})
+}
+
+// GetDHCPv4 returns DHCPv4 header if L4 header is UDP header with DHCP
+// server or client port and packet is long enough. L7 should be parsed
+// for UDP protocol.
+func (packet *Packet) GetDHCPv4() *DHCPv4Hdr {
+	udp := (*UDPHdr)(packet.L4)
+	if udp.DstPort != SwapUDPPortDHCPv4Server && udp.DstPort != SwapUDPPortDHCPv4Client {
+		return nil
+	}
+	if SwapBytesUint16(udp.DgramLen) < types.UDPLen+DHCPv4HdrLen || !packet.checkEnoughSpace(DHCPv4HdrLen-1) {
+		return nil
+	}
+	hdr := (*DHCPv4Hdr)(packet.Data)
+	if hdr.Magic != SwapBytesUint32(DHCPv4Magic) {
+		return nil
+	}
+	return hdr
+}
+
+// GetDHCPv4NoCheck casts Data pointer to *DHCPv4Hdr type.
+func (packet *Packet) GetDHCPv4NoCheck() *DHCPv4Hdr {
+	return (*DHCPv4Hdr)(packet.Data)
+}
+
+// DHCPv4Options calls fn for every option of DHCPv4 message until fn
+// returns false or end option. Data of option is a slice of packet.
+// Options end at the end of UDP datagram. Returns false if option
+// doesn't fit in datagram.
+func (packet *Packet) DHCPv4Options(fn func(code uint8, data []byte) bool) bool {
+	end := uintptr(packet.L4) + uintptr(SwapBytesUint16((*UDPHdr)(packet.L4).DgramLen))
+	if segmentEnd := uintptr(packet.StartAtOffset(uintptr(packet.GetPacketSegmentLen()))); end > segmentEnd {
+		end = segmentEnd
+	}
+	if end < uintptr(packet.Data)+DHCPv4HdrLen {
+		return false
+	}
+	limit := end - uintptr(packet.Data)
+	for off := uintptr(DHCPv4HdrLen); off < limit; {
+		code := *(*uint8)(unsafe.Pointer(uintptr(packet.Data) + off))
+		if code == DHCPv4OptionEnd {
+			return true
+		}
+		if code == DHCPv4OptionPad {
+			off++
+			continue
+		}
+		if off+2 > limit {
+			return false
+		}
+		length := uintptr(*(*uint8)(unsafe.Pointer(uintptr(packet.Data) + off + 1)))
+		if off+2+length > limit {
+			return false
+		}
+		if !fn(code, (*[0xff]byte)(unsafe.Pointer(uintptr(packet.Data) + off + 2))[:length:length]) {
+			return true
+		}
+		off += 2 + length
+	}
+	return true
+}
+
+// GetDHCPv4MessageType returns value of message type option or zero if
+// message doesn't have it.
+func (packet *Packet) GetDHCPv4MessageType() uint8 {
+	var msgType uint8
+	packet.DHCPv4Options(func(code uint8, data []byte) bool {
+		if code == DHCPv4OptionMessageType && len(data) == 1 {
+			msgType = data[0]
+			return false
+		}
+		return true
+	})
+	return msgType
+}
+
+// InitDHCPv4Packet allocates and initializes IPv4 UDP packet with DHCPv4
+// message from given addresses. Client port is used for requests and
+// server port is used for replies. IPv4 and UDP checksums are
+// calculated. Returns false if options are too long or packet can't
+// be allocated.
+func InitDHCPv4Packet(packet *Packet, srcMAC, dstMAC types.MACAddress, srcIP, dstIP types.IPv4Address, msg *DHCPv4Message) bool {
+	optionsLen := uint(3 + 1)
+	for i := range msg.Options {
+		if len(msg.Options[i].Data) > 0xff {
+			return false
+		}
+		optionsLen += 2 + uint(len(msg.Options[i].Data))
+	}
+	if optionsLen > dhcpv4MaxOptions {
+		return false
+	}
+	length := DHCPv4HdrLen + optionsLen
+	if length < dhcpv4MinLen {
+		length = dhcpv4MinLen
+	}
+	if !InitEmptyIPv4UDPPacket(packet, length) {
+		return false
+	}
+	packet.Ether.SAddr = srcMAC
+	packet.Ether.DAddr = dstMAC
+	ipv4 := packet.GetIPv4NoCheck()
+	ipv4.SrcAddr = srcIP
+	ipv4.DstAddr = dstIP
+	udp := packet.GetUDPNoCheck()
+	op := uint8(DHCPv4BootRequest)
+	switch msg.Type {
+	case DHCPv4Offer, DHCPv4Ack, DHCPv4Nak:
+		op = DHCPv4BootReply
+		udp.SrcPort = SwapUDPPortDHCPv4Server
+		udp.DstPort = SwapUDPPortDHCPv4Client
+	default:
+		udp.SrcPort = SwapUDPPortDHCPv4Client
+		udp.DstPort = SwapUDPPortDHCPv4Server
+	}
+	hdr := packet.GetDHCPv4NoCheck()
+	*hdr = DHCPv4Hdr{
+		Op:     op,
+		HType:  1,
+		HLen:   types.EtherAddrLen,
+		XID:    SwapBytesUint32(msg.XID),
+		CIAddr: msg.ClientIP,
+		YIAddr: msg.YourIP,
+		SIAddr: msg.ServerIP,
+		Magic:  SwapBytesUint32(DHCPv4Magic),
+	}
+	if msg.Broadcast {
+		hdr.Flags = SwapBytesUint16(DHCPv4FlagBroadcast)
+	}
+	copy(hdr.CHAddr[:], msg.ClientMAC[:])
+	options := (*[dhcpv4MinLen + dhcpv4MaxOptions]byte)(packet.Data)[DHCPv4HdrLen:length]
+	n := copy(options, []byte{DHCPv4OptionMessageType, 1, msg.Type})
+	for i := range msg.Options {
+		options[n] = msg.Options[i].Code
+		options[n+1] = uint8(len(msg.Options[i].Data))
+		n += 2 + copy(options[n+2:], msg.Options[i].Data)
+	}
+	options[n] = DHCPv4OptionEnd
+	for n++; n < len(options); n++ {
+		options[n] = DHCPv4OptionPad
+	}
+	if hwtxchecksum {
+		udp.DgramCksum = SwapBytesUint16(CalculatePseudoHdrIPv4UDPCksum(ipv4, udp))
+	} else {
+		ipv4.HdrChecksum = SwapBytesUint16(CalculateIPv4Checksum(ipv4))
+		udp.DgramCksum = SwapBytesUint16(CalculateIPv4UDPChecksum(ipv4, udp, packet.Data))
+	}
+	return true
+}
+
+// InitDHCPv4DiscoverPacket initializes broadcast DHCPv4 Discover
+// message of client without address.
+func InitDHCPv4DiscoverPacket(packet *Packet, clientMAC types.MACAddress, xid uint32, options []DHCPv4Option) bool {
+	return InitDHCPv4Packet(packet, clientMAC, dhcpv4BroadcastMAC, 0, dhcpv4BroadcastIPv4, &DHCPv4Message{
+		Type:      DHCPv4Discover,
+		XID:       xid,
+		ClientMAC: clientMAC,
+		Broadcast: true,
+		Options:   options,
+	})
+}
+
+// InitDHCPv4RequestPacket initializes broadcast DHCPv4 Request message
+// of client without address which requests address offered by server.
+func InitDHCPv4RequestPacket(packet *Packet, clientMAC types.MACAddress, xid uint32, requested, server types.IPv4Address, options []DHCPv4Option) bool {
+	return InitDHCPv4Packet(packet, clientMAC, dhcpv4BroadcastMAC, 0, dhcpv4BroadcastIPv4, &DHCPv4Message{
+		Type:      DHCPv4Request,
+		XID:       xid,
+		ClientMAC: clientMAC,
+		Broadcast: true,
+		Options: append([]DHCPv4Option{
+			DHCPv4OptionIPv4(DHCPv4OptionRequestedIP, requested),
+			DHCPv4OptionIPv4(DHCPv4OptionServerID, server),
+		}, options...),
+	})
+}
+
+// InitDHCPv4OfferPacket initializes broadcast DHCPv4 Offer message of
+// server which offers address to client.
+func InitDHCPv4OfferPacket(packet *Packet, serverMAC types.MACAddress, server types.IPv4Address, xid uint32, clientMAC types.MACAddress, offered types.IPv4Address, options []DHCPv4Option) bool {
+	return initDHCPv4Reply(packet, DHCPv4Offer, serverMAC, server, xid, clientMAC, offered, options)
+}
+
+// InitDHCPv4AckPacket initializes broadcast DHCPv4 Ack message of
+// server which assigns address to client.
+func InitDHCPv4AckPacket(packet *Packet, serverMAC types.MACAddress, server types.IPv4Address, xid uint32, clientMAC types.MACAddress, assigned types.IPv4Address, options []DHCPv4Option) bool {
+	return initDHCPv4Reply(packet, DHCPv4Ack, serverMAC, server, xid, clientMAC, assigned, options)
+}
+
+func initDHCPv4Reply(packet *Packet, msgType uint8, serverMAC types.MACAddress, server types.IPv4Address, xid uint32, clientMAC types.MACAddress, yiaddr types.IPv4Address, options []DHCPv4Option) bool {
+	return InitDHCPv4Packet(packet, serverMAC, dhcpv4BroadcastMAC, server, dhcpv4BroadcastIPv4, &DHCPv4Message{
+		Type:      msgType,
+		XID:       xid,
+		ClientMAC: clientMAC,
+		YourIP:    yiaddr,
+		Broadcast: true,
+		Options:   append([]DHCPv4Option{DHCPv4OptionIPv4(DHCPv4OptionServerID, server)}, options...),
+	})
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func TestDHCPv4Exchange(t *testing.T) {
+	clientMAC := types.MACAddress{0x02, 0, 0, 0, 0, 1}
+	serverMAC := types.MACAddress{0x02, 0, 0, 0, 0, 2}
+	server := types.BytesToIPv4(192, 0, 2, 1)
+	offered := types.BytesToIPv4(192, 0, 2, 100)
+	hostName := DHCPv4Option{Code: DHCPv4OptionHostName, Data: []byte("client")}
+
+	tests := []struct {
+		init    func(*Packet) bool
+		msgType uint8
+		op      uint8
+		dstPort uint16
+		options []DHCPv4Option
+	}{
+		{
+			init:    func(p *Packet) bool { return InitDHCPv4DiscoverPacket(p, clientMAC, 0x1234, []DHCPv4Option{hostName}) },
+			msgType: DHCPv4Discover, op: DHCPv4BootRequest, dstPort: UDPPortDHCPv4Server,
+			options: []DHCPv4Option{hostName},
+		},
+		{
+			init: func(p *Packet) bool {
+				return InitDHCPv4OfferPacket(p, serverMAC, server, 0x1234, clientMAC, offered,
+					[]DHCPv4Option{DHCPv4OptionUint32(DHCPv4OptionLeaseTime, 3600)})
+			},
+			msgType: DHCPv4Offer, op: DHCPv4BootReply, dstPort: UDPPortDHCPv4Client,
+			options: []DHCPv4Option{DHCPv4OptionIPv4(DHCPv4OptionServerID, server), {DHCPv4OptionLeaseTime, []byte{0, 0, 0x0e, 0x10}}},
+		},
+		{
+			init:    func(p *Packet) bool { return InitDHCPv4RequestPacket(p, clientMAC, 0x1234, offered, server, nil) },
+			msgType: DHCPv4Request, op: DHCPv4BootRequest, dstPort: UDPPortDHCPv4Server,
+			options: []DHCPv4Option{{DHCPv4OptionRequestedIP, []byte{192, 0, 2, 100}}, {DHCPv4OptionServerID, []byte{192, 0, 2, 1}}},
+		},
+		{
+			init: func(p *Packet) bool {
+				return InitDHCPv4AckPacket(p, serverMAC, server, 0x1234, clientMAC, offered, nil)
+			},
+			msgType: DHCPv4Ack, op: DHCPv4BootReply, dstPort: UDPPortDHCPv4Client,
+			options: []DHCPv4Option{DHCPv4OptionIPv4(DHCPv4OptionServerID, server)},
+		},
+	}
+	for _, test := range tests {
+		pkt := getPacket()
+		if !test.init(pkt) {
+			t.Fatalf("Initialization of message %d failed", test.msgType)
+		}
+		pkt.ParseL3()
+		ipv4 := pkt.GetIPv4()
+		if ipv4 == nil || CalculateIPv4Checksum(ipv4) != SwapBytesUint16(ipv4.HdrChecksum) {
+			t.Fatalf("Message %d has wrong IPv4 header", test.msgType)
+		}
+		pkt.ParseL4ForIPv4()
+		udp := pkt.GetUDPForIPv4()
+		pkt.ParseL7(types.UDPNumber)
+		if udp == nil || SwapBytesUint16(udp.DstPort) != test.dstPort ||
+			CalculateIPv4UDPChecksum(ipv4, udp, pkt.Data) != SwapBytesUint16(udp.DgramCksum) {
+			t.Fatalf("Message %d has wrong UDP header %v", test.msgType, udp)
+		}
+		hdr := pkt.GetDHCPv4()
+		if hdr == nil || hdr.Op != test.op || SwapBytesUint32(hdr.XID) != 0x1234 || !bytes.Equal(hdr.CHAddr[:6], clientMAC[:]) {
+			t.Fatalf("Message %d has wrong DHCPv4 header %v", test.msgType, hdr)
+		}
+		if test.op == DHCPv4BootReply && hdr.YIAddr != offered {
+			t.Errorf("Message %d offers %v", test.msgType, hdr.YIAddr)
+		}
+		if pkt.GetDHCPv4MessageType() != test.msgType {
+			t.Errorf("Message %d has type %d", test.msgType, pkt.GetDHCPv4MessageType())
+		}
+		var options []DHCPv4Option
+		if !pkt.DHCPv4Options(func(code uint8, data []byte) bool {
+			if code != DHCPv4OptionMessageType {
+				options = append(options, DHCPv4Option{code, append([]byte(nil), data...)})
+			}
+			return true
+		}) {
+			t.Fatalf("Options of message %d are malformed", test.msgType)
+		}
+		if len(options) != len(test.options) {
+			t.Fatalf("Message %d has options %v instead of %v", test.msgType, options, test.options)
+		}
+		for i := range options {
+			if options[i].Code != test.options[i].Code || !bytes.Equal(options[i].Data, test.options[i].Data) {
+				t.Errorf("Option %d of message %d is %v instead of %v", i, test.msgType, options[i], test.options[i])
+			}
+		}
+	}
+}
+
+func TestDHCPv4Malformed(t *testing.T) {
+	pkt := getPacket()
+	if InitDHCPv4DiscoverPacket(pkt, types.MACAddress{}, 1, []DHCPv4Option{{Code: 1, Data: make([]byte, 256)}}) {
+		t.Error("Option longer than 255 bytes was accepted")
+	}
+	pkt = getPacket()
+	InitDHCPv4DiscoverPacket(pkt, types.MACAddress{}, 1, nil)
+	pkt.ParseL3()
+	pkt.ParseL4ForIPv4()
+	pkt.ParseL7(types.UDPNumber)
+	// Length of the last option exceeds datagram
+	end := pkt.GetPacketLen() - (uint(uintptr(pkt.Data) - uintptr(pkt.StartAtOffset(0))))
+	data := (*[dhcpv4MinLen]byte)(pkt.Data)[:end]
+	data[DHCPv4HdrLen+3] = DHCPv4OptionHostName
+	data[DHCPv4HdrLen+4] = 0xff
+	if pkt.DHCPv4Options(func(uint8, []byte) bool { return true }) {
+		t.Error("Option which doesn't fit in datagram was accepted")
+	}
+	pkt.GetDHCPv4NoCheck().Magic = 0
+	if pkt.GetDHCPv4() != nil {
+		t.Error("Message without magic cookie was accepted")
+	}
+}
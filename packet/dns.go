@@ -0,0 +1,364 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// DNS constants
+const (
+	UDPPortDNS     = 53
+	SwapUDPPortDNS = 0x3500
+
+	DNSHdrLen = 12
+
+	DNSFlagQR     = 0x8000 // Response
+	DNSOpcodeMask = 0x7800
+	DNSFlagAA     = 0x0400 // Authoritative answer
+	DNSFlagTC     = 0x0200 // Truncated
+	DNSFlagRD     = 0x0100 // Recursion desired
+	DNSFlagRA     = 0x0080 // Recursion available
+	DNSRcodeMask  = 0x000f
+
+	DNSTypeA     = 1
+	DNSTypeNS    = 2
+	DNSTypeCNAME = 5
+	DNSTypeSOA   = 6
+	DNSTypePTR   = 12
+	DNSTypeMX    = 15
+	DNSTypeTXT   = 16
+	DNSTypeAAAA  = 28
+	DNSTypeSRV   = 33
+	DNSTypeANY   = 255
+
+	DNSClassIN = 1
+
+	dnsMaxNameLen = 255
+	dnsMaxMsgLen  = 0xffff
+	// Maximum number of compression pointers in one name
+	dnsMaxPointers = 64
+)
+
+// DNSHeader is a header of DNS message in host byte order.
+type DNSHeader struct {
+	ID      uint16
+	Flags   uint16
+	QDCount uint16
+	ANCount uint16
+	NSCount uint16
+	ARCount uint16
+}
+
+// DNSName is a domain name in DNS message. It refers to memory of
+// message, so it is valid only while message isn't changed.
+type DNSName struct {
+	msg []byte
+	off int
+}
+
+// DNSQuestion is an entry of question section of DNS message.
+type DNSQuestion struct {
+	Name  DNSName
+	Type  uint16
+	Class uint16
+}
+
+// DNSResource is a resource record of answer, authority or additional
+// section of DNS message. Data is a slice of message.
+type DNSResource struct {
+	Name  DNSName
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+	// Offset of data in message, names in data can be compressed
+	dataOff int
+	msg     []byte
+}
+
+// DNSParser parses DNS message without allocations. Start parses header,
+// then Question returns entries of question section and Resource
+// returns records of answer, authority and additional sections in
+// order. Parser stops at the first malformed entry.
+type DNSParser struct {
+	msg       []byte
+	off       int
+	Header    DNSHeader
+	questions int
+	resources int
+	malformed bool
+}
+
+// GetDNS returns DNS message of UDP datagram from or to DNS port. It is
+// a slice of packet memory. L7 should be parsed for UDP protocol.
+func (packet *Packet) GetDNS() []byte {
+	udp := (*UDPHdr)(packet.L4)
+	if udp.DstPort != SwapUDPPortDNS && udp.SrcPort != SwapUDPPortDNS {
+		return nil
+	}
+	length := int(SwapBytesUint16(udp.DgramLen)) - types.UDPLen
+	available := int(packet.GetPacketSegmentLen()) - int(uintptr(packet.Data)-uintptr(packet.StartAtOffset(0)))
+	if length > available {
+		length = available
+	}
+	if length < DNSHdrLen {
+		return nil
+	}
+	return (*[dnsMaxMsgLen]byte)(packet.Data)[:length:length]
+}
+
+// Start parses header of message. Returns false if message is shorter
+// than header.
+func (p *DNSParser) Start(msg []byte) bool {
+	*p = DNSParser{msg: msg, off: DNSHdrLen}
+	if len(msg) < DNSHdrLen {
+		p.malformed = true
+		return false
+	}
+	p.Header = DNSHeader{
+		ID:      binary.BigEndian.Uint16(msg[0:]),
+		Flags:   binary.BigEndian.Uint16(msg[2:]),
+		QDCount: binary.BigEndian.Uint16(msg[4:]),
+		ANCount: binary.BigEndian.Uint16(msg[6:]),
+		NSCount: binary.BigEndian.Uint16(msg[8:]),
+		ARCount: binary.BigEndian.Uint16(msg[10:]),
+	}
+	p.questions = int(p.Header.QDCount)
+	p.resources = int(p.Header.ANCount) + int(p.Header.NSCount) + int(p.Header.ARCount)
+	return true
+}
+
+// Malformed returns true if parser stopped because entry didn't fit in
+// message.
+func (p *DNSParser) Malformed() bool {
+	return p.malformed
+}
+
+// Question parses the next entry of question section. Returns false if
+// there are no more questions or entry is malformed.
+func (p *DNSParser) Question(q *DNSQuestion) bool {
+	if p.malformed || p.questions == 0 {
+		return false
+	}
+	off, ok := skipDNSName(p.msg, p.off)
+	if !ok || off+4 > len(p.msg) {
+		p.malformed = true
+		return false
+	}
+	q.Name = DNSName{msg: p.msg, off: p.off}
+	q.Type = binary.BigEndian.Uint16(p.msg[off:])
+	q.Class = binary.BigEndian.Uint16(p.msg[off+2:])
+	p.off = off + 4
+	p.questions--
+	return true
+}
+
+// Resource parses the next resource record, remaining questions are
+// skipped. Section of record can be found from counts of header.
+// Returns false if there are no more records or record is malformed.
+func (p *DNSParser) Resource(rr *DNSResource) bool {
+	var q DNSQuestion
+	for p.questions != 0 {
+		if !p.Question(&q) {
+			return false
+		}
+	}
+	if p.malformed || p.resources == 0 {
+		return false
+	}
+	off, ok := skipDNSName(p.msg, p.off)
+	if !ok || off+10 > len(p.msg) {
+		p.malformed = true
+		return false
+	}
+	length := int(binary.BigEndian.Uint16(p.msg[off+8:]))
+	if off+10+length > len(p.msg) {
+		p.malformed = true
+		return false
+	}
+	*rr = DNSResource{
+		Name:    DNSName{msg: p.msg, off: p.off},
+		Type:    binary.BigEndian.Uint16(p.msg[off:]),
+		Class:   binary.BigEndian.Uint16(p.msg[off+2:]),
+		TTL:     binary.BigEndian.Uint32(p.msg[off+4:]),
+		Data:    p.msg[off+10 : off+10+length : off+10+length],
+		dataOff: off + 10,
+		msg:     p.msg,
+	}
+	p.off = off + 10 + length
+	p.resources--
+	return true
+}
+
+// IPv4 returns address of A record.
+func (rr *DNSResource) IPv4() (types.IPv4Address, bool) {
+	if rr.Type != DNSTypeA || len(rr.Data) != types.IPv4AddrLen {
+		return 0, false
+	}
+	return types.BytesToIPv4(rr.Data[0], rr.Data[1], rr.Data[2], rr.Data[3]), true
+}
+
+// IPv6 returns address of AAAA record.
+func (rr *DNSResource) IPv6() (types.IPv6Address, bool) {
+	var addr types.IPv6Address
+	if rr.Type != DNSTypeAAAA || len(rr.Data) != types.IPv6AddrLen {
+		return addr, false
+	}
+	copy(addr[:], rr.Data)
+	return addr, true
+}
+
+// Target returns name in data of CNAME, NS and PTR records.
+func (rr *DNSResource) Target() (DNSName, bool) {
+	switch rr.Type {
+	case DNSTypeCNAME, DNSTypeNS, DNSTypePTR:
+		if end, ok := skipDNSName(rr.msg, rr.dataOff); ok && end <= rr.dataOff+len(rr.Data) {
+			return DNSName{msg: rr.msg, off: rr.dataOff}, true
+		}
+	}
+	return DNSName{}, false
+}
+
+// skipDNSName returns offset after name which starts at off.
+func skipDNSName(msg []byte, off int) (int, bool) {
+	for off < len(msg) {
+		length := int(msg[off])
+		switch length & 0xc0 {
+		case 0:
+			if length == 0 {
+				return off + 1, true
+			}
+			off += 1 + length
+		case 0xc0:
+			if off+2 > len(msg) {
+				return 0, false
+			}
+			return off + 2, true
+		default:
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// labels calls fn for every label of name following compression
+// pointers. Returns false if name is malformed.
+func (n DNSName) labels(fn func(label []byte) bool) bool {
+	off, pointers, total := n.off, 0, 0
+	for off < len(n.msg) {
+		length := int(n.msg[off])
+		switch length & 0xc0 {
+		case 0:
+			if length == 0 {
+				return true
+			}
+			if off+1+length > len(n.msg) {
+				return false
+			}
+			if total += length + 1; total > dnsMaxNameLen {
+				return false
+			}
+			if !fn(n.msg[off+1 : off+1+length]) {
+				return true
+			}
+			off += 1 + length
+		case 0xc0:
+			if off+2 > len(n.msg) || pointers == dnsMaxPointers {
+				return false
+			}
+			pointers++
+			off = int(binary.BigEndian.Uint16(n.msg[off:]) & 0x3fff)
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// AppendTo appends dotted name without trailing dot to dst. Root name
+// is empty. Returns false if name is malformed.
+func (n DNSName) AppendTo(dst []byte) ([]byte, bool) {
+	start := len(dst)
+	ok := n.labels(func(label []byte) bool {
+		if len(dst) != start {
+			dst = append(dst, '.')
+		}
+		dst = append(dst, label...)
+		return true
+	})
+	return dst, ok
+}
+
+// String returns dotted name, it allocates memory.
+func (n DNSName) String() string {
+	name, _ := n.AppendTo(nil)
+	return string(name)
+}
+
+// Equal compares name with dotted name ignoring case of ASCII letters.
+// Trailing dot of name is ignored.
+func (n DNSName) Equal(name string) bool {
+	if len(name) != 0 && name[len(name)-1] == '.' {
+		name = name[:len(name)-1]
+	}
+	pos := 0
+	ok := n.labels(func(label []byte) bool {
+		if pos != 0 {
+			if pos >= len(name) || name[pos] != '.' {
+				pos = -1
+				return false
+			}
+			pos++
+		}
+		if pos+len(label) > len(name) || !equalFoldASCII(label, name[pos:pos+len(label)]) {
+			pos = -1
+			return false
+		}
+		pos += len(label)
+		return true
+	})
+	return ok && pos == len(name)
+}
+
+// HasSuffix returns true if name is equal to domain or is its
+// subdomain, case of ASCII letters is ignored.
+func (n DNSName) HasSuffix(domain string) bool {
+	if len(domain) != 0 && domain[len(domain)-1] == '.' {
+		domain = domain[:len(domain)-1]
+	}
+	if domain == "" {
+		return true
+	}
+	// Skip labels until the rest of name is as long as domain
+	var buf [dnsMaxNameLen]byte
+	name, ok := n.AppendTo(buf[:0])
+	if !ok || len(name) < len(domain) {
+		return false
+	}
+	rest := name[len(name)-len(domain):]
+	return equalFoldASCII(rest, domain) && (len(name) == len(domain) || name[len(name)-len(domain)-1] == '.')
+}
+
+func equalFoldASCII(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := range b {
+		x, y := b[i], s[i]
+		if 'A' <= x && x <= 'Z' {
+			x += 'a' - 'A'
+		}
+		if 'A' <= y && y <= 'Z' {
+			y += 'a' - 'A'
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
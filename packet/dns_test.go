@@ -0,0 +1,139 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+// Response to query of www.Example.com A with CNAME pointing to
+// compressed name and A record of target.
+var dnsResponse = []byte{
+	0x12, 0x34, 0x81, 0x80, 0, 1, 0, 2, 0, 0, 0, 0,
+	// 12: www.Example.com IN A
+	3, 'w', 'w', 'w', 7, 'E', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 1, 0, 1,
+	// 33: www.example.com CNAME web.example.com
+	0xc0, 12, 0, 5, 0, 1, 0, 0, 0x0e, 0x10, 0, 6, 3, 'w', 'e', 'b', 0xc0, 16,
+	// 51: web.example.com A 192.0.2.1
+	0xc0, 45, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0, 2, 1,
+}
+
+func TestDNSParser(t *testing.T) {
+	var p DNSParser
+	if !p.Start(dnsResponse) {
+		t.Fatal("Start failed")
+	}
+	if p.Header.ID != 0x1234 || p.Header.Flags&DNSFlagQR == 0 || p.Header.QDCount != 1 || p.Header.ANCount != 2 {
+		t.Errorf("Wrong header %+v", p.Header)
+	}
+	var q DNSQuestion
+	if !p.Question(&q) {
+		t.Fatal("Question failed")
+	}
+	if q.Type != DNSTypeA || q.Class != DNSClassIN || q.Name.String() != "www.Example.com" {
+		t.Errorf("Wrong question %s %d %d", q.Name, q.Type, q.Class)
+	}
+	if p.Question(&q) {
+		t.Error("Parsed more questions than header has")
+	}
+	var rr DNSResource
+	if !p.Resource(&rr) {
+		t.Fatal("Resource failed")
+	}
+	target, ok := rr.Target()
+	if rr.Type != DNSTypeCNAME || rr.TTL != 3600 || !rr.Name.Equal("www.example.com.") || !ok || target.String() != "web.Example.com" {
+		t.Errorf("Wrong CNAME record %s %d %d %s", rr.Name, rr.Type, rr.TTL, target)
+	}
+	if !p.Resource(&rr) {
+		t.Fatal("Resource failed")
+	}
+	addr, ok := rr.IPv4()
+	if !ok || addr != types.BytesToIPv4(192, 0, 2, 1) || !rr.Name.Equal("WEB.example.com") {
+		t.Errorf("Wrong A record %s %v", rr.Name, addr)
+	}
+	if _, ok := rr.IPv6(); ok {
+		t.Error("A record has IPv6 address")
+	}
+	if p.Resource(&rr) || p.Malformed() {
+		t.Error("Parser didn't stop at the end of message")
+	}
+}
+
+func TestDNSName(t *testing.T) {
+	var p DNSParser
+	var q DNSQuestion
+	p.Start(dnsResponse)
+	p.Question(&q)
+	for _, name := range []string{"www.example.com", "WWW.EXAMPLE.COM."} {
+		if !q.Name.Equal(name) {
+			t.Errorf("Name isn't equal to %s", name)
+		}
+	}
+	for _, name := range []string{"www.example.co", "www.example.com.org", "www", "", "wwwexample.com"} {
+		if q.Name.Equal(name) {
+			t.Errorf("Name is equal to %s", name)
+		}
+	}
+	for _, domain := range []string{"com", "example.COM", "www.example.com.", ""} {
+		if !q.Name.HasSuffix(domain) {
+			t.Errorf("Name doesn't have suffix %s", domain)
+		}
+	}
+	for _, domain := range []string{"om", "ample.com", "a.www.example.com"} {
+		if q.Name.HasSuffix(domain) {
+			t.Errorf("Name has suffix %s", domain)
+		}
+	}
+}
+
+func TestDNSMalformed(t *testing.T) {
+	var p DNSParser
+	var rr DNSResource
+	for i := DNSHdrLen; i < len(dnsResponse); i++ {
+		p.Start(dnsResponse[:i])
+		for p.Resource(&rr) {
+		}
+		if !p.Malformed() {
+			t.Errorf("Message truncated to %d bytes isn't malformed", i)
+		}
+	}
+	if p.Start(dnsResponse[:DNSHdrLen-1]) {
+		t.Error("Start accepted truncated header")
+	}
+	// Name which points to itself
+	loop := append(append([]byte(nil), dnsResponse[:DNSHdrLen]...), 0xc0, 12, 0, 1, 0, 1)
+	var q DNSQuestion
+	p.Start(loop)
+	if !p.Question(&q) {
+		t.Fatal("Question failed")
+	}
+	if _, ok := q.Name.AppendTo(nil); ok || q.Name.Equal("") {
+		t.Error("Looped name was accepted")
+	}
+}
+
+func TestDNSAllocations(t *testing.T) {
+	var p DNSParser
+	var q DNSQuestion
+	var rr DNSResource
+	allocs := testing.AllocsPerRun(100, func() {
+		p.Start(dnsResponse)
+		for p.Question(&q) {
+			q.Name.HasSuffix("example.com")
+		}
+		for p.Resource(&rr) {
+			rr.Name.Equal("www.example.com")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Parser made %v allocations", allocs)
+	}
+}
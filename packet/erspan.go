@@ -0,0 +1,240 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// ERSPAN constants (draft-foschiano-erspan)
+const (
+	// ERSPAN types are equal to version field of ERSPAN header
+	ERSPANTypeII  = 1
+	ERSPANTypeIII = 2
+
+	// Encapsulation types of mirrored frame in Type II header
+	ERSPANEncapNoVLAN   = 0
+	ERSPANEncapISL      = 1
+	ERSPANEncapVLAN     = 2
+	ERSPANEncapVLANKept = 3
+
+	// Bad, short and oversized frame values of Type III header
+	ERSPANFrameGood      = 0
+	ERSPANFrameShort     = 1
+	ERSPANFrameOversized = 2
+	ERSPANFrameBad       = 3
+
+	// Timestamp granularities of Type III header
+	ERSPANGranularity100us    = 0
+	ERSPANGranularity100ns    = 1
+	ERSPANGranularityIEEE1588 = 2
+	ERSPANGranularityUser     = 3
+
+	// ERSPANMaxSessionID is a maximum ERSPAN session ID
+	ERSPANMaxSessionID = 0x3ff
+
+	erspanIILen        = 8
+	erspanIIILen       = 12
+	erspanIIISubHdrLen = 8
+	erspanVLANMask     = 0x0fff
+	erspanIndexMask    = 0xfffff
+	erspanHwIDMask     = 0x3f
+	erspanFrameTypeEth = 0
+	// GRE header of ERSPAN packets has sequence number
+	erspanGRELen = types.GRELen + greFieldLen
+	erspanTTL    = 64
+)
+
+// ERSPANHeader is a Type II or Type III ERSPAN header in host byte
+// order. Platform specific subheader of Type III header isn't
+// supported.
+type ERSPANHeader struct {
+	Type uint8
+	// VLAN of mirrored frame
+	VLAN uint16
+	// Class of service of mirrored frame
+	COS uint8
+	// Encapsulation type of Type II header or bad, short and oversized
+	// frame value of Type III header
+	Encap uint8
+	// Mirrored frame was truncated
+	Truncated bool
+	SessionID uint16
+	// Port index of Type II header
+	Index uint32
+	// Fields of Type III header
+	Timestamp   uint32
+	SGT         uint16
+	HardwareID  uint8
+	Egress      bool
+	Granularity uint8
+}
+
+func (h *ERSPANHeader) String() string {
+	return fmt.Sprintf("ERSPAN: type = %d, session = %d, VLAN = %d, COS = %d, encapsulation = %d, truncated = %t, index = %d, timestamp = %d",
+		h.Type, h.SessionID, h.VLAN, h.COS, h.Encap, h.Truncated, h.Index, h.Timestamp)
+}
+
+func (h *ERSPANHeader) protocol() uint16 {
+	if h.Type == ERSPANTypeIII {
+		return types.ERSPANIIINumber
+	}
+	return types.ERSPANIINumber
+}
+
+// AppendTo appends ERSPAN header to b. Returns b unchanged if type of
+// header is unknown.
+func (h *ERSPANHeader) AppendTo(b []byte) []byte {
+	if h.Type != ERSPANTypeII && h.Type != ERSPANTypeIII {
+		return b
+	}
+	var buf [erspanIIILen]byte
+	binary.BigEndian.PutUint16(buf[0:], uint16(h.Type)<<12|h.VLAN&erspanVLANMask)
+	word := uint16(h.COS&7)<<13 | uint16(h.Encap&3)<<11 | h.SessionID&ERSPANMaxSessionID
+	if h.Truncated {
+		word |= 1 << 10
+	}
+	binary.BigEndian.PutUint16(buf[2:], word)
+	if h.Type == ERSPANTypeII {
+		binary.BigEndian.PutUint32(buf[4:], h.Index&erspanIndexMask)
+		return append(b, buf[:erspanIILen]...)
+	}
+	binary.BigEndian.PutUint32(buf[4:], h.Timestamp)
+	binary.BigEndian.PutUint16(buf[8:], h.SGT)
+	word = uint16(erspanFrameTypeEth)<<10 | uint16(h.HardwareID&erspanHwIDMask)<<4 | uint16(h.Granularity&3)<<1
+	if h.Egress {
+		word |= 1 << 3
+	}
+	binary.BigEndian.PutUint16(buf[10:], word)
+	return append(b, buf[:]...)
+}
+
+// ParseERSPAN fills h from ERSPAN header and returns length of header
+// including optional platform specific subheader. Returns zero if
+// header is truncated, has unknown type or mirrored frame isn't
+// Ethernet frame.
+func ParseERSPAN(data []byte, h *ERSPANHeader) int {
+	*h = ERSPANHeader{}
+	if len(data) < erspanIILen {
+		return 0
+	}
+	word := binary.BigEndian.Uint16(data[0:])
+	h.Type = uint8(word >> 12)
+	h.VLAN = word & erspanVLANMask
+	word = binary.BigEndian.Uint16(data[2:])
+	h.COS = uint8(word >> 13)
+	h.Encap = uint8(word>>11) & 3
+	h.Truncated = word&(1<<10) != 0
+	h.SessionID = word & ERSPANMaxSessionID
+	switch h.Type {
+	case ERSPANTypeII:
+		h.Index = binary.BigEndian.Uint32(data[4:]) & erspanIndexMask
+		return erspanIILen
+	case ERSPANTypeIII:
+		if len(data) < erspanIIILen {
+			return 0
+		}
+		h.Timestamp = binary.BigEndian.Uint32(data[4:])
+		h.SGT = binary.BigEndian.Uint16(data[8:])
+		word = binary.BigEndian.Uint16(data[10:])
+		if word>>10 != erspanFrameTypeEth {
+			return 0
+		}
+		h.HardwareID = uint8(word>>4) & erspanHwIDMask
+		h.Egress = word&(1<<3) != 0
+		h.Granularity = uint8(word>>1) & 3
+		if word&1 == 0 {
+			return erspanIIILen
+		}
+		if len(data) < erspanIIILen+erspanIIISubHdrLen {
+			return 0
+		}
+		return erspanIIILen + erspanIIISubHdrLen
+	}
+	return 0
+}
+
+// EncapsulateERSPAN adds outer Ethernet header from srcMAC to dstMAC,
+// IPv4 header from src to dst, GRE header with sequence number seq and
+// ERSPAN header h before the whole frame of packet, so that it can be
+// sent to remote analyzer. Outer IPv4 header has DF flag and its
+// checksum is calculated. Returns false if type of h is unknown or
+// packet can't be extended.
+func (packet *Packet) EncapsulateERSPAN(srcMAC, dstMAC types.MACAddress, src, dst types.IPv4Address, seq uint32, h *ERSPANHeader) bool {
+	var buf [erspanIIILen]byte
+	erspan := h.AppendTo(buf[:0])
+	if len(erspan) == 0 {
+		return false
+	}
+	outerLen := uint(types.EtherLen + types.IPv4MinLen + erspanGRELen + len(erspan))
+	length := packet.GetPacketLen()
+	if !packet.EncapsulateHead(0, outerLen) {
+		return false
+	}
+	packet.Ether.DAddr = dstMAC
+	packet.Ether.SAddr = srcMAC
+	packet.Ether.EtherType = SwapBytesUint16(types.IPV4Number)
+	packet.ParseL3()
+	ipv4 := packet.GetIPv4NoCheck()
+	*ipv4 = IPv4Hdr{
+		VersionIhl:     types.IPv4VersionIhl,
+		TotalLength:    SwapBytesUint16(uint16(length + outerLen - types.EtherLen)),
+		FragmentOffset: SwapBytesUint16(types.IPv4DontFragment),
+		TimeToLive:     erspanTTL,
+		NextProtoID:    types.GRENumber,
+		SrcAddr:        src,
+		DstAddr:        dst,
+	}
+	ipv4.HdrChecksum = SwapBytesUint16(CalculateIPv4Checksum(ipv4))
+	packet.ParseL4ForIPv4()
+	gre := packet.GetGRENoCheck()
+	gre.Flags = SwapBytesUint16(GREFlagSeq)
+	gre.NextProto = SwapBytesUint16(h.protocol())
+	*(*uint32)(unsafe.Pointer(uintptr(packet.L4) + types.GRELen)) = SwapBytesUint32(seq)
+	copy((*[erspanIIILen]byte)(unsafe.Pointer(uintptr(packet.L4) + erspanGRELen))[:], erspan)
+	return true
+}
+
+// DecapsulateERSPAN removes outer Ethernet, IPv4, GRE and ERSPAN
+// headers of packet, fills h from ERSPAN header and returns sequence
+// number of GRE header. L3 header should be parsed and packet should
+// be IPv4 GRE packet without VLAN tag. Returns false if packet isn't
+// ERSPAN packet of supported type or its headers aren't in the first
+// segment.
+func (packet *Packet) DecapsulateERSPAN(h *ERSPANHeader) (uint32, bool) {
+	ipv4 := packet.GetIPv4()
+	if ipv4 == nil || ipv4.NextProtoID != types.GRENumber ||
+		SwapBytesUint16(ipv4.FragmentOffset)&(types.IPv4MoreFragments|types.IPv4FragmentOffsetMask) != 0 {
+		return 0, false
+	}
+	hdrLen := uint(ipv4.VersionIhl&0x0f) * 4
+	b := packet.GetSegmentBytes()
+	offset := uint(uintptr(packet.L3)-uintptr(unsafe.Pointer(packet.Ether))) + hdrLen
+	if offset+erspanGRELen > uint(len(b)) {
+		return 0, false
+	}
+	gre := (*GREHdr)(unsafe.Pointer(&b[offset]))
+	flags := SwapBytesUint16(gre.Flags)
+	if flags&(GREFlagRouting|greVersionMask) != 0 || flags&GREFlagSeq == 0 {
+		return 0, false
+	}
+	greLen := gre.HeaderLen()
+	if offset+greLen > uint(len(b)) {
+		return 0, false
+	}
+	seq := binary.BigEndian.Uint32(b[offset+greLen-greFieldLen:])
+	n := ParseERSPAN(b[offset+greLen:], h)
+	if n == 0 || SwapBytesUint16(gre.NextProto) != h.protocol() {
+		return 0, false
+	}
+	if !packet.DecapsulateHead(0, offset+greLen+uint(n)) {
+		return 0, false
+	}
+	return seq, true
+}
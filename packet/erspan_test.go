@@ -0,0 +1,109 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+var testERSPANHeaders = []ERSPANHeader{
+	{
+		Type:      ERSPANTypeII,
+		VLAN:      100,
+		COS:       5,
+		Encap:     ERSPANEncapVLANKept,
+		Truncated: true,
+		SessionID: 0x2aa,
+		Index:     0xabcde,
+	},
+	{
+		Type:        ERSPANTypeIII,
+		VLAN:        0xfff,
+		COS:         1,
+		Encap:       ERSPANFrameShort,
+		SessionID:   ERSPANMaxSessionID,
+		Timestamp:   0x12345678,
+		SGT:         0xbeef,
+		HardwareID:  0x2a,
+		Egress:      true,
+		Granularity: ERSPANGranularity100ns,
+	},
+}
+
+func TestERSPANAppendParse(t *testing.T) {
+	for i := range testERSPANHeaders {
+		h := &testERSPANHeaders[i]
+		data := h.AppendTo(nil)
+		var parsed ERSPANHeader
+		if n := ParseERSPAN(data, &parsed); n != len(data) || parsed != *h {
+			t.Errorf("Parsed %v with length %d instead of %v with length %d", &parsed, n, h, len(data))
+		}
+		if ParseERSPAN(data[:len(data)-1], &parsed) != 0 {
+			t.Errorf("Truncated header %x was parsed", data[:len(data)-1])
+		}
+	}
+	if len((&ERSPANHeader{Type: 3}).AppendTo(nil)) != 0 {
+		t.Error("Header of unknown type was appended")
+	}
+	data := testERSPANHeaders[1].AppendTo(nil)
+	// Platform specific subheader
+	data[11] |= 1
+	var h ERSPANHeader
+	if ParseERSPAN(data, &h) != 0 {
+		t.Error("Header without platform specific subheader was parsed")
+	}
+	if n := ParseERSPAN(append(data, make([]byte, erspanIIISubHdrLen)...), &h); n != erspanIIILen+erspanIIISubHdrLen {
+		t.Errorf("Header with platform specific subheader has length %d", n)
+	}
+}
+
+func TestERSPANEncapsulation(t *testing.T) {
+	srcMAC := types.MACAddress{0x02, 0, 0, 0, 0, 0x01}
+	dstMAC := types.MACAddress{0x02, 0, 0, 0, 0, 0x02}
+	local := types.BytesToIPv4(192, 0, 2, 1)
+	analyzer := types.BytesToIPv4(198, 51, 100, 1)
+	for i := range testERSPANHeaders {
+		h := &testERSPANHeaders[i]
+		pkt := getIPv4UDPTestPacket()
+		mirrored := append([]byte(nil), pkt.GetRawPacketBytes()...)
+		if !pkt.EncapsulateERSPAN(srcMAC, dstMAC, local, analyzer, 42, h) {
+			t.Fatal("EncapsulateERSPAN failed")
+		}
+		if pkt.Ether.SAddr != srcMAC || pkt.Ether.DAddr != dstMAC {
+			t.Errorf("Wrong outer Ethernet header %v", pkt.Ether)
+		}
+		pkt.ParseL3()
+		ipv4 := pkt.GetIPv4()
+		if ipv4 == nil || ipv4.NextProtoID != types.GRENumber || ipv4.SrcAddr != local || ipv4.DstAddr != analyzer {
+			t.Fatalf("Wrong outer header %v", ipv4)
+		}
+		if CalculateIPv4Checksum(ipv4) != SwapBytesUint16(ipv4.HdrChecksum) {
+			t.Error("Wrong outer header checksum")
+		}
+		if int(SwapBytesUint16(ipv4.TotalLength)) != len(pkt.GetRawPacketBytes())-types.EtherLen {
+			t.Errorf("Outer length is %d", SwapBytesUint16(ipv4.TotalLength))
+		}
+		pkt.ParseL4ForIPv4()
+		gre := pkt.GetGREForIPv4()
+		if SwapBytesUint16(gre.NextProto) != h.protocol() || gre.HeaderLen() != erspanGRELen {
+			t.Errorf("Wrong GRE header %v", gre)
+		}
+		var parsed ERSPANHeader
+		seq, ok := pkt.DecapsulateERSPAN(&parsed)
+		if !ok || seq != 42 || parsed != *h {
+			t.Fatalf("DecapsulateERSPAN returned %v, %d, %v", &parsed, seq, ok)
+		}
+		if !bytes.Equal(pkt.GetRawPacketBytes(), mirrored) {
+			t.Errorf("Decapsulated packet\n%x\nis different from mirrored\n%x", pkt.GetRawPacketBytes(), mirrored)
+		}
+	}
+}
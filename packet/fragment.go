@@ -0,0 +1,278 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"unsafe"
+
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/types"
+)
+
+// IPv4 option types which are used in fragmentation
+const (
+	ipv4OptionEnd     = 0
+	ipv4OptionNOP     = 1
+	ipv4OptionCopyBit = 0x80
+)
+
+// IPv6FragmentHdr is a fragment extension header of IPv6.
+type IPv6FragmentHdr struct {
+	NextHeader     uint8  // Protocol of fragmented packet
+	Reserved       uint8  // Reserved field
+	FragmentOffset uint16 // Offset in 8 bytes units and More Fragments flag
+	Identification uint32 // Identification of fragmented packet
+}
+
+// More Fragments flag of IPv6FragmentHdr FragmentOffset field in host
+// byte order.
+const IPv6MoreFragments = 1
+
+// GetIPv6Fragment returns fragment header of IPv6 packet if it
+// follows IPv6 header or nil otherwise. L3 header should be parsed.
+func (packet *Packet) GetIPv6Fragment() *IPv6FragmentHdr {
+	hdr := packet.GetIPv6()
+	if hdr == nil || hdr.Proto != types.IPv6FragmentNumber {
+		return nil
+	}
+	return (*IPv6FragmentHdr)(unsafe.Pointer(uintptr(packet.L3) + types.IPv6Len))
+}
+
+// IPv4FragmentsNumber returns number of fragments which IPv4 packet
+// should be split to so that every fragment is not longer than mtu
+// bytes without L2 header. Returns 1 if packet already fits into mtu
+// and 0 if packet can't be fragmented because of Don't Fragment flag
+// or too small mtu. L3 header should be parsed.
+func (packet *Packet) IPv4FragmentsNumber(mtu uint) uint {
+	hdr := packet.GetIPv4NoCheck()
+	total := uint(SwapBytesUint16(hdr.TotalLength))
+	if total <= mtu {
+		return 1
+	}
+	if SwapBytesUint16(hdr.FragmentOffset)&types.IPv4DontFragment != 0 || packet.Next != nil {
+		return 0
+	}
+	ihl := uint(hdr.VersionIhl&0x0f) << 2
+	first, next := fragmentDataLen(packet, mtu)
+	if first == 0 || next == 0 {
+		return 0
+	}
+	data := total - ihl
+	if data <= first {
+		return 1
+	}
+	return 1 + (data-first+next-1)/next
+}
+
+// fragmentDataLen returns maximum number of data bytes in first and
+// following fragments. Following fragments contain only options which
+// have copy flag.
+func fragmentDataLen(packet *Packet, mtu uint) (uint, uint) {
+	hdr := packet.GetIPv4NoCheck()
+	ihl := uint(hdr.VersionIhl&0x0f) << 2
+	next := types.IPv4MinLen + uint(len(copiedIPv4Options(packet)))
+	var first, following uint
+	if mtu > ihl {
+		first = (mtu - ihl) &^ 7
+	}
+	if mtu > next {
+		following = (mtu - next) &^ 7
+	}
+	return first, following
+}
+
+// copiedIPv4Options returns options of IPv4 header which should be
+// copied to every fragment padded to 4 bytes.
+func copiedIPv4Options(packet *Packet) []byte {
+	hdr := packet.GetIPv4NoCheck()
+	ihl := int(hdr.VersionIhl&0x0f) << 2
+	options := (*[60]byte)(packet.L3)[types.IPv4MinLen:ihl]
+	var copied []byte
+	for i := 0; i < len(options); {
+		t := options[i]
+		if t == ipv4OptionEnd {
+			break
+		}
+		if t == ipv4OptionNOP {
+			i++
+			continue
+		}
+		if i+1 >= len(options) || options[i+1] < 2 || i+int(options[i+1]) > len(options) {
+			break
+		}
+		l := int(options[i+1])
+		if t&ipv4OptionCopyBit != 0 {
+			copied = append(copied, options[i:i+l]...)
+		}
+		i += l
+	}
+	for len(copied)%4 != 0 {
+		copied = append(copied, ipv4OptionEnd)
+	}
+	return copied
+}
+
+// FragmentIPv4 splits IPv4 packet into fragments which are not
+// longer than mtu bytes without L2 header. Packet itself becomes the
+// first fragment, other fragments are written to given empty packets.
+// Number of given packets should be IPv4FragmentsNumber(mtu) - 1. L2
+// header is copied to every fragment, first fragment keeps all IPv4
+// options, others get only options with copy flag. L4 checksum
+// should be calculated before fragmentation, IPv4 checksums of
+// fragments are calculated in software. Returns false if packet
+// can't be fragmented. L3 header should be parsed. Packets which
+// consist of several mbufs are not supported.
+func (packet *Packet) FragmentIPv4(mtu uint, fragments []*Packet) bool {
+	number := packet.IPv4FragmentsNumber(mtu)
+	if number == 0 || uint(len(fragments)) != number-1 {
+		return false
+	}
+	if number == 1 {
+		return true
+	}
+	hdr := packet.GetIPv4NoCheck()
+	l2 := uint(uintptr(packet.L3) - uintptr(unsafe.Pointer(packet.Ether)))
+	ihl := uint(hdr.VersionIhl&0x0f) << 2
+	total := uint(SwapBytesUint16(hdr.TotalLength))
+	flags := SwapBytesUint16(hdr.FragmentOffset)
+	offset := flags & types.IPv4FragmentOffsetMask
+	moreFragments := flags & types.IPv4MoreFragments
+	first, next := fragmentDataLen(packet, mtu)
+	options := copiedIPv4Options(packet)
+	raw := packet.GetRawPacketBytes()
+
+	// Following fragments
+	nextIhl := types.IPv4MinLen + uint(len(options))
+	start := l2 + ihl + first
+	for _, f := range fragments {
+		length := total - (start - l2)
+		last := true
+		if length > next {
+			length = next
+			last = false
+		}
+		frame := make([]byte, 0, l2+nextIhl+length)
+		frame = append(frame, raw[:l2+types.IPv4MinLen]...)
+		frame = append(frame, options...)
+		frame = append(frame, raw[start:start+length]...)
+		if !GeneratePacketFromByte(f, frame) {
+			return false
+		}
+		f.L3 = unsafe.Pointer(uintptr(unsafe.Pointer(f.Ether)) + uintptr(l2))
+		fhdr := f.GetIPv4NoCheck()
+		fhdr.VersionIhl = 0x40 | uint8(nextIhl>>2)
+		fhdr.TotalLength = SwapBytesUint16(uint16(nextIhl + length))
+		fragmentFlags := offset + uint16((start-l2-ihl)>>3)
+		if !last || moreFragments != 0 {
+			fragmentFlags |= types.IPv4MoreFragments
+		}
+		fhdr.FragmentOffset = SwapBytesUint16(fragmentFlags)
+		setIPv4HeaderChecksum(fhdr)
+		start += length
+	}
+
+	// First fragment
+	if !low.TrimMbuf(packet.CMbuf, packet.GetPacketLen()-(l2+ihl+first)) {
+		return false
+	}
+	hdr.TotalLength = SwapBytesUint16(uint16(ihl + first))
+	hdr.FragmentOffset = SwapBytesUint16(offset | types.IPv4MoreFragments)
+	setIPv4HeaderChecksum(hdr)
+	return true
+}
+
+// setIPv4HeaderChecksum calculates checksum of IPv4 header including
+// options.
+func setIPv4HeaderChecksum(hdr *IPv4Hdr) {
+	hdr.HdrChecksum = 0
+	ihl := int(hdr.VersionIhl&0x0f) << 2
+	hdr.HdrChecksum = SwapBytesUint16(^reduceChecksum(calculateDataChecksum(unsafe.Pointer(hdr), ihl, 0)))
+}
+
+// ReassembleFragments joins data of IPv4 or IPv6 fragments into the
+// first of them. Fragments should belong to one packet, be sorted by
+// offset and cover whole packet without gaps and overlaps, first
+// fragment should have zero offset. Header of the first fragment
+// becomes header of reassembled packet, fragment header is removed
+// from IPv6 packet. Other fragments are not changed and should be
+// freed by caller. Returns false if reassembled packet doesn't fit
+// into mbuf of the first fragment. L3 headers should be parsed.
+// Packets which consist of several mbufs are not supported.
+func ReassembleFragments(fragments []*Packet) bool {
+	first := fragments[0]
+	l2 := uint(uintptr(first.L3) - uintptr(unsafe.Pointer(first.Ether)))
+	var ipv4 *IPv4Hdr
+	var start, length uint
+	if l3IsIPv4(first) {
+		ipv4 = first.GetIPv4NoCheck()
+		start = l2 + uint(ipv4.VersionIhl&0x0f)<<2
+		length = l2 + uint(SwapBytesUint16(ipv4.TotalLength)) - start
+	} else {
+		start = l2 + types.IPv6Len + types.IPv6FragmentLen
+		length = uint(SwapBytesUint16(first.GetIPv6NoCheck().PayloadLen)) - types.IPv6FragmentLen
+	}
+	if first.Next != nil || !low.TrimMbuf(first.CMbuf, first.GetPacketLen()-(start+length)) {
+		return false
+	}
+	for _, f := range fragments[1:] {
+		data := fragmentData(f)
+		if data == nil || !low.AppendMbuf(first.CMbuf, uint(len(data))) {
+			return false
+		}
+		copy(first.GetRawPacketBytes()[start+length:], data)
+		length += uint(len(data))
+	}
+
+	if ipv4 != nil {
+		total := start - l2 + length
+		if total > 0xffff {
+			return false
+		}
+		ipv4.TotalLength = SwapBytesUint16(uint16(total))
+		ipv4.FragmentOffset &= SwapBytesUint16(types.IPv4DontFragment)
+		setIPv4HeaderChecksum(ipv4)
+		return true
+	}
+	if length > 0xffff {
+		return false
+	}
+	next := (*IPv6FragmentHdr)(unsafe.Pointer(uintptr(first.L3) + types.IPv6Len)).NextHeader
+	if !first.DecapsulateHead(l2+types.IPv6Len, types.IPv6FragmentLen) {
+		return false
+	}
+	first.L3 = unsafe.Pointer(uintptr(unsafe.Pointer(first.Ether)) + uintptr(l2))
+	ipv6 := first.GetIPv6NoCheck()
+	ipv6.Proto = next
+	ipv6.PayloadLen = SwapBytesUint16(uint16(length))
+	return true
+}
+
+// fragmentData returns data of IPv4 or IPv6 fragment.
+func fragmentData(packet *Packet) []byte {
+	if packet.Next != nil {
+		return nil
+	}
+	raw := packet.GetRawPacketBytes()
+	l2 := uint(uintptr(packet.L3) - uintptr(unsafe.Pointer(packet.Ether)))
+	var start, end uint
+	if l3IsIPv4(packet) {
+		ipv4 := packet.GetIPv4NoCheck()
+		start = l2 + uint(ipv4.VersionIhl&0x0f)<<2
+		end = l2 + uint(SwapBytesUint16(ipv4.TotalLength))
+	} else {
+		start = l2 + types.IPv6Len + types.IPv6FragmentLen
+		end = l2 + types.IPv6Len + uint(SwapBytesUint16(packet.GetIPv6NoCheck().PayloadLen))
+	}
+	if start > end || end > uint(len(raw)) {
+		return nil
+	}
+	return raw[start:end]
+}
+
+// l3IsIPv4 checks version of IP header, so L2 header can be of any
+// type.
+func l3IsIPv4(packet *Packet) bool {
+	return *(*uint8)(packet.L3)>>4 == 4
+}
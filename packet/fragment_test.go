@@ -0,0 +1,134 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func getFragmentTestPacket(payload uint) (*Packet, []byte) {
+	pkt := getPacket()
+	InitEmptyIPv4UDPPacket(pkt, payload)
+	initIPv4Addrs(pkt)
+	ipv4 := pkt.GetIPv4NoCheck()
+	ipv4.PacketID = SwapBytesUint16(0x1234)
+	data := (*[1 << 16]byte)(pkt.Data)[:payload]
+	for i := range data {
+		data[i] = byte(i)
+	}
+	ipv4.HdrChecksum = SwapBytesUint16(CalculateIPv4Checksum(ipv4))
+	l3 := pkt.GetRawPacketBytes()[types.EtherLen:]
+	return pkt, append([]byte(nil), l3[types.IPv4MinLen:]...)
+}
+
+func TestIPv4FragmentsNumber(t *testing.T) {
+	pkt, _ := getFragmentTestPacket(1000)
+	pkt.ParseL3()
+	// 1028 bytes of IPv4 packet, 1008 bytes of data
+	tests := []struct {
+		mtu    uint
+		number uint
+	}{
+		{1500, 1},
+		{1028, 1},
+		{1027, 2},
+		{576, 2},
+		{200, 6},
+		{27, 0},
+	}
+	for _, test := range tests {
+		if n := pkt.IPv4FragmentsNumber(test.mtu); n != test.number {
+			t.Errorf("MTU %d: got %d fragments, expected %d", test.mtu, n, test.number)
+		}
+	}
+	ipv4 := pkt.GetIPv4NoCheck()
+	ipv4.FragmentOffset = SwapBytesUint16(types.IPv4DontFragment)
+	if n := pkt.IPv4FragmentsNumber(576); n != 0 {
+		t.Errorf("got %d fragments for packet with Don't Fragment flag", n)
+	}
+}
+
+func TestFragmentIPv4(t *testing.T) {
+	pkt, data := getFragmentTestPacket(1000)
+	pkt.ParseL3()
+	mtu := uint(200)
+	n := pkt.IPv4FragmentsNumber(mtu)
+	fragments := make([]*Packet, n-1)
+	for i := range fragments {
+		fragments[i] = getPacket()
+	}
+	if !pkt.FragmentIPv4(mtu, fragments) {
+		t.Fatal("fragmentation failed")
+	}
+	var joined []byte
+	for i, f := range append([]*Packet{pkt}, fragments...) {
+		f.ParseL3()
+		hdr := f.GetIPv4NoCheck()
+		length := uint(SwapBytesUint16(hdr.TotalLength))
+		if length > mtu {
+			t.Errorf("fragment %d has length %d", i, length)
+		}
+		if f.GetPacketLen() != types.EtherLen+length {
+			t.Errorf("fragment %d has frame length %d, expected %d", i, f.GetPacketLen(), types.EtherLen+length)
+		}
+		flags := SwapBytesUint16(hdr.FragmentOffset)
+		if uint(flags&types.IPv4FragmentOffsetMask)*8 != uint(len(joined)) {
+			t.Errorf("fragment %d has offset %d, expected %d", i, (flags&types.IPv4FragmentOffsetMask)*8, len(joined))
+		}
+		if last := i == len(fragments); (flags&types.IPv4MoreFragments == 0) != last {
+			t.Errorf("fragment %d has wrong More Fragments flag", i)
+		}
+		if hdr.PacketID != SwapBytesUint16(0x1234) || hdr.SrcAddr != pkt.GetIPv4NoCheck().SrcAddr {
+			t.Errorf("fragment %d has wrong header fields", i)
+		}
+		if hdr.HdrChecksum != SwapBytesUint16(CalculateIPv4Checksum(hdr)) {
+			t.Errorf("fragment %d has wrong checksum", i)
+		}
+		joined = append(joined, f.GetRawPacketBytes()[types.EtherLen+types.IPv4MinLen:types.EtherLen+length]...)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Error("reassembled fragments differ from original data")
+	}
+}
+
+func TestReassembleIPv4Fragments(t *testing.T) {
+	pkt, data := getFragmentTestPacket(1000)
+	pkt.ParseL3()
+	mtu := uint(200)
+	fragments := make([]*Packet, pkt.IPv4FragmentsNumber(mtu)-1)
+	for i := range fragments {
+		fragments[i] = getPacket()
+	}
+	if !pkt.FragmentIPv4(mtu, fragments) {
+		t.Fatal("fragmentation failed")
+	}
+	all := append([]*Packet{pkt}, fragments...)
+	for _, f := range all {
+		f.ParseL3()
+	}
+	if !ReassembleFragments(all) {
+		t.Fatal("reassembly failed")
+	}
+	hdr := pkt.GetIPv4NoCheck()
+	if length := uint(SwapBytesUint16(hdr.TotalLength)); length != types.IPv4MinLen+uint(len(data)) {
+		t.Errorf("reassembled packet has length %d", length)
+	}
+	if hdr.FragmentOffset != 0 {
+		t.Error("reassembled packet has fragment flags")
+	}
+	if hdr.HdrChecksum != SwapBytesUint16(CalculateIPv4Checksum(hdr)) {
+		t.Error("reassembled packet has wrong checksum")
+	}
+	if !bytes.Equal(pkt.GetRawPacketBytes()[types.EtherLen+types.IPv4MinLen:], data) {
+		t.Error("reassembled data differ from original data")
+	}
+}
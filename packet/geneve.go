@@ -0,0 +1,228 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// GeneveHdr is a header of Geneve tunnel (RFC 8926) which follows UDP
+// header. It is followed by options and encapsulated packet.
+type GeneveHdr struct {
+	VerOptLen uint8    // Version (2 bits) and length of options in 4 byte words (6 bits)
+	Flags     uint8    // OAM and critical options flags
+	Proto     uint16   // EtherType of encapsulated packet
+	VNI       [3]uint8 // Virtual network identifier
+	Reserved  uint8
+}
+
+// GeneveOptionHdr is a header of Geneve option which is followed by
+// data of option.
+type GeneveOptionHdr struct {
+	Class  uint16 // Option class
+	Type   uint8  // Option type, high bit is set for critical options
+	Length uint8  // Length of data in 4 byte words (5 bits)
+}
+
+// GeneveOption is an option of Geneve header which is added by
+// EncapsulateIPv4Geneve. Length of data should be a multiple of 4 and
+// shouldn't exceed 124 bytes.
+type GeneveOption struct {
+	Class uint16
+	Type  uint8
+	Data  []byte
+}
+
+// Geneve constants
+const (
+	UDPPortGeneve     = 6081
+	SwapUDPPortGeneve = 0xc117
+
+	// EtherType of encapsulated Ethernet frames
+	GeneveProtoEthernet = 0x6558
+
+	GeneveFlagOAM      = 0x80
+	GeneveFlagCritical = 0x40
+	// Bit of option type of critical options
+	GeneveOptionCritical = 0x80
+
+	geneveOptionHdrLen     = 4
+	geneveMaxOptionsLen    = 0x3f * 4
+	geneveMaxOptionDataLen = 0x1f * 4
+)
+
+func (hdr *GeneveHdr) String() string {
+	return fmt.Sprintf("Geneve: version = %d, options length = %d, flags = 0x%02x, protocol = 0x%04x, VNI = %d",
+		hdr.VerOptLen>>6, hdr.OptionsLen(), hdr.Flags, SwapBytesUint16(hdr.Proto), hdr.GetVNI())
+}
+
+// OptionsLen returns length of options in bytes.
+func (hdr *GeneveHdr) OptionsLen() uint {
+	return uint(hdr.VerOptLen&0x3f) * 4
+}
+
+// HeaderLen returns length of Geneve header with options.
+func (hdr *GeneveHdr) HeaderLen() uint {
+	return types.GeneveLen + hdr.OptionsLen()
+}
+
+// GetVNI returns virtual network identifier.
+func (hdr *GeneveHdr) GetVNI() uint32 {
+	return uint32(hdr.VNI[0])<<16 | uint32(hdr.VNI[1])<<8 | uint32(hdr.VNI[2])
+}
+
+// SetVNI sets virtual network identifier, only 24 lower bits of vni
+// are used.
+func (hdr *GeneveHdr) SetVNI(vni uint32) {
+	hdr.VNI = [3]uint8{uint8(vni >> 16), uint8(vni >> 8), uint8(vni)}
+}
+
+// GeneveOptions calls fn for every option of Geneve header until fn
+// returns false. Data of option is a slice of packet. Returns false if
+// length of option exceeds length of options.
+func GeneveOptions(hdr *GeneveHdr, fn func(opt *GeneveOptionHdr, data []byte) bool) bool {
+	length := hdr.OptionsLen()
+	for offset := uint(0); offset < length; {
+		if offset+geneveOptionHdrLen > length {
+			return false
+		}
+		opt := (*GeneveOptionHdr)(unsafe.Pointer(uintptr(unsafe.Pointer(hdr)) + uintptr(types.GeneveLen+offset)))
+		dataLen := uint(opt.Length&0x1f) * 4
+		offset += geneveOptionHdrLen
+		if offset+dataLen > length {
+			return false
+		}
+		data := (*[geneveMaxOptionsLen]byte)(unsafe.Pointer(uintptr(unsafe.Pointer(hdr)) + uintptr(types.GeneveLen+offset)))[:dataLen:dataLen]
+		if !fn(opt, data) {
+			return true
+		}
+		offset += dataLen
+	}
+	return true
+}
+
+// GetGeneve returns Geneve header if L4 header is UDP header with
+// Geneve destination port. L7 should be parsed for UDP protocol.
+func (packet *Packet) GetGeneve() *GeneveHdr {
+	if (*UDPHdr)(packet.L4).DstPort == SwapUDPPortGeneve {
+		return (*GeneveHdr)(packet.Data)
+	}
+	return nil
+}
+
+// GetGeneveNoCheck casts Data pointer to *GeneveHdr type.
+func (packet *Packet) GetGeneveNoCheck() *GeneveHdr {
+	return (*GeneveHdr)(packet.Data)
+}
+
+// EncapsulateIPv4Geneve adds outer Ethernet, IPv4, UDP and Geneve
+// headers with given options before Ethernet frame of packet. UDP
+// source port should be derived from flow of encapsulated packet, UDP
+// checksum is not calculated. Outer Ethernet addresses are copied from
+// encapsulated frame. Returns false if options are invalid or packet
+// can't be extended.
+func (packet *Packet) EncapsulateIPv4Geneve(src, dst types.IPv4Address, srcPort uint16, vni uint32, options []GeneveOption) bool {
+	optLen := uint(0)
+	for i := range options {
+		dataLen := uint(len(options[i].Data))
+		if dataLen%4 != 0 || dataLen > geneveMaxOptionDataLen {
+			return false
+		}
+		optLen += geneveOptionHdrLen + dataLen
+	}
+	if optLen > geneveMaxOptionsLen {
+		return false
+	}
+	length := packet.GetPacketLen()
+	tunnelLen := types.IPv4MinLen + types.UDPLen + types.GeneveLen + optLen
+	if !packet.EncapsulateHead(0, types.EtherLen+tunnelLen) {
+		return false
+	}
+	inner := (*EtherHdr)(unsafe.Pointer(uintptr(unsafe.Pointer(packet.Ether)) + uintptr(types.EtherLen+tunnelLen)))
+	packet.Ether.DAddr = inner.DAddr
+	packet.Ether.SAddr = inner.SAddr
+	packet.Ether.EtherType = SwapBytesUint16(types.IPV4Number)
+	packet.ParseL3()
+	ipv4 := packet.GetIPv4NoCheck()
+	*ipv4 = IPv4Hdr{
+		VersionIhl:  types.IPv4VersionIhl,
+		TotalLength: SwapBytesUint16(uint16(length + tunnelLen)),
+		TimeToLive:  64,
+		NextProtoID: types.UDPNumber,
+		SrcAddr:     src,
+		DstAddr:     dst,
+	}
+	ipv4.HdrChecksum = SwapBytesUint16(CalculateIPv4Checksum(ipv4))
+	packet.ParseL4ForIPv4()
+	udp := packet.GetUDPNoCheck()
+	*udp = UDPHdr{
+		SrcPort:  SwapBytesUint16(srcPort),
+		DstPort:  SwapUDPPortGeneve,
+		DgramLen: SwapBytesUint16(uint16(length + tunnelLen - types.IPv4MinLen)),
+	}
+	packet.ParseL7(types.UDPNumber)
+	geneve := packet.GetGeneveNoCheck()
+	*geneve = GeneveHdr{
+		VerOptLen: uint8(optLen / 4),
+		Proto:     SwapBytesUint16(GeneveProtoEthernet),
+	}
+	geneve.SetVNI(vni)
+	offset := uintptr(types.GeneveLen)
+	for i := range options {
+		opt := (*GeneveOptionHdr)(unsafe.Pointer(uintptr(packet.Data) + offset))
+		dataLen := len(options[i].Data)
+		*opt = GeneveOptionHdr{
+			Class:  SwapBytesUint16(options[i].Class),
+			Type:   options[i].Type,
+			Length: uint8(dataLen / 4),
+		}
+		offset += geneveOptionHdrLen
+		copy((*[geneveMaxOptionDataLen]byte)(unsafe.Pointer(uintptr(packet.Data) + offset))[:dataLen], options[i].Data)
+		offset += uintptr(dataLen)
+	}
+	return true
+}
+
+// DecapsulateGeneve removes outer headers of Geneve packet. L3, L4 and
+// L7 headers should be parsed and packet should be IPv4 or IPv6 Geneve
+// packet without VLAN tag. Encapsulated Ethernet frame replaces whole
+// packet, encapsulated IPv4 or IPv6 packet gets outer Ethernet header
+// with its EtherType. Returns false if outer packet is fragmented,
+// Geneve header has unsupported version or critical options, options
+// are malformed or encapsulated protocol isn't Ethernet, IPv4 or IPv6.
+func (packet *Packet) DecapsulateGeneve() bool {
+	if SwapBytesUint16(packet.Ether.EtherType) == types.IPV4Number {
+		ipv4 := packet.GetIPv4NoCheck()
+		if SwapBytesUint16(ipv4.FragmentOffset)&(types.IPv4MoreFragments|types.IPv4FragmentOffsetMask) != 0 {
+			return false
+		}
+	}
+	geneve := packet.GetGeneveNoCheck()
+	if geneve.VerOptLen>>6 != 0 || geneve.Flags&GeneveFlagCritical != 0 {
+		return false
+	}
+	tunnelLen := uint(uintptr(packet.Data)-uintptr(packet.L3)) + geneve.HeaderLen()
+	if types.EtherLen+tunnelLen > packet.GetPacketLen() ||
+		!GeneveOptions(geneve, func(*GeneveOptionHdr, []byte) bool { return true }) {
+		return false
+	}
+	switch proto := SwapBytesUint16(geneve.Proto); proto {
+	case GeneveProtoEthernet:
+		if types.EtherLen+tunnelLen+types.EtherLen > packet.GetPacketLen() {
+			return false
+		}
+		return packet.DecapsulateHead(0, types.EtherLen+tunnelLen)
+	case types.IPV4Number, types.IPV6Number:
+		if !packet.DecapsulateHead(types.EtherLen, tunnelLen) {
+			return false
+		}
+		packet.Ether.EtherType = SwapBytesUint16(proto)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func TestGeneveEncapsulation(t *testing.T) {
+	local := types.BytesToIPv4(192, 0, 2, 1)
+	remote := types.BytesToIPv4(198, 51, 100, 1)
+	options := []GeneveOption{
+		{Class: 0x0102, Type: 0x80, Data: []byte{1, 2, 3, 4}},
+		{Class: 0xffff, Type: 0x01},
+		{Class: 0x0103, Type: 0x02, Data: []byte{5, 6, 7, 8, 9, 10, 11, 12}},
+	}
+	pkt := getIPv4UDPTestPacket()
+	inner := append([]byte(nil), pkt.GetRawPacketBytes()...)
+	if !pkt.EncapsulateIPv4Geneve(local, remote, 50000, 0x123456, options) {
+		t.Fatal("EncapsulateIPv4Geneve failed")
+	}
+	pkt.ParseL3()
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil || ipv4.NextProtoID != types.UDPNumber || ipv4.SrcAddr != local || ipv4.DstAddr != remote {
+		t.Fatalf("Wrong outer header %v", ipv4)
+	}
+	if CalculateIPv4Checksum(ipv4) != SwapBytesUint16(ipv4.HdrChecksum) {
+		t.Error("Wrong outer header checksum")
+	}
+	pkt.ParseL4ForIPv4()
+	pkt.ParseL7(types.UDPNumber)
+	geneve := pkt.GetGeneve()
+	if geneve == nil {
+		t.Fatal("GetGeneve returned nil")
+	}
+	if geneve.GetVNI() != 0x123456 || SwapBytesUint16(geneve.Proto) != GeneveProtoEthernet || geneve.OptionsLen() != 24 {
+		t.Errorf("Wrong Geneve header %v", geneve)
+	}
+	var parsed []GeneveOption
+	if !GeneveOptions(geneve, func(opt *GeneveOptionHdr, data []byte) bool {
+		parsed = append(parsed, GeneveOption{Class: SwapBytesUint16(opt.Class), Type: opt.Type, Data: append([]byte(nil), data...)})
+		return true
+	}) {
+		t.Fatal("GeneveOptions failed")
+	}
+	if len(parsed) != len(options) {
+		t.Fatalf("Parsed %d options instead of %d", len(parsed), len(options))
+	}
+	for i := range options {
+		if parsed[i].Class != options[i].Class || parsed[i].Type != options[i].Type || !bytes.Equal(parsed[i].Data, options[i].Data) {
+			t.Errorf("Option %d is %v instead of %v", i, parsed[i], options[i])
+		}
+	}
+	if !pkt.DecapsulateGeneve() {
+		t.Fatal("DecapsulateGeneve failed")
+	}
+	if !bytes.Equal(pkt.GetRawPacketBytes(), inner) {
+		t.Errorf("Decapsulated packet\n%x\nis different from original\n%x", pkt.GetRawPacketBytes(), inner)
+	}
+}
+
+func TestGeneveRejected(t *testing.T) {
+	remote := types.BytesToIPv4(198, 51, 100, 1)
+	pkt := getIPv4UDPTestPacket()
+	if pkt.EncapsulateIPv4Geneve(0, remote, 1, 1, []GeneveOption{{Data: []byte{1, 2, 3}}}) {
+		t.Error("Option with length which isn't multiple of 4 was accepted")
+	}
+	if !pkt.EncapsulateIPv4Geneve(0, remote, 1, 1, []GeneveOption{{Data: []byte{1, 2, 3, 4}}}) {
+		t.Fatal("EncapsulateIPv4Geneve failed")
+	}
+	pkt.ParseL3()
+	pkt.ParseL4ForIPv4()
+	pkt.ParseL7(types.UDPNumber)
+	geneve := pkt.GetGeneveNoCheck()
+	geneve.Flags = GeneveFlagCritical
+	if pkt.DecapsulateGeneve() {
+		t.Error("Packet with critical options was decapsulated")
+	}
+	geneve.Flags = 0
+	// Option is longer than options
+	geneve.VerOptLen = 1
+	if pkt.DecapsulateGeneve() {
+		t.Error("Packet with malformed options was decapsulated")
+	}
+}
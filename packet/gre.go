@@ -6,6 +6,7 @@ package packet
 
 import (
 	"fmt"
+	"unsafe"
 
 	"github.com/intel-go/nff-go/types"
 )
@@ -20,6 +21,45 @@ func (hdr *GREHdr) String() string {
 		hdr.Flags, hdr.NextProto)
 }
 
+// GRE flags in host byte order
+const (
+	GREFlagChecksum = 0x8000
+	GREFlagRouting  = 0x4000
+	GREFlagKey      = 0x2000
+	GREFlagSeq      = 0x1000
+	greVersionMask  = 0x0007
+)
+
+// Length of every optional field of GRE header
+const greFieldLen = 4
+
+// HeaderLen returns length of GRE header with optional checksum, key
+// and sequence number fields.
+func (hdr *GREHdr) HeaderLen() uint {
+	flags := SwapBytesUint16(hdr.Flags)
+	length := uint(types.GRELen)
+	for _, f := range []uint16{GREFlagChecksum, GREFlagKey, GREFlagSeq} {
+		if flags&f != 0 {
+			length += greFieldLen
+		}
+	}
+	return length
+}
+
+// GetKey returns key of GRE header. Returns false if header doesn't
+// have key.
+func (hdr *GREHdr) GetKey() (uint32, bool) {
+	flags := SwapBytesUint16(hdr.Flags)
+	if flags&GREFlagKey == 0 {
+		return 0, false
+	}
+	offset := uintptr(types.GRELen)
+	if flags&GREFlagChecksum != 0 {
+		offset += greFieldLen
+	}
+	return SwapBytesUint32(*(*uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(hdr)) + offset))), true
+}
+
 // GetGREForIPv4 casts L4 pointer to *GREHdr type.
 func (packet *Packet) GetGREForIPv4() *GREHdr {
 	if packet.GetIPv4NoCheck().NextProtoID == types.GRENumber {
@@ -32,3 +72,76 @@ func (packet *Packet) GetGREForIPv4() *GREHdr {
 func (packet *Packet) GetGRENoCheck() *GREHdr {
 	return (*GREHdr)(packet.L4)
 }
+
+// EncapsulateIPv4GRE adds outer IPv4 header from src to dst and GRE
+// header after Ethernet header of IPv4 or IPv6 packet. GRE header has
+// key field if withKey is true. Outer IPv4 header checksum is
+// calculated, Ethernet addresses are not changed. Packet shouldn't
+// have VLAN tag. Returns false if packet isn't IPv4 or IPv6 or can't
+// be extended.
+func (packet *Packet) EncapsulateIPv4GRE(src, dst types.IPv4Address, key uint32, withKey bool) bool {
+	proto := SwapBytesUint16(packet.Ether.EtherType)
+	if proto != types.IPV4Number && proto != types.IPV6Number {
+		return false
+	}
+	greLen := uint(types.GRELen)
+	if withKey {
+		greLen += greFieldLen
+	}
+	length := packet.GetPacketLen() - types.EtherLen
+	if !packet.EncapsulateHead(types.EtherLen, types.IPv4MinLen+greLen) {
+		return false
+	}
+	packet.Ether.EtherType = SwapBytesUint16(types.IPV4Number)
+	packet.ParseL3()
+	ipv4 := packet.GetIPv4NoCheck()
+	*ipv4 = IPv4Hdr{
+		VersionIhl:  types.IPv4VersionIhl,
+		TotalLength: SwapBytesUint16(uint16(length + types.IPv4MinLen + greLen)),
+		TimeToLive:  64,
+		NextProtoID: types.GRENumber,
+		SrcAddr:     src,
+		DstAddr:     dst,
+	}
+	ipv4.HdrChecksum = SwapBytesUint16(CalculateIPv4Checksum(ipv4))
+	packet.ParseL4ForIPv4()
+	gre := packet.GetGRENoCheck()
+	gre.Flags = 0
+	gre.NextProto = SwapBytesUint16(proto)
+	if withKey {
+		gre.Flags = SwapBytesUint16(GREFlagKey)
+		*(*uint32)(unsafe.Pointer(uintptr(packet.L4) + types.GRELen)) = SwapBytesUint32(key)
+	}
+	return true
+}
+
+// DecapsulateIPv4GRE removes outer IPv4 and GRE headers of packet and
+// sets EtherType to protocol of encapsulated packet. L3 and L4 headers
+// should be parsed and packet should be IPv4 GRE packet without VLAN
+// tag. GRE checksum is verified if it is present. Returns false if
+// packet is fragmented, GRE header has routing field or unsupported
+// version, checksum is wrong or encapsulated packet isn't IPv4 or
+// IPv6.
+func (packet *Packet) DecapsulateIPv4GRE() bool {
+	ipv4 := packet.GetIPv4NoCheck()
+	gre := packet.GetGRENoCheck()
+	flags := SwapBytesUint16(gre.Flags)
+	proto := SwapBytesUint16(gre.NextProto)
+	if flags&(GREFlagRouting|greVersionMask) != 0 ||
+		SwapBytesUint16(ipv4.FragmentOffset)&(types.IPv4MoreFragments|types.IPv4FragmentOffsetMask) != 0 ||
+		(proto != types.IPV4Number && proto != types.IPV6Number) {
+		return false
+	}
+	hdrLen := uint(ipv4.VersionIhl&0x0f) * 4
+	if flags&GREFlagChecksum != 0 {
+		length := int(SwapBytesUint16(ipv4.TotalLength)) - int(hdrLen)
+		if reduceChecksum(calculateDataChecksum(packet.L4, length, 0)) != 0xffff {
+			return false
+		}
+	}
+	if !packet.DecapsulateHead(types.EtherLen, hdrLen+gre.HeaderLen()) {
+		return false
+	}
+	packet.Ether.EtherType = SwapBytesUint16(proto)
+	return true
+}
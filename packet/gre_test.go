@@ -0,0 +1,53 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func TestGREEncapsulation(t *testing.T) {
+	local := types.BytesToIPv4(192, 0, 2, 1)
+	remote := types.BytesToIPv4(198, 51, 100, 1)
+	for _, withKey := range []bool{false, true} {
+		pkt := getIPv4UDPTestPacket()
+		inner := append([]byte(nil), pkt.GetRawPacketBytes()...)
+		if !pkt.EncapsulateIPv4GRE(local, remote, 0x1234, withKey) {
+			t.Fatal("EncapsulateIPv4GRE failed")
+		}
+		pkt.ParseL3()
+		ipv4 := pkt.GetIPv4()
+		if ipv4 == nil || ipv4.NextProtoID != types.GRENumber || ipv4.SrcAddr != local || ipv4.DstAddr != remote {
+			t.Fatalf("Wrong outer header %v", ipv4)
+		}
+		if CalculateIPv4Checksum(ipv4) != SwapBytesUint16(ipv4.HdrChecksum) {
+			t.Error("Wrong outer header checksum")
+		}
+		if int(SwapBytesUint16(ipv4.TotalLength)) != len(pkt.GetRawPacketBytes())-types.EtherLen {
+			t.Errorf("Outer length is %d", SwapBytesUint16(ipv4.TotalLength))
+		}
+		pkt.ParseL4ForIPv4()
+		gre := pkt.GetGREForIPv4()
+		if key, ok := gre.GetKey(); ok != withKey || (withKey && key != 0x1234) {
+			t.Errorf("GetKey() = %x, %v", key, ok)
+		}
+		if SwapBytesUint16(gre.NextProto) != types.IPV4Number {
+			t.Errorf("GRE protocol is %x", SwapBytesUint16(gre.NextProto))
+		}
+		if !pkt.DecapsulateIPv4GRE() {
+			t.Fatal("DecapsulateIPv4GRE failed")
+		}
+		if !bytes.Equal(pkt.GetRawPacketBytes(), inner) {
+			t.Errorf("Decapsulated packet\n%x\nis different from original\n%x", pkt.GetRawPacketBytes(), inner)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"unsafe"
+
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/types"
+)
+
+// tcpSegmentLayout returns length of L2 header, length of IP and TCP
+// headers and total length of IP packet for TCP packet which can be
+// segmented. Returns false if packet isn't TCP over IPv4 or over IPv6
+// without extension headers, is IPv4 fragment or is truncated.
+func tcpSegmentLayout(packet *Packet) (l2, hdrLen, total uint, ok bool) {
+	l2 = uint(uintptr(packet.L3) - uintptr(unsafe.Pointer(packet.Ether)))
+	var l3len uint
+	if l3IsIPv4(packet) {
+		ipv4 := packet.GetIPv4NoCheck()
+		if ipv4.NextProtoID != types.TCPNumber ||
+			SwapBytesUint16(ipv4.FragmentOffset)&(types.IPv4MoreFragments|types.IPv4FragmentOffsetMask) != 0 {
+			return 0, 0, 0, false
+		}
+		l3len = uint(ipv4.VersionIhl&0x0f) << 2
+		total = uint(SwapBytesUint16(ipv4.TotalLength))
+	} else {
+		ipv6 := packet.GetIPv6NoCheck()
+		if ipv6.Proto != types.TCPNumber {
+			return 0, 0, 0, false
+		}
+		l3len = types.IPv6Len
+		total = types.IPv6Len + uint(SwapBytesUint16(ipv6.PayloadLen))
+	}
+	var dataOff [1]byte
+	if l2+total > packet.GetPacketLen() || packet.ReadBytes(l2+l3len+12, dataOff[:]) == nil {
+		return 0, 0, 0, false
+	}
+	hdrLen = l3len + uint(dataOff[0]>>4)*4
+	if l3len < types.IPv4MinLen || hdrLen < l3len+types.TCPMinLen || hdrLen > total {
+		return 0, 0, 0, false
+	}
+	return l2, hdrLen, total, true
+}
+
+// TCPSegmentsNumber returns number of segments which TCP packet should
+// be split to so that every segment is not longer than mtu bytes
+// without L2 header. Returns 1 if packet already fits into mtu and 0
+// if packet can't be segmented because it isn't TCP over IPv4 or over
+// IPv6 without extension headers, it is IPv4 fragment or mtu is too
+// small for its headers. L3 header should be parsed.
+func (packet *Packet) TCPSegmentsNumber(mtu uint) uint {
+	_, hdrLen, total, ok := tcpSegmentLayout(packet)
+	if !ok {
+		return 0
+	}
+	if total <= mtu {
+		return 1
+	}
+	if mtu <= hdrLen {
+		return 0
+	}
+	mss := mtu - hdrLen
+	return (total - hdrLen + mss - 1) / mss
+}
+
+// SegmentTCP splits TCP packet into segments which are not longer
+// than mtu bytes without L2 header like TCP segmentation offload of
+// NIC does. Packet itself becomes the first segment, other segments
+// are written to given empty packets. Number of given packets should
+// be TCPSegmentsNumber(mtu) - 1. Headers are copied to every segment,
+// sequence numbers, lengths and IPv4 identifications are adjusted,
+// FIN and PSH flags are kept only in the last segment and CWR flag
+// only in the first one. Checksums are calculated in software, only
+// pseudo header checksum of TCP is calculated if hardware checksum
+// offloading is enabled. Chained packets are supported. Returns false
+// if packet can't be segmented. L3 header should be parsed.
+func (packet *Packet) SegmentTCP(mtu uint, segments []*Packet) bool {
+	number := packet.TCPSegmentsNumber(mtu)
+	if number == 0 || uint(len(segments)) != number-1 {
+		return false
+	}
+	if number == 1 {
+		return true
+	}
+	l2, hdrLen, total, _ := tcpSegmentLayout(packet)
+	mss := mtu - hdrLen
+	raw := packet.GetRawPacketBytes()
+
+	// Following segments
+	for i, s := range segments {
+		start := l2 + hdrLen + uint(i+1)*mss
+		end := start + mss
+		if end > l2+total {
+			end = l2 + total
+		}
+		frame := make([]byte, 0, l2+hdrLen+end-start)
+		frame = append(frame, raw[:l2+hdrLen]...)
+		frame = append(frame, raw[start:end]...)
+		if !GeneratePacketFromByte(s, frame) {
+			return false
+		}
+		s.L3 = unsafe.Pointer(uintptr(unsafe.Pointer(s.Ether)) + uintptr(l2))
+		setTCPSegmentHeaders(s, hdrLen, uint(i+1), mss, end-start, i == len(segments)-1)
+	}
+
+	// First segment
+	if !packet.truncate(l2+hdrLen+mss, raw) {
+		return false
+	}
+	setTCPSegmentHeaders(packet, hdrLen, 0, mss, mss, false)
+	return true
+}
+
+// truncate makes packet length bytes long. Following segments of
+// chained packet are freed and missing bytes of the first segment are
+// copied from raw bytes of packet.
+func (packet *Packet) truncate(length uint, raw []byte) bool {
+	if packet.Next != nil {
+		rest := low.DetachChainMbuf(packet.CMbuf)
+		low.DirectStop(1, []uintptr{uintptr(unsafe.Pointer(rest))})
+		packet.Next = nil
+	}
+	current := packet.GetPacketLen()
+	if current >= length {
+		return low.TrimMbuf(packet.CMbuf, current-length)
+	}
+	if !low.AppendMbuf(packet.CMbuf, length-current) {
+		return false
+	}
+	copy(packet.GetRawPacketBytes()[current:], raw[current:length])
+	return true
+}
+
+// setTCPSegmentHeaders adjusts IP and TCP headers of index segment
+// with length bytes of data.
+func setTCPSegmentHeaders(packet *Packet, hdrLen, index, mss, length uint, last bool) {
+	var l3len uint
+	var pseudo uint32
+	tcpLen := hdrLen + length
+	if l3IsIPv4(packet) {
+		ipv4 := packet.GetIPv4NoCheck()
+		l3len = uint(ipv4.VersionIhl&0x0f) << 2
+		tcpLen -= l3len
+		ipv4.TotalLength = SwapBytesUint16(uint16(hdrLen + length))
+		ipv4.PacketID = SwapBytesUint16(SwapBytesUint16(ipv4.PacketID) + uint16(index))
+		setIPv4HeaderChecksum(ipv4)
+		pseudo = calculateIPv4AddrChecksum(ipv4) + types.TCPNumber + uint32(tcpLen)
+	} else {
+		ipv6 := packet.GetIPv6NoCheck()
+		l3len = types.IPv6Len
+		tcpLen -= l3len
+		ipv6.PayloadLen = SwapBytesUint16(uint16(tcpLen))
+		pseudo = calculateIPv6AddrChecksum(ipv6) + types.TCPNumber + uint32(tcpLen)
+	}
+	tcp := (*TCPHdr)(unsafe.Pointer(uintptr(packet.L3) + uintptr(l3len)))
+	packet.L4 = unsafe.Pointer(tcp)
+	tcp.SentSeq = SwapBytesUint32(SwapBytesUint32(tcp.SentSeq) + uint32(index*mss))
+	if !last {
+		tcp.TCPFlags &^= types.TCPFlagFin | types.TCPFlagPsh
+	}
+	if index != 0 {
+		tcp.TCPFlags &^= types.TCPFlagCwr
+	}
+	tcp.Cksum = 0
+	if hwtxchecksum {
+		tcp.Cksum = SwapBytesUint16(reduceChecksum(pseudo))
+	} else {
+		tcp.Cksum = SwapBytesUint16(^reduceChecksum(pseudo + calculateDataChecksum(unsafe.Pointer(tcp), int(tcpLen), 0)))
+	}
+}
+
+// SetTXTCPSegOLFlags prepares TCP packet for TCP segmentation offload
+// of NIC: mbuf flags and lengths of headers are set, IPv4 header
+// checksum is zeroed and TCP checksum is set to pseudo header checksum
+// without length as NIC requires. NIC splits packet into segments
+// with mss bytes of data. Port should have TCP segmentation offload
+// enabled. Returns false if packet isn't TCP over IPv4 or over IPv6
+// without extension headers. L3 header should be parsed.
+func (packet *Packet) SetTXTCPSegOLFlags(mss uint) bool {
+	l2, hdrLen, _, ok := tcpSegmentLayout(packet)
+	if !ok {
+		return false
+	}
+	var l3len uint
+	var pseudo uint32
+	ipv4 := l3IsIPv4(packet)
+	if ipv4 {
+		hdr := packet.GetIPv4NoCheck()
+		l3len = uint(hdr.VersionIhl&0x0f) << 2
+		hdr.HdrChecksum = 0
+		pseudo = calculateIPv4AddrChecksum(hdr) + types.TCPNumber
+	} else {
+		l3len = types.IPv6Len
+		pseudo = calculateIPv6AddrChecksum(packet.GetIPv6NoCheck()) + types.TCPNumber
+	}
+	tcp := (*TCPHdr)(unsafe.Pointer(uintptr(packet.L3) + uintptr(l3len)))
+	tcp.Cksum = SwapBytesUint16(reduceChecksum(pseudo))
+	low.SetTXTCPSegOLFlags(packet.CMbuf, ipv4, uint32(l2), uint32(l3len), uint32(hdrLen-l3len), uint32(mss))
+	return true
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func getGSOTestPacket(payload uint) (*Packet, []byte) {
+	pkt := getPacket()
+	InitEmptyIPv4TCPPacket(pkt, payload)
+	initIPv4Addrs(pkt)
+	ipv4 := pkt.GetIPv4NoCheck()
+	ipv4.PacketID = SwapBytesUint16(0x1234)
+	tcp := pkt.GetTCPNoCheck()
+	tcp.SentSeq = SwapBytesUint32(0xfffffff0)
+	tcp.TCPFlags = types.TCPFlagAck | types.TCPFlagPsh | types.TCPFlagFin | types.TCPFlagCwr
+	data := (*[1 << 16]byte)(pkt.Data)[:payload]
+	for i := range data {
+		data[i] = byte(i)
+	}
+	ipv4.HdrChecksum = SwapBytesUint16(CalculateIPv4Checksum(ipv4))
+	tcp.Cksum = SwapBytesUint16(CalculateIPv4TCPChecksum(ipv4, tcp, pkt.Data))
+	return pkt, append([]byte(nil), data...)
+}
+
+func TestTCPSegmentsNumber(t *testing.T) {
+	pkt, _ := getGSOTestPacket(1800)
+	pkt.ParseL3()
+	// 1840 bytes of IPv4 packet, 40 bytes of headers
+	tests := []struct {
+		mtu    uint
+		number uint
+	}{
+		{1840, 1},
+		{1839, 2},
+		{940, 2},
+		{576, 4},
+		{40, 0},
+	}
+	for _, test := range tests {
+		if n := pkt.TCPSegmentsNumber(test.mtu); n != test.number {
+			t.Errorf("MTU %d: got %d segments, expected %d", test.mtu, n, test.number)
+		}
+	}
+	udp := getIPv4UDPTestPacket()
+	udp.ParseL3()
+	if n := udp.TCPSegmentsNumber(576); n != 0 {
+		t.Errorf("got %d segments for UDP packet", n)
+	}
+}
+
+func TestSegmentTCP(t *testing.T) {
+	const mtu = 576
+	pkt, data := getGSOTestPacket(1800)
+	pkt.ParseL3()
+	segments := make([]*Packet, pkt.TCPSegmentsNumber(mtu)-1)
+	for i := range segments {
+		segments[i] = getPacket()
+	}
+	if !pkt.SegmentTCP(mtu, segments) {
+		t.Fatal("SegmentTCP failed")
+	}
+	var payload []byte
+	for i, s := range append([]*Packet{pkt}, segments...) {
+		s.ParseL3()
+		ipv4 := s.GetIPv4()
+		if ipv4 == nil {
+			t.Fatalf("Segment %d isn't IPv4", i)
+		}
+		length := uint(SwapBytesUint16(ipv4.TotalLength))
+		if length > mtu || types.EtherLen+length != s.GetPacketLen() {
+			t.Errorf("Segment %d has wrong length %d", i, length)
+		}
+		if id := SwapBytesUint16(ipv4.PacketID); id != 0x1234+uint16(i) {
+			t.Errorf("Segment %d has identification 0x%x", i, id)
+		}
+		if CalculateIPv4Checksum(ipv4) != SwapBytesUint16(ipv4.HdrChecksum) {
+			t.Errorf("Segment %d has wrong IPv4 checksum", i)
+		}
+		s.ParseL4ForIPv4()
+		tcp := s.GetTCPNoCheck()
+		s.ParseL7(types.TCPNumber)
+		if CalculateIPv4TCPChecksum(ipv4, tcp, s.Data) != SwapBytesUint16(tcp.Cksum) {
+			t.Errorf("Segment %d has wrong TCP checksum", i)
+		}
+		if seq := SwapBytesUint32(tcp.SentSeq); seq != 0xfffffff0+uint32(len(payload)) {
+			t.Errorf("Segment %d has sequence number 0x%x", i, seq)
+		}
+		last := i == len(segments)
+		if (tcp.TCPFlags&types.TCPFlagFin != 0) != last || (tcp.TCPFlags&types.TCPFlagPsh != 0) != last {
+			t.Errorf("Segment %d has wrong FIN or PSH flags 0x%x", i, tcp.TCPFlags)
+		}
+		if (tcp.TCPFlags&types.TCPFlagCwr != 0) != (i == 0) || tcp.TCPFlags&types.TCPFlagAck == 0 {
+			t.Errorf("Segment %d has wrong CWR or ACK flags 0x%x", i, tcp.TCPFlags)
+		}
+		offset := uintptr(s.Data) - uintptr(unsafe.Pointer(s.Ether))
+		payload = append(payload, s.GetRawPacketBytes()[offset:]...)
+	}
+	if !bytes.Equal(payload, data) {
+		t.Error("Segments have wrong payload")
+	}
+}
+
+func TestSetTXTCPSegOLFlags(t *testing.T) {
+	pkt, _ := getGSOTestPacket(1800)
+	pkt.ParseL3()
+	if !pkt.SetTXTCPSegOLFlags(1460) {
+		t.Fatal("SetTXTCPSegOLFlags failed")
+	}
+	ipv4 := pkt.GetIPv4NoCheck()
+	if ipv4.HdrChecksum != 0 {
+		t.Error("IPv4 checksum isn't zeroed")
+	}
+	pseudo := reduceChecksum(calculateIPv4AddrChecksum(ipv4) + types.TCPNumber)
+	if cksum := SwapBytesUint16(pkt.GetTCPNoCheck().Cksum); cksum != pseudo {
+		t.Errorf("TCP checksum is 0x%x instead of pseudo header checksum 0x%x", cksum, pseudo)
+	}
+	udp := getIPv4UDPTestPacket()
+	udp.ParseL3()
+	if udp.SetTXTCPSegOLFlags(1460) {
+		t.Error("SetTXTCPSegOLFlags accepted UDP packet")
+	}
+}
@@ -27,14 +27,38 @@ type GTPHdr struct {
 	NextExtensionHeader uint8  // this is valid only with exatension header flag
 }
 
-// TODO current support of GTP-U: only two extension headers
 // NextExtensionHeader values
 const (
-	NoExtensionHeaders             = 0x00
-	UDPPortExtensionHeader         = 0x40
-	PDCP_PDU_NumberExtensionHeader = 0xc0
+	NoExtensionHeaders                 = 0x00
+	UDPPortExtensionHeader             = 0x40
+	PDUSessionContainerExtensionHeader = 0x85
+	PDCP_PDU_NumberExtensionHeader     = 0xc0
 )
 
+// HeaderType flags
+const (
+	GTPVersionMask = 0xe0
+	GTPVersion1    = 0x20
+	GTPFlagPT      = 0x10 // GTP, not GTP'
+	GTPFlagE       = 0x04 // Extension header flag
+	GTPFlagS       = 0x02 // Sequence number flag
+	GTPFlagPN      = 0x01 // N-PDU number flag
+
+	// Length of sequence number, N-PDU number and next extension
+	// header fields which are present if any of E, S and PN flags is set
+	gtpOptionalLen = 4
+	// Maximum length of extension header content
+	gtpMaxExtensionLen = 0xff*4 - 2
+)
+
+// GTPExtensionHeader is an extension header of GTP-U header which is
+// added by EncapsulateIPv4GTPU. Length of content plus 2 should be a
+// multiple of 4.
+type GTPExtensionHeader struct {
+	Type    uint8
+	Content []byte
+}
+
 type UDPPort struct {
 	Length              uint8 // in 4 octets, here always 0x01
 	UDPPortNumber       uint16
@@ -70,7 +94,7 @@ const (
 func (hdr *GTPHdr) String() string {
 	hType := "GTPv1"
 	hTypeType := "GTP"
-	if hdr.HeaderType&0xe0 != 1 {
+	if hdr.HeaderType&GTPVersionMask != GTPVersion1 {
 		hType = "not GTPv1" // other versions are not supported
 	}
 	if hdr.HeaderType&0x10 == 0 {
@@ -85,13 +109,72 @@ func (hdr *GTPHdr) String() string {
 		min += fmt.Sprintf(`, N-PDU number %d`, hdr.NPDUNumber)
 	}
 	if hdr.HeaderType&0x04 != 0 {
-		min += fmt.Sprintf(`, N-PDU number %d`, hdr.NextExtensionHeader)
+		min += fmt.Sprintf(`, Next extension header 0x%02x`, hdr.NextExtensionHeader)
 		// TODO add dumping of all extension headers
 	}
 	min += "\n"
 	return min
 }
 
+// GetTEID returns tunnel endpoint identifier.
+func (hdr *GTPHdr) GetTEID() uint32 {
+	return SwapBytesUint32(hdr.TEID)
+}
+
+// SetTEID sets tunnel endpoint identifier.
+func (hdr *GTPHdr) SetTEID(teid uint32) {
+	hdr.TEID = SwapBytesUint32(teid)
+}
+
+// HeaderLen returns length of GTP header with optional fields and
+// extension headers. Returns 0 if extension headers don't fit in
+// message length.
+func (hdr *GTPHdr) HeaderLen() uint {
+	return walkGTPExtensionHeaders(hdr, nil)
+}
+
+// GTPExtensionHeaders calls fn for every extension header of GTP header
+// until fn returns false. Content of extension header is a slice of
+// packet without length and next extension header type fields. Returns
+// false if extension headers don't fit in message length.
+func GTPExtensionHeaders(hdr *GTPHdr, fn func(typ uint8, content []byte) bool) bool {
+	return walkGTPExtensionHeaders(hdr, fn) != 0
+}
+
+func walkGTPExtensionHeaders(hdr *GTPHdr, fn func(typ uint8, content []byte) bool) uint {
+	if hdr.HeaderType&(GTPFlagE|GTPFlagS|GTPFlagPN) == 0 {
+		return GTPMinLen
+	}
+	limit := GTPMinLen + uint(SwapBytesUint16(hdr.MessageLength))
+	offset := uint(GTPMinLen + gtpOptionalLen)
+	if offset > limit {
+		return 0
+	}
+	if hdr.HeaderType&GTPFlagE == 0 {
+		return offset
+	}
+	for next := hdr.NextExtensionHeader; next != NoExtensionHeaders; {
+		if offset+4 > limit {
+			return 0
+		}
+		length := uint(*(*uint8)(unsafe.Pointer(uintptr(unsafe.Pointer(hdr)) + uintptr(offset)))) * 4
+		if length == 0 || offset+length > limit {
+			return 0
+		}
+		typ := next
+		next = *(*uint8)(unsafe.Pointer(uintptr(unsafe.Pointer(hdr)) + uintptr(offset+length-1)))
+		if fn != nil {
+			content := (*[gtpMaxExtensionLen]byte)(unsafe.Pointer(uintptr(unsafe.Pointer(hdr)) + uintptr(offset+1)))[: length-2 : length-2]
+			if !fn(typ, content) {
+				// Length of header is still needed
+				fn = nil
+			}
+		}
+		offset += length
+	}
+	return offset
+}
+
 // GetGTP assumes that packet is already parsed. Returns GTP header as payload after L4 header
 func (packet *Packet) GetGTP() *GTPHdr {
 	return (*GTPHdr)(packet.Data)
@@ -141,3 +224,114 @@ func (packet *Packet) DecapsulateIPv4GTP() bool {
 	// Developer can use standart parsing functions after this function
 	// to check inner protocol stack after decapsulation
 }
+
+// EncapsulateIPv4GTPU adds outer IPv4, UDP and GTPv1-U G-PDU headers
+// with given TEID and extension headers before IPv4 or IPv6 packet
+// after Ether header. Outer EtherType is set to IPv4, UDP checksum is
+// not calculated. Returns false if extension headers are invalid or
+// packet can't be extended.
+func (packet *Packet) EncapsulateIPv4GTPU(src, dst IPv4Address, teid uint32, extensions []GTPExtensionHeader) bool {
+	gtpLen := uint(GTPMinLen)
+	if len(extensions) != 0 {
+		gtpLen += gtpOptionalLen
+		for i := range extensions {
+			contentLen := uint(len(extensions[i].Content))
+			if (contentLen+2)%4 != 0 || contentLen > gtpMaxExtensionLen {
+				return false
+			}
+			gtpLen += contentLen + 2
+		}
+	}
+	length := packet.GetPacketLen() - EtherLen
+	tunnelLen := IPv4MinLen + UDPLen + gtpLen
+	if !packet.EncapsulateHead(EtherLen, tunnelLen) {
+		return false
+	}
+	packet.Ether.EtherType = SwapBytesUint16(IPV4Number)
+	packet.ParseL3()
+	ipv4 := packet.GetIPv4NoCheck()
+	*ipv4 = IPv4Hdr{
+		VersionIhl:  IPv4VersionIhl,
+		TotalLength: SwapBytesUint16(uint16(length + tunnelLen)),
+		TimeToLive:  64,
+		NextProtoID: UDPNumber,
+		SrcAddr:     src,
+		DstAddr:     dst,
+	}
+	ipv4.HdrChecksum = SwapBytesUint16(CalculateIPv4Checksum(ipv4))
+	packet.ParseL4ForIPv4()
+	udp := packet.GetUDPNoCheck()
+	*udp = UDPHdr{
+		SrcPort:  SwapUDPPortGTPU,
+		DstPort:  SwapUDPPortGTPU,
+		DgramLen: SwapBytesUint16(uint16(length + tunnelLen - IPv4MinLen)),
+	}
+	packet.ParseL7(UDPNumber)
+	gtp := packet.GetGTP()
+	gtp.HeaderType = GTPVersion1 | GTPFlagPT
+	gtp.MessageType = G_PDU
+	gtp.MessageLength = SwapBytesUint16(uint16(length + gtpLen - GTPMinLen))
+	gtp.SetTEID(teid)
+	if len(extensions) == 0 {
+		return true
+	}
+	gtp.HeaderType |= GTPFlagE
+	gtp.SequenceNumber = 0
+	gtp.NPDUNumber = 0
+	gtp.NextExtensionHeader = extensions[0].Type
+	offset := uintptr(GTPMinLen + gtpOptionalLen)
+	for i := range extensions {
+		contentLen := len(extensions[i].Content)
+		*(*uint8)(unsafe.Pointer(uintptr(packet.Data) + offset)) = uint8((contentLen + 2) / 4)
+		copy((*[gtpMaxExtensionLen]byte)(unsafe.Pointer(uintptr(packet.Data) + offset + 1))[:contentLen], extensions[i].Content)
+		next := uint8(NoExtensionHeaders)
+		if i+1 < len(extensions) {
+			next = extensions[i+1].Type
+		}
+		*(*uint8)(unsafe.Pointer(uintptr(packet.Data) + offset + uintptr(contentLen) + 1)) = next
+		offset += uintptr(contentLen + 2)
+	}
+	return true
+}
+
+// DecapsulateGTPU removes outer IPv4 or IPv6, UDP and GTPv1-U headers
+// of G-PDU packet including optional fields and extension headers. L3,
+// L4 and L7 headers should be parsed and packet shouldn't have VLAN
+// tag. EtherType is set according to version of encapsulated packet.
+// Returns false if outer packet is fragmented, GTP header isn't GTPv1-U
+// G-PDU header or is malformed, or encapsulated packet isn't IPv4 or
+// IPv6 packet.
+func (packet *Packet) DecapsulateGTPU() bool {
+	if SwapBytesUint16(packet.Ether.EtherType) == IPV4Number {
+		ipv4 := packet.GetIPv4NoCheck()
+		if SwapBytesUint16(ipv4.FragmentOffset)&(IPv4MoreFragments|IPv4FragmentOffsetMask) != 0 {
+			return false
+		}
+	}
+	gtp := packet.GetGTP()
+	if gtp.HeaderType&(GTPVersionMask|GTPFlagPT) != GTPVersion1|GTPFlagPT || gtp.MessageType != G_PDU {
+		return false
+	}
+	dataOffset := uint(uintptr(packet.Data) - uintptr(unsafe.Pointer(packet.Ether)))
+	if dataOffset+GTPMinLen+uint(SwapBytesUint16(gtp.MessageLength)) > packet.GetPacketLen() {
+		return false
+	}
+	hdrLen := gtp.HeaderLen()
+	if hdrLen == 0 || dataOffset+hdrLen >= packet.GetPacketLen() {
+		return false
+	}
+	var etherType uint16
+	switch *(*uint8)(unsafe.Pointer(uintptr(packet.Data) + uintptr(hdrLen))) >> 4 {
+	case 4:
+		etherType = IPV4Number
+	case 6:
+		etherType = IPV6Number
+	default:
+		return false
+	}
+	if !packet.DecapsulateHead(EtherLen, dataOffset-EtherLen+hdrLen) {
+		return false
+	}
+	packet.Ether.EtherType = SwapBytesUint16(etherType)
+	return true
+}
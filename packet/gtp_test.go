@@ -0,0 +1,90 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func TestGTPUEncapsulation(t *testing.T) {
+	local := types.BytesToIPv4(192, 0, 2, 1)
+	remote := types.BytesToIPv4(198, 51, 100, 1)
+	extensions := []GTPExtensionHeader{
+		{Type: PDUSessionContainerExtensionHeader, Content: []byte{0x10, 0x09}},
+		{Type: UDPPortExtensionHeader, Content: []byte{0x08, 0x68, 0, 0, 0, 0}},
+	}
+	for _, pkt := range []*Packet{getIPv4UDPTestPacket(), getIPv6TCPTestPacket()} {
+		inner := append([]byte(nil), pkt.GetRawPacketBytes()...)
+		if !pkt.EncapsulateIPv4GTPU(local, remote, 0x01020304, extensions) {
+			t.Fatal("EncapsulateIPv4GTPU failed")
+		}
+		pkt.ParseL3()
+		ipv4 := pkt.GetIPv4()
+		if ipv4 == nil || ipv4.SrcAddr != local || ipv4.DstAddr != remote ||
+			CalculateIPv4Checksum(ipv4) != SwapBytesUint16(ipv4.HdrChecksum) {
+			t.Fatalf("Wrong outer header %v", ipv4)
+		}
+		pkt.ParseL4ForIPv4()
+		pkt.ParseL7(types.UDPNumber)
+		gtp := pkt.GetGTP()
+		if gtp.GetTEID() != 0x01020304 || gtp.MessageType != G_PDU || gtp.HeaderLen() != types.GTPMinLen+4+4+8 {
+			t.Fatalf("Wrong GTP header %v", gtp)
+		}
+		var parsed []GTPExtensionHeader
+		if !GTPExtensionHeaders(gtp, func(typ uint8, content []byte) bool {
+			parsed = append(parsed, GTPExtensionHeader{Type: typ, Content: append([]byte(nil), content...)})
+			return true
+		}) {
+			t.Fatal("GTPExtensionHeaders failed")
+		}
+		if len(parsed) != len(extensions) {
+			t.Fatalf("Parsed %d extension headers instead of %d", len(parsed), len(extensions))
+		}
+		for i := range extensions {
+			if parsed[i].Type != extensions[i].Type || !bytes.Equal(parsed[i].Content, extensions[i].Content) {
+				t.Errorf("Extension header %d is %v instead of %v", i, parsed[i], extensions[i])
+			}
+		}
+		if !pkt.DecapsulateGTPU() {
+			t.Fatal("DecapsulateGTPU failed")
+		}
+		if !bytes.Equal(pkt.GetRawPacketBytes(), inner) {
+			t.Errorf("Decapsulated packet\n%x\nis different from original\n%x", pkt.GetRawPacketBytes(), inner)
+		}
+	}
+}
+
+func TestGTPURejected(t *testing.T) {
+	remote := types.BytesToIPv4(198, 51, 100, 1)
+	pkt := getIPv4UDPTestPacket()
+	if pkt.EncapsulateIPv4GTPU(0, remote, 1, []GTPExtensionHeader{{Type: 1, Content: []byte{1}}}) {
+		t.Error("Extension header with wrong length was accepted")
+	}
+	if !pkt.EncapsulateIPv4GTPU(0, remote, 1, []GTPExtensionHeader{{Type: 1, Content: []byte{1, 2}}}) {
+		t.Fatal("EncapsulateIPv4GTPU failed")
+	}
+	pkt.ParseL3()
+	pkt.ParseL4ForIPv4()
+	pkt.ParseL7(types.UDPNumber)
+	gtp := pkt.GetGTP()
+	gtp.MessageType = EchoRequest
+	if pkt.DecapsulateGTPU() {
+		t.Error("Echo request was decapsulated")
+	}
+	gtp.MessageType = G_PDU
+	// Extension header is longer than message
+	*(*uint8)(unsafe.Pointer(uintptr(pkt.Data) + types.GTPMinLen + 4)) = 0xff
+	if gtp.HeaderLen() != 0 || pkt.DecapsulateGTPU() {
+		t.Error("Packet with malformed extension header was decapsulated")
+	}
+}
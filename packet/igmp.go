@@ -0,0 +1,500 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// IGMP message types
+const (
+	IGMPTypeMembershipQuery = 0x11
+	IGMPTypeV1Report        = 0x12
+	IGMPTypeV2Report        = 0x16
+	IGMPTypeV2Leave         = 0x17
+	IGMPTypeV3Report        = 0x22
+)
+
+// Types of group records of IGMPv3 and MLDv2 reports
+const (
+	MulticastModeIsInclude      = 1
+	MulticastModeIsExclude      = 2
+	MulticastChangeToInclude    = 3
+	MulticastChangeToExclude    = 4
+	MulticastAllowNewSources    = 5
+	MulticastBlockOldSources    = 6
+	multicastMaxRecordType      = MulticastBlockOldSources
+	multicastSuppressRouterFlag = 0x08
+)
+
+// Lengths of messages without sources and records
+const (
+	igmpLen         = 8
+	igmpV3QueryLen  = 12
+	igmpRecordLen   = 8
+	mldLen          = 24
+	mldV2QueryLen   = 28
+	mldV2ReportLen  = 8
+	mldRecordLen    = 20
+	multicastAuxLen = 4
+)
+
+var (
+	igmpAllSystems       = types.BytesToIPv4(224, 0, 0, 1)
+	igmpAllRouters       = types.BytesToIPv4(224, 0, 0, 2)
+	igmpV3Routers        = types.BytesToIPv4(224, 0, 0, 22)
+	mldAllNodes          = types.IPv6Address{0xff, 0x02, 15: 0x01}
+	mldAllRouters        = types.IPv6Address{0xff, 0x02, 15: 0x02}
+	mldV2Routers         = types.IPv6Address{0xff, 0x02, 15: 0x16}
+	multicastRouterAlert = []byte{0x94, 0x04, 0x00, 0x00}
+)
+
+// IGMPRecord is a group record of IGMPv3 report.
+type IGMPRecord struct {
+	Type    uint8
+	Group   types.IPv4Address
+	Sources []types.IPv4Address
+}
+
+// IGMPMessage is a parsed IGMP message. Version of query is detected
+// by its length and maximum response time, version of report is
+// defined by its type.
+type IGMPMessage struct {
+	Type    uint8
+	Version uint8
+	// Maximum response time of query
+	MaxResponse time.Duration
+	// Group of query, IGMPv1 or IGMPv2 report or leave message, it is
+	// zero for general query
+	Group types.IPv4Address
+	// Fields of IGMPv3 query
+	SuppressRouter bool
+	QRV            uint8
+	QQIC           uint8
+	Sources        []types.IPv4Address
+	// Group records of IGMPv3 report
+	Records []IGMPRecord
+}
+
+// MLDRecord is a multicast address record of MLDv2 report.
+type MLDRecord struct {
+	Type    uint8
+	Group   types.IPv6Address
+	Sources []types.IPv6Address
+}
+
+// MLDMessage is a parsed MLD message. Version of query is detected by
+// its length, version of report is defined by its type.
+type MLDMessage struct {
+	Type    uint8
+	Version uint8
+	// Maximum response delay of query
+	MaxResponse time.Duration
+	// Multicast address of query, MLDv1 report or done message, it is
+	// zero for general query
+	Group types.IPv6Address
+	// Fields of MLDv2 query
+	SuppressRouter bool
+	QRV            uint8
+	QQIC           uint8
+	Sources        []types.IPv6Address
+	// Multicast address records of MLDv2 report
+	Records []MLDRecord
+}
+
+// decodeFloatCode decodes maximum response code or QQIC of IGMPv3 and
+// MLDv2 which have exponent of 3 bits and mantissa of given bits if
+// high bit of code is set.
+func decodeFloatCode(code uint32, mantBits uint) uint32 {
+	if code < 1<<(mantBits+3) {
+		return code
+	}
+	exp := (code >> mantBits) & 7
+	mant := code & (1<<mantBits - 1)
+	return (mant | 1<<mantBits) << (exp + 3)
+}
+
+// encodeFloatCode is a reverse of decodeFloatCode, too big values are
+// encoded as maximum code.
+func encodeFloatCode(value uint32, mantBits uint) uint32 {
+	if value < 1<<(mantBits+3) {
+		return value
+	}
+	for exp := uint32(0); exp < 8; exp++ {
+		mant := value >> (exp + 3)
+		if mant < 2<<mantBits {
+			return 1<<(mantBits+3) | exp<<mantBits | mant&(1<<mantBits-1)
+		}
+	}
+	return 1<<(mantBits+4) - 1
+}
+
+// multicastChecksum returns checksum of data with initial sum.
+func multicastChecksum(data []byte, sum uint32) uint16 {
+	if len(data) != 0 {
+		sum += calculateDataChecksum(unsafe.Pointer(&data[0]), len(data), 0)
+	}
+	return ^reduceChecksum(sum)
+}
+
+// parseMulticastRecords calls fn for every group record of IGMPv3 or
+// MLDv2 report with addresses of given length. Returns false if
+// records exceed data.
+func parseMulticastRecords(data []byte, number int, addrLen int, fn func(typ uint8, group, sources []byte)) bool {
+	for i := 0; i < number; i++ {
+		if len(data) < 4+addrLen {
+			return false
+		}
+		sources := int(data[2])<<8 | int(data[3])
+		length := 4 + addrLen*(1+sources) + int(data[1])*multicastAuxLen
+		if len(data) < length {
+			return false
+		}
+		fn(data[0], data[4:4+addrLen], data[4+addrLen:4+addrLen*(1+sources)])
+		data = data[length:]
+	}
+	return true
+}
+
+// ParseIGMP fills msg from IGMP message. Returns false if message is
+// malformed or its checksum is wrong.
+func ParseIGMP(data []byte, msg *IGMPMessage) bool {
+	*msg = IGMPMessage{}
+	if len(data) < igmpLen || multicastChecksum(data, 0) != 0 {
+		return false
+	}
+	msg.Type = data[0]
+	msg.Group = types.SliceToIPv4(data[4:])
+	switch msg.Type {
+	case IGMPTypeMembershipQuery:
+		switch {
+		case len(data) >= igmpV3QueryLen:
+			msg.Version = 3
+			msg.MaxResponse = time.Duration(decodeFloatCode(uint32(data[1]), 4)) * time.Second / 10
+			msg.SuppressRouter = data[8]&multicastSuppressRouterFlag != 0
+			msg.QRV = data[8] & 7
+			msg.QQIC = data[9]
+			sources := int(data[10])<<8 | int(data[11])
+			if len(data) < igmpV3QueryLen+sources*types.IPv4AddrLen {
+				return false
+			}
+			for i := 0; i < sources; i++ {
+				msg.Sources = append(msg.Sources, types.SliceToIPv4(data[igmpV3QueryLen+i*types.IPv4AddrLen:]))
+			}
+		case len(data) != igmpLen:
+			return false
+		case data[1] == 0:
+			msg.Version = 1
+		default:
+			msg.Version = 2
+			msg.MaxResponse = time.Duration(data[1]) * time.Second / 10
+		}
+	case IGMPTypeV1Report:
+		msg.Version = 1
+	case IGMPTypeV2Report, IGMPTypeV2Leave:
+		msg.Version = 2
+	case IGMPTypeV3Report:
+		msg.Version = 3
+		msg.Group = 0
+		return parseMulticastRecords(data[igmpLen:], int(data[6])<<8|int(data[7]), types.IPv4AddrLen, func(typ uint8, group, sources []byte) {
+			r := IGMPRecord{Type: typ, Group: types.SliceToIPv4(group)}
+			for i := 0; i < len(sources); i += types.IPv4AddrLen {
+				r.Sources = append(r.Sources, types.SliceToIPv4(sources[i:]))
+			}
+			msg.Records = append(msg.Records, r)
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// AppendTo appends IGMP message with checksum to b. Query is IGMPv3
+// query if Version is 3, IGMPv3 report has records instead of group.
+func (msg *IGMPMessage) AppendTo(b []byte) []byte {
+	start := len(b)
+	code := uint8(0)
+	if msg.Type == IGMPTypeMembershipQuery {
+		tenths := uint32(msg.MaxResponse / (time.Second / 10))
+		if msg.Version == 3 {
+			code = uint8(encodeFloatCode(tenths, 4))
+		} else if tenths > 0xff {
+			code = 0xff
+		} else {
+			code = uint8(tenths)
+		}
+	}
+	b = append(b, msg.Type, code, 0, 0)
+	switch {
+	case msg.Type == IGMPTypeV3Report:
+		b = append(b, 0, 0, uint8(len(msg.Records)>>8), uint8(len(msg.Records)))
+		for i := range msg.Records {
+			r := &msg.Records[i]
+			group := types.IPv4ToBytes(r.Group)
+			b = append(b, r.Type, 0, uint8(len(r.Sources)>>8), uint8(len(r.Sources)))
+			b = append(b, group[:]...)
+			for _, s := range r.Sources {
+				a := types.IPv4ToBytes(s)
+				b = append(b, a[:]...)
+			}
+		}
+	default:
+		group := types.IPv4ToBytes(msg.Group)
+		b = append(b, group[:]...)
+		if msg.Type == IGMPTypeMembershipQuery && msg.Version == 3 {
+			flags := msg.QRV & 7
+			if msg.SuppressRouter {
+				flags |= multicastSuppressRouterFlag
+			}
+			b = append(b, flags, msg.QQIC, uint8(len(msg.Sources)>>8), uint8(len(msg.Sources)))
+			for _, s := range msg.Sources {
+				a := types.IPv4ToBytes(s)
+				b = append(b, a[:]...)
+			}
+		}
+	}
+	cksum := multicastChecksum(b[start:], 0)
+	b[start+2], b[start+3] = uint8(cksum>>8), uint8(cksum)
+	return b
+}
+
+// ParseMLD fills msg from MLD message which starts with ICMPv6 header.
+// Checksum of message is not verified. Returns false if message is
+// malformed.
+func ParseMLD(data []byte, msg *MLDMessage) bool {
+	*msg = MLDMessage{}
+	if len(data) < mldV2ReportLen {
+		return false
+	}
+	msg.Type = data[0]
+	switch msg.Type {
+	case types.ICMPv6TypeMLDQuery, types.ICMPv6TypeMLDReport, types.ICMPv6TypeMLDDone:
+		if len(data) < mldLen {
+			return false
+		}
+		msg.Version = 1
+		copy(msg.Group[:], data[8:mldLen])
+		if msg.Type != types.ICMPv6TypeMLDQuery {
+			return true
+		}
+		code := uint32(data[4])<<8 | uint32(data[5])
+		if len(data) < mldV2QueryLen {
+			msg.MaxResponse = time.Duration(code) * time.Millisecond
+			return true
+		}
+		msg.Version = 2
+		msg.MaxResponse = time.Duration(decodeFloatCode(code, 12)) * time.Millisecond
+		msg.SuppressRouter = data[24]&multicastSuppressRouterFlag != 0
+		msg.QRV = data[24] & 7
+		msg.QQIC = data[25]
+		sources := int(data[26])<<8 | int(data[27])
+		if len(data) < mldV2QueryLen+sources*types.IPv6AddrLen {
+			return false
+		}
+		for i := 0; i < sources; i++ {
+			var a types.IPv6Address
+			copy(a[:], data[mldV2QueryLen+i*types.IPv6AddrLen:])
+			msg.Sources = append(msg.Sources, a)
+		}
+		return true
+	case types.ICMPv6TypeMLDv2Report:
+		msg.Version = 2
+		return parseMulticastRecords(data[mldV2ReportLen:], int(data[6])<<8|int(data[7]), types.IPv6AddrLen, func(typ uint8, group, sources []byte) {
+			r := MLDRecord{Type: typ}
+			copy(r.Group[:], group)
+			for i := 0; i < len(sources); i += types.IPv6AddrLen {
+				var a types.IPv6Address
+				copy(a[:], sources[i:])
+				r.Sources = append(r.Sources, a)
+			}
+			msg.Records = append(msg.Records, r)
+		})
+	}
+	return false
+}
+
+// AppendTo appends MLD message to b, ICMPv6 checksum is zero. Query is
+// MLDv2 query if Version is 2, MLDv2 report has records instead of
+// multicast address.
+func (msg *MLDMessage) AppendTo(b []byte) []byte {
+	if msg.Type == types.ICMPv6TypeMLDv2Report {
+		b = append(b, msg.Type, 0, 0, 0, 0, 0, uint8(len(msg.Records)>>8), uint8(len(msg.Records)))
+		for i := range msg.Records {
+			r := &msg.Records[i]
+			b = append(b, r.Type, 0, uint8(len(r.Sources)>>8), uint8(len(r.Sources)))
+			b = append(b, r.Group[:]...)
+			for j := range r.Sources {
+				b = append(b, r.Sources[j][:]...)
+			}
+		}
+		return b
+	}
+	code := uint32(0)
+	if msg.Type == types.ICMPv6TypeMLDQuery {
+		code = uint32(msg.MaxResponse / time.Millisecond)
+		if msg.Version == 2 {
+			code = encodeFloatCode(code, 12)
+		} else if code > 0xffff {
+			code = 0xffff
+		}
+	}
+	b = append(b, msg.Type, 0, 0, 0, uint8(code>>8), uint8(code), 0, 0)
+	b = append(b, msg.Group[:]...)
+	if msg.Type == types.ICMPv6TypeMLDQuery && msg.Version == 2 {
+		flags := msg.QRV & 7
+		if msg.SuppressRouter {
+			flags |= multicastSuppressRouterFlag
+		}
+		b = append(b, flags, msg.QQIC, uint8(len(msg.Sources)>>8), uint8(len(msg.Sources)))
+		for i := range msg.Sources {
+			b = append(b, msg.Sources[i][:]...)
+		}
+	}
+	return b
+}
+
+// GetIGMP returns IGMP message of IPv4 packet or nil if packet isn't
+// IGMP packet. L3 header should be parsed. Message is a slice of the
+// first segment of packet.
+func (packet *Packet) GetIGMP() []byte {
+	ipv4 := packet.GetIPv4()
+	if ipv4 == nil || ipv4.NextProtoID != types.IGMPNumber ||
+		SwapBytesUint16(ipv4.FragmentOffset)&(types.IPv4MoreFragments|types.IPv4FragmentOffsetMask) != 0 {
+		return nil
+	}
+	return l3Payload(packet, uint(ipv4.VersionIhl&0x0f)<<2, uint(SwapBytesUint16(ipv4.TotalLength)))
+}
+
+// GetMLD returns MLD message of IPv6 packet or nil if packet isn't MLD
+// packet. MLD message can follow hop-by-hop options header. L3 header
+// should be parsed. Message is a slice of the first segment of packet.
+func (packet *Packet) GetMLD() []byte {
+	ipv6 := packet.GetIPv6()
+	if ipv6 == nil {
+		return nil
+	}
+	total := types.IPv6Len + uint(SwapBytesUint16(ipv6.PayloadLen))
+	data := l3Payload(packet, types.IPv6Len, total)
+	next := ipv6.Proto
+	if next == types.IPv6HopByHopNumber && len(data) >= 8 {
+		next = data[0]
+		length := (uint(data[1]) + 1) * 8
+		if length > uint(len(data)) {
+			return nil
+		}
+		data = data[length:]
+	}
+	if next != types.ICMPv6Number || len(data) == 0 {
+		return nil
+	}
+	switch data[0] {
+	case types.ICMPv6TypeMLDQuery, types.ICMPv6TypeMLDReport, types.ICMPv6TypeMLDDone, types.ICMPv6TypeMLDv2Report:
+		return data
+	}
+	return nil
+}
+
+// l3Payload returns bytes of L3 packet of total length after header of
+// hdrLen bytes which are in the first segment.
+func l3Payload(packet *Packet, hdrLen, total uint) []byte {
+	b := packet.GetSegmentBytes()
+	l3 := uint(uintptr(packet.L3) - uintptr(unsafe.Pointer(packet.Ether)))
+	if total < hdrLen || l3+total > uint(len(b)) {
+		return nil
+	}
+	return b[l3+hdrLen : l3+total]
+}
+
+// InitIGMPPacket initializes empty packet as IGMP packet with message
+// from srcMAC and srcIP. IPv4 header has router alert option and TTL
+// 1. Destination is group of message for IGMPv1 and IGMPv2 reports and
+// for group specific queries, all routers for leave messages, all
+// IGMPv3 routers for IGMPv3 reports and all systems for general
+// queries. Returns false if packet can't be initialized.
+func InitIGMPPacket(packet *Packet, srcMAC types.MACAddress, srcIP types.IPv4Address, msg *IGMPMessage) bool {
+	dst := msg.Group
+	switch {
+	case msg.Type == IGMPTypeV2Leave:
+		dst = igmpAllRouters
+	case msg.Type == IGMPTypeV3Report:
+		dst = igmpV3Routers
+	case msg.Type == IGMPTypeMembershipQuery && dst == 0:
+		dst = igmpAllSystems
+	}
+	dstIP := types.IPv4ToBytes(dst)
+	frame := make([]byte, types.EtherLen, 128)
+	copy(frame, []byte{0x01, 0x00, 0x5e, dstIP[1] & 0x7f, dstIP[2], dstIP[3]})
+	copy(frame[types.EtherAddrLen:], srcMAC[:])
+	frame[12], frame[13] = types.IPV4Number>>8, types.IPV4Number&0xff
+	hdrLen := types.IPv4MinLen + len(multicastRouterAlert)
+	frame = append(frame, make([]byte, types.IPv4MinLen)...)
+	frame = append(frame, multicastRouterAlert...)
+	frame = msg.AppendTo(frame)
+	if len(frame) > 0xffff {
+		return false
+	}
+	ipv4 := (*IPv4Hdr)(unsafe.Pointer(&frame[types.EtherLen]))
+	*ipv4 = IPv4Hdr{
+		VersionIhl:    0x40 | uint8(hdrLen/4),
+		TypeOfService: 0xc0,
+		TotalLength:   SwapBytesUint16(uint16(len(frame) - types.EtherLen)),
+		TimeToLive:    1,
+		NextProtoID:   types.IGMPNumber,
+		SrcAddr:       srcIP,
+		DstAddr:       dst,
+	}
+	setIPv4HeaderChecksum(ipv4)
+	return GeneratePacketFromByte(packet, frame)
+}
+
+// InitMLDPacket initializes empty packet as MLD packet with message
+// from srcMAC and srcIP. MLD message follows hop-by-hop options header
+// with router alert option, hop limit is 1. Destination is multicast
+// address of message for MLDv1 reports and address specific queries,
+// all routers for done messages, all MLDv2 routers for MLDv2 reports
+// and all nodes for general queries. ICMPv6 checksum is calculated.
+// Returns false if packet can't be initialized.
+func InitMLDPacket(packet *Packet, srcMAC types.MACAddress, srcIP types.IPv6Address, msg *MLDMessage) bool {
+	dst := msg.Group
+	switch {
+	case msg.Type == types.ICMPv6TypeMLDDone:
+		dst = mldAllRouters
+	case msg.Type == types.ICMPv6TypeMLDv2Report:
+		dst = mldV2Routers
+	case msg.Type == types.ICMPv6TypeMLDQuery && dst == types.IPv6Address{}:
+		dst = mldAllNodes
+	}
+	frame := make([]byte, types.EtherLen, 256)
+	var dstMAC types.MACAddress
+	CalculateIPv6BroadcastMACForDstMulticastIP(&dstMAC, dst)
+	copy(frame, dstMAC[:])
+	copy(frame[types.EtherAddrLen:], srcMAC[:])
+	frame[12], frame[13] = types.IPV6Number>>8, types.IPV6Number&0xff
+	frame = append(frame, make([]byte, types.IPv6Len)...)
+	// Hop-by-hop options header with router alert and PadN options
+	frame = append(frame, types.ICMPv6Number, 0, 0x05, 0x02, 0x00, 0x00, 0x01, 0x00)
+	icmp := len(frame)
+	frame = msg.AppendTo(frame)
+	payload := len(frame) - types.EtherLen - types.IPv6Len
+	if payload > 0xffff {
+		return false
+	}
+	ipv6 := (*IPv6Hdr)(unsafe.Pointer(&frame[types.EtherLen]))
+	*ipv6 = IPv6Hdr{
+		VtcFlow:    SwapBytesUint32(6 << 28),
+		PayloadLen: SwapBytesUint16(uint16(payload)),
+		Proto:      types.IPv6HopByHopNumber,
+		HopLimits:  1,
+		SrcAddr:    srcIP,
+		DstAddr:    dst,
+	}
+	icmpLen := len(frame) - icmp
+	cksum := multicastChecksum(frame[icmp:], calculateIPv6AddrChecksum(ipv6)+types.ICMPv6Number+uint32(icmpLen))
+	frame[icmp+2], frame[icmp+3] = uint8(cksum>>8), uint8(cksum)
+	return GeneratePacketFromByte(packet, frame)
+}
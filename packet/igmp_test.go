@@ -0,0 +1,198 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+var (
+	testIGMPGroup  = types.BytesToIPv4(239, 1, 2, 3)
+	testIGMPSource = types.BytesToIPv4(192, 0, 2, 10)
+	testMLDGroup   = types.IPv6Address{0xff, 0x0e, 15: 0x42}
+	testMLDSource  = types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 0x0a}
+)
+
+func TestMulticastFloatCode(t *testing.T) {
+	for _, mantBits := range []uint{4, 12} {
+		for _, value := range []uint32{0, 100, 1<<(mantBits+3) - 1, 1 << (mantBits + 3), 3 << (mantBits + 5), 1 << (mantBits + 10)} {
+			code := encodeFloatCode(value, mantBits)
+			if decoded := decodeFloatCode(code, mantBits); decoded != value {
+				t.Errorf("Value %d with %d bits of mantissa was encoded as %#x and decoded as %d", value, mantBits, code, decoded)
+			}
+		}
+	}
+	// Maximum code of IGMPv3
+	if decodeFloatCode(0xff, 4) != 31744 {
+		t.Errorf("Code 0xff was decoded as %d", decodeFloatCode(0xff, 4))
+	}
+}
+
+func TestIGMPAppendParse(t *testing.T) {
+	messages := []IGMPMessage{
+		{Type: IGMPTypeMembershipQuery, Version: 1},
+		{Type: IGMPTypeMembershipQuery, Version: 2, MaxResponse: 10 * time.Second, Group: testIGMPGroup},
+		{Type: IGMPTypeMembershipQuery, Version: 3, MaxResponse: 25600 * time.Millisecond, Group: testIGMPGroup,
+			SuppressRouter: true, QRV: 2, QQIC: 125, Sources: []types.IPv4Address{testIGMPSource}},
+		{Type: IGMPTypeV1Report, Version: 1, Group: testIGMPGroup},
+		{Type: IGMPTypeV2Report, Version: 2, Group: testIGMPGroup},
+		{Type: IGMPTypeV2Leave, Version: 2, Group: testIGMPGroup},
+		{Type: IGMPTypeV3Report, Version: 3, Records: []IGMPRecord{
+			{Type: MulticastModeIsInclude, Group: testIGMPGroup, Sources: []types.IPv4Address{testIGMPSource}},
+			{Type: MulticastChangeToExclude, Group: types.BytesToIPv4(239, 1, 2, 4)},
+		}},
+	}
+	for i := range messages {
+		data := messages[i].AppendTo(nil)
+		var msg IGMPMessage
+		if !ParseIGMP(data, &msg) {
+			t.Errorf("Can't parse IGMP message %x", data)
+			continue
+		}
+		if !reflect.DeepEqual(msg, messages[i]) {
+			t.Errorf("Parsed %+v instead of %+v", msg, messages[i])
+		}
+		// Corrupted checksum
+		data[2] ^= 0xff
+		if ParseIGMP(data, &msg) {
+			t.Errorf("IGMP message %x with wrong checksum was parsed", data)
+		}
+	}
+	data := messages[len(messages)-1].AppendTo(nil)
+	var msg IGMPMessage
+	if ParseIGMP(data[:len(data)-1], &msg) {
+		t.Error("Truncated IGMPv3 report was parsed")
+	}
+}
+
+func TestMLDAppendParse(t *testing.T) {
+	messages := []MLDMessage{
+		{Type: types.ICMPv6TypeMLDQuery, Version: 1, MaxResponse: 10 * time.Second},
+		{Type: types.ICMPv6TypeMLDQuery, Version: 2, MaxResponse: 10 * time.Second, Group: testMLDGroup,
+			QRV: 2, QQIC: 125, Sources: []types.IPv6Address{testMLDSource}},
+		{Type: types.ICMPv6TypeMLDReport, Version: 1, Group: testMLDGroup},
+		{Type: types.ICMPv6TypeMLDDone, Version: 1, Group: testMLDGroup},
+		{Type: types.ICMPv6TypeMLDv2Report, Version: 2, Records: []MLDRecord{
+			{Type: MulticastAllowNewSources, Group: testMLDGroup, Sources: []types.IPv6Address{testMLDSource}},
+		}},
+	}
+	for i := range messages {
+		data := messages[i].AppendTo(nil)
+		var msg MLDMessage
+		if !ParseMLD(data, &msg) {
+			t.Errorf("Can't parse MLD message %x", data)
+			continue
+		}
+		if !reflect.DeepEqual(msg, messages[i]) {
+			t.Errorf("Parsed %+v instead of %+v", msg, messages[i])
+		}
+		if ParseMLD(data[:len(data)-1], &msg) {
+			t.Errorf("Truncated MLD message %x was parsed", data[:len(data)-1])
+		}
+	}
+}
+
+func TestIGMPPacket(t *testing.T) {
+	src := types.MACAddress{0x02, 0, 0, 0, 0, 0x01}
+	srcIP := types.BytesToIPv4(192, 0, 2, 1)
+	msg := IGMPMessage{Type: IGMPTypeV2Report, Version: 2, Group: testIGMPGroup}
+	pkt := getPacket()
+	if !InitIGMPPacket(pkt, src, srcIP, &msg) {
+		t.Fatal("InitIGMPPacket failed")
+	}
+	pkt.ParseL3()
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil || ipv4.DstAddr != testIGMPGroup || ipv4.TimeToLive != 1 ||
+		pkt.Ether.DAddr != (types.MACAddress{0x01, 0x00, 0x5e, 0x01, 0x02, 0x03}) {
+		t.Fatalf("Wrong IGMP packet %x", pkt.GetRawPacketBytes())
+	}
+	if multicastChecksum(pkt.GetRawPacketBytes()[types.EtherLen:types.EtherLen+int(ipv4.VersionIhl&0x0f)*4], 0) != 0 {
+		t.Error("Wrong IPv4 header checksum")
+	}
+	var parsed IGMPMessage
+	if !ParseIGMP(pkt.GetIGMP(), &parsed) || !reflect.DeepEqual(parsed, msg) {
+		t.Errorf("Can't parse IGMP packet %x", pkt.GetRawPacketBytes())
+	}
+	if getIPv4UDPTestPacket().GetIGMP() != nil {
+		t.Error("GetIGMP returned message of UDP packet")
+	}
+}
+
+func TestMLDPacket(t *testing.T) {
+	src := types.MACAddress{0x02, 0, 0, 0, 0, 0x01}
+	srcIP := types.IPv6Address{0xfe, 0x80, 15: 0x01}
+	msg := MLDMessage{Type: types.ICMPv6TypeMLDDone, Version: 1, Group: testMLDGroup}
+	pkt := getPacket()
+	if !InitMLDPacket(pkt, src, srcIP, &msg) {
+		t.Fatal("InitMLDPacket failed")
+	}
+	pkt.ParseL3()
+	ipv6 := pkt.GetIPv6()
+	if ipv6 == nil || ipv6.DstAddr != mldAllRouters || ipv6.HopLimits != 1 {
+		t.Fatalf("Wrong MLD packet %x", pkt.GetRawPacketBytes())
+	}
+	data := pkt.GetMLD()
+	var parsed MLDMessage
+	if !ParseMLD(data, &parsed) || !reflect.DeepEqual(parsed, msg) {
+		t.Errorf("Can't parse MLD packet %x", pkt.GetRawPacketBytes())
+	}
+	sum := calculateIPv6AddrChecksum(ipv6) + types.ICMPv6Number + uint32(len(data))
+	if multicastChecksum(data, sum) != 0 {
+		t.Error("Wrong ICMPv6 checksum")
+	}
+}
+
+func TestMulticastMembership(t *testing.T) {
+	now := time.Now()
+	m := NewMulticastMembership(time.Minute)
+	other := types.BytesToIPv4(192, 0, 2, 20)
+
+	m.HandleIGMP(1, &IGMPMessage{Type: IGMPTypeV2Report, Group: testIGMPGroup}, now)
+	m.HandleIGMP(2, &IGMPMessage{Type: IGMPTypeV3Report, Records: []IGMPRecord{
+		{Type: MulticastModeIsInclude, Group: testIGMPGroup, Sources: []types.IPv4Address{testIGMPSource}},
+	}}, now)
+	if ports := m.IPv4Ports(testIGMPGroup, testIGMPSource, nil, now); len(ports) != 2 {
+		t.Errorf("Ports of source are %v", ports)
+	}
+	if ports := m.IPv4Ports(testIGMPGroup, other, nil, now); !reflect.DeepEqual(ports, []uint16{1}) {
+		t.Errorf("Ports of other source are %v", ports)
+	}
+
+	// Port 1 blocks source, port 2 leaves
+	m.HandleIGMP(1, &IGMPMessage{Type: IGMPTypeV3Report, Records: []IGMPRecord{
+		{Type: MulticastBlockOldSources, Group: testIGMPGroup, Sources: []types.IPv4Address{testIGMPSource}},
+	}}, now)
+	m.HandleIGMP(2, &IGMPMessage{Type: IGMPTypeV3Report, Records: []IGMPRecord{
+		{Type: MulticastChangeToInclude, Group: testIGMPGroup},
+	}}, now)
+	if ports := m.IPv4Ports(testIGMPGroup, testIGMPSource, nil, now); len(ports) != 0 {
+		t.Errorf("Ports of blocked source are %v", ports)
+	}
+	if ports := m.IPv4Ports(testIGMPGroup, other, nil, now.Add(2*time.Minute)); len(ports) != 0 {
+		t.Errorf("Ports of expired group are %v", ports)
+	}
+
+	m.HandleMLD(3, &MLDMessage{Type: types.ICMPv6TypeMLDReport, Group: testMLDGroup}, now)
+	if ports := m.Ports(testMLDGroup, testMLDSource, nil, now); !reflect.DeepEqual(ports, []uint16{3}) {
+		t.Errorf("Ports of IPv6 group are %v", ports)
+	}
+	m.HandleMLD(3, &MLDMessage{Type: types.ICMPv6TypeMLDDone, Group: testMLDGroup}, now)
+	if ports := m.Ports(testMLDGroup, testMLDSource, nil, now); len(ports) != 0 {
+		t.Errorf("Ports of left IPv6 group are %v", ports)
+	}
+
+	m.Expire(now.Add(2 * time.Minute))
+	if len(m.groups) != 0 {
+		t.Errorf("Groups %v weren't expired", m.groups)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Maximum number of extension headers which are walked, longer chains
+// are treated as malformed to bound processing time of crafted packets.
+const maxIPv6ExtHeaders = 16
+
+// IPv6ExtHdr describes one extension header of IPv6 packet.
+type IPv6ExtHdr struct {
+	Type       uint8 // Protocol number of extension header
+	NextHeader uint8 // Protocol number of the following header
+	Offset     uint  // Offset of header from start of IPv6 header
+	Len        uint  // Length of header in bytes
+}
+
+// IsIPv6ExtHeader returns true if proto is a protocol number of
+// extension header which is walked by ParseIPv6ExtHeaders: hop-by-hop
+// options, routing, fragment or destination options.
+func IsIPv6ExtHeader(proto uint8) bool {
+	switch proto {
+	case types.IPv6HopByHopNumber, types.IPv6RoutingNumber, types.IPv6FragmentNumber, types.IPv6DestOptsNumber:
+		return true
+	}
+	return false
+}
+
+// ParseIPv6ExtHeaders walks extension headers of IPv6 packet in data
+// which starts with IPv6 header. It returns protocol number of L4
+// header and its offset from start of IPv6 header. Extension headers
+// are appended to hdrs which can be nil. Walk stops at fragment header
+// of fragment with non-zero offset because such fragment doesn't have
+// L4 header, in this case IPv6FragmentNumber and offset of fragment
+// header are returned. Hop-by-hop options are accepted only right
+// after IPv6 header. Returns false if chain is longer than data, is
+// too long or is malformed.
+func ParseIPv6ExtHeaders(data []byte, hdrs []IPv6ExtHdr) (uint8, uint, []IPv6ExtHdr, bool) {
+	if len(data) < types.IPv6Len {
+		return 0, 0, hdrs, false
+	}
+	proto := data[6]
+	offset := uint(types.IPv6Len)
+	for i := 0; IsIPv6ExtHeader(proto); i++ {
+		if i == maxIPv6ExtHeaders || (proto == types.IPv6HopByHopNumber && i != 0) ||
+			offset+2 > uint(len(data)) {
+			return 0, 0, hdrs, false
+		}
+		var length uint
+		if proto == types.IPv6FragmentNumber {
+			length = types.IPv6FragmentLen
+		} else {
+			length = (uint(data[offset+1]) + 1) * 8
+		}
+		if offset+length > uint(len(data)) {
+			return 0, 0, hdrs, false
+		}
+		next := data[offset]
+		hdrs = append(hdrs, IPv6ExtHdr{Type: proto, NextHeader: next, Offset: offset, Len: length})
+		if proto == types.IPv6FragmentNumber &&
+			(uint16(data[offset+2])<<8|uint16(data[offset+3]))&^7 != 0 {
+			return proto, offset, hdrs, true
+		}
+		proto = next
+		offset += length
+	}
+	return proto, offset, hdrs, true
+}
+
+// ParseL4ForIPv6Ext sets L4 to start of L4 header of IPv6 packet
+// skipping extension headers and returns protocol number of L4 header.
+// Unlike ParseL4ForIPv6 it doesn't assume that L4 header follows IPv6
+// header. L3 header should be parsed and be in the first segment of
+// packet. Returns false and doesn't change L4 if extension headers
+// are malformed.
+func (packet *Packet) ParseL4ForIPv6Ext() (uint8, bool) {
+	proto, offset, _, ok := ParseIPv6ExtHeaders(packet.l3Bytes(), nil)
+	if ok {
+		packet.L4 = unsafe.Pointer(uintptr(packet.L3) + uintptr(offset))
+	}
+	return proto, ok
+}
+
+// GetIPv6ExtHeaders returns extension headers of IPv6 packet appended
+// to hdrs. L3 header should be parsed.
+func (packet *Packet) GetIPv6ExtHeaders(hdrs []IPv6ExtHdr) ([]IPv6ExtHdr, bool) {
+	_, _, hdrs, ok := ParseIPv6ExtHeaders(packet.l3Bytes(), hdrs)
+	return hdrs, ok
+}
+
+// l3Bytes returns bytes of the first segment of packet starting at L3
+// header.
+func (packet *Packet) l3Bytes() []byte {
+	start := uintptr(packet.L3) - uintptr(packet.StartAtOffset(0))
+	length := uintptr(packet.GetPacketSegmentLen())
+	if start >= length {
+		return nil
+	}
+	return (*[types.MaxLength]byte)(packet.L3)[: length-start : length-start]
+}
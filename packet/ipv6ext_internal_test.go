@@ -0,0 +1,83 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// ipv6WithExt returns IPv6 header with next header proto followed by
+// given headers.
+func ipv6WithExt(proto uint8, hdrs ...[]byte) []byte {
+	data := make([]byte, types.IPv6Len)
+	data[0] = types.IPv6VtcFlow
+	data[6] = proto
+	for _, h := range hdrs {
+		data = append(data, h...)
+	}
+	return data
+}
+
+func TestParseIPv6ExtHeaders(t *testing.T) {
+	hopByHop := []byte{types.IPv6RoutingNumber, 0, 1, 4, 0, 0, 0, 0}
+	routing := []byte{types.IPv6FragmentNumber, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	firstFragment := []byte{types.IPv6DestOptsNumber, 0, 0, 1, 0, 0, 0, 7}
+	destOpts := []byte{types.UDPNumber, 0, 1, 4, 0, 0, 0, 0}
+	udp := make([]byte, types.UDPLen)
+	tests := []struct {
+		name   string
+		data   []byte
+		proto  uint8
+		offset uint
+		hdrs   []IPv6ExtHdr
+		ok     bool
+	}{
+		{"no extensions", ipv6WithExt(types.TCPNumber), types.TCPNumber, 40, nil, true},
+		{"no next header", ipv6WithExt(types.NoNextHeader), types.NoNextHeader, 40, nil, true},
+		{"chain", ipv6WithExt(types.IPv6HopByHopNumber, hopByHop, routing, firstFragment, destOpts, udp), types.UDPNumber, 80,
+			[]IPv6ExtHdr{
+				{types.IPv6HopByHopNumber, types.IPv6RoutingNumber, 40, 8},
+				{types.IPv6RoutingNumber, types.IPv6FragmentNumber, 48, 16},
+				{types.IPv6FragmentNumber, types.IPv6DestOptsNumber, 64, 8},
+				{types.IPv6DestOptsNumber, types.UDPNumber, 72, 8},
+			}, true},
+		{"next fragment", ipv6WithExt(types.IPv6FragmentNumber, []byte{types.UDPNumber, 0, 0x05, 0x01, 0, 0, 0, 7}, udp),
+			types.IPv6FragmentNumber, 40,
+			[]IPv6ExtHdr{{types.IPv6FragmentNumber, types.UDPNumber, 40, 8}}, true},
+		{"truncated header", ipv6WithExt(types.IPv6RoutingNumber, routing[:12]), 0, 0, nil, false},
+		{"truncated chain", ipv6WithExt(types.IPv6DestOptsNumber, destOpts[:1]), 0, 0, nil, false},
+		{"late hop-by-hop", ipv6WithExt(types.IPv6DestOptsNumber, []byte{types.IPv6HopByHopNumber, 0, 1, 4, 0, 0, 0, 0}, hopByHop),
+			0, 0, nil, false},
+		{"short IPv6 header", make([]byte, 20), 0, 0, nil, false},
+	}
+	for _, tt := range tests {
+		proto, offset, hdrs, ok := ParseIPv6ExtHeaders(tt.data, nil)
+		if ok != tt.ok || proto != tt.proto || offset != tt.offset {
+			t.Errorf("%s: ParseIPv6ExtHeaders() = %d, %d, %v, want %d, %d, %v", tt.name, proto, offset, ok, tt.proto, tt.offset, tt.ok)
+		}
+		if ok && !reflect.DeepEqual(hdrs, tt.hdrs) {
+			t.Errorf("%s: headers are %v, want %v", tt.name, hdrs, tt.hdrs)
+		}
+	}
+}
+
+func TestParseIPv6ExtHeadersLimit(t *testing.T) {
+	var hdrs [][]byte
+	for i := 0; i < maxIPv6ExtHeaders; i++ {
+		hdrs = append(hdrs, []byte{types.IPv6DestOptsNumber, 0, 1, 4, 0, 0, 0, 0})
+	}
+	data := ipv6WithExt(types.IPv6DestOptsNumber, hdrs...)
+	if _, _, _, ok := ParseIPv6ExtHeaders(data, nil); ok {
+		t.Errorf("Chain of %d extension headers is accepted", len(hdrs)+1)
+	}
+	hdrs[len(hdrs)-1][0] = types.TCPNumber
+	data = ipv6WithExt(types.IPv6DestOptsNumber, hdrs...)
+	if proto, _, _, ok := ParseIPv6ExtHeaders(data, nil); !ok || proto != types.TCPNumber {
+		t.Errorf("Chain of %d extension headers isn't accepted", len(hdrs))
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"fmt"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// LLDP TLV types (IEEE 802.1AB)
+const (
+	LLDPTypeEnd               = 0
+	LLDPTypeChassisID         = 1
+	LLDPTypePortID            = 2
+	LLDPTypeTTL               = 3
+	LLDPTypePortDescription   = 4
+	LLDPTypeSystemName        = 5
+	LLDPTypeSystemDescription = 6
+	LLDPTypeCapabilities      = 7
+	LLDPTypeManagementAddress = 8
+	LLDPTypeOrgSpecific       = 127
+)
+
+// Subtypes of chassis ID TLV
+const (
+	LLDPChassisIDInterfaceAlias = 2
+	LLDPChassisIDMACAddress     = 4
+	LLDPChassisIDNetworkAddress = 5
+	LLDPChassisIDInterfaceName  = 6
+	LLDPChassisIDLocal          = 7
+)
+
+// Subtypes of port ID TLV
+const (
+	LLDPPortIDInterfaceAlias = 1
+	LLDPPortIDMACAddress     = 3
+	LLDPPortIDNetworkAddress = 4
+	LLDPPortIDInterfaceName  = 5
+	LLDPPortIDLocal          = 7
+)
+
+// System capabilities of LLDP
+const (
+	LLDPCapabilityOther     = 0x0001
+	LLDPCapabilityRepeater  = 0x0002
+	LLDPCapabilityBridge    = 0x0004
+	LLDPCapabilityWLAN      = 0x0008
+	LLDPCapabilityRouter    = 0x0010
+	LLDPCapabilityTelephone = 0x0020
+	LLDPCapabilityDOCSIS    = 0x0040
+	LLDPCapabilityStation   = 0x0080
+)
+
+// LLDPMulticastAddress is a nearest bridge destination address of LLDP
+// frames.
+var LLDPMulticastAddress = types.MACAddress{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+const (
+	lldpTLVHdrLen   = 2
+	lldpMaxValueLen = 0x1ff
+)
+
+// LLDPInfo is a content of LLDP data unit. Slices of parsed data unit
+// point to packet data.
+type LLDPInfo struct {
+	ChassisIDSubtype uint8
+	ChassisID        []byte
+	PortIDSubtype    uint8
+	PortID           []byte
+	// Time to live of information in seconds, zero TTL means that
+	// information should be removed
+	TTL               uint16
+	PortDescription   []byte
+	SystemName        []byte
+	SystemDescription []byte
+	// Supported and enabled system capabilities, zero if data unit
+	// doesn't have capabilities TLV
+	Capabilities        uint16
+	EnabledCapabilities uint16
+}
+
+func (info *LLDPInfo) String() string {
+	return fmt.Sprintf("LLDP: chassis ID = %s, port ID = %s, TTL = %d, system name = %q, port description = %q",
+		lldpIDString(info.ChassisIDSubtype, info.ChassisID, LLDPChassisIDMACAddress),
+		lldpIDString(info.PortIDSubtype, info.PortID, LLDPPortIDMACAddress),
+		info.TTL, info.SystemName, info.PortDescription)
+}
+
+// lldpIDString formats chassis or port ID, MAC addresses are formatted
+// as usual.
+func lldpIDString(subtype uint8, id []byte, macSubtype uint8) string {
+	if subtype == macSubtype && len(id) == types.EtherAddrLen {
+		var mac types.MACAddress
+		copy(mac[:], id)
+		return mac.String()
+	}
+	return fmt.Sprintf("%q", id)
+}
+
+// LLDPTLVs calls fn for every TLV of LLDP data unit before end TLV
+// until fn returns false. Value of TLV is a slice of data. Returns
+// false if length of TLV exceeds data.
+func LLDPTLVs(data []byte, fn func(typ uint8, value []byte) bool) bool {
+	for len(data) != 0 {
+		if len(data) < lldpTLVHdrLen {
+			return false
+		}
+		typ := data[0] >> 1
+		length := int(data[0]&1)<<8 | int(data[1])
+		data = data[lldpTLVHdrLen:]
+		if typ == LLDPTypeEnd {
+			return true
+		}
+		if length > len(data) {
+			return false
+		}
+		if !fn(typ, data[:length:length]) {
+			return true
+		}
+		data = data[length:]
+	}
+	return true
+}
+
+// ParseLLDP fills info from LLDP data unit. Returns false if data unit
+// is malformed or doesn't start with chassis ID, port ID and TTL TLVs.
+func ParseLLDP(data []byte, info *LLDPInfo) bool {
+	*info = LLDPInfo{}
+	index := 0
+	ok := true
+	if !LLDPTLVs(data, func(typ uint8, value []byte) bool {
+		// Mandatory TLVs
+		if index < LLDPTypeTTL {
+			index++
+			if typ != uint8(index) {
+				ok = false
+				return false
+			}
+		}
+		switch typ {
+		case LLDPTypeChassisID, LLDPTypePortID:
+			if len(value) < 2 || len(value) > 256 {
+				ok = false
+				return false
+			}
+			if typ == LLDPTypeChassisID {
+				info.ChassisIDSubtype, info.ChassisID = value[0], value[1:]
+			} else {
+				info.PortIDSubtype, info.PortID = value[0], value[1:]
+			}
+		case LLDPTypeTTL:
+			if len(value) < 2 {
+				ok = false
+				return false
+			}
+			info.TTL = uint16(value[0])<<8 | uint16(value[1])
+		case LLDPTypePortDescription:
+			info.PortDescription = value
+		case LLDPTypeSystemName:
+			info.SystemName = value
+		case LLDPTypeSystemDescription:
+			info.SystemDescription = value
+		case LLDPTypeCapabilities:
+			if len(value) == 4 {
+				info.Capabilities = uint16(value[0])<<8 | uint16(value[1])
+				info.EnabledCapabilities = uint16(value[2])<<8 | uint16(value[3])
+			}
+		}
+		return true
+	}) {
+		return false
+	}
+	return ok && index == LLDPTypeTTL
+}
+
+// AppendLLDPTLV appends TLV with given type and value to b. Value
+// should not be longer than 511 bytes.
+func AppendLLDPTLV(b []byte, typ uint8, value []byte) []byte {
+	b = append(b, typ<<1|uint8(len(value)>>8), uint8(len(value)))
+	return append(b, value...)
+}
+
+// AppendTo appends LLDP data unit with info and end TLV to b. Returns
+// false if IDs are empty or fields are too long.
+func (info *LLDPInfo) AppendTo(b []byte) ([]byte, bool) {
+	if len(info.ChassisID) == 0 || len(info.ChassisID) > 255 || len(info.PortID) == 0 || len(info.PortID) > 255 {
+		return b, false
+	}
+	for _, s := range [][]byte{info.PortDescription, info.SystemName, info.SystemDescription} {
+		if len(s) > lldpMaxValueLen {
+			return b, false
+		}
+	}
+	b = append(b, LLDPTypeChassisID<<1, uint8(len(info.ChassisID)+1), info.ChassisIDSubtype)
+	b = append(b, info.ChassisID...)
+	b = append(b, LLDPTypePortID<<1, uint8(len(info.PortID)+1), info.PortIDSubtype)
+	b = append(b, info.PortID...)
+	b = AppendLLDPTLV(b, LLDPTypeTTL, []byte{uint8(info.TTL >> 8), uint8(info.TTL)})
+	if len(info.PortDescription) != 0 {
+		b = AppendLLDPTLV(b, LLDPTypePortDescription, info.PortDescription)
+	}
+	if len(info.SystemName) != 0 {
+		b = AppendLLDPTLV(b, LLDPTypeSystemName, info.SystemName)
+	}
+	if len(info.SystemDescription) != 0 {
+		b = AppendLLDPTLV(b, LLDPTypeSystemDescription, info.SystemDescription)
+	}
+	if info.Capabilities != 0 {
+		b = AppendLLDPTLV(b, LLDPTypeCapabilities, []byte{uint8(info.Capabilities >> 8), uint8(info.Capabilities),
+			uint8(info.EnabledCapabilities >> 8), uint8(info.EnabledCapabilities)})
+	}
+	return AppendLLDPTLV(b, LLDPTypeEnd, nil), true
+}
+
+// GetLLDP returns LLDP data unit of packet or nil if packet isn't LLDP
+// frame. Data unit is a slice of the first segment of packet.
+func (packet *Packet) GetLLDP() []byte {
+	if packet.Ether.EtherType != types.SwapLLDPNumber {
+		return nil
+	}
+	b := packet.GetSegmentBytes()
+	if len(b) < types.EtherLen {
+		return nil
+	}
+	return b[types.EtherLen:]
+}
+
+// InitLLDPPacket initializes empty packet as LLDP frame from src to
+// nearest bridge multicast address with data unit of info. Returns
+// false if info is invalid or packet can't be extended.
+func InitLLDPPacket(packet *Packet, src types.MACAddress, info *LLDPInfo) bool {
+	frame := make([]byte, types.EtherLen, 128)
+	copy(frame, LLDPMulticastAddress[:])
+	copy(frame[types.EtherAddrLen:], src[:])
+	frame[12], frame[13] = types.LLDPNumber>>8, types.LLDPNumber&0xff
+	frame, ok := info.AppendTo(frame)
+	if !ok {
+		return false
+	}
+	// Minimal Ethernet frame without FCS
+	for len(frame) < 60 {
+		frame = append(frame, 0)
+	}
+	return GeneratePacketFromByte(packet, frame)
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+var testLLDPInfo = LLDPInfo{
+	ChassisIDSubtype:    LLDPChassisIDMACAddress,
+	ChassisID:           []byte{0x02, 0, 0, 0, 0, 0x01},
+	PortIDSubtype:       LLDPPortIDInterfaceName,
+	PortID:              []byte("port0"),
+	TTL:                 120,
+	PortDescription:     []byte("uplink"),
+	SystemName:          []byte("nff-go"),
+	SystemDescription:   []byte("NFF-GO appliance"),
+	Capabilities:        LLDPCapabilityRouter | LLDPCapabilityBridge,
+	EnabledCapabilities: LLDPCapabilityRouter,
+}
+
+func TestLLDPAppendParse(t *testing.T) {
+	data, ok := testLLDPInfo.AppendTo(nil)
+	if !ok {
+		t.Fatal("AppendTo failed")
+	}
+	// Chassis ID TLV
+	if !bytes.Equal(data[:9], []byte{0x02, 7, LLDPChassisIDMACAddress, 0x02, 0, 0, 0, 0, 0x01}) {
+		t.Errorf("Wrong chassis ID TLV %x", data[:9])
+	}
+	if !bytes.Equal(data[len(data)-2:], []byte{0, 0}) {
+		t.Error("Data unit doesn't end with end TLV")
+	}
+	var info LLDPInfo
+	if !ParseLLDP(data, &info) {
+		t.Fatal("ParseLLDP failed")
+	}
+	if info.ChassisIDSubtype != testLLDPInfo.ChassisIDSubtype || !bytes.Equal(info.ChassisID, testLLDPInfo.ChassisID) ||
+		info.PortIDSubtype != testLLDPInfo.PortIDSubtype || !bytes.Equal(info.PortID, testLLDPInfo.PortID) ||
+		info.TTL != testLLDPInfo.TTL || !bytes.Equal(info.PortDescription, testLLDPInfo.PortDescription) ||
+		!bytes.Equal(info.SystemName, testLLDPInfo.SystemName) ||
+		!bytes.Equal(info.SystemDescription, testLLDPInfo.SystemDescription) ||
+		info.Capabilities != testLLDPInfo.Capabilities || info.EnabledCapabilities != testLLDPInfo.EnabledCapabilities {
+		t.Errorf("Parsed %v instead of %v", &info, &testLLDPInfo)
+	}
+	if s := info.String(); !bytes.Contains([]byte(s), []byte("02:00:00:00:00:01")) {
+		t.Errorf("String() = %s", s)
+	}
+	var seen []uint8
+	LLDPTLVs(data, func(typ uint8, value []byte) bool {
+		seen = append(seen, typ)
+		return typ != LLDPTypeTTL
+	})
+	if !bytes.Equal(seen, []byte{LLDPTypeChassisID, LLDPTypePortID, LLDPTypeTTL}) {
+		t.Errorf("LLDPTLVs stopped after types %v", seen)
+	}
+}
+
+func TestLLDPMalformed(t *testing.T) {
+	data, _ := testLLDPInfo.AppendTo(nil)
+	var info LLDPInfo
+	for i := 0; i < 13; i++ {
+		if ParseLLDP(data[:i], &info) {
+			t.Errorf("Truncated data unit of %d bytes was parsed", i)
+		}
+	}
+	// Port ID TLV before chassis ID TLV
+	swapped := AppendLLDPTLV(nil, LLDPTypePortID, []byte{LLDPPortIDLocal, '1'})
+	swapped = AppendLLDPTLV(swapped, LLDPTypeChassisID, []byte{LLDPChassisIDLocal, '1'})
+	swapped = AppendLLDPTLV(swapped, LLDPTypeTTL, []byte{0, 1})
+	if ParseLLDP(swapped, &info) {
+		t.Error("Data unit with wrong order of TLVs was parsed")
+	}
+	if _, ok := (&LLDPInfo{PortID: []byte("1")}).AppendTo(nil); ok {
+		t.Error("Data unit without chassis ID was built")
+	}
+}
+
+func TestLLDPPacket(t *testing.T) {
+	src := types.MACAddress{0x02, 0, 0, 0, 0, 0x01}
+	pkt := getPacket()
+	if !InitLLDPPacket(pkt, src, &testLLDPInfo) {
+		t.Fatal("InitLLDPPacket failed")
+	}
+	if pkt.Ether.DAddr != LLDPMulticastAddress || pkt.Ether.SAddr != src || pkt.GetPacketLen() < 60 {
+		t.Errorf("Wrong LLDP frame %x", pkt.GetRawPacketBytes())
+	}
+	var info LLDPInfo
+	if !ParseLLDP(pkt.GetLLDP(), &info) || !bytes.Equal(info.SystemName, testLLDPInfo.SystemName) {
+		t.Error("Can't parse LLDP frame")
+	}
+	if getIPv4UDPTestPacket().GetLLDP() != nil {
+		t.Error("GetLLDP returned data unit of IPv4 packet")
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"sync"
+	"unsafe"
+
+	. "github.com/intel-go/nff-go/common"
+)
+
+// MetadataSize is a size of per packet metadata area in bytes. Area
+// is stored in mbuf headroom together with Packet structure, so it is
+// small.
+const MetadataSize = 14
+
+// metadata is an area of Packet structure with values of metadata
+// fields. Bit mask should be at offset 62 of Packet structure because
+// it is cleared by C code, whole area at offset 48 is copied to clones
+// by C code too.
+type metadata struct {
+	data  [MetadataSize]byte
+	valid uint16
+}
+
+// metadataField is a registered field of metadata area. Fields are
+// aligned to their size.
+type metadataField struct {
+	offset uintptr
+	size   uintptr
+	// Bit of field in mask of set fields, zero for unregistered
+	// fields
+	bit uint16
+}
+
+var metadataFields = struct {
+	sync.Mutex
+	fields map[string]metadataField
+	used   [MetadataSize]bool
+	count  uint
+}{
+	fields: make(map[string]metadataField),
+}
+
+// Metadata fields which are registered by default, so that nodes of
+// different applications can exchange classification results.
+var (
+	FlowIDMetadata  = MetadataUint32{mustRegisterMetadata("flow-id", 4)}
+	TenantMetadata  = MetadataUint32{mustRegisterMetadata("tenant", 4)}
+	VerdictMetadata = MetadataUint8{mustRegisterMetadata("verdict", 1)}
+)
+
+func mustRegisterMetadata(name string, size uintptr) metadataField {
+	f, err := registerMetadata(name, size)
+	if err != nil {
+		LogFatal(Initialization, err)
+	}
+	return f
+}
+
+// registerMetadata allocates space for field with given name and size
+// or returns already registered field. Returns error if field is
+// registered with different size or metadata area is full.
+func registerMetadata(name string, size uintptr) (metadataField, error) {
+	metadataFields.Lock()
+	defer metadataFields.Unlock()
+	if f, ok := metadataFields.fields[name]; ok {
+		if f.size != size {
+			return metadataField{}, WrapWithNFError(nil, "Metadata field "+name+" is registered with different type", BadArgument)
+		}
+		return f, nil
+	}
+	if metadataFields.count < 16 {
+		for offset := uintptr(0); offset+size <= MetadataSize; offset += size {
+			free := true
+			for i := offset; i < offset+size; i++ {
+				free = free && !metadataFields.used[i]
+			}
+			if !free {
+				continue
+			}
+			for i := offset; i < offset+size; i++ {
+				metadataFields.used[i] = true
+			}
+			f := metadataField{offset: offset, size: size, bit: 1 << metadataFields.count}
+			metadataFields.count++
+			metadataFields.fields[name] = f
+			return f, nil
+		}
+	}
+	return metadataField{}, WrapWithNFError(nil, "No space for metadata field "+name, TableIsFull)
+}
+
+func (packet *Packet) metadataPointer(f metadataField) unsafe.Pointer {
+	return unsafe.Pointer(&packet.meta.data[f.offset])
+}
+
+// IsSet returns true if field of packet is set.
+func (f metadataField) IsSet(packet *Packet) bool {
+	return f.bit != 0 && packet.meta.valid&f.bit != 0
+}
+
+// Clear marks field of packet as not set.
+func (f metadataField) Clear(packet *Packet) {
+	packet.meta.valid &^= f.bit
+}
+
+// ClearMetadata marks all metadata fields of packet as not set.
+func (packet *Packet) ClearMetadata() {
+	packet.meta.valid = 0
+}
+
+// MetadataUint8 is a uint8 metadata field.
+type MetadataUint8 struct{ metadataField }
+
+// RegisterMetadataUint8 registers uint8 metadata field with given name
+// or returns field which was already registered with this name, so
+// that nodes can find fields of each other. Returns error if name is
+// registered with different type or metadata area is full.
+func RegisterMetadataUint8(name string) (MetadataUint8, error) {
+	f, err := registerMetadata(name, 1)
+	return MetadataUint8{f}, err
+}
+
+// Get returns value of field of packet and true if it is set.
+func (f MetadataUint8) Get(packet *Packet) (uint8, bool) {
+	if !f.IsSet(packet) {
+		return 0, false
+	}
+	return *(*uint8)(packet.metadataPointer(f.metadataField)), true
+}
+
+// Set sets value of field of packet.
+func (f MetadataUint8) Set(packet *Packet, value uint8) {
+	if f.bit != 0 {
+		*(*uint8)(packet.metadataPointer(f.metadataField)) = value
+		packet.meta.valid |= f.bit
+	}
+}
+
+// MetadataUint16 is a uint16 metadata field.
+type MetadataUint16 struct{ metadataField }
+
+// RegisterMetadataUint16 registers uint16 metadata field like
+// RegisterMetadataUint8.
+func RegisterMetadataUint16(name string) (MetadataUint16, error) {
+	f, err := registerMetadata(name, 2)
+	return MetadataUint16{f}, err
+}
+
+// Get returns value of field of packet and true if it is set.
+func (f MetadataUint16) Get(packet *Packet) (uint16, bool) {
+	if !f.IsSet(packet) {
+		return 0, false
+	}
+	return *(*uint16)(packet.metadataPointer(f.metadataField)), true
+}
+
+// Set sets value of field of packet.
+func (f MetadataUint16) Set(packet *Packet, value uint16) {
+	if f.bit != 0 {
+		*(*uint16)(packet.metadataPointer(f.metadataField)) = value
+		packet.meta.valid |= f.bit
+	}
+}
+
+// MetadataUint32 is a uint32 metadata field.
+type MetadataUint32 struct{ metadataField }
+
+// RegisterMetadataUint32 registers uint32 metadata field like
+// RegisterMetadataUint8.
+func RegisterMetadataUint32(name string) (MetadataUint32, error) {
+	f, err := registerMetadata(name, 4)
+	return MetadataUint32{f}, err
+}
+
+// Get returns value of field of packet and true if it is set.
+func (f MetadataUint32) Get(packet *Packet) (uint32, bool) {
+	if !f.IsSet(packet) {
+		return 0, false
+	}
+	return *(*uint32)(packet.metadataPointer(f.metadataField)), true
+}
+
+// Set sets value of field of packet.
+func (f MetadataUint32) Set(packet *Packet, value uint32) {
+	if f.bit != 0 {
+		*(*uint32)(packet.metadataPointer(f.metadataField)) = value
+		packet.meta.valid |= f.bit
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/internal/low"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func TestMetadataLayout(t *testing.T) {
+	var p Packet
+	// Bit mask of set fields is cleared by C code at this offset
+	if offset := unsafe.Offsetof(p.meta) + unsafe.Offsetof(p.meta.valid); offset != 62 {
+		t.Errorf("Bit mask of metadata fields has offset %d", offset)
+	}
+	// Whole area is copied to clones by C code from this offset
+	if offset := unsafe.Offsetof(p.meta); offset != 48 || unsafe.Sizeof(p.meta) != 16 {
+		t.Errorf("Metadata area has offset %d and size %d", offset, unsafe.Sizeof(p.meta))
+	}
+}
+
+func TestMetadataFields(t *testing.T) {
+	mark, err := RegisterMetadataUint16("test-mark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := RegisterMetadataUint16("test-mark"); err != nil || again != mark {
+		t.Errorf("Registered field again as %v, %v", again, err)
+	}
+	if _, err := RegisterMetadataUint32("test-mark"); err == nil {
+		t.Error("Field was registered with different type")
+	}
+	if _, err := RegisterMetadataUint32("test-too-large"); err == nil {
+		t.Error("Field which doesn't fit in metadata area was registered")
+	}
+
+	pkt := getPacket()
+	if _, ok := FlowIDMetadata.Get(pkt); ok {
+		t.Error("Metadata of new packet is set")
+	}
+	FlowIDMetadata.Set(pkt, 0x12345678)
+	TenantMetadata.Set(pkt, 42)
+	VerdictMetadata.Set(pkt, 3)
+	mark.Set(pkt, 0xbeef)
+	if v, ok := FlowIDMetadata.Get(pkt); !ok || v != 0x12345678 {
+		t.Errorf("Flow ID is %x, %v", v, ok)
+	}
+	if v, ok := TenantMetadata.Get(pkt); !ok || v != 42 {
+		t.Errorf("Tenant is %d, %v", v, ok)
+	}
+	if v, ok := VerdictMetadata.Get(pkt); !ok || v != 3 {
+		t.Errorf("Verdict is %d, %v", v, ok)
+	}
+	if v, ok := mark.Get(pkt); !ok || v != 0xbeef {
+		t.Errorf("Mark is %x, %v", v, ok)
+	}
+
+	clone, err := pkt.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := mark.Get(clone); !ok || v != 0xbeef {
+		t.Errorf("Mark of clone is %x, %v", v, ok)
+	}
+	// Clones made by copy flow function don't use Packet.Clone
+	direct := ExtractPacket(low.CloneMbuf(pkt.CMbuf, nonPerfMempool))
+	if direct.meta != pkt.meta {
+		t.Errorf("Metadata of mbuf clone is %v instead of %v", direct.meta, pkt.meta)
+	}
+	direct.DecRefCount()
+	clone.DecRefCount()
+
+	TenantMetadata.Clear(pkt)
+	if TenantMetadata.IsSet(pkt) || !FlowIDMetadata.IsSet(pkt) {
+		t.Error("Wrong field was cleared")
+	}
+	pkt.ClearMetadata()
+	if FlowIDMetadata.IsSet(pkt) || mark.IsSet(pkt) {
+		t.Error("Metadata wasn't cleared")
+	}
+
+	var unregistered MetadataUint32
+	unregistered.Set(pkt, 1)
+	if unregistered.IsSet(pkt) || FlowIDMetadata.IsSet(pkt) {
+		t.Error("Unregistered field was set")
+	}
+}
@@ -109,3 +109,110 @@ func (hdr *MPLSHdr) DecreaseTTL() bool {
 	hdr.mpls = SwapBytesUint32(SwapBytesUint32(hdr.mpls)&0xffffff00 | newTime)
 	return true
 }
+
+// SetMPLSTC sets the Traffic Class, only 3 lower bits of tc are used.
+func (hdr *MPLSHdr) SetMPLSTC(tc uint32) {
+	hdr.mpls = SwapBytesUint32(SwapBytesUint32(hdr.mpls)&0xfffff1ff | (tc&7)<<9)
+}
+
+// SetMPLSS sets the Bottom-Of-Stack value, only lower bit of s is used.
+func (hdr *MPLSHdr) SetMPLSS(s uint32) {
+	hdr.mpls = SwapBytesUint32(SwapBytesUint32(hdr.mpls)&0xfffffeff | (s&1)<<8)
+}
+
+// SetMPLSTTL sets the Time-to-Live value.
+func (hdr *MPLSHdr) SetMPLSTTL(ttl uint8) {
+	hdr.mpls = SwapBytesUint32(SwapBytesUint32(hdr.mpls)&0xffffff00 | uint32(ttl))
+}
+
+// MPLSStackLen returns number of MPLS headers after Ether header up to
+// and including header with Bottom-Of-Stack bit. Returns 0 if packet
+// isn't MPLS packet or bottom of stack isn't found in packet.
+func (packet *Packet) MPLSStackLen() uint {
+	if packet.Ether.EtherType != SwapBytesUint16(MPLSNumber) {
+		return 0
+	}
+	length := packet.GetPacketLen()
+	for n := uint(1); EtherLen+n*MPLSLen <= length; n++ {
+		hdr := (*MPLSHdr)(unsafe.Pointer(uintptr(packet.unparsed()) + uintptr((n-1)*MPLSLen)))
+		if hdr.GetMPLSS() == 1 {
+			return n
+		}
+	}
+	return 0
+}
+
+// ParseL3SkipMPLS sets pointer to start of L3 header after the whole
+// MPLS label stack and returns number of labels. L3 is set right after
+// Ether header if packet isn't MPLS packet and to nil if bottom of
+// stack isn't found.
+func (packet *Packet) ParseL3SkipMPLS() uint {
+	if packet.Ether.EtherType != SwapBytesUint16(MPLSNumber) {
+		packet.L3 = packet.unparsed()
+		return 0
+	}
+	n := packet.MPLSStackLen()
+	if n == 0 {
+		packet.L3 = nil
+		return 0
+	}
+	packet.L3 = unsafe.Pointer(uintptr(packet.unparsed()) + uintptr(n*MPLSLen))
+	return n
+}
+
+// PushMPLS adds MPLS header with given label, traffic class and TTL on
+// top of label stack. Bottom-Of-Stack bit is set and EtherType is
+// changed to MPLS if packet isn't MPLS packet yet. Packet shouldn't
+// have VLAN tags. L3 and L4 pointers should be parsed again. Returns
+// false if packet can't be extended.
+func (packet *Packet) PushMPLS(label uint32, tc uint32, ttl uint8) bool {
+	var s uint32
+	if packet.Ether.EtherType != SwapBytesUint16(MPLSNumber) {
+		s = 1
+	}
+	return packet.AddMPLS(label<<12 | (tc&7)<<9 | s<<8 | uint32(ttl))
+}
+
+// PopMPLS removes MPLS header from top of label stack. If it was the
+// bottom of stack, EtherType is set to IPv4 or IPv6 according to
+// version of encapsulated packet. Returns false and doesn't change
+// packet if it isn't MPLS packet or it is the bottom of stack and
+// encapsulated packet isn't IPv4 or IPv6 packet. L3 and L4 pointers
+// should be parsed again.
+func (packet *Packet) PopMPLS() bool {
+	hdr := packet.GetMPLS()
+	if hdr == nil || packet.GetPacketLen() < EtherLen+MPLSLen {
+		return false
+	}
+	etherType := uint16(MPLSNumber)
+	if hdr.GetMPLSS() == 1 {
+		if packet.GetPacketLen() == EtherLen+MPLSLen {
+			return false
+		}
+		switch *(*uint8)(unsafe.Pointer(uintptr(unsafe.Pointer(hdr)) + MPLSLen)) >> 4 {
+		case 4:
+			etherType = IPV4Number
+		case 6:
+			etherType = IPV6Number
+		default:
+			return false
+		}
+	}
+	if !packet.RemoveMPLS() {
+		return false
+	}
+	packet.Ether.EtherType = SwapBytesUint16(etherType)
+	return true
+}
+
+// SwapMPLS replaces label of header on top of label stack and decreases
+// its TTL like label switching router does. Returns false if packet
+// isn't MPLS packet or TTL has expired and packet should be discarded.
+func (packet *Packet) SwapMPLS(label uint32) bool {
+	hdr := packet.GetMPLS()
+	if hdr == nil || hdr.GetMPLSTTL() <= 1 {
+		return false
+	}
+	hdr.SetMPLSLabel(label)
+	return hdr.DecreaseTTL()
+}
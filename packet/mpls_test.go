@@ -5,8 +5,11 @@
 package packet
 
 import (
+	"bytes"
 	"encoding/hex"
 	"testing"
+
+	"github.com/intel-go/nff-go/types"
 )
 
 func init() {
@@ -55,3 +58,59 @@ func internalTest(m *MPLSHdr, t *testing.T, label uint32, exp uint32, s uint32,
 		t.Errorf("Incorrect result:\ngot:  %d, \nwant: %d\n\n", SwapBytesUint32(m.mpls)&0x000000ff, ttl)
 	}
 }
+
+func TestMPLSStack(t *testing.T) {
+	pkt := getIPv4UDPTestPacket()
+	original := append([]byte(nil), pkt.GetRawPacketBytes()...)
+	if pkt.PopMPLS() {
+		t.Error("PopMPLS of IPv4 packet succeeded")
+	}
+	if pkt.ParseL3SkipMPLS() != 0 || pkt.L3 != pkt.unparsed() {
+		t.Error("Wrong L3 of packet without MPLS")
+	}
+	if !pkt.PushMPLS(100, 1, 64) || !pkt.PushMPLS(200, 2, 64) || !pkt.PushMPLS(300, 3, 64) {
+		t.Fatal("PushMPLS failed")
+	}
+	if pkt.GetMPLS() == nil || pkt.MPLSStackLen() != 3 {
+		t.Fatalf("Label stack has %d labels instead of 3", pkt.MPLSStackLen())
+	}
+	if pkt.ParseL3SkipMPLS() != 3 || pkt.GetIPv4NoCheck().VersionIhl != original[types.EtherLen] {
+		t.Error("ParseL3SkipMPLS didn't skip label stack")
+	}
+	internalTest(pkt.GetMPLSNoCheck(), t, 300, 3, 0, 64)
+	if !pkt.SwapMPLS(400) {
+		t.Fatal("SwapMPLS failed")
+	}
+	internalTest(pkt.GetMPLSNoCheck(), t, 400, 3, 0, 63)
+	pkt.GetMPLSNoCheck().SetMPLSTTL(1)
+	if pkt.SwapMPLS(500) {
+		t.Error("SwapMPLS with expired TTL succeeded")
+	}
+	for _, label := range []uint32{400, 200, 100} {
+		bottom := uint32(0)
+		if label == 100 {
+			bottom = 1
+		}
+		if m := pkt.GetMPLSNoCheck(); m.GetMPLSLabel() != label || m.GetMPLSS() != bottom {
+			t.Errorf("Wrong header %v on top of stack instead of label %d", m, label)
+		}
+		if !pkt.PopMPLS() {
+			t.Fatalf("PopMPLS of label %d failed", label)
+		}
+	}
+	if pkt.GetMPLS() != nil || !bytes.Equal(pkt.GetRawPacketBytes(), original) {
+		t.Errorf("Packet after PopMPLS\n%x\nis different from original\n%x", pkt.GetRawPacketBytes(), original)
+	}
+}
+
+func TestMPLSSetters(t *testing.T) {
+	var m MPLSHdr
+	m.SetMPLSLabel(0xabcde)
+	m.SetMPLSTC(5)
+	m.SetMPLSS(1)
+	m.SetMPLSTTL(255)
+	internalTest(&m, t, 0xabcde, 5, 1, 255)
+	m.SetMPLSTC(2)
+	m.SetMPLSS(0)
+	internalTest(&m, t, 0xabcde, 2, 0, 255)
+}
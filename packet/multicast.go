@@ -0,0 +1,233 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"sync"
+	"time"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Default time during which membership is kept without reports, it is
+// a group membership interval of IGMPv3 and MLDv2 with default timers.
+const multicastDefaultTimeout = 260 * time.Second
+
+// MulticastMembership tracks multicast listeners of ports from IGMP and
+// MLD reports. IPv4 groups and sources are kept as IPv4-mapped IPv6
+// addresses. Filter mode of group follows simplified rules: IGMPv1,
+// IGMPv2 and MLDv1 reports and exclude records join any source
+// multicast, include records and allowed sources join source specific
+// multicast, leave and done messages and empty include records remove
+// membership.
+type MulticastMembership struct {
+	sync.Mutex
+	timeout time.Duration
+	groups  map[types.IPv6Address]map[uint16]*multicastListener
+}
+
+type multicastListener struct {
+	// Listener accepts all sources except sources in exclude mode,
+	// only sources in include mode
+	exclude bool
+	// Expiration time of sources in include mode, blocked sources in
+	// exclude mode
+	sources map[types.IPv6Address]time.Time
+	// Expiration of exclude mode
+	expires time.Time
+}
+
+// NewMulticastMembership creates membership table with timeout after
+// which membership expires if it isn't refreshed by reports. Default
+// timeout of 260 seconds is used if timeout is zero.
+func NewMulticastMembership(timeout time.Duration) *MulticastMembership {
+	if timeout == 0 {
+		timeout = multicastDefaultTimeout
+	}
+	return &MulticastMembership{
+		timeout: timeout,
+		groups:  make(map[types.IPv6Address]map[uint16]*multicastListener),
+	}
+}
+
+// ipv4ToMapped returns IPv4-mapped IPv6 address.
+func ipv4ToMapped(addr types.IPv4Address) types.IPv6Address {
+	a := types.IPv4ToBytes(addr)
+	return types.IPv6Address{10: 0xff, 11: 0xff, 12: a[0], 13: a[1], 14: a[2], 15: a[3]}
+}
+
+// HandlePacket updates membership of port from IGMP or MLD report in
+// packet. L3 header should be parsed. Returns true if packet is IGMP or
+// MLD message, such packets shouldn't be forwarded as multicast data.
+func (m *MulticastMembership) HandlePacket(port uint16, packet *Packet, now time.Time) bool {
+	if data := packet.GetIGMP(); data != nil {
+		var msg IGMPMessage
+		if ParseIGMP(data, &msg) {
+			m.HandleIGMP(port, &msg, now)
+		}
+		return true
+	}
+	if data := packet.GetMLD(); data != nil {
+		var msg MLDMessage
+		if ParseMLD(data, &msg) {
+			m.HandleMLD(port, &msg, now)
+		}
+		return true
+	}
+	return false
+}
+
+// HandleIGMP updates membership of port from IGMP message. Queries are
+// ignored.
+func (m *MulticastMembership) HandleIGMP(port uint16, msg *IGMPMessage, now time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	switch msg.Type {
+	case IGMPTypeV1Report, IGMPTypeV2Report:
+		m.update(port, ipv4ToMapped(msg.Group), MulticastModeIsExclude, nil, now)
+	case IGMPTypeV2Leave:
+		m.remove(port, ipv4ToMapped(msg.Group))
+	case IGMPTypeV3Report:
+		for i := range msg.Records {
+			r := &msg.Records[i]
+			sources := make([]types.IPv6Address, len(r.Sources))
+			for j, s := range r.Sources {
+				sources[j] = ipv4ToMapped(s)
+			}
+			m.update(port, ipv4ToMapped(r.Group), r.Type, sources, now)
+		}
+	}
+}
+
+// HandleMLD updates membership of port from MLD message. Queries are
+// ignored.
+func (m *MulticastMembership) HandleMLD(port uint16, msg *MLDMessage, now time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	switch msg.Type {
+	case types.ICMPv6TypeMLDReport:
+		m.update(port, msg.Group, MulticastModeIsExclude, nil, now)
+	case types.ICMPv6TypeMLDDone:
+		m.remove(port, msg.Group)
+	case types.ICMPv6TypeMLDv2Report:
+		for i := range msg.Records {
+			r := &msg.Records[i]
+			m.update(port, r.Group, r.Type, r.Sources, now)
+		}
+	}
+}
+
+func (m *MulticastMembership) remove(port uint16, group types.IPv6Address) {
+	ports := m.groups[group]
+	delete(ports, port)
+	if len(ports) == 0 {
+		delete(m.groups, group)
+	}
+}
+
+// update applies group record of given type to listener of port.
+func (m *MulticastMembership) update(port uint16, group types.IPv6Address, typ uint8, sources []types.IPv6Address, now time.Time) {
+	if typ == 0 || typ > multicastMaxRecordType {
+		return
+	}
+	ports := m.groups[group]
+	l := ports[port]
+	expires := now.Add(m.timeout)
+	switch typ {
+	case MulticastModeIsExclude, MulticastChangeToExclude:
+		l = &multicastListener{
+			exclude: true,
+			sources: make(map[types.IPv6Address]time.Time),
+			expires: expires,
+		}
+		for _, s := range sources {
+			l.sources[s] = expires
+		}
+	case MulticastModeIsInclude, MulticastChangeToInclude, MulticastAllowNewSources:
+		if typ == MulticastChangeToInclude || l == nil || l.exclude && typ != MulticastAllowNewSources {
+			l = &multicastListener{sources: make(map[types.IPv6Address]time.Time)}
+		}
+		for _, s := range sources {
+			if l.exclude {
+				delete(l.sources, s)
+			} else {
+				l.sources[s] = expires
+			}
+		}
+		if !l.exclude && len(l.sources) == 0 {
+			m.remove(port, group)
+			return
+		}
+	case MulticastBlockOldSources:
+		if l == nil {
+			return
+		}
+		for _, s := range sources {
+			if l.exclude {
+				l.sources[s] = expires
+			} else {
+				delete(l.sources, s)
+			}
+		}
+		if !l.exclude && len(l.sources) == 0 {
+			m.remove(port, group)
+			return
+		}
+	}
+	if ports == nil {
+		ports = make(map[uint16]*multicastListener)
+		m.groups[group] = ports
+	}
+	ports[port] = l
+}
+
+// Ports appends to buf ports which have listeners of IPv6 group for
+// source and returns result.
+func (m *MulticastMembership) Ports(group, source types.IPv6Address, buf []uint16, now time.Time) []uint16 {
+	m.Lock()
+	defer m.Unlock()
+	for port, l := range m.groups[group] {
+		expires, listed := l.sources[source]
+		if l.exclude && !listed && now.Before(l.expires) ||
+			!l.exclude && listed && now.Before(expires) {
+			buf = append(buf, port)
+		}
+	}
+	return buf
+}
+
+// IPv4Ports appends to buf ports which have listeners of IPv4 group for
+// source and returns result.
+func (m *MulticastMembership) IPv4Ports(group, source types.IPv4Address, buf []uint16, now time.Time) []uint16 {
+	return m.Ports(ipv4ToMapped(group), ipv4ToMapped(source), buf, now)
+}
+
+// Expire removes expired listeners and sources, it should be called
+// periodically.
+func (m *MulticastMembership) Expire(now time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	for group, ports := range m.groups {
+		for port, l := range ports {
+			if l.exclude {
+				if !now.Before(l.expires) {
+					delete(ports, port)
+				}
+				continue
+			}
+			for s, expires := range l.sources {
+				if !now.Before(expires) {
+					delete(l.sources, s)
+				}
+			}
+			if len(l.sources) == 0 {
+				delete(ports, port)
+			}
+		}
+		if len(ports) == 0 {
+			delete(m.groups, group)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Router advertisement and prefix information flags
+const (
+	ICMPv6RAManagedFlag uint8 = 0x80
+	ICMPv6RAOtherFlag   uint8 = 0x40
+
+	ICMPv6NDPrefixOnLinkFlag     uint8 = 0x80
+	ICMPv6NDPrefixAutonomousFlag uint8 = 0x40
+
+	icmpv6NDMTUOptionSize = 8
+	// Maximum length of one option, length field counts 8 byte units
+	icmpv6NDMaxOptionSize = 0xff * ICMPv6NDMessageOptionUnitSize
+)
+
+var (
+	ipv6AllNodesMulticastAddr   = types.IPv6Address{0xff, 0x02, 14: 0x00, 15: 0x01}
+	ipv6AllRoutersMulticastAddr = types.IPv6Address{0xff, 0x02, 14: 0x00, 15: 0x02}
+
+	ICMPv6RouterAdvertisementMessageSize uint = uint(unsafe.Sizeof(ICMPv6RouterAdvertisementMessage{}))
+	ICMPv6NDPrefixInformationOptionSize  uint = uint(unsafe.Sizeof(ICMPv6NDPrefixInformationOption{}))
+)
+
+// ICMPv6RouterAdvertisementMessage follows ICMPv6 header of Router
+// Advertisement. Current hop limit and flags are kept in Identifier
+// field of ICMPHdr and router lifetime is kept in SeqNum field.
+type ICMPv6RouterAdvertisementMessage struct {
+	ReachableTime uint32
+	RetransTimer  uint32
+}
+
+// ICMPv6NDPrefix is a prefix information option of Router
+// Advertisement in host byte order.
+type ICMPv6NDPrefix struct {
+	Prefix            types.IPv6Address
+	Length            uint8
+	Flags             uint8
+	ValidLifetime     uint32
+	PreferredLifetime uint32
+}
+
+// ICMPv6RouterAdvertisement describes Router Advertisement message
+// which is built by InitICMPv6RouterAdvertisementPacket. Times are in
+// host byte order, MTU option is added if MTU isn't zero.
+type ICMPv6RouterAdvertisement struct {
+	CurHopLimit uint8
+	Flags       uint8
+	// Router lifetime in seconds, zero means that router isn't default
+	// router
+	RouterLifetime uint16
+	// Times in milliseconds
+	ReachableTime uint32
+	RetransTimer  uint32
+	MTU           uint32
+	Prefixes      []ICMPv6NDPrefix
+}
+
+// GetICMPv6RouterAdvertisementMessage returns pointer to ICMPv6 Router
+// Advertisement message buffer. It should be called after packet.Data
+// field is initialized with ParseL7 or ParseData calls.
+func (packet *Packet) GetICMPv6RouterAdvertisementMessage() *ICMPv6RouterAdvertisementMessage {
+	return (*ICMPv6RouterAdvertisementMessage)(packet.Data)
+}
+
+// ICMPv6NDOptions calls fn for every Neighbor Discovery option of
+// ICMPv6 message of length msgLength until fn returns false. Option is
+// a slice of packet which starts with type and length fields. Options
+// end at the end of IPv6 payload. L3, L4 and Data should be parsed for
+// IPv6 packet without extension headers. Returns false if option has
+// zero length or doesn't fit in packet.
+func (packet *Packet) ICMPv6NDOptions(msgLength uint, fn func(typ uint8, option []byte) bool) bool {
+	ipv6 := packet.GetIPv6NoCheck()
+	end := uintptr(packet.L3) + types.IPv6Len + uintptr(SwapBytesUint16(ipv6.PayloadLen))
+	if segmentEnd := uintptr(packet.StartAtOffset(uintptr(packet.GetPacketSegmentLen()))); end > segmentEnd {
+		end = segmentEnd
+	}
+	for offset := uintptr(msgLength); uintptr(packet.Data)+offset < end; {
+		if uintptr(packet.Data)+offset+2 > end {
+			return false
+		}
+		length := uintptr(*(*uint8)(unsafe.Pointer(uintptr(packet.Data) + offset + 1))) * ICMPv6NDMessageOptionUnitSize
+		if length == 0 || uintptr(packet.Data)+offset+length > end {
+			return false
+		}
+		option := (*[icmpv6NDMaxOptionSize]byte)(unsafe.Pointer(uintptr(packet.Data) + offset))[:length:length]
+		if !fn(option[0], option) {
+			return true
+		}
+		offset += length
+	}
+	return true
+}
+
+// ParseICMPv6NDLinkLayerAddress returns address of source or target
+// link-layer address option.
+func ParseICMPv6NDLinkLayerAddress(option []byte) (types.MACAddress, bool) {
+	var mac types.MACAddress
+	if len(option) < 2+types.EtherAddrLen ||
+		(option[0] != ICMPv6NDSourceLinkLayerAddress && option[0] != ICMPv6NDTargetLinkLayerAddress) {
+		return mac, false
+	}
+	copy(mac[:], option[2:])
+	return mac, true
+}
+
+// ParseICMPv6NDPrefix returns prefix of prefix information option.
+func ParseICMPv6NDPrefix(option []byte) (ICMPv6NDPrefix, bool) {
+	if uint(len(option)) < ICMPv6NDPrefixInformationOptionSize || option[0] != ICMPv6NDPrefixInformation {
+		return ICMPv6NDPrefix{}, false
+	}
+	opt := (*ICMPv6NDPrefixInformationOption)(unsafe.Pointer(&option[0]))
+	return ICMPv6NDPrefix{
+		Prefix:            opt.Prefix,
+		Length:            opt.PrefixLength,
+		Flags:             opt.LAFlags,
+		ValidLifetime:     SwapBytesUint32(opt.ValidLifetime),
+		PreferredLifetime: SwapBytesUint32(opt.PreferredLifetime),
+	}, true
+}
+
+// ParseICMPv6NDMTU returns MTU of MTU option.
+func ParseICMPv6NDMTU(option []byte) (uint32, bool) {
+	if len(option) < icmpv6NDMTUOptionSize || option[0] != ICMPv6NDMTU {
+		return 0, false
+	}
+	return SwapBytesUint32((*ICMPv6NDMTUOption)(unsafe.Pointer(&option[0])).MTU), true
+}
+
+// ParseICMPv6RouterAdvertisement parses Router Advertisement message
+// with its options. L3, L4 and Data should be parsed.
+func (packet *Packet) ParseICMPv6RouterAdvertisement() (ra ICMPv6RouterAdvertisement, source types.MACAddress, ok bool) {
+	icmp := packet.GetICMPNoCheck()
+	if icmp.Type != types.ICMPv6TypeRouterAdvertisement || !packet.checkEnoughSpace(ICMPv6RouterAdvertisementMessageSize-1) {
+		return ra, source, false
+	}
+	msg := packet.GetICMPv6RouterAdvertisementMessage()
+	identifier := SwapBytesUint16(icmp.Identifier)
+	ra = ICMPv6RouterAdvertisement{
+		CurHopLimit:    uint8(identifier >> 8),
+		Flags:          uint8(identifier),
+		RouterLifetime: SwapBytesUint16(icmp.SeqNum),
+		ReachableTime:  SwapBytesUint32(msg.ReachableTime),
+		RetransTimer:   SwapBytesUint32(msg.RetransTimer),
+	}
+	ok = packet.ICMPv6NDOptions(ICMPv6RouterAdvertisementMessageSize, func(typ uint8, option []byte) bool {
+		switch typ {
+		case ICMPv6NDSourceLinkLayerAddress:
+			source, _ = ParseICMPv6NDLinkLayerAddress(option)
+		case ICMPv6NDPrefixInformation:
+			if prefix, ok := ParseICMPv6NDPrefix(option); ok {
+				ra.Prefixes = append(ra.Prefixes, prefix)
+			}
+		case ICMPv6NDMTU:
+			ra.MTU, _ = ParseICMPv6NDMTU(option)
+		}
+		return true
+	})
+	return ra, source, ok
+}
+
+// InitICMPv6RouterSolicitationPacket allocates and initializes ICMPv6
+// Router Solicitation message packet to all routers multicast address
+// with source MAC and IPv6 address. Source link-layer address option
+// is added if source address is specified. ICMPv6 checksum is
+// calculated.
+func InitICMPv6RouterSolicitationPacket(packet *Packet, srcMAC types.MACAddress, srcIP types.IPv6Address) bool {
+	var optionsSize uint
+	if srcIP != (types.IPv6Address{}) {
+		optionsSize = ICMPv6NDSourceLinkLayerAddressOptionSize
+	}
+	if !InitEmptyIPv6ICMPPacket(packet, optionsSize) {
+		return false
+	}
+	CalculateIPv6BroadcastMACForDstMulticastIP(&packet.Ether.DAddr, ipv6AllRoutersMulticastAddr)
+	packet.Ether.SAddr = srcMAC
+	ipv6 := packet.GetIPv6NoCheck()
+	ipv6.DstAddr = ipv6AllRoutersMulticastAddr
+	ipv6.SrcAddr = srcIP
+	icmp := packet.GetICMPNoCheck()
+	icmp.Type = types.ICMPv6TypeRouterSolicitation
+	icmp.Identifier = 0
+	icmp.SeqNum = 0
+	packet.ParseL7(types.ICMPv6Number)
+	if optionsSize != 0 {
+		option := (*ICMPv6NDSourceLinkLayerAddressOption)(packet.Data)
+		option.Type = ICMPv6NDSourceLinkLayerAddress
+		option.Length = uint8(ICMPv6NDSourceLinkLayerAddressOptionSize / ICMPv6NDMessageOptionUnitSize)
+		option.LinkLayerAddress = srcMAC
+	}
+	icmp.Cksum = SwapBytesUint16(CalculateIPv6ICMPChecksum(ipv6, icmp, packet.Data))
+	return true
+}
+
+// InitICMPv6RouterAdvertisementPacket allocates and initializes ICMPv6
+// Router Advertisement message packet with source link-layer address,
+// MTU and prefix information options. Message is sent to all nodes
+// multicast address if dstIP is not specified, otherwise it is an
+// answer to solicitation of dstMAC and dstIP. ICMPv6 checksum is
+// calculated.
+func InitICMPv6RouterAdvertisementPacket(packet *Packet, srcMAC, dstMAC types.MACAddress, srcIP, dstIP types.IPv6Address, ra *ICMPv6RouterAdvertisement) bool {
+	size := ICMPv6RouterAdvertisementMessageSize + ICMPv6NDSourceLinkLayerAddressOptionSize +
+		uint(len(ra.Prefixes))*ICMPv6NDPrefixInformationOptionSize
+	if ra.MTU != 0 {
+		size += icmpv6NDMTUOptionSize
+	}
+	if !InitEmptyIPv6ICMPPacket(packet, size) {
+		return false
+	}
+	if dstIP == (types.IPv6Address{}) {
+		dstIP = ipv6AllNodesMulticastAddr
+		CalculateIPv6BroadcastMACForDstMulticastIP(&dstMAC, dstIP)
+	}
+	packet.Ether.DAddr = dstMAC
+	packet.Ether.SAddr = srcMAC
+	ipv6 := packet.GetIPv6NoCheck()
+	ipv6.DstAddr = dstIP
+	ipv6.SrcAddr = srcIP
+	icmp := packet.GetICMPNoCheck()
+	icmp.Type = types.ICMPv6TypeRouterAdvertisement
+	icmp.Identifier = SwapBytesUint16(uint16(ra.CurHopLimit)<<8 | uint16(ra.Flags))
+	icmp.SeqNum = SwapBytesUint16(ra.RouterLifetime)
+	packet.ParseL7(types.ICMPv6Number)
+	msg := packet.GetICMPv6RouterAdvertisementMessage()
+	msg.ReachableTime = SwapBytesUint32(ra.ReachableTime)
+	msg.RetransTimer = SwapBytesUint32(ra.RetransTimer)
+
+	offset := uintptr(ICMPv6RouterAdvertisementMessageSize)
+	source := (*ICMPv6NDSourceLinkLayerAddressOption)(unsafe.Pointer(uintptr(packet.Data) + offset))
+	source.Type = ICMPv6NDSourceLinkLayerAddress
+	source.Length = uint8(ICMPv6NDSourceLinkLayerAddressOptionSize / ICMPv6NDMessageOptionUnitSize)
+	source.LinkLayerAddress = srcMAC
+	offset += uintptr(ICMPv6NDSourceLinkLayerAddressOptionSize)
+	if ra.MTU != 0 {
+		mtu := (*ICMPv6NDMTUOption)(unsafe.Pointer(uintptr(packet.Data) + offset))
+		*mtu = ICMPv6NDMTUOption{
+			Type:   ICMPv6NDMTU,
+			Length: icmpv6NDMTUOptionSize / ICMPv6NDMessageOptionUnitSize,
+			MTU:    SwapBytesUint32(ra.MTU),
+		}
+		offset += icmpv6NDMTUOptionSize
+	}
+	for i := range ra.Prefixes {
+		p := &ra.Prefixes[i]
+		*(*ICMPv6NDPrefixInformationOption)(unsafe.Pointer(uintptr(packet.Data) + offset)) = ICMPv6NDPrefixInformationOption{
+			Type:              ICMPv6NDPrefixInformation,
+			Length:            uint8(ICMPv6NDPrefixInformationOptionSize / ICMPv6NDMessageOptionUnitSize),
+			PrefixLength:      p.Length,
+			LAFlags:           p.Flags,
+			ValidLifetime:     SwapBytesUint32(p.ValidLifetime),
+			PreferredLifetime: SwapBytesUint32(p.PreferredLifetime),
+			Prefix:            p.Prefix,
+		}
+		offset += uintptr(ICMPv6NDPrefixInformationOptionSize)
+	}
+	icmp.Cksum = SwapBytesUint16(CalculateIPv6ICMPChecksum(ipv6, icmp, packet.Data))
+	return true
+}
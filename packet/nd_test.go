@@ -0,0 +1,89 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func TestRouterAdvertisement(t *testing.T) {
+	srcMAC := types.MACAddress{0x02, 0, 0, 0, 0, 1}
+	srcIP := types.IPv6Address{0xfe, 0x80, 15: 1}
+	ra := ICMPv6RouterAdvertisement{
+		CurHopLimit:    64,
+		Flags:          ICMPv6RAOtherFlag,
+		RouterLifetime: 1800,
+		ReachableTime:  30000,
+		RetransTimer:   1000,
+		MTU:            1500,
+		Prefixes: []ICMPv6NDPrefix{
+			{Prefix: types.IPv6Address{0x20, 0x01, 0x0d, 0xb8}, Length: 64,
+				Flags: ICMPv6NDPrefixOnLinkFlag | ICMPv6NDPrefixAutonomousFlag, ValidLifetime: 86400, PreferredLifetime: 14400},
+			{Prefix: types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 0, 1}, Length: 48, ValidLifetime: 3600},
+		},
+	}
+	pkt := getPacket()
+	if !InitICMPv6RouterAdvertisementPacket(pkt, srcMAC, types.MACAddress{}, srcIP, types.IPv6Address{}, &ra) {
+		t.Fatal("InitICMPv6RouterAdvertisementPacket failed")
+	}
+	if pkt.Ether.DAddr != (types.MACAddress{0x33, 0x33, 0, 0, 0, 1}) || pkt.GetIPv6NoCheck().DstAddr != ipv6AllNodesMulticastAddr {
+		t.Errorf("Wrong destination %v %v", pkt.Ether.DAddr, pkt.GetIPv6NoCheck().DstAddr)
+	}
+	icmp := pkt.GetICMPNoCheck()
+	if SwapBytesUint16(icmp.Cksum) != CalculateIPv6ICMPChecksum(pkt.GetIPv6NoCheck(), icmp, pkt.Data) {
+		t.Error("Wrong checksum")
+	}
+	parsed, source, ok := pkt.ParseICMPv6RouterAdvertisement()
+	if !ok {
+		t.Fatal("ParseICMPv6RouterAdvertisement failed")
+	}
+	if source != srcMAC {
+		t.Errorf("Source link-layer address is %v", source)
+	}
+	if !reflect.DeepEqual(parsed, ra) {
+		t.Errorf("Parsed advertisement\n%+v\nis different from original\n%+v", parsed, ra)
+	}
+	// Option with zero length
+	*(*uint8)(unsafe.Pointer(uintptr(pkt.Data) + uintptr(ICMPv6RouterAdvertisementMessageSize) + 1)) = 0
+	if _, _, ok := pkt.ParseICMPv6RouterAdvertisement(); ok {
+		t.Error("Advertisement with option of zero length was parsed")
+	}
+}
+
+func TestRouterSolicitation(t *testing.T) {
+	srcMAC := types.MACAddress{0x02, 0, 0, 0, 0, 1}
+	pkt := getPacket()
+	if !InitICMPv6RouterSolicitationPacket(pkt, srcMAC, types.IPv6Address{0xfe, 0x80, 15: 1}) {
+		t.Fatal("InitICMPv6RouterSolicitationPacket failed")
+	}
+	if pkt.GetICMPNoCheck().Type != types.ICMPv6TypeRouterSolicitation || pkt.GetIPv6NoCheck().DstAddr != ipv6AllRoutersMulticastAddr {
+		t.Fatal("Wrong solicitation")
+	}
+	var options int
+	pkt.ICMPv6NDOptions(0, func(typ uint8, option []byte) bool {
+		options++
+		if mac, ok := ParseICMPv6NDLinkLayerAddress(option); !ok || mac != srcMAC {
+			t.Errorf("Wrong option %x", option)
+		}
+		return true
+	})
+	if options != 1 {
+		t.Errorf("Solicitation has %d options instead of 1", options)
+	}
+	// Unspecified source address doesn't have source link-layer address
+	pkt = getPacket()
+	InitICMPv6RouterSolicitationPacket(pkt, srcMAC, types.IPv6Address{})
+	if pkt.GetPacketLen() != types.EtherLen+types.IPv6Len+types.ICMPLen {
+		t.Errorf("Solicitation from unspecified address has length %d", pkt.GetPacketLen())
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"sync"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// NDProxy is a set of IPv6 addresses and subnets of hosts behind
+// interface. Neighbor table with proxy answers Neighbor Solicitations
+// for these addresses with its own MAC address as described in RFC
+// 4389, so application can route packets to proxied hosts without
+// translation. Proxy can be changed while it is used by neighbor
+// tables.
+type NDProxy struct {
+	lock      sync.RWMutex
+	addresses map[types.IPv6Address]bool
+	subnets   []types.IPv6Subnet
+}
+
+// NewNDProxy creates empty proxy.
+func NewNDProxy() *NDProxy {
+	return &NDProxy{addresses: make(map[types.IPv6Address]bool)}
+}
+
+// AddAddress adds single proxied address.
+func (p *NDProxy) AddAddress(addr types.IPv6Address) {
+	p.lock.Lock()
+	p.addresses[addr] = true
+	p.lock.Unlock()
+}
+
+// RemoveAddress removes address added by AddAddress.
+func (p *NDProxy) RemoveAddress(addr types.IPv6Address) {
+	p.lock.Lock()
+	delete(p.addresses, addr)
+	p.lock.Unlock()
+}
+
+// AddSubnet adds proxied subnet, for example delegated prefix.
+func (p *NDProxy) AddSubnet(subnet types.IPv6Subnet) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, sn := range p.subnets {
+		if sn == subnet {
+			return
+		}
+	}
+	p.subnets = append(p.subnets, subnet)
+}
+
+// RemoveSubnet removes subnet added by AddSubnet.
+func (p *NDProxy) RemoveSubnet(subnet types.IPv6Subnet) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, sn := range p.subnets {
+		if sn == subnet {
+			p.subnets = append(p.subnets[:i], p.subnets[i+1:]...)
+			return
+		}
+	}
+}
+
+// Contains returns true if address is proxied.
+func (p *NDProxy) Contains(addr types.IPv6Address) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.addresses[addr] {
+		return true
+	}
+	for i := range p.subnets {
+		if p.subnets[i].CheckIPv6AddressWithinSubnet(addr) {
+			return true
+		}
+	}
+	return false
+}
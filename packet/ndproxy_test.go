@@ -0,0 +1,50 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func parseIPv6(s string) types.IPv6Address {
+	var addr types.IPv6Address
+	copy(addr[:], net.ParseIP(s).To16())
+	return addr
+}
+
+func TestNDProxy(t *testing.T) {
+	proxy := NewNDProxy()
+	host := parseIPv6("2001:db8::10")
+	subnet := types.IPv6Subnet{
+		Addr: parseIPv6("2001:db8:1::"),
+		Mask: parseIPv6("ffff:ffff:ffff:ffff::"),
+	}
+	proxy.AddAddress(host)
+	proxy.AddSubnet(subnet)
+	proxy.AddSubnet(subnet)
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{"2001:db8::10", true},
+		{"2001:db8::11", false},
+		{"2001:db8:1::1", true},
+		{"2001:db8:1:0:ffff::1", true},
+		{"2001:db8:2::1", false},
+	}
+	for _, tt := range tests {
+		if got := proxy.Contains(parseIPv6(tt.addr)); got != tt.expected {
+			t.Errorf("Contains(%s) = %v, want %v", tt.addr, got, tt.expected)
+		}
+	}
+	proxy.RemoveAddress(host)
+	proxy.RemoveSubnet(subnet)
+	if proxy.Contains(host) || proxy.Contains(parseIPv6("2001:db8:1::1")) {
+		t.Error("Removed address or subnet is still proxied")
+	}
+}
@@ -7,7 +7,9 @@ package packet
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/intel-go/nff-go/common"
 	"github.com/intel-go/nff-go/types"
@@ -16,6 +18,11 @@ import (
 const (
 	arpRequestsRepeatInterval = 1 * time.Second
 	arpEntryCleanup = 60 * time.Second
+	// Packets waiting for resolution of address are dropped if
+	// address isn't resolved during this time
+	neighbourPendingTimeout = 3 * time.Second
+	// Maximum number of packets waiting for resolution of one address
+	neighbourMaxPendingPackets = 16
 )
 
 type NeighboursLookupTable struct {
@@ -29,11 +36,26 @@ type NeighboursLookupTable struct {
 	checkv4 func(ipv4 types.IPv4Address) bool
 	// Should return true if IPv6 address belongs to interface
 	checkv6 func(ipv6 types.IPv6Address) bool
+	// Addresses of hosts behind interface, accessed atomically
+	ndProxy unsafe.Pointer
+	// Queues of packets waiting for resolution of IPv4 or IPv6
+	// addresses
+	pending sync.Map
 }
 
 type neighboursLookupTableEntry struct {
 	MAC      types.MACAddress
 	LastUsed time.Time
+	// Static entries aren't aged, flushed or replaced by learned ones
+	Static bool
+}
+
+type neighboursPendingQueue struct {
+	sync.Mutex
+	started time.Time
+	packets []*Packet
+	// Queue is closed when address is resolved or queue is dropped
+	closed bool
 }
 
 func NewNeighbourTable(index uint16, mac types.MACAddress,
@@ -74,17 +96,149 @@ func NewTimeoutNeighbourTable(index uint16, mac types.MACAddress,
 }
 
 func (table *NeighboursLookupTable) cleanup() {
-	table.ipv4Table.Range(func(k interface{}, v interface{}) bool {
-		entry := v.(neighboursLookupTableEntry)
-		if time.Since(entry.LastUsed) >= arpEntryCleanup {
-			ipv4 := k.(types.IPv4Address)
-			table.ipv4Table.Delete(ipv4)
-			common.LogDebug(common.Debug, "Removed ARP Entry for", ipv4, ":", entry.MAC)
+	for _, m := range []*sync.Map{&table.ipv4Table, &table.ipv6Table} {
+		m.Range(func(k interface{}, v interface{}) bool {
+			entry := v.(neighboursLookupTableEntry)
+			if !entry.Static && time.Since(entry.LastUsed) >= arpEntryCleanup {
+				m.Delete(k)
+				common.LogDebug(common.Debug, "Removed neighbour entry for", k, ":", entry.MAC)
+			}
+			return true
+		})
+	}
+	table.pending.Range(func(k interface{}, v interface{}) bool {
+		q := v.(*neighboursPendingQueue)
+		q.Lock()
+		expired := time.Since(q.started) >= neighbourPendingTimeout
+		q.Unlock()
+		if expired {
+			table.dropPending(k)
 		}
 		return true
 	})
 }
 
+// Flush removes all learned IPv4 and IPv6 neighbours, pending requests
+// and packets waiting for them, for example when link of port goes
+// down and neighbours may have changed. Static neighbours are kept.
+func (table *NeighboursLookupTable) Flush() {
+	for _, m := range []*sync.Map{&table.ipv4Table, &table.ipv6Table} {
+		m.Range(func(k interface{}, v interface{}) bool {
+			if !v.(neighboursLookupTableEntry).Static {
+				m.Delete(k)
+			}
+			return true
+		})
+	}
+	for _, m := range []*sync.Map{&table.ipv4SentRequestTable, &table.ipv6SentRequestTable} {
+		m.Range(func(k interface{}, v interface{}) bool {
+			m.Delete(k)
+			return true
+		})
+	}
+	table.pending.Range(func(k interface{}, v interface{}) bool {
+		table.dropPending(k)
+		return true
+	})
+}
+
+// AddStaticIPv4Neighbour adds IPv4 neighbour which isn't aged, flushed
+// or replaced by ARP replies. Packets waiting for address are sent.
+func (table *NeighboursLookupTable) AddStaticIPv4Neighbour(ipv4 types.IPv4Address, mac types.MACAddress) {
+	table.ipv4Table.Store(ipv4, neighboursLookupTableEntry{MAC: mac, LastUsed: time.Now(), Static: true})
+	table.sendPending(ipv4, mac)
+}
+
+// AddStaticIPv6Neighbour adds IPv6 neighbour which isn't aged, flushed
+// or replaced by Neighbor Advertisements. Packets waiting for address
+// are sent.
+func (table *NeighboursLookupTable) AddStaticIPv6Neighbour(ipv6 types.IPv6Address, mac types.MACAddress) {
+	table.ipv6Table.Store(ipv6, neighboursLookupTableEntry{MAC: mac, LastUsed: time.Now(), Static: true})
+	table.sendPending(ipv6, mac)
+}
+
+// RemoveIPv4Neighbour removes static or learned IPv4 neighbour.
+func (table *NeighboursLookupTable) RemoveIPv4Neighbour(ipv4 types.IPv4Address) {
+	table.ipv4Table.Delete(ipv4)
+}
+
+// RemoveIPv6Neighbour removes static or learned IPv6 neighbour.
+func (table *NeighboursLookupTable) RemoveIPv6Neighbour(ipv6 types.IPv6Address) {
+	table.ipv6Table.Delete(ipv6)
+}
+
+// learn stores learned neighbour unless there is a static entry for
+// its address and sends packets waiting for address.
+func (table *NeighboursLookupTable) learn(m *sync.Map, addr interface{}, mac types.MACAddress) {
+	if v, found := m.Load(addr); found && v.(neighboursLookupTableEntry).Static {
+		return
+	}
+	m.Store(addr, neighboursLookupTableEntry{
+		MAC:      mac,
+		LastUsed: time.Now(),
+	})
+	common.LogDebug(common.Debug, "Added neighbour entry for", addr, ":", mac)
+	table.sendPending(addr, mac)
+}
+
+// enqueue keeps packet until address is resolved. Reference counter of
+// packet is incremented, so caller should free packet as usual.
+// Returns false if packet wasn't queued.
+func (table *NeighboursLookupTable) enqueue(addr interface{}, pkt *Packet) bool {
+	v, _ := table.pending.LoadOrStore(addr, &neighboursPendingQueue{started: time.Now()})
+	q := v.(*neighboursPendingQueue)
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return false
+	}
+	if time.Since(q.started) >= neighbourPendingTimeout {
+		// Address wasn't resolved in time, new packets wait for the
+		// next request
+		for _, p := range q.packets {
+			p.DecRefCount()
+		}
+		q.packets = q.packets[:0]
+		q.started = time.Now()
+	}
+	if len(q.packets) >= neighbourMaxPendingPackets {
+		return false
+	}
+	pkt.IncRefCount()
+	q.packets = append(q.packets, pkt)
+	return true
+}
+
+// takePending removes queue of address and returns its packets.
+func (table *NeighboursLookupTable) takePending(addr interface{}) []*Packet {
+	v, found := table.pending.Load(addr)
+	if !found {
+		return nil
+	}
+	table.pending.Delete(addr)
+	q := v.(*neighboursPendingQueue)
+	q.Lock()
+	defer q.Unlock()
+	q.closed = true
+	packets := q.packets
+	q.packets = nil
+	return packets
+}
+
+func (table *NeighboursLookupTable) sendPending(addr interface{}, mac types.MACAddress) {
+	for _, pkt := range table.takePending(addr) {
+		pkt.Ether.SAddr = table.interfaceMAC
+		pkt.Ether.DAddr = mac
+		pkt.SendPacket(table.portIndex)
+	}
+}
+
+func (table *NeighboursLookupTable) dropPending(addr interface{}) {
+	for _, pkt := range table.takePending(addr) {
+		pkt.DecRefCount()
+	}
+}
+
 // HandleIPv4ARPRequest processes IPv4 ARP request and reply packets
 // and sends an ARP response (if needed) to the same interface. Packet
 // has to have L3 parsed. If ARP request packet has VLAN tag, VLAN tag
@@ -95,13 +249,7 @@ func (table *NeighboursLookupTable) HandleIPv4ARPPacket(pkt *Packet) error {
 	if SwapBytesUint16(arp.Operation) != ARPRequest {
 		// Handle ARP reply and record information in lookup table
 		if SwapBytesUint16(arp.Operation) == ARPReply {
-			ipv4 := types.ArrayToIPv4(arp.SPA)
-			entry := neighboursLookupTableEntry {
-				MAC: arp.SHA,
-				LastUsed: time.Now(),
-			}
-			table.ipv4Table.Store(ipv4, entry)
-			common.LogDebug(common.Debug, "Added ARP Entry for", ipv4, ":", entry.MAC)
+			table.learn(&table.ipv4Table, types.ArrayToIPv4(arp.SPA), arp.SHA)
 		}
 		return nil
 	}
@@ -170,3 +318,160 @@ func (table *NeighboursLookupTable) SendARPRequestForIPv4(ipv4, myIPv4Address ty
 	requestPacket.SendPacket(table.portIndex)
 	table.ipv4SentRequestTable.Store(ipv4, time.Now())
 }
+
+// ResolveIPv4 fills L2 addresses of packet sent to IPv4 neighbour.
+// If MAC address of neighbour is unknown, packet waits for ARP reply
+// and ARP request is sent as SendARPRequestForIPv4 does. Waiting
+// packet has incremented reference counter, so caller should drop
+// packet as usual if false is returned. Packet is sent to port of
+// table when reply is received and dropped if reply isn't received
+// in 3 seconds. At most 16 packets can wait for one address. Tables
+// without cleanup interval drop expired packets when the next packet
+// waits for the same address.
+func (table *NeighboursLookupTable) ResolveIPv4(pkt *Packet, ipv4, myIPv4Address types.IPv4Address, vlan uint16) bool {
+	if mac, found := table.LookupMACForIPv4(ipv4); found {
+		pkt.Ether.SAddr = table.interfaceMAC
+		pkt.Ether.DAddr = mac
+		return true
+	}
+	table.enqueue(ipv4, pkt)
+	table.SendARPRequestForIPv4(ipv4, myIPv4Address, vlan)
+	return false
+}
+
+// SetNDProxy sets addresses for which table answers IPv6 Neighbor
+// Solicitations in addition to addresses which belong to interface.
+// Nil proxy disables proxying.
+func (table *NeighboursLookupTable) SetNDProxy(proxy *NDProxy) {
+	atomic.StorePointer(&table.ndProxy, unsafe.Pointer(proxy))
+}
+
+func (table *NeighboursLookupTable) getNDProxy() *NDProxy {
+	return (*NDProxy)(atomic.LoadPointer(&table.ndProxy))
+}
+
+// HandleIPv6NeighborDiscoveryPacket processes IPv6 Neighbor
+// Solicitation and Neighbor Advertisement packets and sends a Neighbor
+// Advertisement (if needed) to the same interface. Packet has to have
+// L3 parsed. Solicitations for addresses of proxy set by SetNDProxy are
+// answered without override flag, so that advertisements of hosts
+// themselves take precedence. Returns error for other packets. If
+// packet has VLAN tag, VLAN tag is copied into reply packet.
+func (table *NeighboursLookupTable) HandleIPv6NeighborDiscoveryPacket(pkt *Packet) error {
+	ipv6 := pkt.GetIPv6()
+	if ipv6 == nil || ipv6.Proto != types.ICMPv6Number {
+		return fmt.Errorf("Packet is not ICMPv6")
+	}
+	pkt.ParseL4ForIPv6()
+	icmp := pkt.GetICMPForIPv6()
+	pkt.ParseL7(types.ICMPv6Number)
+
+	switch icmp.Type {
+	case types.ICMPv6NeighborAdvertisement:
+		msg := pkt.GetICMPv6NeighborAdvertisementMessage()
+		// Target link-layer address option may be omitted in
+		// answers to unicast solicitations
+		mac := pkt.Ether.SAddr
+		option := pkt.GetICMPv6NDTargetLinkLayerAddressOption(ICMPv6NeighborAdvertisementMessageSize)
+		if option != nil && option.Type == ICMPv6NDTargetLinkLayerAddress {
+			mac = option.LinkLayerAddress
+		}
+		table.learn(&table.ipv6Table, msg.TargetAddr, mac)
+		return nil
+	case types.ICMPv6NeighborSolicitation:
+	default:
+		return fmt.Errorf("ICMPv6 packet of type %d is not Neighbor Discovery", icmp.Type)
+	}
+
+	msg := pkt.GetICMPv6NeighborSolicitationMessage()
+	target := msg.TargetAddr
+	override := table.checkv6(target)
+	if !override {
+		if proxy := table.getNDProxy(); proxy == nil || !proxy.Contains(target) {
+			return fmt.Errorf("Warning! Got Neighbor Solicitation for IPv6 address %s which doesn't belong to interface. Request ignored.", target)
+		}
+	}
+	if ipv6.SrcAddr == (types.IPv6Address{}) {
+		// Duplicate address detection, address isn't assigned yet
+		return nil
+	}
+	// Requester MAC can be used to answer its packets
+	table.learn(&table.ipv6Table, ipv6.SrcAddr, pkt.Ether.SAddr)
+
+	answerPacket, err := NewPacket()
+	if err != nil {
+		return err
+	}
+	InitICMPv6NeighborAdvertisementPacket(answerPacket, table.interfaceMAC, pkt.Ether.SAddr, target, ipv6.SrcAddr)
+	answerICMP := answerPacket.GetICMPNoCheck()
+	if !override {
+		answerICMP.Identifier = SwapBytesUint16(ICMPv6NDSolicitedFlag)
+	}
+	answerICMP.Cksum = SwapBytesUint16(CalculateIPv6ICMPChecksum(answerPacket.GetIPv6NoCheck(), answerICMP, answerPacket.Data))
+	vlan := pkt.GetVLAN()
+	if vlan != nil {
+		answerPacket.AddVLANTag(SwapBytesUint16(vlan.TCI))
+	}
+
+	answerPacket.SendPacket(table.portIndex)
+	return nil
+}
+
+// LookupMACForIPv6 tries to find MAC address for specified IPv6
+// address.
+func (table *NeighboursLookupTable) LookupMACForIPv6(ipv6 types.IPv6Address) (types.MACAddress, bool) {
+	v, found := table.ipv6Table.Load(ipv6)
+	if found {
+		entry := v.(neighboursLookupTableEntry)
+		entry.LastUsed = time.Now()
+		table.ipv6Table.Store(ipv6, entry)
+		return entry.MAC, true
+	}
+	return [types.EtherAddrLen]byte{}, false
+}
+
+// SendNeighborSolicitationForIPv6 sends Neighbor Solicitation for
+// specified IPv6 address. If specified vlan tag is not zero, request
+// packet gets VLAN tag assigned to it.
+func (table *NeighboursLookupTable) SendNeighborSolicitationForIPv6(ipv6, myIPv6Address types.IPv6Address, vlan uint16) {
+	v, found := table.ipv6SentRequestTable.Load(ipv6)
+	if found {
+		lastsent := v.(time.Time)
+		if time.Since(lastsent) < arpRequestsRepeatInterval {
+			// Another request has been sent recently, we're still
+			// waiting for reply
+			return
+		}
+	}
+
+	requestPacket, err := NewPacket()
+	if err != nil {
+		common.LogFatal(common.Debug, err)
+	}
+
+	InitICMPv6NeighborSolicitationPacket(requestPacket, table.interfaceMAC, myIPv6Address, ipv6)
+	icmp := requestPacket.GetICMPNoCheck()
+	icmp.Cksum = SwapBytesUint16(CalculateIPv6ICMPChecksum(requestPacket.GetIPv6NoCheck(), icmp, requestPacket.Data))
+
+	if vlan != 0 {
+		requestPacket.AddVLANTag(vlan)
+	}
+
+	requestPacket.SendPacket(table.portIndex)
+	table.ipv6SentRequestTable.Store(ipv6, time.Now())
+}
+
+// ResolveIPv6 fills L2 addresses of packet sent to IPv6 neighbour.
+// If MAC address of neighbour is unknown, packet waits for Neighbor
+// Advertisement and Neighbor Solicitation is sent as
+// SendNeighborSolicitationForIPv6 does. Packets wait as in ResolveIPv4.
+func (table *NeighboursLookupTable) ResolveIPv6(pkt *Packet, ipv6, myIPv6Address types.IPv6Address, vlan uint16) bool {
+	if mac, found := table.LookupMACForIPv6(ipv6); found {
+		pkt.Ether.SAddr = table.interfaceMAC
+		pkt.Ether.DAddr = mac
+		return true
+	}
+	table.enqueue(ipv6, pkt)
+	table.SendNeighborSolicitationForIPv6(ipv6, myIPv6Address, vlan)
+	return false
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func TestNeighbourTableStaticEntries(t *testing.T) {
+	table := NewNeighbourTable(0, types.MACAddress{0x02, 0, 0, 0, 0, 0x01}, nil, nil)
+	static := types.BytesToIPv4(192, 0, 2, 1)
+	learned := types.BytesToIPv4(192, 0, 2, 2)
+	staticMAC := types.MACAddress{0x02, 0, 0, 0, 0, 0x02}
+	learnedMAC := types.MACAddress{0x02, 0, 0, 0, 0, 0x03}
+	staticIPv6 := types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 0x01}
+
+	table.AddStaticIPv4Neighbour(static, staticMAC)
+	table.AddStaticIPv6Neighbour(staticIPv6, staticMAC)
+	table.learn(&table.ipv4Table, learned, learnedMAC)
+	// Learned address doesn't replace static one
+	table.learn(&table.ipv4Table, static, learnedMAC)
+	if mac, found := table.LookupMACForIPv4(static); !found || mac != staticMAC {
+		t.Errorf("Static neighbour has MAC %v", mac)
+	}
+	if mac, found := table.LookupMACForIPv4(learned); !found || mac != learnedMAC {
+		t.Errorf("Learned neighbour has MAC %v", mac)
+	}
+
+	table.Flush()
+	if _, found := table.LookupMACForIPv4(learned); found {
+		t.Error("Learned neighbour wasn't flushed")
+	}
+	if _, found := table.LookupMACForIPv4(static); !found {
+		t.Error("Static IPv4 neighbour was flushed")
+	}
+	if _, found := table.LookupMACForIPv6(staticIPv6); !found {
+		t.Error("Static IPv6 neighbour was flushed")
+	}
+	table.RemoveIPv4Neighbour(static)
+	if _, found := table.LookupMACForIPv4(static); found {
+		t.Error("Static neighbour wasn't removed")
+	}
+}
+
+func TestNeighbourTablePendingPackets(t *testing.T) {
+	table := NewNeighbourTable(0, types.MACAddress{0x02, 0, 0, 0, 0, 0x01}, nil, nil)
+	addr := types.BytesToIPv4(192, 0, 2, 1)
+	pkt := getPacket()
+	for i := 0; i < neighbourMaxPendingPackets; i++ {
+		if !table.enqueue(addr, pkt) {
+			t.Fatalf("Packet %d wasn't queued", i)
+		}
+	}
+	if table.enqueue(addr, pkt) {
+		t.Error("Queue exceeded maximum number of packets")
+	}
+	if pkt.RefCount() != neighbourMaxPendingPackets+1 {
+		t.Errorf("Reference counter of queued packet is %d", pkt.RefCount())
+	}
+	table.Flush()
+	if pkt.RefCount() != 1 {
+		t.Errorf("Reference counter of flushed packet is %d", pkt.RefCount())
+	}
+	if packets := table.takePending(addr); len(packets) != 0 {
+		t.Errorf("%d packets are still pending", len(packets))
+	}
+}
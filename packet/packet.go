@@ -51,7 +51,7 @@ import (
 )
 
 var mbufStructSize uintptr
-var hwtxchecksum bool
+var hwtxchecksum, hwtxtunnelchecksum bool
 var nonPerfMempool *low.Mempool
 
 func init() {
@@ -59,11 +59,34 @@ func init() {
 	mbufStructSize = unsafe.Sizeof(t1)
 	var t2 Packet
 	packetStructSize := unsafe.Sizeof(t2)
-	if err := low.SetPacketStructSize(int(packetStructSize)); err != nil {
+	if err := low.SetPacketStructSize(int(packetStructSize), int(maxPushLen())); err != nil {
 		LogFatal(Debug, err)
 	}
 }
 
+// maxPushLen returns the largest number of bytes which helpers with
+// fixed size headers prepend to packet in one call. Headroom after
+// Packet structure should fit all of them. Helpers with variable
+// length headers, like GTP-U extensions, Geneve options or
+// EncapsulateHead, return false if headroom is not enough.
+func maxPushLen() uint {
+	lens := []uint{
+		2 * types.VLANLen, // AddQinQTags
+		types.MPLSLen,     // PushMPLS
+		types.IPv4MinLen + types.GRELen + greFieldLen,                      // EncapsulateIPv4GRE
+		types.IPv4MinLen + types.UDPLen + types.GTPMinLen + gtpOptionalLen, // EncapsulateIPv4GTPU
+		types.EtherLen + types.IPv4MinLen + types.UDPLen + types.GeneveLen, // EncapsulateIPv4Geneve
+		types.EtherLen + types.IPv4MinLen + erspanGRELen + erspanIIILen,    // EncapsulateERSPAN
+	}
+	max := uint(0)
+	for _, l := range lens {
+		if l > max {
+			max = l
+		}
+	}
+	return max
+}
+
 // TODO Add function to write user data after headers and set "data" field
 
 // The following structures must be consistent with these C duplications
@@ -87,11 +110,13 @@ Ethernet Destination: %s
 
 var (
 	etherTypeNameLookupTable = map[uint16]string{
-		types.SwapIPV4Number: "IPv4",
-		types.SwapARPNumber:  "ARP",
-		types.SwapVLANNumber: "VLAN",
-		types.SwapMPLSNumber: "MPLS",
-		types.SwapIPV6Number: "IPv6",
+		types.SwapIPV4Number:  "IPv4",
+		types.SwapARPNumber:   "ARP",
+		types.SwapVLANNumber:  "VLAN",
+		types.SwapMPLSNumber:  "MPLS",
+		types.SwapIPV6Number:  "IPv6",
+		types.SwapSVLANNumber: "S-VLAN",
+		types.SwapLLDPNumber:  "LLDP",
 	}
 )
 
@@ -204,6 +229,11 @@ func (hdr *ICMPHdr) String() string {
 // Parsing means to fill required header pointers with corresponding headers. For example,
 // after user fills IPv4 pointer to right place inside packet he can use its fields like
 // packet.IPv4.SrcAddr or packet.IPv4.DstAddr.
+//
+// Packet structure with its metadata occupies the first 64 bytes of
+// mbuf headroom, so with default RTE_PKTMBUF_HEADROOM of 128 bytes only
+// 64 bytes are left for headers which are prepended by EncapsulateHead
+// and tunnel, MPLS and VLAN push helpers.
 type Packet struct {
 	L3   unsafe.Pointer // Pointer to L3 header in mbuf
 	L4   unsafe.Pointer // Pointer to L4 header in mbuf
@@ -215,6 +245,10 @@ type Packet struct {
 	CMbuf *low.Mbuf // Private pointer to mbuf. Users shouldn't know anything about mbuf
 
 	Next *Packet // non nil if packet consists of several chained mbufs
+
+	// Values of metadata fields. Bit mask of set fields is cleared
+	// inside low.c file when packet is received or allocated.
+	meta metadata
 }
 
 func (packet *Packet) unparsed() unsafe.Pointer {
@@ -449,6 +483,11 @@ func SetHWTXChecksumFlag(flag bool) {
 	hwtxchecksum = flag
 }
 
+// SetHWTXTunnelChecksumFlag should not be exported but it is used in flow.
+func SetHWTXTunnelChecksumFlag(flag bool) {
+	hwtxtunnelchecksum = flag
+}
+
 // ExtractPackets creates vector of packets by calling ExtractPacket function
 // is unexported, used in flow package
 func ExtractPackets(packet []*Packet, IN []uintptr, n uint) {
@@ -463,13 +502,36 @@ func (p *Packet) ToUintptr() uintptr {
 }
 
 // GeneratePacketFromByte function gets non-initialized packet and slice of bytes of any size.
-// Initializes input packet and fills it with these bytes.
+// Initializes input packet and fills it with these bytes. Bytes which don't fit
+// into one mbuf are placed into new chained segments.
 func GeneratePacketFromByte(packet *Packet, data []byte) bool {
-	if low.AppendMbuf(packet.CMbuf, uint(len(data))) == false {
+	first := uint(len(data))
+	if tailroom := low.GetTailroomMbuf(packet.CMbuf); first > tailroom {
+		first = tailroom
+	}
+	if low.AppendMbuf(packet.CMbuf, first) == false {
 		LogWarning(Debug, "GeneratePacketFromByte: Cannot append mbuf")
 		return false
 	}
-	low.WriteDataToMbuf(packet.CMbuf, data)
+	low.WriteDataToMbuf(packet.CMbuf, data[:first])
+	for data = data[first:]; len(data) != 0; {
+		segment, err := NewPacket()
+		if err != nil {
+			LogWarning(Debug, "GeneratePacketFromByte: Cannot allocate new segment")
+			return false
+		}
+		length := uint(len(data))
+		if tailroom := low.GetTailroomMbuf(segment.CMbuf); length > tailroom {
+			length = tailroom
+		}
+		low.AppendMbuf(segment.CMbuf, length)
+		low.WriteDataToMbuf(segment.CMbuf, data[:length])
+		segment.Data = unsafe.Pointer(segment.Ether)
+		if !packet.chainSegment(segment) {
+			return false
+		}
+		data = data[length:]
+	}
 	return true
 }
 
@@ -494,6 +556,9 @@ func InitEmptyPacket(packet *Packet, plSize uint) bool {
 // new packet is attached to Next pointer of prev packet
 // Return new packet or nil if error
 // Function is not performance efficient due to use of single packet allocation
+// Length and number of segments are updated only in prev packet, so prev
+// should be the first segment. AppendSegment should be used to add
+// segments to longer chains.
 func InitNextPacket(plSize uint, prev *Packet) *Packet {
 	packet, err := NewPacket()
 	if err != nil || low.AppendMbuf(packet.CMbuf, plSize) == false {
@@ -501,11 +566,52 @@ func InitNextPacket(plSize uint, prev *Packet) *Packet {
 		return nil
 	}
 	packet.Data = unsafe.Pointer(packet.Ether)
-	prev.Next = packet
-	low.SetNextMbuf(packet.CMbuf, prev.CMbuf)
+	if !prev.chainSegment(packet) {
+		return nil
+	}
 	return packet
 }
 
+// AppendSegment creates new segment with plSize bytes and attaches it
+// to the end of chained packet. Packet should be the first segment,
+// its length and number of segments are updated. Data pointer of new
+// segment is set to its beginning. Returns new segment or nil if error.
+// Function is not performance efficient due to use of single packet
+// allocation.
+func (packet *Packet) AppendSegment(plSize uint) *Packet {
+	segment, err := NewPacket()
+	if err != nil {
+		LogWarning(Debug, "AppendSegment: Cannot allocate new segment")
+		return nil
+	}
+	if low.AppendMbuf(segment.CMbuf, plSize) == false {
+		LogWarning(Debug, "AppendSegment: Cannot append mbuf")
+		low.DirectStop(1, []uintptr{segment.ToUintptr()})
+		return nil
+	}
+	segment.Data = unsafe.Pointer(segment.Ether)
+	if !packet.chainSegment(segment) {
+		return nil
+	}
+	return segment
+}
+
+// chainSegment attaches segment to the end of chained packet. Segment
+// is freed if packet has too many segments.
+func (packet *Packet) chainSegment(segment *Packet) bool {
+	if !low.ChainMbuf(packet.CMbuf, segment.CMbuf) {
+		LogWarning(Debug, "Packet has too many segments")
+		low.DirectStop(1, []uintptr{segment.ToUintptr()})
+		return false
+	}
+	last := packet
+	for last.Next != nil {
+		last = last.Next
+	}
+	last.Next = segment
+	return true
+}
+
 func fillIPv4Default(packet *Packet, plLen uint16, nextProto uint8) {
 	packet.GetIPv4NoCheck().VersionIhl = types.IPv4VersionIhl
 	packet.GetIPv4NoCheck().TotalLength = SwapBytesUint16(plLen)
@@ -724,10 +830,79 @@ func SwapBytesIPv4Addr(x types.IPv4Address) types.IPv4Address {
 }
 
 // GetRawPacketBytes returns all bytes from this packet. Not zero-copy.
+// Bytes of all segments are copied if packet is chained.
 func (packet *Packet) GetRawPacketBytes() []byte {
+	if packet.Next == nil {
+		return low.GetRawPacketBytesMbuf(packet.CMbuf)
+	}
+	data := make([]byte, 0, packet.GetPacketLen())
+	for segment := packet; segment != nil; segment = segment.Next {
+		data = append(data, low.GetRawPacketBytesMbuf(segment.CMbuf)...)
+	}
+	return data
+}
+
+// GetSegmentBytes returns bytes of this segment of packet. Zero-copy.
+func (packet *Packet) GetSegmentBytes() []byte {
 	return low.GetRawPacketBytesMbuf(packet.CMbuf)
 }
 
+// GetSegmentsNumber returns number of segments of packet. Packet should
+// be the first segment.
+func (packet *Packet) GetSegmentsNumber() uint {
+	return low.GetSegsNumberMbuf(packet.CMbuf)
+}
+
+// ReadBytes returns len(buf) bytes of packet starting at offset. If
+// these bytes are in one segment, slice of packet is returned, otherwise
+// bytes are copied from segments to buf. Returns nil if packet is
+// shorter than offset+len(buf).
+func (packet *Packet) ReadBytes(offset uint, buf []byte) []byte {
+	length := uint(len(buf))
+	if offset+length > packet.GetPacketLen() {
+		return nil
+	}
+	segment := packet
+	data := segment.GetSegmentBytes()
+	for offset >= uint(len(data)) && segment.Next != nil {
+		offset -= uint(len(data))
+		segment = segment.Next
+		data = segment.GetSegmentBytes()
+	}
+	if offset+length <= uint(len(data)) {
+		return data[offset : offset+length : offset+length]
+	}
+	for n := 0; ; {
+		n += copy(buf[n:], data[offset:])
+		if n == len(buf) || segment.Next == nil {
+			return buf
+		}
+		segment = segment.Next
+		data = segment.GetSegmentBytes()
+		offset = 0
+	}
+}
+
+// WriteBytes copies data to packet starting at offset, data can span
+// several segments. Returns false if packet is shorter than
+// offset+len(data).
+func (packet *Packet) WriteBytes(offset uint, data []byte) bool {
+	if offset+uint(len(data)) > packet.GetPacketLen() {
+		return false
+	}
+	for segment := packet; segment != nil && len(data) != 0; segment = segment.Next {
+		bytes := segment.GetSegmentBytes()
+		if offset >= uint(len(bytes)) {
+			offset -= uint(len(bytes))
+			continue
+		}
+		n := copy(bytes[offset:], data)
+		data = data[n:]
+		offset = 0
+	}
+	return true
+}
+
 // GetPacketLen returns length of this packet. Sum of length of all segments if scattered.
 func (packet *Packet) GetPacketLen() uint {
 	return low.GetPktLenMbuf(packet.CMbuf)
@@ -750,13 +925,55 @@ func (packet *Packet) GetPacketPayload() ([]byte, bool) {
 	return pktBytes[hdrsLen:], true
 }
 
+// GetL4Bytes returns L4 header and payload of IPv4 or IPv6 packet as
+// slice of packet memory. Zero-copy. L4 should be parsed. Slice ends
+// at the end of L3 packet, so Ethernet padding isn't included.
+// Returns false if L4 isn't parsed or isn't inside L3 packet or L3
+// packet isn't in the first segment.
+func (packet *Packet) GetL4Bytes() ([]byte, bool) {
+	return packet.l3PacketBytes(packet.L4)
+}
+
+// GetL7Bytes returns payload of IPv4 or IPv6 packet after L4 header
+// as slice of packet memory. Zero-copy. L7 should be parsed by ParseL7
+// or ParseData. Returns false like GetL4Bytes.
+func (packet *Packet) GetL7Bytes() ([]byte, bool) {
+	return packet.l3PacketBytes(packet.Data)
+}
+
+// l3PacketBytes returns bytes of the first segment from start to the
+// end of IPv4 or IPv6 packet. Length of L3 packet is validated against
+// data length of segment.
+func (packet *Packet) l3PacketBytes(start unsafe.Pointer) ([]byte, bool) {
+	if start == nil || packet.L3 == nil {
+		return nil, false
+	}
+	var end uint
+	b := packet.GetSegmentBytes()
+	ether := uintptr(unsafe.Pointer(packet.Ether))
+	l3 := uint(uintptr(packet.L3) - ether)
+	if ipv4 := packet.GetIPv4CheckVLAN(); ipv4 != nil && l3+types.IPv4MinLen <= uint(len(b)) {
+		end = l3 + uint(SwapBytesUint16(ipv4.TotalLength))
+	} else if ipv6 := packet.GetIPv6CheckVLAN(); ipv6 != nil && l3+types.IPv6Len <= uint(len(b)) {
+		end = l3 + types.IPv6Len + uint(SwapBytesUint16(ipv6.PayloadLen))
+	} else {
+		return nil, false
+	}
+	// Subtraction wraps if start is before L3 header
+	begin := uint(uintptr(start) - ether)
+	if begin < l3 || begin > end || end > uint(len(b)) {
+		return nil, false
+	}
+	return b[begin:end:end], true
+}
+
 // EncapsulateHead adds bytes to packet. start - number of beginning byte, length - number of
 // added bytes. This function should be used to add bytes to the first half
 // of packet. Return false if error.
 // You must not add NoPacketHeadChange option to SystemInit for using this function safely.
-// TODO change this for scattered packet case (multiple mbufs)
+// Bytes before start should be in the first segment of chained packet.
 func (packet *Packet) EncapsulateHead(start uint, length uint) bool {
-	if low.PrependMbuf(packet.CMbuf, length) == false {
+	if start > packet.GetPacketSegmentLen() || low.PrependMbuf(packet.CMbuf, length) == false {
 		return false
 	}
 	packet.Ether = (*EtherHdr)(unsafe.Pointer(uintptr(unsafe.Pointer(packet.Ether)) - uintptr(length)))
@@ -769,9 +986,9 @@ func (packet *Packet) EncapsulateHead(start uint, length uint) bool {
 // EncapsulateTail adds bytes to packet. start - number of beginning byte, length - number of
 // added bytes. This function should be used to add bytes to the second half
 // of packet. Return false if error.
-// TODO change this for scattered packet case (multiple mbufs)
+// Returns false for chained packets.
 func (packet *Packet) EncapsulateTail(start uint, length uint) bool {
-	if low.AppendMbuf(packet.CMbuf, length) == false {
+	if packet.Next != nil || low.AppendMbuf(packet.CMbuf, length) == false {
 		return false
 	}
 	packetLength := packet.GetPacketLen()
@@ -785,9 +1002,10 @@ func (packet *Packet) EncapsulateTail(start uint, length uint) bool {
 // removed bytes. This function should be used to remove bytes from the first half
 // of packet. Return false if error.
 // You must not add NoPacketHeadChange option to SystemInit for using this function safely.
-// TODO change this for scattered packet case (multiple mbufs)
+// Removed bytes and bytes before them should be in the first segment of
+// chained packet.
 func (packet *Packet) DecapsulateHead(start uint, length uint) bool {
-	if low.AdjMbuf(packet.CMbuf, length) == false {
+	if start+length > packet.GetPacketSegmentLen() || low.AdjMbuf(packet.CMbuf, length) == false {
 		return false
 	}
 	for i := int(start - 1); i >= 0; i-- {
@@ -800,10 +1018,10 @@ func (packet *Packet) DecapsulateHead(start uint, length uint) bool {
 // DecapsulateTail removes bytes from packet. start - number of beginning byte, length - number of
 // removed bytes. This function should be used to remove bytes from the second half
 // of packet. Return false if error.
-// TODO change this for scattered packet case (multiple mbufs)
+// Returns false for chained packets.
 func (packet *Packet) DecapsulateTail(start uint, length uint) bool {
 	packetLength := packet.GetPacketLen() // This won't be changed by next operation
-	if low.TrimMbuf(packet.CMbuf, length) == false {
+	if packet.Next != nil || low.TrimMbuf(packet.CMbuf, length) == false {
 		return false
 	}
 	for i := start; i < packetLength; i++ {
@@ -815,14 +1033,7 @@ func (packet *Packet) DecapsulateTail(start uint, length uint) bool {
 // PacketBytesChange changes packet bytes from start byte to given bytes.
 // Return false if error.
 func (packet *Packet) PacketBytesChange(start uint, bytes []byte) bool {
-	length := uint(len(bytes))
-	if start+length > packet.GetPacketLen() {
-		return false
-	}
-	for i := uint(0); i < length; i++ {
-		*(*byte)(packet.StartAtOffset(uintptr(start + i))) = bytes[i]
-	}
-	return true
+	return packet.WriteBytes(start, bytes)
 }
 
 // NewPacket shouldn't be used for performance critical allocations.
@@ -838,6 +1049,47 @@ func NewPacket() (*Packet, error) {
 	return pkt, nil
 }
 
+// Clone returns new packet which shares data with packet without
+// copying it, so packet can be sent to several destinations. Data is
+// freed when packet and all its clones are freed or sent. Changes of
+// data are visible in all clones, so packet which is modified after
+// cloning should be copied instead. Headers of packet should not be
+// added or removed. Parsed header pointers and metadata are copied to
+// clone.
+func (p *Packet) Clone() (*Packet, error) {
+	mb := low.CloneMbuf(p.CMbuf, nonPerfMempool)
+	if mb == 0 {
+		return nil, WrapWithNFError(nil, "Cannot clone packet: mempool is empty", AllocMbufErr)
+	}
+	clone := ExtractPacket(mb)
+	clone.L3 = p.L3
+	clone.L4 = p.L4
+	clone.Data = p.Data
+	clone.meta = p.meta
+	return clone, nil
+}
+
+// IncRefCount increments reference counter of packet, so packet
+// should be freed or sent one more time before it returns to mempool.
+// It allows to send the same packet to several destinations without
+// clones if packet isn't changed.
+func (p *Packet) IncRefCount() {
+	low.RefcntUpdateMbuf(p.CMbuf, 1)
+}
+
+// DecRefCount decrements reference counter of packet. Packet is freed
+// if counter becomes zero, so packet shouldn't be used after call
+// unless its counter was incremented before.
+func (p *Packet) DecRefCount() {
+	low.DirectStop(1, []uintptr{p.ToUintptr()})
+}
+
+// RefCount returns reference counter of packet. Packet is shared and
+// shouldn't be changed if counter is bigger than one.
+func (p *Packet) RefCount() uint16 {
+	return low.GetRefcntMbuf(p.CMbuf)
+}
+
 // SendPacket immediately sends packet to specified port via calling C function.
 // Packet is freed. Function return true if packet was actually sent.
 // Port should be initialized. Packet is sent to zero queue (is always present).
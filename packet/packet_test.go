@@ -652,3 +652,143 @@ type plTest struct {
 	payload string
 	status  bool
 }
+
+func TestGetL4L7Bytes(t *testing.T) {
+	// IPv4 UDP DNS query from TestGetPacketPayload
+	header, _ := hex.DecodeString("00015c31bbc1d48564a7bfa308004500003e17210000801100001806addc44574cb6ed9b0035002a572b")
+	payload, _ := hex.DecodeString("dac301000001000000000000037777770866616365626f6f6b03636f6d0000010001")
+	// Ethernet padding is not a part of payload
+	padding := make([]byte, 4)
+
+	pkt := getPacket()
+	GeneratePacketFromByte(pkt, append(append(append([]byte(nil), header...), payload...), padding...))
+	if pkt.ParseData() != 0 {
+		t.Fatal("ParseData failed")
+	}
+	l4, ok := pkt.GetL4Bytes()
+	if !ok || !bytes.Equal(l4[types.UDPLen:], payload) || uintptr(unsafe.Pointer(&l4[0])) != uintptr(pkt.L4) {
+		t.Errorf("GetL4Bytes returned %x, %v", l4, ok)
+	}
+	l7, ok := pkt.GetL7Bytes()
+	if !ok || !bytes.Equal(l7, payload) || cap(l7) != len(payload) {
+		t.Errorf("GetL7Bytes returned %x, %v", l7, ok)
+	}
+
+	// IPv4 length exceeds length of packet
+	pkt = getPacket()
+	GeneratePacketFromByte(pkt, append(append([]byte(nil), header...), payload[:len(payload)-1]...))
+	pkt.ParseData()
+	if l7, ok := pkt.GetL7Bytes(); ok {
+		t.Errorf("GetL7Bytes returned %x for truncated packet", l7)
+	}
+}
+
+func TestChainedPacket(t *testing.T) {
+	data := make([]byte, 5000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	pkt := getPacket()
+	if !GeneratePacketFromByte(pkt, data) {
+		t.Fatal("GeneratePacketFromByte failed")
+	}
+	if pkt.Next == nil || pkt.GetSegmentsNumber() < 2 {
+		t.Fatalf("Packet has %d segments", pkt.GetSegmentsNumber())
+	}
+	if pkt.GetPacketLen() != uint(len(data)) || !bytes.Equal(pkt.GetRawPacketBytes(), data) {
+		t.Fatal("Chained packet is different from original data")
+	}
+	segments, length := uint(0), uint(0)
+	for segment := pkt; segment != nil; segment = segment.Next {
+		segments++
+		length += uint(len(segment.GetSegmentBytes()))
+	}
+	if segments != pkt.GetSegmentsNumber() || length != pkt.GetPacketLen() {
+		t.Errorf("Segments have %d bytes in %d segments", length, segments)
+	}
+	// Bytes on the boundary of the first and the second segments
+	first := pkt.GetPacketSegmentLen()
+	buf := make([]byte, 8)
+	if got := pkt.ReadBytes(first-4, buf); !bytes.Equal(got, data[first-4:first+4]) {
+		t.Errorf("ReadBytes returned %x instead of %x", got, data[first-4:first+4])
+	}
+	if got := pkt.ReadBytes(first+4, buf); !bytes.Equal(got, data[first+4:first+12]) {
+		t.Errorf("ReadBytes returned %x instead of %x", got, data[first+4:first+12])
+	}
+	if pkt.ReadBytes(uint(len(data))-4, buf) != nil {
+		t.Error("ReadBytes read bytes after the end of packet")
+	}
+	if !pkt.WriteBytes(first-2, []byte{0xaa, 0xbb, 0xcc, 0xdd}) {
+		t.Fatal("WriteBytes failed")
+	}
+	copy(data[first-2:], []byte{0xaa, 0xbb, 0xcc, 0xdd})
+	if !bytes.Equal(pkt.GetRawPacketBytes(), data) {
+		t.Error("WriteBytes wrote wrong bytes")
+	}
+	if pkt.EncapsulateTail(0, 4) || pkt.DecapsulateTail(0, 4) {
+		t.Error("Tail of chained packet was changed")
+	}
+	if pkt.AppendSegment(100) == nil || pkt.GetPacketLen() != uint(len(data))+100 {
+		t.Errorf("Length after AppendSegment is %d", pkt.GetPacketLen())
+	}
+}
+
+func TestClonePacket(t *testing.T) {
+	pkt := getIPv4UDPTestPacket()
+	pkt.ParseL3()
+	clone, err := pkt.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clone.GetRawPacketBytes(), pkt.GetRawPacketBytes()) || clone.L3 != pkt.L3 {
+		t.Error("Clone is different from original packet")
+	}
+	if pkt.RefCount() != 2 || clone.RefCount() != 2 {
+		t.Errorf("Reference counters are %d and %d instead of 2", pkt.RefCount(), clone.RefCount())
+	}
+	// Data is shared
+	pkt.Ether.DAddr = types.MACAddress{1, 2, 3, 4, 5, 6}
+	if clone.Ether.DAddr != pkt.Ether.DAddr {
+		t.Error("Clone doesn't share data with original packet")
+	}
+	clone.DecRefCount()
+	if pkt.RefCount() != 1 {
+		t.Errorf("Reference counter is %d after clone is freed", pkt.RefCount())
+	}
+	pkt.IncRefCount()
+	if pkt.RefCount() != 2 {
+		t.Errorf("Reference counter is %d after increment", pkt.RefCount())
+	}
+	pkt.DecRefCount()
+	if pkt.RefCount() != 1 {
+		t.Errorf("Reference counter is %d after decrement", pkt.RefCount())
+	}
+}
+
+func TestCloneChainedPacket(t *testing.T) {
+	data := make([]byte, 5000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	pkt := getPacket()
+	if !GeneratePacketFromByte(pkt, data) || pkt.Next == nil {
+		t.Fatal("Chained packet wasn't generated")
+	}
+	clone, err := pkt.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.GetSegmentsNumber() != pkt.GetSegmentsNumber() || !bytes.Equal(clone.GetRawPacketBytes(), data) {
+		t.Fatal("Clone is different from original packet")
+	}
+	// Following segments start with data
+	for segment, original := clone.Next, pkt.Next; segment != nil; segment, original = segment.Next, original.Next {
+		if segment.Data != unsafe.Pointer(segment.Ether) || segment.Ether != original.Ether {
+			t.Error("Segment of clone doesn't point to data of original segment")
+		}
+	}
+	clone.DecRefCount()
+	if pkt.RefCount() != 1 {
+		t.Errorf("Reference counter is %d after clone is freed", pkt.RefCount())
+	}
+}
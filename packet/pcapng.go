@@ -0,0 +1,153 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/internal/low"
+)
+
+// Block types of pcapng format
+const (
+	pcapngSectionHeader  uint32 = 0x0a0d0d0a
+	pcapngInterfaceDesc  uint32 = 1
+	pcapngEnhancedPacket uint32 = 6
+	pcapngByteOrderMagic uint32 = 0x1a2b3c4d
+)
+
+// Codes of pcapng options
+const (
+	PcapngOptEnd          uint16 = 0
+	PcapngOptComment      uint16 = 1
+	PcapngOptCustomString uint16 = 2988
+	PcapngOptCustomBinary uint16 = 2989
+
+	pcapngOptIfName    uint16 = 2
+	pcapngOptIfTsresol uint16 = 9
+)
+
+// PcapngOption is an option of pcapng block. Value is padded to 32
+// bits when option is written.
+type PcapngOption struct {
+	Code  uint16
+	Value []byte
+}
+
+// PcapngComment returns comment option which is shown by packet
+// analyzers with packet.
+func PcapngComment(comment string) PcapngOption {
+	return PcapngOption{Code: PcapngOptComment, Value: []byte(comment)}
+}
+
+// PcapngCustomString returns custom option with UTF-8 string. pen is
+// IANA Private Enterprise Number of organization which defines
+// meaning of option.
+func PcapngCustomString(pen uint32, value string) PcapngOption {
+	v := make([]byte, 4, 4+len(value))
+	binary.LittleEndian.PutUint32(v, pen)
+	return PcapngOption{Code: PcapngOptCustomString, Value: append(v, value...)}
+}
+
+func pad32(n int) int {
+	return (n + 3) &^ 3
+}
+
+func optionsLen(options []PcapngOption) int {
+	if len(options) == 0 {
+		return 0
+	}
+	// The end of options takes 4 bytes
+	n := 4
+	for _, o := range options {
+		n += 4 + pad32(len(o.Value))
+	}
+	return n
+}
+
+// writePcapngBlock writes block with given body and options. Body
+// should be padded to 32 bits.
+func writePcapngBlock(f io.Writer, blockType uint32, body []byte, options []PcapngOption) error {
+	total := 12 + len(body) + optionsLen(options)
+	b := make([]byte, 0, total)
+	b = appendUint32(b, blockType)
+	b = appendUint32(b, uint32(total))
+	b = append(b, body...)
+	for _, o := range options {
+		b = appendUint16(b, o.Code)
+		b = appendUint16(b, uint16(len(o.Value)))
+		b = append(b, o.Value...)
+		b = append(b, make([]byte, pad32(len(o.Value))-len(o.Value))...)
+	}
+	if len(options) != 0 {
+		b = appendUint32(b, uint32(PcapngOptEnd))
+	}
+	b = appendUint32(b, uint32(total))
+	if _, err := f.Write(b); err != nil {
+		return common.WrapWithNFError(err, "write pcapng block failed", common.PcapWriteFail)
+	}
+	return nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// WritePcapngHdr writes pcapng section header and descriptions of
+// Ethernet interfaces with given names. Interfaces are referred by
+// their indexes in names when packets are written. Packet timestamps
+// have nanosecond resolution.
+func WritePcapngHdr(f io.Writer, names ...string) error {
+	shb := appendUint32(nil, pcapngByteOrderMagic)
+	// Version 1.0 and unknown section length
+	shb = appendUint16(shb, 1)
+	shb = appendUint16(shb, 0)
+	shb = append(shb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	if err := writePcapngBlock(f, pcapngSectionHeader, shb, nil); err != nil {
+		return err
+	}
+	for _, name := range names {
+		// Ethernet link type and snapshot length
+		idb := appendUint16(nil, 1)
+		idb = appendUint16(idb, 0)
+		idb = appendUint32(idb, 65535)
+		options := []PcapngOption{
+			{Code: pcapngOptIfName, Value: []byte(name)},
+			{Code: pcapngOptIfTsresol, Value: []byte{9}},
+		}
+		if err := writePcapngBlock(f, pcapngInterfaceDesc, idb, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WritePcapngPacketBytes writes packet data captured on interface
+// iface with given options. Assumes pcapng header is already present
+// in file.
+func WritePcapngPacketBytes(f io.Writer, iface uint32, data []byte, options ...PcapngOption) error {
+	ts := uint64(now().UnixNano())
+	epb := make([]byte, 0, 20+pad32(len(data)))
+	epb = appendUint32(epb, iface)
+	epb = appendUint32(epb, uint32(ts>>32))
+	epb = appendUint32(epb, uint32(ts))
+	epb = appendUint32(epb, uint32(len(data)))
+	epb = appendUint32(epb, uint32(len(data)))
+	epb = append(epb, data...)
+	epb = append(epb, make([]byte, pad32(len(data))-len(data))...)
+	return writePcapngBlock(f, pcapngEnhancedPacket, epb, options)
+}
+
+// WritePcapngOnePacket writes packet captured on interface iface with
+// given options. Assumes pcapng header is already present in file.
+func (pkt *Packet) WritePcapngOnePacket(f io.Writer, iface uint32, options ...PcapngOption) error {
+	return WritePcapngPacketBytes(f, iface, low.GetRawPacketBytesMbuf(pkt.CMbuf), options...)
+}
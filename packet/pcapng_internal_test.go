@@ -0,0 +1,83 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type pcapngBlock struct {
+	blockType uint32
+	body      []byte
+}
+
+// readPcapngBlocks splits pcapng data to blocks and checks that both
+// lengths of every block are equal.
+func readPcapngBlocks(t *testing.T, data []byte) []pcapngBlock {
+	var blocks []pcapngBlock
+	for len(data) != 0 {
+		if len(data) < 12 {
+			t.Fatalf("Truncated block: %x", data)
+		}
+		total := binary.LittleEndian.Uint32(data[4:])
+		if total%4 != 0 || int(total) > len(data) {
+			t.Fatalf("Wrong block length %d", total)
+		}
+		if trailer := binary.LittleEndian.Uint32(data[total-4:]); trailer != total {
+			t.Fatalf("Block lengths differ: %d and %d", total, trailer)
+		}
+		blocks = append(blocks, pcapngBlock{binary.LittleEndian.Uint32(data), data[8 : total-4]})
+		data = data[total:]
+	}
+	return blocks
+}
+
+func TestWritePcapng(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	if err := WritePcapngHdr(buffer, "private", "public"); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte{1, 2, 3, 4, 5}
+	if err := WritePcapngPacketBytes(buffer, 1, data, PcapngCustomString(343, "session=7")); err != nil {
+		t.Fatal(err)
+	}
+	blocks := readPcapngBlocks(t, buffer.Bytes())
+	if len(blocks) != 4 {
+		t.Fatalf("Got %d blocks, want 4", len(blocks))
+	}
+	if blocks[0].blockType != pcapngSectionHeader || binary.LittleEndian.Uint32(blocks[0].body) != pcapngByteOrderMagic {
+		t.Errorf("Wrong section header: %x", blocks[0].body)
+	}
+	wantIDB := []byte{1, 0, 0, 0, 0xff, 0xff, 0, 0,
+		2, 0, 6, 0, 'p', 'u', 'b', 'l', 'i', 'c', 0, 0,
+		9, 0, 1, 0, 9, 0, 0, 0,
+		0, 0, 0, 0}
+	if blocks[2].blockType != pcapngInterfaceDesc || !bytes.Equal(blocks[2].body, wantIDB) {
+		t.Errorf("Wrong interface description:\ngot:  %x\nwant: %x", blocks[2].body, wantIDB)
+	}
+	ts := uint64(fixedTime.UnixNano())
+	epb := blocks[3]
+	if epb.blockType != pcapngEnhancedPacket {
+		t.Fatalf("Wrong block type %d", epb.blockType)
+	}
+	b := epb.body
+	if iface := binary.LittleEndian.Uint32(b); iface != 1 {
+		t.Errorf("Wrong interface %d", iface)
+	}
+	if got := uint64(binary.LittleEndian.Uint32(b[4:]))<<32 | uint64(binary.LittleEndian.Uint32(b[8:])); got != ts {
+		t.Errorf("Wrong timestamp %d, want %d", got, ts)
+	}
+	if !bytes.Equal(b[20:25], data) || binary.LittleEndian.Uint32(b[12:]) != 5 {
+		t.Errorf("Wrong packet data: %x", b[12:28])
+	}
+	wantOptions := []byte{0xac, 0x0b, 13, 0, 0x57, 0x01, 0, 0,
+		's', 'e', 's', 's', 'i', 'o', 'n', '=', '7', 0, 0, 0,
+		0, 0, 0, 0}
+	if !bytes.Equal(b[28:], wantOptions) {
+		t.Errorf("Wrong options:\ngot:  %x\nwant: %x", b[28:], wantOptions)
+	}
+}
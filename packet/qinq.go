@@ -0,0 +1,97 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"unsafe"
+
+	. "github.com/intel-go/nff-go/types"
+)
+
+// 802.1ad (QinQ) frames have outer service tag with TPID 0x88a8 in
+// EtherHdr.EtherType and optional inner customer tag with TPID 0x8100
+// in EtherType of outer VLANHdr. Both tags are represented by VLANHdr.
+
+// GetSVLAN returns outer 802.1ad service VLAN header if it is present
+// in the packet.
+func (packet *Packet) GetSVLAN() *VLANHdr {
+	if packet.Ether.EtherType == SwapBytesUint16(SVLANNumber) {
+		return (*VLANHdr)(packet.unparsed())
+	}
+	return nil
+}
+
+// GetCVLAN returns 802.1Q customer VLAN header if it is present in the
+// packet either inside service tag or as the only tag.
+func (packet *Packet) GetCVLAN() *VLANHdr {
+	switch packet.Ether.EtherType {
+	case SwapBytesUint16(VLANNumber):
+		return (*VLANHdr)(packet.unparsed())
+	case SwapBytesUint16(SVLANNumber):
+		if (*VLANHdr)(packet.unparsed()).EtherType == SwapBytesUint16(VLANNumber) {
+			return (*VLANHdr)(unsafe.Pointer(uintptr(packet.unparsed()) + VLANLen))
+		}
+	}
+	return nil
+}
+
+// GetEtherTypeQinQ returns EtherType of frame after service and
+// customer VLAN tags.
+func (packet *Packet) GetEtherTypeQinQ() uint16 {
+	if hdr := packet.GetCVLAN(); hdr != nil {
+		return SwapBytesUint16(hdr.EtherType)
+	}
+	if hdr := packet.GetSVLAN(); hdr != nil {
+		return SwapBytesUint16(hdr.EtherType)
+	}
+	return SwapBytesUint16(packet.Ether.EtherType)
+}
+
+// ParseL3CheckQinQ sets pointer to start of L3 header taking possible
+// presence of service and customer VLAN tags into account. Returns
+// headers of tags which are present. GetIPv4CheckVLAN and similar
+// functions shouldn't be used after it, EtherType should be checked
+// with GetEtherTypeQinQ.
+func (packet *Packet) ParseL3CheckQinQ() (svlan, cvlan *VLANHdr) {
+	svlan = packet.GetSVLAN()
+	cvlan = packet.GetCVLAN()
+	packet.L3 = packet.unparsed()
+	if svlan != nil {
+		packet.L3 = unsafe.Pointer(uintptr(packet.L3) + VLANLen)
+	}
+	if cvlan != nil {
+		packet.L3 = unsafe.Pointer(uintptr(packet.L3) + VLANLen)
+	}
+	return svlan, cvlan
+}
+
+// AddSVLANTag increases size of packet on VLANLen and adds 802.1ad
+// service VLAN header after Ether header before existing tags, tag is a
+// tag control information. Returns false if error.
+func (packet *Packet) AddSVLANTag(tag uint16) bool {
+	if !packet.EncapsulateHead(EtherLen-2, VLANLen) {
+		return false
+	}
+	// Previous EtherType has moved to service VLAN header
+	packet.Ether.EtherType = SwapBytesUint16(SVLANNumber)
+	(*VLANHdr)(packet.unparsed()).TCI = SwapBytesUint16(tag)
+	return true
+}
+
+// AddQinQTags adds customer VLAN header with ctag and service VLAN
+// header with stag. Returns false if error.
+func (packet *Packet) AddQinQTags(stag, ctag uint16) bool {
+	return packet.AddVLANTag(ctag) && packet.AddSVLANTag(stag)
+}
+
+// RemoveSVLANTag decreases size of packet on VLANLen removing outer
+// service VLAN header, customer VLAN header becomes the outer one.
+// Returns false if packet doesn't have service tag or error.
+func (packet *Packet) RemoveSVLANTag() bool {
+	if packet.GetSVLAN() == nil {
+		return false
+	}
+	return packet.DecapsulateHead(EtherLen-2, VLANLen)
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func TestQinQTags(t *testing.T) {
+	pkt := getIPv4UDPTestPacket()
+	original := append([]byte(nil), pkt.GetRawPacketBytes()...)
+	if pkt.GetSVLAN() != nil || pkt.GetCVLAN() != nil || pkt.RemoveSVLANTag() {
+		t.Fatal("Untagged packet has VLAN tags")
+	}
+	if !pkt.AddQinQTags(100, 200) {
+		t.Fatal("AddQinQTags failed")
+	}
+	if SwapBytesUint16(pkt.Ether.EtherType) != types.SVLANNumber {
+		t.Errorf("Outer EtherType is 0x%04x", SwapBytesUint16(pkt.Ether.EtherType))
+	}
+	svlan, cvlan := pkt.ParseL3CheckQinQ()
+	if svlan == nil || cvlan == nil || svlan.GetVLANTagIdentifier() != 100 || cvlan.GetVLANTagIdentifier() != 200 {
+		t.Fatalf("Wrong tags %v and %v", svlan, cvlan)
+	}
+	if pkt.GetEtherTypeQinQ() != types.IPV4Number || pkt.GetIPv4NoCheck().VersionIhl != original[types.EtherLen] {
+		t.Error("ParseL3CheckQinQ didn't skip tags")
+	}
+	// Service tag isn't mistaken for customer tag
+	if pkt.GetVLAN() != nil {
+		t.Error("GetVLAN returned service tag")
+	}
+	if !pkt.RemoveSVLANTag() {
+		t.Fatal("RemoveSVLANTag failed")
+	}
+	if pkt.GetSVLAN() != nil || pkt.GetCVLAN() == nil || pkt.GetCVLAN().GetVLANTagIdentifier() != 200 {
+		t.Error("Customer tag isn't the outer tag after removal of service tag")
+	}
+	if pkt.RemoveSVLANTag() {
+		t.Error("Customer tag was removed by RemoveSVLANTag")
+	}
+	if !pkt.RemoveVLANTag() || !bytes.Equal(pkt.GetRawPacketBytes(), original) {
+		t.Errorf("Packet without tags\n%x\nis different from original\n%x", pkt.GetRawPacketBytes(), original)
+	}
+	// Service tag without customer tag
+	if !pkt.AddSVLANTag(300) {
+		t.Fatal("AddSVLANTag failed")
+	}
+	svlan, cvlan = pkt.ParseL3CheckQinQ()
+	if svlan == nil || cvlan != nil || pkt.GetEtherTypeQinQ() != types.IPV4Number {
+		t.Errorf("Wrong tags %v and %v of single tagged packet", svlan, cvlan)
+	}
+}
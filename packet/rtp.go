@@ -0,0 +1,249 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// RTPHdr is a fixed header of RTP packet (RFC 3550). It is followed by
+// CSRC list, optional header extension and payload.
+type RTPHdr struct {
+	VPXCC     uint8  // Version (2 bits), padding and extension flags, CSRC count (4 bits)
+	MPT       uint8  // Marker flag and payload type (7 bits)
+	SeqNum    uint16 // Sequence number
+	Timestamp uint32
+	SSRC      uint32 // Synchronization source identifier
+}
+
+// RTCPHdr is a common header of RTCP packet (RFC 3550) with SSRC of
+// sender which is present in all standard packet types. Compound RTCP
+// packet consists of several such packets.
+type RTCPHdr struct {
+	VPRC       uint8 // Version (2 bits), padding flag and reception report or subtype count (5 bits)
+	PacketType uint8
+	Length     uint16 // Length of packet in 4 byte words minus one
+	SSRC       uint32
+}
+
+// RTP and RTCP constants
+const (
+	RTPVersion = 2
+
+	RTPFlagPadding   = 0x20
+	RTPFlagExtension = 0x10
+	RTPFlagMarker    = 0x80
+
+	RTCPTypeSR    = 200 // Sender report
+	RTCPTypeRR    = 201 // Receiver report
+	RTCPTypeSDES  = 202 // Source description
+	RTCPTypeBYE   = 203
+	RTCPTypeAPP   = 204
+	RTCPTypeRTPFB = 205 // Transport layer feedback
+	RTCPTypePSFB  = 206 // Payload specific feedback
+	RTCPTypeXR    = 207 // Extended report
+
+	RTPHdrLen  = 12
+	RTCPHdrLen = 8
+
+	rtpCSRCLen   = 4
+	rtpExtHdrLen = 4
+	// Range of the second byte of RTCP packets multiplexed with RTP
+	// packets (RFC 5761)
+	rtcpMinType = 192
+	rtcpMaxType = 223
+)
+
+func (hdr *RTPHdr) String() string {
+	return fmt.Sprintf("RTP: version = %d, payload type = %d, marker = %t, sequence number = %d, timestamp = %d, SSRC = 0x%08x, CSRC count = %d",
+		hdr.Version(), hdr.PayloadType(), hdr.Marker(), hdr.GetSeqNum(), hdr.GetTimestamp(), hdr.GetSSRC(), hdr.CSRCCount())
+}
+
+// Version returns RTP version, it should be 2.
+func (hdr *RTPHdr) Version() uint8 {
+	return hdr.VPXCC >> 6
+}
+
+// Padding returns true if payload is followed by padding.
+func (hdr *RTPHdr) Padding() bool {
+	return hdr.VPXCC&RTPFlagPadding != 0
+}
+
+// Extension returns true if header extension follows CSRC list.
+func (hdr *RTPHdr) Extension() bool {
+	return hdr.VPXCC&RTPFlagExtension != 0
+}
+
+// CSRCCount returns number of contributing sources.
+func (hdr *RTPHdr) CSRCCount() uint {
+	return uint(hdr.VPXCC & 0x0f)
+}
+
+// Marker returns marker flag.
+func (hdr *RTPHdr) Marker() bool {
+	return hdr.MPT&RTPFlagMarker != 0
+}
+
+// PayloadType returns payload type.
+func (hdr *RTPHdr) PayloadType() uint8 {
+	return hdr.MPT &^ RTPFlagMarker
+}
+
+// GetSeqNum returns sequence number.
+func (hdr *RTPHdr) GetSeqNum() uint16 {
+	return SwapBytesUint16(hdr.SeqNum)
+}
+
+// SetSeqNum sets sequence number.
+func (hdr *RTPHdr) SetSeqNum(seq uint16) {
+	hdr.SeqNum = SwapBytesUint16(seq)
+}
+
+// GetTimestamp returns timestamp.
+func (hdr *RTPHdr) GetTimestamp() uint32 {
+	return SwapBytesUint32(hdr.Timestamp)
+}
+
+// SetTimestamp sets timestamp.
+func (hdr *RTPHdr) SetTimestamp(ts uint32) {
+	hdr.Timestamp = SwapBytesUint32(ts)
+}
+
+// GetSSRC returns synchronization source identifier.
+func (hdr *RTPHdr) GetSSRC() uint32 {
+	return SwapBytesUint32(hdr.SSRC)
+}
+
+// SetSSRC sets synchronization source identifier.
+func (hdr *RTPHdr) SetSSRC(ssrc uint32) {
+	hdr.SSRC = SwapBytesUint32(ssrc)
+}
+
+// GetCSRC returns contributing source identifier with index i which
+// should be less than CSRCCount.
+func (hdr *RTPHdr) GetCSRC(i uint) uint32 {
+	return SwapBytesUint32(*(*uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(hdr)) + RTPHdrLen + uintptr(i)*rtpCSRCLen)))
+}
+
+func (hdr *RTCPHdr) String() string {
+	return fmt.Sprintf("RTCP: version = %d, packet type = %d, count = %d, length = %d, SSRC = 0x%08x",
+		hdr.Version(), hdr.PacketType, hdr.Count(), hdr.PacketLen(), hdr.GetSSRC())
+}
+
+// Version returns RTP version, it should be 2.
+func (hdr *RTCPHdr) Version() uint8 {
+	return hdr.VPRC >> 6
+}
+
+// Count returns reception report count or subtype of packet.
+func (hdr *RTCPHdr) Count() uint8 {
+	return hdr.VPRC & 0x1f
+}
+
+// PacketLen returns length of RTCP packet in bytes including header.
+func (hdr *RTCPHdr) PacketLen() uint {
+	return (uint(SwapBytesUint16(hdr.Length)) + 1) * 4
+}
+
+// GetSSRC returns synchronization source identifier of sender.
+func (hdr *RTCPHdr) GetSSRC() uint32 {
+	return SwapBytesUint32(hdr.SSRC)
+}
+
+// udpPayload returns UDP payload which is in the first segment of
+// packet. L7 should be parsed for UDP protocol.
+func (packet *Packet) udpPayload() []byte {
+	udp := (*UDPHdr)(packet.L4)
+	length := int(SwapBytesUint16(udp.DgramLen)) - types.UDPLen
+	available := int(packet.GetPacketSegmentLen()) - int(uintptr(packet.Data)-uintptr(packet.StartAtOffset(0)))
+	if length > available {
+		length = available
+	}
+	if length <= 0 {
+		return nil
+	}
+	return (*[types.MaxLength]byte)(packet.Data)[:length:length]
+}
+
+// GetRTP returns RTP header if UDP payload looks like RTP packet of
+// version 2 which isn't RTCP packet multiplexed on the same port. RTP
+// doesn't have well known ports, so UDP ports should be checked by
+// caller. L7 should be parsed for UDP protocol.
+func (packet *Packet) GetRTP() *RTPHdr {
+	data := packet.udpPayload()
+	if len(data) < RTPHdrLen || data[0]>>6 != RTPVersion || data[1] >= rtcpMinType && data[1] <= rtcpMaxType {
+		return nil
+	}
+	return (*RTPHdr)(packet.Data)
+}
+
+// GetRTPPayload returns payload of RTP packet without CSRC list,
+// header extension and padding. Returns nil if packet isn't RTP
+// packet or its headers don't fit in the first segment. L7 should be
+// parsed for UDP protocol.
+func (packet *Packet) GetRTPPayload() []byte {
+	hdr := packet.GetRTP()
+	if hdr == nil {
+		return nil
+	}
+	data := packet.udpPayload()
+	offset := RTPHdrLen + int(hdr.CSRCCount())*rtpCSRCLen
+	if hdr.Extension() {
+		if len(data) < offset+rtpExtHdrLen {
+			return nil
+		}
+		offset += rtpExtHdrLen + (int(data[offset+2])<<8|int(data[offset+3]))*4
+	}
+	end := len(data)
+	if hdr.Padding() && end > 0 {
+		end -= int(data[end-1])
+	}
+	if offset > end {
+		return nil
+	}
+	return data[offset:end]
+}
+
+// GetRTCP returns header of the first RTCP packet of compound packet
+// if UDP payload looks like RTCP packet of version 2. L7 should be
+// parsed for UDP protocol.
+func (packet *Packet) GetRTCP() *RTCPHdr {
+	data := packet.udpPayload()
+	if len(data) < RTCPHdrLen || data[0]>>6 != RTPVersion || data[1] < RTCPTypeSR || data[1] > rtcpMaxType {
+		return nil
+	}
+	return (*RTCPHdr)(packet.Data)
+}
+
+// RTCPPackets calls fn for every RTCP packet of compound packet until
+// fn returns false. Packet is a slice of packet memory including
+// header. Returns false if packets don't fit in UDP payload.
+func (packet *Packet) RTCPPackets(fn func(hdr *RTCPHdr, data []byte) bool) bool {
+	if packet.GetRTCP() == nil {
+		return false
+	}
+	return rtcpPackets(packet.udpPayload(), fn)
+}
+
+func rtcpPackets(data []byte, fn func(hdr *RTCPHdr, data []byte) bool) bool {
+	for len(data) != 0 {
+		if len(data) < RTCPHdrLen {
+			return false
+		}
+		hdr := (*RTCPHdr)(unsafe.Pointer(&data[0]))
+		length := int(hdr.PacketLen())
+		if length > len(data) {
+			return false
+		}
+		if !fn(hdr, data[:length:length]) {
+			return true
+		}
+		data = data[length:]
+	}
+	return true
+}
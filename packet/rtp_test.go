@@ -0,0 +1,98 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func getRTPTestPacket(payload []byte) *Packet {
+	pkt := getPacket()
+	InitEmptyIPv4UDPPacket(pkt, uint(len(payload)))
+	pkt.ParseL3()
+	pkt.ParseL4ForIPv4()
+	pkt.ParseL7(types.UDPNumber)
+	copy((*[types.MaxLength]byte)(pkt.Data)[:len(payload)], payload)
+	return pkt
+}
+
+func TestGetRTP(t *testing.T) {
+	data := []byte{
+		0xb1, 0xe0, 0x12, 0x34, // V=2, P, X, CC=1, M, PT=96, sequence number
+		0x00, 0x01, 0x00, 0x00, // timestamp
+		0xca, 0xfe, 0xba, 0xbe, // SSRC
+		0x01, 0x02, 0x03, 0x04, // CSRC
+		0xbe, 0xde, 0x00, 0x01, // header extension with one word
+		0x10, 0xaa, 0x00, 0x00,
+		'm', 'e', 'd', 'i', 'a',
+		0x00, 0x00, 0x03, // padding
+	}
+	pkt := getRTPTestPacket(data)
+	rtp := pkt.GetRTP()
+	if rtp == nil {
+		t.Fatal("GetRTP returned nil")
+	}
+	if rtp.Version() != RTPVersion || !rtp.Padding() || !rtp.Extension() || rtp.CSRCCount() != 1 ||
+		!rtp.Marker() || rtp.PayloadType() != 96 || rtp.GetSeqNum() != 0x1234 ||
+		rtp.GetTimestamp() != 0x10000 || rtp.GetSSRC() != 0xcafebabe || rtp.GetCSRC(0) != 0x01020304 {
+		t.Errorf("Wrong RTP header %v", rtp)
+	}
+	if payload := pkt.GetRTPPayload(); string(payload) != "media" {
+		t.Errorf("Wrong RTP payload %q", payload)
+	}
+	if pkt.GetRTCP() != nil {
+		t.Error("GetRTCP returned RTP packet")
+	}
+
+	rtp.SetSeqNum(0x4321)
+	rtp.SetTimestamp(0xdeadbeef)
+	rtp.SetSSRC(1)
+	if rtp.GetSeqNum() != 0x4321 || rtp.GetTimestamp() != 0xdeadbeef || rtp.GetSSRC() != 1 {
+		t.Errorf("Wrong RTP header after setting fields %v", rtp)
+	}
+
+	if getRTPTestPacket(data[:RTPHdrLen-1]).GetRTP() != nil {
+		t.Error("GetRTP returned truncated packet")
+	}
+	data[0] = 0x40
+	if getRTPTestPacket(data).GetRTP() != nil {
+		t.Error("GetRTP returned packet of version 1")
+	}
+}
+
+func TestGetRTCP(t *testing.T) {
+	data := []byte{
+		0x80, RTCPTypeRR, 0x00, 0x01, // V=2, RC=0, length
+		0x00, 0x00, 0x00, 0x2a, // SSRC
+		0x81, RTCPTypeSDES, 0x00, 0x02,
+		0x00, 0x00, 0x00, 0x2a,
+		0x01, 0x02, 'a', 'b', // CNAME item
+	}
+	pkt := getRTPTestPacket(data)
+	if pkt.GetRTP() != nil {
+		t.Error("GetRTP returned RTCP packet")
+	}
+	rtcp := pkt.GetRTCP()
+	if rtcp == nil || rtcp.Version() != RTPVersion || rtcp.PacketType != RTCPTypeRR || rtcp.Count() != 0 ||
+		rtcp.PacketLen() != 8 || rtcp.GetSSRC() != 42 {
+		t.Fatalf("Wrong RTCP header %v", rtcp)
+	}
+	var pts []uint8
+	if !pkt.RTCPPackets(func(hdr *RTCPHdr, data []byte) bool {
+		pts = append(pts, hdr.PacketType)
+		return true
+	}) || len(pts) != 2 || pts[0] != RTCPTypeRR || pts[1] != RTCPTypeSDES {
+		t.Errorf("Wrong RTCP packets %v", pts)
+	}
+	if getRTPTestPacket(data[:len(data)-1]).RTCPPackets(func(*RTCPHdr, []byte) bool { return true }) {
+		t.Error("Truncated compound packet was accepted")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"fmt"
+	"hash/crc32"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// SCTPHdr is a common header of SCTP packet which is followed by
+// chunks.
+type SCTPHdr struct {
+	SrcPort uint16 // SCTP source port
+	DstPort uint16 // SCTP destination port
+	VerTag  uint32 // Verification tag
+	Cksum   uint32 // CRC32c checksum, least significant byte first
+}
+
+// SCTPChunkHdr is a header of SCTP chunk. Length includes chunk
+// header but doesn't include padding to 4 bytes.
+type SCTPChunkHdr struct {
+	Type   uint8  // Chunk type
+	Flags  uint8  // Chunk flags
+	Length uint16 // Chunk length
+}
+
+// SCTP chunk types
+const (
+	SCTPData             uint8 = 0
+	SCTPInit             uint8 = 1
+	SCTPInitAck          uint8 = 2
+	SCTPSack             uint8 = 3
+	SCTPHeartbeat        uint8 = 4
+	SCTPHeartbeatAck     uint8 = 5
+	SCTPAbort            uint8 = 6
+	SCTPShutdown         uint8 = 7
+	SCTPShutdownAck      uint8 = 8
+	SCTPError            uint8 = 9
+	SCTPCookieEcho       uint8 = 10
+	SCTPCookieAck        uint8 = 11
+	SCTPShutdownComplete uint8 = 14
+)
+
+// Length of SCTP chunk header
+const sctpChunkHdrLen = 4
+
+func (hdr *SCTPHdr) String() string {
+	r0 := "        L4 protocol: SCTP\n"
+	r1 := fmt.Sprintf("        L4 Source: %d\n", SwapBytesUint16(hdr.SrcPort))
+	r2 := fmt.Sprintf("        L4 Destination: %d\n", SwapBytesUint16(hdr.DstPort))
+	r3 := fmt.Sprintf("        Verification tag: %#x\n", SwapBytesUint32(hdr.VerTag))
+	return r0 + r1 + r2 + r3
+}
+
+// GetSCTPForIPv4 ensures if L4 type is SCTP and cast L4 pointer to *SCTPHdr type.
+func (packet *Packet) GetSCTPForIPv4() *SCTPHdr {
+	if packet.GetIPv4NoCheck().NextProtoID == types.SCTPNumber {
+		return (*SCTPHdr)(packet.L4)
+	}
+	return nil
+}
+
+// GetSCTPForIPv6 ensures if L4 type is SCTP and cast L4 pointer to *SCTPHdr type.
+func (packet *Packet) GetSCTPForIPv6() *SCTPHdr {
+	if packet.GetIPv6NoCheck().Proto == types.SCTPNumber {
+		return (*SCTPHdr)(packet.L4)
+	}
+	return nil
+}
+
+// GetSCTPNoCheck casts L4 pointer to *SCTPHdr type.
+func (packet *Packet) GetSCTPNoCheck() *SCTPHdr {
+	return (*SCTPHdr)(packet.L4)
+}
+
+// SCTPChunks calls fn for every chunk of SCTP packet of given length
+// until fn returns false. Returns false if chunk length exceeds
+// packet length or is less than length of chunk header.
+func SCTPChunks(sctp *SCTPHdr, length int, fn func(chunk *SCTPChunkHdr) bool) bool {
+	for offset := types.SCTPLen; offset < length; {
+		if offset+sctpChunkHdrLen > length {
+			return false
+		}
+		chunk := (*SCTPChunkHdr)(unsafe.Pointer(uintptr(unsafe.Pointer(sctp)) + uintptr(offset)))
+		chunkLen := int(SwapBytesUint16(chunk.Length))
+		if chunkLen < sctpChunkHdrLen || offset+chunkLen > length {
+			return false
+		}
+		if !fn(chunk) {
+			return true
+		}
+		offset += (chunkLen + 3) &^ 3
+	}
+	return true
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// calculateSCTPChecksum computes CRC32c of SCTP packet of given length
+// with zero checksum field.
+func calculateSCTPChecksum(sctp *SCTPHdr, length int) uint32 {
+	if length < types.SCTPLen {
+		return 0
+	}
+	data := (*[types.MaxLength]byte)(unsafe.Pointer(sctp))[:length:length]
+	var zero [4]byte
+	crc := crc32.Update(0, castagnoli, data[:8])
+	crc = crc32.Update(crc, castagnoli, zero[:])
+	return crc32.Update(crc, castagnoli, data[types.SCTPLen:])
+}
+
+// CalculateIPv4SCTPChecksum calculates CRC32c checksum of SCTP packet
+// for case if L3 protocol is IPv4. Unlike checksums of other
+// protocols it doesn't cover pseudo header. Result can be assigned to
+// Cksum field as is on little endian CPUs.
+func CalculateIPv4SCTPChecksum(hdr *IPv4Hdr, sctp *SCTPHdr) uint32 {
+	return calculateSCTPChecksum(sctp, int(SwapBytesUint16(hdr.TotalLength))-int(hdr.VersionIhl&0x0f)*4)
+}
+
+// CalculateIPv6SCTPChecksum calculates CRC32c checksum of SCTP packet
+// for case if L3 protocol is IPv6 and SCTP header follows IPv6 header.
+// Result can be assigned to Cksum field as is on little endian CPUs.
+func CalculateIPv6SCTPChecksum(hdr *IPv6Hdr, sctp *SCTPHdr) uint32 {
+	return calculateSCTPChecksum(sctp, int(SwapBytesUint16(hdr.PayloadLen)))
+}
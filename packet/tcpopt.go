@@ -0,0 +1,302 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// TCP option kinds and lengths (RFC 793, RFC 2018, RFC 7323)
+const (
+	TCPOptEnd           = 0
+	TCPOptNop           = 1
+	TCPOptMSS           = 2
+	TCPOptWindowScale   = 3
+	TCPOptSACKPermitted = 4
+	TCPOptSACK          = 5
+	TCPOptTimestamps    = 8
+
+	TCPOptMSSLen           = 4
+	TCPOptWindowScaleLen   = 3
+	TCPOptSACKPermittedLen = 2
+	TCPOptTimestampsLen    = 10
+
+	// Maximum shift count of window scale option
+	TCPMaxWindowScale = 14
+
+	tcpMaxHdrLen      = 60
+	tcpChecksumOff    = 16
+	tcpSACKBlockLen   = 8
+	tcpOptionHdrLen   = 2
+	tcpMaxOptionsLen  = tcpMaxHdrLen - types.TCPMinLen
+	tcpOptionNotFound = -1
+)
+
+// TCPSACKBlock is a block of SACK option, edges are in host byte order.
+type TCPSACKBlock struct {
+	Left  uint32
+	Right uint32
+}
+
+// HeaderLen returns length of TCP header with options.
+func (hdr *TCPHdr) HeaderLen() uint {
+	return uint(hdr.DataOff>>4) * 4
+}
+
+// bytes returns TCP header with options as a slice of packet. Returns
+// nil if data offset is less than minimal TCP header length.
+func (hdr *TCPHdr) bytes() []byte {
+	length := hdr.HeaderLen()
+	if length < types.TCPMinLen {
+		return nil
+	}
+	return (*[tcpMaxHdrLen]byte)(unsafe.Pointer(hdr))[:length:length]
+}
+
+// TCPOptions calls fn for every option of TCP header except padding
+// until fn returns false. Data of option is a slice of packet without
+// kind and length bytes. Returns false if options are malformed.
+func TCPOptions(hdr *TCPHdr, fn func(kind uint8, data []byte) bool) bool {
+	b := hdr.bytes()
+	return walkTCPOptions(b, func(off int) bool {
+		end := off + int(b[off+1])
+		return fn(b[off], b[off+tcpOptionHdrLen:end:end])
+	})
+}
+
+// walkTCPOptions calls fn with offset of every option of TCP header
+// except padding until fn returns false. Length of option is checked
+// before fn is called.
+func walkTCPOptions(b []byte, fn func(off int) bool) bool {
+	if b == nil {
+		return false
+	}
+	for off := types.TCPMinLen; off < len(b); {
+		switch b[off] {
+		case TCPOptEnd:
+			return true
+		case TCPOptNop:
+			off++
+			continue
+		}
+		if off+tcpOptionHdrLen > len(b) || b[off+1] < tcpOptionHdrLen || off+int(b[off+1]) > len(b) {
+			return false
+		}
+		if !fn(off) {
+			return true
+		}
+		off += int(b[off+1])
+	}
+	return true
+}
+
+// findOption returns offset of the first option with given kind from
+// the beginning of TCP header if it has given length.
+func (hdr *TCPHdr) findOption(kind uint8, length int) int {
+	b := hdr.bytes()
+	found := tcpOptionNotFound
+	walkTCPOptions(b, func(off int) bool {
+		if b[off] != kind {
+			return true
+		}
+		if int(b[off+1]) == length {
+			found = off
+		}
+		return false
+	})
+	return found
+}
+
+// replace replaces bytes of TCP header at offset off and updates
+// checksum incrementally as described in RFC 1624. Offset can be odd,
+// so checksum is adjusted for all 16-bit words which contain replaced
+// bytes. Checksum isn't changed if hardware checksum offloading is
+// enabled because pseudo header checksum doesn't cover options.
+func (hdr *TCPHdr) replace(off int, value []byte) {
+	b := hdr.bytes()
+	if hwtxchecksum {
+		copy(b[off:], value)
+		return
+	}
+	start := off &^ 1
+	end := (off + len(value) + 1) &^ 1
+	var old [tcpMaxHdrLen]byte
+	copy(old[:], b[start:end])
+	copy(b[off:], value)
+	checksum := binary.BigEndian.Uint16(b[tcpChecksumOff:])
+	for i := start; i < end; i += 2 {
+		checksum = adjustChecksum(checksum, binary.BigEndian.Uint16(old[i-start:]), binary.BigEndian.Uint16(b[i:]))
+	}
+	binary.BigEndian.PutUint16(b[tcpChecksumOff:], checksum)
+}
+
+// adjustChecksum returns checksum after 16-bit word of checksummed
+// data is changed from old to new value.
+func adjustChecksum(checksum, old, new uint16) uint16 {
+	// ~C' = ~C + ~m + m'
+	sum := uint32(^checksum) + uint32(^old) + uint32(new)
+	sum = (sum & 0xffff) + (sum >> 16)
+	sum = (sum & 0xffff) + (sum >> 16)
+	return ^uint16(sum)
+}
+
+// GetMSS returns value of MSS option.
+func (hdr *TCPHdr) GetMSS() (uint16, bool) {
+	off := hdr.findOption(TCPOptMSS, TCPOptMSSLen)
+	if off == tcpOptionNotFound {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(hdr.bytes()[off+tcpOptionHdrLen:]), true
+}
+
+// SetMSS sets value of MSS option and updates checksum. Returns false
+// if packet doesn't have MSS option.
+func (hdr *TCPHdr) SetMSS(mss uint16) bool {
+	off := hdr.findOption(TCPOptMSS, TCPOptMSSLen)
+	if off == tcpOptionNotFound {
+		return false
+	}
+	var value [2]byte
+	binary.BigEndian.PutUint16(value[:], mss)
+	hdr.replace(off+tcpOptionHdrLen, value[:])
+	return true
+}
+
+// ClampMSS decreases MSS option to mss if it is larger and updates
+// checksum. Returns true if option is changed.
+func (hdr *TCPHdr) ClampMSS(mss uint16) bool {
+	current, ok := hdr.GetMSS()
+	if !ok || current <= mss {
+		return false
+	}
+	return hdr.SetMSS(mss)
+}
+
+// GetWindowScale returns shift count of window scale option.
+func (hdr *TCPHdr) GetWindowScale() (uint8, bool) {
+	off := hdr.findOption(TCPOptWindowScale, TCPOptWindowScaleLen)
+	if off == tcpOptionNotFound {
+		return 0, false
+	}
+	return hdr.bytes()[off+tcpOptionHdrLen], true
+}
+
+// SetWindowScale sets shift count of window scale option and updates
+// checksum. Returns false if packet doesn't have window scale option
+// or shift is larger than TCPMaxWindowScale.
+func (hdr *TCPHdr) SetWindowScale(shift uint8) bool {
+	off := hdr.findOption(TCPOptWindowScale, TCPOptWindowScaleLen)
+	if off == tcpOptionNotFound || shift > TCPMaxWindowScale {
+		return false
+	}
+	hdr.replace(off+tcpOptionHdrLen, []byte{shift})
+	return true
+}
+
+// SACKPermitted returns true if packet has SACK permitted option.
+func (hdr *TCPHdr) SACKPermitted() bool {
+	return hdr.findOption(TCPOptSACKPermitted, TCPOptSACKPermittedLen) != tcpOptionNotFound
+}
+
+// GetSACK appends blocks of SACK option to blocks. Returns false if
+// packet doesn't have valid SACK option.
+func (hdr *TCPHdr) GetSACK(blocks []TCPSACKBlock) ([]TCPSACKBlock, bool) {
+	found := false
+	TCPOptions(hdr, func(kind uint8, data []byte) bool {
+		if kind != TCPOptSACK {
+			return true
+		}
+		if len(data) != 0 && len(data)%tcpSACKBlockLen == 0 {
+			found = true
+			for i := 0; i < len(data); i += tcpSACKBlockLen {
+				blocks = append(blocks, TCPSACKBlock{
+					Left:  binary.BigEndian.Uint32(data[i:]),
+					Right: binary.BigEndian.Uint32(data[i+4:]),
+				})
+			}
+		}
+		return false
+	})
+	return blocks, found
+}
+
+// AdjustSACK adds delta to edges of all blocks of SACK option and
+// updates checksum. It is used when sequence numbers are translated,
+// for example by SYN proxy. Returns false if packet doesn't have valid
+// SACK option.
+func (hdr *TCPHdr) AdjustSACK(delta uint32) bool {
+	b := hdr.bytes()
+	off := tcpOptionNotFound
+	walkTCPOptions(b, func(o int) bool {
+		if b[o] != TCPOptSACK {
+			return true
+		}
+		if length := int(b[o+1]) - tcpOptionHdrLen; length != 0 && length%tcpSACKBlockLen == 0 {
+			off = o
+		}
+		return false
+	})
+	if off == tcpOptionNotFound {
+		return false
+	}
+	var value [4]byte
+	for i := off + tcpOptionHdrLen; i < off+int(b[off+1]); i += 4 {
+		binary.BigEndian.PutUint32(value[:], binary.BigEndian.Uint32(b[i:])+delta)
+		hdr.replace(i, value[:])
+	}
+	return true
+}
+
+// GetTimestamps returns timestamp value and timestamp echo reply of
+// timestamps option.
+func (hdr *TCPHdr) GetTimestamps() (value, echo uint32, ok bool) {
+	off := hdr.findOption(TCPOptTimestamps, TCPOptTimestampsLen)
+	if off == tcpOptionNotFound {
+		return 0, 0, false
+	}
+	b := hdr.bytes()[off+tcpOptionHdrLen:]
+	return binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:]), true
+}
+
+// SetTimestamps sets timestamp value and timestamp echo reply of
+// timestamps option and updates checksum. Returns false if packet
+// doesn't have timestamps option.
+func (hdr *TCPHdr) SetTimestamps(value, echo uint32) bool {
+	off := hdr.findOption(TCPOptTimestamps, TCPOptTimestampsLen)
+	if off == tcpOptionNotFound {
+		return false
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:], value)
+	binary.BigEndian.PutUint32(b[4:], echo)
+	hdr.replace(off+tcpOptionHdrLen, b[:])
+	return true
+}
+
+// RemoveOption replaces all options with given kind by NOP options and
+// updates checksum, so length of header isn't changed. Returns true if
+// any option is removed.
+func (hdr *TCPHdr) RemoveOption(kind uint8) bool {
+	if kind == TCPOptEnd || kind == TCPOptNop {
+		return false
+	}
+	var nops [tcpMaxOptionsLen]byte
+	for i := range nops {
+		nops[i] = TCPOptNop
+	}
+	b := hdr.bytes()
+	removed := false
+	walkTCPOptions(b, func(off int) bool {
+		if b[off] == kind {
+			hdr.replace(off, nops[:b[off+1]])
+			removed = true
+		}
+		return true
+	})
+	return removed
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+// tcpOptionsHeader returns SYN header with MSS, window scale, SACK
+// permitted, timestamps and SACK options and valid checksum of header
+// without pseudo header.
+func tcpOptionsHeader() []byte {
+	b := []byte{
+		0x9c, 0x40, 0, 80, 0, 0, 0, 1, 0, 0, 0, 0, 0xd0, 0x02, 0xff, 0xff, 0, 0, 0, 0,
+		TCPOptMSS, TCPOptMSSLen, 0x05, 0xb4,
+		TCPOptNop, TCPOptWindowScale, TCPOptWindowScaleLen, 7,
+		TCPOptSACKPermitted, TCPOptSACKPermittedLen,
+		TCPOptTimestamps, TCPOptTimestampsLen, 0, 0, 0, 10, 0, 0, 0, 20,
+		TCPOptSACK, 10, 0, 0, 0x10, 0, 0, 0, 0x20, 0,
+		TCPOptEnd, 0,
+	}
+	binary.BigEndian.PutUint16(b[tcpChecksumOff:], ^tcpHeaderSum(b))
+	return b
+}
+
+func tcpHeaderSum(b []byte) uint16 {
+	sum := uint32(0)
+	for i := 0; i < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return uint16(sum)
+}
+
+func TestTCPOptionsGet(t *testing.T) {
+	b := tcpOptionsHeader()
+	hdr := (*TCPHdr)(unsafe.Pointer(&b[0]))
+	if hdr.HeaderLen() != uint(len(b)) {
+		t.Fatalf("Header length is %d instead of %d", hdr.HeaderLen(), len(b))
+	}
+	var kinds []uint8
+	if !TCPOptions(hdr, func(kind uint8, data []byte) bool {
+		kinds = append(kinds, kind)
+		return true
+	}) {
+		t.Fatal("TCPOptions failed")
+	}
+	if len(kinds) != 5 {
+		t.Errorf("Found options %v", kinds)
+	}
+	if mss, ok := hdr.GetMSS(); !ok || mss != 1460 {
+		t.Errorf("MSS is %d", mss)
+	}
+	if shift, ok := hdr.GetWindowScale(); !ok || shift != 7 {
+		t.Errorf("Window scale is %d", shift)
+	}
+	if !hdr.SACKPermitted() {
+		t.Error("SACK isn't permitted")
+	}
+	if value, echo, ok := hdr.GetTimestamps(); !ok || value != 10 || echo != 20 {
+		t.Errorf("Timestamps are %d and %d", value, echo)
+	}
+	blocks, ok := hdr.GetSACK(nil)
+	if !ok || len(blocks) != 1 || blocks[0] != (TCPSACKBlock{0x1000, 0x2000}) {
+		t.Errorf("SACK blocks are %v", blocks)
+	}
+}
+
+func TestTCPOptionsSet(t *testing.T) {
+	b := tcpOptionsHeader()
+	hdr := (*TCPHdr)(unsafe.Pointer(&b[0]))
+	if hdr.ClampMSS(1500) {
+		t.Error("MSS was increased")
+	}
+	if !hdr.ClampMSS(1400) || !hdr.SetWindowScale(2) || !hdr.SetTimestamps(0x01020304, 5) || !hdr.AdjustSACK(0xfffff000) {
+		t.Fatal("Options weren't changed")
+	}
+	if hdr.SetWindowScale(TCPMaxWindowScale + 1) {
+		t.Error("Too large window scale was set")
+	}
+	if mss, _ := hdr.GetMSS(); mss != 1400 {
+		t.Errorf("MSS is %d", mss)
+	}
+	if shift, _ := hdr.GetWindowScale(); shift != 2 {
+		t.Errorf("Window scale is %d", shift)
+	}
+	if value, echo, _ := hdr.GetTimestamps(); value != 0x01020304 || echo != 5 {
+		t.Errorf("Timestamps are %d and %d", value, echo)
+	}
+	if blocks, _ := hdr.GetSACK(nil); blocks[0] != (TCPSACKBlock{0, 0x1000}) {
+		t.Errorf("SACK blocks are %v", blocks)
+	}
+	if !hdr.RemoveOption(TCPOptSACKPermitted) || hdr.SACKPermitted() || hdr.RemoveOption(TCPOptSACKPermitted) {
+		t.Error("SACK permitted option wasn't removed")
+	}
+	if tcpHeaderSum(b) != 0xffff {
+		t.Errorf("Wrong checksum 0x%04x after options were changed", binary.BigEndian.Uint16(b[tcpChecksumOff:]))
+	}
+}
+
+func TestTCPOptionsMalformed(t *testing.T) {
+	b := tcpOptionsHeader()
+	hdr := (*TCPHdr)(unsafe.Pointer(&b[0]))
+	// Length of timestamps option exceeds header
+	b[31] = 40
+	if TCPOptions(hdr, func(uint8, []byte) bool { return true }) {
+		t.Error("Malformed options were accepted")
+	}
+	if _, _, ok := hdr.GetTimestamps(); ok {
+		t.Error("Malformed timestamps option was found")
+	}
+	b[31] = 1
+	if TCPOptions(hdr, func(uint8, []byte) bool { return true }) {
+		t.Error("Option with length 1 was accepted")
+	}
+	hdr.DataOff = 0x40
+	if TCPOptions(hdr, func(uint8, []byte) bool { return true }) || hdr.SetMSS(1) {
+		t.Error("Header with wrong data offset was accepted")
+	}
+}
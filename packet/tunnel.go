@@ -0,0 +1,256 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"unsafe"
+
+	"github.com/intel-go/nff-go/internal/low"
+	"github.com/intel-go/nff-go/types"
+)
+
+// VXLAN constants
+const (
+	UDPPortVXLAN     = 4789
+	SwapUDPPortVXLAN = 0xb512
+
+	vxlanLen = 8
+)
+
+// tunnelLayout describes headers of tunnel packet. Offsets are counted
+// from the beginning of packet.
+type tunnelLayout struct {
+	tunnel     uint64
+	outerL3    uint
+	outerL3Len uint
+	outerL4    uint
+	outerL4Len uint
+	innerL3    uint
+	innerL3Len uint
+	// Inner L4 protocol, zero for IPv4 fragments
+	innerProto uint8
+	innerL4Len uint
+	outerIPv4  bool
+	innerIPv4  bool
+	// GRE header has checksum field
+	greChecksum bool
+}
+
+// parseTunnel fills layout of VXLAN or Geneve over UDP or of GRE tunnel
+// packet. Returns false if packet isn't one of them or its headers
+// don't fit into the first segment.
+func parseTunnel(b []byte, l *tunnelLayout) bool {
+	var proto uint8
+	var total uint
+	l.outerIPv4, l.outerL3Len, proto, total = parseIPHeader(b, l.outerL3)
+	if l.outerL3Len == 0 || proto == 0 || l.outerL3+total > uint(len(b)) {
+		return false
+	}
+	b = b[:l.outerL3+total]
+	l.outerL4 = l.outerL3 + l.outerL3Len
+	l.outerL4Len = total - l.outerL3Len
+	hdr := unsafe.Pointer(&b[0])
+	inner := l.outerL4
+	var etherType uint16
+	switch proto {
+	case types.UDPNumber:
+		if l.outerL4+types.UDPLen+types.GeneveLen > uint(len(b)) {
+			return false
+		}
+		inner += types.UDPLen
+		switch (*UDPHdr)(unsafe.Pointer(uintptr(hdr) + uintptr(l.outerL4))).DstPort {
+		case SwapUDPPortVXLAN:
+			l.tunnel = low.TXTunnelVXLAN
+			inner += vxlanLen
+			etherType = GeneveProtoEthernet
+		case SwapUDPPortGeneve:
+			l.tunnel = low.TXTunnelGeneve
+			geneve := (*GeneveHdr)(unsafe.Pointer(uintptr(hdr) + uintptr(inner)))
+			inner += geneve.HeaderLen()
+			etherType = SwapBytesUint16(geneve.Proto)
+		default:
+			return false
+		}
+	case types.GRENumber:
+		if l.outerL4+types.GRELen > uint(len(b)) {
+			return false
+		}
+		l.tunnel = low.TXTunnelGRE
+		gre := (*GREHdr)(unsafe.Pointer(uintptr(hdr) + uintptr(l.outerL4)))
+		inner += gre.HeaderLen()
+		etherType = SwapBytesUint16(gre.NextProto)
+		l.greChecksum = SwapBytesUint16(gre.Flags)&GREFlagChecksum != 0
+	default:
+		return false
+	}
+	if etherType == GeneveProtoEthernet {
+		inner += types.EtherLen
+		if inner > uint(len(b)) {
+			return false
+		}
+		etherType = uint16(b[inner-2])<<8 | uint16(b[inner-1])
+	}
+	if etherType != types.IPV4Number && etherType != types.IPV6Number {
+		return false
+	}
+	l.innerL3 = inner
+	l.innerIPv4, l.innerL3Len, l.innerProto, total = parseIPHeader(b, inner)
+	if l.innerL3Len == 0 || l.innerIPv4 != (etherType == types.IPV4Number) || inner+total > uint(len(b)) {
+		return false
+	}
+	l.innerL4Len = total - l.innerL3Len
+	switch l.innerProto {
+	case types.TCPNumber:
+		if l.innerL4Len < types.TCPMinLen {
+			return false
+		}
+	case types.UDPNumber:
+		if l.innerL4Len < types.UDPLen {
+			return false
+		}
+	}
+	return true
+}
+
+// parseIPHeader returns version, header length, next protocol and
+// total length of IPv4 or IPv6 header at offset of b. Protocol is zero
+// for IPv4 fragments, header length is zero if header is invalid.
+func parseIPHeader(b []byte, offset uint) (ipv4 bool, hdrLen uint, proto uint8, total uint) {
+	if offset+types.IPv4MinLen > uint(len(b)) {
+		return false, 0, 0, 0
+	}
+	switch b[offset] >> 4 {
+	case 4:
+		hdr := (*IPv4Hdr)(unsafe.Pointer(&b[offset]))
+		hdrLen = uint(hdr.VersionIhl&0x0f) << 2
+		total = uint(SwapBytesUint16(hdr.TotalLength))
+		if hdrLen < types.IPv4MinLen || total < hdrLen {
+			return true, 0, 0, 0
+		}
+		if SwapBytesUint16(hdr.FragmentOffset)&(types.IPv4MoreFragments|types.IPv4FragmentOffsetMask) == 0 {
+			proto = hdr.NextProtoID
+		}
+		return true, hdrLen, proto, total
+	case 6:
+		if offset+types.IPv6Len > uint(len(b)) {
+			return false, 0, 0, 0
+		}
+		hdr := (*IPv6Hdr)(unsafe.Pointer(&b[offset]))
+		return false, types.IPv6Len, hdr.Proto, types.IPv6Len + uint(SwapBytesUint16(hdr.PayloadLen))
+	}
+	return false, 0, 0, 0
+}
+
+// pseudoHdrChecksum returns checksum of pseudo header of L4 header
+// after IPv4 or IPv6 header.
+func pseudoHdrChecksum(l3 unsafe.Pointer, ipv4 bool, proto uint8, length uint) uint32 {
+	if ipv4 {
+		return calculateIPv4AddrChecksum((*IPv4Hdr)(l3)) + uint32(proto) + uint32(length)
+	}
+	return calculateIPv6AddrChecksum((*IPv6Hdr)(l3)) + uint32(proto) + uint32(length)
+}
+
+// setL4Checksum sets checksum of TCP or UDP header. Only pseudo header
+// checksum is set if checksum is calculated by hardware.
+func setL4Checksum(l4 unsafe.Pointer, proto uint8, length uint, pseudo uint32, hw bool) {
+	var cksum *uint16
+	switch proto {
+	case types.TCPNumber:
+		cksum = &(*TCPHdr)(l4).Cksum
+	case types.UDPNumber:
+		cksum = &(*UDPHdr)(l4).DgramCksum
+	default:
+		return
+	}
+	*cksum = 0
+	if hw {
+		*cksum = SwapBytesUint16(reduceChecksum(pseudo))
+		return
+	}
+	sum := ^reduceChecksum(pseudo + calculateDataChecksum(l4, int(length), 0))
+	if proto == types.UDPNumber && sum == 0 {
+		sum = 0xffff
+	}
+	*cksum = SwapBytesUint16(sum)
+}
+
+// SetTunnelChecksums sets checksums of inner and outer headers of
+// VXLAN, Geneve or GRE tunnel packet. Checksums of inner IPv4, TCP and
+// UDP headers, outer IPv4 header and outer UDP header are calculated
+// by hardware if both HWTXChecksum and HWTXTunnelChecksum
+// capabilities are used, otherwise they are calculated in software.
+// Packets with GRE checksum are always processed in software because
+// hardware can't calculate it. Outer header can be IPv4 or IPv6 after
+// Ethernet header with optional VLAN tag, whole packet should fit into
+// the first segment. Returns false if packet isn't tunnel packet or
+// its headers are malformed.
+func (packet *Packet) SetTunnelChecksums() bool {
+	b := packet.GetSegmentBytes()
+	l := tunnelLayout{outerL3: types.EtherLen}
+	etherType := SwapBytesUint16(packet.Ether.EtherType)
+	if etherType == types.VLANNumber && uint(len(b)) >= types.EtherLen+types.VLANLen {
+		l.outerL3 += types.VLANLen
+		etherType = uint16(b[l.outerL3-2])<<8 | uint16(b[l.outerL3-1])
+	}
+	if (etherType != types.IPV4Number && etherType != types.IPV6Number) || !parseTunnel(b, &l) {
+		return false
+	}
+	hw := hwtxchecksum && hwtxtunnelchecksum && !l.greChecksum
+	base := unsafe.Pointer(&b[0])
+	outer := unsafe.Pointer(uintptr(base) + uintptr(l.outerL3))
+	inner := unsafe.Pointer(uintptr(base) + uintptr(l.innerL3))
+
+	// Inner headers
+	if l.innerIPv4 {
+		if hw {
+			(*IPv4Hdr)(inner).HdrChecksum = 0
+		} else {
+			setIPv4HeaderChecksum((*IPv4Hdr)(inner))
+		}
+	}
+	setL4Checksum(unsafe.Pointer(uintptr(inner)+uintptr(l.innerL3Len)), l.innerProto, l.innerL4Len,
+		pseudoHdrChecksum(inner, l.innerIPv4, l.innerProto, l.innerL4Len), hw)
+
+	// Outer headers
+	if l.tunnel != low.TXTunnelGRE {
+		setL4Checksum(unsafe.Pointer(uintptr(base)+uintptr(l.outerL4)), types.UDPNumber, l.outerL4Len,
+			pseudoHdrChecksum(outer, l.outerIPv4, types.UDPNumber, l.outerL4Len), hw)
+	} else if l.greChecksum {
+		gre := unsafe.Pointer(uintptr(base) + uintptr(l.outerL4))
+		cksum := (*uint16)(unsafe.Pointer(uintptr(gre) + types.GRELen))
+		*cksum = 0
+		*cksum = SwapBytesUint16(^reduceChecksum(calculateDataChecksum(gre, int(l.outerL4Len), 0)))
+	}
+	if l.outerIPv4 {
+		if hw {
+			(*IPv4Hdr)(outer).HdrChecksum = 0
+		} else {
+			setIPv4HeaderChecksum((*IPv4Hdr)(outer))
+		}
+	}
+	if !hw {
+		return true
+	}
+
+	innerL2Len := uint32(l.innerL3 - l.outerL4)
+	innerL3Len := uint32(l.innerL3Len)
+	switch {
+	case l.innerIPv4 && l.innerProto == types.TCPNumber:
+		low.SetTXIPv4TCPOLFlags(packet.CMbuf, innerL2Len, innerL3Len)
+	case l.innerIPv4 && l.innerProto == types.UDPNumber:
+		low.SetTXIPv4UDPOLFlags(packet.CMbuf, innerL2Len, innerL3Len)
+	case l.innerIPv4:
+		low.SetTXIPv4OLFlags(packet.CMbuf, innerL2Len, innerL3Len)
+	case l.innerProto == types.TCPNumber:
+		low.SetTXIPv6TCPOLFlags(packet.CMbuf, innerL2Len, innerL3Len)
+	case l.innerProto == types.UDPNumber:
+		low.SetTXIPv6UDPOLFlags(packet.CMbuf, innerL2Len, innerL3Len)
+	default:
+		low.SetTXIPv6OLFlags(packet.CMbuf, innerL2Len, innerL3Len)
+	}
+	low.SetTXOuterOLFlags(packet.CMbuf, l.tunnel, l.outerIPv4, l.tunnel != low.TXTunnelGRE,
+		uint32(l.outerL3), uint32(l.outerL3Len))
+	return true
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func TestGeneveTunnelChecksums(t *testing.T) {
+	local := types.BytesToIPv4(192, 0, 2, 1)
+	remote := types.BytesToIPv4(198, 51, 100, 1)
+	pkt := getIPv4UDPTestPacket()
+	if !pkt.EncapsulateIPv4Geneve(local, remote, 50000, 1, nil) {
+		t.Fatal("EncapsulateIPv4Geneve failed")
+	}
+	pkt.ParseL3()
+	outer := pkt.GetIPv4NoCheck()
+	outer.HdrChecksum = 0
+	pkt.ParseL4ForIPv4()
+	udp := pkt.GetUDPNoCheck()
+	offset := types.EtherLen + types.IPv4MinLen + types.UDPLen + types.GeneveLen + types.EtherLen
+	inner := (*IPv4Hdr)(unsafe.Pointer(uintptr(unsafe.Pointer(pkt.Ether)) + uintptr(offset)))
+	inner.HdrChecksum = 0
+	innerUDP := (*UDPHdr)(unsafe.Pointer(uintptr(unsafe.Pointer(inner)) + types.IPv4MinLen))
+	innerUDP.DgramCksum = 0
+
+	if !pkt.SetTunnelChecksums() {
+		t.Fatal("SetTunnelChecksums failed")
+	}
+	if CalculateIPv4Checksum(outer) != SwapBytesUint16(outer.HdrChecksum) {
+		t.Error("Wrong outer IPv4 checksum")
+	}
+	if CalculateIPv4UDPChecksum(outer, udp, unsafe.Pointer(uintptr(unsafe.Pointer(udp))+types.UDPLen)) != SwapBytesUint16(udp.DgramCksum) {
+		t.Error("Wrong outer UDP checksum")
+	}
+	if CalculateIPv4Checksum(inner) != SwapBytesUint16(inner.HdrChecksum) {
+		t.Error("Wrong inner IPv4 checksum")
+	}
+	if CalculateIPv4UDPChecksum(inner, innerUDP, unsafe.Pointer(uintptr(unsafe.Pointer(innerUDP))+types.UDPLen)) != SwapBytesUint16(innerUDP.DgramCksum) {
+		t.Error("Wrong inner UDP checksum")
+	}
+}
+
+func TestGRETunnelChecksums(t *testing.T) {
+	local := types.BytesToIPv4(192, 0, 2, 1)
+	remote := types.BytesToIPv4(198, 51, 100, 1)
+	pkt := getIPv4TCPTestPacket()
+	if !pkt.EncapsulateIPv4GRE(local, remote, 0x1234, true) {
+		t.Fatal("EncapsulateIPv4GRE failed")
+	}
+	pkt.ParseL3()
+	outer := pkt.GetIPv4NoCheck()
+	outer.HdrChecksum = 0
+	offset := types.EtherLen + types.IPv4MinLen + types.GRELen + greFieldLen
+	inner := (*IPv4Hdr)(unsafe.Pointer(uintptr(unsafe.Pointer(pkt.Ether)) + uintptr(offset)))
+	tcp := (*TCPHdr)(unsafe.Pointer(uintptr(unsafe.Pointer(inner)) + types.IPv4MinLen))
+	tcp.Cksum = 0
+
+	if !pkt.SetTunnelChecksums() {
+		t.Fatal("SetTunnelChecksums failed")
+	}
+	if CalculateIPv4Checksum(outer) != SwapBytesUint16(outer.HdrChecksum) {
+		t.Error("Wrong outer IPv4 checksum")
+	}
+	if CalculateIPv4TCPChecksum(inner, tcp, unsafe.Pointer(uintptr(unsafe.Pointer(tcp))+types.TCPMinLen)) != SwapBytesUint16(tcp.Cksum) {
+		t.Error("Wrong inner TCP checksum")
+	}
+}
+
+func TestTunnelChecksumsRejected(t *testing.T) {
+	if getIPv4UDPTestPacket().SetTunnelChecksums() {
+		t.Error("SetTunnelChecksums accepted packet without tunnel")
+	}
+	if getARPRequestTestPacket().SetTunnelChecksums() {
+		t.Error("SetTunnelChecksums accepted ARP packet")
+	}
+}
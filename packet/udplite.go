@@ -0,0 +1,102 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// UDPLiteHdr is a UDP-Lite header. It has the same layout as UDP
+// header but length field is replaced by checksum coverage.
+type UDPLiteHdr struct {
+	SrcPort  uint16 // UDP-Lite source port
+	DstPort  uint16 // UDP-Lite destination port
+	Coverage uint16 // Number of bytes covered by checksum, zero means whole packet
+	Cksum    uint16 // UDP-Lite checksum
+}
+
+func (hdr *UDPLiteHdr) String() string {
+	r0 := "        L4 protocol: UDP-Lite\n"
+	r1 := fmt.Sprintf("        L4 Source: %d\n", SwapBytesUint16(hdr.SrcPort))
+	r2 := fmt.Sprintf("        L4 Destination: %d\n", SwapBytesUint16(hdr.DstPort))
+	r3 := fmt.Sprintf("        Checksum coverage: %d\n", SwapBytesUint16(hdr.Coverage))
+	return r0 + r1 + r2 + r3
+}
+
+// GetUDPLiteForIPv4 ensures if L4 type is UDP-Lite and cast L4 pointer to *UDPLiteHdr type.
+func (packet *Packet) GetUDPLiteForIPv4() *UDPLiteHdr {
+	if packet.GetIPv4NoCheck().NextProtoID == types.UDPLiteNumber {
+		return (*UDPLiteHdr)(packet.L4)
+	}
+	return nil
+}
+
+// GetUDPLiteForIPv6 ensures if L4 type is UDP-Lite and cast L4 pointer to *UDPLiteHdr type.
+func (packet *Packet) GetUDPLiteForIPv6() *UDPLiteHdr {
+	if packet.GetIPv6NoCheck().Proto == types.UDPLiteNumber {
+		return (*UDPLiteHdr)(packet.L4)
+	}
+	return nil
+}
+
+// GetUDPLiteNoCheck casts L4 pointer to *UDPLiteHdr type.
+func (packet *Packet) GetUDPLiteNoCheck() *UDPLiteHdr {
+	return (*UDPLiteHdr)(packet.L4)
+}
+
+// udpLiteCoverage returns number of bytes of UDP-Lite packet with
+// given length which are covered by checksum. Returns false if
+// coverage is illegal, such packets should be dropped.
+func udpLiteCoverage(udp *UDPLiteHdr, length int) (int, bool) {
+	coverage := int(SwapBytesUint16(udp.Coverage))
+	if coverage == 0 {
+		return length, true
+	}
+	return coverage, coverage >= types.UDPLiteLen && coverage <= length
+}
+
+func finishUDPLiteChecksum(sum uint32) uint16 {
+	retSum := ^reduceChecksum(sum)
+	// Zero checksum is not allowed in UDP-Lite, it is sent as all 1s
+	if retSum == 0 {
+		retSum = ^retSum
+	}
+	return retSum
+}
+
+// CalculateIPv4UDPLiteChecksum calculates UDP-Lite checksum for case
+// if L3 protocol is IPv4. Checksum covers part of packet according
+// to Coverage field. Returns false if Coverage field is illegal.
+func CalculateIPv4UDPLiteChecksum(hdr *IPv4Hdr, udp *UDPLiteHdr) (uint16, bool) {
+	length := int(SwapBytesUint16(hdr.TotalLength)) - int(hdr.VersionIhl&0x0f)*4
+	covered, ok := udpLiteCoverage(udp, length)
+	if !ok {
+		return 0, false
+	}
+	sum := calculateCoveredChecksum(unsafe.Pointer(udp), covered, udp.Cksum) +
+		calculateIPv4AddrChecksum(hdr) +
+		uint32(hdr.NextProtoID) +
+		uint32(length)
+	return finishUDPLiteChecksum(sum), true
+}
+
+// CalculateIPv6UDPLiteChecksum calculates UDP-Lite checksum for case
+// if L3 protocol is IPv6. Checksum covers part of packet according
+// to Coverage field. Returns false if Coverage field is illegal.
+func CalculateIPv6UDPLiteChecksum(hdr *IPv6Hdr, udp *UDPLiteHdr) (uint16, bool) {
+	length := int(SwapBytesUint16(hdr.PayloadLen))
+	covered, ok := udpLiteCoverage(udp, length)
+	if !ok {
+		return 0, false
+	}
+	sum := calculateCoveredChecksum(unsafe.Pointer(udp), covered, udp.Cksum) +
+		calculateIPv6AddrChecksum(hdr) +
+		uint32(hdr.Proto) +
+		uint32(length)
+	return finishUDPLiteChecksum(sum), true
+}
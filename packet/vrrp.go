@@ -0,0 +1,243 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// VRRPHdr is a header of VRRP advertisement (RFC 3768 and RFC 5798).
+// It is followed by virtual addresses and, in VRRPv2, by
+// authentication data.
+type VRRPHdr struct {
+	VersionType uint8  // Version (4 bits) and type (4 bits)
+	VRID        uint8  // Virtual router identifier
+	Priority    uint8  // Priority of sender
+	CountAddrs  uint8  // Number of virtual addresses
+	AdverInt    uint16 // Authentication type and advertisement interval in seconds in VRRPv2, maximum advertisement interval in centiseconds (12 bits) in VRRPv3
+	Cksum       uint16
+}
+
+// VRRP constants
+const (
+	VRRPTypeAdvertisement = 1
+	// Priority of router which owns virtual addresses
+	VRRPPriorityOwner = 255
+	// Priority of master which stops participating in virtual router
+	VRRPPriorityStop = 0
+	// Default priority of backup routers
+	VRRPPriorityDefault = 100
+	// TTL or hop limit of advertisements
+	VRRPTTL = 255
+
+	vrrpHdrLen     = 8
+	vrrpV2AuthLen  = 8
+	vrrpMaxV3Int   = 0xfff
+	vrrpIntervalV2 = time.Second
+	vrrpIntervalV3 = 10 * time.Millisecond
+)
+
+var (
+	// VRRPMulticastIPv4 is a destination address of VRRP
+	// advertisements over IPv4.
+	VRRPMulticastIPv4 = types.BytesToIPv4(224, 0, 0, 18)
+	// VRRPMulticastIPv6 is a destination address of VRRP
+	// advertisements over IPv6.
+	VRRPMulticastIPv6 = types.IPv6Address{0xff, 0x02, 15: 0x12}
+)
+
+// VRRPAdvertisement is a parsed VRRP advertisement. VRRPv2
+// advertisements have only IPv4 addresses.
+type VRRPAdvertisement struct {
+	Version  uint8
+	VRID     uint8
+	Priority uint8
+	// Advertisement interval with precision of seconds in VRRPv2 and
+	// centiseconds in VRRPv3
+	Interval  time.Duration
+	IPv4Addrs []types.IPv4Address
+	IPv6Addrs []types.IPv6Address
+}
+
+func (hdr *VRRPHdr) String() string {
+	return fmt.Sprintf("VRRP: version = %d, type = %d, VRID = %d, priority = %d, addresses = %d",
+		hdr.VersionType>>4, hdr.VersionType&0x0f, hdr.VRID, hdr.Priority, hdr.CountAddrs)
+}
+
+// VRRPVirtualMAC returns virtual MAC address of virtual router, IPv4
+// and IPv6 virtual routers with the same identifier have different
+// addresses.
+func VRRPVirtualMAC(vrid uint8, ipv6 bool) types.MACAddress {
+	if ipv6 {
+		return types.MACAddress{0x00, 0x00, 0x5e, 0x00, 0x02, vrid}
+	}
+	return types.MACAddress{0x00, 0x00, 0x5e, 0x00, 0x01, vrid}
+}
+
+// ParseVRRP fills adv from VRRP advertisement sent over IPv4 or IPv6.
+// Checksum isn't verified. Returns false if advertisement is malformed
+// or has unsupported version.
+func ParseVRRP(data []byte, ipv6 bool, adv *VRRPAdvertisement) bool {
+	*adv = VRRPAdvertisement{}
+	if len(data) < vrrpHdrLen || data[0]&0x0f != VRRPTypeAdvertisement {
+		return false
+	}
+	adv.Version = data[0] >> 4
+	adv.VRID = data[1]
+	adv.Priority = data[2]
+	count := int(data[3])
+	addrLen := types.IPv4AddrLen
+	if ipv6 {
+		addrLen = types.IPv6AddrLen
+	}
+	switch {
+	case adv.Version == 2 && !ipv6:
+		adv.Interval = time.Duration(data[5]) * vrrpIntervalV2
+	case adv.Version == 3:
+		adv.Interval = time.Duration(uint(data[4]&0x0f)<<8|uint(data[5])) * vrrpIntervalV3
+	default:
+		return false
+	}
+	if len(data) < vrrpHdrLen+count*addrLen {
+		return false
+	}
+	for i := 0; i < count; i++ {
+		addr := data[vrrpHdrLen+i*addrLen:]
+		if ipv6 {
+			var a types.IPv6Address
+			copy(a[:], addr)
+			adv.IPv6Addrs = append(adv.IPv6Addrs, a)
+		} else {
+			adv.IPv4Addrs = append(adv.IPv4Addrs, types.SliceToIPv4(addr))
+		}
+	}
+	return true
+}
+
+// AppendTo appends VRRP advertisement with zero checksum to b. VRRPv2
+// advertisement has empty authentication data. Addresses of one IP
+// version should be set. Interval is rounded down and limited by
+// maximum interval of version.
+func (adv *VRRPAdvertisement) AppendTo(b []byte) []byte {
+	count := len(adv.IPv4Addrs) + len(adv.IPv6Addrs)
+	var interval uint
+	if adv.Version == 2 {
+		interval = uint(adv.Interval / vrrpIntervalV2)
+		if interval > 0xff {
+			interval = 0xff
+		}
+	} else {
+		interval = uint(adv.Interval / vrrpIntervalV3)
+		if interval > vrrpMaxV3Int {
+			interval = vrrpMaxV3Int
+		}
+	}
+	b = append(b, adv.Version<<4|VRRPTypeAdvertisement, adv.VRID, adv.Priority, uint8(count),
+		uint8(interval>>8), uint8(interval), 0, 0)
+	for _, a := range adv.IPv4Addrs {
+		addr := types.IPv4ToBytes(a)
+		b = append(b, addr[:]...)
+	}
+	for i := range adv.IPv6Addrs {
+		b = append(b, adv.IPv6Addrs[i][:]...)
+	}
+	if adv.Version == 2 {
+		b = append(b, make([]byte, vrrpV2AuthLen)...)
+	}
+	return b
+}
+
+// vrrpChecksum returns checksum of VRRP advertisement which is
+// calculated with pseudo header in VRRPv3.
+func vrrpChecksum(l3 unsafe.Pointer, ipv4 bool, data []byte) uint16 {
+	if data[0]>>4 == 2 {
+		return multicastChecksum(data, 0)
+	}
+	return multicastChecksum(data, pseudoHdrChecksum(l3, ipv4, types.VRRPNumber, uint(len(data))))
+}
+
+// GetVRRP returns VRRP advertisement of IPv4 or IPv6 packet or nil if
+// packet isn't VRRP packet, its TTL or hop limit isn't 255 or its
+// checksum is wrong. L3 header should be parsed. Advertisement is a
+// slice of the first segment of packet.
+func (packet *Packet) GetVRRP() []byte {
+	var data []byte
+	ipv4 := packet.GetIPv4()
+	if ipv4 != nil {
+		if ipv4.NextProtoID != types.VRRPNumber || ipv4.TimeToLive != VRRPTTL ||
+			SwapBytesUint16(ipv4.FragmentOffset)&(types.IPv4MoreFragments|types.IPv4FragmentOffsetMask) != 0 {
+			return nil
+		}
+		data = l3Payload(packet, uint(ipv4.VersionIhl&0x0f)<<2, uint(SwapBytesUint16(ipv4.TotalLength)))
+	} else if ipv6 := packet.GetIPv6(); ipv6 != nil {
+		if ipv6.Proto != types.VRRPNumber || ipv6.HopLimits != VRRPTTL {
+			return nil
+		}
+		data = l3Payload(packet, types.IPv6Len, types.IPv6Len+uint(SwapBytesUint16(ipv6.PayloadLen)))
+	}
+	if len(data) < vrrpHdrLen || vrrpChecksum(packet.L3, ipv4 != nil, data) != 0 {
+		return nil
+	}
+	return data
+}
+
+// InitVRRPPacket initializes empty packet as VRRP advertisement over
+// IPv4 from srcIP. Source MAC address is a virtual MAC address of
+// virtual router. Returns false if packet can't be initialized.
+func InitVRRPPacket(packet *Packet, srcIP types.IPv4Address, adv *VRRPAdvertisement) bool {
+	frame := make([]byte, types.EtherLen+types.IPv4MinLen, 128)
+	copy(frame, []byte{0x01, 0x00, 0x5e, 0x00, 0x00, 0x12})
+	mac := VRRPVirtualMAC(adv.VRID, false)
+	copy(frame[types.EtherAddrLen:], mac[:])
+	frame[12], frame[13] = types.IPV4Number>>8, types.IPV4Number&0xff
+	frame = adv.AppendTo(frame)
+	ipv4 := (*IPv4Hdr)(unsafe.Pointer(&frame[types.EtherLen]))
+	*ipv4 = IPv4Hdr{
+		VersionIhl:    0x45,
+		TypeOfService: 0xc0,
+		TotalLength:   SwapBytesUint16(uint16(len(frame) - types.EtherLen)),
+		TimeToLive:    VRRPTTL,
+		NextProtoID:   types.VRRPNumber,
+		SrcAddr:       srcIP,
+		DstAddr:       VRRPMulticastIPv4,
+	}
+	ipv4.HdrChecksum = SwapBytesUint16(CalculateIPv4Checksum(ipv4))
+	data := frame[types.EtherLen+types.IPv4MinLen:]
+	cksum := vrrpChecksum(unsafe.Pointer(ipv4), true, data)
+	data[6], data[7] = uint8(cksum>>8), uint8(cksum)
+	return GeneratePacketFromByte(packet, frame)
+}
+
+// InitVRRPv6Packet initializes empty packet as VRRPv3 advertisement
+// over IPv6 from link local srcIP. Source MAC address is a virtual MAC
+// address of virtual router. Returns false if packet can't be
+// initialized.
+func InitVRRPv6Packet(packet *Packet, srcIP types.IPv6Address, adv *VRRPAdvertisement) bool {
+	frame := make([]byte, types.EtherLen+types.IPv6Len, 256)
+	var dstMAC types.MACAddress
+	CalculateIPv6BroadcastMACForDstMulticastIP(&dstMAC, VRRPMulticastIPv6)
+	copy(frame, dstMAC[:])
+	mac := VRRPVirtualMAC(adv.VRID, true)
+	copy(frame[types.EtherAddrLen:], mac[:])
+	frame[12], frame[13] = types.IPV6Number>>8, types.IPV6Number&0xff
+	frame = adv.AppendTo(frame)
+	data := frame[types.EtherLen+types.IPv6Len:]
+	ipv6 := (*IPv6Hdr)(unsafe.Pointer(&frame[types.EtherLen]))
+	*ipv6 = IPv6Hdr{
+		VtcFlow:    SwapBytesUint32(6<<28 | 0xc0<<20),
+		PayloadLen: SwapBytesUint16(uint16(len(data))),
+		Proto:      types.VRRPNumber,
+		HopLimits:  VRRPTTL,
+		SrcAddr:    srcIP,
+		DstAddr:    VRRPMulticastIPv6,
+	}
+	cksum := vrrpChecksum(unsafe.Pointer(ipv6), false, data)
+	data[6], data[7] = uint8(cksum>>8), uint8(cksum)
+	return GeneratePacketFromByte(packet, frame)
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+var testVRRPAdvertisement = VRRPAdvertisement{
+	Version:   3,
+	VRID:      7,
+	Priority:  VRRPPriorityDefault,
+	Interval:  250 * time.Millisecond,
+	IPv4Addrs: []types.IPv4Address{types.BytesToIPv4(192, 0, 2, 254), types.BytesToIPv4(192, 0, 2, 253)},
+}
+
+func TestVRRPAppendParse(t *testing.T) {
+	v2 := testVRRPAdvertisement
+	v2.Version = 2
+	v2.Interval = 3 * time.Second
+	v6 := VRRPAdvertisement{
+		Version:   3,
+		VRID:      7,
+		Priority:  VRRPPriorityOwner,
+		Interval:  time.Second,
+		IPv6Addrs: []types.IPv6Address{{0xfe, 0x80, 15: 0x01}},
+	}
+	for _, adv := range []VRRPAdvertisement{testVRRPAdvertisement, v2, v6} {
+		data := adv.AppendTo(nil)
+		var parsed VRRPAdvertisement
+		if !ParseVRRP(data, adv.IPv6Addrs != nil, &parsed) {
+			t.Errorf("Can't parse VRRP advertisement %x", data)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, adv) {
+			t.Errorf("Parsed %+v instead of %+v", parsed, adv)
+		}
+		if ParseVRRP(data[:vrrpHdrLen+1], adv.IPv6Addrs != nil, &parsed) {
+			t.Errorf("Truncated VRRP advertisement %x was parsed", data[:vrrpHdrLen+1])
+		}
+	}
+	// VRRPv2 over IPv6 doesn't exist
+	if ParseVRRP(v2.AppendTo(nil), true, &VRRPAdvertisement{}) {
+		t.Error("VRRPv2 advertisement over IPv6 was parsed")
+	}
+}
+
+func TestVRRPPacket(t *testing.T) {
+	srcIP := types.BytesToIPv4(192, 0, 2, 1)
+	for _, version := range []uint8{2, 3} {
+		adv := testVRRPAdvertisement
+		adv.Version = version
+		adv.Interval = time.Second
+		pkt := getPacket()
+		if !InitVRRPPacket(pkt, srcIP, &adv) {
+			t.Fatal("InitVRRPPacket failed")
+		}
+		pkt.ParseL3()
+		if pkt.Ether.SAddr != VRRPVirtualMAC(adv.VRID, false) || pkt.GetIPv4().DstAddr != VRRPMulticastIPv4 {
+			t.Errorf("Wrong VRRP packet %x", pkt.GetRawPacketBytes())
+		}
+		var parsed VRRPAdvertisement
+		if !ParseVRRP(pkt.GetVRRP(), false, &parsed) || !reflect.DeepEqual(parsed, adv) {
+			t.Errorf("Can't parse VRRPv%d packet %x", version, pkt.GetRawPacketBytes())
+		}
+		pkt.GetIPv4().SrcAddr++
+		if version == 3 && pkt.GetVRRP() != nil {
+			t.Error("VRRPv3 advertisement with wrong pseudo header checksum was returned")
+		}
+	}
+
+	srcIPv6 := types.IPv6Address{0xfe, 0x80, 15: 0x01}
+	adv := VRRPAdvertisement{Version: 3, VRID: 7, Priority: VRRPPriorityDefault, Interval: time.Second,
+		IPv6Addrs: []types.IPv6Address{{0xfe, 0x80, 15: 0xfe}}}
+	pkt := getPacket()
+	if !InitVRRPv6Packet(pkt, srcIPv6, &adv) {
+		t.Fatal("InitVRRPv6Packet failed")
+	}
+	pkt.ParseL3()
+	var parsed VRRPAdvertisement
+	if !ParseVRRP(pkt.GetVRRP(), true, &parsed) || !reflect.DeepEqual(parsed, adv) {
+		t.Errorf("Can't parse IPv6 VRRP packet %x", pkt.GetRawPacketBytes())
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pktfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+type opcode uint8
+
+const (
+	opAccept    opcode = iota
+	opReject           // Terminal instructions
+	opEtherType        // EtherType after VLAN tags equals val
+	opVLAN             // Packet has VLAN tag val or any tag
+	opProto            // IP protocol after extension headers equals val
+	opEtherHost        // Ethernet address equals addr[:6]
+	opNet4             // IPv4 address masked by mask equals val
+	opNet6             // IPv6 address has prefix of length prefix of addr
+	opPort             // TCP, UDP or SCTP port is between lo and hi
+	opLen              // Packet length compared with val
+	opLoad             // Loaded value masked by mask compared with val
+)
+
+// Where loaded values are taken from
+type layer uint8
+
+const (
+	baseEther layer = iota
+	baseL3
+	baseL4
+)
+
+type direction uint8
+
+const (
+	dirSrcOrDst direction = iota
+	dirSrc
+	dirDst
+	dirSrcAndDst
+)
+
+func (d direction) match(src, dst bool) bool {
+	switch d {
+	case dirSrc:
+		return src
+	case dirDst:
+		return dst
+	case dirSrcAndDst:
+		return src && dst
+	}
+	return src || dst
+}
+
+type relation uint8
+
+const (
+	relEq relation = iota
+	relNe
+	relLt
+	relLe
+	relGt
+	relGe
+)
+
+func (r relation) compare(a, b uint32) bool {
+	switch r {
+	case relNe:
+		return a != b
+	case relLt:
+		return a < b
+	case relLe:
+		return a <= b
+	case relGt:
+		return a > b
+	case relGe:
+		return a >= b
+	}
+	return a == b
+}
+
+// anyVLAN is a value of opVLAN which matches any tag
+const anyVLAN = 0xffffffff
+
+// insn is an instruction of filter program. Test of instruction is
+// defined by op, other fields are its operands. Program continues from
+// jt if test is true and from jf otherwise.
+type insn struct {
+	op     opcode
+	dir    direction
+	base   layer
+	rel    relation
+	size   uint8
+	prefix uint8
+	off    uint16
+	lo, hi uint16
+	val    uint32
+	mask   uint32
+	addr   [types.IPv6AddrLen]byte
+	jt, jf int
+}
+
+// generate compiles expression tree to program which starts at index 0
+// and ends with accept and reject instructions.
+func generate(root *node) []insn {
+	if root.kind == nodeTrue {
+		return []insn{{op: opAccept}}
+	}
+	prog := []insn{{op: opAccept}, {op: opReject}}
+	emit(&prog, root, 0, 1)
+	// Program was built from its end, reverse it so that entry is the
+	// first instruction and branches go forward.
+	last := len(prog) - 1
+	for i := 0; i < len(prog)/2; i++ {
+		prog[i], prog[last-i] = prog[last-i], prog[i]
+	}
+	for i := range prog {
+		prog[i].jt = last - prog[i].jt
+		prog[i].jf = last - prog[i].jf
+	}
+	return prog
+}
+
+// emit appends instructions of n to program which is built from its
+// end. Instructions t and f are executed when n is true or false.
+// Returns index of entry of n which is always the last instruction.
+func emit(prog *[]insn, n *node, t, f int) int {
+	switch n.kind {
+	case nodeAnd:
+		return emit(prog, n.a, emit(prog, n.b, t, f), f)
+	case nodeOr:
+		return emit(prog, n.a, t, emit(prog, n.b, t, f))
+	case nodeNot:
+		return emit(prog, n.a, f, t)
+	}
+	ins := n.test
+	ins.jt, ins.jf = t, f
+	*prog = append(*prog, ins)
+	return len(*prog) - 1
+}
+
+const (
+	maxVLANs        = 4
+	maxIPv6Headers  = 8
+	ipv6AuthNumber  = 0x33
+	qinqNumber      = 0x88a8
+	oldQinQNumber   = 0x9100
+	ipv4FragOffMask = 0x1fff
+	ipv6FragOffMask = 0xfff8
+)
+
+// frame is a packet with decoded offsets of headers.
+type frame struct {
+	data      []byte
+	etherType uint16
+	vlans     [maxVLANs]uint16
+	nvlans    int
+	// Offsets of L3 and L4 headers, -1 if headers are absent or
+	// truncated
+	l3, l4 int
+	// Protocol of the last IPv6 extension header or IPv4 protocol,
+	// valid if hasProto is true
+	proto    uint8
+	hasProto bool
+}
+
+func (fr *frame) decode(data []byte) {
+	fr.data = data
+	fr.l3, fr.l4 = -1, -1
+	if len(data) < types.EtherLen {
+		return
+	}
+	off := types.EtherLen - 2
+	t := binary.BigEndian.Uint16(data[off:])
+	for (t == types.VLANNumber || t == qinqNumber || t == oldQinQNumber) && fr.nvlans < maxVLANs && off+6 <= len(data) {
+		fr.vlans[fr.nvlans] = binary.BigEndian.Uint16(data[off+2:]) & 0xfff
+		fr.nvlans++
+		off += 4
+		t = binary.BigEndian.Uint16(data[off:])
+	}
+	fr.etherType = t
+	l3 := off + 2
+	switch t {
+	case types.IPV4Number:
+		if l3+types.IPv4MinLen > len(data) || data[l3]>>4 != 4 {
+			return
+		}
+		ihl := int(data[l3]&0x0f) * 4
+		if ihl < types.IPv4MinLen {
+			return
+		}
+		fr.l3 = l3
+		fr.proto = data[l3+9]
+		fr.hasProto = true
+		if binary.BigEndian.Uint16(data[l3+6:])&ipv4FragOffMask == 0 && l3+ihl <= len(data) {
+			fr.l4 = l3 + ihl
+		}
+	case types.IPV6Number:
+		if l3+types.IPv6Len > len(data) || data[l3]>>4 != 6 {
+			return
+		}
+		fr.l3 = l3
+		fr.decodeIPv6(l3)
+	}
+}
+
+// decodeIPv6 skips IPv6 extension headers and finds L4 header.
+func (fr *frame) decodeIPv6(l3 int) {
+	data := fr.data
+	next := data[l3+6]
+	off := l3 + types.IPv6Len
+	for i := 0; i < maxIPv6Headers; i++ {
+		var length int
+		switch next {
+		case types.IPv6HopByHopNumber, types.IPv6RoutingNumber, types.IPv6DestOptsNumber:
+			if off+8 > len(data) {
+				return
+			}
+			length = (int(data[off+1]) + 1) * 8
+		case ipv6AuthNumber:
+			if off+8 > len(data) {
+				return
+			}
+			length = (int(data[off+1]) + 2) * 4
+		case types.IPv6FragmentNumber:
+			if off+8 > len(data) {
+				return
+			}
+			if binary.BigEndian.Uint16(data[off+2:])&ipv6FragOffMask != 0 {
+				fr.proto = data[off]
+				fr.hasProto = true
+				return
+			}
+			length = 8
+		default:
+			fr.proto = next
+			fr.hasProto = true
+			if off <= len(data) {
+				fr.l4 = off
+			}
+			return
+		}
+		next = data[off]
+		off += length
+	}
+}
+
+// test returns result of test of instruction for frame.
+func (fr *frame) test(ins *insn) bool {
+	data := fr.data
+	switch ins.op {
+	case opEtherType:
+		return fr.etherType == uint16(ins.val)
+	case opVLAN:
+		for i := 0; i < fr.nvlans; i++ {
+			if ins.val == anyVLAN || uint32(fr.vlans[i]) == ins.val {
+				return true
+			}
+		}
+		return false
+	case opProto:
+		return fr.hasProto && fr.proto == uint8(ins.val)
+	case opEtherHost:
+		if len(data) < types.EtherLen {
+			return false
+		}
+		return ins.dir.match(bytes.Equal(data[6:12], ins.addr[:6]), bytes.Equal(data[0:6], ins.addr[:6]))
+	case opNet4:
+		if fr.l3 < 0 || fr.etherType != types.IPV4Number {
+			return false
+		}
+		src := binary.BigEndian.Uint32(data[fr.l3+12:])
+		dst := binary.BigEndian.Uint32(data[fr.l3+16:])
+		return ins.dir.match(src&ins.mask == ins.val, dst&ins.mask == ins.val)
+	case opNet6:
+		if fr.l3 < 0 || fr.etherType != types.IPV6Number {
+			return false
+		}
+		return ins.dir.match(hasPrefix(data[fr.l3+8:], &ins.addr, ins.prefix), hasPrefix(data[fr.l3+24:], &ins.addr, ins.prefix))
+	case opPort:
+		if fr.l4 < 0 || fr.l4+4 > len(data) ||
+			(fr.proto != types.TCPNumber && fr.proto != types.UDPNumber && fr.proto != types.SCTPNumber) {
+			return false
+		}
+		src := binary.BigEndian.Uint16(data[fr.l4:])
+		dst := binary.BigEndian.Uint16(data[fr.l4+2:])
+		return ins.dir.match(src >= ins.lo && src <= ins.hi, dst >= ins.lo && dst <= ins.hi)
+	case opLen:
+		return ins.rel.compare(uint32(len(data)), ins.val)
+	case opLoad:
+		base := 0
+		switch ins.base {
+		case baseL3:
+			base = fr.l3
+		case baseL4:
+			base = fr.l4
+		}
+		off := base + int(ins.off)
+		if base < 0 || off+int(ins.size) > len(data) {
+			return false
+		}
+		var v uint32
+		switch ins.size {
+		case 1:
+			v = uint32(data[off])
+		case 2:
+			v = uint32(binary.BigEndian.Uint16(data[off:]))
+		default:
+			v = binary.BigEndian.Uint32(data[off:])
+		}
+		return ins.rel.compare(v&ins.mask, ins.val)
+	}
+	return false
+}
+
+// hasPrefix returns true if first prefix bits of IPv6 address a are
+// equal to bits of p.
+func hasPrefix(a []byte, p *[types.IPv6AddrLen]byte, prefix uint8) bool {
+	n := int(prefix / 8)
+	if !bytes.Equal(a[:n], p[:n]) {
+		return false
+	}
+	if rem := prefix % 8; rem != 0 {
+		mask := byte(0xff << (8 - rem))
+		return a[n]&mask == p[n]
+	}
+	return true
+}
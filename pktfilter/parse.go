@@ -0,0 +1,540 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pktfilter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+type nodeKind uint8
+
+const (
+	nodeTest nodeKind = iota
+	nodeAnd
+	nodeOr
+	nodeNot
+	nodeTrue
+)
+
+// node is a node of expression tree. Leaves are tests which are
+// compiled to one instruction.
+type node struct {
+	kind nodeKind
+	a, b *node
+	test insn
+}
+
+func and(a, b *node) *node {
+	return &node{kind: nodeAnd, a: a, b: b}
+}
+
+func or(a, b *node) *node {
+	return &node{kind: nodeOr, a: a, b: b}
+}
+
+func leaf(test insn) *node {
+	return &node{kind: nodeTest, test: test}
+}
+
+func etherType(t uint16) *node {
+	return leaf(insn{op: opEtherType, val: uint32(t)})
+}
+
+func ipProto(p uint8) *node {
+	return leaf(insn{op: opProto, val: uint32(p)})
+}
+
+// IP protocols which can be used as primitives
+var protocols = map[string]uint8{
+	"tcp":   types.TCPNumber,
+	"udp":   types.UDPNumber,
+	"sctp":  types.SCTPNumber,
+	"icmp":  types.ICMPNumber,
+	"icmp6": types.ICMPv6Number,
+	"gre":   types.GRENumber,
+}
+
+// Named constants of loads
+var constants = map[string]uint32{
+	"tcpflags":        13,
+	"tcp-fin":         0x01,
+	"tcp-syn":         0x02,
+	"tcp-rst":         0x04,
+	"tcp-push":        0x08,
+	"tcp-ack":         0x10,
+	"tcp-urg":         0x20,
+	"icmptype":        0,
+	"icmpcode":        1,
+	"icmp-echoreply":  0,
+	"icmp-unreach":    3,
+	"icmp-echo":       8,
+	"icmp-timxceed":   11,
+	"icmp6type":       0,
+	"icmp6code":       1,
+	"icmp6-echo":      128,
+	"icmp6-echoreply": 129,
+}
+
+var relations = map[string]relation{
+	"=":  relEq,
+	"==": relEq,
+	"!=": relNe,
+	"<":  relLt,
+	"<=": relLe,
+	">":  relGt,
+	">=": relGe,
+}
+
+type token struct {
+	text string
+	pos  int
+}
+
+func isWordChar(c byte, inBrackets bool) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '.' || c == '_' || c == '-' || c == '/' || (c == ':' && !inBrackets)
+}
+
+// tokenize splits expression to words and operators. Colons are parts
+// of words like IPv6 and Ethernet addresses except inside brackets
+// where they separate offset and size.
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	depth := 0
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			i++
+			continue
+		}
+		if isWordChar(c, depth > 0) {
+			start := i
+			for i < len(expr) && isWordChar(expr[i], depth > 0) {
+				i++
+			}
+			tokens = append(tokens, token{expr[start:i], start})
+			continue
+		}
+		n := 1
+		if i+1 < len(expr) {
+			switch expr[i : i+2] {
+			case "==", "!=", "<=", ">=", "&&", "||":
+				n = 2
+			}
+		}
+		switch op := expr[i : i+n]; op {
+		case "[":
+			depth++
+		case "]":
+			depth--
+		case "(", ")", "!", "=", "==", "!=", "<", "<=", ">", ">=", "&", "&&", "||", ":":
+		default:
+			return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+		}
+		tokens = append(tokens, token{expr[i : i+n], i})
+		i += n
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse parses expression to tree.
+func parse(expr string) (*node, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return &node{kind: nodeTrue}, nil
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = p.errorf("unexpected %q", p.peek())
+	}
+	return n, err
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].text
+	}
+	return ""
+}
+
+func (p *parser) peekAt(i int) string {
+	if p.pos+i < len(p.tokens) {
+		return p.tokens[p.pos+i].text
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	s := p.peek()
+	p.pos++
+	return s
+}
+
+func (p *parser) accept(s string) bool {
+	if p.peek() == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// errorf returns error at current token.
+func (p *parser) errorf(format string, args ...interface{}) error {
+	where := "at end of expression"
+	if p.pos < len(p.tokens) {
+		where = "at offset " + strconv.Itoa(p.tokens[p.pos].pos)
+	}
+	return fmt.Errorf(format+" "+where, args...)
+}
+
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		return p.errorf("expected %q", s)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (*node, error) {
+	n, err := p.parseAnd()
+	for err == nil && (p.peek() == "or" || p.peek() == "||") {
+		p.next()
+		var m *node
+		if m, err = p.parseAnd(); err == nil {
+			n = or(n, m)
+		}
+	}
+	return n, err
+}
+
+func (p *parser) parseAnd() (*node, error) {
+	n, err := p.parseNot()
+	for err == nil && (p.peek() == "and" || p.peek() == "&&") {
+		p.next()
+		var m *node
+		if m, err = p.parseNot(); err == nil {
+			n = and(n, m)
+		}
+	}
+	return n, err
+}
+
+func (p *parser) parseNot() (*node, error) {
+	if p.accept("not") || p.accept("!") {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nodeNot, a: n}, nil
+	}
+	return p.parsePrimitive()
+}
+
+func (p *parser) parsePrimitive() (*node, error) {
+	switch word := p.peek(); word {
+	case "":
+		return nil, p.errorf("expected primitive")
+	case "(":
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case "ether":
+		p.next()
+		return p.parseEther()
+	case "vlan":
+		p.next()
+		if p.peek() == "" || !isNumber(p.peek()) {
+			return leaf(insn{op: opVLAN, val: anyVLAN}), nil
+		}
+		id, err := p.number(0xfff)
+		return leaf(insn{op: opVLAN, val: id}), err
+	case "arp":
+		p.next()
+		return etherType(types.ARPNumber), nil
+	case "ip", "ip6":
+		p.next()
+		return p.parseIP(word)
+	case "tcp", "udp", "sctp", "icmp", "icmp6", "gre":
+		p.next()
+		return p.parseTransport(word)
+	case "proto":
+		p.next()
+		proto, err := p.number(0xff)
+		return ipProto(uint8(proto)), err
+	case "len":
+		p.next()
+		rel, err := p.relation()
+		if err != nil {
+			return nil, err
+		}
+		n, err := p.number(0xffffffff)
+		return leaf(insn{op: opLen, rel: rel, val: n}), err
+	case "less", "greater":
+		p.next()
+		n, err := p.number(0xffffffff)
+		rel := relLe
+		if word == "greater" {
+			rel = relGe
+		}
+		return leaf(insn{op: opLen, rel: rel, val: n}), err
+	case "src", "dst", "host", "net", "port", "portrange":
+		return p.parseQualified(0, true, true)
+	}
+	return nil, p.errorf("unknown primitive %q", p.peek())
+}
+
+// parseDir parses optional direction qualifier.
+func (p *parser) parseDir() direction {
+	switch p.peek() {
+	case "src":
+		p.next()
+		if (p.peek() == "or" || p.peek() == "and") && p.peekAt(1) == "dst" {
+			dir := dirSrcOrDst
+			if p.next() == "and" {
+				dir = dirSrcAndDst
+			}
+			p.next()
+			return dir
+		}
+		return dirSrc
+	case "dst":
+		p.next()
+		return dirDst
+	}
+	return dirSrcOrDst
+}
+
+// parseQualified parses [dir] host|net|port|portrange ID. Addresses
+// of host and net should belong to family if it isn't zero.
+func (p *parser) parseQualified(family uint16, allowPort, allowAddr bool) (*node, error) {
+	hasDir := p.peek() == "src" || p.peek() == "dst"
+	dir := p.parseDir()
+	switch kind := p.peek(); kind {
+	case "host", "net":
+		if allowAddr {
+			p.next()
+			return p.parseNet(dir, family, kind == "host")
+		}
+	case "port", "portrange":
+		if allowPort {
+			p.next()
+			return p.parsePort(dir, kind == "portrange")
+		}
+	default:
+		// Address after direction is a host
+		if hasDir && allowAddr && p.peek() != "" {
+			return p.parseNet(dir, family, true)
+		}
+	}
+	return nil, p.errorf("unexpected %q", p.peek())
+}
+
+func (p *parser) parseNet(dir direction, family uint16, host bool) (*node, error) {
+	s := p.peek()
+	var ip net.IP
+	var ipnet *net.IPNet
+	if strings.Contains(s, "/") && !host {
+		var err error
+		if ip, ipnet, err = net.ParseCIDR(s); err != nil {
+			return nil, p.errorf("invalid network %q", s)
+		}
+		if !ip.Equal(ipnet.IP) {
+			return nil, p.errorf("non-network bits set in %q", s)
+		}
+	} else if ip = net.ParseIP(s); ip == nil {
+		return nil, p.errorf("invalid address %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil && !strings.Contains(s, ":") {
+		if family == types.IPV6Number {
+			return nil, p.errorf("IPv4 address %q in IPv6 primitive", s)
+		}
+		mask := uint32(0xffffffff)
+		if ipnet != nil {
+			mask = binary.BigEndian.Uint32(ipnet.Mask)
+		}
+		p.next()
+		return leaf(insn{op: opNet4, dir: dir, val: binary.BigEndian.Uint32(ip4), mask: mask}), nil
+	}
+	if family == types.IPV4Number {
+		return nil, p.errorf("IPv6 address %q in IPv4 primitive", s)
+	}
+	ins := insn{op: opNet6, dir: dir, prefix: 128}
+	copy(ins.addr[:], ip.To16())
+	if ipnet != nil {
+		ones, _ := ipnet.Mask.Size()
+		ins.prefix = uint8(ones)
+	}
+	p.next()
+	return leaf(ins), nil
+}
+
+func (p *parser) parsePort(dir direction, isRange bool) (*node, error) {
+	s := p.peek()
+	lo, hi := s, s
+	if isRange {
+		i := strings.IndexByte(s, '-')
+		if i < 0 {
+			return nil, p.errorf("invalid port range %q", s)
+		}
+		lo, hi = s[:i], s[i+1:]
+	}
+	l, err1 := strconv.ParseUint(lo, 10, 16)
+	h, err2 := strconv.ParseUint(hi, 10, 16)
+	if err1 != nil || err2 != nil || l > h {
+		return nil, p.errorf("invalid port %q", s)
+	}
+	p.next()
+	return leaf(insn{op: opPort, dir: dir, lo: uint16(l), hi: uint16(h)}), nil
+}
+
+func (p *parser) parseEther() (*node, error) {
+	switch p.peek() {
+	case "proto":
+		p.next()
+		t, err := p.number(0xffff)
+		return etherType(uint16(t)), err
+	case "[":
+		return p.parseLoad(baseEther)
+	case "src", "dst", "host":
+		dir := p.parseDir()
+		p.accept("host")
+		s := p.peek()
+		mac, err := net.ParseMAC(s)
+		if err != nil || len(mac) != types.EtherAddrLen {
+			return nil, p.errorf("invalid Ethernet address %q", s)
+		}
+		p.next()
+		ins := insn{op: opEtherHost, dir: dir}
+		copy(ins.addr[:], mac)
+		return leaf(ins), nil
+	}
+	return nil, p.errorf("expected proto, host, src, dst or [ after ether")
+}
+
+func (p *parser) parseIP(word string) (*node, error) {
+	family := uint16(types.IPV4Number)
+	if word == "ip6" {
+		family = types.IPV6Number
+	}
+	n := etherType(family)
+	switch p.peek() {
+	case "[":
+		m, err := p.parseLoad(baseL3)
+		return and(n, m), err
+	case "proto":
+		p.next()
+		proto, err := p.number(0xff)
+		return and(n, ipProto(uint8(proto))), err
+	case "src", "dst", "host", "net":
+		m, err := p.parseQualified(family, false, true)
+		return and(n, m), err
+	}
+	return n, nil
+}
+
+func (p *parser) parseTransport(word string) (*node, error) {
+	n := ipProto(protocols[word])
+	switch word {
+	case "icmp":
+		n = and(etherType(types.IPV4Number), n)
+	case "icmp6":
+		n = and(etherType(types.IPV6Number), n)
+	}
+	switch p.peek() {
+	case "[":
+		m, err := p.parseLoad(baseL4)
+		return and(n, m), err
+	case "src", "dst", "port", "portrange":
+		if word == "tcp" || word == "udp" || word == "sctp" {
+			m, err := p.parseQualified(0, true, false)
+			return and(n, m), err
+		}
+	}
+	return n, nil
+}
+
+// parseLoad parses [OFFSET[:SIZE]] [& MASK] RELOP VALUE.
+func (p *parser) parseLoad(base layer) (*node, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	off, err := p.number(0xffff)
+	if err != nil {
+		return nil, err
+	}
+	ins := insn{op: opLoad, base: base, off: uint16(off), size: 1, mask: 0xffffffff}
+	if p.accept(":") {
+		size, err := p.number(4)
+		if err != nil {
+			return nil, err
+		}
+		if size != 1 && size != 2 && size != 4 {
+			return nil, p.errorf("size should be 1, 2 or 4")
+		}
+		ins.size = uint8(size)
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	if p.accept("&") {
+		if ins.mask, err = p.number(0xffffffff); err != nil {
+			return nil, err
+		}
+	}
+	if ins.rel, err = p.relation(); err != nil {
+		return nil, err
+	}
+	ins.val, err = p.number(0xffffffff)
+	return leaf(ins), err
+}
+
+func (p *parser) relation() (relation, error) {
+	rel, ok := relations[p.peek()]
+	if !ok {
+		return 0, p.errorf("expected comparison")
+	}
+	p.next()
+	return rel, nil
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseUint(s, 0, 32)
+	return err == nil
+}
+
+// number parses number or named constant which is not greater than max.
+func (p *parser) number(max uint32) (uint32, error) {
+	s := p.peek()
+	v, ok := constants[s]
+	if !ok {
+		n, err := strconv.ParseUint(s, 0, 32)
+		if err != nil {
+			return 0, p.errorf("expected number")
+		}
+		v = uint32(n)
+	}
+	if v > max {
+		return 0, p.errorf("%d is too large", v)
+	}
+	p.next()
+	return v, nil
+}
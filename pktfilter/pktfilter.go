@@ -0,0 +1,88 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pktfilter compiles filter expressions in tcpdump-like syntax
+// to programs which match raw packet data. It is a common expression
+// engine of features which select packets, for example packet tracer
+// of flow package or captures and intercept targets of applications.
+//
+// Expression consists of primitives combined by "and" ("&&"), "or"
+// ("||"), "not" ("!") and parentheses. "and" has higher priority
+// than "or". Supported primitives are
+//
+//	ether [src|dst] host MAC     ether proto N
+//	vlan [ID]                    arp
+//	ip, ip6                      ip proto N, ip6 proto N, proto N
+//	tcp, udp, sctp, icmp, icmp6, gre
+//	[src|dst] host ADDR          [src|dst] net ADDR/LEN
+//	[src|dst] port N             [src|dst] portrange N-M
+//	len RELOP N, less N, greater N
+//	PROTO[OFFSET[:SIZE]] [& MASK] RELOP N
+//
+// Direction can also be "src or dst" (default) and "src and dst".
+// Addresses may be IPv4 or IPv6. Host, net and port primitives can be
+// prefixed by protocol, for example "ip6 net 2001:db8::/32" or "tcp dst
+// port 80". Last primitive loads SIZE bytes (1, 2 or 4) in network
+// byte order from OFFSET of ether, ip, ip6, tcp, udp, sctp, icmp,
+// icmp6 or gre header, for example "tcp[tcpflags] & tcp-syn != 0".
+// Constants tcpflags, tcp-fin, tcp-syn, tcp-rst, tcp-push, tcp-ack,
+// tcp-urg, icmptype, icmpcode, icmp-echoreply, icmp-unreach,
+// icmp-echo, icmp-timxceed, icmp6type, icmp6code, icmp6-echo and
+// icmp6-echoreply can be used instead of numbers.
+//
+// Unlike tcpdump, VLAN tags are skipped before L3 header, so "ip"
+// matches tagged IPv4 packets too. Port and L4 header primitives don't
+// match non-first fragments. Empty expression matches all packets.
+//
+// Expression is compiled to a flat program of tests with true and false
+// branches like classic BPF. Branches only go forward, so programs
+// always terminate, and packet headers are decoded once per match.
+package pktfilter
+
+import (
+	"github.com/intel-go/nff-go/common"
+)
+
+// Filter is a compiled filter expression. Filter can be used by
+// several goroutines simultaneously.
+type Filter struct {
+	expr string
+	prog []insn
+}
+
+// Compile parses filter expression and compiles it to program.
+func Compile(expr string) (*Filter, error) {
+	root, err := parse(expr)
+	if err != nil {
+		return nil, common.WrapWithNFError(err, "Invalid filter expression: "+err.Error(), common.BadArgument)
+	}
+	return &Filter{expr: expr, prog: generate(root)}, nil
+}
+
+// String returns source expression of filter.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match returns true if packet which starts with Ethernet header
+// matches filter. Truncated headers don't match primitives which need
+// them.
+func (f *Filter) Match(data []byte) bool {
+	var fr frame
+	fr.decode(data)
+	for pc := 0; ; {
+		ins := &f.prog[pc]
+		switch ins.op {
+		case opAccept:
+			return true
+		case opReject:
+			return false
+		}
+		if fr.test(ins) {
+			pc = ins.jt
+		} else {
+			pc = ins.jf
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pktfilter
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+var (
+	srcMAC = []byte{0x02, 0, 0, 0, 0, 0x01}
+	dstMAC = []byte{0x02, 0, 0, 0, 0, 0x02}
+)
+
+// ether returns Ethernet header with given VLAN tags.
+func ether(etherType uint16, vlans ...uint16) []byte {
+	b := append(append([]byte(nil), dstMAC...), srcMAC...)
+	for _, v := range vlans {
+		b = append(b, 0x81, 0x00, byte(v>>8), byte(v))
+	}
+	return append(b, byte(etherType>>8), byte(etherType))
+}
+
+// l4 returns L4 header with ports followed by 16 bytes of flags.
+func l4(src, dst uint16, flags byte) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint16(b, src)
+	binary.BigEndian.PutUint16(b[2:], dst)
+	b[13] = flags
+	return b
+}
+
+func ipv4(proto byte, src, dst string, fragOff uint16, payload []byte, vlans ...uint16) []byte {
+	h := make([]byte, 20)
+	h[0] = 0x45
+	binary.BigEndian.PutUint16(h[2:], uint16(20+len(payload)))
+	binary.BigEndian.PutUint16(h[6:], fragOff)
+	h[8] = 64
+	h[9] = proto
+	copy(h[12:], net.ParseIP(src).To4())
+	copy(h[16:], net.ParseIP(dst).To4())
+	return append(append(ether(0x0800, vlans...), h...), payload...)
+}
+
+func ipv6(next byte, src, dst string, payload []byte) []byte {
+	h := make([]byte, 40)
+	h[0] = 0x60
+	binary.BigEndian.PutUint16(h[4:], uint16(len(payload)))
+	h[6] = next
+	h[7] = 64
+	copy(h[8:], net.ParseIP(src))
+	copy(h[24:], net.ParseIP(dst))
+	return append(append(ether(0x86dd), h...), payload...)
+}
+
+func cat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+var (
+	tcpSyn   = ipv4(6, "10.0.0.1", "192.0.2.1", 0, l4(40000, 80, 0x02))
+	udpDNS   = ipv4(17, "10.0.0.2", "198.51.100.1", 0, l4(5353, 53, 0), 100)
+	icmpEcho = ipv4(1, "10.1.0.1", "10.0.0.1", 0, []byte{8, 0, 0, 0, 0, 0, 0, 0})
+	fragment = ipv4(6, "10.0.0.1", "192.0.2.1", 100, l4(1, 80, 0))
+	tcp6     = ipv6(6, "2001:db8::1", "2001:db8:1::2", l4(40000, 443, 0x12))
+	// UDP after hop-by-hop and destination options headers
+	udp6Ext = ipv6(0, "fe80::1", "ff02::1", cat([]byte{60, 0, 0, 0, 0, 0, 0, 0}, []byte{17, 0, 0, 0, 0, 0, 0, 0}, l4(546, 547, 0)))
+	arp     = append(ether(0x0806), make([]byte, 28)...)
+)
+
+func TestMatch(t *testing.T) {
+	packets := map[string][]byte{
+		"tcpSyn": tcpSyn, "udpDNS": udpDNS, "icmpEcho": icmpEcho, "fragment": fragment,
+		"tcp6": tcp6, "udp6Ext": udp6Ext, "arp": arp,
+	}
+	tests := []struct {
+		expr    string
+		matches []string
+	}{
+		{"", []string{"tcpSyn", "udpDNS", "icmpEcho", "fragment", "tcp6", "udp6Ext", "arp"}},
+		{"ip", []string{"tcpSyn", "udpDNS", "icmpEcho", "fragment"}},
+		{"ip6", []string{"tcp6", "udp6Ext"}},
+		{"arp", []string{"arp"}},
+		{"tcp", []string{"tcpSyn", "fragment", "tcp6"}},
+		{"udp", []string{"udpDNS", "udp6Ext"}},
+		{"icmp", []string{"icmpEcho"}},
+		{"not ip and not ip6", []string{"arp"}},
+		{"vlan", []string{"udpDNS"}},
+		{"vlan 100 and udp", []string{"udpDNS"}},
+		{"vlan 101", nil},
+		{"port 80", []string{"tcpSyn"}},
+		{"tcp dst port 80", []string{"tcpSyn"}},
+		{"src port 80", nil},
+		{"portrange 50-60 or port 547", []string{"udpDNS", "udp6Ext"}},
+		{"host 10.0.0.1", []string{"tcpSyn", "icmpEcho", "fragment"}},
+		{"src host 10.0.0.1", []string{"tcpSyn", "fragment"}},
+		{"dst 10.0.0.1", []string{"icmpEcho"}},
+		{"net 10.0.0.0/16", []string{"tcpSyn", "udpDNS", "icmpEcho", "fragment"}},
+		{"src and dst net 10.0.0.0/8", []string{"icmpEcho"}},
+		{"src or dst net 198.51.100.0/24", []string{"udpDNS"}},
+		{"ip6 net 2001:db8::/32", []string{"tcp6"}},
+		{"dst net 2001:db8:1::/48 && tcp port 443", []string{"tcp6"}},
+		{"host ff02::1", []string{"udp6Ext"}},
+		{"ether src 02:00:00:00:00:01", []string{"tcpSyn", "udpDNS", "icmpEcho", "fragment", "tcp6", "udp6Ext", "arp"}},
+		{"ether dst host 02:00:00:00:00:01", nil},
+		{"ether proto 0x806", []string{"arp"}},
+		{"ip proto 17", []string{"udpDNS"}},
+		{"tcp[tcpflags] & tcp-syn != 0", []string{"tcpSyn", "tcp6"}},
+		{"tcp[13] = 0x12", []string{"tcp6"}},
+		{"icmp[icmptype] == icmp-echo", []string{"icmpEcho"}},
+		{"ip[9] = 6", []string{"tcpSyn", "fragment"}},
+		{"udp[2:2] = 53", []string{"udpDNS"}},
+		{"ether[12:2] = 0x8100", []string{"udpDNS"}},
+		{"len < 60", []string{"tcpSyn", "udpDNS", "icmpEcho", "fragment", "arp"}},
+		{"greater 74", []string{"tcp6", "udp6Ext"}},
+		{"(tcp or udp) and not (port 80 or port 53)", []string{"fragment", "tcp6", "udp6Ext"}},
+		{"!tcp && !udp || icmp", []string{"icmpEcho", "arp"}},
+	}
+	for _, test := range tests {
+		f, err := Compile(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		want := make(map[string]bool)
+		for _, name := range test.matches {
+			want[name] = true
+		}
+		for name, data := range packets {
+			if got := f.Match(data); got != want[name] {
+				t.Errorf("%q: Match(%s) = %v", test.expr, name, got)
+			}
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		"tcp and",
+		"(ip",
+		"ip)",
+		"foo",
+		"host 10.0.0.256",
+		"net 10.0.0.1/8",
+		"ip host 2001:db8::1",
+		"ip6 host 10.0.0.1",
+		"port 70000",
+		"portrange 20-10",
+		"icmp port 1",
+		"tcp[0:3] = 1",
+		"tcp[0] 1",
+		"vlan 5000",
+		"ether host 1.2.3.4",
+		"ip $ ip6",
+		"tcp[tcpflags] & (tcp-syn) != 0",
+	} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	f, err := Compile("tcp[13:4] = 0 or port 80 or host 10.0.0.1 or ip6 net ::/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(tcpSyn); i++ {
+		want := i >= 14+20
+		if got := f.Match(tcpSyn[:i]); got != want {
+			t.Errorf("Match of %d bytes = %v", i, got)
+		}
+	}
+	for i := 0; i < len(tcp6); i++ {
+		if f.Match(tcp6[:i]) != (i >= 14+40) {
+			t.Errorf("Match of %d bytes of IPv6 packet is wrong", i)
+		}
+	}
+}
+
+func TestProgramShape(t *testing.T) {
+	f, err := Compile("tcp and (port 80 or port 443) and not host 10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every branch goes forward and program ends with terminals
+	for i, ins := range f.prog {
+		if ins.op == opAccept || ins.op == opReject {
+			if i < len(f.prog)-2 {
+				t.Errorf("Terminal instruction %d isn't at the end", i)
+			}
+			continue
+		}
+		if ins.jt <= i || ins.jf <= i || ins.jt >= len(f.prog) || ins.jf >= len(f.prog) {
+			t.Errorf("Instruction %d has branches %d and %d", i, ins.jt, ins.jf)
+		}
+	}
+	if len(f.prog) != 6 {
+		t.Errorf("Program has %d instructions instead of 6", len(f.prog))
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	f, err := Compile("vlan 100 and udp dst port 53 and src net 10.0.0.0/8")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		f.Match(udpDNS)
+	}
+}
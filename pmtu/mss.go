@@ -0,0 +1,115 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pmtu
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Offsets of TCP header fields and options
+const (
+	tcpMinLen      = 20
+	tcpDataOff     = 12
+	tcpFlagsOff    = 13
+	tcpChecksumOff = 16
+	tcpOptEnd      = 0
+	tcpOptNop      = 1
+	tcpOptMSS      = 2
+	tcpOptMSSLen   = 4
+)
+
+// ClampMSS decreases MSS option of TCP SYN packet so that segments
+// sent by other side fit into path MTU of packet destination or into
+// linkMTU if it is less. Checksum is updated incrementally as
+// described in RFC 1624. Packets with IPv6 extension headers and
+// packets without MSS option aren't changed. Returns true if packet
+// is changed.
+func (c *Cache) ClampMSS(ip []byte, linkMTU uint16, now time.Time) bool {
+	var tcp []byte
+	var hdrLen int
+	switch {
+	case len(ip) >= ipv4MinLen && ip[0]>>4 == 4:
+		hdrLen = int(ip[0]&0xf) * 4
+		if hdrLen < ipv4MinLen || len(ip) < hdrLen || ip[ipv4ProtoOff] != types.TCPNumber ||
+			binary.BigEndian.Uint16(ip[6:8])&0x1fff != 0 {
+			return false
+		}
+	case len(ip) >= ipv6Len && ip[0]>>4 == 6:
+		hdrLen = ipv6Len
+		if ip[ipv6NextOff] != types.TCPNumber {
+			return false
+		}
+	default:
+		return false
+	}
+	tcp = ip[hdrLen:]
+	if len(tcp) < tcpMinLen || tcp[tcpFlagsOff]&types.TCPFlagSyn == 0 {
+		return false
+	}
+	mtu := c.PathMTU(ip, linkMTU, now)
+	if int(mtu) <= hdrLen+tcpMinLen {
+		return false
+	}
+	return clampMSSOption(tcp, uint16(int(mtu)-hdrLen-tcpMinLen))
+}
+
+// clampMSSOption sets MSS option of TCP header to mss if it is larger.
+func clampMSSOption(tcp []byte, mss uint16) bool {
+	dataOff := int(tcp[tcpDataOff]>>4) * 4
+	if dataOff < tcpMinLen || dataOff > len(tcp) {
+		return false
+	}
+	for i := tcpMinLen; i < dataOff; {
+		switch tcp[i] {
+		case tcpOptEnd:
+			return false
+		case tcpOptNop:
+			i++
+			continue
+		}
+		if i+1 >= dataOff || tcp[i+1] < 2 || i+int(tcp[i+1]) > dataOff {
+			return false
+		}
+		if tcp[i] == tcpOptMSS && tcp[i+1] == tcpOptMSSLen {
+			if binary.BigEndian.Uint16(tcp[i+2:]) <= mss {
+				return false
+			}
+			var value [2]byte
+			binary.BigEndian.PutUint16(value[:], mss)
+			replace(tcp, i+2, value[:])
+			return true
+		}
+		i += int(tcp[i+1])
+	}
+	return false
+}
+
+// replace replaces bytes of TCP header at offset off and updates
+// checksum. Offset can be odd, so checksum is adjusted for all 16-bit
+// words which contain replaced bytes.
+func replace(tcp []byte, off int, value []byte) {
+	start := off &^ 1
+	end := (off + len(value) + 1) &^ 1
+	old := append([]byte(nil), tcp[start:end]...)
+	copy(tcp[off:], value)
+	checksum := binary.BigEndian.Uint16(tcp[tcpChecksumOff:])
+	for i := start; i < end; i += 2 {
+		checksum = adjust(checksum, binary.BigEndian.Uint16(old[i-start:]), binary.BigEndian.Uint16(tcp[i:]))
+	}
+	binary.BigEndian.PutUint16(tcp[tcpChecksumOff:], checksum)
+}
+
+// adjust returns checksum after 16-bit word of checksummed data is
+// changed from old to new value.
+func adjust(checksum, old, new uint16) uint16 {
+	// ~C' = ~C + ~m + m'
+	sum := uint32(^checksum) + uint32(^old) + uint32(new)
+	sum = (sum & 0xffff) + (sum >> 16)
+	sum = (sum & 0xffff) + (sum >> 16)
+	return ^uint16(sum)
+}
@@ -0,0 +1,267 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pmtu implements cache of path MTU of destinations which is
+// learned from ICMP Fragmentation Needed and ICMPv6 Packet Too Big
+// messages. Network functions consult cache when they fragment
+// packets or clamp TCP MSS, so traffic passes through tunnels and
+// links with small MTU without relying on hosts which ignore such
+// messages. Package works with byte slices which start with IP header
+// and doesn't depend on DPDK.
+//
+// ICMP messages can be forged, so learned MTU is never lower than
+// MinIPv4MTU or MinIPv6MTU, size of cache is limited and entries
+// expire after timeout, RFC 1191 recommends 10 minutes.
+package pmtu
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/types"
+)
+
+// Minimal MTU which can be learned. IPv4 minimum is higher than 68
+// bytes allowed by RFC 791 to resist forged messages like Linux does,
+// IPv6 minimum is defined by RFC 8200.
+const (
+	MinIPv4MTU = 552
+	MinIPv6MTU = 1280
+)
+
+// Offsets and values of headers
+const (
+	ipv4MinLen          = 20
+	ipv4ProtoOff        = 9
+	ipv4DstOff          = 16
+	ipv6Len             = 40
+	ipv6NextOff         = 6
+	ipv6DstOff          = 24
+	icmpHdrLen          = 8
+	icmpTypeUnreachable = 3
+	icmpCodeFragNeeded  = 4
+	icmpv6TypeTooBig    = 2
+)
+
+// MTU plateaus of RFC 1191 which are used when router doesn't report
+// next hop MTU.
+var plateaus = []uint16{32000, 17914, 8166, 4352, 2002, 1492, 1006, 508, 296, 68}
+
+type entry struct {
+	mtu     uint16
+	learned time.Time
+}
+
+// Cache keeps path MTU of destinations. Cache can be used by several
+// goroutines simultaneously.
+type Cache struct {
+	lock       sync.RWMutex
+	timeout    time.Duration
+	maxEntries int
+	ipv4       map[types.IPv4Address]entry
+	ipv6       map[types.IPv6Address]entry
+}
+
+// Entry is a learned path MTU of destination.
+type Entry struct {
+	Destination string
+	MTU         uint16
+	Learned     time.Time
+}
+
+// NewCache creates cache which keeps entries during timeout and
+// doesn't learn new destinations if it has maxEntries entries.
+func NewCache(timeout time.Duration, maxEntries int) (*Cache, error) {
+	if timeout <= 0 || maxEntries <= 0 {
+		return nil, common.WrapWithNFError(nil, "Timeout and size of path MTU cache should be positive", common.BadArgument)
+	}
+	return &Cache{
+		timeout:    timeout,
+		maxEntries: maxEntries,
+		ipv4:       make(map[types.IPv4Address]entry),
+		ipv6:       make(map[types.IPv6Address]entry),
+	}, nil
+}
+
+// Learn updates cache if packet is ICMP Fragmentation Needed or ICMPv6
+// Packet Too Big message. MTU of destination of quoted packet is
+// updated only if it decreases. Returns true if packet is such
+// message.
+func (c *Cache) Learn(ip []byte, now time.Time) bool {
+	if len(ip) == 0 {
+		return false
+	}
+	switch ip[0] >> 4 {
+	case 4:
+		dst, mtu, ok := parseFragNeeded(ip)
+		if ok {
+			c.lock.Lock()
+			if e, found := c.ipv4[dst]; found && now.Sub(e.learned) < c.timeout {
+				if mtu < e.mtu {
+					c.ipv4[dst] = entry{mtu, now}
+				}
+			} else if found || c.len() < c.maxEntries {
+				c.ipv4[dst] = entry{mtu, now}
+			}
+			c.lock.Unlock()
+		}
+		return ok
+	case 6:
+		dst, mtu, ok := parseTooBig(ip)
+		if ok {
+			c.lock.Lock()
+			if e, found := c.ipv6[dst]; found && now.Sub(e.learned) < c.timeout {
+				if mtu < e.mtu {
+					c.ipv6[dst] = entry{mtu, now}
+				}
+			} else if found || c.len() < c.maxEntries {
+				c.ipv6[dst] = entry{mtu, now}
+			}
+			c.lock.Unlock()
+		}
+		return ok
+	}
+	return false
+}
+
+func (c *Cache) len() int {
+	return len(c.ipv4) + len(c.ipv6)
+}
+
+// parseFragNeeded returns destination and MTU from ICMP Fragmentation
+// Needed message.
+func parseFragNeeded(ip []byte) (types.IPv4Address, uint16, bool) {
+	hdrLen := int(ip[0]&0xf) * 4
+	if hdrLen < ipv4MinLen || len(ip) < hdrLen+icmpHdrLen+ipv4MinLen ||
+		ip[ipv4ProtoOff] != types.ICMPNumber || binary.BigEndian.Uint16(ip[6:8])&0x1fff != 0 {
+		return 0, 0, false
+	}
+	icmp := ip[hdrLen:]
+	if icmp[0] != icmpTypeUnreachable || icmp[1] != icmpCodeFragNeeded {
+		return 0, 0, false
+	}
+	inner := icmp[icmpHdrLen:]
+	if inner[0]>>4 != 4 {
+		return 0, 0, false
+	}
+	mtu := binary.BigEndian.Uint16(icmp[6:8])
+	if mtu == 0 {
+		// Old router, guess MTU by length of original packet
+		length := binary.BigEndian.Uint16(inner[2:4])
+		for _, p := range plateaus {
+			if p < length {
+				mtu = p
+				break
+			}
+		}
+	}
+	if mtu < MinIPv4MTU {
+		mtu = MinIPv4MTU
+	}
+	return types.SliceToIPv4(inner[ipv4DstOff:]), mtu, true
+}
+
+// parseTooBig returns destination and MTU from ICMPv6 Packet Too Big
+// message. Messages in packets with extension headers are ignored.
+func parseTooBig(ip []byte) (types.IPv6Address, uint16, bool) {
+	var dst types.IPv6Address
+	if len(ip) < ipv6Len+icmpHdrLen+ipv6Len || ip[ipv6NextOff] != types.ICMPv6Number {
+		return dst, 0, false
+	}
+	icmp := ip[ipv6Len:]
+	inner := icmp[icmpHdrLen:]
+	if icmp[0] != icmpv6TypeTooBig || inner[0]>>4 != 6 {
+		return dst, 0, false
+	}
+	mtu := binary.BigEndian.Uint32(icmp[4:8])
+	if mtu < MinIPv6MTU {
+		mtu = MinIPv6MTU
+	}
+	if mtu > 0xffff {
+		mtu = 0xffff
+	}
+	copy(dst[:], inner[ipv6DstOff:])
+	return dst, uint16(mtu), true
+}
+
+// LookupIPv4 returns path MTU of IPv4 destination. Returns false if
+// it isn't known.
+func (c *Cache) LookupIPv4(dst types.IPv4Address, now time.Time) (uint16, bool) {
+	c.lock.RLock()
+	e, ok := c.ipv4[dst]
+	c.lock.RUnlock()
+	if !ok || now.Sub(e.learned) >= c.timeout {
+		return 0, false
+	}
+	return e.mtu, true
+}
+
+// LookupIPv6 returns path MTU of IPv6 destination. Returns false if
+// it isn't known.
+func (c *Cache) LookupIPv6(dst types.IPv6Address, now time.Time) (uint16, bool) {
+	c.lock.RLock()
+	e, ok := c.ipv6[dst]
+	c.lock.RUnlock()
+	if !ok || now.Sub(e.learned) >= c.timeout {
+		return 0, false
+	}
+	return e.mtu, true
+}
+
+// Expire removes entries which were learned timeout ago. It should be
+// called periodically, expired entries aren't used even if they are
+// not removed yet.
+func (c *Cache) Expire(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for dst, e := range c.ipv4 {
+		if now.Sub(e.learned) >= c.timeout {
+			delete(c.ipv4, dst)
+		}
+	}
+	for dst, e := range c.ipv6 {
+		if now.Sub(e.learned) >= c.timeout {
+			delete(c.ipv6, dst)
+		}
+	}
+}
+
+// Entries returns entries which are not expired.
+func (c *Cache) Entries(now time.Time) []Entry {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	ret := make([]Entry, 0, c.len())
+	for dst, e := range c.ipv4 {
+		if now.Sub(e.learned) < c.timeout {
+			ret = append(ret, Entry{dst.String(), e.mtu, e.learned})
+		}
+	}
+	for dst, e := range c.ipv6 {
+		if now.Sub(e.learned) < c.timeout {
+			ret = append(ret, Entry{dst.String(), e.mtu, e.learned})
+		}
+	}
+	return ret
+}
+
+// PathMTU returns MTU which should be used for packet: path MTU of
+// its destination if it is known and is less than linkMTU or linkMTU
+// otherwise.
+func (c *Cache) PathMTU(ip []byte, linkMTU uint16, now time.Time) uint16 {
+	mtu, ok := uint16(0), false
+	switch {
+	case len(ip) >= ipv4MinLen && ip[0]>>4 == 4:
+		mtu, ok = c.LookupIPv4(types.SliceToIPv4(ip[ipv4DstOff:]), now)
+	case len(ip) >= ipv6Len && ip[0]>>4 == 6:
+		var dst types.IPv6Address
+		copy(dst[:], ip[ipv6DstOff:])
+		mtu, ok = c.LookupIPv6(dst, now)
+	}
+	if ok && mtu < linkMTU {
+		return mtu
+	}
+	return linkMTU
+}
@@ -0,0 +1,237 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pmtu
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+var (
+	server  = types.BytesToIPv4(198, 51, 100, 7)
+	server6 = types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 7}
+	start   = time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+)
+
+func ipv4Header(proto uint8, length int, dst types.IPv4Address) []byte {
+	h := make([]byte, ipv4MinLen)
+	h[0] = 0x45
+	binary.BigEndian.PutUint16(h[2:], uint16(length))
+	h[8] = 64
+	h[ipv4ProtoOff] = proto
+	copy(h[12:], []byte{10, 0, 0, 1})
+	a := types.IPv4ToBytes(dst)
+	copy(h[ipv4DstOff:], a[:])
+	return h
+}
+
+func ipv6Header(next uint8, payload int, dst types.IPv6Address) []byte {
+	h := make([]byte, ipv6Len)
+	h[0] = 0x60
+	binary.BigEndian.PutUint16(h[4:], uint16(payload))
+	h[ipv6NextOff] = next
+	h[7] = 64
+	h[8] = 0xfd
+	copy(h[ipv6DstOff:], dst[:])
+	return h
+}
+
+// fragNeeded builds ICMP Fragmentation Needed message about packet of
+// given length sent to dst.
+func fragNeeded(dst types.IPv4Address, mtu uint16, length int) []byte {
+	inner := append(ipv4Header(types.TCPNumber, length, dst), make([]byte, 8)...)
+	icmp := []byte{icmpTypeUnreachable, icmpCodeFragNeeded, 0, 0, 0, 0, byte(mtu >> 8), byte(mtu)}
+	return append(append(ipv4Header(types.ICMPNumber, 20+8+len(inner), types.BytesToIPv4(10, 0, 0, 1)), icmp...), inner...)
+}
+
+func tooBig(dst types.IPv6Address, mtu uint32) []byte {
+	inner := append(ipv6Header(types.TCPNumber, 20, dst), make([]byte, 20)...)
+	icmp := []byte{icmpv6TypeTooBig, 0, 0, 0, byte(mtu >> 24), byte(mtu >> 16), byte(mtu >> 8), byte(mtu)}
+	return append(append(ipv6Header(types.ICMPv6Number, 8+len(inner), types.IPv6Address{0xfd, 15: 1}), icmp...), inner...)
+}
+
+func newCache(t *testing.T) *Cache {
+	c, err := NewCache(10*time.Minute, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestLearnIPv4(t *testing.T) {
+	tests := []struct {
+		name   string
+		mtu    uint16
+		length int
+		want   uint16
+	}{
+		{"reported", 1400, 1500, 1400},
+		{"too small", 300, 1500, MinIPv4MTU},
+		{"plateau", 0, 1500, 1492},
+		{"plateau of large packet", 0, 9000, 8166},
+	}
+	for _, tt := range tests {
+		c := newCache(t)
+		if !c.Learn(fragNeeded(server, tt.mtu, tt.length), start) {
+			t.Errorf("%s: message isn't recognized", tt.name)
+			continue
+		}
+		if mtu, ok := c.LookupIPv4(server, start); !ok || mtu != tt.want {
+			t.Errorf("%s: LookupIPv4() = %d, %v, want %d", tt.name, mtu, ok, tt.want)
+		}
+	}
+}
+
+func TestLearnIgnored(t *testing.T) {
+	c := newCache(t)
+	echo := ipv4Header(types.ICMPNumber, 28, server)
+	echo = append(echo, types.ICMPTypeEchoRequest, 0, 0, 0, 0, 0, 0, 0)
+	unreachable := fragNeeded(server, 1400, 1500)
+	unreachable[ipv4MinLen+1] = 1
+	for _, p := range [][]byte{nil, echo, unreachable, fragNeeded(server, 1400, 1500)[:40]} {
+		if c.Learn(p, start) {
+			t.Errorf("Learn(%x) = true", p)
+		}
+	}
+	if len(c.Entries(start)) != 0 {
+		t.Error("Cache isn't empty")
+	}
+}
+
+func TestLearnIPv6(t *testing.T) {
+	c := newCache(t)
+	if !c.Learn(tooBig(server6, 1280+80), start) {
+		t.Fatal("message isn't recognized")
+	}
+	if mtu, ok := c.LookupIPv6(server6, start); !ok || mtu != 1360 {
+		t.Errorf("LookupIPv6() = %d, %v, want 1360", mtu, ok)
+	}
+	// MTU below minimum is raised to minimum
+	c.Learn(tooBig(server6, 600), start)
+	if mtu, _ := c.LookupIPv6(server6, start); mtu != MinIPv6MTU {
+		t.Errorf("LookupIPv6() = %d, want %d", mtu, MinIPv6MTU)
+	}
+}
+
+func TestAging(t *testing.T) {
+	c := newCache(t)
+	c.Learn(fragNeeded(server, 1400, 1500), start)
+	// Larger MTU doesn't replace known one
+	c.Learn(fragNeeded(server, 1450, 1500), start.Add(time.Minute))
+	if mtu, _ := c.LookupIPv4(server, start.Add(time.Minute)); mtu != 1400 {
+		t.Errorf("MTU is increased to %d", mtu)
+	}
+	later := start.Add(10 * time.Minute)
+	if _, ok := c.LookupIPv4(server, later); ok {
+		t.Error("Expired entry is used")
+	}
+	// Expired entry is replaced by any MTU
+	c.Learn(fragNeeded(server, 1450, 1500), later)
+	if mtu, _ := c.LookupIPv4(server, later); mtu != 1450 {
+		t.Errorf("LookupIPv4() = %d after expiration, want 1450", mtu)
+	}
+	c.Expire(later.Add(10 * time.Minute))
+	if n := len(c.ipv4); n != 0 {
+		t.Errorf("%d entries after Expire()", n)
+	}
+}
+
+func TestCacheSize(t *testing.T) {
+	c := newCache(t)
+	for i := byte(1); i <= 5; i++ {
+		c.Learn(fragNeeded(types.BytesToIPv4(198, 51, 100, i), 1400, 1500), start)
+	}
+	if n := len(c.Entries(start)); n != 3 {
+		t.Errorf("Cache has %d entries, want 3", n)
+	}
+	// Known destination is updated when cache is full
+	c.Learn(fragNeeded(types.BytesToIPv4(198, 51, 100, 1), 1300, 1500), start)
+	if mtu, _ := c.LookupIPv4(types.BytesToIPv4(198, 51, 100, 1), start); mtu != 1300 {
+		t.Errorf("LookupIPv4() = %d, want 1300", mtu)
+	}
+}
+
+// checksum computes TCP checksum of IPv4 packet.
+func checksum(ip []byte) uint16 {
+	tcp := ip[ipv4MinLen:]
+	sum := uint32(types.TCPNumber) + uint32(len(tcp))
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 != 0 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(ip[12:20])
+	add(tcp)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// syn builds TCP SYN packet to server with given options.
+func syn(options ...byte) []byte {
+	tcp := make([]byte, tcpMinLen, tcpMinLen+len(options))
+	tcp = append(tcp, options...)
+	tcp[tcpDataOff] = byte(len(tcp)/4) << 4
+	tcp[tcpFlagsOff] = types.TCPFlagSyn
+	ip := append(ipv4Header(types.TCPNumber, ipv4MinLen+len(tcp), server), tcp...)
+	binary.BigEndian.PutUint16(ip[ipv4MinLen+tcpChecksumOff:], checksum(ip))
+	return ip
+}
+
+func TestClampMSS(t *testing.T) {
+	c := newCache(t)
+	tests := []struct {
+		name    string
+		pkt     []byte
+		mssOff  int
+		changed bool
+		want    uint16
+	}{
+		{"aligned", syn(tcpOptMSS, 4, 0x05, 0xb4), 22, true, 1360},
+		{"unaligned", syn(tcpOptNop, tcpOptMSS, 4, 0x05, 0xb4, tcpOptNop, tcpOptNop, tcpOptNop), 23, true, 1360},
+		{"after other option", syn(4, 2, tcpOptMSS, 4, 0x05, 0xb4, 0, 0), 24, true, 1360},
+		{"small", syn(tcpOptMSS, 4, 0x02, 0x18), 22, false, 536},
+		{"no option", syn(tcpOptNop, tcpOptNop, tcpOptNop, tcpOptNop), 0, false, 0},
+		{"broken option", syn(3, 0, tcpOptMSS, 4), 0, false, 0},
+	}
+	for _, tt := range tests {
+		if changed := c.ClampMSS(tt.pkt, 1400, start); changed != tt.changed {
+			t.Errorf("%s: ClampMSS() = %v, want %v", tt.name, changed, tt.changed)
+		}
+		if tt.mssOff != 0 {
+			if mss := binary.BigEndian.Uint16(tt.pkt[ipv4MinLen+tt.mssOff:]); mss != tt.want {
+				t.Errorf("%s: MSS is %d, want %d", tt.name, mss, tt.want)
+			}
+		}
+		// Checksum of data with correct checksum is zero
+		if checksum(tt.pkt) != 0 {
+			t.Errorf("%s: checksum is wrong", tt.name)
+		}
+	}
+
+	c.Learn(fragNeeded(server, 1300, 1500), start)
+	pkt := syn(tcpOptNop, tcpOptMSS, 4, 0x05, 0xb4, tcpOptNop, tcpOptNop, tcpOptNop)
+	if !c.ClampMSS(pkt, 1500, start) {
+		t.Fatal("MSS isn't clamped to path MTU")
+	}
+	if mss := binary.BigEndian.Uint16(pkt[ipv4MinLen+23:]); mss != 1260 {
+		t.Errorf("MSS is %d, want 1260", mss)
+	}
+	if checksum(pkt) != 0 {
+		t.Error("Checksum is wrong after clamping")
+	}
+	ack := syn(tcpOptMSS, 4, 0x05, 0xb4)
+	ack[ipv4MinLen+tcpFlagsOff] = types.TCPFlagAck
+	if c.ClampMSS(ack, 1500, start) {
+		t.Error("Packet without SYN is changed")
+	}
+}
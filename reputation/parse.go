@@ -0,0 +1,133 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reputation
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// Format is a format of reputation list.
+type Format uint8
+
+const (
+	// FormatAuto detects format by content: JSON documents are parsed
+	// as STIX, everything else as plain text
+	FormatAuto Format = iota
+	// FormatPlain is a text with one IPv4 address or CIDR prefix per
+	// line. Text after '#' or ';' is a comment, text after the first
+	// field is ignored, lines which are not addresses are skipped.
+	FormatPlain
+	// FormatSTIX is a STIX 2 bundle. Addresses are taken from
+	// ipv4-addr objects and from patterns of not revoked indicators.
+	FormatSTIX
+)
+
+// prefix is an IPv4 prefix with address in host byte order.
+type prefix struct {
+	addr uint32
+	len  uint8
+}
+
+func parsePrefix(s string) (prefix, bool) {
+	if !strings.Contains(s, "/") {
+		s += "/32"
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return prefix{}, false
+	}
+	ip := n.IP.To4()
+	ones, bits := n.Mask.Size()
+	if ip == nil || bits != 32 {
+		return prefix{}, false
+	}
+	return prefix{
+		addr: uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3]),
+		len:  uint8(ones),
+	}, true
+}
+
+func parseList(data []byte, format Format) ([]prefix, error) {
+	if format == FormatAuto {
+		if t := bytes.TrimSpace(data); len(t) != 0 && t[0] == '{' {
+			format = FormatSTIX
+		} else {
+			format = FormatPlain
+		}
+	}
+	if format == FormatSTIX {
+		return parseSTIX(data)
+	}
+	return parsePlain(data)
+}
+
+func parsePlain(data []byte) ([]prefix, error) {
+	var prefixes []prefix
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if p, ok := parsePrefix(fields[0]); ok {
+			prefixes = append(prefixes, p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, common.WrapWithNFError(err, "Can't read plain text list", common.ParseRuleErr)
+	}
+	return prefixes, nil
+}
+
+// Matches "ipv4-addr:value = '192.0.2.0/24'" comparisons in STIX
+// patterns
+var stixPattern = regexp.MustCompile(`ipv4-addr:value\s*=\s*'([^']+)'`)
+
+type stixObject struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+	Value   string `json:"value"`
+	Revoked bool   `json:"revoked"`
+}
+
+func parseSTIX(data []byte) ([]prefix, error) {
+	var bundle struct {
+		Type    string       `json:"type"`
+		Objects []stixObject `json:"objects"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, common.WrapWithNFError(err, "Can't parse STIX bundle", common.ParseRuleErr)
+	}
+	if bundle.Type != "bundle" {
+		return nil, common.WrapWithNFError(nil, "STIX document is not a bundle", common.ParseRuleErr)
+	}
+	var prefixes []prefix
+	for _, o := range bundle.Objects {
+		switch {
+		case o.Type == "ipv4-addr":
+			if p, ok := parsePrefix(o.Value); ok {
+				prefixes = append(prefixes, p)
+			}
+		case o.Type == "indicator" && !o.Revoked:
+			for _, m := range stixPattern.FindAllStringSubmatch(o.Pattern, -1) {
+				if p, ok := parsePrefix(m[1]); ok {
+					prefixes = append(prefixes, p)
+				}
+			}
+		}
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,293 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package reputation checks IPv4 addresses against external threat
+// intelligence lists. Lists are loaded from files or HTTP servers in
+// plain text or STIX format and are refreshed periodically. Every
+// refresh builds a new lookup snapshot and atomically replaces the
+// old one, so flow functions which check packets are never blocked
+// and always see either the old or the new version of all lists.
+package reputation
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/common/ratelimit"
+	"github.com/intel-go/nff-go/types"
+)
+
+// Maximum number of lists in Feed, lists are stored as bits of uint64
+const maxLists = 64
+
+// Timeout of downloading list from HTTP server
+const fetchTimeout = 30 * time.Second
+
+// Action is applied to packets whose addresses are found in list.
+type Action uint8
+
+const (
+	// Drop means that packets should be dropped
+	Drop Action = iota
+	// Log means that packets should be passed and reported
+	Log
+	// RateLimit means that packets should be dropped if all packets
+	// matched by list exceed rate limit of list
+	RateLimit
+)
+
+// List describes one reputation list.
+type List struct {
+	// Unique name of list which is used in reports
+	Name string
+	// File name or http:// or https:// URL of list
+	Source string
+	Format Format
+	Action Action
+	// Rate in packets per second and burst of RateLimit action
+	Rate  float64
+	Burst uint
+}
+
+type list struct {
+	List
+	matches uint64
+	// Limiter of RateLimit action, it isn't replaced by refresh
+	limiterLock sync.Mutex
+	limiter     *ratelimit.TokenBucket
+}
+
+// snapshot is an immutable version of all lists.
+type snapshot struct {
+	matcher *matcher
+	// Prefixes of every list, they are used for lists which can't be
+	// loaded during refresh
+	prefixes [][]prefix
+}
+
+// Feed is a set of reputation lists. Check can be called by several
+// flow functions and their clones simultaneously with Reload.
+type Feed struct {
+	lists []*list
+	// Current snapshot, accessed atomically
+	current unsafe.Pointer
+	// Serializes reloads
+	reloadLock sync.Mutex
+	stop       chan struct{}
+	// LogFunction is called for every packet which matches list with
+	// Log action. If it is nil, such packets are only counted.
+	LogFunction func(list string, addr types.IPv4Address)
+	// ErrorFunction is called for every list which can't be loaded
+	// during periodic refresh. Previous version of such list is kept.
+	ErrorFunction func(list string, err error)
+}
+
+// NewFeed creates feed of given lists and loads all of them. Returns
+// error if any list can't be loaded.
+func NewFeed(lists []List) (*Feed, error) {
+	if len(lists) == 0 || len(lists) > maxLists {
+		return nil, common.WrapWithNFError(nil, fmt.Sprintf("Feed should have from 1 to %d lists", maxLists), common.BadArgument)
+	}
+	f := new(Feed)
+	names := make(map[string]bool)
+	for _, l := range lists {
+		if names[l.Name] {
+			return nil, common.WrapWithNFError(nil, "Duplicate reputation list "+l.Name, common.BadArgument)
+		}
+		names[l.Name] = true
+		if l.Action > RateLimit {
+			return nil, common.WrapWithNFError(nil, "Unknown action of reputation list "+l.Name, common.BadArgument)
+		}
+		fl := &list{List: l}
+		if l.Action == RateLimit {
+			if l.Rate <= 0 || l.Burst == 0 {
+				return nil, common.WrapWithNFError(nil, "Rate limit of reputation list "+l.Name+" should be positive", common.BadArgument)
+			}
+			fl.limiter = ratelimit.NewTokenBucket(l.Rate, l.Burst, time.Now())
+		}
+		f.lists = append(f.lists, fl)
+	}
+	if err := f.load(nil); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *Feed) getSnapshot() *snapshot {
+	return (*snapshot)(atomic.LoadPointer(&f.current))
+}
+
+func fetch(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return ioutil.ReadFile(source)
+	}
+	client := http.Client{Timeout: fetchTimeout}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func loadList(l *list) ([]prefix, error) {
+	data, err := fetch(l.Source)
+	if err != nil {
+		return nil, common.WrapWithNFError(err, "Can't load reputation list "+l.Name+": "+err.Error(), common.FileErr)
+	}
+	return parseList(data, l.Format)
+}
+
+// load builds new snapshot from all lists. If old snapshot is not
+// nil, lists which fail to load keep their old prefixes and errors
+// are passed to report. Otherwise the first error is returned.
+func (f *Feed) load(report func(string, error)) error {
+	f.reloadLock.Lock()
+	defer f.reloadLock.Unlock()
+	old := f.getSnapshot()
+	next := &snapshot{
+		matcher:  newMatcher(),
+		prefixes: make([][]prefix, len(f.lists)),
+	}
+	var firstErr error
+	for i, l := range f.lists {
+		prefixes, err := loadList(l)
+		if err != nil {
+			if old == nil {
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if report != nil {
+				report(l.Name, err)
+			}
+			prefixes = old.prefixes[i]
+		}
+		next.prefixes[i] = prefixes
+		for _, p := range prefixes {
+			next.matcher.add(p, uint(i))
+		}
+	}
+	atomic.StorePointer(&f.current, unsafe.Pointer(next))
+	return firstErr
+}
+
+// Reload loads all lists again and replaces current snapshot. Lists
+// which can't be loaded keep their previous version, error of the
+// first such list is returned.
+func (f *Feed) Reload() error {
+	return f.load(nil)
+}
+
+// Start reloads lists every interval in separate goroutine until Stop
+// is called.
+func (f *Feed) Start(interval time.Duration) {
+	f.Stop()
+	f.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				f.load(func(name string, err error) {
+					if f.ErrorFunction != nil {
+						f.ErrorFunction(name, err)
+					} else {
+						common.LogWarning(common.Debug, err.Error())
+					}
+				})
+			}
+		}
+	}(f.stop)
+}
+
+// Stop stops periodic reloading.
+func (f *Feed) Stop() {
+	if f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+}
+
+// Lookup returns names of lists which contain address.
+func (f *Feed) Lookup(addr types.IPv4Address) []string {
+	var names []string
+	lists := f.getSnapshot().matcher.lookup(hostOrder(addr))
+	for i, l := range f.lists {
+		if lists&(1<<uint(i)) != 0 {
+			names = append(names, l.Name)
+		}
+	}
+	return names
+}
+
+// Check applies actions of all lists which contain address to one
+// packet. Returns false if packet should be dropped.
+func (f *Feed) Check(addr types.IPv4Address, now time.Time) bool {
+	lists := f.getSnapshot().matcher.lookup(hostOrder(addr))
+	pass := true
+	for i := 0; lists != 0; i++ {
+		if lists&1 != 0 {
+			l := f.lists[i]
+			atomic.AddUint64(&l.matches, 1)
+			switch l.Action {
+			case Drop:
+				pass = false
+			case Log:
+				if f.LogFunction != nil {
+					f.LogFunction(l.Name, addr)
+				}
+			case RateLimit:
+				l.limiterLock.Lock()
+				if !l.limiter.Allow(now) {
+					pass = false
+				}
+				l.limiterLock.Unlock()
+			}
+		}
+		lists >>= 1
+	}
+	return pass
+}
+
+// Matches returns number of addresses checked by Check which were
+// found in list with given name.
+func (f *Feed) Matches(name string) uint64 {
+	for _, l := range f.lists {
+		if l.Name == name {
+			return atomic.LoadUint64(&l.matches)
+		}
+	}
+	return 0
+}
+
+// Len returns number of prefixes in list with given name.
+func (f *Feed) Len(name string) int {
+	s := f.getSnapshot()
+	for i, l := range f.lists {
+		if l.Name == name {
+			return len(s.prefixes[i])
+		}
+	}
+	return 0
+}
+
+func hostOrder(addr types.IPv4Address) uint32 {
+	b := types.IPv4ToBytes(addr)
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reputation
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+const plainList = `# comment
+192.0.2.1
+198.51.100.0/24 ; network
+2001:db8::1
+not an address
+203.0.113.7 some description
+`
+
+const stixList = `{
+	"type": "bundle",
+	"objects": [
+		{"type": "indicator", "pattern": "[ipv4-addr:value = '192.0.2.10'] OR [ipv4-addr:value = '10.0.0.0/8']"},
+		{"type": "indicator", "revoked": true, "pattern": "[ipv4-addr:value = '192.0.2.11']"},
+		{"type": "ipv4-addr", "value": "192.0.2.12"},
+		{"type": "domain-name", "value": "example.com"}
+	]
+}`
+
+func writeList(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParse(t *testing.T) {
+	plain, err := parseList([]byte(plainList), FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []prefix{{0xc0000201, 32}, {0xc6336400, 24}, {0xcb007107, 32}}
+	if !reflect.DeepEqual(plain, expected) {
+		t.Errorf("Plain list parsed as %v, expected %v", plain, expected)
+	}
+	stix, err := parseList([]byte(stixList), FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []prefix{{0xc000020a, 32}, {0x0a000000, 8}, {0xc000020c, 32}}
+	if !reflect.DeepEqual(stix, expected) {
+		t.Errorf("STIX bundle parsed as %v, expected %v", stix, expected)
+	}
+	if _, err := parseList([]byte("{}"), FormatSTIX); err == nil {
+		t.Error("STIX document without bundle was accepted")
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	m := newMatcher()
+	m.add(prefix{0x0a000000, 8}, 0)
+	m.add(prefix{0x0a010000, 16}, 1)
+	m.add(prefix{0x0a010101, 32}, 2)
+	m.add(prefix{0, 0}, 3)
+	tests := []struct {
+		addr  uint32
+		lists uint64
+	}{
+		{0x0a010101, 0xf},
+		{0x0a010102, 0xb},
+		{0x0a020101, 0x9},
+		{0x0b000000, 0x8},
+	}
+	for _, test := range tests {
+		if lists := m.lookup(test.addr); lists != test.lists {
+			t.Errorf("Lookup of %x returned %x, expected %x", test.addr, lists, test.lists)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reputation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(stixList))
+	}))
+	defer server.Close()
+
+	dropPath := writeList(t, dir, "drop", plainList)
+	logPath := writeList(t, dir, "log", "192.0.2.0/24\n")
+	var logged []string
+	f, err := NewFeed([]List{
+		{Name: "drop", Source: dropPath, Action: Drop},
+		{Name: "log", Source: logPath, Action: Log},
+		{Name: "limit", Source: server.URL, Action: RateLimit, Rate: 1, Burst: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.LogFunction = func(list string, addr types.IPv4Address) {
+		logged = append(logged, list+" "+addr.String())
+	}
+	now := time.Now()
+	if f.Check(types.BytesToIPv4(192, 0, 2, 1), now) {
+		t.Error("Address from drop list was passed")
+	}
+	if !f.Check(types.BytesToIPv4(192, 0, 2, 2), now) {
+		t.Error("Address from log list was dropped")
+	}
+	if !reflect.DeepEqual(logged, []string{"log 192.0.2.1", "log 192.0.2.2"}) {
+		t.Errorf("Logged %v", logged)
+	}
+	limited := types.BytesToIPv4(10, 1, 2, 3)
+	if !f.Check(limited, now) || !f.Check(limited, now) || f.Check(limited, now) {
+		t.Error("Rate limit of 2 packets burst wasn't applied")
+	}
+	if !f.Check(types.BytesToIPv4(8, 8, 8, 8), now) {
+		t.Error("Unknown address was dropped")
+	}
+	if m := f.Matches("limit"); m != 3 {
+		t.Errorf("Limit list matched %d addresses, expected 3", m)
+	}
+
+	// Failed list keeps previous version, other lists are replaced
+	os.Remove(dropPath)
+	writeList(t, dir, "log", "203.0.113.0/24\n")
+	if err := f.Reload(); err == nil {
+		t.Error("Reload of removed list succeeded")
+	}
+	if names := f.Lookup(types.BytesToIPv4(203, 0, 113, 7)); !reflect.DeepEqual(names, []string{"drop", "log"}) {
+		t.Errorf("Address is found in %v after reload", names)
+	}
+	if names := f.Lookup(types.BytesToIPv4(192, 0, 2, 2)); len(names) != 0 {
+		t.Errorf("Address from replaced list is found in %v after reload", names)
+	}
+	if f.Len("drop") != 3 || f.Len("log") != 1 {
+		t.Errorf("Lists have %d and %d prefixes after reload", f.Len("drop"), f.Len("log"))
+	}
+
+	if _, err := NewFeed([]List{{Name: "missing", Source: dropPath}}); err == nil {
+		t.Error("Feed with missing list was created")
+	}
+}
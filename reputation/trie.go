@@ -0,0 +1,64 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reputation
+
+// matcher maps IPv4 addresses to bitmaps of lists which contain them.
+// Host addresses are kept in a hash map, shorter prefixes in a binary
+// trie, because feeds mostly consist of host addresses. Matcher is not
+// changed after it is built, so it can be read concurrently.
+type matcher struct {
+	hosts map[uint32]uint64
+	nodes []trieNode
+}
+
+type trieNode struct {
+	// Indexes of child nodes, zero means no child because root can't
+	// be a child
+	child [2]uint32
+	// Lists which contain prefix ending in this node
+	lists uint64
+}
+
+func newMatcher() *matcher {
+	return &matcher{
+		hosts: make(map[uint32]uint64),
+		nodes: make([]trieNode, 1),
+	}
+}
+
+func (m *matcher) add(p prefix, list uint) {
+	bit := uint64(1) << list
+	if p.len == 32 {
+		m.hosts[p.addr] |= bit
+		return
+	}
+	n := uint32(0)
+	for i := uint8(0); i < p.len; i++ {
+		b := (p.addr >> (31 - i)) & 1
+		if m.nodes[n].child[b] == 0 {
+			m.nodes = append(m.nodes, trieNode{})
+			m.nodes[n].child[b] = uint32(len(m.nodes) - 1)
+		}
+		n = m.nodes[n].child[b]
+	}
+	m.nodes[n].lists |= bit
+}
+
+// lookup returns bitmap of all lists which have prefixes containing
+// address in host byte order.
+func (m *matcher) lookup(addr uint32) uint64 {
+	lists := m.hosts[addr]
+	n := uint32(0)
+	for i := uint(0); ; i++ {
+		lists |= m.nodes[n].lists
+		if i == 32 {
+			break
+		}
+		if n = m.nodes[n].child[(addr>>(31-i))&1]; n == 0 {
+			break
+		}
+	}
+	return lists
+}
@@ -20,38 +20,61 @@ const (
 
 // Supported EtherType for L2
 const (
-	IPV4Number = 0x0800
-	ARPNumber  = 0x0806
-	VLANNumber = 0x8100
-	MPLSNumber = 0x8847
-	IPV6Number = 0x86dd
+	IPV4Number  = 0x0800
+	ARPNumber   = 0x0806
+	VLANNumber  = 0x8100
+	MPLSNumber  = 0x8847
+	IPV6Number  = 0x86dd
+	SVLANNumber = 0x88a8
+	LLDPNumber  = 0x88cc
+	// Protocol types of ERSPAN Type II and Type III in GRE header
+	ERSPANIINumber  = 0x88be
+	ERSPANIIINumber = 0x22eb
 
-	SwapIPV4Number = 0x0008
-	SwapARPNumber  = 0x0608
-	SwapVLANNumber = 0x0081
-	SwapMPLSNumber = 0x4788
-	SwapIPV6Number = 0xdd86
+	SwapIPV4Number  = 0x0008
+	SwapARPNumber   = 0x0608
+	SwapVLANNumber  = 0x0081
+	SwapMPLSNumber  = 0x4788
+	SwapIPV6Number  = 0xdd86
+	SwapSVLANNumber = 0xa888
+	SwapLLDPNumber  = 0xcc88
 )
 
 // Supported L4 types
 const (
-	ICMPNumber   = 0x01
-	IPNumber     = 0x04
-	TCPNumber    = 0x06
-	UDPNumber    = 0x11
-	GRENumber    = 0x2f
-	ICMPv6Number = 0x3a
-	NoNextHeader = 0x3b
+	ICMPNumber    = 0x01
+	IGMPNumber    = 0x02
+	IPNumber      = 0x04
+	TCPNumber     = 0x06
+	UDPNumber     = 0x11
+	DCCPNumber    = 0x21
+	GRENumber     = 0x2f
+	ICMPv6Number  = 0x3a
+	NoNextHeader  = 0x3b
+	VRRPNumber    = 0x70
+	SCTPNumber    = 0x84
+	UDPLiteNumber = 0x88
+
+	IPv6HopByHopNumber = 0x00
+	IPv6RoutingNumber  = 0x2b
+	IPv6FragmentNumber = 0x2c
+	IPv6DestOptsNumber = 0x3c
 )
 
 // Supported ICMP Types
 const (
-	ICMPTypeEchoRequest         uint8 = 8
-	ICMPTypeEchoResponse        uint8 = 0
-	ICMPv6TypeEchoRequest       uint8 = 128
-	ICMPv6TypeEchoResponse      uint8 = 129
-	ICMPv6NeighborSolicitation  uint8 = 135
-	ICMPv6NeighborAdvertisement uint8 = 136
+	ICMPTypeEchoRequest           uint8 = 8
+	ICMPTypeEchoResponse          uint8 = 0
+	ICMPv6TypeEchoRequest         uint8 = 128
+	ICMPv6TypeEchoResponse        uint8 = 129
+	ICMPv6TypeRouterSolicitation  uint8 = 133
+	ICMPv6TypeRouterAdvertisement uint8 = 134
+	ICMPv6NeighborSolicitation    uint8 = 135
+	ICMPv6NeighborAdvertisement   uint8 = 136
+	ICMPv6TypeMLDQuery            uint8 = 130
+	ICMPv6TypeMLDReport           uint8 = 131
+	ICMPv6TypeMLDDone             uint8 = 132
+	ICMPv6TypeMLDv2Report         uint8 = 143
 )
 
 // These constants keep length of supported headers in bytes.
@@ -72,9 +95,15 @@ const (
 	ICMPLen    = 8
 	TCPMinLen  = 20
 	UDPLen     = 8
+	UDPLiteLen = 8
+	DCCPMinLen = 12
+	SCTPLen    = 12
 	ARPLen     = 28
 	GTPMinLen  = 8
 	GRELen     = 4
+	GeneveLen  = 8
+
+	IPv6FragmentLen = 8
 )
 
 const (
@@ -83,6 +112,13 @@ const (
 	IPv6VtcFlow      = 0x60 // IPv6 version
 )
 
+// Flags and offset of IPv4 FragmentOffset field in host byte order.
+const (
+	IPv4DontFragment       = 0x4000
+	IPv4MoreFragments      = 0x2000
+	IPv4FragmentOffsetMask = 0x1fff
+)
+
 // TCPFlags contains set TCP flags.
 type TCPFlags uint8
 
@@ -0,0 +1,474 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasmfilter
+
+import (
+	"fmt"
+)
+
+// Section identifiers of WebAssembly binary format
+const (
+	sectionCustom   = 0
+	sectionType     = 1
+	sectionImport   = 2
+	sectionFunction = 3
+	sectionExport   = 7
+	sectionCode     = 10
+)
+
+const (
+	valueI32     = 0x7f
+	blockEmpty   = 0x40
+	funcTypeForm = 0x60
+	externFunc   = 0x00
+)
+
+// Limits of module size which keep decoding and execution cheap
+const (
+	maxFunctions = 1024
+	maxLocals    = 256
+	maxParams    = 16
+)
+
+type funcType struct {
+	params  int
+	results int
+}
+
+// block describes position of matching else and end instructions of
+// block, loop or if instruction.
+type block struct {
+	// Position of else instruction of if or -1
+	elsePos int
+	// Position of end instruction
+	endPos int
+}
+
+type function struct {
+	typ    funcType
+	locals int
+	code   []byte
+	// Blocks by positions of block, loop, if and else instructions
+	blocks map[int]block
+}
+
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reader) eof() bool {
+	return r.pos >= len(r.data)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.eof() {
+		return 0, fmt.Errorf("unexpected end at offset %d", r.pos)
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n uint32) ([]byte, error) {
+	if uint64(r.pos)+uint64(n) > uint64(len(r.data)) {
+		return nil, fmt.Errorf("unexpected end at offset %d", r.pos)
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// u32 reads unsigned LEB128 number.
+func (r *reader) u32() (uint32, error) {
+	var v uint64
+	for shift := uint(0); shift < 35; shift += 7 {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if v > 0xffffffff {
+				return 0, fmt.Errorf("integer is too large at offset %d", r.pos)
+			}
+			return uint32(v), nil
+		}
+	}
+	return 0, fmt.Errorf("integer is too long at offset %d", r.pos)
+}
+
+// s32 reads signed LEB128 number.
+func (r *reader) s32() (int32, error) {
+	var v int64
+	shift := uint(0)
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		v |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			if v < -1<<31 || v > 1<<31-1 {
+				return 0, fmt.Errorf("integer is too large at offset %d", r.pos)
+			}
+			return int32(v), nil
+		}
+		if shift >= 35 {
+			return 0, fmt.Errorf("integer is too long at offset %d", r.pos)
+		}
+	}
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(n)
+	return string(b), err
+}
+
+// count reads length of vector and checks that it doesn't exceed max.
+func (r *reader) count(max uint32, what string) (int, error) {
+	n, err := r.u32()
+	if err != nil {
+		return 0, err
+	}
+	if n > max {
+		return 0, fmt.Errorf("too many %s: %d", what, n)
+	}
+	return int(n), nil
+}
+
+func (r *reader) valueTypes() (int, error) {
+	n, err := r.count(maxParams, "parameters or results")
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		t, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if t != valueI32 {
+			return 0, fmt.Errorf("value type %#x is not supported, only i32 can be used", t)
+		}
+	}
+	return n, nil
+}
+
+// decode parses module and fills filter. Only subset of WebAssembly is
+// accepted: functions with i32 values, imports of host functions and
+// exports of functions. Memories, tables, globals and start function
+// are rejected.
+func (f *Filter) decode(code []byte) error {
+	r := &reader{data: code}
+	header, err := r.bytes(8)
+	if err != nil || string(header) != "\x00asm\x01\x00\x00\x00" {
+		return fmt.Errorf("not WebAssembly module of version 1")
+	}
+	var types []funcType
+	var funcTypes []uint32
+	lastID := byte(0)
+	for !r.eof() {
+		id, err := r.byte()
+		if err != nil {
+			return err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		data, err := r.bytes(size)
+		if err != nil {
+			return err
+		}
+		if id != sectionCustom {
+			if id <= lastID {
+				return fmt.Errorf("section %d is out of order", id)
+			}
+			lastID = id
+		}
+		s := &reader{data: data}
+		switch id {
+		case sectionCustom:
+			continue
+		case sectionType:
+			types, err = decodeTypes(s)
+		case sectionImport:
+			err = f.decodeImports(s, types)
+		case sectionFunction:
+			funcTypes, err = decodeFunctions(s, types)
+		case sectionExport:
+			err = f.decodeExports(s)
+		case sectionCode:
+			err = f.decodeCode(s, types, funcTypes)
+		default:
+			err = fmt.Errorf("section %d is not supported in filters", id)
+		}
+		if err != nil {
+			return err
+		}
+		if !s.eof() {
+			return fmt.Errorf("section %d has trailing bytes", id)
+		}
+	}
+	if len(f.functions) != len(funcTypes) {
+		return fmt.Errorf("number of function bodies doesn't match number of functions")
+	}
+	for name, idx := range f.exports {
+		if int(idx) >= len(f.imports)+len(f.functions) {
+			return fmt.Errorf("export %s refers to unknown function %d", name, idx)
+		}
+	}
+	return nil
+}
+
+func decodeTypes(r *reader) ([]funcType, error) {
+	n, err := r.count(maxFunctions, "types")
+	if err != nil {
+		return nil, err
+	}
+	types := make([]funcType, n)
+	for i := range types {
+		form, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if form != funcTypeForm {
+			return nil, fmt.Errorf("type %d is not function type", i)
+		}
+		if types[i].params, err = r.valueTypes(); err != nil {
+			return nil, err
+		}
+		if types[i].results, err = r.valueTypes(); err != nil {
+			return nil, err
+		}
+		if types[i].results > 1 {
+			return nil, fmt.Errorf("type %d has multiple results", i)
+		}
+	}
+	return types, nil
+}
+
+func (f *Filter) decodeImports(r *reader, types []funcType) error {
+	n, err := r.count(maxFunctions, "imports")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		module, err := r.name()
+		if err != nil {
+			return err
+		}
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if kind != externFunc {
+			return fmt.Errorf("import %s.%s is not function", module, name)
+		}
+		idx, err := r.u32()
+		if err != nil {
+			return err
+		}
+		h, ok := hostFunctions[name]
+		if module != HostModule || !ok {
+			return fmt.Errorf("unknown host function %s.%s", module, name)
+		}
+		if int(idx) >= len(types) || types[idx] != h.typ {
+			return fmt.Errorf("host function %s.%s is imported with wrong type", module, name)
+		}
+		f.imports = append(f.imports, h)
+	}
+	return nil
+}
+
+func decodeFunctions(r *reader, types []funcType) ([]uint32, error) {
+	n, err := r.count(maxFunctions, "functions")
+	if err != nil {
+		return nil, err
+	}
+	funcTypes := make([]uint32, n)
+	for i := range funcTypes {
+		if funcTypes[i], err = r.u32(); err != nil {
+			return nil, err
+		}
+		if int(funcTypes[i]) >= len(types) {
+			return nil, fmt.Errorf("function %d has unknown type", i)
+		}
+	}
+	return funcTypes, nil
+}
+
+func (f *Filter) decodeExports(r *reader) error {
+	n, err := r.count(maxFunctions, "exports")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		idx, err := r.u32()
+		if err != nil {
+			return err
+		}
+		// Other exports are allowed but can't be called
+		if kind == externFunc {
+			f.exports[name] = idx
+		}
+	}
+	return nil
+}
+
+func (f *Filter) decodeCode(r *reader, types []funcType, funcTypes []uint32) error {
+	n, err := r.count(maxFunctions, "function bodies")
+	if err != nil {
+		return err
+	}
+	if n != len(funcTypes) {
+		return fmt.Errorf("number of function bodies doesn't match number of functions")
+	}
+	f.functions = make([]function, n)
+	for i := range f.functions {
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		body, err := r.bytes(size)
+		if err != nil {
+			return err
+		}
+		fn := &f.functions[i]
+		fn.typ = types[funcTypes[i]]
+		b := &reader{data: body}
+		groups, err := b.u32()
+		if err != nil {
+			return err
+		}
+		locals := fn.typ.params
+		for g := uint32(0); g < groups; g++ {
+			count, err := b.u32()
+			if err != nil {
+				return err
+			}
+			t, err := b.byte()
+			if err != nil {
+				return err
+			}
+			if t != valueI32 {
+				return fmt.Errorf("function %d has local of type %#x, only i32 can be used", i, t)
+			}
+			if uint64(locals)+uint64(count) > maxLocals {
+				return fmt.Errorf("function %d has too many locals", i)
+			}
+			locals += int(count)
+		}
+		fn.locals = locals
+		fn.code = body[b.pos:]
+		if fn.blocks, err = f.scan(fn); err != nil {
+			return fmt.Errorf("function %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// scan checks instructions of function and finds matching else and
+// end instructions of blocks. Code should end with end instruction of
+// function body.
+func (f *Filter) scan(fn *function) (map[int]block, error) {
+	blocks := make(map[int]block)
+	// Positions of open block instructions
+	var open []int
+	r := &reader{data: fn.code}
+	for !r.eof() {
+		pos := r.pos
+		op, _ := r.byte()
+		var err error
+		switch {
+		case op == opBlock || op == opLoop || op == opIf:
+			var t byte
+			if t, err = r.byte(); err == nil && t != blockEmpty && t != valueI32 {
+				err = fmt.Errorf("block type %#x is not supported", t)
+			}
+			open = append(open, pos)
+			blocks[pos] = block{elsePos: -1}
+		case op == opElse:
+			if len(open) == 0 || fn.code[open[len(open)-1]] != opIf || blocks[open[len(open)-1]].elsePos != -1 {
+				return nil, fmt.Errorf("else without if at offset %d", pos)
+			}
+			b := blocks[open[len(open)-1]]
+			b.elsePos = pos
+			blocks[open[len(open)-1]] = b
+		case op == opEnd:
+			if len(open) == 0 {
+				if !r.eof() {
+					return nil, fmt.Errorf("code after end of function at offset %d", pos)
+				}
+				return blocks, nil
+			}
+			start := open[len(open)-1]
+			open = open[:len(open)-1]
+			b := blocks[start]
+			b.endPos = pos
+			blocks[start] = b
+			if b.elsePos != -1 {
+				blocks[b.elsePos] = b
+			}
+		case op == opBr || op == opBrIf:
+			var depth uint32
+			if depth, err = r.u32(); err == nil && int(depth) > len(open) {
+				err = fmt.Errorf("branch depth %d is too large", depth)
+			}
+		case op == opBrTable:
+			var n uint32
+			if n, err = r.u32(); err == nil && n > maxFunctions {
+				err = fmt.Errorf("branch table is too large")
+			}
+			for i := uint32(0); err == nil && i <= n; i++ {
+				var depth uint32
+				if depth, err = r.u32(); err == nil && int(depth) > len(open) {
+					err = fmt.Errorf("branch depth %d is too large", depth)
+				}
+			}
+		case op == opCall:
+			var idx uint32
+			if idx, err = r.u32(); err == nil && int(idx) >= len(f.imports)+len(f.functions) {
+				err = fmt.Errorf("call of unknown function %d", idx)
+			}
+		case op == opLocalGet || op == opLocalSet || op == opLocalTee:
+			var idx uint32
+			if idx, err = r.u32(); err == nil && int(idx) >= fn.locals {
+				err = fmt.Errorf("unknown local %d", idx)
+			}
+		case op == opI32Const:
+			_, err = r.s32()
+		case op == opUnreachable || op == opNop || op == opReturn || op == opDrop || op == opSelect:
+		case op >= opI32Eqz && op <= opI32GeU:
+		case op >= opI32Clz && op <= opI32Rotr:
+		default:
+			return nil, fmt.Errorf("instruction %#x at offset %d is not supported", op, pos)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("function doesn't end with end instruction")
+}
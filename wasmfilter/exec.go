@@ -0,0 +1,435 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasmfilter
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Supported instructions
+const (
+	opUnreachable = 0x00
+	opNop         = 0x01
+	opBlock       = 0x02
+	opLoop        = 0x03
+	opIf          = 0x04
+	opElse        = 0x05
+	opEnd         = 0x0b
+	opBr          = 0x0c
+	opBrIf        = 0x0d
+	opBrTable     = 0x0e
+	opReturn      = 0x0f
+	opCall        = 0x10
+	opDrop        = 0x1a
+	opSelect      = 0x1b
+	opLocalGet    = 0x20
+	opLocalSet    = 0x21
+	opLocalTee    = 0x22
+	opI32Const    = 0x41
+	opI32Eqz      = 0x45
+	opI32Eq       = 0x46
+	opI32Ne       = 0x47
+	opI32LtS      = 0x48
+	opI32LtU      = 0x49
+	opI32GtS      = 0x4a
+	opI32GtU      = 0x4b
+	opI32LeS      = 0x4c
+	opI32LeU      = 0x4d
+	opI32GeS      = 0x4e
+	opI32GeU      = 0x4f
+	opI32Clz      = 0x67
+	opI32Ctz      = 0x68
+	opI32Popcnt   = 0x69
+	opI32Add      = 0x6a
+	opI32Sub      = 0x6b
+	opI32Mul      = 0x6c
+	opI32DivS     = 0x6d
+	opI32DivU     = 0x6e
+	opI32RemS     = 0x6f
+	opI32RemU     = 0x70
+	opI32And      = 0x71
+	opI32Or       = 0x72
+	opI32Xor      = 0x73
+	opI32Shl      = 0x74
+	opI32ShrS     = 0x75
+	opI32ShrU     = 0x76
+	opI32Rotl     = 0x77
+	opI32Rotr     = 0x78
+)
+
+// Limits of execution
+const (
+	maxStack     = 1024
+	maxCallDepth = 64
+)
+
+// Trap is an error of filter program execution. Trapped program is
+// stopped, but filter can be used for other packets.
+type Trap string
+
+func (t Trap) Error() string {
+	return "wasm trap: " + string(t)
+}
+
+// HostModule is a name of module from which filter programs import
+// host functions. Host functions give read only access to packet
+// data which starts with Ethernet header. Multibyte values are read in
+// network byte order. Reading outside of packet traps.
+//
+//	length() i32       - length of packet
+//	load8(offset i32) i32
+//	load16(offset i32) i32
+//	load32(offset i32) i32
+const HostModule = "nffgo"
+
+type hostFunction struct {
+	typ funcType
+	fn  func(data []byte, args []uint32) (uint32, error)
+}
+
+func load(size int) func(data []byte, args []uint32) (uint32, error) {
+	return func(data []byte, args []uint32) (uint32, error) {
+		off := args[0]
+		if uint64(off)+uint64(size) > uint64(len(data)) {
+			return 0, Trap("packet is read out of bounds")
+		}
+		switch size {
+		case 1:
+			return uint32(data[off]), nil
+		case 2:
+			return uint32(binary.BigEndian.Uint16(data[off:])), nil
+		}
+		return binary.BigEndian.Uint32(data[off:]), nil
+	}
+}
+
+var hostFunctions = map[string]hostFunction{
+	"length": {funcType{0, 1}, func(data []byte, args []uint32) (uint32, error) {
+		return uint32(len(data)), nil
+	}},
+	"load8":  {funcType{1, 1}, load(1)},
+	"load16": {funcType{1, 1}, load(2)},
+	"load32": {funcType{1, 1}, load(4)},
+}
+
+// machine is a state of one execution of filter program.
+type machine struct {
+	filter *Filter
+	data   []byte
+	fuel   uint
+	stack  []uint32
+	depth  int
+}
+
+type label struct {
+	// Stack height at start of block
+	height int
+	// Number of values which are left on stack after branch
+	arity int
+	// Position where execution continues after branch
+	target int
+	loop   bool
+}
+
+func (m *machine) push(v uint32) error {
+	if len(m.stack) >= maxStack {
+		return Trap("stack overflow")
+	}
+	m.stack = append(m.stack, v)
+	return nil
+}
+
+func (m *machine) pop() (uint32, error) {
+	if len(m.stack) == 0 {
+		return 0, Trap("stack underflow")
+	}
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v, nil
+}
+
+func (m *machine) pop2() (uint32, uint32, error) {
+	b, err := m.pop()
+	if err != nil {
+		return 0, 0, err
+	}
+	a, err := m.pop()
+	return a, b, err
+}
+
+// unwind leaves arity top values of stack above height.
+func (m *machine) unwind(height, arity int) error {
+	if len(m.stack) < height+arity {
+		return Trap("stack underflow")
+	}
+	copy(m.stack[height:], m.stack[len(m.stack)-arity:])
+	m.stack = m.stack[:height+arity]
+	return nil
+}
+
+func blockArity(t byte) int {
+	if t == valueI32 {
+		return 1
+	}
+	return 0
+}
+
+// call calls function with arguments on stack and leaves its result on
+// stack.
+func (m *machine) call(idx uint32) error {
+	f := m.filter
+	if int(idx) < len(f.imports) {
+		h := f.imports[idx]
+		if len(m.stack) < h.typ.params {
+			return Trap("stack underflow")
+		}
+		args := m.stack[len(m.stack)-h.typ.params:]
+		v, err := h.fn(m.data, args)
+		if err != nil {
+			return err
+		}
+		m.stack = m.stack[:len(m.stack)-h.typ.params]
+		if h.typ.results != 0 {
+			return m.push(v)
+		}
+		return nil
+	}
+	fn := &f.functions[int(idx)-len(f.imports)]
+	if m.depth++; m.depth > maxCallDepth {
+		return Trap("call stack is exhausted")
+	}
+	defer func() { m.depth-- }()
+	if len(m.stack) < fn.typ.params {
+		return Trap("stack underflow")
+	}
+	locals := make([]uint32, fn.locals)
+	copy(locals, m.stack[len(m.stack)-fn.typ.params:])
+	m.stack = m.stack[:len(m.stack)-fn.typ.params]
+	base := len(m.stack)
+
+	code := fn.code
+	labels := []label{{height: base, arity: fn.typ.results, target: len(code)}}
+	r := &reader{data: code}
+	branch := func(depth uint32) error {
+		l := labels[len(labels)-1-int(depth)]
+		if l.loop {
+			labels = labels[:len(labels)-int(depth)]
+			m.stack = m.stack[:l.height]
+		} else {
+			labels = labels[:len(labels)-1-int(depth)]
+			if err := m.unwind(l.height, l.arity); err != nil {
+				return err
+			}
+		}
+		r.pos = l.target
+		return nil
+	}
+	for len(labels) != 0 {
+		if m.fuel == 0 {
+			return Trap("instruction limit is exceeded")
+		}
+		m.fuel--
+		pos := r.pos
+		op, err := r.byte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opUnreachable:
+			return Trap("unreachable is executed")
+		case opNop:
+		case opBlock, opLoop:
+			t, _ := r.byte()
+			l := label{height: len(m.stack), arity: blockArity(t), target: fn.blocks[pos].endPos + 1}
+			if op == opLoop {
+				l = label{height: len(m.stack), target: r.pos, loop: true}
+			}
+			labels = append(labels, l)
+		case opIf:
+			t, _ := r.byte()
+			cond, err := m.pop()
+			if err != nil {
+				return err
+			}
+			b := fn.blocks[pos]
+			l := label{height: len(m.stack), arity: blockArity(t), target: b.endPos + 1}
+			if cond != 0 {
+				labels = append(labels, l)
+			} else if b.elsePos != -1 {
+				labels = append(labels, l)
+				r.pos = b.elsePos + 1
+			} else {
+				r.pos = b.endPos + 1
+			}
+		case opElse:
+			// The end of then branch, skip else branch
+			r.pos = fn.blocks[pos].endPos
+		case opEnd:
+			l := labels[len(labels)-1]
+			labels = labels[:len(labels)-1]
+			if err = m.unwind(l.height, l.arity); err != nil {
+				return err
+			}
+		case opBr:
+			depth, _ := r.u32()
+			err = branch(depth)
+		case opBrIf:
+			depth, _ := r.u32()
+			var cond uint32
+			if cond, err = m.pop(); err == nil && cond != 0 {
+				err = branch(depth)
+			}
+		case opBrTable:
+			n, _ := r.u32()
+			var i uint32
+			if i, err = m.pop(); err != nil {
+				return err
+			}
+			if i > n {
+				i = n
+			}
+			var depth uint32
+			for j := uint32(0); j <= i; j++ {
+				depth, _ = r.u32()
+			}
+			err = branch(depth)
+		case opReturn:
+			err = branch(uint32(len(labels) - 1))
+		case opCall:
+			callee, _ := r.u32()
+			err = m.call(callee)
+		case opDrop:
+			_, err = m.pop()
+		case opSelect:
+			var cond, a, b uint32
+			if cond, err = m.pop(); err == nil {
+				if a, b, err = m.pop2(); err == nil {
+					if cond == 0 {
+						a = b
+					}
+					err = m.push(a)
+				}
+			}
+		case opLocalGet:
+			idx, _ := r.u32()
+			err = m.push(locals[idx])
+		case opLocalSet:
+			idx, _ := r.u32()
+			locals[idx], err = m.pop()
+		case opLocalTee:
+			idx, _ := r.u32()
+			if len(m.stack) == 0 {
+				return Trap("stack underflow")
+			}
+			locals[idx] = m.stack[len(m.stack)-1]
+		case opI32Const:
+			v, _ := r.s32()
+			err = m.push(uint32(v))
+		case opI32Eqz, opI32Clz, opI32Ctz, opI32Popcnt:
+			var a uint32
+			if a, err = m.pop(); err == nil {
+				err = m.push(unary(op, a))
+			}
+		default:
+			var a, b, v uint32
+			if a, b, err = m.pop2(); err == nil {
+				if v, err = binaryOp(op, a, b); err == nil {
+					err = m.push(v)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return m.unwind(base, fn.typ.results)
+}
+
+func boolToU32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func unary(op byte, a uint32) uint32 {
+	switch op {
+	case opI32Eqz:
+		return boolToU32(a == 0)
+	case opI32Clz:
+		return uint32(bits.LeadingZeros32(a))
+	case opI32Ctz:
+		return uint32(bits.TrailingZeros32(a))
+	}
+	return uint32(bits.OnesCount32(a))
+}
+
+func binaryOp(op byte, a, b uint32) (uint32, error) {
+	switch op {
+	case opI32Eq:
+		return boolToU32(a == b), nil
+	case opI32Ne:
+		return boolToU32(a != b), nil
+	case opI32LtS:
+		return boolToU32(int32(a) < int32(b)), nil
+	case opI32LtU:
+		return boolToU32(a < b), nil
+	case opI32GtS:
+		return boolToU32(int32(a) > int32(b)), nil
+	case opI32GtU:
+		return boolToU32(a > b), nil
+	case opI32LeS:
+		return boolToU32(int32(a) <= int32(b)), nil
+	case opI32LeU:
+		return boolToU32(a <= b), nil
+	case opI32GeS:
+		return boolToU32(int32(a) >= int32(b)), nil
+	case opI32GeU:
+		return boolToU32(a >= b), nil
+	case opI32Add:
+		return a + b, nil
+	case opI32Sub:
+		return a - b, nil
+	case opI32Mul:
+		return a * b, nil
+	case opI32DivS, opI32DivU, opI32RemS, opI32RemU:
+		if b == 0 {
+			return 0, Trap("integer divide by zero")
+		}
+		switch op {
+		case opI32DivS:
+			if int32(a) == -1<<31 && int32(b) == -1 {
+				return 0, Trap("integer overflow")
+			}
+			return uint32(int32(a) / int32(b)), nil
+		case opI32DivU:
+			return a / b, nil
+		case opI32RemS:
+			if int32(b) == -1 {
+				return 0, nil
+			}
+			return uint32(int32(a) % int32(b)), nil
+		}
+		return a % b, nil
+	case opI32And:
+		return a & b, nil
+	case opI32Or:
+		return a | b, nil
+	case opI32Xor:
+		return a ^ b, nil
+	case opI32Shl:
+		return a << (b & 31), nil
+	case opI32ShrS:
+		return uint32(int32(a) >> (b & 31)), nil
+	case opI32ShrU:
+		return a >> (b & 31), nil
+	case opI32Rotl:
+		return bits.RotateLeft32(a, int(b&31)), nil
+	case opI32Rotr:
+		return bits.RotateLeft32(a, -int(b&31)), nil
+	}
+	return 0, Trap("unknown instruction")
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package wasmfilter runs small WebAssembly programs which filter or
+// classify packets. Programs are sandboxed: they can only read packet
+// data by host functions of HostModule and return verdict, execution
+// is limited by number of instructions, and errors of programs stop
+// only program itself. So untrusted policy logic can be attached to
+// data plane without risk to crash it.
+//
+// Only subset of WebAssembly 1.0 is supported: functions with i32
+// parameters, locals and results, integer i32 instructions, control
+// instructions and calls. Modules with memories, tables, globals,
+// start function or other value types are rejected. Such programs are
+// usually written in WebAssembly text format and compiled by wat2wasm,
+// for example program which passes only IPv4 packets is
+//
+//	(module
+//	  (import "nffgo" "load16" (func $load16 (param i32) (result i32)))
+//	  (func (export "filter") (result i32)
+//	    (i32.eq (call $load16 (i32.const 12)) (i32.const 0x0800))))
+//
+// Exported functions without parameters which return i32 are hooks
+// which are called by Run. Meaning of their results is defined by
+// application.
+package wasmfilter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// DefaultFuel is a default limit of instructions executed by one Run.
+const DefaultFuel = 10000
+
+// Filter is a decoded and checked WebAssembly program. Filter can be
+// used by several goroutines simultaneously.
+type Filter struct {
+	// Maximum number of instructions which are executed by one Run,
+	// longer executions trap. It shouldn't be changed while filter is
+	// used. Default value is DefaultFuel.
+	Fuel uint
+
+	imports   []hostFunction
+	functions []function
+	exports   map[string]uint32
+	machines  sync.Pool
+}
+
+// Compile decodes WebAssembly module in binary format and checks that
+// it can be used as filter.
+func Compile(code []byte) (*Filter, error) {
+	f := &Filter{
+		Fuel:    DefaultFuel,
+		exports: make(map[string]uint32),
+	}
+	if err := f.decode(code); err != nil {
+		return nil, common.WrapWithNFError(err, "Invalid WebAssembly filter: "+err.Error(), common.BadArgument)
+	}
+	f.machines.New = func() interface{} {
+		return &machine{filter: f, stack: make([]uint32, 0, 64)}
+	}
+	return f, nil
+}
+
+// Load reads WebAssembly module from file and compiles it.
+func Load(file string) (*Filter, error) {
+	code, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, common.WrapWithNFError(err, "Can't read WebAssembly filter "+file, common.FileErr)
+	}
+	return Compile(code)
+}
+
+// hook returns index of exported hook function.
+func (f *Filter) hook(name string) (uint32, error) {
+	idx, ok := f.exports[name]
+	if !ok {
+		return 0, fmt.Errorf("filter doesn't export function %s", name)
+	}
+	var typ funcType
+	if int(idx) < len(f.imports) {
+		typ = f.imports[idx].typ
+	} else {
+		typ = f.functions[int(idx)-len(f.imports)].typ
+	}
+	if typ != (funcType{0, 1}) {
+		return 0, fmt.Errorf("function %s should have no parameters and return i32", name)
+	}
+	return idx, nil
+}
+
+// HasHook returns true if program exports function name which can be
+// called by Run.
+func (f *Filter) HasHook(name string) bool {
+	_, err := f.hook(name)
+	return err == nil
+}
+
+// Run calls exported function name with given packet data and returns
+// its result. Error is returned if function doesn't exist or traps.
+func (f *Filter) Run(name string, data []byte) (result int32, err error) {
+	idx, err := f.hook(name)
+	if err != nil {
+		return 0, err
+	}
+	m := f.machines.Get().(*machine)
+	m.data = data
+	m.fuel = f.Fuel
+	m.stack = m.stack[:0]
+	m.depth = 0
+	defer func() {
+		// Checks of programs should prevent all panics, this is
+		// the last resort which keeps data plane running.
+		if r := recover(); r != nil {
+			err = Trap(fmt.Sprint("internal error: ", r))
+		}
+		m.data = nil
+		f.machines.Put(m)
+	}()
+	if err = m.call(idx); err != nil {
+		return 0, err
+	}
+	return int32(m.stack[0]), nil
+}
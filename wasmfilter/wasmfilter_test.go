@@ -0,0 +1,359 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasmfilter
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Helpers which assemble modules in binary format
+
+func leb(v uint32) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func sleb(v int32) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func cat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func vec(items ...[]byte) []byte {
+	return cat(leb(uint32(len(items))), cat(items...))
+}
+
+func str(s string) []byte {
+	return cat(leb(uint32(len(s))), []byte(s))
+}
+
+func section(id byte, items ...[]byte) []byte {
+	content := vec(items...)
+	return cat([]byte{id}, leb(uint32(len(content))), content)
+}
+
+func module(sections ...[]byte) []byte {
+	return cat([]byte("\x00asm\x01\x00\x00\x00"), cat(sections...))
+}
+
+func sig(params, results int) []byte {
+	p := make([][]byte, params)
+	for i := range p {
+		p[i] = []byte{valueI32}
+	}
+	r := make([][]byte, results)
+	for i := range r {
+		r[i] = []byte{valueI32}
+	}
+	return cat([]byte{funcTypeForm}, vec(p...), vec(r...))
+}
+
+func imp(name string, typ uint32) []byte {
+	return cat(str(HostModule), str(name), []byte{externFunc}, leb(typ))
+}
+
+func exp(name string, idx uint32) []byte {
+	return cat(str(name), []byte{externFunc}, leb(idx))
+}
+
+func body(locals uint32, code ...[]byte) []byte {
+	var l []byte
+	if locals != 0 {
+		l = vec(cat(leb(locals), []byte{valueI32}))
+	} else {
+		l = vec()
+	}
+	b := cat(l, cat(code...), []byte{opEnd})
+	return cat(leb(uint32(len(b))), b)
+}
+
+func op(code byte, imm ...uint32) []byte {
+	b := []byte{code}
+	for _, i := range imm {
+		b = append(b, leb(i)...)
+	}
+	return b
+}
+
+func i32(v int32) []byte {
+	return cat([]byte{opI32Const}, sleb(v))
+}
+
+// Types used by tests: 0 is () -> i32, 1 is (i32) -> i32
+var testTypes = section(sectionType, sig(0, 1), sig(1, 1))
+
+// program builds module which imports load8, load16 and length as
+// functions 0, 1 and 2 and exports the first defined function as
+// "filter".
+func program(funcs ...[]byte) []byte {
+	types := make([][]byte, len(funcs))
+	for i := range types {
+		types[i] = leb(0)
+	}
+	return module(testTypes,
+		section(sectionImport, imp("load8", 1), imp("load16", 1), imp("length", 0)),
+		section(sectionFunction, types...),
+		section(sectionExport, exp("filter", 3)),
+		section(sectionCode, funcs...))
+}
+
+func frame(etherType uint16, payload ...byte) []byte {
+	f := make([]byte, 14)
+	f[12] = byte(etherType >> 8)
+	f[13] = byte(etherType)
+	return append(f, payload...)
+}
+
+func run(t *testing.T, code []byte, data []byte) (int32, error) {
+	t.Helper()
+	f, err := Compile(code)
+	if err != nil {
+		t.Fatalf("Compile() failed: %v", err)
+	}
+	return f.Run("filter", data)
+}
+
+func TestIPv4Filter(t *testing.T) {
+	code := program(body(0,
+		i32(12), op(opCall, 1), i32(0x0800), op(opI32Eq)))
+	for _, tt := range []struct {
+		etherType uint16
+		want      int32
+	}{{0x0800, 1}, {0x86dd, 0}, {0x0806, 0}} {
+		got, err := run(t, code, frame(tt.etherType))
+		if err != nil || got != tt.want {
+			t.Errorf("EtherType %#x: Run() = %d, %v, want %d", tt.etherType, got, err, tt.want)
+		}
+	}
+}
+
+func TestControl(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		data []byte
+		want int32
+	}{
+		{
+			// Counts bytes equal to 0xff, local 0 is index, local 1 is counter
+			"loop", program(body(2,
+				op(opBlock, blockEmpty),
+				op(opLoop, blockEmpty),
+				op(opLocalGet, 0), op(opCall, 2), op(opI32GeU), op(opBrIf, 1),
+				op(opLocalGet, 0), op(opCall, 0), i32(0xff), op(opI32Eq),
+				op(opLocalGet, 1), op(opI32Add), op(opLocalSet, 1),
+				op(opLocalGet, 0), i32(1), op(opI32Add), op(opLocalSet, 0),
+				op(opBr, 0),
+				op(opEnd),
+				op(opEnd),
+				op(opLocalGet, 1))),
+			frame(0xffff, 0xff, 1, 0xff), 4,
+		},
+		{
+			"if else", program(body(0,
+				op(opCall, 2), i32(20), op(opI32GtU),
+				op(opIf, valueI32), i32(10), op(opElse), i32(20), op(opEnd))),
+			frame(0), 20,
+		},
+		{
+			"if without else", program(body(1,
+				i32(7), op(opLocalSet, 0),
+				i32(0), op(opIf, blockEmpty), i32(1), op(opLocalSet, 0), op(opEnd),
+				op(opLocalGet, 0))),
+			nil, 7,
+		},
+		{
+			"br_table", program(body(0,
+				op(opBlock, blockEmpty), op(opBlock, blockEmpty), op(opBlock, blockEmpty),
+				i32(0), op(opCall, 0), op(opBrTable, 2, 0, 1, 2),
+				op(opEnd), i32(100), op(opReturn),
+				op(opEnd), i32(200), op(opReturn),
+				op(opEnd), i32(300))),
+			[]byte{1}, 200,
+		},
+		{
+			"br_table default", program(body(0,
+				op(opBlock, blockEmpty), op(opBlock, blockEmpty),
+				i32(0), op(opCall, 0), op(opBrTable, 1, 0, 1),
+				op(opEnd), i32(100), op(opReturn),
+				op(opEnd), i32(300))),
+			[]byte{9}, 300,
+		},
+		{
+			"block result", program(body(0,
+				op(opBlock, valueI32), i32(5), i32(6), op(opBr, 0), op(opEnd), i32(1), op(opI32Add))),
+			nil, 7,
+		},
+		{
+			"select", program(body(0,
+				i32(11), i32(22), i32(0), op(opSelect))),
+			nil, 22,
+		},
+		{
+			"call with parameter", module(testTypes,
+				section(sectionFunction, leb(0), leb(1)),
+				section(sectionExport, exp("filter", 0)),
+				section(sectionCode,
+					body(0, i32(20), op(opCall, 1), op(opReturn)),
+					body(0, op(opLocalGet, 0), i32(-3), op(opI32Mul)))),
+			nil, -60,
+		},
+		{
+			"arithmetic", program(body(0,
+				i32(-7), i32(2), op(opI32DivS),
+				i32(-7), i32(2), op(opI32RemS), op(opI32Add),
+				i32(1), i32(4), op(opI32Rotl), op(opI32Add),
+				i32(0x100), op(opI32Ctz), op(opI32Add))),
+			nil, -3 + -1 + 16 + 8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := run(t, tt.code, tt.data)
+			if err != nil || got != tt.want {
+				t.Errorf("Run() = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraps(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+	}{
+		{"infinite loop", program(body(0,
+			op(opLoop, blockEmpty), op(opBr, 0), op(opEnd), i32(1)))},
+		{"out of bounds", program(body(0,
+			i32(100), op(opCall, 1)))},
+		{"divide by zero", program(body(0,
+			i32(1), i32(0), op(opI32DivU)))},
+		{"unreachable", program(body(0,
+			op(opUnreachable)))},
+		{"recursion", program(body(0,
+			op(opCall, 3)))},
+		{"stack underflow", program(body(0,
+			op(opI32Add)))},
+		{"no result", program(body(0))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Compile(tt.code)
+			if err != nil {
+				t.Fatalf("Compile() failed: %v", err)
+			}
+			if _, err := f.Run("filter", frame(0x0800)); err == nil {
+				t.Fatal("Run() didn't trap")
+			} else if _, ok := err.(Trap); !ok {
+				t.Fatalf("Run() returned %v which isn't trap", err)
+			}
+		})
+	}
+}
+
+func TestFilterReusedAfterTrap(t *testing.T) {
+	f, err := Compile(program(body(0, i32(14), op(opCall, 0))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Run("filter", frame(0)); err == nil {
+		t.Error("read after end of packet didn't trap")
+	}
+	if v, err := f.Run("filter", frame(0, 42)); err != nil || v != 42 {
+		t.Errorf("Run() = %d, %v after trap, want 42", v, err)
+	}
+	if _, err := f.Run("other", nil); err == nil {
+		t.Error("Run() of unknown function succeeded")
+	}
+	if !f.HasHook("filter") || f.HasHook("other") {
+		t.Error("HasHook() is wrong")
+	}
+}
+
+func TestInvalidModules(t *testing.T) {
+	valid := program(body(0, i32(1)))
+	tests := []struct {
+		name string
+		code []byte
+	}{
+		{"empty", nil},
+		{"bad magic", append([]byte("\x00wsm"), valid[4:]...)},
+		{"truncated", valid[:len(valid)-2]},
+		{"memory", module(testTypes, section(5, []byte{0, 1}))},
+		{"i64 type", module(section(sectionType, []byte{funcTypeForm, 0, 1, 0x7e}))},
+		{"unknown import", module(testTypes,
+			section(sectionImport, imp("store8", 1)))},
+		{"import with wrong type", module(testTypes,
+			section(sectionImport, imp("length", 1)))},
+		{"import from other module", module(testTypes,
+			section(sectionImport, cat(str("env"), str("length"), []byte{externFunc}, leb(0))))},
+		{"missing body", module(testTypes, section(sectionFunction, leb(0)))},
+		{"unsupported instruction", program(body(0, []byte{0x28, 0, 0}))},
+		{"unknown local", program(body(0, op(opLocalGet, 0)))},
+		{"unknown function", program(body(0, op(opCall, 9)))},
+		{"deep branch", program(body(0, op(opBr, 1)))},
+		{"unclosed block", program(body(0, op(opBlock, blockEmpty), i32(1)))},
+		{"else without if", program(body(0, op(opElse), i32(1)))},
+		{"sections out of order", module(section(sectionFunction), testTypes)},
+	}
+	for _, tt := range tests {
+		if _, err := Compile(tt.code); err == nil {
+			t.Errorf("%s: Compile() succeeded", tt.name)
+		}
+	}
+}
+
+// TestMutations checks that damaged programs are either rejected or
+// run without panics.
+func TestMutations(t *testing.T) {
+	valid := program(body(2,
+		op(opBlock, blockEmpty),
+		op(opLoop, blockEmpty),
+		op(opLocalGet, 0), op(opCall, 2), op(opI32GeU), op(opBrIf, 1),
+		op(opLocalGet, 0), op(opCall, 0), op(opLocalGet, 1), op(opI32Add), op(opLocalSet, 1),
+		op(opLocalGet, 0), i32(1), op(opI32Add), op(opLocalSet, 0),
+		op(opBr, 0),
+		op(opEnd),
+		op(opEnd),
+		op(opLocalGet, 1)))
+	rnd := rand.New(rand.NewSource(1))
+	data := frame(0x0800, 1, 2, 3, 4)
+	for i := 0; i < 20000; i++ {
+		code := append([]byte(nil), valid...)
+		for j := rnd.Intn(3); j >= 0; j-- {
+			code[8+rnd.Intn(len(code)-8)] = byte(rnd.Intn(256))
+		}
+		f, err := Compile(code)
+		if err != nil {
+			continue
+		}
+		f.Run("filter", data)
+	}
+}